@@ -0,0 +1,683 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"simple-web-scraper/pkg/config"
+	"simple-web-scraper/pkg/crawler"
+)
+
+// configFlag is a setting that can be given on the command line as well as
+// in the environment. A flag that is set wins over an exported variable,
+// which wins over the .env file, then the config file, then the built-in
+// default.
+type configFlag struct {
+	name, env, usage string
+}
+
+var configFlags = []configFlag{
+	{"base-url", "BASE_URL", "URL to start from; only URLs under it are crawled"},
+	{"project", "PROJECT_FOLDERNAME", "folder for the state files and downloads (default: the BASE_URL host)"},
+	{"workers", "NUM_WORKERS", "number of concurrent workers (default 10)"},
+	{"adaptive", "ADAPTIVE_CONCURRENCY", "adapt the number of workers to the site's errors and latency"},
+	{"max-depth", "MAX_DEPTH", "links to follow away from the seeds, -1 for no limit (default -1)"},
+	{"order", "CRAWL_ORDER", "bfs to crawl the shallowest pages first, dfs for the deepest (default bfs)"},
+	{"max-pages", "MAX_PAGES", "stop after fetching this many pages, 0 for no limit"},
+	{"max-duration", "MAX_DURATION", "stop dispatching after this long, e.g. 30m"},
+	{"max-age", "MAX_AGE", "refetch pages older than this, e.g. 7d, skipping the rest; new URLs are always fetched"},
+	{"deadline", "CRAWL_DEADLINE", "abort the crawl, in-flight requests included, after this long"},
+	{"schedule", "CRAWL_SCHEDULE", "time of day to crawl in, e.g. 22:00-06:00, pausing outside it"},
+	{"output", "OUTPUT", "print a JSON line per page on stdout, jsonl or ndjson-full with links and metadata, logging to stderr"},
+	{"control", "CONTROL_ADDR", "serve the control API on this address, e.g. :8081, for CONTROL_TOKEN holders"},
+	{"delay", "DELAY_BETWEEN_REQUESTS_MS", "milliseconds each worker waits between requests"},
+	{"rate", "MAX_REQUESTS_PER_SECOND", "requests per second to each host across all workers, 0 for no limit"},
+	{"proxy", "PROXY_URL", "proxy to crawl through, http://, https:// or socks5://, credentials in the URL"},
+	{"proxy-file", "PROXY_FILE", "file of proxies, one per line, to spread the requests over"},
+	{"cookies-file", "COOKIES_FILE", "cookies.txt in the Netscape format to start the cookie jar from"},
+	{"user-agent", "USER_AGENTS", "User-Agent to send, or several separated by | to rotate (default simple-web-scraper/1.0)"},
+	{"contact", "CONTACT_URL", "URL or address added to the default User-Agent so site owners can reach you"},
+	{"resolve", "RESOLVE", "connect to host:port at another address instead, as host:port:address, comma-separated"},
+	{"timeout", "REQUEST_TIMEOUT_SECONDS", "per-request timeout in seconds (default 30)"},
+	{"attempts", "MAX_ATTEMPTS", "attempts per URL before it is recorded as failed (default 3)"},
+	{"include", "INCLUDE_PATTERNS", "comma-separated regular expressions URLs must match"},
+	{"exclude", "EXCLUDE_PATTERNS", "comma-separated regular expressions URLs must not match"},
+	{"languages", "ALLOWED_LANGUAGES", "comma-separated languages, like en,de, of the only pages to save"},
+	{"robots", "RESPECT_ROBOTS", "obey robots.txt (default true)"},
+	{"script-links", "SCRIPT_LINKS", "also crawl in-scope URLs found in inline scripts and JSON blocks like __NEXT_DATA__"},
+	{"script-redirects", "SCRIPT_REDIRECTS", "follow inline scripts that set window.location to a URL, as meta refreshes are"},
+	{"screenshots", "SCREENSHOTS", "save a PNG screenshot of every page rendered in headless Chrome"},
+	{"seed", "SEED_URLS", "comma-separated URLs to start from besides BASE_URL, - to read them from stdin"},
+	{"seed-file", "SEED_FILE", "file of URLs to start from, one per line with # comments, - for stdin"},
+	{"allow-seed-domains", "ALLOW_SEED_DOMAINS", "crawl the hosts of seeds outside BASE_URL instead of rejecting them"},
+	{"sitemap", "SEED_FROM_SITEMAP", "seed the crawl from /sitemap.xml"},
+	{"save-non-html", "SAVE_NON_HTML", "also save responses that aren't HTML"},
+	{"download-assets", "DOWNLOAD_ASSETS", "download same-host assets for an offline mirror"},
+	{"images", "CRAWL_IMAGES", "download the images pages show into images/, recording their size, dimensions and alt text in images.jsonl"},
+}
+
+// command is one subcommand; flags registers its own flags and returns
+// what runs it once they are parsed and the settings are loaded. args
+// names its positional arguments, read from fs.Args(), if it takes any.
+type command struct {
+	name, args, summary string
+	flags               func(fs *flag.FlagSet) func(cfg crawler.Config)
+}
+
+var commands = []command{
+	{"crawl", "", "crawl BASE_URL, resuming where the last run stopped (the default)", crawlCommand(false)},
+	{"retry-failed", "", "crawl again after forgetting which URLs failed", crawlCommand(true)},
+	{"status", "", "print how many URLs are found, scraped, failed and pending", func(fs *flag.FlagSet) func(crawler.Config) {
+		return runStatus
+	}},
+	{"report", "[seo]", "write the summary, broken links and duplicates reports, and optionally the link graph; with seo, the SEO audit", reportCommand},
+	{"rewrite-links", "", "write .offline.html copies of saved pages that link to the local files", func(fs *flag.FlagSet) func(crawler.Config) {
+		return runRewriteLinks
+	}},
+	{"markdown", "", "convert saved pages to Markdown under markdown/, linking to each other", func(fs *flag.FlagSet) func(crawler.Config) {
+		return runMarkdown
+	}},
+	{"serve", "", "serve the downloaded mirror over HTTP", serveCommand},
+	{"migrate", "", "import an older frontier: the journal or the found, scraped and failed files", func(fs *flag.FlagSet) func(crawler.Config) {
+		return runMigrate
+	}},
+	{"index", "", "update the full-text search index from the saved pages", func(fs *flag.FlagSet) func(crawler.Config) {
+		return runIndex
+	}},
+	{"search", "<query>", "search the indexed pages and print the best matches", searchCommand},
+	{"diff", "[from] [to]", "compare two snapshot runs, by default the last two", diffCommand},
+	{"verify", "", "re-hash the saved files, reporting missing, corrupted and orphaned ones", verifyCommand},
+	{"compress", "", "compress the saved pages of an uncompressed project in place, with STORAGE_COMPRESSION or else gzip, printing the space saved", func(fs *flag.FlagSet) func(crawler.Config) {
+		return runCompress
+	}},
+	{"export", "<archive>", "pack the project folder into a .zip or .tar.gz with checksums", archiveCommand(runExport)},
+	{"import", "<archive>", "unpack an exported archive into the project folder, verifying it", archiveCommand(runImport)},
+}
+
+func crawlCommand(retryFailed bool) func(fs *flag.FlagSet) func(crawler.Config) {
+	return func(fs *flag.FlagSet) func(crawler.Config) {
+		retry := fs.Bool("retry-failed", retryFailed, "re-enqueue the URLs in the failed file before crawling")
+		recrawl := fs.Bool("recrawl", false, "re-fetch scraped URLs, skipping pages the server reports unchanged")
+		checkExternal := fs.Bool("check-external", false, "HEAD-check out-of-scope links and include dead ones in the broken links report")
+		forceLock := fs.Bool("force-lock", false, "take over the project folder's lock even if the crawl holding it may still be running")
+		quiet := fs.Bool("quiet", false, "log pages at debug level, leaving the progress lines and the summaries")
+		markdown := fs.Bool("markdown", false, "convert saved pages to Markdown at the end of the crawl")
+		noBreaker := fs.Bool("no-circuit-breaker", false, "keep crawling however many fetches in a row get no answer")
+		skipPreflight := fs.Bool("skip-preflight", false, "start without checking BASE_URL, robots.txt and the project folder first")
+		dryRun := fs.Bool("dry-run", false, "discover what would be crawled, saving only plan.jsonl with the rule that kept or dropped each URL")
+		planOnly := fs.Bool("plan-only", false, "like -dry-run, but re-decide the URLs already found, without any network traffic")
+		imagesReportOnly := fs.Bool("images-report-only", false, "like -images true, but only HEAD the images for images.jsonl, saving none")
+		return func(cfg crawler.Config) {
+			if *dryRun || *planOnly {
+				runPlan(cfg, *planOnly)
+				return
+			}
+			cfg.Quiet = cfg.Quiet || *quiet
+			cfg.Markdown = cfg.Markdown || *markdown
+			cfg.CircuitBreaker = cfg.CircuitBreaker && !*noBreaker
+			cfg.SkipPreflight = cfg.SkipPreflight || *skipPreflight
+			if *imagesReportOnly {
+				cfg.CrawlImages, cfg.ImagesReportOnly = true, true
+			}
+			cfg.RetryFailed, cfg.Recrawl, cfg.CheckExternal, cfg.ForceLock = *retry, *recrawl, *checkExternal, *forceLock
+			runCrawl(cfg)
+		}
+	}
+}
+
+func reportCommand(fs *flag.FlagSet) func(crawler.Config) {
+	graphFormat := fs.String("graph", "", "also export the link graph as `dot` or graphml")
+	graphCollapse := fs.Int("graph-collapse", 0, "with -graph, group URLs by their first `n` path segments")
+	checkExternal := fs.Bool("check-external", false, "HEAD-check out-of-scope links and include dead ones in the broken links report")
+	return func(cfg crawler.Config) {
+		switch {
+		case fs.NArg() == 1 && fs.Arg(0) == "seo":
+			runSEOReport(cfg)
+			return
+		case fs.NArg() > 0:
+			fmt.Fprintf(os.Stderr, "unknown report %q\n", fs.Arg(0))
+			fs.Usage()
+			os.Exit(exitConfig)
+		}
+		cfg.CheckExternal = *checkExternal
+		runReport(cfg, *graphFormat, *graphCollapse)
+	}
+}
+
+func searchCommand(fs *flag.FlagSet) func(crawler.Config) {
+	limit := fs.Int("limit", 10, "print at most `n` results")
+	return func(cfg crawler.Config) {
+		query := strings.Join(fs.Args(), " ")
+		if strings.TrimSpace(query) == "" {
+			fmt.Fprintln(os.Stderr, "search needs a query")
+			fs.Usage()
+			os.Exit(exitConfig)
+		}
+		runSearch(cfg, query, *limit)
+	}
+}
+
+// archiveCommand is export or import, which take the archive's path
+func archiveCommand(run func(cfg crawler.Config, archive string)) func(fs *flag.FlagSet) func(crawler.Config) {
+	return func(fs *flag.FlagSet) func(crawler.Config) {
+		return func(cfg crawler.Config) {
+			if fs.NArg() != 1 {
+				fmt.Fprintf(os.Stderr, "%s needs the archive's path\n", fs.Name())
+				fs.Usage()
+				os.Exit(exitConfig)
+			}
+			run(cfg, fs.Arg(0))
+		}
+	}
+}
+
+func verifyCommand(fs *flag.FlagSet) func(crawler.Config) {
+	repair := fs.Bool("repair", false, "queue the pages and assets in missing or corrupted files to be fetched again by the next crawl")
+	return func(cfg crawler.Config) {
+		runVerify(cfg, *repair)
+	}
+}
+
+func diffCommand(fs *flag.FlagSet) func(crawler.Config) {
+	text := fs.Bool("text", false, "include a unified diff of the text of changed pages")
+	asJSON := fs.Bool("json", false, "print the diff as JSON")
+	return func(cfg crawler.Config) {
+		if fs.NArg() > 2 {
+			fs.Usage()
+			os.Exit(exitConfig)
+		}
+		runDiff(cfg, fs.Args(), *text, *asJSON)
+	}
+}
+
+func serveCommand(fs *flag.FlagSet) func(crawler.Config) {
+	port := fs.Int("port", 0, "port to serve the mirror on (env SERVE_PORT, default 8080)")
+	return func(cfg crawler.Config) {
+		if *port == 0 {
+			*port = servePort
+		}
+		runServe(cfg, *port)
+	}
+}
+
+func main() {
+	args := os.Args[1:]
+	name := "crawl"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout, nil)
+		return
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr, nil)
+		os.Exit(exitConfig)
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	settings := settingFlags(fs)
+	run := cmd.flags(fs)
+	fs.Usage = func() { printUsage(os.Stderr, fs) }
+	if cmd.args != "" {
+		// Arguments may come before the flags too: search "refund policy" -limit 5
+		var positional []string
+		for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			positional, args = append(positional, args[0]), args[1:]
+		}
+		args = append(args, positional...)
+	}
+	// A bad flag is a configuration error, not a crawl that had failures
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitConfig)
+	}
+	if fs.NArg() > 0 && cmd.args == "" {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q; the command goes before the flags\n\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(exitConfig)
+	}
+
+	s, err := loadSettings(fs, settings)
+	var invalid *config.Error
+	if errors.As(err, &invalid) {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		for _, p := range invalid.Problems {
+			fmt.Fprintln(os.Stderr, "  "+p)
+		}
+		os.Exit(exitConfig)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	useSettings(s)
+	run(s.Crawler)
+}
+
+// settingFlags registers -env-file, -config and the configFlags on fs,
+// returning where the configFlags' values go by setting name
+func settingFlags(fs *flag.FlagSet) map[string]*string {
+	fs.String("env-file", ".env", "file to read settings from; missing is fine unless given explicitly")
+	fs.String("config", "crawler.yaml", "YAML config file read after the .env file; missing is fine unless given explicitly")
+	settings := make(map[string]*string, len(configFlags))
+	for _, cf := range configFlags {
+		settings[cf.env] = fs.String(cf.name, "", cf.usage+" (env "+cf.env+")")
+	}
+	return settings
+}
+
+// loadSettings loads the settings once fs, with the settingFlags, has
+// parsed the command line. Flags go into the environment before .env is
+// read, and godotenv never overrides a variable that is already set.
+func loadSettings(fs *flag.FlagSet, settings map[string]*string) (config.Settings, error) {
+	envFile, configFile := fs.Lookup("env-file").Value.String(), fs.Lookup("config").Value.String()
+	explicitEnvFile, explicitConfigFile := false, false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env-file":
+			explicitEnvFile = true
+		case "config":
+			explicitConfigFile = true
+		}
+		for _, cf := range configFlags {
+			if cf.name == f.Name {
+				os.Setenv(cf.env, *settings[cf.env])
+			}
+		}
+	})
+	if err := godotenv.Load(envFile); err != nil {
+		if explicitEnvFile || !os.IsNotExist(err) {
+			return config.Settings{}, fmt.Errorf("Error loading %s: %v", envFile, err)
+		}
+		fmt.Fprintf(os.Stderr, "No %s file, using flags and environment variables only\n", envFile)
+	}
+	aliases := make(map[string]string, len(configFlags))
+	for _, cf := range configFlags {
+		aliases[cf.name] = cf.env
+	}
+	return config.Load(config.Options{File: configFile, Explicit: explicitConfigFile, Aliases: aliases})
+}
+
+// servePort is the default port for the serve command
+var servePort int
+
+// output is how a crawl reports its pages on stdout: "" for not at all,
+// "jsonl" or "ndjson-full" for a JSON line per page; console is where the
+// logs and the messages for people go, stderr when stdout has the pages
+var (
+	output  string
+	console io.Writer = os.Stdout
+)
+
+// controlAddr is where the crawl serves its control API, if anywhere, and
+// controlToken the token its requests must carry
+var controlAddr, controlToken string
+
+// logger writes the crawl's log records to the console, as set by
+// LOG_LEVEL and LOG_FORMAT; jsonLogs is whether they are JSON
+var (
+	logger   *slog.Logger
+	jsonLogs bool
+)
+
+// useSettings sets up the command from the loaded settings
+func useSettings(s config.Settings) {
+	servePort, output, controlAddr, controlToken = s.ServePort, s.Output, s.ControlAddr, s.ControlToken
+	if output != "" {
+		console = os.Stderr
+	}
+	jsonLogs = s.JSONLogs
+	opts := &slog.HandlerOptions{Level: s.LogLevel}
+	if !jsonLogs {
+		logger = slog.New(slog.NewTextHandler(console, opts))
+		return
+	}
+	// Durations as "1.5s" rather than nanoseconds
+	opts.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() == slog.KindDuration {
+			a.Value = slog.StringValue(a.Value.Duration().String())
+		}
+		return a
+	}
+	logger = slog.New(slog.NewJSONHandler(console, opts))
+}
+
+// printUsage lists the commands and, for a command's flag set, its flags
+func printUsage(w *os.File, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-18s %s\n", strings.TrimSpace(c.name+" "+c.args), c.summary)
+	}
+	fmt.Fprintln(w, "\nSettings are read from flags, then the environment, the .env file and the config file.")
+	if fs == nil {
+		fmt.Fprintf(w, "Run '%s <command> -h' for the flags of a command.\n", os.Args[0])
+		fmt.Fprint(w, exitStatusHelp)
+		return
+	}
+	fmt.Fprintf(w, "\nFlags for %s:\n", fs.Name())
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	if fs.Name() == "crawl" || fs.Name() == "retry-failed" {
+		fmt.Fprint(w, exitStatusHelp)
+	}
+}
+
+// newCrawler builds the crawler for cfg, exiting if it is unusable
+func newCrawler(cfg crawler.Config) *crawler.Crawler {
+	c, err := crawler.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.Logger = logger
+	return c
+}
+
+// runCrawl crawls until done, stopping gracefully on the first interrupt
+// and at once on the second
+func runCrawl(cfg crawler.Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Fprintln(console, "Interrupted (interrupt again to force)")
+		cancel()
+		<-sigs
+		fmt.Fprintln(console, "Forced exit")
+		os.Exit(exitAborted)
+	}()
+	c := newCrawler(cfg)
+	var stream *pageStream
+	if output != "" {
+		stream = streamPages(c, output == "ndjson-full")
+	}
+	notifyPause(c)
+	if controlAddr != "" {
+		stop := serveControl(c, func() {
+			fmt.Fprintln(console, "Stopping (stop requested over the control API)")
+			cancel()
+		})
+		defer stop()
+	}
+	err := c.Run(ctx)
+	if stream != nil {
+		stream.close()
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, crawler.ErrLocked):
+			log.Print(err, "; pass -force-lock if that crawl is not running")
+		case errors.Is(err, crawler.ErrPreflight):
+			log.Print(err, "; pass -skip-preflight to start anyway")
+		case errors.Is(err, crawler.ErrOriginDown):
+			printReport(c)
+			log.Print(err, "; run again to resume once the site is back")
+		default:
+			log.Print(err)
+		}
+	} else {
+		printReport(c)
+	}
+	finishCrawl(c, err)
+}
+
+// runPlan makes a plan with a dry run, or offline from the frontier, and
+// prints its totals
+func runPlan(cfg crawler.Config, offline bool) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	c := newCrawler(cfg)
+	var plan *crawler.Plan
+	var err error
+	if offline {
+		plan, err = c.Plan()
+	} else {
+		plan, err = c.DryRun(ctx)
+	}
+	if plan != nil {
+		plan.WriteText(console)
+		fmt.Fprintf(console, "Decisions in %s\n", filepath.Join(c.ProjectFolder(), "plan.jsonl"))
+	}
+	if errors.Is(err, context.Canceled) {
+		os.Exit(exitAborted)
+	}
+	if err != nil {
+		log.Fatal("Error planning the crawl: ", err)
+	}
+}
+
+// serveControl serves the control API on controlAddr while the crawl
+// runs, exiting if it can't listen there; stop shuts it down
+func serveControl(c *crawler.Crawler, stopCrawl func()) (stop func()) {
+	ln, err := net.Listen("tcp", controlAddr)
+	if err != nil {
+		log.Fatal("Error serving the control API: ", err)
+	}
+	srv := &http.Server{Handler: c.ControlHandler(controlToken, stopCrawl), ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	logger.Info("serving control API", "addr", "http://"+ln.Addr().String()+"/")
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
+
+// printReport prints the summary report for people, unless the logs are
+// JSON and so carry it already
+func printReport(c *crawler.Crawler) {
+	if r := c.Report(); r != nil && !jsonLogs {
+		r.WriteText(console)
+	}
+}
+
+// runStatus prints the crawl state from the state files without crawling
+func runStatus(cfg crawler.Config) {
+	s, err := newCrawler(cfg).Status()
+	if err != nil {
+		log.Fatal("Error opening state files: ", err)
+	}
+	fmt.Printf("STATUS: \n\tTOTAL=%d \n\tSCRAPED=%d \n\tFAILED=%d \n\tUNSCRAPED=%d\n", s.Found, s.Scraped, s.Failed, s.Pending)
+	if len(s.Proxies) > 0 {
+		fmt.Println("Proxies in the last run:")
+		for _, p := range s.Proxies {
+			fmt.Printf("\t%s OK=%d ERRORS=%d\n", p.Proxy, p.OK, p.Errors)
+		}
+	}
+}
+
+// runReport writes the end-of-crawl reports from the files of an earlier
+// crawl, plus the link graph if asked for
+func runReport(cfg crawler.Config, graphFormat string, graphCollapse int) {
+	c := newCrawler(cfg)
+	if err := c.WriteReports(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	printReport(c)
+	if graphFormat != "" {
+		out := filepath.Join(c.ProjectFolder(), "graph."+graphFormat)
+		if err := c.ExportGraph(graphFormat, out, graphCollapse); err != nil {
+			log.Fatal("Error exporting graph: ", err)
+		}
+	}
+}
+
+// runSEOReport writes the SEO audit under seo/ from what earlier crawls
+// stored, without fetching anything
+func runSEOReport(cfg crawler.Config) {
+	r, err := newCrawler(cfg).WriteSEOReport()
+	if err != nil {
+		log.Fatal("Error writing the SEO report: ", err)
+	}
+	if !jsonLogs {
+		r.WriteText(console)
+	}
+}
+
+func runRewriteLinks(cfg crawler.Config) {
+	if err := newCrawler(cfg).RewriteLinks(); err != nil {
+		log.Fatal("Error rewriting links: ", err)
+	}
+}
+
+func runMarkdown(cfg crawler.Config) {
+	if err := newCrawler(cfg).ExportMarkdown(); err != nil {
+		log.Fatal("Error converting to markdown: ", err)
+	}
+}
+
+func runIndex(cfg crawler.Config) {
+	if err := newCrawler(cfg).IndexPages(); err != nil {
+		log.Fatal("Error updating the search index: ", err)
+	}
+}
+
+func runSearch(cfg crawler.Config, query string, limit int) {
+	results, err := newCrawler(cfg).Search(query, limit)
+	if err != nil {
+		log.Fatal("Error searching: ", err)
+	}
+	if len(results) == 0 {
+		fmt.Println("No matches")
+		return
+	}
+	for i, r := range results {
+		fmt.Printf("%d. %s (%.2f)\n", i+1, r.URL, r.Score)
+		for _, line := range []string{r.Title, r.Snippet} {
+			if line != "" {
+				fmt.Printf("   %s\n", line)
+			}
+		}
+	}
+}
+
+// runVerify checks the saved files against the manifest, exiting with
+// exitFailures when any don't match
+func runVerify(cfg crawler.Config, repair bool) {
+	report, err := newCrawler(cfg).Verify(context.Background(), repair)
+	if err != nil {
+		log.Fatal("Error verifying: ", err)
+	}
+	if err := report.WriteText(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	if !report.OK() {
+		os.Exit(exitFailures)
+	}
+}
+
+func runCompress(cfg crawler.Config) {
+	stats, err := newCrawler(cfg).CompressStorage(context.Background())
+	if err != nil {
+		log.Fatal("Error compressing: ", err)
+	}
+	if err := stats.WriteText(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runExport(cfg crawler.Config, archive string) {
+	if err := newCrawler(cfg).Export(archive); err != nil {
+		log.Fatal("Error exporting: ", err)
+	}
+}
+
+func runImport(cfg crawler.Config, archive string) {
+	if err := newCrawler(cfg).Import(archive); err != nil {
+		log.Fatal("Error importing: ", err)
+	}
+}
+
+// runDiff compares the given snapshots; a missing one is the latest, or
+// the one before it if only the latest is left
+func runDiff(cfg crawler.Config, ids []string, text, asJSON bool) {
+	c := newCrawler(cfg)
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		log.Fatal("Error listing snapshots: ", err)
+	}
+	from, to, err := diffPair(snapshots, ids)
+	if err != nil {
+		log.Fatal("Error comparing snapshots: ", err)
+	}
+	d, err := c.DiffSnapshots(from, to, text)
+	if err != nil {
+		log.Fatal("Error comparing snapshots: ", err)
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	} else {
+		err = d.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// diffPair picks the snapshots diff compares from the ids given: the last
+// two with none, the one given against the newest, or, when that is the
+// newest, the one before it against it
+func diffPair(snapshots, ids []string) (from, to string, err error) {
+	switch len(ids) {
+	case 0:
+		if len(snapshots) < 2 {
+			return "", "", fmt.Errorf("need two snapshots to compare, found %d", len(snapshots))
+		}
+		return snapshots[len(snapshots)-2], snapshots[len(snapshots)-1], nil
+	case 1:
+		if len(snapshots) == 0 {
+			return "", "", errors.New("no snapshots to compare with")
+		}
+		newest := snapshots[len(snapshots)-1]
+		if ids[0] != newest {
+			return ids[0], newest, nil
+		}
+		if len(snapshots) < 2 {
+			return "", "", fmt.Errorf("%s is the only snapshot, there is none to compare it with", newest)
+		}
+		return snapshots[len(snapshots)-2], newest, nil
+	}
+	return ids[0], ids[1], nil
+}
+
+func runMigrate(cfg crawler.Config) {
+	s, err := newCrawler(cfg).MigrateFrontier()
+	if err != nil {
+		log.Fatal("Error migrating the frontier: ", err)
+	}
+	fmt.Printf("Imported %d found, %d scraped and %d failed URLs; %d are pending\n", s.Found, s.Scraped, s.Failed, s.Pending)
+}
+
+func runServe(cfg crawler.Config, port int) {
+	if err := newCrawler(cfg).Serve(fmt.Sprintf("localhost:%d", port)); err != nil {
+		log.Fatal(err)
+	}
+}
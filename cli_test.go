@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSettingsPrecedence(t *testing.T) {
+	tests := []struct {
+		name               string
+		flag, env          string
+		dotEnv, configFile string
+		want               int
+	}{
+		{name: "default", want: 10},
+		{name: "config file", configFile: "workers: 4\n", want: 4},
+		{name: ".env over the config file", dotEnv: "NUM_WORKERS=5\n", configFile: "workers: 4\n", want: 5},
+		{name: "environment over .env", env: "6", dotEnv: "NUM_WORKERS=5\n", configFile: "workers: 4\n", want: 6},
+		{name: "flag over the environment", flag: "7", env: "6", dotEnv: "NUM_WORKERS=5\n", configFile: "workers: 4\n", want: 7},
+		{name: "flag alone", flag: "7", want: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			envFile, configFile := filepath.Join(dir, ".env"), filepath.Join(dir, "crawler.yaml")
+			os.WriteFile(envFile, []byte(tt.dotEnv), 0o644)
+			os.WriteFile(configFile, []byte(tt.configFile), 0o644)
+			t.Setenv("BASE_URL", "https://example.com/")
+			// Setenv restores NUM_WORKERS afterwards, whoever sets it
+			t.Setenv("NUM_WORKERS", tt.env)
+			if tt.env == "" {
+				os.Unsetenv("NUM_WORKERS")
+			}
+
+			fs := flag.NewFlagSet("crawl", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			settings := settingFlags(fs)
+			args := []string{"-env-file", envFile, "-config", configFile}
+			if tt.flag != "" {
+				args = append(args, "-workers", tt.flag)
+			}
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			s, err := loadSettings(fs, settings)
+			if err != nil {
+				t.Fatalf("loadSettings: %v", err)
+			}
+			if s.Crawler.Workers != tt.want {
+				t.Errorf("workers = %d, want %d", s.Crawler.Workers, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSettingsFiles(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "missing defaults", args: nil},
+		{name: "missing .env given by name", args: []string{"-env-file", missing}, wantErr: true},
+		{name: "missing config file given by name", args: []string{"-config", missing}, wantErr: true},
+	}
+	// the default files are looked for in the working directory
+	t.Chdir(dir)
+	t.Setenv("BASE_URL", "https://example.com/")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("crawl", flag.ContinueOnError)
+			settings := settingFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadSettings(fs, settings); (err != nil) != tt.wantErr {
+				t.Errorf("loadSettings error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiffPair(t *testing.T) {
+	three := []string{"run-1", "run-2", "run-3"}
+	tests := []struct {
+		name      string
+		snapshots []string
+		ids       []string
+		from, to  string
+		wantErr   bool
+	}{
+		{name: "last two", snapshots: three, from: "run-2", to: "run-3"},
+		{name: "one snapshot", snapshots: three[:1], wantErr: true},
+		{name: "older against the newest", snapshots: three, ids: []string{"run-1"}, from: "run-1", to: "run-3"},
+		{name: "the newest against the one before", snapshots: three, ids: []string{"run-3"}, from: "run-2", to: "run-3"},
+		{name: "the only snapshot", snapshots: three[:1], ids: []string{"run-1"}, wantErr: true},
+		{name: "none to compare with", ids: []string{"run-1"}, wantErr: true},
+		{name: "both given", snapshots: three, ids: []string{"run-3", "run-1"}, from: "run-3", to: "run-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := diffPair(tt.snapshots, tt.ids)
+			if (err != nil) != tt.wantErr || from != tt.from || to != tt.to {
+				t.Errorf("diffPair(%v, %v) = %s, %s, %v, want %s, %s, error %v", tt.snapshots, tt.ids, from, to, err, tt.from, tt.to, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"simple-web-scraper/pkg/crawler"
+)
+
+// pageLine is a line of -output jsonl; ndjson-full adds the links and the
+// metadata
+type pageLine struct {
+	URL         string            `json:"url"`
+	FinalURL    string            `json:"final_url,omitempty"`
+	Status      int               `json:"status,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	File        string            `json:"file,omitempty"`
+	Depth       int               `json:"depth"`
+	LinkCount   int               `json:"link_count"`
+	Bytes       int64             `json:"bytes"`
+	DurationMS  int64             `json:"duration_ms"`
+	Links       []string          `json:"links,omitempty"`
+	Canonical   string            `json:"canonical,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Lang        string            `json:"lang,omitempty"`
+	OpenGraph   map[string]string `json:"og,omitempty"`
+	Twitter     map[string]string `json:"twitter,omitempty"`
+}
+
+// failureLine is the line of a URL that failed for good
+type failureLine struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// pageStream writes a JSON line to stdout for every page the crawl saves
+// and every URL that fails, from one goroutine so lines never interleave,
+// flushing each as it goes
+type pageStream struct {
+	lines chan any
+	done  chan struct{}
+}
+
+// streamPages hooks a page stream onto c; full adds each page's links and
+// metadata to its line
+func streamPages(c *crawler.Crawler, full bool) *pageStream {
+	s := &pageStream{lines: make(chan any, 256), done: make(chan struct{})}
+	c.OnPage = func(p crawler.PageResult) {
+		line := pageLine{URL: p.URL, FinalURL: p.FinalURL, Status: p.Status, Title: p.Title, ContentType: p.ContentType,
+			File: p.File, Depth: p.Depth, LinkCount: len(p.Links), Bytes: p.Bytes, DurationMS: p.Duration.Milliseconds()}
+		if full {
+			line.Links, line.Canonical, line.Description, line.Lang = p.Links, p.Canonical, p.Description, p.Lang
+			line.OpenGraph, line.Twitter = p.OpenGraph, p.Twitter
+		}
+		s.lines <- line
+	}
+	c.OnError = func(url string, err error) {
+		s.lines <- failureLine{URL: url, Error: err.Error()}
+	}
+	go func() {
+		defer close(s.done)
+		w := bufio.NewWriter(os.Stdout)
+		enc := json.NewEncoder(w)
+		for line := range s.lines {
+			if enc.Encode(line) == nil {
+				w.Flush()
+			}
+		}
+	}()
+	return s
+}
+
+// close writes out the lines still queued; the crawl must be over
+func (s *pageStream) close() {
+	close(s.lines)
+	<-s.done
+}
@@ -0,0 +1,138 @@
+// Package config loads the crawler's settings from the environment and a
+// crawler.yaml file, checking all of them and reporting every problem at
+// once.
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"simple-web-scraper/pkg/crawler"
+)
+
+// Settings is everything Load reads: the crawler's Config and the settings
+// of the command around it
+type Settings struct {
+	Crawler crawler.Config
+	// ServePort is the default port for the serve command
+	ServePort int
+	// Output is how a crawl reports its pages on stdout: "" for not at
+	// all, "jsonl" or "ndjson-full" for a JSON line per page
+	Output string
+	// ControlAddr is where the crawl serves its control API, if anywhere,
+	// and ControlToken the token its requests must carry
+	ControlAddr, ControlToken string
+	// LogLevel and JSONLogs are LOG_LEVEL and LOG_FORMAT
+	LogLevel slog.Level
+	JSONLogs bool
+}
+
+// Options says where Load finds the settings
+type Options struct {
+	// File is the YAML config file. A missing one is fine unless Explicit
+	// is set, as it is when the file was asked for by name.
+	File     string
+	Explicit bool
+	// Lookup finds a setting by name, like os.LookupEnv; a setting it has,
+	// even empty, wins over the file's. Nil means os.LookupEnv.
+	Lookup func(name string) (string, bool)
+	// Aliases maps the other names a file key may use, like a flag's, to
+	// the settings they stand for
+	Aliases map[string]string
+}
+
+// Error lists every problem found with the settings
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Load reads every setting, from Lookup or else the config file, and
+// validates them. All the problems come back together in an *Error.
+func Load(opts Options) (Settings, error) {
+	l := &loader{lookupEnv: opts.Lookup, read: make(map[string]bool)}
+	if l.lookupEnv == nil {
+		l.lookupEnv = os.LookupEnv
+	}
+	if opts.File != "" {
+		f, err := os.Open(opts.File)
+		switch {
+		case err == nil:
+			l.file, err = parseFile(opts.File, f, opts.Aliases)
+			f.Close()
+			if err != nil {
+				l.errors(err)
+			}
+			if l.file == nil && err != nil {
+				// the file isn't YAML, and what is missing because of it
+				// would only bury that
+				return Settings{}, &Error{Problems: l.problems}
+			}
+		case opts.Explicit || !os.IsNotExist(err):
+			l.problem("%v", err)
+			return Settings{}, &Error{Problems: l.problems}
+		}
+	}
+	s := l.settings()
+	l.unknownKeys(opts.File)
+	if len(l.problems) > 0 {
+		return s, &Error{Problems: l.problems}
+	}
+	return s, nil
+}
+
+// loader reads the settings and collects what is wrong with them
+type loader struct {
+	lookupEnv func(string) (string, bool)
+	// file holds the config file's settings by name
+	file map[string]fileSetting
+	// read holds every setting name looked up, so keys of the file that
+	// no setting reads can be reported
+	read     map[string]bool
+	problems []string
+}
+
+func (l *loader) problem(format string, args ...any) {
+	l.problems = append(l.problems, fmt.Sprintf(format, args...))
+}
+
+// errors records each error an errors.Join combined as its own problem
+func (l *loader) errors(err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			l.problem("%v", e)
+		}
+		return
+	}
+	l.problem("%v", err)
+}
+
+// lookup returns a setting, from the environment or else the config file
+func (l *loader) lookup(name string) string {
+	l.read[name] = true
+	if v, ok := l.lookupEnv(name); ok {
+		return v
+	}
+	return l.file[name].value
+}
+
+// unknownKeys reports the config file keys no setting read, in the order
+// of their lines
+func (l *loader) unknownKeys(path string) {
+	var unknown []string
+	for key := range l.file {
+		if !l.read[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return l.file[unknown[i]].line < l.file[unknown[j]].line })
+	for _, key := range unknown {
+		l.problem("%s:%d: unknown setting %q", path, l.file[key].line, l.file[key].key)
+	}
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"simple-web-scraper/pkg/crawler"
+)
+
+var testAliases = map[string]string{"workers": "NUM_WORKERS", "project": "PROJECT_FOLDERNAME"}
+
+// env is an environment holding just vars
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		check func(t *testing.T, cfg crawler.Config)
+	}{
+		{
+			name: "the file's settings",
+			file: "testdata/good.yaml",
+			env:  map[string]string{"LOGIN_PASSWORD": "secret"},
+			check: func(t *testing.T, cfg crawler.Config) {
+				tests := []struct {
+					setting   string
+					got, want any
+				}{
+					{"base_url", cfg.BaseURL, "https://example.com/"},
+					{"project", cfg.ProjectFolder, "example"},
+					{"workers", cfg.Workers, 4},
+					{"MAX_DEPTH", cfg.MaxDepth, 2},
+					{"include_patterns", len(cfg.Include), 2},
+					{"exclude_patterns", len(cfg.Exclude), 2},
+					{"respect_robots", cfg.RespectRobots, false},
+					{"user_agents", cfg.UserAgents, []string{"crawler #1"}},
+					{"login url", cfg.Login.URL, "https://example.com/login"},
+					{"login fields", cfg.Login.Fields, map[string]string{"username": "alice", "password": "secret"}},
+					{"login success_selector", cfg.Login.SuccessSelector, "#account"},
+				}
+				for _, tt := range tests {
+					if !reflect.DeepEqual(tt.got, tt.want) {
+						t.Errorf("%s = %v, want %v", tt.setting, tt.got, tt.want)
+					}
+				}
+			},
+		},
+		{
+			name: "the environment wins over the file",
+			file: "testdata/good.yaml",
+			env:  map[string]string{"LOGIN_PASSWORD": "secret", "NUM_WORKERS": "8", "RESPECT_ROBOTS": "true"},
+			check: func(t *testing.T, cfg crawler.Config) {
+				if cfg.Workers != 8 || !cfg.RespectRobots {
+					t.Errorf("workers = %d, respect robots = %v, want the environment's 8 and true", cfg.Workers, cfg.RespectRobots)
+				}
+			},
+		},
+		{
+			name: "host mapping and resolve",
+			env: map[string]string{
+				"BASE_URL":     "https://example.com/",
+				"HOST_MAPPING": "example.com=10.0.0.5:443, cdn.example.com=10.0.0.6",
+				"RESOLVE":      "api.example.com:8443:10.0.0.7,v6.example.com:443:[::1]",
+			},
+			check: func(t *testing.T, cfg crawler.Config) {
+				want := map[string]string{
+					"example.com":          "10.0.0.5:443",
+					"cdn.example.com":      "10.0.0.6",
+					"api.example.com:8443": "10.0.0.7:8443",
+					"v6.example.com:443":   "[::1]:443",
+				}
+				if !reflect.DeepEqual(cfg.HostMapping, want) {
+					t.Errorf("host mapping = %v, want %v", cfg.HostMapping, want)
+				}
+			},
+		},
+		{
+			name: "a missing file that wasn't asked for",
+			file: "testdata/missing.yaml",
+			env:  map[string]string{"BASE_URL": "https://example.com/"},
+			check: func(t *testing.T, cfg crawler.Config) {
+				if cfg.Workers != crawler.DefaultConfig("").Workers {
+					t.Errorf("workers = %d, want the default", cfg.Workers)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Load(Options{File: tt.file, Lookup: env(tt.env), Aliases: testAliases})
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tt.check(t, s.Crawler)
+		})
+	}
+}
+
+func TestLoadProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		env      map[string]string
+		problems []string
+	}{
+		{
+			name: "every problem in the file and its values",
+			opts: Options{File: "testdata/bad.yaml"},
+			problems: []string{
+				`testdata/bad.yaml:5: max_depth is already set on line 4`,
+				`testdata/bad.yaml:9: nested settings are not supported`,
+				`BASE_URL "example.com" has no scheme`,
+				`NUM_WORKERS must be a whole number of at least 1, got "-2"`,
+				`INCLUDE_PATTERNS`,
+				`testdata/bad.yaml:6: unknown setting "no_such_setting"`,
+			},
+		},
+		{
+			name:     "a syntax error",
+			opts:     Options{File: "testdata/syntax.yaml"},
+			problems: []string{"testdata/syntax.yaml: yaml: line 1: did not find expected"},
+		},
+		{
+			name:     "a missing file asked for by name",
+			opts:     Options{File: "testdata/missing.yaml", Explicit: true},
+			env:      map[string]string{"BASE_URL": "https://example.com/"},
+			problems: []string{"testdata/missing.yaml: no such file"},
+		},
+		{
+			name:     "an unset ${NAME}",
+			opts:     Options{File: "testdata/good.yaml"},
+			problems: []string{"LOGIN_PASSWORD is not set"},
+		},
+		{
+			name: "bad host mapping and resolve entries",
+			env:  map[string]string{"BASE_URL": "https://example.com/", "HOST_MAPPING": "example.com", "RESOLVE": "example.com:https:10.0.0.5,example.com:443"},
+			problems: []string{
+				`HOST_MAPPING entries must be host=address, got "example.com"`,
+				`RESOLVE entries must be host:port:address, got "example.com:https:10.0.0.5"`,
+				`RESOLVE entries must be host:port:address, got "example.com:443"`,
+			},
+		},
+		{
+			name: "bad values in the environment",
+			env:  map[string]string{"BASE_URL": "ftp://example.com/", "LOG_LEVEL": "loud", "OUTPUT": "xml", "MAX_ERROR_RATE": "-1"},
+			problems: []string{
+				`BASE_URL must be an http or https URL, got "ftp://example.com/"`,
+				`MAX_ERROR_RATE must be a non-negative number, got "-1"`,
+				`OUTPUT must be jsonl or ndjson-full, got "xml"`,
+				`LOG_LEVEL must be debug, info, warn or error, got "loud"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Lookup, tt.opts.Aliases = env(tt.env), testAliases
+			_, err := Load(tt.opts)
+			invalid, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("Load = %v, want an *Error", err)
+			}
+			if len(invalid.Problems) != len(tt.problems) {
+				t.Errorf("%d problems, want %d:\n  %s", len(invalid.Problems), len(tt.problems), strings.Join(invalid.Problems, "\n  "))
+			}
+			for _, want := range tt.problems {
+				found := false
+				for _, p := range invalid.Problems {
+					found = found || strings.Contains(p, want)
+				}
+				if !found {
+					t.Errorf("no problem mentions %q:\n  %s", want, strings.Join(invalid.Problems, "\n  "))
+				}
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"30m", "30m0s", true},
+		{"7d", "168h0m0s", true},
+		{"1d12h", "36h0m0s", true},
+		{"d", "", false},
+		{"soon", "", false},
+	}
+	for _, tt := range tests {
+		d, err := parseDuration(tt.in)
+		if (err == nil) != tt.ok || (tt.ok && d.String() != tt.want) {
+			t.Errorf("parseDuration(%q) = %s, %v", tt.in, d, err)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"512", 512, true},
+		{"512KB", 512 << 10, true},
+		{"1.5 MB", 3 << 19, true},
+		{"2gb", 2 << 30, true},
+		{"-1MB", 0, false},
+		{"lots", 0, false},
+	}
+	for _, tt := range tests {
+		n, err := parseByteSize(tt.in)
+		if (err == nil) != tt.ok || n != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v", tt.in, n, err)
+		}
+	}
+}
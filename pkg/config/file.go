@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileSetting is a setting from the config file: the key it was written
+// under, its value and the line it is on
+type fileSetting struct {
+	key, value string
+	line       int
+}
+
+// parseFile reads a crawler.yaml file. Its top level maps keys to values;
+// keys are the setting names in either case (num_workers or NUM_WORKERS)
+// or one of aliases, the flag names (workers). A value is a scalar, a list
+// of scalars, which becomes a comma-separated value the way list settings
+// are written in the environment, or a block of such keys that set the
+// settings named after the block and the key (url in a login block sets
+// LOGIN_URL). Anything nested deeper is reported, every such key and not
+// just the first; the other settings still come back with them.
+func parseFile(path string, r io.Reader, aliases map[string]string) (map[string]fileSetting, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	settings := make(map[string]fileSetting)
+	var problems []error
+	bad := func(n *yaml.Node, format string, args ...any) {
+		problems = append(problems, fmt.Errorf("%s:%d: %s", path, n.Line, fmt.Sprintf(format, args...)))
+	}
+	set := func(name string, key, value *yaml.Node) {
+		v, ok := listValue(value)
+		if !ok {
+			bad(value, "nested settings are not supported")
+			return
+		}
+		if prev, ok := settings[name]; ok {
+			bad(key, "%s is already set on line %d", key.Value, prev.line)
+			return
+		}
+		settings[name] = fileSetting{key: key.Value, value: v, line: key.Line}
+	}
+
+	top := doc.Content[0]
+	if top.Kind != yaml.MappingNode {
+		bad(top, "expected \"key: value\" pairs")
+		return nil, errors.Join(problems...)
+	}
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		key, value := top.Content[i], resolve(top.Content[i+1])
+		name := settingName(key.Value, aliases)
+		if value.Kind != yaml.MappingNode {
+			set(name, key, value)
+			continue
+		}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			inner := value.Content[j]
+			set(name+"_"+strings.ToUpper(inner.Value), inner, resolve(value.Content[j+1]))
+		}
+	}
+	return settings, errors.Join(problems...)
+}
+
+// listValue is a scalar as written, or a list of scalars joined by commas
+func listValue(n *yaml.Node) (string, bool) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return "", true
+		}
+		return n.Value, true
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(n.Content))
+		for _, item := range n.Content {
+			if item = resolve(item); item.Kind != yaml.ScalarNode {
+				return "", false
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), true
+	}
+	return "", false
+}
+
+// resolve follows an alias to the node it names
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+// settingName maps a config file key to the setting it sets
+func settingName(key string, aliases map[string]string) string {
+	if name, ok := aliases[strings.ReplaceAll(key, "_", "-")]; ok {
+		return name
+	}
+	return strings.ToUpper(key)
+}
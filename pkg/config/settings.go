@@ -0,0 +1,604 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"simple-web-scraper/pkg/crawler"
+)
+
+// envInt reads a whole-number setting of at least min, falling back to def
+// when it is missing. An invalid value is recorded as a config problem.
+func (l *loader) envInt(name string, def, min int) int {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		l.problem("%s must be a whole number of at least %d, got %q", name, min, v)
+		return def
+	}
+	return n
+}
+
+// envString reads a setting, falling back to def when it is empty
+func (l *loader) envString(name, def string) string {
+	if v := l.lookup(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envFloat reads a non-negative number, falling back to def when it is
+// missing
+func (l *loader) envFloat(name string, def float64) float64 {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		l.problem("%s must be a non-negative number, got %q", name, v)
+		return def
+	}
+	return f
+}
+
+// envDuration reads a duration such as "30m" or "7d", falling back to def
+// when it is missing
+func (l *loader) envDuration(name string, def time.Duration) time.Duration {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	d, err := parseDuration(v)
+	if err != nil || d < 0 {
+		l.problem("%s must be a duration such as 30m or 7d, got %q", name, v)
+		return def
+	}
+	return d
+}
+
+// parseDuration is time.ParseDuration that also takes a leading number of
+// days, as in 7d or 1d12h
+func parseDuration(v string) (time.Duration, error) {
+	days, rest, ok := strings.Cut(v, "d")
+	if !ok {
+		return time.ParseDuration(v)
+	}
+	n, err := strconv.Atoi(days)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(n) * 24 * time.Hour
+	if rest != "" {
+		r, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, err
+		}
+		d += r
+	}
+	return d, nil
+}
+
+// envByteSize reads a size such as "512KB" or "10MB", falling back to def
+// when it is missing. Zero means no limit.
+func (l *loader) envByteSize(name string, def int64) int64 {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	n, err := parseByteSize(v)
+	if err != nil {
+		l.problem("%s must be a size such as 10MB, got %q", name, v)
+		return def
+	}
+	return n
+}
+
+// envBandwidth reads a rate such as "5MB/s", the "/s" being optional,
+// falling back to def when it is missing. Zero means no limit.
+func (l *loader) envBandwidth(name string, def int64) int64 {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	n, err := parseByteSize(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(v)), "/s"))
+	if err != nil {
+		l.problem("%s must be a rate such as 5MB/s, got %q", name, v)
+		return def
+	}
+	return n
+}
+
+// parseByteSize parses a byte count with an optional B, KB, MB or GB
+// suffix, using powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// envBool reads a true/false setting, falling back to def when it is
+// missing
+func (l *loader) envBool(name string, def bool) bool {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.problem("%s must be true or false, got %q", name, v)
+		return def
+	}
+	return b
+}
+
+// settings reads every setting, recording the problems with them
+func (l *loader) settings() Settings {
+	var s Settings
+	cfg := crawler.DefaultConfig(l.lookup("BASE_URL"))
+	if cfg.BaseURL == "" {
+		l.problem("BASE_URL is not set; pass -base-url or set it in the environment, .env or the config file")
+	} else if !strings.Contains(cfg.BaseURL, "://") {
+		l.problem("BASE_URL %q has no scheme; did you mean %q?", cfg.BaseURL, "https://"+cfg.BaseURL)
+	} else if base, err := url.Parse(cfg.BaseURL); err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		l.problem("BASE_URL must be an http or https URL, got %q", cfg.BaseURL)
+	}
+	// Without a project folder, the crawler names one after the host
+	cfg.ProjectFolder = l.envString("PROJECT_FOLDERNAME", "")
+	cfg.FrontierBackend = l.envString("FRONTIER", cfg.FrontierBackend)
+	if cfg.FrontierBackend != "sqlite" && cfg.FrontierBackend != "journal" && cfg.FrontierBackend != "text" {
+		l.problem("FRONTIER must be sqlite, journal or text, got %q", cfg.FrontierBackend)
+	}
+	cfg.FoundURLsFile = l.envString("FOUND_URLS_FILENAME", cfg.FoundURLsFile)
+	cfg.ScrapedURLsFile = l.envString("SCRAPED_URLS_FILENAME", cfg.ScrapedURLsFile)
+	cfg.FailedURLsFile = l.envString("FAILED_URLS_FILENAME", cfg.FailedURLsFile)
+	cfg.RedirectsFile = l.envString("REDIRECTS_FILENAME", cfg.RedirectsFile)
+	cfg.ExternalURLsFile = l.envString("EXTERNAL_URLS_FILENAME", cfg.ExternalURLsFile)
+	cfg.DownloadFolder = l.envString("DOWNLOADED_FILES_FOLDERNAME", cfg.DownloadFolder)
+	cfg.StorageBackend = l.envString("STORAGE_BACKEND", cfg.StorageBackend)
+	switch cfg.StorageBackend {
+	case "fs":
+	case "s3":
+		cfg.S3 = crawler.S3Config{
+			Endpoint:        l.envString("S3_ENDPOINT", ""),
+			Bucket:          l.envString("S3_BUCKET", ""),
+			Region:          l.envString("S3_REGION", ""),
+			AccessKeyID:     l.envString("S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: l.envString("S3_SECRET_ACCESS_KEY", ""),
+			Prefix:          l.envString("S3_PREFIX", ""),
+		}
+		for _, s := range []struct{ name, v string }{
+			{"S3_ENDPOINT", cfg.S3.Endpoint},
+			{"S3_BUCKET", cfg.S3.Bucket},
+			{"S3_ACCESS_KEY_ID", cfg.S3.AccessKeyID},
+			{"S3_SECRET_ACCESS_KEY", cfg.S3.SecretAccessKey},
+		} {
+			if s.v == "" {
+				l.problem("%s must be set when STORAGE_BACKEND is s3", s.name)
+			}
+		}
+	case "sqlite":
+		cfg.SQLiteFile = l.envString("SQLITE_FILENAME", cfg.SQLiteFile)
+	default:
+		l.problem("STORAGE_BACKEND must be fs, s3 or sqlite, got %q", cfg.StorageBackend)
+	}
+	cfg.StorageCompression = l.envString("STORAGE_COMPRESSION", cfg.StorageCompression)
+	switch cfg.StorageCompression {
+	case "none":
+	case "gzip", "zstd":
+		if cfg.StorageBackend != "fs" {
+			l.problem("STORAGE_COMPRESSION %s needs STORAGE_BACKEND fs", cfg.StorageCompression)
+		}
+	default:
+		l.problem("STORAGE_COMPRESSION must be none, gzip or zstd, got %q", cfg.StorageCompression)
+	}
+	cfg.RespectRobots = l.envBool("RESPECT_ROBOTS", cfg.RespectRobots)
+	cfg.RespectNofollow = l.envBool("RESPECT_NOFOLLOW", cfg.RespectNofollow)
+	cfg.RespectCanonical = l.envBool("RESPECT_CANONICAL", cfg.RespectCanonical)
+	cfg.SkipNonCanonical = l.envBool("SKIP_NON_CANONICAL", cfg.SkipNonCanonical)
+	cfg.SeedFromSitemap = l.envBool("SEED_FROM_SITEMAP", cfg.SeedFromSitemap)
+	cfg.MetaRefresh = l.envBool("META_REFRESH", cfg.MetaRefresh)
+	cfg.MetaRefreshMaxDelay = l.envDuration("META_REFRESH_MAX_DELAY", cfg.MetaRefreshMaxDelay)
+	cfg.ScriptRedirects = l.envBool("SCRIPT_REDIRECTS", cfg.ScriptRedirects)
+	cfg.ScriptLinks = l.envBool("SCRIPT_LINKS", cfg.ScriptLinks)
+	cfg.ScriptScanLimit = l.envByteSize("SCRIPT_SCAN_LIMIT", cfg.ScriptScanLimit)
+	for _, s := range strings.Split(l.lookup("SEED_URLS"), ",") {
+		if s = strings.TrimSpace(s); s == "-" {
+			cfg.SeedURLs = append(cfg.SeedURLs, l.readURLList("SEED_URLS", "-")...)
+		} else if s != "" {
+			cfg.SeedURLs = append(cfg.SeedURLs, s)
+		}
+	}
+	if v := l.lookup("SEED_FILE"); v != "" {
+		cfg.SeedURLs = append(cfg.SeedURLs, l.readURLList("SEED_FILE", v)...)
+	}
+	cfg.AllowSeedDomains = l.envBool("ALLOW_SEED_DOMAINS", cfg.AllowSeedDomains)
+	cfg.Workers = l.envInt("NUM_WORKERS", cfg.Workers, 1)
+	cfg.AdaptiveConcurrency = l.envBool("ADAPTIVE_CONCURRENCY", cfg.AdaptiveConcurrency)
+	cfg.MinWorkers = l.envInt("MIN_WORKERS", cfg.MinWorkers, 1)
+	cfg.MaxWorkers = l.envInt("MAX_WORKERS", cfg.MaxWorkers, 1)
+	cfg.AdaptInterval = l.envDuration("ADAPT_INTERVAL", cfg.AdaptInterval)
+	cfg.MaxErrorRate = l.envFloat("MAX_ERROR_RATE", cfg.MaxErrorRate)
+	cfg.MaxDepth = l.envInt("MAX_DEPTH", cfg.MaxDepth, -1)
+	cfg.MaxPaginationChain = l.envInt("MAX_PAGINATION_CHAIN", cfg.MaxPaginationChain, 0)
+	switch cfg.CrawlOrder = l.envString("CRAWL_ORDER", cfg.CrawlOrder); cfg.CrawlOrder {
+	case "bfs", "dfs":
+	default:
+		l.problem("CRAWL_ORDER must be bfs or dfs, got %q", cfg.CrawlOrder)
+	}
+	var err error
+	if cfg.PriorityRules, err = crawler.CompilePriorityRules("PRIORITY_RULES", l.lookup("PRIORITY_RULES")); err != nil {
+		l.errors(err)
+	}
+	if cfg.Include, err = crawler.CompilePatterns("INCLUDE_PATTERNS", l.lookup("INCLUDE_PATTERNS")); err != nil {
+		l.errors(err)
+	}
+	if cfg.Exclude, err = crawler.CompilePatterns("EXCLUDE_PATTERNS", l.lookup("EXCLUDE_PATTERNS")); err != nil {
+		l.errors(err)
+	}
+	if v := l.lookup("ALLOWED_LANGUAGES"); v != "" {
+		cfg.AllowedLanguages = strings.Split(v, ",")
+	}
+	cfg.FollowOtherLanguages = l.envBool("FOLLOW_OTHER_LANGUAGES", cfg.FollowOtherLanguages)
+	if v := l.lookup("PATH_BUDGETS"); v != "" {
+		cfg.PathBudgets = make(map[string]int)
+		for _, b := range strings.Split(v, ",") {
+			prefix, n, ok := strings.Cut(strings.TrimSpace(b), "=")
+			budget, err := strconv.Atoi(strings.TrimSpace(n))
+			if !ok || !strings.HasPrefix(prefix, "/") || err != nil || budget < 0 {
+				l.problem("PATH_BUDGETS entries must be /prefix=count, got %q", b)
+				continue
+			}
+			cfg.PathBudgets[prefix] = budget
+		}
+	}
+	cfg.PathBudget = l.envInt("PATH_BUDGET", cfg.PathBudget, 0)
+	if cfg.CrawlBudgets, err = crawler.CompileCrawlBudgets("CRAWL_BUDGETS", l.lookup("CRAWL_BUDGETS")); err != nil {
+		l.errors(err)
+	}
+	cfg.MaxQueryParams = l.envInt("MAX_QUERY_PARAMS", cfg.MaxQueryParams, 0)
+	cfg.MaxPathRepeats = l.envInt("MAX_PATH_REPEATS", cfg.MaxPathRepeats, 0)
+	cfg.WarnNewURLs = l.envInt("WARN_NEW_URLS", cfg.WarnNewURLs, 0)
+	if path := l.lookup("EXTRACT_RULES"); path != "" {
+		if cfg.ExtractRules, err = crawler.LoadExtractRules(path); err != nil {
+			l.errors(err)
+		}
+	}
+	cfg.ExtractStructuredData = l.envBool("EXTRACT_STRUCTURED_DATA", cfg.ExtractStructuredData)
+	switch v := l.envString("EXTRACT_TEXT", "off"); v {
+	case "off":
+	case "full", "main":
+		cfg.ExtractText = v
+	default:
+		l.problem("EXTRACT_TEXT must be off, full or main, got %q", v)
+	}
+	cfg.ThinContentWords = l.envInt("THIN_CONTENT_WORDS", cfg.ThinContentWords, 0)
+	cfg.DetectSoft404 = l.envBool("DETECT_SOFT_404", cfg.DetectSoft404)
+	if cfg.RenderPatterns, err = crawler.CompilePatterns("RENDER_PATTERNS", l.lookup("RENDER_PATTERNS")); err != nil {
+		l.errors(err)
+	}
+	cfg.RenderMinLinks = l.envInt("RENDER_MIN_LINKS", cfg.RenderMinLinks, 0)
+	cfg.RenderWaitSelector = l.envString("RENDER_WAIT_SELECTOR", cfg.RenderWaitSelector)
+	cfg.RenderWait = l.envDuration("RENDER_WAIT", cfg.RenderWait)
+	cfg.RenderTimeout = l.envDuration("RENDER_TIMEOUT", cfg.RenderTimeout)
+	cfg.RenderWorkers = l.envInt("RENDER_WORKERS", cfg.RenderWorkers, 1)
+	cfg.ChromePath = l.envString("CHROME_PATH", cfg.ChromePath)
+	cfg.Screenshots = l.envBool("SCREENSHOTS", cfg.Screenshots)
+	cfg.ScreenshotWidth = l.envInt("SCREENSHOT_WIDTH", cfg.ScreenshotWidth, 1)
+	cfg.ScreenshotHeight = l.envInt("SCREENSHOT_HEIGHT", cfg.ScreenshotHeight, 1)
+	cfg.ScreenshotAboveFold = l.envBool("SCREENSHOT_ABOVE_FOLD", cfg.ScreenshotAboveFold)
+	cfg.Markdown = l.envBool("MARKDOWN", cfg.Markdown)
+	cfg.SearchIndex = l.envBool("SEARCH_INDEX", cfg.SearchIndex)
+	cfg.WARC = l.envBool("WARC", cfg.WARC)
+	cfg.WARCMaxSize = l.envByteSize("WARC_MAX_SIZE", cfg.WARCMaxSize)
+	cfg.Snapshots = l.envBool("SNAPSHOTS", cfg.Snapshots)
+	if v := l.lookup("ALLOWED_DOMAINS"); v != "" {
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				cfg.AllowedDomains = append(cfg.AllowedDomains, d)
+			}
+		}
+	}
+	cfg.NormalizeQuery = l.envBool("NORMALIZE_QUERY", cfg.NormalizeQuery)
+	cfg.NormalizeScheme = l.envBool("NORMALIZE_SCHEME", cfg.NormalizeScheme)
+	cfg.NormalizeWWW = l.envBool("NORMALIZE_WWW", cfg.NormalizeWWW)
+	if v := l.lookup("STRIP_QUERY_PARAMS"); v != "" {
+		cfg.StripQueryParams = nil
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				cfg.StripQueryParams = append(cfg.StripQueryParams, p)
+			}
+		}
+	}
+	cfg.ShutdownTimeout = time.Duration(l.envInt("SHUTDOWN_TIMEOUT_SECONDS", int(cfg.ShutdownTimeout/time.Second), 0)) * time.Second
+	cfg.Deadline = l.envDuration("CRAWL_DEADLINE", cfg.Deadline)
+	cfg.MaxBodySize = l.envByteSize("MAX_BODY_SIZE", cfg.MaxBodySize)
+	switch cfg.ProbeHead = l.envString("PROBE_HEAD", cfg.ProbeHead); cfg.ProbeHead {
+	case "off", "on", "auto":
+	default:
+		l.problem("PROBE_HEAD must be off, on or auto, got %q", cfg.ProbeHead)
+	}
+	cfg.ProbeAutoRatio = l.envFloat("PROBE_AUTO_RATIO", cfg.ProbeAutoRatio)
+	if cfg.ProbeAutoRatio < 0 || cfg.ProbeAutoRatio > 1 {
+		l.problem("PROBE_AUTO_RATIO must be a fraction between 0 and 1, got %g", cfg.ProbeAutoRatio)
+	}
+	cfg.SaveNonHTML = l.envBool("SAVE_NON_HTML", cfg.SaveNonHTML)
+	cfg.KeepRawEncoded = l.envBool("KEEP_RAW_ENCODED", cfg.KeepRawEncoded)
+	cfg.KeepOriginalCharset = l.envBool("KEEP_ORIGINAL_CHARSET", cfg.KeepOriginalCharset)
+	cfg.DedupContent = l.envBool("DEDUP_CONTENT", cfg.DedupContent)
+	cfg.RecordAllReferrers = l.envBool("RECORD_ALL_REFERRERS", cfg.RecordAllReferrers)
+	cfg.RecordHeaders = l.envBool("RECORD_HEADERS", cfg.RecordHeaders)
+	cfg.ExtractContacts = l.envBool("EXTRACT_CONTACTS", cfg.ExtractContacts)
+	if v := l.lookup("DOWNLOAD_CONTENT_TYPES"); v != "" {
+		cfg.DownloadContentTypes = strings.Split(v, ",")
+	}
+	if v := l.lookup("DOCUMENT_TYPES"); v != "" {
+		cfg.DocumentTypes = strings.Split(v, ",")
+	}
+	cfg.DownloadAssets = l.envBool("DOWNLOAD_ASSETS", cfg.DownloadAssets)
+	cfg.CrawlImages = l.envBool("CRAWL_IMAGES", cfg.CrawlImages)
+	cfg.ImagesReportOnly = l.envBool("IMAGES_REPORT_ONLY", cfg.ImagesReportOnly)
+	cfg.ReportHTML = l.envBool("REPORT_HTML", cfg.ReportHTML)
+	cfg.SEOTitleMaxLength = l.envInt("SEO_TITLE_MAX_LENGTH", cfg.SEOTitleMaxLength, 1)
+	cfg.RewriteUncrawled = l.envString("REWRITE_UNCRAWLED", cfg.RewriteUncrawled)
+	if cfg.RewriteUncrawled != "keep" && cfg.RewriteUncrawled != "live" {
+		l.problem("REWRITE_UNCRAWLED must be keep or live, got %q", cfg.RewriteUncrawled)
+	}
+	cfg.AssetMaxSize = l.envByteSize("ASSET_MAX_SIZE", cfg.AssetMaxSize)
+	if v := l.lookup("ASSET_SKIP_EXTENSIONS"); v != "" {
+		cfg.AssetSkipExtensions = nil
+		for _, ext := range strings.Split(v, ",") {
+			if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+				if !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+				cfg.AssetSkipExtensions = append(cfg.AssetSkipExtensions, ext)
+			}
+		}
+	}
+	cfg.Quiet = l.envBool("QUIET", cfg.Quiet)
+	cfg.SkipPreflight = l.envBool("SKIP_PREFLIGHT", cfg.SkipPreflight)
+	cfg.ProgressInterval = l.envDuration("PROGRESS_INTERVAL", cfg.ProgressInterval)
+	cfg.MaxPages = l.envInt("MAX_PAGES", cfg.MaxPages, 0)
+	cfg.MaxDuration = l.envDuration("MAX_DURATION", cfg.MaxDuration)
+	if v := l.lookup("CRAWL_SCHEDULE"); v != "" {
+		if w, err := crawler.ParseCrawlWindow(v); err != nil {
+			l.problem("CRAWL_SCHEDULE: %v", err)
+		} else {
+			cfg.Schedule = w
+		}
+	}
+	cfg.MaxAge = l.envDuration("MAX_AGE", cfg.MaxAge)
+	cfg.RequestTimeout = time.Duration(l.envInt("REQUEST_TIMEOUT_SECONDS", int(cfg.RequestTimeout/time.Second), 1)) * time.Second
+	cfg.RequestDelay = time.Duration(l.envInt("DELAY_BETWEEN_REQUESTS_MS", 0, 0)) * time.Millisecond
+	cfg.MaxRequestsPerSecond = l.envFloat("MAX_REQUESTS_PER_SECOND", cfg.MaxRequestsPerSecond)
+	cfg.MaxBandwidth = l.envBandwidth("MAX_BANDWIDTH", cfg.MaxBandwidth)
+	if v := l.lookup("PROXY_URL"); v != "" {
+		cfg.ProxyURLs = append(cfg.ProxyURLs, v)
+	}
+	if v := l.lookup("PROXY_FILE"); v != "" {
+		cfg.ProxyURLs = append(cfg.ProxyURLs, l.readURLList("PROXY_FILE", v)...)
+	}
+	cfg.ProxyRotation = l.envString("PROXY_ROTATION", "round-robin")
+	if cfg.ProxyRotation != "round-robin" && cfg.ProxyRotation != "per-worker" {
+		l.problem("PROXY_ROTATION must be round-robin or per-worker, got %q", cfg.ProxyRotation)
+	}
+	if v := l.lookup("REQUEST_HEADERS"); v != "" {
+		cfg.Headers = make(map[string]string)
+		for _, h := range strings.Split(v, ";") {
+			if h = strings.TrimSpace(h); h == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(h, ":")
+			if name = strings.TrimSpace(name); !ok || name == "" {
+				l.problem("REQUEST_HEADERS entries must be Name: value, got %q", h)
+				continue
+			}
+			cfg.Headers[name] = strings.TrimSpace(value)
+		}
+	}
+	cfg.BasicAuthUser = l.envString("BASIC_AUTH_USER", "")
+	cfg.BasicAuthPassword = l.envString("BASIC_AUTH_PASSWORD", "")
+	cfg.BearerToken = l.envString("BEARER_TOKEN", "")
+	if cfg.BasicAuthUser != "" && cfg.BearerToken != "" {
+		l.problem("BASIC_AUTH_USER and BEARER_TOKEN can't both be set")
+	}
+	cfg.Cookies = l.envString("COOKIES", "")
+	cfg.CookiesFile = l.envString("COOKIES_FILE", "")
+	cfg.PersistCookies = l.envBool("PERSIST_COOKIES", cfg.PersistCookies)
+	cfg.Login.URL = l.envString("LOGIN_URL", "")
+	cfg.Login.Method = l.envString("LOGIN_METHOD", "POST")
+	if m := strings.ToUpper(cfg.Login.Method); m != "POST" && m != "GET" {
+		l.problem("LOGIN_METHOD must be POST or GET, got %q", cfg.Login.Method)
+	}
+	if v := l.lookup("LOGIN_FIELDS"); v != "" {
+		cfg.Login.Fields = make(map[string]string)
+		for _, f := range strings.Split(v, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(f), "=")
+			if !ok || name == "" {
+				l.problem("LOGIN_FIELDS entries must be name=value, got %q", f)
+				continue
+			}
+			cfg.Login.Fields[name] = l.expandEnvRefs(value)
+		}
+	}
+	cfg.Login.SuccessStatus = l.envInt("LOGIN_SUCCESS_STATUS", 0, 0)
+	cfg.Login.SuccessSelector = l.envString("LOGIN_SUCCESS_SELECTOR", "")
+	if cfg.Login.URL == "" && (cfg.Login.Fields != nil || cfg.Login.SuccessSelector != "") {
+		l.problem("LOGIN_URL is needed for the other LOGIN settings")
+	}
+	if v := l.lookup("USER_AGENTS"); v != "" {
+		for _, ua := range strings.Split(v, "|") {
+			if ua = strings.TrimSpace(ua); ua != "" {
+				cfg.UserAgents = append(cfg.UserAgents, ua)
+			}
+		}
+	}
+	if v := l.lookup("USER_AGENTS_FILE"); v != "" {
+		cfg.UserAgents = append(cfg.UserAgents, l.readURLList("USER_AGENTS_FILE", v)...)
+	}
+	cfg.UserAgentRotation = l.envString("USER_AGENT_ROTATION", "per-request")
+	if cfg.UserAgentRotation != "per-request" && cfg.UserAgentRotation != "per-worker" {
+		l.problem("USER_AGENT_ROTATION must be per-request or per-worker, got %q", cfg.UserAgentRotation)
+	}
+	cfg.ContactURL = l.envString("CONTACT_URL", "")
+	cfg.RobotsToken = l.envString("ROBOTS_TOKEN", "")
+	cfg.TLSCAFile = l.envString("TLS_CA_FILE", "")
+	cfg.TLSCertFile = l.envString("TLS_CERT_FILE", "")
+	cfg.TLSKeyFile = l.envString("TLS_KEY_FILE", "")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		l.problem("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	cfg.TLSInsecureSkipVerify = l.envBool("TLS_INSECURE_SKIP_VERIFY", cfg.TLSInsecureSkipVerify)
+	if v := l.lookup("HOST_MAPPING"); v != "" {
+		cfg.HostMapping = make(map[string]string)
+		for _, m := range strings.Split(v, ",") {
+			from, to, ok := strings.Cut(strings.TrimSpace(m), "=")
+			if !ok || from == "" || to == "" {
+				l.problem("HOST_MAPPING entries must be host=address, got %q", m)
+				continue
+			}
+			cfg.HostMapping[from] = to
+		}
+	}
+	// RESOLVE takes entries like curl's --resolve, host:port:address
+	if v := l.lookup("RESOLVE"); v != "" {
+		if cfg.HostMapping == nil {
+			cfg.HostMapping = make(map[string]string)
+		}
+		for _, r := range strings.Split(v, ",") {
+			parts := strings.SplitN(strings.TrimSpace(r), ":", 3)
+			if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+				l.problem("RESOLVE entries must be host:port:address, got %q", r)
+				continue
+			}
+			if _, err := strconv.Atoi(parts[1]); err != nil {
+				l.problem("RESOLVE entries must be host:port:address, got %q", r)
+				continue
+			}
+			cfg.HostMapping[parts[0]+":"+parts[1]] = net.JoinHostPort(strings.Trim(parts[2], "[]"), parts[1])
+		}
+	}
+	cfg.MaxIdleConns = l.envInt("MAX_IDLE_CONNS", cfg.MaxIdleConns, 0)
+	cfg.MaxIdleConnsPerHost = l.envInt("MAX_IDLE_CONNS_PER_HOST", cfg.MaxIdleConnsPerHost, 0)
+	cfg.MaxConnsPerHost = l.envInt("MAX_CONNS_PER_HOST", cfg.MaxConnsPerHost, 0)
+	cfg.IdleConnTimeout = l.envDuration("IDLE_CONN_TIMEOUT", cfg.IdleConnTimeout)
+	cfg.ForceAttemptHTTP2 = l.envBool("HTTP2", cfg.ForceAttemptHTTP2)
+	cfg.MaxAttempts = l.envInt("MAX_ATTEMPTS", cfg.MaxAttempts, 1)
+	cfg.MaxThrottles = l.envInt("MAX_THROTTLES", cfg.MaxThrottles, 1)
+	cfg.RetryBaseDelay = time.Duration(l.envInt("RETRY_BASE_DELAY_MS", int(cfg.RetryBaseDelay/time.Millisecond), 0)) * time.Millisecond
+	cfg.CircuitBreaker = l.envBool("CIRCUIT_BREAKER", cfg.CircuitBreaker)
+	cfg.CircuitBreakerThreshold = l.envInt("CIRCUIT_BREAKER_THRESHOLD", cfg.CircuitBreakerThreshold, 1)
+	cfg.CircuitBreakerProbes = l.envInt("CIRCUIT_BREAKER_PROBES", cfg.CircuitBreakerProbes, 1)
+	cfg.CircuitBreakerBackoff = l.envDuration("CIRCUIT_BREAKER_BACKOFF", cfg.CircuitBreakerBackoff)
+	s.ServePort = l.envInt("SERVE_PORT", 8080, 1)
+	hook := crawler.Webhook{URL: l.lookup("WEBHOOK_URL"), Secret: l.lookup("WEBHOOK_SECRET")}
+	for _, e := range strings.Split(l.lookup("WEBHOOK_EVENTS"), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			hook.Events = append(hook.Events, e)
+		}
+	}
+	if hook.URL != "" {
+		cfg.Webhooks = append(cfg.Webhooks, hook)
+	}
+	cfg.WebhookMilestone = l.envInt("WEBHOOK_MILESTONE", cfg.WebhookMilestone, 0)
+	cfg.WebhookErrorRate = l.envFloat("WEBHOOK_ERROR_RATE", cfg.WebhookErrorRate)
+	if cfg.WebhookErrorRate > 1 {
+		l.problem("WEBHOOK_ERROR_RATE must be a fraction between 0 and 1, got %g", cfg.WebhookErrorRate)
+	}
+	cfg.EventSink = l.envString("EVENT_SINK", cfg.EventSink)
+	cfg.EventTopic = l.envString("EVENT_TOPIC", cfg.EventTopic)
+	cfg.EventBodyLimit = l.envByteSize("EVENT_BODY_LIMIT", cfg.EventBodyLimit)
+	switch cfg.DryRunMethod = l.envString("DRY_RUN_METHOD", cfg.DryRunMethod); cfg.DryRunMethod {
+	case "get", "head":
+	default:
+		l.problem("DRY_RUN_METHOD must be get or head, got %q", cfg.DryRunMethod)
+	}
+	cfg.DryRunSampleDepth = l.envInt("DRY_RUN_SAMPLE_DEPTH", cfg.DryRunSampleDepth, 0)
+	s.ControlAddr = l.envString("CONTROL_ADDR", "")
+	s.ControlToken = l.envString("CONTROL_TOKEN", "")
+	if s.ControlAddr != "" && s.ControlToken == "" {
+		l.problem("CONTROL_ADDR needs CONTROL_TOKEN, the token requests to the control API must carry")
+	}
+	switch s.Output = l.envString("OUTPUT", ""); s.Output {
+	case "", "jsonl", "ndjson-full":
+	default:
+		l.problem("OUTPUT must be jsonl or ndjson-full, got %q", s.Output)
+	}
+	v := l.envString("LOG_LEVEL", "info")
+	if err := s.LogLevel.UnmarshalText([]byte(v)); err != nil {
+		l.problem("LOG_LEVEL must be debug, info, warn or error, got %q", v)
+	}
+	switch format := l.envString("LOG_FORMAT", "text"); format {
+	case "json":
+		s.JSONLogs = true
+	case "text":
+	default:
+		l.problem("LOG_FORMAT must be text or json, got %q", format)
+	}
+	s.Crawler = cfg
+	return s
+}
+
+// readURLList reads one URL, or other value, per line from path, or stdin
+// for "-", skipping blank lines and # comments
+func (l *loader) readURLList(name, path string) []string {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		l.problem("%s: %v", name, err)
+		return nil
+	}
+	var urls []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			urls = append(urls, line)
+		}
+	}
+	return urls
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvRefs replaces each ${NAME} in v with that environment variable,
+// so secrets can stay out of the config file. A bare $ is left alone.
+func (l *loader) expandEnvRefs(v string) string {
+	return envRef.ReplaceAllStringFunc(v, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		v := l.lookup(name)
+		if v == "" {
+			l.problem("%s is not set", name)
+		}
+		return v
+	})
+}
@@ -0,0 +1,183 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// minAdaptSamples is how many requests a window needs before the
+// controller acts on it
+const minAdaptSamples = 10
+
+// workerGate lets at most limit workers work at once. With
+// AdaptiveConcurrency MaxWorkers workers run and the controller moves the
+// limit; a nil gate lets every worker through.
+type workerGate struct {
+	mu            sync.Mutex
+	limit, active int
+	// peak is the most workers at work during the window
+	peak int
+	// wake is closed, and replaced, whenever a slot may have freed
+	wake chan struct{}
+
+	// The window of requests since the last evaluation
+	requests, errs int
+	latencies      []time.Duration
+	// baseline is the lowest median latency of a healthy window
+	baseline time.Duration
+	// cutback is set once the limit went down; from then on it only goes
+	// up one worker at a time, to settle just under what the host takes
+	cutback bool
+}
+
+func newWorkerGate(cfg Config) *workerGate {
+	if !cfg.AdaptiveConcurrency {
+		return nil
+	}
+	return &workerGate{limit: min(max(cfg.Workers, cfg.MinWorkers), cfg.MaxWorkers), wake: make(chan struct{})}
+}
+
+// acquire blocks until the worker may take on a job
+func (g *workerGate) acquire(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	for {
+		g.mu.Lock()
+		if g.active < g.limit {
+			g.active++
+			g.peak = max(g.peak, g.active)
+			g.mu.Unlock()
+			return nil
+		}
+		wake := g.wake
+		g.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (g *workerGate) release() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.active--
+	g.signal()
+	g.mu.Unlock()
+}
+
+// signal wakes the waiting workers; g.mu must be held
+func (g *workerGate) signal() {
+	close(g.wake)
+	g.wake = make(chan struct{})
+}
+
+// observe records one request: how long it took to answer, and whether the
+// host pushed back on it with a 429, a 503 or a timeout
+func (g *workerGate) observe(latency time.Duration, pushback bool) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.requests++
+	if pushback {
+		g.errs++
+	} else {
+		g.latencies = append(g.latencies, latency)
+	}
+	g.mu.Unlock()
+}
+
+// isPushback reports whether a request's outcome is the host asking for
+// less: a throttling status or a timeout
+func isPushback(code int, err error) bool {
+	if err == nil {
+		return isThrottleStatus(code)
+	}
+	return isTimeout(err)
+}
+
+// adjust evaluates the window and starts a new one. It returns the new
+// limit and why it changed, or an empty reason when it stays.
+func (g *workerGate) adjust(cfg Config) (from, to int, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	requests, errs, peak := g.requests, g.errs, g.peak
+	latencies := g.latencies
+	g.requests, g.errs, g.latencies, g.peak = 0, 0, nil, g.active
+	from, to = g.limit, g.limit
+	if requests < minAdaptSamples {
+		return from, to, ""
+	}
+	var median time.Duration
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		median = latencies[len(latencies)/2]
+	}
+	errRate := float64(errs) / float64(requests)
+	switch {
+	case errRate > cfg.MaxErrorRate:
+		to = max(g.limit/2, cfg.MinWorkers)
+		reason = fmt.Sprintf("%.0f%% of %d requests throttled or timed out", errRate*100, requests)
+	case g.baseline > 0 && median > 2*g.baseline:
+		to = max(g.limit*3/4, cfg.MinWorkers)
+		reason = fmt.Sprintf("median latency %s, over twice the %s baseline",
+			median.Round(time.Millisecond), g.baseline.Round(time.Millisecond))
+	case peak >= g.limit:
+		// Only worth it when the workers were all busy
+		step := max(g.limit/4, 1)
+		if g.cutback {
+			step = 1
+		}
+		to = min(g.limit+step, cfg.MaxWorkers)
+		reason = fmt.Sprintf("all busy with %.0f%% of %d requests throttled or timed out, median latency %s",
+			errRate*100, requests, median.Round(time.Millisecond))
+	}
+	if errRate <= cfg.MaxErrorRate && median > 0 && (g.baseline == 0 || median < g.baseline) {
+		g.baseline = median
+	}
+	if to == from {
+		return from, to, ""
+	}
+	g.cutback = g.cutback || to < from
+	g.limit = to
+	g.signal()
+	return from, to, reason
+}
+
+// workerLimit is how many workers may work at once right now
+func (c *Crawler) workerLimit() int {
+	if c.gate == nil {
+		return c.cfg.Workers
+	}
+	c.gate.mu.Lock()
+	defer c.gate.mu.Unlock()
+	return c.gate.limit
+}
+
+// adaptConcurrency moves the worker limit every AdaptInterval until ctx
+// is done: down by half when the error rate tops MaxErrorRate, by a
+// quarter when the median latency doubles from the best seen, and up
+// otherwise, as long as the workers are kept busy: by a quarter until the
+// first cut, then by one
+func (c *Crawler) adaptConcurrency(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.AdaptInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if from, to, reason := c.gate.adjust(c.cfg); reason != "" {
+				c.log().Info("concurrency", "workers", to, "was", from, "reason", reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAdaptiveConcurrency runs MaxWorkers workers through the gate against
+// a host that answers 429 to any request past its capacity: the limit
+// comes down to about the capacity, and goes back up once the host can
+// take more
+func TestAdaptiveConcurrency(t *testing.T) {
+	const (
+		window   = 100 * time.Millisecond
+		capacity = 4
+	)
+	var inFlight, limit atomic.Int32
+	limit.Store(capacity)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer inFlight.Add(-1)
+		if inFlight.Add(1) > limit.Load() {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig(srv.URL + "/")
+	cfg.AdaptiveConcurrency = true
+	cfg.Workers, cfg.MinWorkers, cfg.MaxWorkers = 16, 1, 32
+	g := newWorkerGate(cfg)
+	client := srv.Client()
+	client.Transport.(*http.Transport).MaxIdleConnsPerHost = cfg.MaxWorkers
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for range cfg.MaxWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && g.acquire(ctx) == nil {
+				start := time.Now()
+				code := 0
+				resp, err := client.Get(srv.URL)
+				if err == nil {
+					code = resp.StatusCode
+					resp.Body.Close()
+				}
+				g.observe(time.Since(start), isPushback(code, err))
+				g.release()
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// windows evaluates n windows, returning the limit after each
+	windows := func(n int) []int {
+		var limits []int
+		for range n {
+			time.Sleep(window)
+			_, to, _ := g.adjust(cfg)
+			limits = append(limits, to)
+		}
+		return limits
+	}
+
+	limits := windows(20)
+	if limits[0] >= cfg.Workers {
+		t.Errorf("limit went from %d to %d with most requests throttled", cfg.Workers, limits[0])
+	}
+	settled := limits[5:]
+	atCapacity := 0
+	for _, l := range settled {
+		if l > capacity+1 {
+			t.Errorf("limit %d after settling, want no more than one over the capacity of %d: %v", l, capacity, limits)
+			break
+		}
+		if l <= capacity {
+			atCapacity++
+		}
+	}
+	if atCapacity < len(settled)/2 {
+		t.Errorf("limit over the capacity of %d in most windows: %v", capacity, limits)
+	}
+
+	// The host can take all the workers now
+	limit.Store(int32(cfg.MaxWorkers))
+	limits = windows(20)
+	if last := limits[len(limits)-1]; last < 3*capacity {
+		t.Errorf("limit only back up to %d once the host recovered: %v", last, limits)
+	}
+	for i := 1; i < len(limits); i++ {
+		if limits[i] < limits[i-1] {
+			t.Errorf("limit went down with nothing throttled: %v", limits)
+			break
+		}
+	}
+}
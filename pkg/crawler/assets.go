@@ -0,0 +1,204 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var errAssetTooLarge = errors.New("asset exceeds ASSET_MAX_SIZE")
+
+// wantAsset reports whether an asset URL from the inventory should be
+// downloaded: it has to be on an allowed host and not have a skipped
+// extension.
+func (c *Crawler) wantAsset(raw string) bool {
+	if !c.cfg.DownloadAssets {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if !c.hostAllowed(u.Host) {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	for _, skip := range c.cfg.AssetSkipExtensions {
+		if ext != "" && ext == skip {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingAssets returns the inventory entries still to download. An asset
+// is done once the frontier has it as scraped or failed, like a page.
+func (c *Crawler) pendingAssets() []FrontierEntry {
+	seen := make(map[string]bool)
+	for _, f := range c.Frontier.Failed() {
+		seen[f.URL] = true
+	}
+	// An image that is an asset too is fetched once, as an image
+	assets := c.pendingImages(seen)
+	for _, line := range c.assetURLsFile.snapshot() {
+		u, referrer, _ := strings.Cut(line, "\t")
+		if seen[u] || c.Frontier.IsScraped(u) || !c.wantAsset(u) {
+			continue
+		}
+		seen[u] = true
+		assets = append(assets, FrontierEntry{URL: u, Referrer: referrer, Asset: true})
+	}
+	return assets
+}
+
+// assetPath lays an asset out under assets/ the way it is on the server,
+// so relative references between assets keep working, in a folder named
+// after the host unless it is the BaseURL one. A query string gets a short
+// hash before the extension to keep versions apart.
+func (c *Crawler) assetPath(u *url.URL) string {
+	return c.mirrorPath(assetsSubfolder, u)
+}
+
+// mirrorPath is assetPath under another folder. The path is run through
+// sanitizeFilename, so it may differ from the URL's where that isn't a
+// valid file name.
+func (c *Crawler) mirrorPath(folder string, u *url.URL) string {
+	p := path.Clean("/" + u.EscapedPath())
+	if strings.HasSuffix(u.Path, "/") || p == "/" {
+		p = path.Join(p, "index")
+	}
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		ext := path.Ext(p)
+		p = strings.TrimSuffix(p, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+	}
+	return path.Join(folder, c.hostFolder(u), sanitizeFilename(p))
+}
+
+// scrapeAsset downloads one asset into the mirror layout and records it in
+// the manifest. Stylesheets are scanned for url() and @import references,
+// which join the asset inventory.
+func (c *Crawler) scrapeAsset(ctx context.Context, job Job) (err error) {
+	log := c.logFrom(ctx)
+	log.Debug("fetching asset")
+	started := time.Now()
+
+	u, err := url.Parse(job.URL)
+	if err != nil {
+		return err
+	}
+	resp, err := c.fetchWithRetry(ctx, job.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if c.cfg.AssetMaxSize > 0 && resp.ContentLength > c.cfg.AssetMaxSize {
+		return errAssetTooLarge
+	}
+
+	wire, captured, err := c.archiveCapture(resp.Body)
+	if err != nil {
+		return err
+	}
+	if captured != nil {
+		defer captured.discard()
+	}
+	decoded, err := decodeBody(wire, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewReader(decoded)
+	contentType := responseContentType(resp, buffered)
+
+	fileName := c.pageManifest.claimFile(c.assetPath(u), job.URL)
+	var body io.Reader = buffered
+	if c.cfg.AssetMaxSize > 0 {
+		body = &limitedBody{r: body, remaining: c.cfg.AssetMaxSize, err: errAssetTooLarge}
+	}
+	hashed := newHashingReader(body)
+	size, err := c.Storage.SavePage(fileName, PageMeta{URL: job.URL, ContentType: contentType, Header: resp.Header}, &ctxReader{ctx: ctx, r: hashed})
+	if err != nil {
+		return err
+	}
+	c.bodiesSaved.Add(1)
+	c.bytesSaved.Add(size)
+	c.archive(resp, captured, wire, started)
+	entry := manifestEntry{
+		URL:          job.URL,
+		File:         fileName,
+		Size:         size,
+		ContentType:  contentType,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CheckedAt:    started.UTC(),
+		SHA256:       hashed.sum(),
+	}
+	c.noteStored(&entry)
+	if err := c.pageManifest.record(entry); err != nil {
+		return err
+	}
+	_ = c.pageRecords.write(pageRecord{
+		URL:           job.URL,
+		Status:        resp.StatusCode,
+		ContentType:   contentType,
+		ContentLength: size,
+		FetchedAt:     started.UTC(),
+		DurationMS:    time.Since(started).Milliseconds(),
+		UserAgent:     resp.Request.Header.Get("User-Agent"),
+		File:          fileName,
+		Order:         job.Order,
+		Referrer:      job.Referrer,
+	})
+	log.Log(ctx, c.pageLevel(), "asset saved", "status", resp.StatusCode, "duration_ms", time.Since(started).Milliseconds(),
+		"bytes", size, "file", fileName)
+
+	if contentType == "text/css" {
+		f, err := c.Storage.LoadPage(fileName)
+		if err != nil {
+			return err
+		}
+		css, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		c.recordLinksFrom(c.assetURLsFile, cssReferences(resp.Request.URL, string(css)), job.URL)
+	}
+	return nil
+}
+
+var (
+	cssURLPattern    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+	cssImportPattern = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// cssReferences returns the absolute http(s) URLs a stylesheet refers to
+// through url() and @import, resolved against base
+func cssReferences(base *url.URL, css string) []string {
+	var refs []string
+	for _, pattern := range []*regexp.Regexp{cssURLPattern, cssImportPattern} {
+		for _, m := range pattern.FindAllStringSubmatch(css, -1) {
+			raw := strings.TrimSpace(strings.Join(m[1:], ""))
+			if raw == "" || strings.HasPrefix(raw, "data:") {
+				continue
+			}
+			ref, err := url.Parse(raw)
+			if err != nil {
+				continue
+			}
+			if resolved := base.ResolveReference(ref); resolved.Scheme == "http" || resolved.Scheme == "https" {
+				resolved.Fragment = ""
+				refs = append(refs, resolved.String())
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so a crash leaves either the old file or the complete new
+// one, never a truncated mix.
+func writeFileAtomic(path string, data []byte) error {
+	_, err := writeReaderAtomic(path, bytes.NewReader(data))
+	return err
+}
+
+// writeReaderAtomic is writeFileAtomic for a stream, returning the number
+// of bytes written. If reading r fails, path is left untouched.
+func writeReaderAtomic(path string, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+// sidecar tees a stream into a temporary file that is only stored if
+// commit is called, for extra copies of a body that must not outlive a
+// failed save.
+type sidecar struct {
+	f *os.File
+}
+
+func newSidecar() (*sidecar, error) {
+	f, err := os.CreateTemp("", ".sidecar-*")
+	if err != nil {
+		return nil, err
+	}
+	return &sidecar{f: f}, nil
+}
+
+func (s *sidecar) tee(r io.Reader) io.Reader {
+	return io.TeeReader(r, s.f)
+}
+
+func (s *sidecar) commit(st Storage, name string, meta PageMeta) error {
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := st.SavePage(name, meta, s.f)
+	return err
+}
+
+// discard removes the temporary file
+func (s *sidecar) discard() {
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// bandwidthChunk is the most a single body read takes from the bandwidth
+// limiter, so a large download holds the other workers up for one chunk
+// at a time, not for the whole file
+const bandwidthChunk = 16 << 10
+
+// bandwidthLimiter is one token bucket, a token per byte, that every
+// response body read draws from. It also counts the bytes for the
+// throughput in the progress lines.
+type bandwidthLimiter struct {
+	// lim is nil without MaxBandwidth
+	lim  *rate.Limiter
+	read atomic.Int64
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	b := &bandwidthLimiter{}
+	if bytesPerSecond > 0 {
+		b.lim = rate.NewLimiter(rate.Limit(bytesPerSecond), int(min(bytesPerSecond, bandwidthChunk)))
+	}
+	return b
+}
+
+// wrap returns body reading through the limiter until ctx is done
+func (b *bandwidthLimiter) wrap(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	return &limitedReader{ctx: ctx, r: body, b: b}
+}
+
+// limitedReader pays for what it read after each read. Waits are granted
+// in the order they are asked for, so readers take turns chunk by chunk.
+type limitedReader struct {
+	ctx context.Context
+	r   io.ReadCloser
+	b   *bandwidthLimiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.b.lim != nil && len(p) > l.b.lim.Burst() {
+		p = p[:l.b.lim.Burst()]
+	}
+	n, err := l.r.Read(p)
+	l.b.read.Add(int64(n))
+	if l.b.lim != nil && n > 0 {
+		if werr := l.b.lim.WaitN(l.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (l *limitedReader) Close() error {
+	return l.r.Close()
+}
@@ -0,0 +1,128 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// closeRecorder is a body that notes being closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// testBody is size bytes to read
+func testBody(size int) *closeRecorder {
+	return &closeRecorder{Reader: bytes.NewReader(bytes.Repeat([]byte("x"), size))}
+}
+
+func TestLimitedReader(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		b := newBandwidthLimiter(0)
+		src := testBody(100 << 10)
+		r := b.wrap(context.Background(), src)
+		start := time.Now()
+		n, err := io.Copy(io.Discard, r)
+		if err != nil || n != 100<<10 {
+			t.Fatalf("copied %d, %v", n, err)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("took %s without a limit", elapsed)
+		}
+		if got := b.read.Load(); got != n {
+			t.Errorf("counted %d bytes, read %d", got, n)
+		}
+		r.Close()
+		if !src.closed {
+			t.Error("Close didn't close the body")
+		}
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		const rate, size = 100 << 10, 60 << 10
+		b := newBandwidthLimiter(rate)
+		r := b.wrap(context.Background(), testBody(size))
+		p := make([]byte, 64<<10)
+		start := time.Now()
+		total := 0
+		for {
+			n, err := r.Read(p)
+			if n > bandwidthChunk {
+				t.Errorf("read %d bytes at once, over the %d chunk", n, bandwidthChunk)
+			}
+			total += n
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		elapsed := time.Since(start)
+		// The first chunk is free, the rest paid for at the rate
+		if want := time.Duration(float64(size-bandwidthChunk) / rate * float64(time.Second)); elapsed < want*9/10 {
+			t.Errorf("read %d bytes in %s, want at least %s", total, elapsed, want)
+		}
+		if total != size || b.read.Load() != size {
+			t.Errorf("read %d and counted %d bytes, want %d", total, b.read.Load(), size)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		b := newBandwidthLimiter(1 << 10)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r := b.wrap(ctx, testBody(10<<10))
+		if _, err := io.Copy(io.Discard, r); !errors.Is(err, context.Canceled) {
+			t.Errorf("reading after cancelling = %v", err)
+		}
+	})
+}
+
+// TestBandwidthSharedCap has several bodies read at once through one
+// limiter: together they take as long as the cap makes their total take,
+// and every byte is read and counted
+func TestBandwidthSharedCap(t *testing.T) {
+	const rate, readers, size = 200 << 10, 4, 30 << 10
+	b := newBandwidthLimiter(rate)
+	var wg sync.WaitGroup
+	got := make([]int64, readers)
+	errs := make([]error, readers)
+	start := time.Now()
+	for i := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i], errs[i] = io.Copy(io.Discard, b.wrap(context.Background(), testBody(size)))
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	for i := range readers {
+		if errs[i] != nil || got[i] != size {
+			t.Errorf("reader %d read %d, %v", i, got[i], errs[i])
+		}
+	}
+	total := int64(readers * size)
+	if b.read.Load() != total {
+		t.Errorf("counted %d bytes, want %d", b.read.Load(), total)
+	}
+	want := time.Duration(float64(total-bandwidthChunk) / rate * float64(time.Second))
+	if elapsed < want*9/10 {
+		t.Errorf("%d readers took %s for %d bytes, want at least %s at %d bytes/s", readers, elapsed, total, want, rate)
+	}
+	if elapsed > 3*want {
+		t.Errorf("%d readers took %s for %d bytes, want about %s", readers, elapsed, total, want)
+	}
+}
@@ -0,0 +1,168 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxBreakerBackoff caps the wait between two probes of a site that is down
+const maxBreakerBackoff = 10 * time.Minute
+
+// ErrOriginDown is returned by Run when the site stayed down through every
+// probe of the circuit breaker. What wasn't crawled is still pending.
+var ErrOriginDown = errors.New("origin down")
+
+// circuitBreaker watches for the site going down: failures that say nothing
+// is answering, as opposed to a page that is missing, in a row to one host
+// or to all of them together. Once CircuitBreakerThreshold of them pile up
+// it opens, and the crawl holds off until a probe gets an answer. A nil
+// breaker never opens.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	open      bool
+	// The URLs of the current run of failures, overall and by host
+	streak     []string
+	hostStreak map[string][]string
+}
+
+func newCircuitBreaker(cfg Config) *circuitBreaker {
+	if !cfg.CircuitBreaker {
+		return nil
+	}
+	return &circuitBreaker{threshold: cfg.CircuitBreakerThreshold, hostStreak: make(map[string][]string)}
+}
+
+// isOriginFailure reports whether err means the host didn't answer, or
+// answered that it is broken
+func isOriginFailure(err error) bool {
+	switch classify(err) {
+	case failDNS, failConnect, failConnectTimeout, failTimeout, failTLS, failHTTP5xx:
+		return true
+	}
+	return false
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// observe records how a fetch of url went. It returns whether the breaker
+// is open and err is an origin failure, so the URL should stay pending,
+// and, when this failure is the one that opened it, the URLs of the run of
+// failures that did, with why.
+func (b *circuitBreaker) observe(url string, err error) (held bool, streak []string, reason string) {
+	if b == nil {
+		return false, nil, ""
+	}
+	host := hostOf(url)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || !isOriginFailure(err) {
+		b.streak = nil
+		delete(b.hostStreak, host)
+		return false, nil, ""
+	}
+	if b.open {
+		return true, nil, ""
+	}
+	b.streak = append(b.streak, url)
+	b.hostStreak[host] = append(b.hostStreak[host], url)
+	switch {
+	case len(b.hostStreak[host]) >= b.threshold:
+		streak, reason = b.hostStreak[host], fmt.Sprintf("%d failures in a row from %s", len(b.hostStreak[host]), host)
+	case len(b.streak) >= b.threshold:
+		streak, reason = b.streak, fmt.Sprintf("%d failures in a row", len(b.streak))
+	default:
+		return false, nil, ""
+	}
+	b.open = true
+	return true, streak, reason
+}
+
+// close lets the crawl go on with a clean slate
+func (b *circuitBreaker) close() {
+	b.mu.Lock()
+	b.open = false
+	b.streak = nil
+	clear(b.hostStreak)
+	b.mu.Unlock()
+}
+
+// breakerObserve records the outcome of a fetch of url with the breaker,
+// returning whether the URL should stay pending. When the breaker opens,
+// the URLs of the failures that opened it are made pending again too,
+// since they most likely only failed because the site was down.
+func (c *Crawler) breakerObserve(url string, err error) bool {
+	held, streak, reason := c.breaker.observe(url, err)
+	if reason != "" {
+		c.log().Warn("circuit breaker open, pausing the crawl", "reason", reason, "error", err)
+		requeue := make(map[string]bool, len(streak))
+		for _, u := range streak {
+			requeue[u] = true
+		}
+		if err := c.Frontier.ForgetFailed(requeue); err != nil {
+			c.log().Error("re-queueing failed URLs", "error", err)
+		}
+	}
+	return held
+}
+
+// probeOrigin fetches BaseURL with a backoff that doubles from
+// CircuitBreakerBackoff, until it gets an answer that isn't a 5xx, which
+// closes the breaker, or CircuitBreakerProbes probes have failed, which
+// returns ErrOriginDown
+func (c *Crawler) probeOrigin(ctx context.Context) error {
+	wait := c.cfg.CircuitBreakerBackoff
+	for probe := 1; probe <= c.cfg.CircuitBreakerProbes; probe++ {
+		c.log().Info("probing origin", "url", c.cfg.BaseURL, "in", wait, "probe", probe,
+			"max_probes", c.cfg.CircuitBreakerProbes)
+		if err := sleepCtx(ctx, wait); err != nil {
+			return err
+		}
+		// Any answer but a 5xx, even a 404 or a redirect away, means the
+		// site is up
+		err := c.probe(ctx)
+		if err == nil || !isOriginFailure(err) {
+			c.log().Info("origin answering again, resuming the crawl", "probes", probe)
+			c.breaker.close()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.log().Warn("origin still down", "probe", probe, "error", err)
+		wait = min(wait*2, maxBreakerBackoff)
+	}
+	c.log().Error("origin down, giving up; the pending URLs are kept for the next run",
+		"probes", c.cfg.CircuitBreakerProbes)
+	return fmt.Errorf("%w: %s failed %d probes", ErrOriginDown, c.cfg.BaseURL, c.cfg.CircuitBreakerProbes)
+}
+
+// probe makes one request for BaseURL, without retries
+func (c *Crawler) probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.cfg.BaseURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", c.userAgent(ctx))
+	c.setRequestHeaders(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return &statusError{code: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerObserve(t *testing.T) {
+	down := &statusError{code: http.StatusBadGateway}
+	missing := &statusError{code: http.StatusNotFound}
+	type outcome struct {
+		url string
+		err error
+	}
+	tests := []struct {
+		name     string
+		outcomes []outcome
+		wantOpen bool
+	}{
+		{
+			name:     "5xx in a row from one host",
+			outcomes: []outcome{{"https://a.example/1", down}, {"https://a.example/2", down}, {"https://a.example/3", down}},
+			wantOpen: true,
+		},
+		{
+			name:     "a 404 in between is an answer",
+			outcomes: []outcome{{"https://a.example/1", down}, {"https://a.example/2", down}, {"https://a.example/3", missing}, {"https://a.example/4", down}},
+		},
+		{
+			name:     "a success resets the streak",
+			outcomes: []outcome{{"https://a.example/1", down}, {"https://a.example/2", nil}, {"https://a.example/3", down}, {"https://a.example/4", down}},
+		},
+		{
+			name:     "failures across hosts add up",
+			outcomes: []outcome{{"https://a.example/1", down}, {"https://b.example/1", down}, {"https://c.example/1", down}},
+			wantOpen: true,
+		},
+	}
+	for _, tt := range tests {
+		b := newCircuitBreaker(Config{CircuitBreaker: true, CircuitBreakerThreshold: 3})
+		for _, o := range tt.outcomes {
+			b.observe(o.url, o.err)
+		}
+		if b.isOpen() != tt.wantOpen {
+			t.Errorf("%s: open = %v, want %v", tt.name, b.isOpen(), tt.wantOpen)
+		}
+	}
+	if newCircuitBreaker(Config{}).isOpen() {
+		t.Error("a disabled breaker is open")
+	}
+}
+
+// TestAlwaysUnavailableOpensBreaker crawls a host that answers every
+// request with a 503: the throttled URL has to count towards the breaker,
+// which stops the crawl once the origin stays down through its probes
+func TestAlwaysUnavailableOpensBreaker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.CircuitBreakerThreshold = 2
+		cfg.CircuitBreakerProbes = 1
+		cfg.CircuitBreakerBackoff = 10 * time.Millisecond
+		cfg.RetryBaseDelay = time.Millisecond
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := c.Run(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("Run never gave up on a host that is always unavailable")
+	}
+	if !errors.Is(err, ErrOriginDown) {
+		t.Errorf("Run = %v, want ErrOriginDown", err)
+	}
+}
@@ -0,0 +1,199 @@
+package crawler
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// brokenLink is one row of the broken links report. Status is the HTTP
+// status, or zero when the fetch failed without one and Error says why.
+type brokenLink struct {
+	URL       string
+	Status    int
+	Error     string
+	Referrers []string
+	Time      string
+	External  bool
+}
+
+func appendUnique(list []string, s string) []string {
+	if s == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// brokenProblem reads a failed file reason and reports whether it means
+// the link is dead: an error status, a DNS failure or a timeout. Other
+// failures, like an oversized body, say nothing about the link itself.
+func brokenProblem(reason string) (status int, broken bool) {
+	if code, ok := strings.CutPrefix(reason, "bad status code: "); ok {
+		n, _ := strconv.Atoi(code)
+		return n, n >= 400
+	}
+	for _, s := range []string{"no such host", "server misbehaving", "Client.Timeout exceeded", "i/o timeout", "deadline exceeded"} {
+		if strings.Contains(reason, s) {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// collectBrokenLinks builds the report rows from the frontier and the
+// referrers file lines, sorted by URL. It only looks at its arguments, so it
+// works on the files of a finished crawl as well as a running one.
+func collectBrokenLinks(found []FrontierEntry, referrerLines []string, failed []FailedURL) []brokenLink {
+	referrers := make(map[string][]string)
+	for _, e := range found {
+		referrers[e.URL] = appendUnique(referrers[e.URL], e.Referrer)
+	}
+	for _, line := range referrerLines {
+		url, referrer, _ := strings.Cut(line, "\t")
+		referrers[url] = appendUnique(referrers[url], referrer)
+	}
+
+	var links []brokenLink
+	for _, f := range failed {
+		status, broken := brokenProblem(f.Reason)
+		if !broken {
+			continue
+		}
+		link := brokenLink{URL: f.URL, Status: status}
+		if status == 0 {
+			link.Error = f.Reason
+		}
+		referrers[link.URL] = appendUnique(referrers[link.URL], f.Referrer)
+		if !f.At.IsZero() {
+			link.Time = f.At.UTC().Format(time.RFC3339)
+		}
+		link.Referrers = referrers[link.URL]
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].URL < links[j].URL })
+	return links
+}
+
+// writeBrokenLinksCSV writes links as CSV with a header row. Referrers are
+// space-separated within their column.
+func writeBrokenLinksCSV(w io.Writer, links []brokenLink) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"url", "status", "error", "referrers", "time", "external"})
+	for _, l := range links {
+		status := ""
+		if l.Status != 0 {
+			status = strconv.Itoa(l.Status)
+		}
+		cw.Write([]string{l.URL, status, l.Error, strings.Join(l.Referrers, " "), l.Time, strconv.FormatBool(l.External)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeBrokenLinksReport writes the broken links report from the state
+// files plus any dead external links, returning how many rows it has.
+func (c *Crawler) writeBrokenLinksReport(ctx context.Context, path string) (int, error) {
+	links := collectBrokenLinks(c.Frontier.Found(), append(c.referrersFile.snapshot(), c.imageReferrerLines()...), c.Frontier.Failed())
+	if c.cfg.CheckExternal {
+		links = append(links, c.checkExternalLinks(ctx)...)
+	}
+
+	var out strings.Builder
+	if err := writeBrokenLinksCSV(&out, links); err != nil {
+		return 0, err
+	}
+	if err := writeFileAtomic(path, []byte(out.String())); err != nil {
+		return 0, err
+	}
+	return len(links), nil
+}
+
+// checkExternalLinks HEAD-checks every link in the external URLs file with
+// Workers requests at a time, returning the dead ones
+func (c *Crawler) checkExternalLinks(ctx context.Context) []brokenLink {
+	referrers := make(map[string][]string)
+	var urls []string
+	for _, line := range c.externalFile.snapshot() {
+		u, referrer, _ := strings.Cut(line, "\t")
+		if _, ok := referrers[u]; !ok {
+			urls = append(urls, u)
+		}
+		referrers[u] = appendUnique(referrers[u], referrer)
+	}
+	sort.Strings(urls)
+	if len(urls) == 0 {
+		return nil
+	}
+
+	var mu sync.Mutex
+	var dead []brokenLink
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < c.cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				status, err := c.checkLink(ctx, u)
+				if (err == nil && status < 400) || ctx.Err() != nil {
+					continue
+				}
+				link := brokenLink{URL: u, Status: status, Time: time.Now().UTC().Format(time.RFC3339), External: true}
+				if err != nil {
+					link.Error = strings.Join(strings.Fields(err.Error()), " ")
+				}
+				link.Referrers = referrers[u]
+				mu.Lock()
+				dead = append(dead, link)
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for _, u := range urls {
+		select {
+		case jobs <- u:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	sort.Slice(dead, func(i, j int) bool { return dead[i].URL < dead[j].URL })
+	return dead
+}
+
+// checkLink returns the status of a HEAD request for u, falling back to GET
+// for servers that don't allow HEAD
+func (c *Crawler) checkLink(ctx context.Context, u string) (int, error) {
+	status, err := c.requestStatus(ctx, "HEAD", u)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		return c.requestStatus(ctx, "GET", u)
+	}
+	return status, err
+}
+
+func (c *Crawler) requestStatus(ctx context.Context, method, u string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", c.userAgent(ctx))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
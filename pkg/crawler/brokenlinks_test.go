@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBrokenProblem(t *testing.T) {
+	tests := []struct {
+		reason string
+		status int
+		broken bool
+	}{
+		{"bad status code: 404", 404, true},
+		{"bad status code: 503", 503, true},
+		{"bad status code: 304", 304, false},
+		{`Get "https://nowhere.example/": dial tcp: lookup nowhere.example: no such host`, 0, true},
+		{`Get "https://example.com/": context deadline exceeded (Client.Timeout exceeded while awaiting headers)`, 0, true},
+		{"read tcp 10.0.0.1:443: i/o timeout", 0, true},
+		{"response body exceeds MAX_BODY_SIZE", 0, false},
+	}
+	for _, tt := range tests {
+		status, broken := brokenProblem(tt.reason)
+		if status != tt.status || broken != tt.broken {
+			t.Errorf("brokenProblem(%q) = %d, %v, want %d, %v", tt.reason, status, broken, tt.status, tt.broken)
+		}
+	}
+}
+
+func TestCollectBrokenLinks(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	found := []FrontierEntry{
+		{URL: "https://example.com/gone", Referrer: "https://example.com/"},
+		{URL: "https://example.com/slow", Referrer: "https://example.com/a"},
+		{URL: "https://example.com/big", Referrer: "https://example.com/"},
+	}
+	referrerLines := []string{
+		"https://example.com/gone\thttps://example.com/b",
+		"https://example.com/gone\thttps://example.com/",
+	}
+	failed := []FailedURL{
+		{URL: "https://example.com/slow", Reason: "i/o timeout", At: at},
+		{URL: "https://example.com/gone", Reason: "bad status code: 404", At: at},
+		{URL: "https://example.com/big", Reason: "response body exceeds MAX_BODY_SIZE", At: at},
+		{URL: "https://example.com/img.png", Reason: "bad status code: 500", Referrer: "https://example.com/c"},
+	}
+	want := []brokenLink{
+		{URL: "https://example.com/gone", Status: 404, Referrers: []string{"https://example.com/", "https://example.com/b"}, Time: "2024-05-01T12:00:00Z"},
+		{URL: "https://example.com/img.png", Status: 500, Referrers: []string{"https://example.com/c"}},
+		{URL: "https://example.com/slow", Error: "i/o timeout", Referrers: []string{"https://example.com/a"}, Time: "2024-05-01T12:00:00Z"},
+	}
+	if got := collectBrokenLinks(found, referrerLines, failed); !reflect.DeepEqual(got, want) {
+		t.Errorf("collectBrokenLinks =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestWriteBrokenLinksCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		links []brokenLink
+		want  string
+	}{
+		{"none", nil, "url,status,error,referrers,time,external\n"},
+		{
+			"a status and an error",
+			[]brokenLink{
+				{URL: "https://example.com/gone", Status: 404, Referrers: []string{"https://example.com/", "https://example.com/b"}, Time: "2024-05-01T12:00:00Z"},
+				{URL: "https://other.example/", Error: "no such host, really", External: true},
+			},
+			"url,status,error,referrers,time,external\n" +
+				"https://example.com/gone,404,,https://example.com/ https://example.com/b,2024-05-01T12:00:00Z,false\n" +
+				"https://other.example/,,\"no such host, really\",,,true\n",
+		},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		if err := writeBrokenLinksCSV(&sb, tt.links); err != nil {
+			t.Fatal(err)
+		}
+		if sb.String() != tt.want {
+			t.Errorf("%s: wrote\n%s\nwant\n%s", tt.name, sb.String(), tt.want)
+		}
+	}
+}
+
+// TestBrokenLinksReport crawls a site with a dead page and a dead external
+// link: the report has the page, and with CheckExternal the external link
+func TestBrokenLinksReport(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dead" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer external.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.Error(w, "gone", http.StatusGone)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><a href="/gone">gone</a><a href="` + external.URL + `/dead">dead</a><a href="` + external.URL + `/alive">alive</a></html>`))
+	}))
+	defer srv.Close()
+	// The external server is on another port; localhost makes it another host
+	external.URL = strings.Replace(external.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name          string
+		checkExternal bool
+		want          [][]string
+	}{
+		{"internal only", false, [][]string{{srv.URL + "/gone", "410", "false"}}},
+		{"external checked", true, [][]string{{srv.URL + "/gone", "410", "false"}, {external.URL + "/dead", "404", "true"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.MaxAttempts = 1
+				cfg.CheckExternal = tt.checkExternal
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.Run(ctx); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			f, err := os.Open(c.brokenLinksFileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rows, err := csv.NewReader(f).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]string
+			for _, row := range rows[1:] {
+				got = append(got, []string{row[0], row[1], row[5]})
+				if row[3] != srv.URL+"/" {
+					t.Errorf("%s referred to by %q", row[0], row[3])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("report %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
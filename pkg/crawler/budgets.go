@@ -0,0 +1,190 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// CrawlBudget caps how many pages whose URL matches Pattern the crawl
+// fetches, across runs
+type CrawlBudget struct {
+	Pattern  *regexp.Regexp
+	MaxPages int
+}
+
+// CompileCrawlBudgets parses a comma-separated list of pattern=pages
+// budgets for the setting called name, reporting every bad budget
+func CompileCrawlBudgets(name, list string) ([]CrawlBudget, error) {
+	patterns, pages, err := compileNumberedPatterns(name, "pages", list)
+	var errs []error
+	if err != nil {
+		errs = err.(interface{ Unwrap() []error }).Unwrap()
+	}
+	var budgets []CrawlBudget
+	for i := range patterns {
+		if pages[i] < 0 {
+			errs = append(errs, fmt.Errorf("invalid pages %d for %s in %s: can't be negative", pages[i], patterns[i], name))
+			continue
+		}
+		budgets = append(budgets, CrawlBudget{Pattern: patterns[i], MaxPages: pages[i]})
+	}
+	return budgets, errors.Join(errs...)
+}
+
+// BudgetUsage is how much of a crawl budget has been used, and how many
+// URLs the last run skipped once it was
+type BudgetUsage struct {
+	Pattern  string `json:"pattern"`
+	MaxPages int    `json:"max_pages"`
+	Used     int    `json:"used"`
+	Skipped  int    `json:"skipped"`
+}
+
+// budgetTracker counts the pages dispatched under each budget
+type budgetTracker struct {
+	mu   sync.Mutex
+	used []int
+	// spent is set once a budget's last page has gone out, so that is
+	// logged once
+	spent []bool
+}
+
+// budgetFor returns the index of the first budget url matches, -1 if none
+func (c *Crawler) budgetFor(url string) int {
+	for i, b := range c.cfg.CrawlBudgets {
+		if b.Pattern.MatchString(url) {
+			return i
+		}
+	}
+	return -1
+}
+
+// budgetUse counts the pages under each budget the crawl has been through
+// so far: the ones fetched, as pages.jsonl has them, that are still
+// scraped, so a recrawl starts the budgets over, and the ones that failed
+func (c *Crawler) budgetUse() ([]int, error) {
+	used := make([]int, len(c.cfg.CrawlBudgets))
+	if len(used) == 0 {
+		return used, nil
+	}
+	// Assets and images don't count, and their lines start with the URL
+	seen := make(map[string]bool)
+	for _, line := range c.assetURLsFile.snapshot() {
+		seen[lineKey(line)] = true
+	}
+	if c.imageURLsFile != nil {
+		for _, line := range c.imageURLsFile.snapshot() {
+			seen[lineKey(line)] = true
+		}
+	}
+	count := func(url string) {
+		if seen[url] {
+			return
+		}
+		seen[url] = true
+		if i := c.budgetFor(url); i >= 0 {
+			used[i]++
+		}
+	}
+	if err := readJSONL(c.pagesFileName, func(line []byte) {
+		var p pageRecord
+		if json.Unmarshal(line, &p) == nil && p.URL != "" && c.Frontier.IsScraped(p.URL) {
+			count(p.URL)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	for _, f := range c.Frontier.Failed() {
+		count(f.URL)
+	}
+	return used, nil
+}
+
+// startBudgets queues the URLs the last run skipped over budget again,
+// to be weighed against the budgets as they are now, and counts what the
+// budgets have used
+func (c *Crawler) startBudgets() error {
+	skipped := make(map[string]bool)
+	for _, line := range c.budgetFile.snapshot() {
+		skipped[lineKey(line)] = true
+	}
+	if len(skipped) > 0 {
+		// They must be pending for sure before the list goes
+		if err := c.Frontier.ForgetScraped(skipped); err != nil {
+			return err
+		}
+		if err := c.Frontier.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := c.budgetFile.truncate(); err != nil {
+		return err
+	}
+	used, err := c.budgetUse()
+	if err != nil {
+		return err
+	}
+	c.budgets = budgetTracker{used: used, spent: make([]bool, len(used))}
+	return nil
+}
+
+// takeBudget claims a page of url's budget, returning the budget's index,
+// -1 if it has none, and false if the budget is used up
+func (c *Crawler) takeBudget(url string) (int, bool) {
+	i := c.budgetFor(url)
+	if i < 0 {
+		return i, true
+	}
+	b := c.cfg.CrawlBudgets[i]
+	c.budgets.mu.Lock()
+	defer c.budgets.mu.Unlock()
+	if c.budgets.used[i] >= b.MaxPages {
+		if !c.budgets.spent[i] {
+			c.budgets.spent[i] = true
+			c.log().Info("crawl budget used up, skipping the rest", "pattern", b.Pattern.String(), "max_pages", b.MaxPages)
+		}
+		return i, false
+	}
+	c.budgets.used[i]++
+	return i, true
+}
+
+// returnBudget gives back a page taken with takeBudget when the URL stays
+// pending
+func (c *Crawler) returnBudget(i int) {
+	if i < 0 {
+		return
+	}
+	c.budgets.mu.Lock()
+	c.budgets.used[i]--
+	c.budgets.mu.Unlock()
+}
+
+// skipOverBudget finishes a URL whose budget is used up without fetching
+// it, noting it in budget_skipped.txt
+func (c *Crawler) skipOverBudget(url string, i int) {
+	_, _ = c.budgetFile.add(url + "\t" + c.cfg.CrawlBudgets[i].Pattern.String())
+	_ = c.Frontier.MarkScraped(url)
+}
+
+// budgetUsage reports every budget for the summary
+func (c *Crawler) budgetUsage() ([]BudgetUsage, error) {
+	used, err := c.budgetUse()
+	if err != nil || len(used) == 0 {
+		return nil, err
+	}
+	skipped := make([]int, len(used))
+	for _, line := range c.budgetFile.snapshot() {
+		if i := c.budgetFor(lineKey(line)); i >= 0 {
+			skipped[i]++
+		}
+	}
+	usage := make([]BudgetUsage, len(used))
+	for i, b := range c.cfg.CrawlBudgets {
+		usage[i] = BudgetUsage{Pattern: b.Pattern.String(), MaxPages: b.MaxPages, Used: used[i], Skipped: skipped[i]}
+	}
+	return usage, nil
+}
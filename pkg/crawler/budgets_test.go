@@ -0,0 +1,100 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCrawlBudgetPrecedence crawls a forum under a budget of two pages
+// along with the rules that come before it and after it. Excluded URLs and
+// the links past MAX_DEPTH are never found, so never weighed; a URL
+// robots.txt disallows is skipped before its budget is; the rest of the
+// forum is skipped over budget, with the budget as the reason, and takes
+// none of MAX_PAGES.
+func TestCrawlBudgetPrecedence(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nDisallow: /forum/1\n"))
+			return
+		}
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path != "/" {
+			// Past MAX_DEPTH
+			w.Write([]byte(`<html><a href="` + r.URL.Path + `/deep">deep</a></html>`))
+			return
+		}
+		w.Write([]byte(`<html>` +
+			`<a href="/forum/1">1</a><a href="/forum/2">2</a><a href="/forum/3">3</a><a href="/forum/4">4</a><a href="/forum/5">5</a>` +
+			`<a href="/forum/secret">excluded</a><a href="/docs/a">docs</a><a href="/about">about</a></html>`))
+	}))
+	defer srv.Close()
+
+	budgets, err := CompileCrawlBudgets("CRAWL_BUDGETS", "/forum/=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, _ := CompilePatterns("EXCLUDE_PATTERNS", "secret")
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.MaxDepth = 1
+		cfg.MaxPages = 5
+		cfg.Exclude = exclude
+		cfg.CrawlBudgets = budgets
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	sort.Strings(requested)
+	if want := []string{"/", "/about", "/docs/a", "/forum/2", "/forum/3"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("requested %v, want %v", requested, want)
+	}
+
+	b, err := os.ReadFile(c.budgetFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var skipped []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		url, reason, _ := strings.Cut(line, "\t")
+		if reason != "/forum/" {
+			t.Errorf("%s skipped for %q, want the /forum/ budget", url, reason)
+		}
+		skipped = append(skipped, strings.TrimPrefix(url, srv.URL))
+	}
+	if want := []string{"/forum/4", "/forum/5"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped over budget %v, want %v", skipped, want)
+	}
+	if run := c.LastRun(); run.Frontier == nil || run.Frontier.Pending != 0 {
+		t.Errorf("frontier %+v, want nothing left pending", run.Frontier)
+	}
+	if err := c.Frontier.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Frontier.Close()
+	if found := urlsOf(c.Frontier.Found()); slices.ContainsFunc(found, func(u string) bool {
+		return strings.HasSuffix(u, "/deep") || strings.HasSuffix(u, "/secret")
+	}) {
+		t.Errorf("found %v, want no excluded URL or link past MAX_DEPTH", found)
+	}
+
+	if want := []BudgetUsage{{Pattern: "/forum/", MaxPages: 2, Used: 2, Skipped: 2}}; !reflect.DeepEqual(c.Report().Budgets, want) {
+		t.Errorf("budget usage %+v, want %+v", c.Report().Budgets, want)
+	}
+}
@@ -0,0 +1,85 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// pageCanonical returns the normalized canonical URL of a page fetched as
+// rawURL, and served from finalURL if it redirected, when that is another
+// page, and whether it is in scope. A canonical that is the page itself,
+// its query in another order included, is empty. Of several canonical
+// tags the first counts, which is logged.
+func (c *Crawler) pageCanonical(log *slog.Logger, rawURL, finalURL string, info *pageInfo) (string, bool) {
+	if info.Canonical == "" {
+		return "", false
+	}
+	if len(info.ExtraCanonicals) > 0 {
+		log.Warn("several canonical URLs, using the first", "canonical", info.Canonical, "ignored", info.ExtraCanonicals)
+	}
+	canonical, err := c.normalizeURL(info.Canonical)
+	if err != nil {
+		return "", false
+	}
+	for _, self := range []string{rawURL, finalURL} {
+		if self != "" && sameIgnoringQueryOrder(canonical, self) {
+			return "", false
+		}
+	}
+	return canonical, c.inScope(canonical)
+}
+
+// sameIgnoringQueryOrder reports whether two URLs differ at most in the
+// order of their query parameters
+func sameIgnoringQueryOrder(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	ua.RawQuery, ub.RawQuery = sortedQuery(ua.RawQuery), sortedQuery(ub.RawQuery)
+	return ua.String() == ub.String()
+}
+
+func sortedQuery(rawQuery string) string {
+	pairs := strings.Split(rawQuery, "&")
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// recordCanonical notes in the canonicals file that the page at alias
+// names canonical as its canonical URL, marked "external" out of scope
+func (c *Crawler) recordCanonical(alias, canonical string, inScope bool) {
+	if c.canonicalsFile == nil {
+		return
+	}
+	line := alias + "\t" + canonical
+	if !inScope {
+		line += "\texternal"
+	}
+	_, _ = c.canonicalsFile.add(line)
+}
+
+// skipNonCanonical drops the body of a page saved as fileName whose
+// canonical is another in-scope URL, keeping what parsing it found in
+// pages.jsonl and following its links, the canonical among them
+func (c *Crawler) skipNonCanonical(ctx context.Context, job Job, record *pageRecord, info *pageInfo, fileName, canonical string) ([]string, error) {
+	if err := c.dropSaved(record, fileName); err != nil {
+		return nil, err
+	}
+	c.logFrom(ctx).Debug("skipped", "reason", "non-canonical", "canonical", canonical)
+	c.recordCanonical(job.URL, canonical, true)
+	record.NonCanonical = true
+	pages := c.recordPageLinks(job.URL, info)
+	c.storeScriptLinks(job, info.ScriptLinks)
+	c.storePagination(job, info.Next)
+	c.storeTranslations(job, info)
+	describePage(record, info, pages)
+	return pages, nil
+}
@@ -0,0 +1,342 @@
+package crawler
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The StorageCompression codecs
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// gzipSuffix and zstdSuffix are added to the name of a compressed body
+const (
+	gzipSuffix = ".gz"
+	zstdSuffix = ".zst"
+)
+
+// codecs are the codecs a body may be stored with, in the order they are
+// looked for
+var codecs = []string{compressGzip, compressZstd}
+
+// codecSuffix is the suffix of a body stored with codec, "" for none
+func codecSuffix(codec string) string {
+	switch codec {
+	case compressGzip:
+		return gzipSuffix
+	case compressZstd:
+		return zstdSuffix
+	}
+	return ""
+}
+
+// trimCodecSuffix is the name a stored file holds the body of, name less
+// a codec's suffix
+func trimCodecSuffix(name string) string {
+	for _, codec := range codecs {
+		if trimmed, ok := strings.CutSuffix(name, codecSuffix(codec)); ok {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// compressedExtensions are bodies stored compressed already, like the raw
+// copies kept with KeepRawEncoded
+var compressedExtensions = map[string]bool{".gz": true, ".br": true, ".zst": true, ".zip": true, ".bz2": true, ".xz": true}
+
+// compressible reports whether a body of mediaType saved as name is worth
+// compressing: text, and the JSON, XML and script types
+func compressible(name, mediaType string) bool {
+	if compressedExtensions[strings.ToLower(path.Ext(name))] {
+		return false
+	}
+	if mediaType == "" {
+		mediaType = mime.TypeByExtension(path.Ext(name))
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+	}
+	major, sub, _ := strings.Cut(strings.ToLower(mediaType), "/")
+	switch {
+	case major == "text":
+		return true
+	case strings.HasSuffix(sub, "+xml"), strings.HasSuffix(sub, "+json"):
+		return true
+	}
+	switch sub {
+	case "json", "xml", "javascript", "x-javascript", "ecmascript", "xhtml+xml", "manifest+json", "wasm":
+		return major == "application"
+	}
+	return false
+}
+
+// zstdEncoders are reused, as each is costly to set up
+var zstdEncoders = sync.Pool{New: func() any {
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	return enc
+}}
+
+// compressor returns a writer compressing to w with codec; closing it
+// finishes the stream without closing w
+func compressor(codec string, w io.Writer) io.WriteCloser {
+	if codec == compressZstd {
+		enc := zstdEncoders.Get().(*zstd.Encoder)
+		enc.Reset(w)
+		return &pooledEncoder{enc}
+	}
+	return gzip.NewWriter(w)
+}
+
+// pooledEncoder goes back to zstdEncoders once closed
+type pooledEncoder struct {
+	*zstd.Encoder
+}
+
+func (e *pooledEncoder) Close() error {
+	err := e.Encoder.Close()
+	e.Encoder.Reset(nil)
+	zstdEncoders.Put(e.Encoder)
+	return err
+}
+
+// writeCompressedAtomic is writeReaderAtomic compressing r with codec on
+// the way, returning the uncompressed bytes written
+func writeCompressedAtomic(p, codec string, r io.Reader) (int64, error) {
+	pr, pw := io.Pipe()
+	done := make(chan int64, 1)
+	go func() {
+		zw := compressor(codec, pw)
+		n, err := io.Copy(zw, r)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+		done <- n
+	}()
+	_, err := writeReaderAtomic(p, pr)
+	// Unblocks the copy if the write gave up first
+	pr.Close()
+	return <-done, err
+}
+
+// compressedFile reads a compressed body, closing the file with it
+type compressedFile struct {
+	io.Reader
+	close func()
+	f     *os.File
+}
+
+func (c *compressedFile) Close() error {
+	c.close()
+	return c.f.Close()
+}
+
+// openCompressed opens the file p, stored with codec, to read it
+// decompressed
+func openCompressed(p, codec string) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if codec == compressZstd {
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		return &compressedFile{Reader: zr, close: zr.Close, f: f}, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return &compressedFile{Reader: gz, close: func() { gz.Close() }, f: f}, nil
+}
+
+// compressedSize returns the uncompressed size of the file p, stored with
+// codec. A gzip trailer has it, modulo 4GB; a zstd stream, written before
+// its size is known, has to be decompressed to count it.
+func compressedSize(p, codec string) (int64, bool) {
+	if codec == compressGzip {
+		return gzipSize(p)
+	}
+	f, err := openCompressed(p, codec)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	n, err := io.Copy(io.Discard, f)
+	return n, err == nil
+}
+
+// gzipSize reads the uncompressed size, modulo 4GB, from the trailer of a
+// gzip file
+func gzipSize(p string) (int64, bool) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	// A header and a trailer are 18 bytes at the least
+	if err != nil || info.IsDir() || info.Size() < 18 {
+		return 0, false
+	}
+	var trailer [4]byte
+	if _, err := f.ReadAt(trailer[:], info.Size()-4); err != nil {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:])), true
+}
+
+// noteStored sets Codec and StoredSize in e for how its file is stored
+func (c *Crawler) noteStored(e *manifestEntry) {
+	fsys, ok := c.Storage.(fsStorage)
+	if !ok {
+		return
+	}
+	e.Codec, e.StoredSize = fsys.stored(e.File)
+	if e.Codec == "" {
+		e.StoredSize = 0
+	}
+}
+
+// CompressStats is what CompressStorage did. CPU is the time spent
+// compressing, which PerPage spreads over the files.
+type CompressStats struct {
+	Files   int
+	Before  int64
+	After   int64
+	CPU     time.Duration
+	Skipped int
+}
+
+// PerPage is the compressing time per file
+func (s CompressStats) PerPage() time.Duration {
+	if s.Files == 0 {
+		return 0
+	}
+	return s.CPU / time.Duration(s.Files)
+}
+
+// WriteText prints the stats for people
+func (s CompressStats) WriteText(w io.Writer) error {
+	saved := 0.0
+	if s.Before > 0 {
+		saved = 100 * float64(s.Before-s.After) / float64(s.Before)
+	}
+	_, err := fmt.Fprintf(w, "Compressed %d files from %s to %s (%.1f%% saved), %s per file; %d left as they were\n",
+		s.Files, formatSize(s.Before), formatSize(s.After), saved, s.PerPage().Round(time.Microsecond), s.Skipped)
+	return err
+}
+
+// CompressStorage compresses in place the files of the download folder
+// that StorageCompression would have, with its codec or gzip when it is
+// "none": the saved pages and assets of text types, their untranscoded,
+// text and offline copies, updating the manifest. Files compressed
+// already, and other types, are left alone.
+func (c *Crawler) CompressStorage(ctx context.Context) (CompressStats, error) {
+	var stats CompressStats
+	fsys, ok := c.Storage.(fsStorage)
+	if !ok {
+		return stats, fmt.Errorf("compressing needs the fs storage backend, not %s", c.cfg.StorageBackend)
+	}
+	fsys.compress = c.cfg.StorageCompression
+	if codecSuffix(fsys.compress) == "" {
+		fsys.compress = compressGzip
+	}
+	if err := c.lock(); err != nil {
+		return stats, err
+	}
+	defer c.unlock()
+	if err := c.openManifest(); err != nil {
+		return stats, err
+	}
+	defer c.pageManifest.close()
+
+	c.pageManifest.mu.Lock()
+	entries := make([]manifestEntry, 0, len(c.pageManifest.entries))
+	for _, e := range c.pageManifest.entries {
+		entries = append(entries, e)
+	}
+	c.pageManifest.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+
+	// compressOne compresses name unless it is stored compressed or isn't
+	// text, reporting whether it did
+	done := make(map[string]bool)
+	compressOne := func(name, mediaType string) (bool, error) {
+		if done[name] {
+			return false, nil
+		}
+		done[name] = true
+		codec, before := fsys.stored(name)
+		if codec != "" || before == 0 || !compressible(name, mediaType) {
+			return false, nil
+		}
+		f, err := os.Open(fsys.path(name))
+		if err != nil {
+			return false, err
+		}
+		start := time.Now()
+		_, err = fsys.SavePage(name, PageMeta{ContentType: mediaType}, f)
+		f.Close()
+		if err != nil {
+			return false, err
+		}
+		stats.CPU += time.Since(start)
+		_, after := fsys.stored(name)
+		stats.Files++
+		stats.Before += before
+		stats.After += after
+		return true, nil
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if e.File == "" {
+			continue
+		}
+		compressed, err := compressOne(e.File, e.ContentType)
+		if err != nil {
+			return stats, fmt.Errorf("compressing %s: %w", e.File, err)
+		}
+		if !compressed && e.Codec == "" {
+			if codec, _ := fsys.stored(e.File); codec == "" {
+				stats.Skipped++
+				continue
+			}
+		}
+		for _, extra := range []string{e.OriginalFile, textFileName(e.File), offlineName(e.File)} {
+			if extra == "" || extra == e.File {
+				continue
+			}
+			if _, err := compressOne(extra, e.ContentType); err != nil {
+				return stats, fmt.Errorf("compressing %s: %w", extra, err)
+			}
+		}
+		if e.Codec == "" {
+			c.noteStored(&e)
+			if err := c.pageManifest.record(e); err != nil {
+				return stats, err
+			}
+		}
+	}
+	return stats, nil
+}
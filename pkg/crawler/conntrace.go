@@ -0,0 +1,42 @@
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync/atomic"
+)
+
+// connStats counts the connections the fetches dialed and reused, to show
+// how well the transport keeps them
+type connStats struct {
+	reused, opened, handshakes atomic.Int64
+}
+
+// trace returns ctx with a client trace counting into s
+func (s *connStats) trace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		// A dial started for one fetch may end up serving another, so
+		// connections are counted as they are dialed, not as fetches get them
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				s.reused.Add(1)
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				s.opened.Add(1)
+			}
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				s.handshakes.Add(1)
+			}
+		},
+	})
+}
+
+// attrs are the counts as log attributes
+func (s *connStats) attrs() []any {
+	return []any{"conns_reused", s.reused.Load(), "conns_opened", s.opened.Load(), "tls_handshakes", s.handshakes.Load()}
+}
@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reuseSite is a site of a front page linking to a number of pages, served
+// over TLS with a certificate from a CA of its own
+type reuseSite struct {
+	pages  int
+	links  string
+	cert   tls.Certificate
+	caFile string
+}
+
+func newReuseSite(t testing.TB, pages int) *reuseSite {
+	t.Helper()
+	ca := newTestCA(t, "test CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM := ca.issue(t, "127.0.0.1")
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links strings.Builder
+	for i := range pages {
+		fmt.Fprintf(&links, `<a href="/p/%d">%d</a>`, i, i)
+	}
+	return &reuseSite{pages: pages, links: links.String(), cert: cert, caFile: caFile}
+}
+
+// start serves the site, over HTTP/2 or not, counting the connections the
+// server accepts
+func (s *reuseSite) start(t testing.TB, http2 bool) (srv *httptest.Server, accepted *atomic.Int64) {
+	accepted = new(atomic.Int64)
+	srv = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			w.Write([]byte(`<html><body>` + s.links + `</body></html>`))
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+		w.Write([]byte(`<html><body>` + r.URL.Path + `</body></html>`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			accepted.Add(1)
+		}
+	}
+	srv.EnableHTTP2 = http2
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{s.cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, accepted
+}
+
+// crawl crawls the site served at srvURL and returns the crawl's counts
+func (s *reuseSite) crawl(t testing.TB, srvURL string, workers, idlePerHost int) *connStats {
+	t.Helper()
+	c := newTestCrawler(t, srvURL+"/", func(cfg *Config) {
+		cfg.Workers = workers
+		cfg.RespectRobots = false
+		cfg.TLSCAFile = s.caFile
+		cfg.MaxIdleConnsPerHost = idlePerHost
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if run := c.LastRun(); run.Pages != int64(s.pages+1) || run.Failed != 0 {
+		t.Fatalf("fetched %d pages, %d failed, want %d fetched", run.Pages, run.Failed, s.pages+1)
+	}
+	return &c.conns
+}
+
+// TestConnectionReuse crawls a site over TLS and counts the connections
+// the server accepts against the crawl's own counts: with the pool keeping
+// an idle connection per worker there is about a handshake per worker,
+// where a pool of one churns through more, and over HTTP/2 the fetches
+// share a single connection
+func TestConnectionReuse(t *testing.T) {
+	const pages, workers = 60, 6
+	site := newReuseSite(t, pages)
+
+	// crawl returns the connections the server accepted and the crawler's
+	// counts
+	crawl := func(t *testing.T, http2 bool, idlePerHost int) (accepted int64, stats *connStats) {
+		srv, conns := site.start(t, http2)
+		stats = site.crawl(t, srv.URL, workers, idlePerHost)
+		srv.Close()
+		return conns.Load(), stats
+	}
+
+	fetches := int64(pages + 1)
+	pooled, stats := crawl(t, false, 0)
+	// A fetch may dial while another worker's connection comes free, and
+	// take that one, leaving its own dial over
+	if pooled > workers+1 {
+		t.Errorf("%d connections for %d workers", pooled, workers)
+	}
+	if got := stats.handshakes.Load(); got != pooled {
+		t.Errorf("counted %d handshakes, the server accepted %d connections", got, pooled)
+	}
+	if got := stats.opened.Load(); got != pooled {
+		t.Errorf("counted %d connections opened, the server accepted %d", got, pooled)
+	}
+	if got := stats.reused.Load(); got < fetches-pooled {
+		t.Errorf("%d fetches reused a connection, want at least %d", got, fetches-pooled)
+	}
+
+	churned, stats := crawl(t, false, 1)
+	if churned <= pooled || stats.handshakes.Load() != churned {
+		t.Errorf("%d connections and %d handshakes keeping one idle, want more than the %d keeping one per worker",
+			churned, stats.handshakes.Load(), pooled)
+	}
+
+	shared, stats := crawl(t, true, 0)
+	if shared != 1 || stats.handshakes.Load() != 1 {
+		t.Errorf("%d connections and %d handshakes over HTTP/2, want one", shared, stats.handshakes.Load())
+	}
+}
+
+// BenchmarkConnectionReuse crawls the site of TestConnectionReuse with the
+// pool as tuned and keeping a single idle connection, reporting the TLS
+// handshakes each crawl took
+func BenchmarkConnectionReuse(b *testing.B) {
+	const pages, workers = 60, 6
+	site := newReuseSite(b, pages)
+	benchmarks := []struct {
+		name        string
+		idlePerHost int
+	}{
+		{"tuned", 0},
+		{"one idle", 1},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			srv, _ := site.start(b, false)
+			var handshakes int64
+			for i := 0; i < b.N; i++ {
+				handshakes += site.crawl(b, srv.URL, workers, bm.idlePerHost).handshakes.Load()
+			}
+			b.ReportMetric(float64(handshakes)/float64(b.N), "handshakes/op")
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// assetsSubfolder sits inside the downloaded files folder
+const assetsSubfolder = "assets"
+
+// responseContentType returns the media type of resp without parameters.
+// When the server doesn't send one, it is sniffed from the first bytes of
+// body, which must wrap resp.Body.
+func responseContentType(resp *http.Response, body *bufio.Reader) string {
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err := mime.ParseMediaType(ct); err == nil {
+			return strings.ToLower(mediaType)
+		}
+	}
+	head, _ := body.Peek(512)
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return strings.ToLower(mediaType)
+}
+
+func isHTMLType(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// contentTypeAllowed matches mediaType against DownloadContentTypes, where
+// "image/*" matches by major type and "*/*" matches everything.
+func (c *Crawler) contentTypeAllowed(mediaType string) bool {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, allowed := range c.cfg.DownloadContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		switch {
+		case allowed == "*/*" || allowed == "*":
+			return true
+		case strings.HasSuffix(allowed, "/*"):
+			if strings.TrimSuffix(allowed, "/*") == major {
+				return true
+			}
+		case allowed == mediaType:
+			return true
+		}
+	}
+	return false
+}
+
+// commonExtensions covers the types where mime.ExtensionsByType would pick
+// an odd first choice (".jfif" for JPEG, say)
+var commonExtensions = map[string]string{
+	"text/html":             ".html",
+	"application/xhtml+xml": ".html",
+	"text/plain":            ".txt",
+	"text/css":              ".css",
+	"text/javascript":       ".js",
+	"application/pdf":       ".pdf",
+	"application/json":      ".json",
+	"application/zip":       ".zip",
+	"image/jpeg":            ".jpg",
+	"image/png":             ".png",
+	"image/gif":             ".gif",
+	"image/svg+xml":         ".svg",
+	"image/webp":            ".webp",
+}
+
+func extensionFor(mediaType string) string {
+	if ext, ok := commonExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// htmlEncoding is the encoding of an HTML page from the first bytes of its
+// body and its Content-Type: a BOM, the header's charset or a <meta
+// charset>, in that order. A page that declares none is taken to be UTF-8
+// and left alone, where charset.DetermineEncoding would guess
+// windows-1252 and garble it along with its non-ASCII links.
+func htmlEncoding(head []byte, contentType string) (encoding.Encoding, string) {
+	if enc, name, certain := charset.DetermineEncoding(head, contentType); certain {
+		return enc, name
+	}
+	if enc, name := metaCharset(head); enc != nil {
+		return enc, name
+	}
+	return encoding.Nop, "utf-8"
+}
+
+// metaCharset is the encoding a <meta charset> or <meta http-equiv
+// content-type> in head names, nil when there is none it knows
+func metaCharset(head []byte) (encoding.Encoding, string) {
+	z := html.NewTokenizer(bytes.NewReader(head))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return nil, ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "meta" {
+				continue
+			}
+			var declared, content string
+			httpEquiv := false
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				switch string(key) {
+				case "charset":
+					declared = string(val)
+				case "http-equiv":
+					httpEquiv = strings.EqualFold(string(val), "content-type")
+				case "content":
+					content = string(val)
+				}
+			}
+			if declared == "" && httpEquiv {
+				if _, params, err := mime.ParseMediaType(content); err == nil {
+					declared = params["charset"]
+				}
+			}
+			if declared != "" {
+				if enc, name := charset.Lookup(declared); enc != nil {
+					return enc, name
+				}
+			}
+		}
+	}
+}
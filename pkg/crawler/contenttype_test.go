@@ -0,0 +1,243 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// utf8Straddling is an undeclared UTF-8 page whose first 1024 bytes end in
+// the middle of an "é"
+func utf8Straddling(link string) string {
+	prefix := "<html><head><title>Café</title></head><body><p>"
+	pad := strings.Repeat("a", 1023-len(prefix))
+	return prefix + pad + "é</p><a href=\"" + link + "\">café</a></body></html>"
+}
+
+func TestHTMLEncoding(t *testing.T) {
+	tests := []struct {
+		name, body, contentType, want string
+	}{
+		{"undeclared ASCII", "<html><body>plain</body></html>", "text/html", "utf-8"},
+		{"undeclared UTF-8 cut mid-character", utf8Straddling("/x"), "text/html", "utf-8"},
+		{"undeclared bytes that aren't UTF-8", "<html><body>caf\xe9</body></html>", "text/html", "utf-8"},
+		{"header charset", "<html><body>caf\xe9</body></html>", "text/html; charset=ISO-8859-1", "windows-1252"},
+		{"meta charset", `<html><head><meta charset="shift_jis"></head><body>x</body></html>`, "text/html", "shift_jis"},
+		{"meta http-equiv", `<html><head><meta http-equiv="Content-Type" content="text/html; charset=windows-1251"></head></html>`, "text/html", "windows-1251"},
+		{"header beats meta", `<html><head><meta charset="shift_jis"></head></html>`, "text/html; charset=utf-8", "utf-8"},
+		{"BOM beats header", "\xff\xfe<\x00h\x00", "text/html; charset=iso-8859-1", "utf-16le"},
+	}
+	for _, tt := range tests {
+		head := []byte(tt.body)
+		if len(head) > 1024 {
+			head = head[:1024]
+		}
+		if _, got := htmlEncoding(head, tt.contentType); got != tt.want {
+			t.Errorf("%s: htmlEncoding = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestUndeclaredUTF8PageKept crawls an undeclared UTF-8 page whose first
+// KB ends mid-character: it has to be saved as it was served, and its
+// non-ASCII link fetched rather than garbled into a 404
+func TestUndeclaredUTF8PageKept(t *testing.T) {
+	page := utf8Straddling("/café")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.EscapedPath() {
+		case "/":
+			w.Write([]byte(page))
+		case "/caf%C3%A9":
+			w.Write([]byte("<html><title>found</title></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+	})
+	var mu sync.Mutex
+	pages := make(map[string]PageResult)
+	c.OnPage = func(p PageResult) {
+		mu.Lock()
+		pages[p.URL] = p
+		mu.Unlock()
+	}
+	var failed []string
+	c.OnError = func(url string, err error) {
+		mu.Lock()
+		failed = append(failed, url+": "+err.Error())
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(failed) > 0 {
+		t.Errorf("failed: %v", failed)
+	}
+	linked := srv.URL + "/caf%C3%A9"
+	if p, ok := pages[linked]; !ok || p.Status != http.StatusOK {
+		t.Errorf("%s wasn't fetched: %v", linked, pages)
+	}
+	home, ok := pages[srv.URL+"/"]
+	if !ok {
+		t.Fatalf("home page wasn't saved: %v", pages)
+	}
+	if home.Title != "Café" {
+		t.Errorf("title = %q, want Café", home.Title)
+	}
+	got := readSaved(t, c, home.File)
+	if got != page {
+		t.Errorf("saved page differs from the one served:\n%q", got)
+	}
+}
+
+func TestContentTypeAllowed(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		allow   []string
+		refuse  []string
+	}{
+		{[]string{"application/pdf"}, []string{"application/pdf"}, []string{"application/zip", "image/png"}},
+		{[]string{" Image/* "}, []string{"image/png", "image/svg+xml"}, []string{"application/pdf"}},
+		{[]string{"*/*"}, []string{"application/zip", "video/mp4"}, nil},
+	}
+	for _, tt := range tests {
+		c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.DownloadContentTypes = tt.allowed })
+		for _, mt := range tt.allow {
+			if !c.contentTypeAllowed(mt) {
+				t.Errorf("%v refuses %s", tt.allowed, mt)
+			}
+		}
+		for _, mt := range tt.refuse {
+			if c.contentTypeAllowed(mt) {
+				t.Errorf("%v allows %s", tt.allowed, mt)
+			}
+		}
+	}
+}
+
+func TestExtensionFor(t *testing.T) {
+	tests := []struct{ mediaType, want string }{
+		{"text/html", ".html"},
+		{"application/xhtml+xml", ".html"},
+		{"application/pdf", ".pdf"},
+		{"image/jpeg", ".jpg"},
+		{"image/png", ".png"},
+		{"application/zip", ".zip"},
+	}
+	for _, tt := range tests {
+		if got := extensionFor(tt.mediaType); got != tt.want {
+			t.Errorf("extensionFor(%s) = %q, want %q", tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+// TestNonHTMLResponses crawls a page linking to a PDF, an image and a page
+// served without a Content-Type: the PDF and image are kept under assets/
+// when their types are wanted, and never parsed, and the untyped page is
+// sniffed as HTML and its links followed
+func TestNonHTMLResponses(t *testing.T) {
+	pngHeader := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><a href="/doc">pdf</a><a href="/pic">image</a><a href="/untyped">untyped</a></html>`))
+		case "/doc":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte(`%PDF-1.4 <a href="/from-pdf">not a link</a>`))
+		case "/pic":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(pngHeader))
+		case "/untyped":
+			// Keeps the server from sniffing a type itself
+			w.Header()["Content-Type"] = nil
+			w.Write([]byte(`<!DOCTYPE html><html><a href="/from-untyped">more</a></html>`))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html>leaf</html>`))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		saveNonHTML bool
+		types       []string
+		files       map[string]string
+	}{
+		{
+			name:  "non-HTML skipped",
+			types: []string{"*/*"},
+			files: map[string]string{"/": "text/html", "/untyped": "text/html", "/from-untyped": "text/html"},
+		},
+		{
+			name:        "PDFs kept",
+			saveNonHTML: true,
+			types:       []string{"application/pdf"},
+			files:       map[string]string{"/": "text/html", "/doc": "application/pdf", "/untyped": "text/html", "/from-untyped": "text/html"},
+		},
+		{
+			name:        "PDFs and images kept",
+			saveNonHTML: true,
+			types:       []string{"application/pdf", "image/*"},
+			files:       map[string]string{"/": "text/html", "/doc": "application/pdf", "/pic": "image/png", "/untyped": "text/html", "/from-untyped": "text/html"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.SaveNonHTML = tt.saveNonHTML
+				cfg.DownloadContentTypes = tt.types
+			})
+			var mu sync.Mutex
+			saved := make(map[string]PageResult)
+			c.OnPage = func(p PageResult) {
+				mu.Lock()
+				defer mu.Unlock()
+				// A skipped type is reported without a file
+				if p.File != "" {
+					saved[strings.TrimPrefix(p.URL, srv.URL)] = p
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.Run(ctx); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if len(saved) != len(tt.files) {
+				var paths []string
+				for path := range saved {
+					paths = append(paths, path)
+				}
+				t.Errorf("saved %v, want %v", paths, tt.files)
+			}
+			for path, contentType := range tt.files {
+				p, ok := saved[path]
+				switch {
+				case !ok:
+					t.Errorf("%s not saved", path)
+				case p.ContentType != contentType:
+					t.Errorf("%s saved as %s, want %s", path, p.ContentType, contentType)
+				case contentType != "text/html" && (!strings.HasPrefix(p.File, assetsSubfolder+"/") || !strings.HasSuffix(p.File, extensionFor(contentType))):
+					t.Errorf("%s saved as %s, want it under assets/ with its extension", path, p.File)
+				case contentType != "text/html" && len(p.Links) > 0:
+					t.Errorf("%s was parsed for links: %v", path, p.Links)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package crawler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxSeedBody caps the body of a POST /seed
+const maxSeedBody = 1 << 20
+
+// ErrNotRunning is returned when something needs a running crawl and Run
+// isn't crawling
+var ErrNotRunning = errors.New("no crawl running")
+
+// LiveStatus is where a running crawl stands
+type LiveStatus struct {
+	RunID     string    `json:"run_id"`
+	StartedAt time.Time `json:"started_at"`
+	ElapsedS  int64     `json:"elapsed_seconds"`
+	Found     int       `json:"found"`
+	Scraped   int       `json:"scraped"`
+	Failed    int       `json:"failed"`
+	Pending   int       `json:"pending"`
+	// PagesDone, BytesRead and the rates are this run's
+	PagesDone   int64   `json:"pages_done"`
+	BytesRead   int64   `json:"bytes_read"`
+	ReqPerSec   float64 `json:"req_per_sec"`
+	PagesPerSec float64 `json:"pages_per_sec"`
+	Workers     int     `json:"workers"`
+	// Paused says why the crawl is paused, or about to be once the
+	// requests in flight finish
+	Paused      string         `json:"paused,omitempty"`
+	CircuitOpen bool           `json:"circuit_open"`
+	Failures    map[string]int `json:"failures,omitempty"`
+	// RecentErrors are the latest failures, newest last
+	RecentErrors []RecentError `json:"recent_errors"`
+}
+
+// RecentError is a URL that failed for good
+type RecentError struct {
+	URL   string    `json:"url"`
+	Class string    `json:"class"`
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+// setLive marks whether Run has its state open for the control API
+func (c *Crawler) setLive(live bool) {
+	c.liveMu.Lock()
+	c.live = live
+	c.liveMu.Unlock()
+}
+
+// whileLive runs fn unless the crawl isn't running, keeping Run from
+// closing its state meanwhile, and reports whether it ran
+func (c *Crawler) whileLive(fn func()) bool {
+	c.liveMu.RLock()
+	defer c.liveMu.RUnlock()
+	if !c.live {
+		return false
+	}
+	fn()
+	return true
+}
+
+// LiveStatus returns where the running crawl stands, or ErrNotRunning
+func (c *Crawler) LiveStatus() (LiveStatus, error) {
+	var s LiveStatus
+	if !c.whileLive(func() {
+		state, _ := c.crawlStatus()
+		elapsed := time.Since(c.crawlStart)
+		s = LiveStatus{
+			RunID:       c.run.ID,
+			StartedAt:   c.run.StartedAt,
+			ElapsedS:    int64(elapsed.Round(time.Second) / time.Second),
+			Found:       state.Found,
+			Scraped:     state.Scraped,
+			Failed:      state.Failed,
+			Pending:     state.Pending,
+			PagesDone:   c.pagesDone.Load(),
+			BytesRead:   c.bandwidth.read.Load(),
+			ReqPerSec:   roundRate(c.achievedRate()),
+			PagesPerSec: roundRate(float64(c.pagesDone.Load()) / elapsed.Seconds()),
+			Workers:     c.workerLimit(),
+			Paused:      c.pauseReason(),
+			CircuitOpen: c.breaker.isOpen(),
+			Failures:    c.failures.counts(),
+		}
+		s.RecentErrors = []RecentError{}
+		for _, d := range c.failures.latest() {
+			s.RecentErrors = append(s.RecentErrors, RecentError{URL: d.URL, Class: d.Class, Error: d.Error, At: d.At})
+		}
+	}) {
+		return s, ErrNotRunning
+	}
+	return s, nil
+}
+
+// AddSeeds adds urls to the running crawl at depth zero, like SeedURLs.
+// They must be in scope; it returns how many were new to the frontier,
+// and why any were turned down.
+func (c *Crawler) AddSeeds(urls []string) (added int, err error) {
+	var errs []error
+	if !c.whileLive(func() {
+		for _, seed := range urls {
+			u, perr := url.Parse(strings.TrimSpace(seed))
+			if perr != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				errs = append(errs, fmt.Errorf("seed %q is not an http or https URL", seed))
+				continue
+			}
+			if !c.inScope(u.String()) {
+				errs = append(errs, fmt.Errorf("seed %s is outside BaseURL and AllowedDomains", seed))
+				continue
+			}
+			normalized, nerr := c.normalizeURL(u.String())
+			if nerr != nil {
+				errs = append(errs, fmt.Errorf("seed %s: %w", seed, nerr))
+				continue
+			}
+			ok, aerr := c.Frontier.Add(FrontierEntry{URL: normalized, Priority: c.priority(normalized)})
+			if aerr != nil {
+				errs = append(errs, fmt.Errorf("seed %s: %w", seed, aerr))
+			} else if ok {
+				added++
+			}
+		}
+	}) {
+		return 0, ErrNotRunning
+	}
+	if added > 0 {
+		c.log().Info("seeds added", "added", added, "rejected", len(errs))
+	}
+	return added, errors.Join(errs...)
+}
+
+// ControlHandler serves the control API of the crawl:
+//
+//	GET  /status  the LiveStatus as JSON
+//	POST /pause   Pause, then the status
+//	POST /resume  Resume, then the status
+//	POST /seed    AddSeeds with the body's {"urls": [...]}
+//	POST /stop    stop, which should cancel the context given to Run
+//
+// Every request must carry token as "Authorization: Bearer <token>"; with
+// an empty token all of them are refused.
+func (c *Crawler) ControlHandler(token string, stop func()) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", c.serveStatus)
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		c.log().Info("pause requested over the control API", "remote", r.RemoteAddr)
+		c.Pause()
+		c.serveStatus(w, r)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		c.log().Info("resume requested over the control API", "remote", r.RemoteAddr)
+		c.Resume()
+		c.serveStatus(w, r)
+	})
+	mux.HandleFunc("POST /seed", c.serveSeed)
+	mux.HandleFunc("POST /stop", func(w http.ResponseWriter, r *http.Request) {
+		c.log().Info("stop requested over the control API", "remote", r.RemoteAddr)
+		stop()
+		writeJSON(w, http.StatusAccepted, map[string]bool{"stopping": true})
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="crawler"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong token"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (c *Crawler) serveStatus(w http.ResponseWriter, r *http.Request) {
+	s, err := c.LiveStatus()
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (c *Crawler) serveSeed(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URLs []string `json:"urls"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSeedBody)).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `want {"urls": [...]}: ` + err.Error()})
+		return
+	}
+	added, err := c.AddSeeds(body.URLs)
+	if errors.Is(err, ErrNotRunning) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+	rejected := []string{}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			rejected = append(rejected, e.Error())
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"added": added, "rejected": rejected})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,196 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// controlClient calls a control API with a token
+type controlClient struct {
+	t     *testing.T
+	url   string
+	token string
+}
+
+// do sends method path with body, if any, and decodes the JSON answer into
+// out, if given, returning the status code
+func (cc controlClient) do(method, path, body string, out any) int {
+	cc.t.Helper()
+	req, err := http.NewRequest(method, cc.url+path, strings.NewReader(body))
+	if err != nil {
+		cc.t.Fatal(err)
+	}
+	if cc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cc.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cc.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			cc.t.Fatalf("%s %s: decoding %q: %v", method, path, b, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestControlAuth(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	tests := []struct {
+		name, token, sent string
+	}{
+		{"no token", "secret", ""},
+		{"wrong token", "secret", "guess"},
+		{"no token configured", "", ""},
+		{"token sent but none configured", "", "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopped := false
+			srv := httptest.NewServer(c.ControlHandler(tt.token, func() { stopped = true }))
+			defer srv.Close()
+			cc := controlClient{t, srv.URL, tt.sent}
+			for _, path := range []string{"/status", "/pause", "/stop"} {
+				method := http.MethodPost
+				if path == "/status" {
+					method = http.MethodGet
+				}
+				var body map[string]string
+				if code := cc.do(method, path, "", &body); code != http.StatusUnauthorized || body["error"] == "" {
+					t.Errorf("%s %s = %d %v, want 401", method, path, code, body)
+				}
+			}
+			if stopped || c.pauseRequested.Load() {
+				t.Error("an unauthorized request paused or stopped the crawl")
+			}
+		})
+	}
+}
+
+// TestControlAPI drives a crawl through its control API: the status is
+// served while it runs, a pause holds back the pending page until resume,
+// a seed added meanwhile is crawled, and stop ends Run
+func TestControlAPI(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			<-release
+		case "/stuck":
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><a href="/stuck">stuck</a></html>`))
+	}))
+	defer srv.Close()
+	wasRequested := func(path string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Contains(requested, path)
+	}
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.ShutdownTimeout = 100 * time.Millisecond
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	api := httptest.NewServer(c.ControlHandler("secret", cancel))
+	defer api.Close()
+	cc := controlClient{t, api.URL, "secret"}
+
+	var status LiveStatus
+	if code := cc.do(http.MethodGet, "/status", "", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("status before Run = %d, want 503", code)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	waitFor(t, "the crawl to start", func() bool { return wasRequested("/") })
+
+	if code := cc.do(http.MethodGet, "/status", "", &status); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if status.RunID == "" || status.Found != 1 || status.Pending != 1 || status.Workers != 1 || status.Paused != "" {
+		t.Errorf("status while fetching / = %+v", status)
+	}
+
+	if code := cc.do(http.MethodPost, "/pause", "", &status); code != http.StatusOK || status.Paused != "requested" {
+		t.Errorf("pause = %d %+v", code, status)
+	}
+	close(release)
+	waitFor(t, "/ to be done", func() bool {
+		cc.do(http.MethodGet, "/status", "", &status)
+		return status.PagesDone == 1
+	})
+	time.Sleep(200 * time.Millisecond)
+	if wasRequested("/stuck") {
+		t.Error("/stuck was requested while paused")
+	}
+
+	var seeded struct {
+		Added    int      `json:"added"`
+		Rejected []string `json:"rejected"`
+	}
+	body := `{"urls": ["` + srv.URL + `/extra", "https://elsewhere.example/", "ftp://example.com/"]}`
+	if code := cc.do(http.MethodPost, "/seed", body, &seeded); code != http.StatusOK || seeded.Added != 1 || len(seeded.Rejected) != 2 {
+		t.Errorf("seed = %d %+v, want 1 added and 2 rejected", code, seeded)
+	}
+	if code := cc.do(http.MethodPost, "/seed", `not json`, nil); code != http.StatusBadRequest {
+		t.Errorf("seed with a bad body = %d, want 400", code)
+	}
+	cc.do(http.MethodGet, "/status", "", &status)
+	if status.Found != 3 || status.Pending != 2 {
+		t.Errorf("status after seeding = %+v, want /stuck and /extra pending", status)
+	}
+
+	if code := cc.do(http.MethodPost, "/resume", "", &status); code != http.StatusOK {
+		t.Errorf("resume = %d", code)
+	}
+	waitFor(t, "the pending pages after resuming", func() bool { return wasRequested("/stuck") && wasRequested("/extra") })
+
+	var stopping map[string]bool
+	if code := cc.do(http.MethodPost, "/stop", "", &stopping); code != http.StatusAccepted || !stopping["stopping"] {
+		t.Errorf("stop = %d %v", code, stopping)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after stop")
+	}
+	if run := c.LastRun(); run.Outcome != outcomeInterrupted {
+		t.Errorf("outcome %q, want %q", run.Outcome, outcomeInterrupted)
+	}
+	if code := cc.do(http.MethodGet, "/status", "", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("status after Run = %d, want 503", code)
+	}
+}
@@ -0,0 +1,204 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// setRequestHeaders adds the configured headers and credentials to a
+// request for a crawled host. net/http drops Authorization and Cookie
+// when a redirect leaves the domain.
+func (c *Crawler) setRequestHeaders(req *http.Request) {
+	for name, value := range c.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	if c.cfg.BasicAuthUser != "" {
+		req.SetBasicAuth(c.cfg.BasicAuthUser, c.cfg.BasicAuthPassword)
+	}
+	if c.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.BearerToken)
+	}
+}
+
+// savedCookie is one line of cookie_jar.jsonl: a cookie as the Set-Cookie
+// header that set it, and the URL it came from
+type savedCookie struct {
+	URL       string `json:"url"`
+	SetCookie string `json:"set_cookie"`
+}
+
+// recordingJar is a cookie jar that also remembers every cookie it was
+// given, since a cookiejar.Jar can't list its cookies to save them
+type recordingJar struct {
+	*cookiejar.Jar
+	mu    sync.Mutex
+	saved map[string]savedCookie
+}
+
+func newRecordingJar() (*recordingJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	return &recordingJar{Jar: jar, saved: make(map[string]savedCookie)}, nil
+}
+
+func (j *recordingJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	origin := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+	for _, ck := range cookies {
+		domain := ck.Domain
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		key := strings.ToLower(strings.TrimPrefix(domain, ".")) + "\t" + ck.Path + "\t" + ck.Name
+		if ck.MaxAge < 0 || (!ck.Expires.IsZero() && ck.Expires.Before(time.Now())) {
+			delete(j.saved, key)
+			continue
+		}
+		// Max-Age would restart counting when the jar is loaded again
+		kept := *ck
+		if kept.MaxAge > 0 {
+			kept.Expires, kept.MaxAge = time.Now().Add(time.Duration(kept.MaxAge)*time.Second), 0
+		}
+		if kept.Expires.IsZero() {
+			// Session cookies last for the run only
+			delete(j.saved, key)
+			continue
+		}
+		j.saved[key] = savedCookie{URL: origin, SetCookie: kept.String()}
+	}
+}
+
+// save writes the cookies that are still valid to path, readable by the
+// owner only since they may log the crawler in
+func (j *recordingJar) save(path string) error {
+	j.mu.Lock()
+	var lines []byte
+	for _, s := range j.saved {
+		if ck, err := http.ParseSetCookie(s.SetCookie); err == nil && ck.Expires.Before(time.Now()) {
+			continue
+		}
+		line, err := json.Marshal(s)
+		if err != nil {
+			j.mu.Unlock()
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	j.mu.Unlock()
+	if err := writeFileAtomic(path, lines); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// load puts the cookies saved by an earlier run back in the jar
+func (j *recordingJar) load(path string) error {
+	err := readJSONL(path, func(line []byte) {
+		var s savedCookie
+		if json.Unmarshal(line, &s) != nil {
+			return
+		}
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			return
+		}
+		if ck, err := http.ParseSetCookie(s.SetCookie); err == nil {
+			j.SetCookies(u, []*http.Cookie{ck})
+		}
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// setInitialCookies puts Cookies, for the BaseURL host, and the cookies of
+// CookiesFile into the jar
+func (c *Crawler) setInitialCookies(jar http.CookieJar) error {
+	if c.cfg.Cookies != "" {
+		base, err := url.Parse(c.cfg.BaseURL)
+		if err != nil {
+			return err
+		}
+		cookies, err := http.ParseCookie(c.cfg.Cookies)
+		if err != nil {
+			return fmt.Errorf("Cookies: %w", err)
+		}
+		for _, ck := range cookies {
+			ck.Path = "/"
+		}
+		jar.SetCookies(&url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/"}, cookies)
+	}
+	if c.cfg.CookiesFile != "" {
+		if err := loadCookiesTxt(jar, c.cfg.CookiesFile); err != nil {
+			return fmt.Errorf("CookiesFile: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadCookiesTxt reads a cookies.txt in the Netscape format browsers
+// export: domain, subdomains flag, path, secure flag, expiry, name and
+// value, tab-separated, with "#HttpOnly_" before the domain of HTTP-only
+// cookies
+func loadCookiesTxt(jar http.CookieJar, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line, httpOnly = rest, true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: want 7 tab-separated fields, got %d", path, n, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: bad expiry %q", path, n, fields[4])
+		}
+		host := strings.TrimPrefix(fields[0], ".")
+		ck := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			ck.Domain = host
+		}
+		if expiry > 0 {
+			ck.Expires = time.Unix(expiry, 0)
+		}
+		scheme := "http"
+		if ck.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: ck.Path}, []*http.Cookie{ck})
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,1308 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (c *Crawler) ensureFoldersAndFiles() {
+	os.MkdirAll(filepath.Join(c.downloadedFilesFolderName, assetsSubfolder), os.ModePerm)
+	for _, f := range []string{c.redirectsFileName, c.externalFileName} {
+		if _, err := os.Stat(f); os.IsNotExist(err) {
+			os.WriteFile(f, []byte(""), 0644)
+		}
+	}
+}
+
+func readLines(filepath string) ([]string, error) {
+	var lines []string
+	file, err := os.Open(filepath)
+	if err != nil {
+		return lines, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// lineKey is the URL a state file line is about. Lines may carry extra
+// tab-separated fields after it (depth, failure reason).
+func lineKey(line string) string {
+	key, _, _ := strings.Cut(line, "\t")
+	return key
+}
+
+func (c *Crawler) scrapeAndSave(ctx context.Context, job Job) (links []string, err error) {
+	url, depth := job.URL, job.Depth
+	log := c.logFrom(ctx)
+	log.Debug("fetching page", "depth", depth)
+	started := time.Now()
+
+	previous, seen := c.pageManifest.lookup(url)
+	var conditional http.Header
+	if c.cfg.Recrawl && seen {
+		conditional = c.conditionalHeaders(previous)
+	}
+
+	// A recrawl knows the type already and asks conditionally anyway
+	if conditional == nil && c.probing() {
+		probed, ok, err := c.probeHead(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			reason, err := c.probeSkip(url, probed)
+			if err != nil {
+				c.probes.forget(url)
+				return nil, err
+			}
+			if reason != "" {
+				c.probes.forget(url)
+				c.probes.countType(probed.contentType)
+				log.Debug("skipped", "reason", reason, "content_type", probed.contentType, "probe", http.MethodHead)
+				c.pageDone(ctx, log, pageRecord{URL: url, Status: probed.status, ContentType: probed.contentType,
+					ContentLength: max(probed.length, 0), FetchedAt: started.UTC(), Depth: depth, Order: job.Order,
+					Referrer: job.Referrer, Probed: true}, started, "", nil)
+				return nil, nil
+			}
+		}
+	}
+
+	ctx, redirects := withRedirectChain(ctx)
+	resp, err := c.fetchWithRetry(ctx, url, conditional)
+	// The probe is kept for a job that is re-queued
+	var throttled *throttledError
+	if !errors.As(err, &throttled) {
+		c.probes.forget(url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Servers that don't answer HEAD truthfully still say the size here
+	if c.cfg.MaxBodySize > 0 && resp.ContentLength > c.cfg.MaxBodySize {
+		return nil, errBodyTooLarge
+	}
+
+	record := pageRecord{
+		URL:       url,
+		FinalURL:  resp.Request.URL.String(),
+		Status:    resp.StatusCode,
+		FetchedAt: started.UTC(),
+		Depth:     depth,
+		Order:     job.Order,
+		Referrer:  job.Referrer,
+		Source:    job.Source,
+		Chain:     job.Chain,
+		UserAgent: resp.Request.Header.Get("User-Agent"),
+	}
+	if record.FinalURL == url {
+		record.FinalURL = ""
+	}
+	record.DisplayURL = displayURL(url)
+	record.Redirects = redirects.list()
+	c.noteServedPlain(url, record.Redirects, resp.StatusCode)
+	// sum is the saved body's SHA-256, for OnPage and the page events
+	sum := ""
+	if c.cfg.RecordHeaders {
+		record.Headers = resp.Header
+	}
+
+	// A redirect within scope means the page is really the final URL; if
+	// that has been saved already there is nothing new here
+	finalURL := ""
+	if normalized, err := c.normalizeURL(resp.Request.URL.String()); err == nil && normalized != url {
+		finalURL = normalized
+		c.recordRedirect(url, finalURL, "")
+		if c.Frontier.IsScraped(finalURL) {
+			log.Debug("skipped", "reason", "redirects to a scraped URL", "final_url", finalURL)
+			return nil, nil
+		}
+	}
+	defer func() {
+		if err == nil {
+			c.pageDone(ctx, log, record, started, sum, links)
+		}
+	}()
+
+	// Unchanged since the last crawl: keep the saved file, whose links are
+	// already in the found file
+	if resp.StatusCode == http.StatusNotModified {
+		previous.CheckedAt = started.UTC()
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			previous.ETag = etag
+		}
+		c.noteStored(&previous)
+		if err := c.pageManifest.record(previous); err != nil {
+			return nil, err
+		}
+		c.pagesUnchanged.Add(1)
+		record.ContentType = previous.ContentType
+		record.File = previous.File
+		record.ContentLength = previous.Size
+		sum = previous.SHA256
+		return nil, nil
+	}
+
+	// The raw copy, if wanted, is teed off before decoding and only kept
+	// once the decoded page has been saved
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	wire, captured, err := c.archiveCapture(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if captured != nil {
+		defer captured.discard()
+	}
+	archived := wire
+	var raw *sidecar
+	if c.cfg.KeepRawEncoded && rawExtension(contentEncoding) != "" {
+		raw, err = newSidecar()
+		if err != nil {
+			return nil, err
+		}
+		defer raw.discard()
+		wire = raw.tee(wire)
+	}
+	decoded, err := decodeBody(wire, contentEncoding)
+	if err != nil {
+		return nil, &parseError{err}
+	}
+
+	buffered := bufio.NewReader(decoded)
+	contentType := responseContentType(resp, buffered)
+	record.ContentType = contentType
+	c.probes.countType(contentType)
+	isHTML := isHTMLType(contentType)
+	document := c.documents != nil && c.isDocument(url, contentType)
+	if !isHTML && !document && (!c.cfg.SaveNonHTML || !c.contentTypeAllowed(contentType)) {
+		log.Debug("skipped", "reason", "content type", "content_type", contentType)
+		return nil, nil
+	}
+
+	// Anything that isn't HTML is kept under assets/, or documents/ for
+	// the DocumentTypes, and never parsed
+	fileName := pageFileName(url, extensionFor(contentType))
+	switch {
+	case document:
+		fileName = c.documents.reserve(url, contentType)
+		defer func() {
+			if err != nil {
+				c.documents.release(fileName, url)
+			}
+		}()
+	case !isHTML:
+		fileName = path.Join(assetsSubfolder, fileName)
+	}
+	meta := PageMeta{URL: url, ContentType: contentType, Header: resp.Header}
+
+	// HTML is transcoded to UTF-8 using a BOM, the header charset or a
+	// <meta charset>, as htmlEncoding has it; the original bytes can be
+	// kept too
+	var body io.Reader = buffered
+	var original *sidecar
+	charsetName := ""
+	if isHTML {
+		head, _ := buffered.Peek(1024)
+		enc, name := htmlEncoding(head, resp.Header.Get("Content-Type"))
+		if name != "utf-8" {
+			charsetName = name
+			if c.cfg.KeepOriginalCharset {
+				original, err = newSidecar()
+				if err != nil {
+					return nil, err
+				}
+				defer original.discard()
+				body = original.tee(body)
+			}
+			body = enc.NewDecoder().Reader(body)
+		}
+	}
+
+	// Stream the body straight to storage so memory use doesn't grow with
+	// the page size, giving up once it passes MAX_BODY_SIZE
+	if c.cfg.MaxBodySize > 0 {
+		body = &limitedBody{r: body, remaining: c.cfg.MaxBodySize}
+	}
+	hashed := newHashingReader(body)
+	size, err := c.Storage.SavePage(fileName, meta, &ctxReader{ctx: ctx, r: hashed})
+	if err != nil {
+		return nil, err
+	}
+	bodySum := hashed.sum()
+
+	// An app shell is replaced with what Chrome makes of it, which is
+	// UTF-8 and has no raw or untranscoded copy to go with it
+	if isHTML && c.renderer != nil {
+		pc := pageContext{URL: resp.Request.URL.String(), Header: resp.Header}
+		n, renderedSum, rendered, err := c.renderPage(ctx, job, pc, fileName, meta, resp.Request.Header.Get("User-Agent"), &record)
+		if err != nil {
+			return nil, err
+		}
+		if rendered {
+			size, bodySum, record.Rendered = n, renderedSum, true
+			charsetName, raw, original = "", nil, nil
+		}
+	}
+
+	// A page in a language that isn't wanted, or with SkipNonCanonical one
+	// that names another page as canonical, is dropped as soon as that is
+	// known
+	var parsed *pageInfo
+	if isHTML && (len(c.cfg.AllowedLanguages) > 0 || c.cfg.SkipNonCanonical) {
+		if parsed, err = c.parseSaved(resp, fileName); err != nil {
+			return nil, err
+		}
+		record.Language, record.LanguageSource = detectLanguage(parsed.doc, resp.Header)
+		if !c.languageAllowed(record.Language) {
+			return c.skipLanguage(ctx, job, &record, parsed, fileName)
+		}
+		if c.cfg.SkipNonCanonical {
+			canonical, inScope := c.pageCanonical(log, url, finalURL, parsed)
+			if inScope {
+				return c.skipNonCanonical(ctx, job, &record, parsed, fileName, canonical)
+			}
+			if canonical != "" {
+				c.recordCanonical(url, canonical, false)
+			}
+		}
+	}
+	c.bodiesSaved.Add(1)
+	c.bytesSaved.Add(size)
+	c.archive(resp, captured, archived, started)
+	entry := manifestEntry{
+		URL:          url,
+		File:         fileName,
+		Size:         size,
+		ContentType:  contentType,
+		Charset:      charsetName,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CheckedAt:    started.UTC(),
+		SHA256:       bodySum,
+		Rendered:     record.Rendered,
+	}
+
+	// A document identical to one saved from another URL is always stored
+	// once, and listed in documents.csv as an alias of it
+	if document {
+		row, dropped, err := c.documents.record(documentRow{URL: url, Referrer: job.Referrer, File: fileName,
+			Size: size, ContentType: contentType, SHA256: entry.SHA256})
+		if err != nil {
+			return nil, err
+		}
+		if dropped != "" {
+			if err := c.removeDuplicateCopy(dropped, manifestEntry{File: row.File}); err != nil {
+				return nil, err
+			}
+			log.Debug("duplicate", "of", row.AliasOf)
+			fileName = row.File
+			entry.File = row.File
+			entry.DuplicateOf = row.AliasOf
+			raw = nil
+		}
+	}
+
+	// An identical body already saved becomes the file for this URL too,
+	// along with its raw and original copies
+	if canonical, ok := c.pageManifest.canonical(entry.SHA256, url); ok && c.cfg.DedupContent && !document {
+		if err := c.removeDuplicateCopy(fileName, canonical); err != nil {
+			return nil, err
+		}
+		log.Debug("duplicate", "of", canonical.URL)
+		fileName = canonical.File
+		entry.File = canonical.File
+		entry.DuplicateOf = canonical.URL
+		entry.RawFile, entry.ContentEncoding = canonical.RawFile, canonical.ContentEncoding
+		entry.OriginalFile = canonical.OriginalFile
+		raw, original = nil, nil
+	}
+	if raw != nil {
+		entry.RawFile = fileName + rawExtension(contentEncoding)
+		entry.ContentEncoding = contentEncoding
+		if err := raw.commit(c.Storage, entry.RawFile, meta); err != nil {
+			return nil, err
+		}
+	}
+	if original != nil {
+		ext := path.Ext(fileName)
+		entry.OriginalFile = strings.TrimSuffix(fileName, ext) + ".orig" + ext
+		if err := original.commit(c.Storage, entry.OriginalFile, meta); err != nil {
+			return nil, err
+		}
+	}
+	c.noteStored(&entry)
+	if err := c.pageManifest.record(entry); err != nil {
+		return nil, err
+	}
+	if seen {
+		c.pagesUpdated.Add(1)
+	} else {
+		c.pagesNew.Add(1)
+	}
+	if finalURL != "" {
+		alias := entry
+		alias.URL = finalURL
+		if err := c.pageManifest.record(alias); err != nil {
+			return nil, err
+		}
+		_ = c.Frontier.MarkScraped(finalURL)
+	}
+	record.File = fileName
+	record.ContentLength = size
+	sum = entry.SHA256
+	if !isHTML {
+		return nil, nil
+	}
+	if c.soft404 != nil && strings.EqualFold(resp.Request.URL.Host, c.baseHost) {
+		soft, err := c.isSoft404(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if soft {
+			log.Debug("soft 404", "title", c.soft404.title)
+			record.Soft404 = true
+			return nil, nil
+		}
+	}
+
+	info := parsed
+	if info == nil {
+		if info, err = c.parseSaved(resp, fileName); err != nil {
+			return nil, err
+		}
+		record.Language, record.LanguageSource = detectLanguage(info.doc, resp.Header)
+	}
+	pages := c.recordPageLinks(url, info)
+	c.storeScriptLinks(job, info.ScriptLinks)
+	c.storePagination(job, info.Next)
+	c.storeTranslations(job, info)
+	if c.cfg.ExtractText != "" {
+		if record.TextFile, record.WordCount, err = c.saveText(fileName, meta, info.doc); err != nil {
+			return nil, err
+		}
+	}
+	// SkipNonCanonical has looked at the canonical already
+	if c.cfg.RespectCanonical && !c.cfg.SkipNonCanonical {
+		if canonical, inScope := c.pageCanonical(log, url, finalURL, info); canonical != "" {
+			c.recordCanonical(url, canonical, inScope)
+		}
+	}
+	describePage(&record, info, pages)
+	return pages, nil
+}
+
+// describePage copies what parsing a page found into its record, pages
+// being the links recordPageLinks returned for it
+func describePage(record *pageRecord, info *pageInfo, pages []string) {
+	record.Title = info.Title
+	record.NoFollow = info.NoFollow
+	record.Links = len(pages) + len(info.ScriptLinks)
+	record.Assets = len(info.Assets)
+	record.Canonical = info.Canonical
+	record.pageMetadata = info.Metadata
+	record.Alternates = info.Alternates
+	record.Next, record.Prev = info.Next, info.Prev
+	record.MixedContent = info.Insecure
+	record.LinkSchemes, record.MalformedLinks = info.LinkSchemes, len(info.Malformed)
+	record.Contacts = info.Contacts
+}
+
+// parseSaved parses the page just saved as fileName. Links are resolved
+// against where the request ended up: normalization strips trailing
+// slashes, so "/docs" is usually served from "/docs/" after a redirect.
+func (c *Crawler) parseSaved(resp *http.Response, fileName string) (*pageInfo, error) {
+	saved, err := c.Storage.LoadPage(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer saved.Close()
+	info, err := c.extractLinksFromHTML(pageContext{URL: resp.Request.URL.String(), Header: resp.Header}, saved)
+	if err != nil {
+		return nil, &parseError{err}
+	}
+	return info, nil
+}
+
+// dropSaved removes the file a page that isn't kept after all was saved
+// as, and its screenshot
+func (c *Crawler) dropSaved(record *pageRecord, fileName string) error {
+	for _, name := range []string{fileName, record.Screenshot} {
+		if name == "" {
+			continue
+		}
+		if err := c.Storage.Remove(name); err != nil {
+			return err
+		}
+	}
+	record.Screenshot, record.ScreenshotWidth, record.ScreenshotHeight = "", 0, 0
+	return nil
+}
+
+// skipLanguage drops a page saved as fileName that is in none of the
+// AllowedLanguages, keeping its translations into them and, with
+// FollowOtherLanguages, its links
+func (c *Crawler) skipLanguage(ctx context.Context, job Job, record *pageRecord, info *pageInfo, fileName string) ([]string, error) {
+	if err := c.dropSaved(record, fileName); err != nil {
+		return nil, err
+	}
+	record.Title = info.Title
+	c.logFrom(ctx).Debug("skipped", "reason", "language", "language", record.Language)
+	c.storeTranslations(job, info)
+	if !c.cfg.FollowOtherLanguages {
+		return nil, nil
+	}
+	pages := c.recordPageLinks(job.URL, info)
+	c.storeScriptLinks(job, info.ScriptLinks)
+	c.storePagination(job, info.Next)
+	record.NoFollow = info.NoFollow
+	record.Links = len(pages) + len(info.ScriptLinks)
+	return pages, nil
+}
+
+// pageDone records a page fetched, saved or not, and hands it to OnPage
+// and the page events
+func (c *Crawler) pageDone(ctx context.Context, log *slog.Logger, record pageRecord, started time.Time, sum string, links []string) {
+	record.DurationMS = time.Since(started).Milliseconds()
+	_ = c.pageRecords.write(record)
+	if record.File != "" {
+		msg := "page saved"
+		if record.Status == http.StatusNotModified {
+			msg = "page unchanged"
+		}
+		log.Log(ctx, c.pageLevel(), msg, "status", record.Status, "duration_ms", record.DurationMS,
+			"bytes", record.ContentLength, "file", record.File)
+	}
+	if c.OnPage == nil && c.events == nil {
+		return
+	}
+	page := PageResult{
+		URL:         record.URL,
+		FinalURL:    record.FinalURL,
+		Status:      record.Status,
+		Depth:       record.Depth,
+		ContentType: record.ContentType,
+		Title:       record.Title,
+		File:        record.File,
+		SHA256:      sum,
+		Links:       links,
+		Bytes:       record.ContentLength,
+		Duration:    time.Duration(record.DurationMS) * time.Millisecond,
+		Canonical:   record.Canonical,
+		Description: record.Description,
+		Lang:        record.Lang,
+		OpenGraph:   record.OpenGraph,
+		Twitter:     record.Twitter,
+	}
+	if c.OnPage != nil {
+		c.OnPage(page)
+	}
+	c.publishPage(log, page, record.FetchedAt)
+}
+
+// recordLinks parses a saved page, records its edges, external links and
+// assets, and returns the pages to crawl from it, those in info.ScriptLinks
+// aside
+func (c *Crawler) recordLinks(url string, pc pageContext, page io.Reader) (*pageInfo, []string, error) {
+	info, err := c.extractLinksFromHTML(pc, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, c.recordPageLinks(url, info), nil
+}
+
+// recordPageLinks is recordLinks for a page parsed already
+func (c *Crawler) recordPageLinks(url string, info *pageInfo) []string {
+	if info.NoFollow {
+		c.log().Debug("not following links", "url", url, "reason", "nofollow")
+	}
+	// Frames are pages in their own right and are crawled like links, and
+	// so is an in-scope canonical URL, which may not be linked at all
+	pages := append(info.Links, info.Frames...)
+	if info.Canonical != "" && !info.NoFollow && c.inScope(info.Canonical) {
+		pages = append(pages, info.Canonical)
+	}
+	if info.Refresh != "" {
+		c.recordRefresh(url, info)
+	}
+	c.recordEdges(url, append(pages[:len(pages):len(pages)], info.ScriptLinks...))
+	c.recordLinksFrom(c.externalFile, info.External, url)
+	c.recordLinksFrom(c.assetURLsFile, info.Assets, url)
+	c.recordImages(info.Images, url)
+	c.recordMalformed(url, info.Malformed)
+	c.applyExtractRules(url, info.doc)
+	c.applyStructuredData(url, info.doc)
+	return pages
+}
+
+// errBodyTooLarge is returned when a response passes MAX_BODY_SIZE
+var errBodyTooLarge = errors.New("response body exceeds MAX_BODY_SIZE")
+
+// limitedBody fails with err, or errBodyTooLarge if that is nil, instead
+// of silently truncating like io.LimitReader
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only an error if there actually is more to read
+		var probe [1]byte
+		if n, _ := l.r.Read(probe[:]); n > 0 {
+			if l.err != nil {
+				return 0, l.err
+			}
+			return 0, errBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// ctxReader stops a copy as soon as ctx is done, so a cancelled URL doesn't
+// leave a page behind
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// newHTTPClient builds the client shared by all workers. The overall
+// timeout caps a whole request; the transport timeouts stop a dead host from
+// holding a worker during connect or while waiting for headers.
+func (c *Crawler) newHTTPClient(timeout time.Duration) *http.Client {
+	var transport http.RoundTripper = c.newTransport(timeout)
+	if c.proxies != nil {
+		transport = c.proxies
+	}
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: c.checkRedirect,
+		Jar:           c.jar,
+	}
+}
+
+// newTransport is the default transport with the timeouts, TLS options,
+// HostMapping and connection pool settings applied. It is built once and
+// shared by the workers, or once per proxy. It goes through HTTP_PROXY and
+// HTTPS_PROXY when they are set.
+func (c *Crawler) newTransport(timeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = c.mappedDial((&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext)
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	if c.cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.cfg.MaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = c.cfg.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = c.cfg.MaxConnsPerHost
+	if c.cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = c.cfg.IdleConnTimeout
+	}
+	transport.ForceAttemptHTTP2 = c.cfg.ForceAttemptHTTP2
+	if !c.cfg.ForceAttemptHTTP2 {
+		// A non-nil empty map is what turns HTTP/2 off
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig
+	}
+	return transport
+}
+
+type Job struct {
+	URL      string
+	Depth    int
+	Referrer string
+	Asset    bool
+	Image    bool
+	Source   string
+	// Chain is how many rel="next" steps led to the page
+	Chain int
+	// Order is the job's place in the run's dispatch order, from 1
+	Order int64
+}
+
+// worker scrapes jobs until the channel is closed. ctx bounds the requests
+// themselves; it is only cancelled once the shutdown grace period is over.
+func (c *Crawler) worker(ctx context.Context, id int, jobs <-chan Job, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	workerLog := c.logFrom(ctx).With("worker", id)
+	var lastRequest time.Time
+	for job := range jobs {
+		// A worker over the adaptive limit holds its job until a slot frees;
+		// on shutdown the job stays pending
+		if c.gate.acquire(ctx) != nil {
+			continue
+		}
+		c.runJob(ctx, id, workerLog.With("url", job.URL), job, &lastRequest)
+		c.gate.release()
+	}
+}
+
+// runJob fetches one job for worker id, whose last request was at
+// *lastRequest
+func (c *Crawler) runJob(ctx context.Context, id int, log *slog.Logger, job Job, lastRequest *time.Time) {
+	// While the site is down the rest of the batch stays pending
+	if c.breaker.isOpen() {
+		return
+	}
+	delay := c.cfg.RequestDelay
+	// Disallowed URLs are marked as scraped so the loop still terminates
+	rules, allowed := c.robotsAllowed(ctx, job.URL)
+	if !allowed {
+		log.Debug("skipped", "reason", "disallowed by robots.txt")
+		_ = c.Frontier.MarkScraped(job.URL)
+		return
+	}
+	// Crawl budgets weigh pages only; a URL over its budget is skipped for
+	// good, like a disallowed one
+	budget := -1
+	if !job.Asset {
+		var ok bool
+		if budget, ok = c.takeBudget(job.URL); !ok {
+			log.Debug("skipped", "reason", "over crawl budget", "budget", c.cfg.CrawlBudgets[budget].Pattern.String())
+			c.skipOverBudget(job.URL, budget)
+			return
+		}
+	}
+	// release gives back the page reserved for the job, and its budget,
+	// when the URL wasn't crawled after all
+	release := func() {
+		c.releasePage()
+		c.returnBudget(budget)
+	}
+	// Once a limit is hit the remaining jobs are drained untouched and
+	// stay pending for the next run
+	if c.limitReached() != "" || !c.reservePage() {
+		c.returnBudget(budget)
+		return
+	}
+	if delay > 0 {
+		if sleepCtx(ctx, time.Until(lastRequest.Add(delay))) != nil {
+			release()
+			return
+		}
+	}
+	// Crawl-delay from robots.txt spaces all workers' requests to the host
+	if rules != nil && c.throttle.space(ctx, hostOf(job.URL), rules.crawlDelay) != nil {
+		release()
+		return
+	}
+	*lastRequest = time.Now()
+
+	var newLinks []string
+	var err error
+	jobCtx := withWorker(withLogger(ctx, log), id)
+	switch {
+	case job.Image:
+		err = c.scrapeImage(jobCtx, job)
+	case job.Asset:
+		err = c.scrapeAsset(jobCtx, job)
+	default:
+		newLinks, err = c.scrapeAndSave(jobCtx, job)
+	}
+	if err != nil && ctx.Err() != nil {
+		// Interrupted by shutdown, not a real failure: leave it pending
+		log.Info("abandoned", "error", err)
+		release()
+		return
+	}
+	var throttled *throttledError
+	var offSite *offSiteRedirectError
+	if errors.As(err, &offSite) {
+		// Not a failure, just not ours to crawl
+		log.Debug("skipped", "reason", "redirected out of scope", "location", offSite.to)
+		c.recordRedirect(job.URL, offSite.to, "out-of-scope")
+		_ = c.Frontier.MarkScraped(job.URL)
+		release()
+		return
+	}
+	if errors.As(err, &throttled) {
+		n := c.throttle.requeue(job.URL)
+		if n <= c.cfg.MaxThrottles {
+			// A 503 also says the site is in trouble, so a host that
+			// answers nothing else opens the circuit breaker
+			if throttled.code == http.StatusServiceUnavailable {
+				c.breakerObserve(job.URL, &statusError{code: throttled.code})
+			}
+			log.Warn("re-queued", "error", err, "throttled", n)
+			release()
+			return
+		}
+		// Given up on, it fails like any other status
+		c.throttle.forget(job.URL)
+		err = &fetchError{class: classify(&statusError{code: throttled.code}), attempts: n,
+			err: fmt.Errorf("%w, throttled %d times", &statusError{code: throttled.code}, n)}
+	}
+	if c.breakerObserve(job.URL, err) {
+		log.Warn("re-queued", "reason", "circuit breaker open", "error", err)
+		release()
+		return
+	}
+	if err != nil {
+		log.Error("failed", "class", classify(err), "error", err)
+		_ = c.recordFailure(job.URL, job.Referrer, err)
+		return
+	}
+
+	c.throttle.forget(job.URL)
+	c.finishPage(job, newLinks)
+	if done := c.pagesDone.Add(1); c.cfg.WebhookMilestone > 0 && done%int64(c.cfg.WebhookMilestone) == 0 {
+		c.notify(EventMilestone, fmt.Sprintf("%d pages", done))
+	}
+}
+
+// finishPage stores the links found on a scraped page, then marks the page
+// done
+func (c *Crawler) finishPage(job Job, links []string) {
+	// Pages at the depth limit are saved, but their links go no further
+	if c.cfg.MaxDepth >= 0 && job.Depth >= c.cfg.MaxDepth {
+		links = nil
+	}
+	c.storeURLs(links, FrontierEntry{Depth: job.Depth + 1, Referrer: job.URL})
+	_ = c.Frontier.MarkScraped(job.URL)
+}
+
+// storeScriptLinks stores the links found in a page's inline scripts,
+// marked with sourceScript, as finishPage stores the others
+func (c *Crawler) storeScriptLinks(job Job, links []string) {
+	if len(links) == 0 || (c.cfg.MaxDepth >= 0 && job.Depth >= c.cfg.MaxDepth) {
+		return
+	}
+	c.storeURLs(links, FrontierEntry{Depth: job.Depth + 1, Referrer: job.URL, Source: sourceScript})
+}
+
+// robotsAllowed reports whether robots.txt lets rawURL be fetched, along
+// with the rules of its host; they are nil when robots.txt is ignored
+func (c *Crawler) robotsAllowed(ctx context.Context, rawURL string) (*robotsRules, bool) {
+	if !c.cfg.RespectRobots {
+		return nil, true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, true
+	}
+	rules := c.robotsFor(ctx, u)
+	return rules, rules.allowed(u)
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// storeURLs adds the http(s) URLs that pass the include/exclude filters
+// and the trap rules to the found file, along with the page they were found on, if
+// any, and where on it, if not in the markup
+func (c *Crawler) storeURLs(urls []string, found FrontierEntry) {
+	referrer := found.Referrer
+	added := 0
+	for _, url := range urls {
+		normalized, err := c.normalizeURL(url)
+		if err != nil || !isWebURL(normalized) || !c.passesFilters(normalized) {
+			continue
+		}
+		rule, detail, reserved := c.trapRule(normalized)
+		if rule != "" {
+			if !c.Frontier.IsScraped(normalized) {
+				c.rejectTrap(normalized, referrer, rule, detail)
+			}
+			continue
+		}
+		if referrer != "" {
+			c.recordReferrer(normalized, referrer)
+		}
+		found.URL, found.Priority = normalized, c.priority(normalized)
+		if ok, _ := c.Frontier.Add(found); ok {
+			added++
+		} else if reserved != "" {
+			c.unreserve(reserved)
+		}
+	}
+	if n := c.cfg.WarnNewURLs; n > 0 && added > n && referrer != "" {
+		c.log().Warn("page yields many new URLs, possibly a crawler trap", "url", referrer, "new_urls", added)
+	}
+}
+
+// storeSeed adds url to the found file at depth 0. Seeds are chosen by the
+// user, so they bypass the filters.
+func (c *Crawler) storeSeed(url string) error {
+	normalized, err := c.normalizeURL(url)
+	if err != nil {
+		return err
+	}
+	_, err = c.Frontier.Add(FrontierEntry{URL: normalized, Priority: c.priority(normalized)})
+	return err
+}
+
+// crawlStatus counts the frontier and the asset inventory together,
+// returning the assets still to download too since they are dispatched
+// after the pages. Failed URLs count as done so one bad page can't keep
+// the crawl alive.
+func (c *Crawler) crawlStatus() (Status, []FrontierEntry) {
+	fs := c.Frontier.Stats()
+	assets := c.pendingAssets()
+	return Status{
+		Found:   fs.Found + len(assets),
+		Scraped: fs.Scraped,
+		Failed:  fs.Failed,
+		Pending: fs.Pending + len(assets),
+	}, assets
+}
+
+func (c *Crawler) printConfig() {
+	attrs := []any{"base_url", c.cfg.BaseURL}
+	if c.cfg.MaxDepth >= 0 {
+		attrs = append(attrs, "max_depth", c.cfg.MaxDepth)
+	}
+	if c.cfg.MaxPages > 0 {
+		attrs = append(attrs, "max_pages", c.cfg.MaxPages)
+	}
+	if c.cfg.MaxDuration > 0 {
+		attrs = append(attrs, "max_duration", c.cfg.MaxDuration)
+	}
+	if c.cfg.Deadline > 0 {
+		attrs = append(attrs, "deadline", c.cfg.Deadline)
+	}
+	attrs = append(attrs, "order", c.cfg.CrawlOrder)
+	if len(c.cfg.PriorityRules) > 0 {
+		attrs = append(attrs, "priority_rules", len(c.cfg.PriorityRules))
+	}
+	if len(c.cfg.CrawlBudgets) > 0 {
+		attrs = append(attrs, "crawl_budgets", len(c.cfg.CrawlBudgets))
+	}
+	if c.cfg.Schedule != nil {
+		attrs = append(attrs, "schedule", c.cfg.Schedule.String())
+	}
+	attrs = append(attrs, "workers", c.cfg.Workers, "request_timeout", c.cfg.RequestTimeout, "request_delay", c.cfg.RequestDelay)
+	if c.gate != nil {
+		attrs = append(attrs, "adaptive_workers", fmt.Sprintf("%d-%d", c.cfg.MinWorkers, c.cfg.MaxWorkers))
+	}
+	if c.cfg.MaxBandwidth > 0 {
+		attrs = append(attrs, "max_bandwidth", formatSize(c.cfg.MaxBandwidth)+"/s")
+	}
+	if c.limiter != nil {
+		attrs = append(attrs, "rate_limit", c.cfg.MaxRequestsPerSecond)
+	} else {
+		attrs = append(attrs, "rate_limit", "unlimited")
+	}
+	attrs = append(attrs, "max_attempts", c.cfg.MaxAttempts, "retry_base_delay", c.cfg.RetryBaseDelay,
+		"max_idle_conns_per_host", c.cfg.MaxIdleConnsPerHost)
+	c.log().Info("config", attrs...)
+	if c.cfg.TLSInsecureSkipVerify {
+		c.log().Warn("TLS certificate verification is OFF: any server can pose as the site")
+	}
+}
+
+// frontierBatchSize is how many pending pages one pass of the crawl loop
+// dispatches
+const frontierBatchSize = 1000
+
+// Run crawls until nothing is pending, a limit is hit or ctx is done, then
+// writes the end-of-crawl reports. Cancelling ctx stops new URLs being
+// dispatched; requests in flight get ShutdownTimeout to finish before they
+// are aborted too.
+func (c *Crawler) Run(ctx context.Context) (err error) {
+	if c.cfg.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Deadline)
+		defer cancel()
+	}
+	// The run ID goes on every log record, so one run can be picked out
+	now := time.Now()
+	c.run = runRecord{ID: newRunID(now), StartedAt: now.UTC()}
+	c.runLog.Store(c.baseLog().With("run", c.run.ID))
+	log := c.log()
+	c.hooks.start(log)
+	defer func() {
+		c.notifyEnd(err)
+		c.hooks.stop()
+	}()
+
+	crawlCtx, fetchCtx, stop := c.shutdownContexts(ctx)
+	defer stop()
+
+	c.printConfig()
+	if c.renderer != nil && c.renderer.chrome == "" {
+		log.Warn("rendering is off, saving pages as served", "error", errNoChrome)
+	}
+	if !c.cfg.SkipPreflight {
+		if err := c.preflight(fetchCtx); err != nil {
+			return err
+		}
+	}
+
+	// Nothing in the project folder is touched before the lock is held
+	if err := os.MkdirAll(c.cfg.ProjectFolder, os.ModePerm); err != nil {
+		return err
+	}
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	// Saved cookies go in first so the configured ones win
+	if c.cfg.PersistCookies {
+		if err := c.jar.load(c.cookieJarFileName); err != nil {
+			return fmt.Errorf("loading %s: %w", c.cookieJarFileName, err)
+		}
+		defer func() {
+			if err := c.jar.save(c.cookieJarFileName); err != nil {
+				log.Error("saving cookies", "error", err)
+			}
+		}()
+	}
+	if err := c.setInitialCookies(c.jar); err != nil {
+		return err
+	}
+	if c.cfg.Login.URL != "" {
+		if err := c.login(fetchCtx); err != nil {
+			return err
+		}
+	}
+
+	// Ensure folders and files, then proceed with scraping logic
+	if c.cfg.RespectRobots {
+		base, err := url.Parse(c.cfg.BaseURL)
+		if err != nil {
+			return err
+		}
+		// Other hosts' robots.txt is fetched when their first URL comes up
+		c.robotsFor(fetchCtx, base)
+	}
+
+	c.ensureFoldersAndFiles()
+	if err := c.migrateNumericFiles(); err != nil {
+		return fmt.Errorf("building manifest: %w", err)
+	}
+	if err := c.openManifest(); err != nil {
+		return err
+	}
+	defer c.pageManifest.close()
+	c.pageRecords, err = openJSONL(c.pagesFileName)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", c.pagesFileName, err)
+	}
+	defer c.pageRecords.close()
+	c.deadLetters, err = openJSONL(c.deadLettersFileName)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", c.deadLettersFileName, err)
+	}
+	defer c.deadLetters.close()
+	c.linkEdges, err = openJSONL(c.edgesFileName)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", c.edgesFileName, err)
+	}
+	defer c.linkEdges.close()
+	if len(c.extractRules) > 0 {
+		c.extracted, err = openJSONL(c.extractedFileName)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", c.extractedFileName, err)
+		}
+		defer func() {
+			c.extracted.close()
+			c.extracted = nil
+		}()
+	}
+	if c.cfg.CrawlImages {
+		c.images, err = openJSONL(c.imagesFileName)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", c.imagesFileName, err)
+		}
+		defer func() {
+			c.images.close()
+			c.images = nil
+		}()
+	}
+	if c.cfg.ExtractStructuredData {
+		c.structured, err = openJSONL(c.structuredFileName)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", c.structuredFileName, err)
+		}
+		defer func() {
+			c.structured.close()
+			c.structured = nil
+		}()
+	}
+	if c.cfg.WARC {
+		c.warc, err = c.openWARC()
+		if err != nil {
+			return fmt.Errorf("opening WARC output: %w", err)
+		}
+		defer func() {
+			if err := c.warc.close(); err != nil {
+				log.Error("closing WARC output", "error", err)
+			}
+			c.warc = nil
+		}()
+	}
+	if err := c.openStateFiles(); err != nil {
+		return fmt.Errorf("opening state files: %w", err)
+	}
+	flushCtx, stopFlushing := context.WithCancel(context.Background())
+	go c.flushStatePeriodically(flushCtx)
+	defer func() {
+		stopFlushing()
+		if err := c.closeStateFiles(); err != nil {
+			log.Error("writing state files", "error", err)
+		}
+	}()
+	if err := c.startEvents(); err != nil {
+		return err
+	}
+	defer c.stopEvents()
+	if len(c.cfg.DocumentTypes) > 0 {
+		c.documents, err = openDocuments(c.documentsFileName)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", c.documentsFileName, err)
+		}
+		defer func() {
+			if err := c.documents.close(); err != nil {
+				log.Error("writing documents index", "error", err)
+			}
+			c.documents = nil
+		}()
+	}
+
+	previous, hasPrevious, err := c.startRun()
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", c.runsFileName, err)
+	}
+	outcome := outcomeCompleted
+	defer func() { c.endRun(outcome, err) }()
+	if err := c.reconcile(previous, hasPrevious); err != nil {
+		return fmt.Errorf("reconciling the frontier: %w", err)
+	}
+	if c.cfg.PathBudget > 0 || len(c.cfg.PathBudgets) > 0 {
+		c.countFound(c.Frontier.Found())
+	}
+
+	if c.cfg.RetryFailed {
+		// Failed URLs are still in the frontier, so forgetting the
+		// failures is enough to have them dispatched again
+		log.Info("retrying failed URLs", "count", len(c.Frontier.Failed()))
+		if err := c.Frontier.ForgetFailed(nil); err != nil {
+			return fmt.Errorf("clearing failed URLs: %w", err)
+		}
+	}
+	if c.cfg.Recrawl {
+		if err := c.startRecrawl(); err != nil {
+			return fmt.Errorf("clearing scraped URLs: %w", err)
+		}
+	}
+	if err := c.startBudgets(); err != nil {
+		return fmt.Errorf("counting crawl budgets: %w", err)
+	}
+	if err := c.reprioritize(); err != nil {
+		return fmt.Errorf("reprioritizing the frontier: %w", err)
+	}
+
+	if err := c.storeSeed(c.cfg.BaseURL); err != nil {
+		return fmt.Errorf("storing BaseURL: %w", err)
+	}
+	for _, seed := range c.cfg.SeedURLs {
+		if err := c.storeSeed(seed); err != nil {
+			return fmt.Errorf("storing seed %s: %w", seed, err)
+		}
+	}
+	if c.cfg.SeedFromSitemap {
+		if err := c.seedFromSitemap(fetchCtx); err != nil {
+			log.Warn("could not seed from sitemap", "error", err)
+		}
+	}
+
+	c.crawlStart = time.Now()
+	c.setLive(true)
+	defer c.setLive(false)
+	c.notify(EventStarted, "")
+	if c.cfg.DetectSoft404 {
+		if err := c.probeSoft404(fetchCtx); err != nil {
+			log.Warn("soft 404 probe failed, not detecting soft 404s", "error", err)
+		}
+	}
+	if c.cfg.ProgressInterval > 0 {
+		progressCtx, stopProgress := context.WithCancel(crawlCtx)
+		defer stopProgress()
+		go c.reportProgress(progressCtx)
+	}
+	if c.gate != nil {
+		adaptCtx, stopAdapting := context.WithCancel(crawlCtx)
+		defer stopAdapting()
+		go c.adaptConcurrency(adaptCtx)
+	}
+	if c.hooks != nil && c.cfg.WebhookErrorRate > 0 {
+		watchCtx, stopWatching := context.WithCancel(crawlCtx)
+		defer stopWatching()
+		go c.watchErrorRate(watchCtx)
+	}
+	for {
+		state, assets := c.crawlStatus()
+		log.Info("status", append([]any{"total", state.Found, "scraped", state.Scraped, "failed", state.Failed,
+			"pending", state.Pending, "req_per_sec", roundRate(c.achievedRate())}, c.conns.attrs()...)...)
+		if state.Pending == 0 {
+			log.Info("crawl completed")
+			break
+		}
+		if err := crawlCtx.Err(); err != nil {
+			outcome = outcomeInterrupted
+			if errors.Is(err, context.DeadlineExceeded) {
+				outcome = outcomeDeadline
+				log.Info("crawl deadline reached, stopping", "deadline", c.cfg.Deadline)
+			}
+			log.Info("crawl interrupted, URLs left pending for the next run", "pending", state.Pending)
+			break
+		}
+		if reason := c.limitReached(); reason != "" {
+			outcome = outcomeLimit
+			log.Info("crawl limit reached, stopping", "limit", reason, "pages", c.pagesStarted.Load(),
+				"elapsed", time.Since(c.crawlStart).Round(time.Second), "pending", state.Pending)
+			break
+		}
+
+		if reason := c.pauseReason(); reason != "" {
+			// Interrupted while paused: the check above stops the loop
+			_ = c.waitWhilePaused(crawlCtx, reason)
+			continue
+		}
+
+		// Dispatch the next batch of pending pages, then the assets
+		workers := c.cfg.Workers
+		if c.gate != nil {
+			workers = c.cfg.MaxWorkers
+		}
+		batchSize := c.batchSize(workers)
+		pages := c.nextBatch(batchSize)
+		batch := append(pages, assets...)
+		jobs := make(chan Job)
+		var wg sync.WaitGroup
+		for w := 1; w <= workers; w++ {
+			wg.Add(1)
+			go c.worker(fetchCtx, w, jobs, &wg)
+		}
+	dispatch:
+		for _, e := range batch {
+			if c.limitReached() != "" || c.breaker.isOpen() || c.pauseReason() != "" {
+				break
+			}
+			select {
+			case jobs <- Job{URL: e.URL, Depth: e.Depth, Referrer: e.Referrer, Asset: e.Asset, Image: e.Image, Source: e.Source, Chain: e.Chain, Order: c.dispatched.Add(1)}:
+			case <-crawlCtx.Done():
+				break dispatch
+			}
+		}
+		close(jobs)
+		wg.Wait()
+
+		if c.breaker.isOpen() {
+			if perr := c.probeOrigin(crawlCtx); errors.Is(perr, ErrOriginDown) {
+				outcome, err = outcomeOriginDown, perr
+				break
+			}
+			// Interrupted while probing: the check above stops the loop
+			continue
+		}
+
+		// Pause before the next iteration to allow updates to the files;
+		// small batches go out back to back as long as they come full
+		if batchSize == frontierBatchSize || len(pages) < batchSize {
+			_ = sleepCtx(crawlCtx, 1*time.Second) // Adjust the duration as necessary
+		}
+	}
+	if c.cfg.Recrawl {
+		c.printRecrawlSummary()
+	}
+	c.logTraps()
+	c.logMalformed()
+	c.run.Outcome = outcome
+	if c.cfg.Markdown {
+		if err := c.writeMarkdown(); err != nil {
+			c.log().Error("converting pages to markdown", "error", err)
+		}
+	}
+	if c.cfg.Snapshots {
+		if err := c.writeSnapshot(); err != nil {
+			c.log().Error("writing snapshot", "error", err)
+		}
+	}
+	if c.cfg.SearchIndex {
+		if err := c.updateSearchIndex(); err != nil {
+			c.log().Error("updating search index", "error", err)
+		}
+	}
+	c.writeReports(fetchCtx)
+	return err
+}
+
+// writeReports writes the broken links, mixed content and duplicates
+// reports from the state files and the manifest
+func (c *Crawler) writeReports(ctx context.Context) {
+	if broken, err := c.writeBrokenLinksReport(ctx, c.brokenLinksFileName); err != nil {
+		c.log().Error("writing broken links report", "error", err)
+	} else if broken > 0 {
+		c.log().Info("broken links", "dead", broken, "file", c.brokenLinksFileName)
+	}
+	if active, other, err := c.writeMixedContentReport(c.mixedContentFileName); err != nil {
+		c.log().Error("writing mixed content report", "error", err)
+	} else if active+other > 0 {
+		c.log().Info("mixed content", "active", active, "passive_or_links", other, "file", c.mixedContentFileName)
+	}
+	if c.cfg.ExtractContacts {
+		if n, err := c.writeContactsReport(c.contactsFileName); err != nil {
+			c.log().Error("writing contacts report", "error", err)
+		} else if n > 0 {
+			c.log().Info("contacts", "count", n, "file", c.contactsFileName)
+		}
+	}
+	if groups, err := c.writeDuplicatesReport(c.duplicatesFileName); err != nil {
+		c.log().Error("writing duplicates report", "error", err)
+	} else if groups > 0 {
+		c.log().Info("duplicates", "groups", groups, "file", c.duplicatesFileName)
+	}
+	if r, err := c.writeReport(); err != nil {
+		c.log().Error("writing report", "error", err)
+	} else {
+		c.report = r
+		c.log().Info("report", "pages", r.Pages, "bytes", r.Bytes, "avg_latency_ms", int64(r.AvgLatencyMS),
+			"p95_latency_ms", r.P95LatencyMS, "failed", r.Failed, "skipped", r.Skipped, "duplicates", r.Duplicates,
+			"file", c.reportFileName)
+	}
+}
@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimitedBody(t *testing.T) {
+	errCustom := errors.New("too big")
+	tests := []struct {
+		name    string
+		body    string
+		limit   int64
+		err     error
+		want    string
+		wantErr error
+	}{
+		{"under the limit", "hello", 10, nil, "hello", nil},
+		{"at the limit", "hello", 5, nil, "hello", nil},
+		{"over the limit", "hello!", 5, nil, "hello", errBodyTooLarge},
+		{"over with its own error", "hello!", 5, errCustom, "hello", errCustom},
+		{"empty", "", 0, nil, "", nil},
+	}
+	for _, tt := range tests {
+		got, err := io.ReadAll(&limitedBody{r: strings.NewReader(tt.body), remaining: tt.limit, err: tt.err})
+		if string(got) != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: read %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestMaxBodySize fails the pages over MaxBodySize, whether the server
+// says their size up front or not, and saves nothing of them
+func TestMaxBodySize(t *testing.T) {
+	const limit = 1 << 10
+	tests := []struct {
+		name          string
+		size          int
+		contentLength bool
+		fail          bool
+	}{
+		{"under with a length", limit / 2, true, false},
+		{"under, chunked", limit / 2, false, false},
+		{"over with a length", 4 * limit, true, true},
+		{"over, chunked", 4 * limit, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := "<html><p>" + strings.Repeat("x", tt.size) + "</p></html>"
+				w.Header().Set("Content-Type", "text/html")
+				if tt.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				} else {
+					w.(http.Flusher).Flush()
+				}
+				io.WriteString(w, body)
+			}))
+			defer srv.Close()
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.MaxBodySize = limit
+				cfg.MaxAttempts = 1
+			})
+			var pages int
+			var failure error
+			c.OnPage = func(PageResult) { pages++ }
+			c.OnError = func(_ string, err error) { failure = err }
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.Run(ctx); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if tt.fail && (pages != 0 || classify(failure) != failBodyTooLarge) {
+				t.Errorf("%d pages saved, failure %v, want it failing as too large", pages, failure)
+			}
+			if !tt.fail && (pages != 1 || failure != nil) {
+				t.Errorf("%d pages saved, failure %v, want the page", pages, failure)
+			}
+		})
+	}
+}
+
+// TestLargeBodyStreamed downloads a response of a few hundred MB: it goes
+// to storage without the heap growing with it
+func TestLargeBodyStreamed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("downloads 256MB")
+	}
+	const size = 256 << 20
+	chunk := make([]byte, 1<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		for sent := 0; sent < size; sent += len(chunk) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	c := newTestCrawler(t, srv.URL+"/big.bin", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.SaveNonHTML = true
+		cfg.DownloadContentTypes = []string{"application/octet-stream"}
+		cfg.MaxBodySize = 2 * size
+	})
+	var saved PageResult
+	c.OnPage = func(p PageResult) { saved = p }
+	c.OnError = func(u string, err error) { t.Errorf("%s: %v", u, err) }
+
+	// The heap is sampled through the download for the most it took
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var m runtime.MemStats
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > peak.Load() {
+				peak.Store(m.HeapInuse)
+			}
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	err := c.Run(ctx)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if saved.Bytes != size {
+		t.Fatalf("saved %d bytes, want %d", saved.Bytes, size)
+	}
+	if grown := int64(peak.Load()) - int64(before.HeapInuse); grown > 64<<20 {
+		t.Errorf("the heap grew by %d MB downloading %d MB", grown>>20, size>>20)
+	}
+}
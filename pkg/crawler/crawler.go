@@ -0,0 +1,1187 @@
+// Package crawler crawls a site from a base URL, saving every in-scope page
+// into a project folder that a later run resumes from.
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andybalholm/cascadia"
+)
+
+// Config holds every crawl setting. Start from DefaultConfig; zero numbers
+// and empty names left in a Config are replaced by the defaults in New,
+// but switches that default to on have to be copied from it.
+type Config struct {
+	// BaseURL is the seed and the scope: only URLs under it are crawled,
+	// unless AllowedDomains widens it
+	BaseURL string
+	// AllowedDomains, if set, are the hosts to crawl instead of the BaseURL
+	// prefix: a host name, or "*.example.com" for any of its subdomains.
+	// robots.txt, rate limits and throttling then apply per host.
+	AllowedDomains []string
+	// SeedURLs are more URLs to start from besides BaseURL, each at depth
+	// 0. They have to be in scope, unless AllowSeedDomains adds their hosts
+	// to AllowedDomains; BaseURL's host is added too, so the BaseURL prefix
+	// no longer limits the crawl.
+	SeedURLs         []string
+	AllowSeedDomains bool
+	// ProjectFolder holds the state files, the reports and the downloads.
+	// Defaults to the BaseURL host.
+	ProjectFolder string
+
+	// FrontierBackend is how the crawl state is kept: "sqlite" in the
+	// database frontier.db, "journal" in frontier.jsonl, which holds every
+	// URL in memory, "text" in the found, scraped and failed files, which
+	// is simpler to inspect but only suits small crawls
+	FrontierBackend string
+
+	// File and folder names inside ProjectFolder
+	FoundURLsFile    string
+	ScrapedURLsFile  string
+	FailedURLsFile   string
+	RedirectsFile    string
+	ExternalURLsFile string
+	DownloadFolder   string
+
+	// StorageBackend is where saved bodies go: "fs" keeps them as files in
+	// DownloadFolder, "s3" as objects in the bucket S3 describes and
+	// "sqlite" as rows of the database SQLiteFile, in ProjectFolder
+	StorageBackend string
+	S3             S3Config
+	SQLiteFile     string
+	// StorageCompression "gzip" or "zstd" stores the pages and assets of
+	// text types compressed, as the file name plus ".gz" or ".zst", with
+	// the fs backend; "none" stores them as they are. Reads take any of
+	// them, so changing it is safe.
+	StorageCompression string
+
+	Workers int
+	// AdaptiveConcurrency runs MaxWorkers workers but lets only some of them
+	// work at once, starting at Workers. Every AdaptInterval the number is
+	// halved if more than MaxErrorRate of the requests got a 429 or 503 or
+	// timed out, cut by a quarter if the median latency is over twice the
+	// best seen, and otherwise raised, by a quarter until the first cut and
+	// by one after it, within MinWorkers and MaxWorkers.
+	AdaptiveConcurrency    bool
+	MinWorkers, MaxWorkers int
+	AdaptInterval          time.Duration
+	MaxErrorRate           float64
+	// MaxDepth is how many links away from the seeds to follow; -1 means
+	// no limit
+	MaxDepth int
+	// MaxPaginationChain is how many rel="next" steps are followed at the
+	// depth of the page the chain started on; after that the next page is
+	// a level deeper, like any other link. 0 means no limit.
+	MaxPaginationChain int
+	// CrawlOrder is "bfs" to dispatch the shallowest pending URLs first or
+	// "dfs" for the deepest, after the URLs PriorityRules weigh higher
+	CrawlOrder    string
+	PriorityRules []PriorityRule
+	// CrawlBudgets caps the pages fetched under URL patterns, over all runs.
+	// A URL counts against the first budget it matches, when it is
+	// dispatched; once that budget is used up it is skipped, listed in
+	// budget_skipped.txt, rather than left pending.
+	CrawlBudgets []CrawlBudget
+	// Schedule, when set, pauses the crawl outside its window of the day
+	Schedule *CrawlWindow
+	// MaxPages and MaxDuration bound a single run; zero means no limit
+	MaxPages    int
+	MaxDuration time.Duration
+	// Deadline is a hard stop: unlike MaxDuration it aborts requests that
+	// are still in flight
+	Deadline time.Duration
+	// ShutdownTimeout is how long in-flight requests get to finish after
+	// Run's context is cancelled before they are aborted
+	ShutdownTimeout time.Duration
+
+	RequestTimeout time.Duration
+	// TLSCAFile is a PEM bundle of CAs to trust besides the system ones, and
+	// TLSCertFile and TLSKeyFile a client certificate for servers that ask
+	// for one. TLSInsecureSkipVerify accepts any server certificate.
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+	// HostMapping sends connections for a host, or a host:port, to another
+	// address, such as a staging server's IP, with or without a port. URLs,
+	// the Host header and TLS verification keep the real host name. It
+	// doesn't apply to requests sent through a proxy.
+	HostMapping map[string]string
+	// The connection pool: MaxIdleConns idle connections kept in all (100
+	// by default), MaxIdleConnsPerHost per host (one per worker by
+	// default), at most MaxConnsPerHost open to a host (zero for no limit),
+	// each closed after IdleConnTimeout unused (90s by default).
+	// ForceAttemptHTTP2 offers HTTP/2 to servers, from DefaultConfig.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	ForceAttemptHTTP2   bool
+	// ProxyURLs are the proxies to send requests through, http(s):// or
+	// socks5:// with any credentials in the URL. Without them HTTP_PROXY
+	// and HTTPS_PROXY are used. ProxyRotation is "round-robin", taking
+	// them in turn, or "per-worker", giving each worker its own; a request
+	// that can't connect moves on to the next proxy either way.
+	ProxyURLs     []string
+	ProxyRotation string
+	// UserAgents are the User-Agent headers to send, taken in turn for each
+	// request or, with UserAgentRotation "per-worker", one per worker.
+	// Without them it is "simple-web-scraper/1.0", with ContactURL after
+	// it. RobotsToken is the name to look for in robots.txt, by default
+	// the product name of the first User-Agent.
+	UserAgents        []string
+	UserAgentRotation string
+	ContactURL        string
+	RobotsToken       string
+	// Headers are sent with every request to a crawled host, as are basic
+	// auth credentials or a bearer token when set
+	Headers           map[string]string
+	BasicAuthUser     string
+	BasicAuthPassword string
+	BearerToken       string
+	// Cookies, as in a Cookie header, start the cookie jar for the BaseURL
+	// host, and CookiesFile, in the Netscape cookies.txt format, for any.
+	// The jar keeps what the site sets for the rest of the run, and with
+	// PersistCookies from one run to the next in cookie_jar.jsonl.
+	Cookies        string
+	CookiesFile    string
+	PersistCookies bool
+	// Login, if its URL is set, is a form to log in with before crawling;
+	// the crawl stops there if it fails
+	Login LoginConfig
+	// RequestDelay is how long each worker waits between requests
+	RequestDelay time.Duration
+	// MaxRequestsPerSecond caps requests to each host across all workers;
+	// zero means unlimited
+	MaxRequestsPerSecond float64
+	// MaxBandwidth caps how many bytes per second all workers download
+	// together; zero means unlimited
+	MaxBandwidth int64
+	// MaxAttempts is how often a URL is tried before it is recorded as
+	// failed, backing off from RetryBaseDelay
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	// MaxThrottles is how often a URL answered with a 429 or 503 is
+	// re-queued before it is recorded as failed
+	MaxThrottles int
+	// CircuitBreaker pauses the crawl once CircuitBreakerThreshold fetches
+	// in a row, to one host or to any, failed for want of an answer: DNS,
+	// connection, timeout, TLS or 5xx. BaseURL is then probed, waiting
+	// CircuitBreakerBackoff before the first probe and twice as long before
+	// each next one; the crawl resumes on an answer and stops with
+	// ErrOriginDown after CircuitBreakerProbes failed probes.
+	CircuitBreaker          bool
+	CircuitBreakerThreshold int
+	CircuitBreakerProbes    int
+	CircuitBreakerBackoff   time.Duration
+	// MaxBodySize caps a page download; zero means no cap
+	MaxBodySize int64
+	// ProbeHead sends a page a HEAD before its GET, which is skipped when
+	// the type isn't one to keep or the size is over MaxBodySize: "off",
+	// "on", or "auto" to start once more than ProbeAutoRatio of the pages
+	// fetched weren't HTML. Hosts that refuse HEAD get just the GET, which
+	// still gives up as soon as the headers show the same.
+	ProbeHead      string
+	ProbeAutoRatio float64
+
+	// Include and Exclude filter the URLs entering the frontier. Excludes
+	// win over includes, and with no includes everything in scope is
+	// wanted.
+	Include []*regexp.Regexp
+	Exclude []*regexp.Regexp
+	// AllowedLanguages, language tags like "en" or "pt-br", are the only
+	// languages HTML pages are saved in once fetched; an "en" takes
+	// "en-gb" too, and pages whose language can't be told are kept. The
+	// links of the others are still followed with FollowOtherLanguages,
+	// and their hreflang alternates in an allowed language always are.
+	AllowedLanguages     []string
+	FollowOtherLanguages bool
+	// The crawler trap rules turn down found URLs that look like an endless
+	// URL space, listing a sample in trap_suspects.txt. PathBudgets caps the
+	// URLs under each path prefix, the longest matching one; PathBudget caps
+	// those under each first folder not in PathBudgets. MaxQueryParams caps
+	// a URL's query parameters, and MaxPathRepeats how many times a run of
+	// path segments may repeat back to back, as in /a/b/a/b/a/b. Zero turns
+	// a rule off.
+	PathBudgets    map[string]int
+	PathBudget     int
+	MaxQueryParams int
+	MaxPathRepeats int
+	// WarnNewURLs is how many new URLs a page may yield before that is
+	// logged as a warning; zero never warns
+	WarnNewURLs int
+	// NormalizeQuery turns on tracking-parameter stripping and query
+	// sorting. Some sites really do serve different content per parameter
+	// order or session, so it can be switched off.
+	NormalizeQuery bool
+	// NormalizeScheme makes http:// URLs https:// on the allowed hosts when
+	// BaseURL is https, and on any host once it has been seen redirecting
+	// its http:// URLs permanently to https://. NormalizeWWW folds the
+	// BaseURL host with "www." and without it into the form BaseURL has.
+	// Both apply wherever URLs are normalized or checked for scope, so the
+	// frontier and the manifest only have one form of each page.
+	NormalizeScheme bool
+	NormalizeWWW    bool
+	// StripQueryParams are dropped during normalization. Entries ending in
+	// "*" match by prefix; matching ignores case.
+	StripQueryParams []string
+
+	RespectRobots bool
+	// RespectNofollow honours rel="nofollow", <meta name="robots"> and
+	// X-Robots-Tag when collecting links
+	RespectNofollow bool
+	// RespectCanonical notes each page whose <link rel="canonical"> is
+	// another URL as its alias in canonicals.txt. SkipNonCanonical, which
+	// implies it, also drops the body of a page whose canonical is in
+	// scope, keeping only its record in pages.jsonl.
+	RespectCanonical bool
+	SkipNonCanonical bool
+	SeedFromSitemap  bool
+	// MetaRefresh follows <meta http-equiv="refresh"> redirects of up to
+	// MetaRefreshMaxDelay, recording them in the redirects file; longer
+	// ones are taken for slideshows and pages that reload themselves.
+	// ScriptRedirects does the same for inline scripts that set the location
+	// to a string, like window.location = "/new".
+	MetaRefresh         bool
+	MetaRefreshMaxDelay time.Duration
+	ScriptRedirects     bool
+	// ScriptLinks also looks for in-scope links in inline scripts: absolute
+	// URLs in any of them, and relative ones under keys like "href" and
+	// "url" in those that parse as JSON, like Next.js's __NEXT_DATA__. They
+	// are crawled with source "script" in the journal and pages.jsonl.
+	// ScriptScanLimit caps the script text read per page, 0 for no cap.
+	ScriptLinks     bool
+	ScriptScanLimit int64
+
+	// SaveNonHTML keeps non-HTML responses (PDFs, images, ...) whose type
+	// is in DownloadContentTypes, saving them under the assets folder.
+	// Otherwise they are skipped without downloading the body.
+	SaveNonHTML          bool
+	DownloadContentTypes []string
+	// DocumentTypes are extensions (".pdf") and media types
+	// ("application/pdf", "application/*") of documents to collect: they
+	// are saved under the documents folder named after their URL, and
+	// listed in documents.csv, an identical one found at another URL being
+	// stored once and listed as an alias. Empty collects none.
+	DocumentTypes []string
+	// KeepRawEncoded also stores the body exactly as it came over the
+	// wire, compressed, next to the decoded page
+	KeepRawEncoded      bool
+	KeepOriginalCharset bool
+	// DedupContent stores a page whose body matches an already saved one
+	// as an alias of that file instead of a second copy
+	DedupContent bool
+	// RecordAllReferrers keeps every page a URL was linked from in the
+	// referrers file, not just the first one stored in the found file
+	RecordAllReferrers bool
+	RecordHeaders      bool
+	// ExtractContacts lists the mailto: addresses and tel: numbers pages
+	// link to in contacts.csv
+	ExtractContacts bool
+
+	// DownloadAssets fetches the same-host stylesheets, scripts, images and
+	// fonts pages refer to, so the download folder works as an offline
+	// mirror. AssetMaxSize caps one asset, zero meaning no cap, and
+	// AssetSkipExtensions are never downloaded whatever their size.
+	DownloadAssets      bool
+	AssetMaxSize        int64
+	AssetSkipExtensions []string
+	// CrawlImages collects the images pages show, img src and srcset,
+	// <picture> sources and CSS backgrounds, from the allowed hosts, saving
+	// them under the images folder and their URL, first referrer, size,
+	// dimensions, format and alt text in images.jsonl. ImagesReportOnly
+	// sends each a HEAD instead, saving nothing.
+	CrawlImages      bool
+	ImagesReportOnly bool
+	// RewriteUncrawled decides what happens to links with no local file
+	// when pages are rewritten: "keep" leaves them as written, "live"
+	// makes them absolute URLs to the live site
+	RewriteUncrawled string
+
+	// ReportHTML also writes the end-of-crawl report as report.html
+	ReportHTML bool
+	// SEOTitleMaxLength is how many characters a title may have before the
+	// SEO audit lists it as too long
+	SEOTitleMaxLength int
+
+	// ExtractRules pull fields out of the pages they match into
+	// extracted.jsonl
+	ExtractRules []ExtractRule
+	// ExtractStructuredData writes the JSON-LD blocks and microdata items
+	// of every page to structured_data.jsonl
+	ExtractStructuredData bool
+	// ExtractText saves the readable text of every page as a .txt next to
+	// it: "full" for the whole body, "main" for the main content only,
+	// empty for none. Pages with fewer than ThinContentWords words are
+	// reported as thin content.
+	ExtractText      string
+	ThinContentWords int
+	// DetectSoft404 fetches a random path of the BaseURL host before
+	// crawling. If the site answers it with a page instead of a 404, pages
+	// of that host with the same title and mostly the same text are taken
+	// for soft 404s: flagged in pages.jsonl and the report, with their
+	// links not followed.
+	DetectSoft404 bool
+
+	// RenderPatterns are the URLs whose pages are loaded in headless Chrome
+	// and saved, and parsed, as the DOM it renders rather than as served.
+	// With RenderMinLinks, so are HTML pages with fewer links than that
+	// whose body looks like an unmounted app, an empty <div id="root"> or
+	// next to no text. Chrome gets RenderWait of virtual time for scripts
+	// and requests to settle, more with RenderWaitSelector until it
+	// matches, and RenderTimeout in all; RenderWorkers pages are rendered
+	// at once. ChromePath is looked for on PATH when empty; without a
+	// Chrome pages are saved as served.
+	RenderPatterns     []*regexp.Regexp
+	RenderMinLinks     int
+	RenderWaitSelector string
+	RenderWait         time.Duration
+	RenderTimeout      time.Duration
+	RenderWorkers      int
+	ChromePath         string
+	// Screenshots also saves a PNG of every rendered page next to its file,
+	// taken in a ScreenshotWidth by ScreenshotHeight viewport, of the whole
+	// page or with ScreenshotAboveFold only of what the viewport shows
+	Screenshots         bool
+	ScreenshotWidth     int
+	ScreenshotHeight    int
+	ScreenshotAboveFold bool
+	// Markdown converts every saved page to a .md file under markdown/ at
+	// the end of the crawl, with links between crawled pages kept local
+	Markdown bool
+	// SearchIndex updates the full-text index in search_index.json at the
+	// end of the crawl, for Search
+	SearchIndex bool
+	// WARC also archives every saved page and asset, request and response
+	// as fetched, into .warc.gz files under warc/ with an index of their
+	// offsets in warc_index.jsonl. A file is rotated once it passes
+	// WARCMaxSize bytes; zero never rotates.
+	WARC        bool
+	WARCMaxSize int64
+	// Snapshots keeps what every run fetched in snapshots/<run ID>, to
+	// compare runs with DiffSnapshots. Unchanged files are hard links to
+	// the same bytes rather than copies. It implies Recrawl, since a
+	// snapshot only has the pages its run checked, and needs the fs
+	// storage backend.
+	Snapshots bool
+
+	// Recrawl re-fetches every found URL, asking the server to skip the
+	// body of pages that haven't changed since they were saved
+	Recrawl bool
+	// MaxAge makes a run incremental: only URLs last fetched longer ago
+	// than this are fetched again, conditionally as with Recrawl, which it
+	// implies. Newly found URLs are always fetched.
+	MaxAge time.Duration
+	// RetryFailed forgets which URLs failed so they are dispatched again
+	RetryFailed bool
+	// CheckExternal HEAD-checks the out-of-scope links found during the
+	// crawl so dead external links end up in the broken links report too
+	CheckExternal bool
+	// Quiet turns the messages about single URLs going well down to debug
+	// level; ProgressInterval is how often a progress line is logged, zero
+	// meaning never
+	Quiet            bool
+	ProgressInterval time.Duration
+	// ForceLock takes over the project folder from another crawl whose
+	// process can't be confirmed dead, such as one on another host
+	ForceLock bool
+	// SkipPreflight starts crawling without first checking that the project
+	// folder is writable and that BaseURL answers a HEAD and is allowed by
+	// robots.txt
+	SkipPreflight bool
+	// Webhooks are posted the run's events: started, finished, failed,
+	// milestone every WebhookMilestone pages, and error_rate when more than
+	// WebhookErrorRate of a window's fetches fail. Zero turns those two off.
+	Webhooks         []Webhook
+	WebhookMilestone int
+	WebhookErrorRate float64
+	// EventSink is a broker to publish a message to for every page saved,
+	// on EventTopic: nats://[user:password@]host:port, or tls:// for NATS
+	// over TLS. EventBodyLimit adds bodies up to that size to the messages;
+	// zero leaves them out.
+	EventSink      string
+	EventTopic     string
+	EventBodyLimit int64
+	// DryRunMethod is how DryRun fetches the URLs it keeps: "get" fetches
+	// each in full to follow its links, "head" only those shallower than
+	// DryRunSampleDepth, sending the rest a HEAD for their type and size
+	DryRunMethod      string
+	DryRunSampleDepth int
+}
+
+// DefaultConfig returns the settings a crawl of baseURL gets when nothing
+// else is configured
+func DefaultConfig(baseURL string) Config {
+	return Config{
+		BaseURL:                 baseURL,
+		FrontierBackend:         "sqlite",
+		FoundURLsFile:           "found_urls.txt",
+		ScrapedURLsFile:         "scraped_urls.txt",
+		FailedURLsFile:          "failed_urls.txt",
+		RedirectsFile:           "redirects.txt",
+		ExternalURLsFile:        "external_urls.txt",
+		DownloadFolder:          "site_pages",
+		StorageBackend:          "fs",
+		SQLiteFile:              "pages.db",
+		StorageCompression:      compressNone,
+		Workers:                 10,
+		MinWorkers:              1,
+		MaxWorkers:              50,
+		AdaptInterval:           10 * time.Second,
+		MaxErrorRate:            0.05,
+		MaxDepth:                -1,
+		CrawlOrder:              orderBFS,
+		ShutdownTimeout:         10 * time.Second,
+		RequestTimeout:          30 * time.Second,
+		MaxAttempts:             3,
+		MaxThrottles:            10,
+		RetryBaseDelay:          time.Second,
+		CircuitBreaker:          true,
+		CircuitBreakerThreshold: 10,
+		CircuitBreakerProbes:    5,
+		CircuitBreakerBackoff:   30 * time.Second,
+		MaxBodySize:             100 << 20,
+		ProbeHead:               probeOff,
+		ProbeAutoRatio:          0.2,
+		MaxPathRepeats:          2,
+		WarnNewURLs:             1000,
+		NormalizeQuery:          true,
+		StripQueryParams: []string{
+			"utm_*", "gclid", "dclid", "fbclid", "msclkid", "yclid", "mc_cid", "mc_eid",
+			"_ga", "_gl", "phpsessid", "jsessionid", "aspsessionid", "sid", "sessionid",
+		},
+		RespectRobots:        true,
+		RespectNofollow:      true,
+		FollowOtherLanguages: true,
+		MetaRefresh:          true,
+		MetaRefreshMaxDelay:  5 * time.Second,
+		ScriptScanLimit:      1 << 20,
+		RenderWait:           5 * time.Second,
+		RenderTimeout:        30 * time.Second,
+		RenderWorkers:        2,
+		ScreenshotWidth:      1280,
+		ScreenshotHeight:     800,
+		DownloadContentTypes: []string{"*/*"},
+		AssetMaxSize:         20 << 20,
+		WARCMaxSize:          1 << 30,
+		AssetSkipExtensions:  []string{".mp4", ".webm", ".mov", ".avi", ".mkv", ".iso", ".zip", ".dmg", ".exe"},
+		RewriteUncrawled:     "keep",
+		SEOTitleMaxLength:    60,
+		ProgressInterval:     10 * time.Second,
+		ForceAttemptHTTP2:    true,
+		EventTopic:           "crawler.pages",
+		DryRunMethod:         "get",
+		DryRunSampleDepth:    1,
+	}
+}
+
+// PageResult describes one page the crawl fetched, and saved unless File
+// is empty
+type PageResult struct {
+	URL         string
+	FinalURL    string
+	Status      int
+	Depth       int
+	ContentType string
+	Title       string
+	// File is where the page was saved, relative to the download folder,
+	// empty when it wasn't, and SHA256 the hex hash of what was saved
+	File     string
+	SHA256   string
+	Links    []string
+	Bytes    int64
+	Duration time.Duration
+	// The page's metadata, as in pages.jsonl
+	Canonical   string
+	Description string
+	Lang        string
+	OpenGraph   map[string]string
+	Twitter     map[string]string
+}
+
+// Crawler crawls one site into one project folder. Crawlers share no
+// state, so several can run in one process as long as their project
+// folders differ.
+type Crawler struct {
+	cfg Config
+
+	// OnPage, if set, is called from the worker goroutines for every page
+	// fetched, saved or not: one skipped for its content type, by the GET
+	// or a HEAD probe, comes without a File
+	OnPage func(PageResult)
+	// OnError, if set, is called from the worker goroutines for every URL
+	// recorded as failed
+	OnError func(url string, err error)
+	// Logger, if set, gets the crawl's log records instead of
+	// slog.Default()
+	Logger *slog.Logger
+
+	// Frontier keeps the found, scraped and failed URLs. New sets it from
+	// FrontierBackend; it can be replaced before Run.
+	Frontier Frontier
+	// Storage keeps the saved bodies. New sets it from StorageBackend; it
+	// can be replaced before Run too.
+	Storage Storage
+	// Publisher, if set, gets the page events. New sets it from EventSink;
+	// setting it before Run plugs in another broker, such as Kafka.
+	Publisher Publisher
+
+	foundUrlFileName          string
+	scrapedUrlFileName        string
+	failedUrlFileName         string
+	redirectsFileName         string
+	referrersFileName         string
+	externalFileName          string
+	assetURLsFileName         string
+	brokenLinksFileName       string
+	mixedContentFileName      string
+	contactsFileName          string
+	malformedFileName         string
+	canonicalsFileName        string
+	downloadedFilesFolderName string
+	sitemapLastModFileName    string
+	manifestFileName          string
+	pagesFileName             string
+	duplicatesFileName        string
+	reportFileName            string
+	reportHTMLFileName        string
+	seoFolderName             string
+	edgesFileName             string
+	extractedFileName         string
+	structuredFileName        string
+	searchIndexFileName       string
+	warcIndexFileName         string
+	frontierFileName          string
+	frontierDBFileName        string
+	runsFileName              string
+	lockFileName              string
+	cookieJarFileName         string
+	trapsFileName             string
+	deadLettersFileName       string
+	budgetFileName            string
+	eventSpillFileName        string
+	planFileName              string
+	planSummaryFileName       string
+	documentsFileName         string
+	imageURLsFileName         string
+	imagesFileName            string
+	pauseFileName             string
+
+	httpClient *http.Client
+	// proxies is only set with ProxyURLs
+	proxies   *proxyPool
+	tlsConfig *tls.Config
+	agentTurn atomic.Uint64
+	jar       *recordingJar
+	// robots caches robots.txt by scheme and host
+	robotsMu sync.Mutex
+	robots   map[string]*hostRobots
+	// limiter caps requests per second to each host across all workers;
+	// nil means unlimited
+	limiter   *hostLimiter
+	throttle  *hostThrottle
+	bandwidth *bandwidthLimiter
+	// wwwTwin is the BaseURL host's other form that NormalizeWWW folds into
+	// it, and upgrades the hosts NormalizeScheme makes https
+	wwwTwin  string
+	upgrades *schemeUpgrades
+	// baseHost is the BaseURL host, the one files are laid out without a
+	// host folder for
+	baseHost string
+
+	pageManifest *manifest
+	pageRecords  *jsonlWriter
+	linkEdges    *jsonlWriter
+	// deadLetters is failed_urls.jsonl, open while Run runs
+	deadLetters *jsonlWriter
+	failures    failureCounts
+	// extracted is only open when there are extraction rules
+	extracted    *jsonlWriter
+	extractRules []compiledRule
+	// structured is only open with ExtractStructuredData
+	structured *jsonlWriter
+	// warc is only open with WARC
+	warc *warcWriter
+
+	redirectsFile, referrersFile, externalFile, assetURLsFile *stateFile
+	// trapsFile is trap_suspects.txt: URL, rule, what the rule saw and the
+	// page it was found on
+	trapsFile *stateFile
+	traps     *trapDetector
+	// budgetFile is budget_skipped.txt: the URLs left unfetched this run
+	// because their crawl budget was used up, with the budget's pattern
+	budgetFile *stateFile
+	budgets    budgetTracker
+	// malformedFile is malformed_links.txt: a sample of the hrefs that
+	// didn't parse, quoted, and the page each was found on; malformedLinks
+	// counts them all this run
+	malformedFile  *stateFile
+	malformedLinks atomic.Int64
+	// pauseRequested is set by Pause; paused holds why the crawl is idling,
+	// while it is
+	pauseRequested atomic.Bool
+	paused         atomic.Value
+	// live is set while Run has its state open, for the control API;
+	// liveMu keeps Run from closing it under a request
+	liveMu sync.RWMutex
+	live   bool
+	// soft404 is what the probe page looked like, nil unless DetectSoft404
+	// found the site answering missing pages with one
+	soft404 *pageFingerprint
+	// renderer loads pages in headless Chrome, nil unless RenderPatterns or
+	// RenderMinLinks is set
+	renderer *renderer
+
+	// ledger is runs.jsonl while Run runs, and run this run's record in it
+	ledger *jsonlWriter
+	run    runRecord
+	// report is the one writeReports last wrote
+	report *Report
+	// runLog is Logger with the latest run's ID attached. The shutdown
+	// watcher reads it while Run is running, so it is only ever swapped whole
+	runLog atomic.Pointer[slog.Logger]
+
+	// pagesStarted counts the fetches this run has committed to, so
+	// concurrent workers can't overshoot MaxPages
+	pagesStarted atomic.Int64
+	requestCount atomic.Int64
+	crawlStart   time.Time
+	// Outcome counts for the end-of-run recrawl summary
+	pagesUnchanged, pagesUpdated, pagesNew atomic.Int64
+	// What this run got through, for the progress lines
+	pagesDone, pagesFailed, bodiesSaved, bytesSaved atomic.Int64
+	// dispatched numbers the jobs in the order they are handed out
+	dispatched atomic.Int64
+	conns      connStats
+	// gate holds back the workers over the adaptive limit, nil without
+	// AdaptiveConcurrency
+	gate *workerGate
+	// breaker is nil without CircuitBreaker
+	breaker *circuitBreaker
+	// hooks is nil without Webhooks
+	hooks *notifier
+	// events is only set while Run runs with a Publisher
+	events *eventSink
+	probes *prober
+	// documents is only set while Run runs with DocumentTypes
+	documents *documentIndex
+	// canonicalsFile is only open with RespectCanonical
+	canonicalsFile *stateFile
+	// imageURLsFile and images are only open while Run runs with
+	// CrawlImages
+	imageURLsFile *stateFile
+	images        *jsonlWriter
+	imageAlts     imageAlts
+}
+
+// New checks cfg and returns a crawler for it. Only the certificate files
+// are read; nothing else is read or written until one of its methods runs.
+func New(cfg Config) (*Crawler, error) {
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, errors.New("BaseURL must be an http or https URL")
+	}
+	// The host is spelled as normalizeURL spells found URLs, so they
+	// compare equal
+	base.Scheme = strings.ToLower(base.Scheme)
+	base.Host = strings.ToLower(asciiHost(base.Host))
+	dropDefaultPort(base)
+	cfg.BaseURL = base.String()
+	if cfg.CrawlOrder != "" && cfg.CrawlOrder != orderBFS && cfg.CrawlOrder != orderDFS {
+		return nil, errors.New(`CrawlOrder must be "bfs" or "dfs"`)
+	}
+	if cfg.RewriteUncrawled != "" && cfg.RewriteUncrawled != "keep" && cfg.RewriteUncrawled != "live" {
+		return nil, errors.New(`RewriteUncrawled must be "keep" or "live"`)
+	}
+	if cfg.DryRunMethod != "" && cfg.DryRunMethod != "get" && cfg.DryRunMethod != "head" {
+		return nil, errors.New(`DryRunMethod must be "get" or "head"`)
+	}
+	switch cfg.ProbeHead {
+	case "", probeOff, probeOn, probeAuto:
+	default:
+		return nil, errors.New(`ProbeHead must be "off", "on" or "auto"`)
+	}
+	if err := checkWebhooks(cfg.Webhooks); err != nil {
+		return nil, err
+	}
+	if cfg.AllowedDomains != nil {
+		if cfg.AllowedDomains, err = checkAllowedDomains(cfg.AllowedDomains); err != nil {
+			return nil, err
+		}
+		allowed := false
+		for _, p := range cfg.AllowedDomains {
+			allowed = allowed || hostMatches(p, strings.ToLower(base.Hostname()))
+		}
+		if len(cfg.AllowedDomains) > 0 && !allowed {
+			return nil, fmt.Errorf("AllowedDomains does not include the BaseURL host %s", base.Hostname())
+		}
+	}
+	def := DefaultConfig(cfg.BaseURL)
+	if cfg.ProjectFolder == "" {
+		cfg.ProjectFolder = strings.ReplaceAll(base.Host, ":", "_")
+	}
+	for _, s := range []struct{ v, def *string }{
+		{&cfg.FoundURLsFile, &def.FoundURLsFile},
+		{&cfg.ScrapedURLsFile, &def.ScrapedURLsFile},
+		{&cfg.FailedURLsFile, &def.FailedURLsFile},
+		{&cfg.RedirectsFile, &def.RedirectsFile},
+		{&cfg.ExternalURLsFile, &def.ExternalURLsFile},
+		{&cfg.DownloadFolder, &def.DownloadFolder},
+		{&cfg.RewriteUncrawled, &def.RewriteUncrawled},
+		{&cfg.CrawlOrder, &def.CrawlOrder},
+		{&cfg.StorageBackend, &def.StorageBackend},
+		{&cfg.SQLiteFile, &def.SQLiteFile},
+		{&cfg.StorageCompression, &def.StorageCompression},
+		{&cfg.FrontierBackend, &def.FrontierBackend},
+		{&cfg.EventTopic, &def.EventTopic},
+		{&cfg.DryRunMethod, &def.DryRunMethod},
+		{&cfg.ProbeHead, &def.ProbeHead},
+	} {
+		if *s.v == "" {
+			*s.v = *s.def
+		}
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = def.Workers
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = def.RequestTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.MaxThrottles <= 0 {
+		cfg.MaxThrottles = def.MaxThrottles
+	}
+	if len(cfg.RenderPatterns) > 0 || cfg.RenderMinLinks > 0 {
+		if cfg.RenderWait <= 0 {
+			cfg.RenderWait = def.RenderWait
+		}
+		if cfg.RenderTimeout <= 0 {
+			cfg.RenderTimeout = def.RenderTimeout
+		}
+		if cfg.RenderWorkers <= 0 {
+			cfg.RenderWorkers = def.RenderWorkers
+		}
+		if cfg.RenderWaitSelector != "" {
+			if _, err := cascadia.Compile(cfg.RenderWaitSelector); err != nil {
+				return nil, fmt.Errorf("RenderWaitSelector %q: %w", cfg.RenderWaitSelector, err)
+			}
+		}
+	}
+	if cfg.Screenshots {
+		if len(cfg.RenderPatterns) == 0 && cfg.RenderMinLinks <= 0 {
+			return nil, errors.New("Screenshots needs RenderPatterns or RenderMinLinks")
+		}
+		if cfg.ScreenshotWidth <= 0 {
+			cfg.ScreenshotWidth = def.ScreenshotWidth
+		}
+		if cfg.ScreenshotHeight <= 0 {
+			cfg.ScreenshotHeight = def.ScreenshotHeight
+		}
+	}
+	if cfg.SEOTitleMaxLength <= 0 {
+		cfg.SEOTitleMaxLength = def.SEOTitleMaxLength
+	}
+	if cfg.CircuitBreaker {
+		if cfg.CircuitBreakerThreshold <= 0 {
+			cfg.CircuitBreakerThreshold = def.CircuitBreakerThreshold
+		}
+		if cfg.CircuitBreakerProbes <= 0 {
+			cfg.CircuitBreakerProbes = def.CircuitBreakerProbes
+		}
+		if cfg.CircuitBreakerBackoff <= 0 {
+			cfg.CircuitBreakerBackoff = def.CircuitBreakerBackoff
+		}
+	}
+	if cfg.AdaptiveConcurrency {
+		if cfg.MinWorkers <= 0 {
+			cfg.MinWorkers = def.MinWorkers
+		}
+		if cfg.MaxWorkers <= 0 {
+			cfg.MaxWorkers = max(def.MaxWorkers, cfg.Workers)
+		}
+		if cfg.MinWorkers > cfg.MaxWorkers {
+			return nil, fmt.Errorf("MinWorkers (%d) is more than MaxWorkers (%d)", cfg.MinWorkers, cfg.MaxWorkers)
+		}
+		if cfg.AdaptInterval <= 0 {
+			cfg.AdaptInterval = def.AdaptInterval
+		}
+		if cfg.MaxErrorRate <= 0 {
+			cfg.MaxErrorRate = def.MaxErrorRate
+		}
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = cfg.Workers
+		if cfg.AdaptiveConcurrency {
+			cfg.MaxIdleConnsPerHost = cfg.MaxWorkers
+		}
+	}
+	if cfg.DownloadContentTypes == nil {
+		cfg.DownloadContentTypes = def.DownloadContentTypes
+	}
+	cfg.CrawlImages = cfg.CrawlImages || cfg.ImagesReportOnly
+	cfg.RespectCanonical = cfg.RespectCanonical || cfg.SkipNonCanonical
+	var documentTypes []string
+	for _, t := range cfg.DocumentTypes {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			if !strings.Contains(t, "/") && !strings.HasPrefix(t, ".") {
+				t = "." + t
+			}
+			documentTypes = append(documentTypes, t)
+		}
+	}
+	cfg.DocumentTypes = documentTypes
+	var languages []string
+	for _, l := range cfg.AllowedLanguages {
+		if l = strings.TrimSpace(l); l != "" {
+			if normalizeLanguage(l) == "" {
+				return nil, fmt.Errorf("AllowedLanguages %q is not a language tag", l)
+			}
+			languages = append(languages, normalizeLanguage(l))
+		}
+	}
+	cfg.AllowedLanguages = languages
+	if len(cfg.UserAgents) == 0 {
+		ua := defaultUserAgent
+		if cfg.ContactURL != "" {
+			ua += " (+" + cfg.ContactURL + ")"
+		}
+		cfg.UserAgents = []string{ua}
+	}
+	if r := cfg.UserAgentRotation; r != "" && r != "per-request" && r != "per-worker" {
+		return nil, fmt.Errorf(`UserAgentRotation must be "per-request" or "per-worker", got %q`, r)
+	}
+
+	dir := cfg.ProjectFolder
+	c := &Crawler{
+		cfg:                       cfg,
+		foundUrlFileName:          filepath.Join(dir, cfg.FoundURLsFile),
+		scrapedUrlFileName:        filepath.Join(dir, cfg.ScrapedURLsFile),
+		failedUrlFileName:         filepath.Join(dir, cfg.FailedURLsFile),
+		redirectsFileName:         filepath.Join(dir, cfg.RedirectsFile),
+		referrersFileName:         filepath.Join(dir, "referrers.txt"),
+		externalFileName:          filepath.Join(dir, cfg.ExternalURLsFile),
+		assetURLsFileName:         filepath.Join(dir, "asset_urls.txt"),
+		brokenLinksFileName:       filepath.Join(dir, "broken_links.csv"),
+		mixedContentFileName:      filepath.Join(dir, "mixed_content.csv"),
+		contactsFileName:          filepath.Join(dir, "contacts.csv"),
+		malformedFileName:         filepath.Join(dir, "malformed_links.txt"),
+		canonicalsFileName:        filepath.Join(dir, "canonicals.txt"),
+		downloadedFilesFolderName: filepath.Join(dir, cfg.DownloadFolder),
+		sitemapLastModFileName:    filepath.Join(dir, "sitemap_lastmod.txt"),
+		manifestFileName:          filepath.Join(dir, "manifest.jsonl"),
+		pagesFileName:             filepath.Join(dir, "pages.jsonl"),
+		duplicatesFileName:        filepath.Join(dir, "duplicates.txt"),
+		reportFileName:            filepath.Join(dir, "report.json"),
+		reportHTMLFileName:        filepath.Join(dir, "report.html"),
+		seoFolderName:             filepath.Join(dir, "seo"),
+		edgesFileName:             filepath.Join(dir, "edges.jsonl"),
+		extractedFileName:         filepath.Join(dir, "extracted.jsonl"),
+		structuredFileName:        filepath.Join(dir, "structured_data.jsonl"),
+		searchIndexFileName:       filepath.Join(dir, "search_index.json"),
+		warcIndexFileName:         filepath.Join(dir, "warc_index.jsonl"),
+		frontierFileName:          filepath.Join(dir, "frontier.jsonl"),
+		frontierDBFileName:        filepath.Join(dir, "frontier.db"),
+		runsFileName:              filepath.Join(dir, "runs.jsonl"),
+		lockFileName:              filepath.Join(dir, "crawler.lock"),
+		cookieJarFileName:         filepath.Join(dir, "cookie_jar.jsonl"),
+		trapsFileName:             filepath.Join(dir, "trap_suspects.txt"),
+		deadLettersFileName:       filepath.Join(dir, "failed_urls.jsonl"),
+		budgetFileName:            filepath.Join(dir, "budget_skipped.txt"),
+		eventSpillFileName:        filepath.Join(dir, "events_spill.jsonl"),
+		planFileName:              filepath.Join(dir, "plan.jsonl"),
+		planSummaryFileName:       filepath.Join(dir, "plan_summary.json"),
+		documentsFileName:         filepath.Join(dir, "documents.csv"),
+		imageURLsFileName:         filepath.Join(dir, "image_urls.txt"),
+		imagesFileName:            filepath.Join(dir, "images.jsonl"),
+		pauseFileName:             filepath.Join(dir, "PAUSE"),
+		limiter:                   newLimiter(cfg.MaxRequestsPerSecond),
+		throttle:                  newHostThrottle(),
+		traps:                     newTrapDetector(),
+		probes:                    newProber(),
+		bandwidth:                 newBandwidthLimiter(cfg.MaxBandwidth),
+		gate:                      newWorkerGate(cfg),
+		breaker:                   newCircuitBreaker(cfg),
+		hooks:                     newNotifier(cfg),
+		robots:                    make(map[string]*hostRobots),
+		baseHost:                  base.Host,
+		wwwTwin:                   wwwTwinOf(base.Host),
+		upgrades:                  newSchemeUpgrades(cfg, base),
+	}
+	if c.cfg.BaseURL, err = c.normalizeURL(c.cfg.BaseURL); err != nil {
+		return nil, fmt.Errorf("BaseURL: %w", err)
+	}
+	if err := c.checkSeeds(); err != nil {
+		return nil, err
+	}
+	if c.cfg.HostMapping, err = checkHostMapping(cfg.HostMapping); err != nil {
+		return nil, err
+	}
+	if c.tlsConfig, err = newTLSConfig(cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.ProxyURLs) > 0 {
+		if cfg.ProxyRotation != "" && cfg.ProxyRotation != "round-robin" && cfg.ProxyRotation != "per-worker" {
+			return nil, fmt.Errorf(`ProxyRotation must be "round-robin" or "per-worker", got %q`, cfg.ProxyRotation)
+		}
+		if c.proxies, err = c.newProxyPool(cfg.RequestTimeout); err != nil {
+			return nil, err
+		}
+	}
+	if l := cfg.Login; l.URL != "" {
+		if u, err := url.Parse(l.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.New("Login.URL must be an http or https URL")
+		}
+		if m := strings.ToUpper(l.Method); m != "" && m != "POST" && m != "GET" {
+			return nil, fmt.Errorf(`Login.Method must be "POST" or "GET", got %q`, l.Method)
+		}
+	}
+	if cfg.Cookies != "" {
+		if _, err := http.ParseCookie(cfg.Cookies); err != nil {
+			return nil, fmt.Errorf("Cookies: %w", err)
+		}
+	}
+	if c.jar, err = newRecordingJar(); err != nil {
+		return nil, err
+	}
+	c.httpClient = c.newHTTPClient(cfg.RequestTimeout)
+	switch cfg.FrontierBackend {
+	case "sqlite":
+		c.Frontier = &sqliteFrontier{path: c.frontierDBFileName, legacyPaths: []string{c.frontierFileName, c.foundUrlFileName}}
+	case "journal":
+		c.Frontier = &journalFrontier{path: c.frontierFileName, legacyPath: c.foundUrlFileName}
+	case "text":
+		c.Frontier = c.textFrontier()
+	default:
+		return nil, fmt.Errorf(`FrontierBackend must be "sqlite", "journal" or "text", got %q`, cfg.FrontierBackend)
+	}
+	switch cfg.StorageCompression {
+	case compressNone:
+	case compressGzip, compressZstd:
+		if cfg.StorageBackend != "fs" {
+			return nil, fmt.Errorf(`StorageCompression %q needs StorageBackend "fs"`, cfg.StorageCompression)
+		}
+	default:
+		return nil, fmt.Errorf(`StorageCompression must be "none", "gzip" or "zstd", got %q`, cfg.StorageCompression)
+	}
+	switch cfg.StorageBackend {
+	case "fs":
+		c.Storage = fsStorage{dir: c.downloadedFilesFolderName, compress: cfg.StorageCompression}
+	case "s3":
+		s3 := cfg.S3
+		if s3.Prefix == "" {
+			s3.Prefix = filepath.Base(cfg.ProjectFolder)
+		}
+		if c.Storage, err = newS3Storage(s3); err != nil {
+			return nil, err
+		}
+	case "sqlite":
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+		if c.Storage, err = newSQLiteStorage(filepath.Join(dir, c.cfg.SQLiteFile)); err != nil {
+			return nil, fmt.Errorf("opening %s: %w", c.cfg.SQLiteFile, err)
+		}
+	default:
+		return nil, fmt.Errorf(`StorageBackend must be "fs", "s3" or "sqlite", got %q`, cfg.StorageBackend)
+	}
+	c.renderer = newRenderer(&c.cfg)
+	if c.Publisher, err = newPublisher(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MaxAge > 0 {
+		c.cfg.Recrawl = true
+	}
+	if cfg.Snapshots {
+		if cfg.StorageBackend != "fs" {
+			return nil, errors.New(`Snapshots needs StorageBackend "fs"`)
+		}
+		c.cfg.Recrawl = true
+	}
+	if cfg.ExtractText != "" && cfg.ExtractText != textFull && cfg.ExtractText != textMain {
+		return nil, fmt.Errorf(`ExtractText must be "full", "main" or empty, got %q`, cfg.ExtractText)
+	}
+	var errs []error
+	for _, r := range cfg.ExtractRules {
+		compiled, err := compileRule(r)
+		errs = append(errs, err)
+		c.extractRules = append(c.extractRules, compiled)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Config returns the settings the crawler runs with, defaults filled in
+func (c *Crawler) Config() Config {
+	return c.cfg
+}
+
+// ProjectFolder is where the crawler keeps its files
+func (c *Crawler) ProjectFolder() string {
+	return c.cfg.ProjectFolder
+}
+
+// Status counts the URLs in the frontier. Failed URLs are done, like
+// scraped ones, so they aren't pending.
+type Status struct {
+	Found, Scraped, Failed, Pending int
+	// Proxies is how each proxy did in the last run, if it used any
+	Proxies []ProxyStats
+}
+
+// Status reads the frontier of the project folder without crawling
+func (c *Crawler) Status() (Status, error) {
+	if err := c.openStateFiles(); err != nil {
+		return Status{}, err
+	}
+	defer c.closeStateFiles()
+	s, _ := c.crawlStatus()
+	if last, ok, err := lastRun(c.runsFileName); err == nil && ok {
+		s.Proxies = last.Proxies
+	}
+	return s, nil
+}
+
+// MigrateFrontier moves an older frontier over to FrontierBackend, which
+// must not hold anything yet: the journal, or the found, scraped and failed
+// files of the text frontier, into the database, and the text files into
+// the journal. The old files are left in place.
+func (c *Crawler) MigrateFrontier() (FrontierStats, error) {
+	var to string
+	switch c.cfg.FrontierBackend {
+	case "sqlite":
+		to = c.frontierDBFileName
+	case "journal":
+		to = c.frontierFileName
+	default:
+		return FrontierStats{}, fmt.Errorf("FrontierBackend %q has nothing to migrate to", c.cfg.FrontierBackend)
+	}
+	if _, err := os.Stat(to); err == nil {
+		return FrontierStats{}, fmt.Errorf("%s already exists", to)
+	}
+	if err := c.lock(); err != nil {
+		return FrontierStats{}, err
+	}
+	defer c.unlock()
+	stats, err := c.migrateFrontier()
+	if err != nil {
+		os.Remove(to)
+		return FrontierStats{}, err
+	}
+	return stats, nil
+}
+
+func (c *Crawler) migrateFrontier() (stats FrontierStats, err error) {
+	toDB := c.cfg.FrontierBackend == "sqlite"
+	db := &sqliteFrontier{path: c.frontierDBFileName}
+	var dest Frontier = db
+	if !toDB {
+		dest = &journalFrontier{path: c.frontierFileName}
+	}
+	if err := dest.Open(); err != nil {
+		return FrontierStats{}, err
+	}
+	defer func() {
+		if closeErr := dest.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if _, statErr := os.Stat(c.frontierFileName); toDB && statErr == nil {
+		return db.importJournal(c.frontierFileName)
+	}
+	text := c.textFrontier()
+	if err := text.Open(); err != nil {
+		return FrontierStats{}, err
+	}
+	defer text.Close()
+	return copyFrontier(dest, text)
+}
+
+// WriteReports writes the broken links and duplicates reports and the
+// summary report from the files of an earlier crawl
+func (c *Crawler) WriteReports(ctx context.Context) error {
+	if err := c.openManifest(); err != nil {
+		return err
+	}
+	defer c.pageManifest.close()
+	if err := c.openStateFiles(); err != nil {
+		return err
+	}
+	defer c.closeStateFiles()
+	c.writeReports(ctx)
+	return nil
+}
+
+// ExportGraph writes the link graph recorded by earlier crawls to path as
+// "dot" or "graphml". With collapse > 0, URLs are grouped by their first
+// collapse path segments.
+func (c *Crawler) ExportGraph(format, path string, collapse int) error {
+	if err := c.openStateFiles(); err != nil {
+		return err
+	}
+	defer c.closeStateFiles()
+	return c.exportGraph(format, path, collapse)
+}
+
+// RewriteLinks writes an .offline.html copy of every saved page with its
+// links pointed at the local files
+func (c *Crawler) RewriteLinks() error {
+	if err := c.openManifest(); err != nil {
+		return err
+	}
+	defer c.pageManifest.close()
+	return c.rewriteSavedPages()
+}
+
+// ExportMarkdown converts every saved page to Markdown under markdown/
+func (c *Crawler) ExportMarkdown() error {
+	if err := c.openManifest(); err != nil {
+		return err
+	}
+	defer c.pageManifest.close()
+	return c.writeMarkdown()
+}
+
+// IndexPages updates the full-text index with the pages saved by earlier
+// crawls
+func (c *Crawler) IndexPages() error {
+	if err := c.openManifest(); err != nil {
+		return err
+	}
+	defer c.pageManifest.close()
+	return c.updateSearchIndex()
+}
+
+// Serve blocks serving the downloaded mirror over HTTP on addr
+func (c *Crawler) Serve(addr string) error {
+	if err := c.openManifest(); err != nil {
+		return err
+	}
+	defer c.pageManifest.close()
+	return c.serveMirror(addr)
+}
+
+func (c *Crawler) openManifest() error {
+	var err error
+	c.pageManifest, err = openManifest(c.manifestFileName)
+	if err != nil {
+		return fmt.Errorf("opening manifest: %w", err)
+	}
+	return nil
+}
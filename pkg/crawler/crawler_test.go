@@ -0,0 +1,258 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCrawler is a crawler for base with its project folder in a
+// temporary directory and its log discarded, after edit has changed its
+// config
+func newTestCrawler(t testing.TB, base string, edit func(*Config)) *Crawler {
+	t.Helper()
+	cfg := DefaultConfig(base)
+	cfg.ProjectFolder = t.TempDir()
+	cfg.SkipPreflight = true
+	if edit != nil {
+		edit(&cfg)
+	}
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New(%s): %v", base, err)
+	}
+	c.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return c
+}
+
+// readSaved is a file c's storage holds, by its name in the download
+// folder
+func readSaved(t *testing.T, c *Crawler, name string) string {
+	t.Helper()
+	f, err := c.Storage.LoadPage(name)
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return string(b)
+}
+
+func TestNewDefaults(t *testing.T) {
+	def := DefaultConfig("https://example.com/")
+	tests := []struct {
+		name  string
+		edit  func(*Config)
+		check func(cfg Config) bool
+	}{
+		{"no workers", func(cfg *Config) { cfg.Workers = 0 }, func(cfg Config) bool { return cfg.Workers == def.Workers }},
+		{"negative workers", func(cfg *Config) { cfg.Workers = -3 }, func(cfg Config) bool { return cfg.Workers == def.Workers }},
+		{"workers set", func(cfg *Config) { cfg.Workers = 4 }, func(cfg Config) bool { return cfg.Workers == 4 }},
+		{"no timeout", func(cfg *Config) { cfg.RequestTimeout = 0 }, func(cfg Config) bool { return cfg.RequestTimeout == def.RequestTimeout }},
+		{"negative timeout", func(cfg *Config) { cfg.RequestTimeout = -time.Second }, func(cfg Config) bool { return cfg.RequestTimeout == def.RequestTimeout }},
+		{"timeout set", func(cfg *Config) { cfg.RequestTimeout = 5 * time.Second }, func(cfg Config) bool { return cfg.RequestTimeout == 5*time.Second }},
+		{"delay set", func(cfg *Config) { cfg.RequestDelay = 250 * time.Millisecond }, func(cfg Config) bool { return cfg.RequestDelay == 250*time.Millisecond }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, "https://example.com/", tt.edit)
+			if !tt.check(c.cfg) {
+				t.Errorf("workers %d, timeout %s, delay %s", c.cfg.Workers, c.cfg.RequestTimeout, c.cfg.RequestDelay)
+			}
+		})
+	}
+}
+
+// TestRequestTimeout crawls a page linking to one that never answers: the
+// request to it gives up after RequestTimeout rather than holding the
+// worker
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// hang is how the slow page keeps the crawl waiting
+		hang func(w http.ResponseWriter, r *http.Request)
+	}{
+		{"no headers", func(w http.ResponseWriter, r *http.Request) {}},
+		{"a body that stops", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><p>"))
+			w.(http.Flusher).Flush()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					tt.hang(w, r)
+					select {
+					case <-r.Context().Done():
+					case <-time.After(10 * time.Second):
+					}
+					return
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(`<html><a href="/slow">slow</a></html>`))
+			}))
+			defer srv.Close()
+
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.RequestTimeout = 200 * time.Millisecond
+				cfg.MaxAttempts = 1
+			})
+			var mu sync.Mutex
+			failed := make(map[string]error)
+			c.OnError = func(u string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				failed[u] = err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			start := time.Now()
+			if err := c.Run(ctx); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("the crawl took %s", elapsed)
+			}
+			err, ok := failed[srv.URL+"/slow"]
+			if !ok {
+				t.Fatalf("failed %v, want /slow", failed)
+			}
+			if class := classify(err); class != failTimeout {
+				t.Errorf("/slow failed with %v, a %s, want a timeout", err, class)
+			}
+		})
+	}
+}
+
+// TestRequestDelay has the one worker wait RequestDelay between requests
+func TestRequestDelay(t *testing.T) {
+	const delay = 150 * time.Millisecond
+	var mu sync.Mutex
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><a href="/a">a</a><a href="/b">b</a></html>`))
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.RequestDelay = delay
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(times) != 3 {
+		t.Fatalf("%d requests, want 3", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < delay {
+			t.Errorf("request %d came %s after the one before, want at least %s", i+1, gap, delay)
+		}
+	}
+}
+
+// TestConcurrentCrawlers runs two crawlers of different sites at once in
+// one process: each sees only its own pages, keeps its own frontier and
+// run result, and saves into its own project folder
+func TestConcurrentCrawlers(t *testing.T) {
+	sites := []map[string]string{
+		{"/": `<a href="/a">a</a><a href="/b">b</a>`, "/a": `<a href="/c">c</a>`, "/b": ``, "/c": ``},
+		{"/": `<a href="/x">x</a>`, "/x": `<a href="/missing">gone</a>`},
+	}
+	for _, backend := range []string{"sqlite", "journal", "text"} {
+		t.Run(backend, func(t *testing.T) {
+			crawlers := make([]*Crawler, len(sites))
+			pages := make([]map[string]string, len(sites))
+			for i, site := range sites {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, ok := site[r.URL.Path]
+					if !ok {
+						http.NotFound(w, r)
+						return
+					}
+					w.Header().Set("Content-Type", "text/html")
+					io.WriteString(w, "<html><body>"+body+"</body></html>")
+				}))
+				t.Cleanup(srv.Close)
+				c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+					cfg.Workers = 2
+					cfg.RespectRobots = false
+					cfg.MaxAttempts = 1
+					cfg.FrontierBackend = backend
+				})
+				var mu sync.Mutex
+				pages[i] = make(map[string]string)
+				c.OnPage = func(p PageResult) {
+					mu.Lock()
+					defer mu.Unlock()
+					pages[i][p.URL[len(srv.URL):]] = p.File
+				}
+				crawlers[i] = c
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			errs := make([]error, len(crawlers))
+			var wg sync.WaitGroup
+			for i, c := range crawlers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = c.Run(ctx)
+				}()
+			}
+			wg.Wait()
+
+			for i, c := range crawlers {
+				if errs[i] != nil {
+					t.Fatalf("crawler %d: %v", i, errs[i])
+				}
+				want := len(sites[i])
+				if len(pages[i]) != want {
+					t.Errorf("crawler %d saw %v, want the %d pages of its own site", i, pages[i], want)
+				}
+				other := crawlers[1-i]
+				for path, file := range pages[i] {
+					if _, ok := sites[i][path]; !ok {
+						t.Errorf("crawler %d saw %s, not on its site", i, path)
+					}
+					if _, ok := c.Storage.Exists(file); !ok {
+						t.Errorf("crawler %d didn't save %s", i, path)
+					}
+					if _, ok := other.Storage.Exists(file); ok {
+						t.Errorf("crawler %d's %s is in the other project", i, path)
+					}
+				}
+				run := c.LastRun()
+				if run.Frontier == nil || run.Frontier.Scraped != want {
+					t.Errorf("crawler %d frontier = %+v, want %d scraped", i, run.Frontier, want)
+				}
+			}
+			if f := crawlers[1].LastRun().Failed; f != 1 {
+				t.Errorf("second crawler failed %d URLs, want its one missing page", f)
+			}
+			if f := crawlers[0].LastRun().Failed; f != 0 {
+				t.Errorf("first crawler failed %d URLs, want none", f)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+)
+
+// hashingReader computes the SHA-256 of everything read through it, so the
+// body is hashed while it streams to disk
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	return n, err
+}
+
+func (hr *hashingReader) sum() string {
+	return hex.EncodeToString(hr.h.Sum(nil))
+}
+
+// writeDuplicatesReport lists the URLs whose saved bodies share a hash, one
+// group per line: the hash followed by its URLs, tab-separated. Groups are
+// sorted by size, largest first.
+func (c *Crawler) writeDuplicatesReport(path string) (int, error) {
+	groups := make(map[string][]string)
+	c.pageManifest.mu.Lock()
+	for url, e := range c.pageManifest.entries {
+		if e.SHA256 != "" {
+			groups[e.SHA256] = append(groups[e.SHA256], url)
+		}
+	}
+	c.pageManifest.mu.Unlock()
+
+	var hashes []string
+	for h, urls := range groups {
+		if len(urls) > 1 {
+			sort.Strings(urls)
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		a, b := groups[hashes[i]], groups[hashes[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return hashes[i] < hashes[j]
+	})
+
+	var out strings.Builder
+	for _, h := range hashes {
+		fmt.Fprintf(&out, "%s\t%s\n", h, strings.Join(groups[h], "\t"))
+	}
+	if err := writeFileAtomic(path, []byte(out.String())); err != nil {
+		return 0, err
+	}
+	return len(hashes), nil
+}
+
+// removeDuplicateCopy deletes a just-saved file that turned out to be
+// identical to canonical's, unless they are the same file
+func (c *Crawler) removeDuplicateCopy(fileName string, canonical manifestEntry) error {
+	if fileName == canonical.File {
+		return nil
+	}
+	return c.Storage.Remove(fileName)
+}
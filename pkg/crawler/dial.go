@@ -0,0 +1,54 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// checkHostMapping lowercases the HostMapping keys and makes sure each
+// target is an address, with or without a port
+func checkHostMapping(mapping map[string]string) (map[string]string, error) {
+	checked := make(map[string]string, len(mapping))
+	for from, to := range mapping {
+		from, to = strings.ToLower(strings.TrimSpace(from)), strings.TrimSpace(to)
+		if from == "" || to == "" || strings.ContainsAny(from+to, "/ ") {
+			return nil, fmt.Errorf("HostMapping: %q=%q is not host=address", from, to)
+		}
+		checked[from] = to
+	}
+	return checked, nil
+}
+
+// mappedAddr is where a connection to addr really goes: the HostMapping
+// entry for its host and port, or for its host alone, keeping the port
+// unless the entry has one
+func (c *Crawler) mappedAddr(addr string) string {
+	if to, ok := c.cfg.HostMapping[strings.ToLower(addr)]; ok {
+		return to
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	to, ok := c.cfg.HostMapping[strings.ToLower(host)]
+	if !ok {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(to); err == nil {
+		return to
+	}
+	return net.JoinHostPort(strings.Trim(to, "[]"), port)
+}
+
+// mappedDial dials through HostMapping. Only the address changes, so the
+// Host header, TLS SNI and certificate checks still use the URL's host.
+func (c *Crawler) mappedDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(c.cfg.HostMapping) == 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, network, c.mappedAddr(addr))
+	}
+}
@@ -0,0 +1,139 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckHostMapping(t *testing.T) {
+	tests := []struct {
+		mapping map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{mapping: map[string]string{" Example.COM ": " 10.0.0.5:443 "}, want: map[string]string{"example.com": "10.0.0.5:443"}},
+		{mapping: map[string]string{"example.com:8443": "10.0.0.5"}, want: map[string]string{"example.com:8443": "10.0.0.5"}},
+		{mapping: map[string]string{"example.com": ""}, wantErr: true},
+		{mapping: map[string]string{"": "10.0.0.5"}, wantErr: true},
+		{mapping: map[string]string{"example.com": "https://10.0.0.5/"}, wantErr: true},
+		{mapping: map[string]string{"example.com": "10.0.0.5 10.0.0.6"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := checkHostMapping(tt.mapping)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("checkHostMapping(%v) = %v, %v, want %v, error %v", tt.mapping, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMappedAddr(t *testing.T) {
+	mapping := map[string]string{
+		"example.com":          "10.0.0.5",
+		"api.example.com":      "10.0.0.6:8080",
+		"api.example.com:8443": "10.0.0.7:9443",
+		"v6.example.com":       "[::1]",
+		"Upper.Example.com":    "10.0.0.8",
+	}
+	tests := []struct{ addr, want string }{
+		{"example.com:443", "10.0.0.5:443"},
+		{"example.com:80", "10.0.0.5:80"},
+		{"EXAMPLE.com:443", "10.0.0.5:443"},
+		{"api.example.com:443", "10.0.0.6:8080"},
+		{"api.example.com:8443", "10.0.0.7:9443"},
+		{"v6.example.com:443", "[::1]:443"},
+		{"upper.example.com:443", "10.0.0.8:443"},
+		{"www.example.com:443", "www.example.com:443"},
+		{"no-port", "no-port"},
+	}
+	c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.HostMapping = mapping })
+	for _, tt := range tests {
+		if got := c.mappedAddr(tt.addr); got != tt.want {
+			t.Errorf("mappedAddr(%s) = %s, want %s", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// TestHostMappingCrawl crawls production host names whose connections go
+// to a local listener: the requests keep the logical host in their Host
+// header and SNI, the certificate is checked against it, and the crawl
+// records the logical URLs
+func TestHostMappingCrawl(t *testing.T) {
+	ca := newTestCA(t, "staging CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// certFor is the name the staging server's certificate is for
+		certFor string
+		// mapping is what HostMapping maps shop.example.test to, PORT being
+		// the listener's; without a port the entry is for host:port
+		mapping string
+		ok      bool
+	}{
+		{name: "host to address", certFor: "shop.example.test", mapping: "127.0.0.1:PORT", ok: true},
+		{name: "certificate for another host", certFor: "staging.internal", mapping: "127.0.0.1:PORT"},
+		{name: "host:port to address", certFor: "shop.example.test", mapping: "127.0.0.1", ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTLSTestServer(t, ca, nil, tt.certFor)
+			var hosts, names []string
+			srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hosts, names = append(hosts, r.Host), append(names, r.TLS.ServerName)
+				w.Header().Set("Content-Type", "text/html")
+				if r.URL.Path == "/" {
+					w.Write([]byte(`<html><body><a href="/cart">cart</a></body></html>`))
+					return
+				}
+				w.Write([]byte(`<html><body>cart</body></html>`))
+			})
+			u, _ := url.Parse(srv.URL)
+			port := u.Port()
+			from, to := "shop.example.test", strings.ReplaceAll(tt.mapping, "PORT", port)
+			if !strings.Contains(to, ":") {
+				from, to = from+":"+port, to+":"+port
+			}
+			base := "https://shop.example.test:" + port + "/"
+			c := newTestCrawler(t, base, func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.MaxAttempts = 1
+				cfg.RequestTimeout = 5 * time.Second
+				cfg.TLSCAFile = caFile
+				cfg.HostMapping = map[string]string{from: to}
+			})
+			var pages []string
+			c.OnPage = func(p PageResult) { pages = append(pages, p.URL) }
+			var failed []string
+			c.OnError = func(url string, err error) { failed = append(failed, url) }
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok {
+				if len(pages) != 0 || len(failed) == 0 || len(hosts) != 0 {
+					t.Errorf("pages %v, failed %v, served %v; want the certificate refused", pages, failed, hosts)
+				}
+				return
+			}
+			if got, want := strings.Join(pages, " "), base+" "+base+"cart"; got != want {
+				t.Errorf("pages %s, want %s", got, want)
+			}
+			for i := range hosts {
+				if hosts[i] != "shop.example.test:"+port || names[i] != "shop.example.test" {
+					t.Errorf("request %d had Host %s and SNI %s", i, hosts[i], names[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package crawler
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// documentsSubfolder sits inside the downloaded files folder
+const documentsSubfolder = "documents"
+
+var documentsHeader = []string{"url", "referrer", "file", "size", "content_type", "sha256", "alias_of"}
+
+// isDocument reports whether a response is one of DocumentTypes, matched by
+// the URL's extension (".pdf") or the media type ("application/pdf",
+// "application/*"). HTML never is, whatever the URL says.
+func (c *Crawler) isDocument(rawURL, mediaType string) bool {
+	if len(c.cfg.DocumentTypes) == 0 || isHTMLType(mediaType) {
+		return false
+	}
+	ext := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		ext = strings.ToLower(path.Ext(u.Path))
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, t := range c.cfg.DocumentTypes {
+		switch {
+		case strings.HasPrefix(t, "."):
+			if t == ext {
+				return true
+			}
+		case strings.HasSuffix(t, "/*"):
+			if mediaType != "" && strings.TrimSuffix(t, "/*") == major {
+				return true
+			}
+		case t == mediaType:
+			return true
+		}
+	}
+	return false
+}
+
+// documentRow is one line of documents.csv. AliasOf is the URL whose file
+// an identical document shares.
+type documentRow struct {
+	URL         string
+	Referrer    string
+	File        string
+	Size        int64
+	ContentType string
+	SHA256      string
+	AliasOf     string
+}
+
+// documentIndex is documents.csv, appended to as documents are saved and
+// rewritten with one row per URL when the crawl ends. It also hands out the
+// file names, so two documents called report.pdf, or Report.pdf, don't
+// overwrite each other.
+type documentIndex struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	w    *csv.Writer
+	rows map[string]documentRow
+	// order keeps the rows in the order their URLs were first saved
+	order []string
+	// files maps the fileKey of a file name to the URL it belongs to,
+	// reserved before the body is streamed there
+	files map[string]string
+	// byHash maps a body's SHA-256 to the URL first saved with it
+	byHash map[string]string
+}
+
+// openDocuments loads documents.csv, a later row for a URL replacing an
+// earlier one, and opens it for appending
+func openDocuments(name string) (*documentIndex, error) {
+	d := &documentIndex{
+		path:   name,
+		rows:   make(map[string]documentRow),
+		files:  make(map[string]string),
+		byHash: make(map[string]string),
+	}
+	if f, err := os.Open(name); err == nil {
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		for i, rec := range records {
+			if i == 0 || len(rec) != len(documentsHeader) {
+				continue
+			}
+			size, _ := strconv.ParseInt(rec[3], 10, 64)
+			d.add(documentRow{URL: rec[0], Referrer: rec[1], File: rec[2], Size: size, ContentType: rec[4], SHA256: rec[5], AliasOf: rec[6]})
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	// Rewriting it compacts what a crash left and fixes a torn last line
+	if err := d.rewrite(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	d.f, d.w = f, csv.NewWriter(f)
+	return d, nil
+}
+
+func (d *documentIndex) add(row documentRow) {
+	if old, ok := d.rows[row.URL]; ok {
+		if d.byHash[old.SHA256] == row.URL {
+			delete(d.byHash, old.SHA256)
+		}
+	} else {
+		d.order = append(d.order, row.URL)
+	}
+	d.rows[row.URL] = row
+	d.files[fileKey(row.File)] = cmp.Or(row.AliasOf, row.URL)
+	if row.AliasOf == "" && row.SHA256 != "" {
+		if _, ok := d.byHash[row.SHA256]; !ok {
+			d.byHash[row.SHA256] = row.URL
+		}
+	}
+}
+
+// reserve picks the file for rawURL's document: the one it had before,
+// unless other URLs share it, or else one named after the URL that no other
+// document has taken, "-2" and so on going before the extension.
+func (d *documentIndex) reserve(rawURL, mediaType string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if old, ok := d.rows[rawURL]; ok && old.AliasOf == "" && !d.shared(rawURL) {
+		return old.File
+	}
+	base := documentName(rawURL, mediaType)
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	name := path.Join(documentsSubfolder, base)
+	for n := 2; d.files[fileKey(name)] != "" && d.files[fileKey(name)] != rawURL; n++ {
+		name = path.Join(documentsSubfolder, fmt.Sprintf("%s-%d%s", stem, n, ext))
+	}
+	d.files[fileKey(name)] = rawURL
+	return name
+}
+
+// shared reports whether some alias points at rawURL's file. d.mu is held.
+func (d *documentIndex) shared(rawURL string) bool {
+	for _, r := range d.rows {
+		if r.AliasOf == rawURL {
+			return true
+		}
+	}
+	return false
+}
+
+// release gives back a name reserved for a document that wasn't saved
+func (d *documentIndex) release(name, rawURL string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.releaseLocked(name, rawURL)
+}
+
+func (d *documentIndex) releaseLocked(name, rawURL string) {
+	if d.files[fileKey(name)] == rawURL {
+		if old, ok := d.rows[rawURL]; !ok || old.File != name {
+			delete(d.files, fileKey(name))
+		}
+	}
+}
+
+// record adds row to the index and appends it to documents.csv. When an
+// identical document was saved from another URL, row becomes an alias of
+// its file and dropped is the file just saved, for the caller to remove.
+func (d *documentIndex) record(row documentRow) (recorded documentRow, dropped string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if u, ok := d.byHash[row.SHA256]; ok && u != row.URL {
+		d.releaseLocked(row.File, row.URL)
+		dropped = row.File
+		row.File, row.AliasOf = d.rows[u].File, u
+	}
+	d.add(row)
+	d.w.Write(row.fields())
+	d.w.Flush()
+	return row, dropped, d.w.Error()
+}
+
+func (r documentRow) fields() []string {
+	return []string{r.URL, r.Referrer, r.File, strconv.FormatInt(r.Size, 10), r.ContentType, r.SHA256, r.AliasOf}
+}
+
+// rewrite replaces documents.csv with one row per URL. d.mu is held or the
+// index isn't shared yet.
+func (d *documentIndex) rewrite() error {
+	var out strings.Builder
+	cw := csv.NewWriter(&out)
+	cw.Write(documentsHeader)
+	for _, u := range d.order {
+		cw.Write(d.rows[u].fields())
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(d.path, []byte(out.String()))
+}
+
+func (d *documentIndex) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.f.Close()
+	return errors.Join(err, d.rewrite())
+}
+
+// documentName is the file name a document is saved as: the last segment
+// of its URL path made safe by sanitizeComponent, with an extension for
+// its type when it has none
+func documentName(rawURL, mediaType string) string {
+	name := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		if seg := path.Base(u.EscapedPath()); seg != "/" && seg != "." {
+			name = seg
+		}
+	}
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		name = "document"
+	}
+	if path.Ext(name) == "" {
+		name += extensionFor(mediaType)
+	}
+	return sanitizeComponent(name)
+}
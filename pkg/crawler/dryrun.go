@@ -0,0 +1,552 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The rules a plan decides URLs by, as they appear in plan.jsonl. A kept
+// URL is a seed, matched an include pattern, or is simply in scope with no
+// includes set; a dropped one names the first rule that turned it down.
+const (
+	planSeed         = "seed"
+	planScope        = "scope"
+	planInclude      = "include"
+	planExclude      = "exclude"
+	planInvalid      = "invalid"
+	planMaxDepth     = "max-depth"
+	planRobots       = "robots"
+	planCrawlBudget  = "crawl-budget"
+	planMaxPages     = "max-pages"
+	planDecisionKept = "kept"
+	planDecisionDrop = "dropped"
+)
+
+// PlanDecision is a line of plan.jsonl: a URL the plan saw, whether the
+// crawl would fetch it and the rule that decided, with what a dry run got
+// fetching it
+type PlanDecision struct {
+	URL      string `json:"url"`
+	Decision string `json:"decision"`
+	Rule     string `json:"rule"`
+	// Detail is the pattern, trap rule or budget that decided, if any
+	Detail   string `json:"detail,omitempty"`
+	Depth    int    `json:"depth"`
+	Referrer string `json:"referrer,omitempty"`
+
+	Method      string `json:"method,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	FinalURL    string `json:"final_url,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Bytes is the body's size, -1 when a HEAD didn't say
+	Bytes int64  `json:"bytes,omitempty"`
+	Links int    `json:"links,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	// next is the page's rel="next", which is decided at the page's depth
+	// as a crawl would, and chain the page's place in its pagination chain
+	next  string
+	chain int
+}
+
+// Plan totals up a dry run or a plan, as plan_summary.json has it
+type Plan struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	// Network is false for a plan made from the frontier alone
+	Network bool `json:"network"`
+	Kept    int  `json:"kept"`
+	// KeptByDepth counts the kept URLs by their depth, and Dropped the
+	// dropped ones by rule
+	KeptByDepth map[int]int    `json:"kept_by_depth"`
+	Dropped     map[string]int `json:"dropped"`
+	// Fetched is how many requests the dry run made, of which Failed got
+	// no usable answer; Bytes adds up the sizes they returned
+	Fetched int   `json:"fetched"`
+	Failed  int   `json:"failed"`
+	Bytes   int64 `json:"bytes"`
+	// ProjectedBytes is the average size fetched times the kept URLs, and
+	// ProjectedDuration how long the rate limit or request delay makes
+	// fetching them take at the least
+	ProjectedBytes    int64 `json:"projected_bytes,omitempty"`
+	ProjectedDuration int64 `json:"projected_duration_seconds,omitempty"`
+}
+
+// WriteText writes the plan's totals for people
+func (p *Plan) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("PLAN")
+	if !p.Network {
+		sb.WriteString(" (offline)")
+	}
+	dropped := 0
+	for _, n := range p.Dropped {
+		dropped += n
+	}
+	fmt.Fprintf(&sb, "\n\tKEPT=%d DROPPED=%d\n", p.Kept, dropped)
+	if dropped > 0 {
+		fmt.Fprintf(&sb, "\tDROPPED_BY=%s\n", formatClassCounts(p.Dropped))
+	}
+	if p.Network {
+		fmt.Fprintf(&sb, "\tFETCHED=%d (%s) FAILED=%d\n", p.Fetched, formatSize(p.Bytes), p.Failed)
+		fmt.Fprintf(&sb, "\tPROJECTED=%s", formatSize(p.ProjectedBytes))
+		if p.ProjectedDuration > 0 {
+			fmt.Fprintf(&sb, " in at least %s", time.Duration(p.ProjectedDuration)*time.Second)
+		}
+		sb.WriteString("\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// planner decides the URLs of a plan, each once, and writes the decisions
+type planner struct {
+	c       *Crawler
+	out     *jsonlWriter
+	seen    map[string]bool
+	budgets []int
+	plan    *Plan
+	sized   int
+}
+
+func (c *Crawler) newPlanner(network bool) (*planner, error) {
+	// A plan starts over rather than adding to the last one
+	if err := os.Remove(c.planFileName); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("clearing %s: %w", c.planFileName, err)
+	}
+	out, err := openJSONL(c.planFileName)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", c.planFileName, err)
+	}
+	return &planner{
+		c:       c,
+		out:     out,
+		seen:    make(map[string]bool),
+		budgets: make([]int, len(c.cfg.CrawlBudgets)),
+		plan: &Plan{GeneratedAt: time.Now().UTC(), Network: network,
+			KeptByDepth: make(map[int]int), Dropped: make(map[string]int)},
+	}, nil
+}
+
+// decide runs raw, found at depth on referrer, through the rules a crawl
+// would, in the order it would: scope, the filters, the trap rules, the
+// depth limit, robots.txt unless the plan is made offline, and the crawl
+// budgets. A URL seen before gets no decision and ok false.
+func (p *planner) decide(ctx context.Context, raw string, depth int, referrer string) (d PlanDecision, ok bool) {
+	c := p.c
+	d = PlanDecision{URL: raw, Decision: planDecisionDrop, Depth: depth, Referrer: referrer}
+	normalized, err := c.normalizeURL(raw)
+	if err != nil {
+		d.Rule, d.Detail = planInvalid, err.Error()
+		return d, !p.see(raw)
+	}
+	d.URL = normalized
+	if p.see(normalized) {
+		return d, false
+	}
+	d.Rule = planScope
+	if !c.inScope(normalized) {
+		return d, true
+	}
+	for _, re := range c.cfg.Exclude {
+		if re.MatchString(normalized) {
+			d.Rule, d.Detail = planExclude, re.String()
+			return d, true
+		}
+	}
+	if len(c.cfg.Include) > 0 {
+		d.Rule = planInclude
+		for _, re := range c.cfg.Include {
+			if re.MatchString(normalized) {
+				d.Detail = re.String()
+				break
+			}
+		}
+		if d.Detail == "" {
+			return d, true
+		}
+	}
+	if rule, detail, _ := c.trapRule(normalized); rule != "" {
+		d.Rule, d.Detail = rule, detail
+		return d, true
+	}
+	if c.cfg.MaxDepth >= 0 && depth > c.cfg.MaxDepth {
+		d.Rule, d.Detail = planMaxDepth, fmt.Sprint(c.cfg.MaxDepth)
+		return d, true
+	}
+	if !p.allowed(ctx, normalized) {
+		d.Rule, d.Detail = planRobots, ""
+		return d, true
+	}
+	if !p.takeBudget(&d) {
+		return d, true
+	}
+	d.Decision = planDecisionKept
+	return d, true
+}
+
+// seed decides a seed, which bypasses the filters like it does in a crawl
+func (p *planner) seed(ctx context.Context, raw string) (d PlanDecision, ok bool) {
+	d = PlanDecision{URL: raw, Decision: planDecisionDrop}
+	normalized, err := p.c.normalizeURL(raw)
+	if err != nil {
+		d.Rule, d.Detail = planInvalid, err.Error()
+		return d, !p.see(raw)
+	}
+	d.URL = normalized
+	if p.see(normalized) {
+		return d, false
+	}
+	if !p.allowed(ctx, normalized) {
+		d.Rule = planRobots
+		return d, true
+	}
+	if !p.takeBudget(&d) {
+		return d, true
+	}
+	d.Decision, d.Rule = planDecisionKept, planSeed
+	return d, true
+}
+
+// takeBudget counts d's URL against its crawl budget, if it has one, and
+// reports whether there was room. The plan's budgets start empty, as a
+// first crawl's would.
+func (p *planner) takeBudget(d *PlanDecision) bool {
+	c := p.c
+	i := c.budgetFor(d.URL)
+	if i < 0 {
+		return true
+	}
+	if p.budgets[i] >= c.cfg.CrawlBudgets[i].MaxPages {
+		d.Rule, d.Detail = planCrawlBudget, c.cfg.CrawlBudgets[i].Pattern.String()
+		return false
+	}
+	p.budgets[i]++
+	return true
+}
+
+// allowed checks robots.txt, which a plan without the network leaves out
+func (p *planner) allowed(ctx context.Context, u string) bool {
+	if !p.plan.Network {
+		return true
+	}
+	_, ok := p.c.robotsAllowed(ctx, u)
+	return ok
+}
+
+// see marks u seen, reporting whether it was already
+func (p *planner) see(u string) bool {
+	if p.seen[u] {
+		return true
+	}
+	p.seen[u] = true
+	return false
+}
+
+// record writes d to plan.jsonl and counts it
+func (p *planner) record(d PlanDecision) {
+	if d.Decision == planDecisionKept {
+		p.plan.Kept++
+		p.plan.KeptByDepth[d.Depth]++
+	} else {
+		p.plan.Dropped[d.Rule]++
+	}
+	if d.Method != "" {
+		p.plan.Fetched++
+		if d.Error != "" {
+			p.plan.Failed++
+		}
+		if d.Bytes > 0 {
+			p.plan.Bytes += d.Bytes
+			p.sized++
+		}
+	}
+	_ = p.out.write(d)
+}
+
+// finish works out the projections and writes plan_summary.json
+func (p *planner) finish() (*Plan, error) {
+	c, plan := p.c, p.plan
+	if p.sized > 0 {
+		plan.ProjectedBytes = plan.Bytes / int64(p.sized) * int64(plan.Kept)
+	}
+	var d time.Duration
+	if c.cfg.MaxRequestsPerSecond > 0 {
+		d = time.Duration(float64(plan.Kept) / c.cfg.MaxRequestsPerSecond * float64(time.Second))
+	}
+	if delay := c.cfg.RequestDelay * time.Duration(plan.Kept) / time.Duration(max(c.cfg.Workers, 1)); delay > d {
+		d = delay
+	}
+	plan.ProjectedDuration = int64(d / time.Second)
+	if err := p.out.close(); err != nil {
+		return plan, fmt.Errorf("writing %s: %w", c.planFileName, err)
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return plan, err
+	}
+	if err := writeFileAtomic(c.planSummaryFileName, append(data, '\n')); err != nil {
+		return plan, fmt.Errorf("writing %s: %w", c.planSummaryFileName, err)
+	}
+	c.log().Info("plan", "kept", plan.Kept, "dropped", formatClassCounts(plan.Dropped), "fetched", plan.Fetched,
+		"failed", plan.Failed, "projected_bytes", formatSize(plan.ProjectedBytes),
+		"projected_duration", time.Duration(plan.ProjectedDuration)*time.Second, "file", c.planFileName)
+	return plan, nil
+}
+
+// DryRun discovers what a crawl of BaseURL would fetch without saving
+// anything but the plan: every URL seen goes to plan.jsonl with the rule
+// that kept or dropped it, and the totals to plan_summary.json. Pages are
+// fetched to find their links, with DryRunMethod "head" only those shallower
+// than DryRunSampleDepth, the rest being sent a HEAD for their type and
+// size. The frontier, the manifest and the other state files are left
+// alone, and the plan starts from the seeds as if nothing had been crawled.
+func (c *Crawler) DryRun(ctx context.Context) (*Plan, error) {
+	if err := c.startPlan(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+	if err := c.setInitialCookies(c.jar); err != nil {
+		return nil, err
+	}
+	if c.cfg.Login.URL != "" {
+		if err := c.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+	p, err := c.newPlanner(true)
+	if err != nil {
+		return nil, err
+	}
+
+	var level []PlanDecision
+	for _, seed := range append([]string{c.cfg.BaseURL}, c.cfg.SeedURLs...) {
+		if d, ok := p.seed(ctx, seed); ok {
+			if d.Decision == planDecisionKept {
+				level = append(level, d)
+			} else {
+				p.record(d)
+			}
+		}
+	}
+	fetched := 0
+	for len(level) > 0 {
+		// A level is fetched at once, then its links decided in order, so
+		// the plan comes out the same every time for the same site
+		if n := c.cfg.MaxPages; n > 0 && fetched+len(level) > n {
+			for _, d := range level[max(n-fetched, 0):] {
+				d.Decision, d.Rule, d.Detail = planDecisionDrop, planMaxPages, fmt.Sprint(n)
+				p.record(d)
+			}
+			level = level[:max(n-fetched, 0)]
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		fetched += len(level)
+		links := c.fetchLevel(ctx, level)
+		var next []PlanDecision
+		for i, d := range level {
+			p.record(d)
+			for _, link := range links[i] {
+				depth, chain := d.Depth+1, 0
+				if limit := c.cfg.MaxPaginationChain; link == d.next && (limit <= 0 || d.chain < limit) {
+					depth, chain = d.Depth, d.chain+1
+				}
+				if nd, ok := p.decide(ctx, link, depth, d.URL); ok {
+					nd.chain = chain
+					if nd.Decision == planDecisionKept {
+						next = append(next, nd)
+					} else {
+						p.record(nd)
+					}
+				}
+			}
+		}
+		level = next
+	}
+	// Interrupted: what is left was kept but never fetched
+	for _, d := range level {
+		p.record(d)
+	}
+	plan, err := p.finish()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return plan, err
+}
+
+// Plan re-decides the URLs in the frontier against the current scope,
+// filters, trap rules, depth limit and crawl budgets without any network
+// traffic, robots.txt being left out, writing plan.jsonl and
+// plan_summary.json like DryRun
+func (c *Crawler) Plan() (*Plan, error) {
+	if err := c.startPlan(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+	if err := c.openStateFiles(); err != nil {
+		return nil, fmt.Errorf("opening state files: %w", err)
+	}
+	defer c.closeStateFiles()
+	p, err := c.newPlanner(false)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	entries := c.Frontier.Found()
+	// Shallowest first, as a crawl would have found them
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Depth < entries[j].Depth })
+	for _, e := range entries {
+		var d PlanDecision
+		var ok bool
+		if e.Depth == 0 && e.Referrer == "" {
+			d, ok = p.seed(ctx, e.URL)
+		} else {
+			d, ok = p.decide(ctx, e.URL, e.Depth, e.Referrer)
+		}
+		if ok {
+			p.record(d)
+		}
+	}
+	return p.finish()
+}
+
+// startPlan takes the project folder for a plan
+func (c *Crawler) startPlan() error {
+	if err := os.MkdirAll(c.cfg.ProjectFolder, os.ModePerm); err != nil {
+		return err
+	}
+	return c.lock()
+}
+
+// fetchLevel fetches the URLs of a level with the configured number of
+// workers, filling in their decisions, and returns the links of each
+func (c *Crawler) fetchLevel(ctx context.Context, level []PlanDecision) [][]string {
+	links := make([][]string, len(level))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(c.cfg.Workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				links[i] = c.planFetch(ctx, &level[i])
+			}
+		}()
+	}
+	for i := range level {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return links
+}
+
+// planFetch fetches d's URL for a dry run, reading an HTML body only to
+// find its links, and returns them
+func (c *Crawler) planFetch(ctx context.Context, d *PlanDecision) []string {
+	if ctx.Err() != nil {
+		return nil
+	}
+	if c.cfg.RequestDelay > 0 {
+		if err := sleepCtx(ctx, c.cfg.RequestDelay); err != nil {
+			return nil
+		}
+	}
+	d.Method = http.MethodGet
+	if c.cfg.DryRunMethod == "head" && d.Depth >= c.cfg.DryRunSampleDepth {
+		d.Method = http.MethodHead
+	}
+	ctx, _ = withRedirectChain(ctx)
+	resp, err := c.requestWithRetry(ctx, d.Method, d.URL, nil)
+	if err != nil {
+		d.Error = err.Error()
+		var se *statusError
+		if errors.As(err, &se) {
+			d.Status = se.code
+		}
+		return nil
+	}
+	defer resp.Body.Close()
+	d.Status = resp.StatusCode
+	if final := resp.Request.URL.String(); final != d.URL {
+		d.FinalURL = final
+	}
+	if d.Method == http.MethodHead {
+		d.ContentType = mediaTypeOf(resp.Header.Get("Content-Type"))
+		d.Bytes = resp.ContentLength
+		return nil
+	}
+
+	decoded, err := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		d.Error = err.Error()
+		return nil
+	}
+	var body io.Reader = decoded
+	if c.cfg.MaxBodySize > 0 {
+		body = &limitedBody{r: body, remaining: c.cfg.MaxBodySize}
+	}
+	counted := &countingReader{r: body}
+	buffered := bufio.NewReader(counted)
+	d.ContentType = responseContentType(resp, buffered)
+	if !isHTMLType(d.ContentType) {
+		_, err = io.Copy(io.Discard, buffered)
+		d.Bytes = counted.n
+		if err != nil {
+			d.Error = err.Error()
+		}
+		return nil
+	}
+	head, _ := buffered.Peek(1024)
+	enc, _ := htmlEncoding(head, resp.Header.Get("Content-Type"))
+	info, err := c.extractLinksFromHTML(pageContext{URL: resp.Request.URL.String(), Header: resp.Header},
+		enc.NewDecoder().Reader(buffered))
+	// The parser may stop short of the end
+	io.Copy(io.Discard, buffered)
+	d.Bytes = counted.n
+	if err != nil {
+		d.Error = err.Error()
+		return nil
+	}
+	links := append(info.Links, info.Frames...)
+	if info.Canonical != "" && !info.NoFollow && c.inScope(info.Canonical) {
+		links = append(links, info.Canonical)
+	}
+	links = append(links, info.ScriptLinks...)
+	d.Links = len(links)
+	d.next = info.Next
+	// External links are decided too, so the plan shows what scope drops
+	return append(links, info.External...)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// mediaTypeOf is a Content-Type header's media type, lowercased
+func mediaTypeOf(header string) string {
+	t, _, _ := strings.Cut(header, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
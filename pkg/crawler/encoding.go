@@ -0,0 +1,70 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// acceptEncoding is sent explicitly, which also turns off net/http's own
+// transparent gzip handling, so every response is decoded by decodeBody
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody undoes a Content-Encoding header, which lists the codings in
+// the order they were applied.
+func decodeBody(r io.Reader, contentEncoding string) (io.Reader, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch coding := strings.ToLower(strings.TrimSpace(codings[i])); coding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = newDeflateReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", coding)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// newDeflateReader handles "deflate" both as the zlib stream the spec calls
+// for and as the raw deflate data some servers send instead.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// rawExtension is added to the page's filename for the raw copy
+func rawExtension(contentEncoding string) string {
+	var exts []string
+	for _, coding := range strings.Split(contentEncoding, ",") {
+		switch coding = strings.ToLower(strings.TrimSpace(coding)); coding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			exts = append(exts, ".gz")
+		default:
+			exts = append(exts, "."+coding)
+		}
+	}
+	return strings.Join(exts, "")
+}
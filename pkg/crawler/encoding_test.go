@@ -0,0 +1,168 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encode applies the codings of a Content-Encoding header to s, in order
+func encode(t *testing.T, s, contentEncoding string) []byte {
+	t.Helper()
+	data := []byte(s)
+	for _, coding := range strings.Split(contentEncoding, ",") {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch strings.TrimSpace(coding) {
+		case "", "identity":
+			continue
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case "br":
+			w = brotli.NewWriter(&buf)
+		default:
+			t.Fatalf("no encoder for %q", coding)
+		}
+		w.Write(data)
+		w.Close()
+		data = buf.Bytes()
+	}
+	return data
+}
+
+func TestDecodeBody(t *testing.T) {
+	const page = "<html><a href=\"/a\">a</a></html>"
+	tests := []struct {
+		name            string
+		encoded         []byte
+		contentEncoding string
+		wantErr         bool
+	}{
+		{"none", []byte(page), "", false},
+		{"identity", []byte(page), "identity", false},
+		{"gzip", encode(t, page, "gzip"), "gzip", false},
+		{"x-gzip", encode(t, page, "gzip"), "X-Gzip", false},
+		{"zlib deflate", encode(t, page, "deflate"), "deflate", false},
+		{"raw deflate", encode(t, page, "raw-deflate"), "deflate", false},
+		{"brotli", encode(t, page, "br"), "br", false},
+		{"gzip then brotli", encode(t, page, "gzip, br"), "gzip, br", false},
+		{"unsupported", []byte(page), "compress", true},
+		{"not really gzip", []byte(page), "gzip", true},
+	}
+	for _, tt := range tests {
+		r, err := decodeBody(bytes.NewReader(tt.encoded), tt.contentEncoding)
+		var got []byte
+		if err == nil {
+			got, err = io.ReadAll(r)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.wantErr && string(got) != page {
+			t.Errorf("%s: decoded %q", tt.name, got)
+		}
+	}
+}
+
+func TestRawExtension(t *testing.T) {
+	tests := []struct{ contentEncoding, want string }{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", ".gz"},
+		{"br", ".br"},
+		{"gzip, br", ".gz.br"},
+	}
+	for _, tt := range tests {
+		if got := rawExtension(tt.contentEncoding); got != tt.want {
+			t.Errorf("rawExtension(%q) = %q, want %q", tt.contentEncoding, got, tt.want)
+		}
+	}
+}
+
+// TestEncodedResponses crawls a site serving its pages compressed: they are
+// saved decoded and their links followed, and with KeepRawEncoded the
+// bytes as served are kept too
+func TestEncodedResponses(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentEncoding string
+		keepRaw         bool
+	}{
+		{"gzip", "gzip", false},
+		{"brotli", "br", false},
+		{"deflate", "deflate", false},
+		{"brotli kept raw", "br", true},
+		{"gzip kept raw", "gzip", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var accepted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				accepted = append(accepted, r.Header.Get("Accept-Encoding"))
+				mu.Unlock()
+				page := `<html><a href="/next">next</a></html>`
+				if r.URL.Path == "/next" {
+					page = `<html>the end</html>`
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("Content-Encoding", tt.contentEncoding)
+				w.Write(encode(t, page, tt.contentEncoding))
+			}))
+			defer srv.Close()
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.KeepRawEncoded = tt.keepRaw
+			})
+			files := make(map[string]string)
+			c.OnPage = func(p PageResult) { files[strings.TrimPrefix(p.URL, srv.URL)] = p.File }
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.Run(ctx); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if len(files) != 2 {
+				t.Fatalf("saved %v, want / and the page it links to", files)
+			}
+			if got := readSaved(t, c, files["/"]); !strings.Contains(got, `<a href="/next">`) {
+				t.Errorf("saved %q, want the decoded page", got)
+			}
+			for _, ae := range accepted {
+				if ae != acceptEncoding {
+					t.Errorf("sent Accept-Encoding %q, want %q", ae, acceptEncoding)
+				}
+			}
+			raw := files["/"] + rawExtension(tt.contentEncoding)
+			_, kept := c.Storage.Exists(raw)
+			if kept != tt.keepRaw {
+				t.Fatalf("raw copy %s kept: %v, want %v", raw, kept, tt.keepRaw)
+			}
+			if kept {
+				r, err := decodeBody(strings.NewReader(readSaved(t, c, raw)), tt.contentEncoding)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decoded, _ := io.ReadAll(r); !strings.Contains(string(decoded), "/next") {
+					t.Errorf("raw copy decodes to %q", decoded)
+				}
+			}
+		})
+	}
+}
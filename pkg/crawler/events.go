@@ -0,0 +1,329 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventQueueSize is how many page events can wait for the broker; past
+	// it they go straight to the spill file
+	eventQueueSize = 1000
+	eventTimeout   = 10 * time.Second
+	// eventRetryInterval is how often the spill file is published from
+	eventRetryInterval = 5 * time.Second
+	// eventDrainTime is how long the end of Run waits for the queued events
+	eventDrainTime = 15 * time.Second
+)
+
+// ErrEventRejected is wrapped by a Publisher's error when the broker will
+// never take the message, so it is dropped rather than retried
+var ErrEventRejected = errors.New("event rejected")
+
+// Publisher sends messages to a broker. Publish must not return until the
+// broker has the message, or fail; it is only called from one goroutine.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, msg []byte) error
+	Close() error
+}
+
+// newPublisher returns the Publisher for EventSink, nil without one
+func newPublisher(cfg Config) (Publisher, error) {
+	if cfg.EventSink == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.EventSink)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("EventSink %q is not a broker URL", cfg.EventSink)
+	}
+	switch u.Scheme {
+	case "nats", "tls":
+		if strings.ContainsAny(cfg.EventTopic, " \t\r\n") {
+			return nil, fmt.Errorf("EventTopic %q is not a NATS subject", cfg.EventTopic)
+		}
+		return newNATSPublisher(u), nil
+	case "kafka":
+		return nil, errors.New("EventSink kafka:// needs a Kafka client, which this build does not include; set Crawler.Publisher to one")
+	default:
+		return nil, fmt.Errorf("EventSink must be a nats:// or tls:// URL, got %q", cfg.EventSink)
+	}
+}
+
+// PageEvent is the message published for a page saved
+type PageEvent struct {
+	RunID       string            `json:"run_id"`
+	URL         string            `json:"url"`
+	FinalURL    string            `json:"final_url,omitempty"`
+	Status      int               `json:"status"`
+	Depth       int               `json:"depth"`
+	FetchedAt   time.Time         `json:"fetched_at"`
+	ContentType string            `json:"content_type,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	Canonical   string            `json:"canonical,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Lang        string            `json:"lang,omitempty"`
+	OpenGraph   map[string]string `json:"og,omitempty"`
+	Twitter     map[string]string `json:"twitter,omitempty"`
+	Links       int               `json:"links"`
+	// Storage is where the body is kept and File its name there
+	Storage string `json:"storage"`
+	File    string `json:"file"`
+	SHA256  string `json:"sha256,omitempty"`
+	Bytes   int64  `json:"bytes"`
+	// Body is the saved body, base64 in the JSON, when it is no bigger than
+	// EventBodyLimit
+	Body []byte `json:"body,omitempty"`
+}
+
+// publishPage queues the event of a page saved
+func (c *Crawler) publishPage(log *slog.Logger, p PageResult, fetchedAt time.Time) {
+	if c.events == nil || p.File == "" {
+		return
+	}
+	e := PageEvent{
+		RunID:       c.run.ID,
+		URL:         p.URL,
+		FinalURL:    p.FinalURL,
+		Status:      p.Status,
+		Depth:       p.Depth,
+		FetchedAt:   fetchedAt,
+		ContentType: p.ContentType,
+		Title:       p.Title,
+		Canonical:   p.Canonical,
+		Description: p.Description,
+		Lang:        p.Lang,
+		OpenGraph:   p.OpenGraph,
+		Twitter:     p.Twitter,
+		Links:       len(p.Links),
+		Storage:     fmt.Sprint(c.Storage),
+		File:        p.File,
+		SHA256:      p.SHA256,
+		Bytes:       p.Bytes,
+	}
+	if limit := c.cfg.EventBodyLimit; limit > 0 && p.Bytes <= limit {
+		if r, err := c.Storage.LoadPage(p.File); err == nil {
+			e.Body, err = io.ReadAll(io.LimitReader(r, limit))
+			r.Close()
+			if err != nil {
+				log.Warn("reading the body for the page event", "error", err)
+				e.Body = nil
+			}
+		}
+	}
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	c.events.send(msg)
+}
+
+// eventSink hands the page events to the Publisher from its own goroutine,
+// so the crawl never waits on the broker. An event that can't be published,
+// or finds the queue full, is appended to the spill file instead, and
+// while that holds events newer ones join it rather than try the broker.
+// The spill file is published from every eventRetryInterval, and by the
+// next run if this one ends first.
+type eventSink struct {
+	pub   Publisher
+	topic string
+	path  string
+	log   *slog.Logger
+	// mu guards queue, which is nil once stopped, and the spill file, which
+	// holds spilled events
+	mu      sync.Mutex
+	queue   chan []byte
+	spilled int
+	done    chan struct{}
+	// ctx is cancelled to abandon a publish still waiting on the broker
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// startEvents starts publishing to c.Publisher, picking up the events a
+// previous run spilled
+func (c *Crawler) startEvents() error {
+	if c.Publisher == nil {
+		return nil
+	}
+	lines, err := readLines(c.eventSpillFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading %s: %w", c.eventSpillFileName, err)
+	}
+	s := &eventSink{
+		pub:     c.Publisher,
+		topic:   c.cfg.EventTopic,
+		path:    c.eventSpillFileName,
+		log:     c.log(),
+		queue:   make(chan []byte, eventQueueSize),
+		spilled: len(lines),
+		done:    make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if s.spilled > 0 {
+		s.log.Info("publishing the page events spilled by an earlier run", "events", s.spilled)
+	}
+	go s.run()
+	c.events = s
+	return nil
+}
+
+// stopEvents waits up to eventDrainTime for the queued events, leaving the
+// rest in the spill file, and closes the Publisher
+func (c *Crawler) stopEvents() {
+	s := c.events
+	if s == nil {
+		return
+	}
+	c.events = nil
+	s.mu.Lock()
+	close(s.queue)
+	s.queue = nil
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+	case <-time.After(eventDrainTime):
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+	if err := s.pub.Close(); err != nil {
+		s.log.Warn("closing the event sink", "error", err)
+	}
+	if s.spilled > 0 {
+		s.log.Warn("page events left unpublished for the next run", "events", s.spilled, "file", s.path)
+	}
+}
+
+// send queues msg, or spills it when the queue is full
+func (s *eventSink) send(msg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queue == nil {
+		return
+	}
+	select {
+	case s.queue <- msg:
+	default:
+		s.spillLocked(msg)
+	}
+}
+
+func (s *eventSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(eventRetryInterval)
+	defer ticker.Stop()
+	s.replay()
+	for {
+		select {
+		case msg, ok := <-s.queue:
+			if !ok {
+				// The queue drains into the spill file once the broker
+				// fails, so one last try publishes it too
+				s.replay()
+				return
+			}
+			s.mu.Lock()
+			behind := s.spilled > 0
+			s.mu.Unlock()
+			if behind || s.publish(msg) != nil {
+				s.spill(msg)
+			}
+		case <-ticker.C:
+			s.replay()
+		}
+	}
+}
+
+// publish sends msg, dropping it if the broker rejects it for good
+func (s *eventSink) publish(msg []byte) error {
+	ctx, cancel := context.WithTimeout(s.ctx, eventTimeout)
+	defer cancel()
+	err := s.pub.Publish(ctx, s.topic, msg)
+	if errors.Is(err, ErrEventRejected) {
+		s.log.Warn("page event dropped", "error", err)
+		return nil
+	}
+	return err
+}
+
+// spill appends msg to the spill file
+func (s *eventSink) spill(msg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spillLocked(msg)
+}
+
+func (s *eventSink) spillLocked(msg []byte) {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err == nil {
+		_, err = f.Write(append(msg, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		s.log.Error("page event lost", "error", err)
+		return
+	}
+	if s.spilled == 0 {
+		s.log.Warn("event sink unavailable, spilling page events", "file", s.path)
+	}
+	s.spilled++
+}
+
+// replay publishes the spill file in order, up to the first event that
+// fails, and keeps the rest in it
+func (s *eventSink) replay() {
+	s.mu.Lock()
+	if s.spilled == 0 {
+		s.mu.Unlock()
+		return
+	}
+	lines, err := readLines(s.path)
+	s.mu.Unlock()
+	if err != nil {
+		s.log.Error("reading the spilled page events", "error", err)
+		return
+	}
+	sent := 0
+	for _, line := range lines {
+		if s.publish([]byte(line)) != nil {
+			break
+		}
+		sent++
+	}
+	if sent == 0 {
+		return
+	}
+
+	// Events spilled meanwhile were appended after the ones read
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now, err := readLines(s.path)
+	if err != nil {
+		s.log.Error("reading the spilled page events", "error", err)
+		return
+	}
+	rest := now[sent:]
+	data := ""
+	if len(rest) > 0 {
+		data = strings.Join(rest, "\n") + "\n"
+	}
+	if err := writeFileAtomic(s.path, []byte(data)); err != nil {
+		s.log.Error("writing the spilled page events", "error", err)
+		return
+	}
+	s.spilled = len(rest)
+	if s.spilled == 0 {
+		s.log.Info("spilled page events published", "events", sent)
+	}
+}
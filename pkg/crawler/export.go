@@ -0,0 +1,327 @@
+package crawler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checksumsFile is the last entry of an export, listing the SHA-256 of
+// every other entry in sha256sum format
+const checksumsFile = "SHA256SUMS"
+
+// archiveFormat is "zip" or "tar.gz", from an archive's file name
+func archiveFormat(name string) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", nil
+	}
+	return "", fmt.Errorf("%s: the archive must end in .zip, .tar.gz or .tgz", name)
+}
+
+// archiveEntries is how the two formats are written: create starts an
+// entry and returns where its body goes
+type archiveEntries interface {
+	create(name string, size int64, modified time.Time) (io.Writer, error)
+	Close() error
+}
+
+type zipEntries struct{ w *zip.Writer }
+
+func (z zipEntries) create(name string, size int64, modified time.Time) (io.Writer, error) {
+	return z.w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+}
+
+func (z zipEntries) Close() error { return z.w.Close() }
+
+type tarEntries struct {
+	tw *tar.Writer
+	zw *gzip.Writer
+}
+
+func (t tarEntries) create(name string, size int64, modified time.Time) (io.Writer, error) {
+	err := t.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modified, Typeflag: tar.TypeReg, Format: tar.FormatPAX})
+	return t.tw, err
+}
+
+func (t tarEntries) Close() error {
+	return errors.Join(t.tw.Close(), t.zw.Close())
+}
+
+// Export packs the project folder, downloads, manifest, JSONL files and
+// reports alike, into one .zip or .tar.gz archive at dest. Entries are in
+// path order with paths relative to the project folder, and a SHA256SUMS
+// entry comes last. Files are streamed, so memory use doesn't grow with
+// the crawl. The lock is held meanwhile so no crawl changes the folder.
+func (c *Crawler) Export(dest string) (err error) {
+	format, err := archiveFormat(dest)
+	if err != nil {
+		return err
+	}
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+	destAbs, _ := filepath.Abs(dest)
+
+	var files []string
+	err = filepath.WalkDir(c.cfg.ProjectFolder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || p == c.lockFileName || strings.Contains(d.Name(), ".tmp-") {
+			return nil
+		}
+		if abs, _ := filepath.Abs(p); abs == destAbs {
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+	buffered := bufio.NewWriter(out)
+	var entries archiveEntries
+	if format == "zip" {
+		entries = zipEntries{w: zip.NewWriter(buffered)}
+	} else {
+		zw := gzip.NewWriter(buffered)
+		entries = tarEntries{tw: tar.NewWriter(zw), zw: zw}
+	}
+
+	var sums strings.Builder
+	for _, p := range files {
+		rel, err := filepath.Rel(c.cfg.ProjectFolder, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		sum, err := exportFile(entries, p, name)
+		if err != nil {
+			return fmt.Errorf("exporting %s: %w", p, err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, name)
+	}
+	w, err := entries.create(checksumsFile, int64(sums.Len()), time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, sums.String()); err != nil {
+		return err
+	}
+	if err := entries.Close(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	c.log().Info("exported", "files", len(files), "archive", dest)
+	return nil
+}
+
+// exportFile copies one file into the archive, returning its SHA-256
+func exportFile(entries archiveEntries, p, name string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	w, err := entries.create(name, info.Size(), info.ModTime())
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	// A file still growing would break the tar entry's size
+	if _, err := io.CopyN(io.MultiWriter(w, h), f, info.Size()); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Import unpacks an archive made by Export into the project folder, which
+// must not exist yet or be empty, verifying every file against the
+// archive's SHA256SUMS. If anything doesn't match, the folder is removed
+// again.
+func (c *Crawler) Import(src string) (err error) {
+	format, err := archiveFormat(src)
+	if err != nil {
+		return err
+	}
+	dir := c.cfg.ProjectFolder
+	if existing, err := os.ReadDir(dir); err == nil && len(existing) > 0 {
+		return fmt.Errorf("%s already exists and is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	got := make(map[string]string)
+	var sums []byte
+	extract := func(name string, r io.Reader, modified time.Time) error {
+		clean := path.Clean(name)
+		if !fs.ValidPath(clean) || clean == "." {
+			return fmt.Errorf("unsafe path %q in archive", name)
+		}
+		if clean == checksumsFile {
+			data, err := io.ReadAll(r)
+			sums = data
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(clean))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(f, h), r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		got[clean] = hex.EncodeToString(h.Sum(nil))
+		return os.Chtimes(target, modified, modified)
+	}
+	if format == "zip" {
+		err = importZip(src, extract)
+	} else {
+		err = importTar(src, extract)
+	}
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksums(sums, got); err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	c.log().Info("imported", "files", len(got), "folder", dir)
+	return nil
+}
+
+func importZip(src string, extract func(name string, r io.Reader, modified time.Time) error) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = extract(f.Name, r, f.Modified)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importTar(src string, extract func(name string, r io.Reader, modified time.Time) error) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := extract(h.Name, tr, h.ModTime); err != nil {
+			return err
+		}
+	}
+}
+
+// verifyChecksums compares the unpacked files with the SHA256SUMS lines,
+// reporting every file that is missing, extra or different
+func verifyChecksums(sums []byte, got map[string]string) error {
+	if sums == nil {
+		return fmt.Errorf("no %s in the archive", checksumsFile)
+	}
+	want := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(sums)), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return fmt.Errorf("malformed %s line %q", checksumsFile, line)
+		}
+		want[name] = sum
+	}
+	var problems []string
+	for name, sum := range want {
+		switch actual, ok := got[name]; {
+		case !ok:
+			problems = append(problems, name+" is missing")
+		case actual != sum:
+			problems = append(problems, name+" does not match its checksum")
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			problems = append(problems, name+" is not in "+checksumsFile)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("archive failed verification: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package crawler
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// pageContext is what link extraction needs to know about a page besides
+// its body
+type pageContext struct {
+	// URL is where the page was served from, after any redirects
+	URL    string
+	Header http.Header
+}
+
+// pageInfo is what parsing a saved page yields, split by what a URL is for
+// so the caller can decide what to crawl and what to merely record.
+//
+// Links are in-scope a, area and link rel=next/prev hrefs and Frames the
+// in-scope iframe and frame sources; both are pages. External holds the
+// http(s) links and frames outside BaseURL. Assets are stylesheets, icons,
+// scripts, images and media sources and CSS url() references from any
+// host, with every srcset candidate listed; Images, with CrawlImages, are
+// the images shown and CSS backgrounds among them. Canonical
+// and Alternates come from <link rel>, Metadata from the <meta> tags and
+// <html lang>. Refresh is where a meta refresh, or with ScriptRedirects
+// an inline script, sends the browser, and is among Links or External
+// too; RefreshKind says which it was. Next and Prev are the in-scope
+// rel="next" and rel="prev" of a <link>, an <a> or the Link header, also
+// among Links. NoFollow is set when the page asked
+// for none of its links to be followed; Links, Frames and External are
+// then empty.
+type pageInfo struct {
+	Links     []string
+	Frames    []string
+	External  []string
+	Assets    []string
+	Images    []imageRef
+	Canonical string
+	// ExtraCanonicals are the canonical tags after the first, which are
+	// ignored
+	ExtraCanonicals []string
+	Alternates      []string
+	// Translations are the Alternates with an hreflang
+	Translations []translation
+	Next         string
+	Prev         string
+	Refresh      string
+	// RefreshKind is refreshMeta or refreshScript
+	RefreshKind string
+	// Insecure are the http:// references of a page served over HTTPS
+	Insecure []insecureRef
+	// LinkSchemes counts the a, area and frame hrefs with a scheme other
+	// than http or https, like mailto or javascript, by scheme; Malformed
+	// are those that don't parse. Neither is ever crawled. Contacts, with
+	// Config.ExtractContacts, are the mailto: and tel: ones, each once.
+	LinkSchemes map[string]int
+	Malformed   []string
+	Contacts    []contact
+	// ScriptLinks, with Config.ScriptLinks, are the in-scope pages found in
+	// inline scripts and JSON blocks and nowhere else on the page
+	ScriptLinks []string
+	Title       string
+	NoFollow    bool
+	Metadata    pageMetadata
+
+	// doc is the parsed page, for the extraction rules
+	doc *goquery.Document
+}
+
+// extractLinksFromHTML parses a page and returns its title and the URLs on
+// it, resolved against the page URL (or the page's <base href>, if it has
+// one) so relative hrefs like "../about" end up absolute. Unless
+// RESPECT_NOFOLLOW is off, nofollow links are skipped and a nofollow meta
+// tag or X-Robots-Tag drops them all.
+func (c *Crawler) extractLinksFromHTML(pc pageContext, html io.Reader) (*pageInfo, error) {
+	page, err := url.Parse(pc.URL)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(html)
+	if err != nil {
+		return nil, err
+	}
+
+	// A relative <base href> is itself resolved against the page URL
+	if baseHref, exists := doc.Find("base[href]").First().Attr("href"); exists {
+		if ref, err := url.Parse(strings.TrimSpace(baseHref)); err == nil {
+			page = page.ResolveReference(ref)
+		}
+	}
+	// resolve encodes what an href needs encoded to be requested, as
+	// cleanHref and encodeURL have it, then resolves it
+	resolve := func(raw string) (*url.URL, bool) {
+		raw = cleanHref(raw)
+		if raw == "" {
+			return nil, false
+		}
+		ref, err := url.Parse(raw)
+		if err != nil {
+			return nil, false
+		}
+		u := page.ResolveReference(ref)
+		encodeURL(u)
+		return u, u.Scheme == "http" || u.Scheme == "https"
+	}
+
+	info := &pageInfo{Title: strings.TrimSpace(doc.Find("title").First().Text()), Metadata: readMetadata(doc), doc: doc}
+	if c.cfg.RespectNofollow {
+		info.NoFollow = hasNofollow(info.Metadata.Robots) || headerNofollow(pc.Header, c.robotsToken())
+	}
+
+	// follow sorts a page URL into the in-scope list or the external ones
+	follow := func(into *[]string, raw string) {
+		u, ok := resolve(raw)
+		if !ok || info.NoFollow {
+			return
+		}
+		if link := u.String(); c.inScope(link) {
+			*into = append(*into, link)
+		} else {
+			u.Fragment = ""
+			info.External = append(info.External, u.String())
+		}
+	}
+	// paginate keeps the first in-scope next or prev the page names
+	paginate := func(rel, raw string) {
+		u, ok := resolve(raw)
+		if !ok || info.NoFollow {
+			return
+		}
+		u.Fragment = ""
+		if link := u.String(); c.inScope(link) {
+			if hasToken(rel, "next") && info.Next == "" {
+				info.Next = link
+			}
+			if hasToken(rel, "prev") && info.Prev == "" {
+				info.Prev = link
+			}
+		}
+	}
+	// navigable counts an href that isn't a link to a page and tells
+	// whether it is one
+	seenContacts := make(map[contact]bool)
+	navigable := func(raw string) bool {
+		scheme, u, malformed := linkScheme(raw)
+		switch {
+		case malformed:
+			info.Malformed = append(info.Malformed, raw)
+			return false
+		case scheme == "":
+			return true
+		}
+		if info.LinkSchemes == nil {
+			info.LinkSchemes = make(map[string]int)
+		}
+		info.LinkSchemes[scheme]++
+		if c.cfg.ExtractContacts {
+			for _, ct := range contactsOf(scheme, u) {
+				if !seenContacts[ct] {
+					seenContacts[ct] = true
+					info.Contacts = append(info.Contacts, ct)
+				}
+			}
+		}
+		return false
+	}
+	asset := func(raw string) {
+		if u, ok := resolve(raw); ok {
+			u.Fragment = ""
+			info.Assets = append(info.Assets, u.String())
+		}
+	}
+
+	doc.Find("a[href], area[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		if !navigable(href) {
+			return
+		}
+		if rel, _ := s.Attr("rel"); c.cfg.RespectNofollow && hasToken(rel, "nofollow") {
+			return
+		}
+		follow(&info.Links, href)
+		if rel, _ := s.Attr("rel"); hasToken(rel, "next") || hasToken(rel, "prev") {
+			paginate(rel, href)
+		}
+	})
+	doc.Find("iframe[src], frame[src]").Each(func(i int, s *goquery.Selection) {
+		if src, _ := s.Attr("src"); navigable(src) {
+			follow(&info.Frames, src)
+		}
+	})
+	doc.Find("link[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		rel, _ := s.Attr("rel")
+		switch {
+		case hasToken(rel, "canonical"):
+			if u, ok := resolve(href); ok && info.Canonical == "" {
+				info.Canonical = u.String()
+			} else if ok {
+				info.ExtraCanonicals = append(info.ExtraCanonicals, u.String())
+			}
+		case hasToken(rel, "alternate"):
+			if u, ok := resolve(href); ok {
+				info.Alternates = append(info.Alternates, u.String())
+				if lang, _ := s.Attr("hreflang"); lang != "" && !info.NoFollow {
+					u.Fragment = ""
+					info.Translations = append(info.Translations, translation{Lang: lang, URL: u.String()})
+				}
+			}
+		case hasToken(rel, "stylesheet"), hasToken(rel, "icon"), hasToken(rel, "apple-touch-icon"):
+			asset(href)
+		case hasToken(rel, "next"), hasToken(rel, "prev"):
+			follow(&info.Links, href)
+			paginate(rel, href)
+		}
+	})
+	for _, rel := range []string{"next", "prev"} {
+		for _, target := range linkHeader(pc.Header, rel) {
+			follow(&info.Links, target)
+			paginate(rel, target)
+		}
+	}
+	doc.Find("img, source, script[src]").Each(func(i int, s *goquery.Selection) {
+		if src, ok := s.Attr("src"); ok {
+			asset(src)
+		}
+		if srcset, ok := s.Attr("srcset"); ok {
+			for _, candidate := range parseSrcset(srcset) {
+				asset(candidate)
+			}
+		}
+	})
+
+	if c.cfg.CrawlImages {
+		info.Images = extractImages(doc, page, resolve)
+	}
+	if strings.HasPrefix(pc.URL, "https:") {
+		info.Insecure = insecureRefs(doc, resolve)
+	}
+
+	// A stub page that moves the browser on is a redirect in all but name
+	refresh, kind := "", refreshMeta
+	if c.cfg.MetaRefresh {
+		refresh = metaRefresh(doc, c.cfg.MetaRefreshMaxDelay)
+	}
+	if refresh == "" && c.cfg.ScriptRedirects {
+		refresh, kind = scriptRedirect(doc), refreshScript
+	}
+	if u, ok := resolve(refresh); ok {
+		u.Fragment = ""
+		info.Refresh, info.RefreshKind = u.String(), kind
+		follow(&info.Links, info.Refresh)
+	}
+
+	if c.cfg.ScriptLinks && !info.NoFollow {
+		info.ScriptLinks = c.scriptLinks(doc, page, c.cfg.ScriptScanLimit, append(info.Links, info.Frames...))
+	}
+
+	// url() references in <style> blocks and style attributes
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		info.Assets = append(info.Assets, cssReferences(page, s.Text())...)
+	})
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		style, _ := s.Attr("style")
+		info.Assets = append(info.Assets, cssReferences(page, style)...)
+	})
+	return info, nil
+}
+
+// parseSrcset returns the URLs of a srcset attribute, dropping the width
+// and density descriptors. A URL runs to the next whitespace and may
+// contain commas itself; only trailing commas end it early, as the HTML
+// spec's parsing algorithm has it.
+func parseSrcset(srcset string) []string {
+	var urls []string
+	s := srcset
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f,")
+		if s == "" {
+			return urls
+		}
+		end := strings.IndexAny(s, " \t\n\r\f")
+		if end < 0 {
+			end = len(s)
+		}
+		candidate := s[:end]
+		s = s[end:]
+		if trimmed := strings.TrimRight(candidate, ","); trimmed != candidate {
+			// "a.png, b.png 2x": the comma ended this candidate early
+			urls = append(urls, trimmed)
+			continue
+		}
+		urls = append(urls, candidate)
+		s = s[descriptorsEnd(s):]
+	}
+}
+
+// descriptorsEnd finds the comma ending a srcset candidate's descriptors,
+// ignoring commas inside parentheses
+func descriptorsEnd(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// hasNofollow reports whether a comma-separated robots directive list, as
+// found in <meta name="robots"> or X-Robots-Tag, forbids following links.
+// "none" is shorthand for noindex, nofollow.
+func hasNofollow(directives string) bool {
+	for _, d := range strings.Split(directives, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "nofollow" || d == "none" {
+			return true
+		}
+	}
+	return false
+}
+
+// headerNofollow checks every X-Robots-Tag value. A value may be scoped to
+// a crawler, as in "googlebot: nofollow", and only counts if that crawler
+// is us, by our robots token.
+func headerNofollow(h http.Header, token string) bool {
+	for _, v := range h.Values("X-Robots-Tag") {
+		if agent, rest, ok := strings.Cut(v, ":"); ok && isRobotsAgent(agent) {
+			if !strings.Contains(token, strings.ToLower(strings.TrimSpace(agent))) {
+				continue
+			}
+			v = rest
+		}
+		if hasNofollow(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRobotsAgent tells a crawler name before a colon apart from directives
+// that take a value, such as "unavailable_after: 2025-01-01"
+func isRobotsAgent(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || strings.ContainsAny(s, " ,") {
+		return false
+	}
+	switch s {
+	case "unavailable_after", "max-snippet", "max-image-preview", "max-video-preview":
+		return false
+	}
+	return true
+}
+
+// hasToken reports whether a space-separated attribute such as rel lists
+// token, ignoring case
+func hasToken(attr, token string) bool {
+	for _, t := range strings.Fields(attr) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
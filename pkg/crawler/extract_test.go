@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// extract is what extractLinksFromHTML finds on page, served from pageURL
+// without headers
+func extract(t *testing.T, c *Crawler, pageURL, page string) *pageInfo {
+	t.Helper()
+	info, err := c.extractLinksFromHTML(pageContext{URL: pageURL}, strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("extracting from %s: %v", pageURL, err)
+	}
+	return info
+}
+
+// TestExtractRelativeLinks resolves each kind of relative href against the
+// page URL
+func TestExtractRelativeLinks(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	const page = "https://example.com/docs/guide/page?tab=1"
+	tests := []struct {
+		name, href string
+		want       []string
+	}{
+		{"parent", "../about", []string{"https://example.com/docs/about"}},
+		{"parents past the root", "../../../../up", []string{"https://example.com/up"}},
+		{"dot segments inside", "./a/../b/./c", []string{"https://example.com/docs/guide/b/c"}},
+		{"root relative with dots", "/docs/../top", []string{"https://example.com/top"}},
+		{"query only", "?page=2", []string{"https://example.com/docs/guide/page?page=2"}},
+		{"query only with a fragment", "?page=3#list", []string{"https://example.com/docs/guide/page?page=3#list"}},
+		{"protocol relative", "//example.com/cdn/x", []string{"https://example.com/cdn/x"}},
+		{"protocol relative off site", "//other.example/x", nil},
+		{"padded", "  /padded  ", []string{"https://example.com/padded"}},
+		{"padded with tabs and newlines", "\n\t../tabbed\r\n", []string{"https://example.com/docs/tabbed"}},
+		{"padded query only", " ?page=4 ", []string{"https://example.com/docs/guide/page?page=4"}},
+		{"padded protocol relative", "\t//example.com/cdn/y ", []string{"https://example.com/cdn/y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := extract(t, c, page, `<html><body><a href="`+tt.href+`">link</a></body></html>`)
+			if !reflect.DeepEqual(info.Links, tt.want) {
+				t.Errorf("href %q: links = %q, want %q", tt.href, info.Links, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractBaseHref(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	tests := []struct {
+		name string
+		head string
+		want []string
+	}{
+		{"no base", ``, []string{"https://example.com/docs/guide/intro", "https://example.com/docs/about", "https://example.com/top"}},
+		{"absolute base", `<base href="https://example.com/v2/">`, []string{"https://example.com/v2/intro", "https://example.com/about", "https://example.com/top"}},
+		{"relative base", `<base href="../blog/">`, []string{"https://example.com/docs/blog/intro", "https://example.com/docs/about", "https://example.com/top"}},
+		{"root-relative base", `<base href="/a/b/">`, []string{"https://example.com/a/b/intro", "https://example.com/a/about", "https://example.com/top"}},
+		{"only the first base counts", `<base href="/first/"><base href="/second/">`, []string{"https://example.com/first/intro", "https://example.com/about", "https://example.com/top"}},
+		{"base without href", `<base target="_blank">`, []string{"https://example.com/docs/guide/intro", "https://example.com/docs/about", "https://example.com/top"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := `<html><head>` + tt.head + `</head><body><a href="intro">i</a><a href="../about">a</a><a href="/top">t</a></body></html>`
+			info := extract(t, c, "https://example.com/docs/guide/page", page)
+			if !reflect.DeepEqual(info.Links, tt.want) {
+				t.Errorf("links = %v, want %v", info.Links, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractNofollow(t *testing.T) {
+	const (
+		a = "https://example.com/a"
+		b = "https://example.com/b"
+	)
+	tests := []struct {
+		name     string
+		rel      string
+		head     string
+		header   http.Header
+		ignore   bool
+		want     []string
+		noFollow bool
+	}{
+		{name: "nothing", want: []string{a, b}},
+		{name: "nofollow on the anchor", rel: "external nofollow", want: []string{b}},
+		{name: "meta nofollow", head: `<meta name="robots" content="index, NoFollow">`, noFollow: true},
+		{name: "meta none", head: `<meta name="robots" content="none">`, noFollow: true},
+		{name: "meta noindex only", head: `<meta name="robots" content="noindex">`, want: []string{a, b}},
+		{name: "header nofollow", header: http.Header{"X-Robots-Tag": {"nofollow"}}, noFollow: true},
+		{name: "header for us", header: http.Header{"X-Robots-Tag": {"simple-web-scraper: nofollow"}}, noFollow: true},
+		{name: "header for another bot", header: http.Header{"X-Robots-Tag": {"googlebot: nofollow"}}, want: []string{a, b}},
+		{name: "header unavailable_after", header: http.Header{"X-Robots-Tag": {"unavailable_after: 2030-01-01"}}, want: []string{a, b}},
+		{name: "all ignored", rel: "nofollow", head: `<meta name="robots" content="nofollow">`, header: http.Header{"X-Robots-Tag": {"nofollow"}}, ignore: true, want: []string{a, b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.RespectNofollow = !tt.ignore })
+			page := `<html><head>` + tt.head + `</head><body><a href="/a" rel="` + tt.rel + `">a</a><a href="/b">b</a></body></html>`
+			info, err := c.extractLinksFromHTML(pageContext{URL: "https://example.com/", Header: tt.header}, strings.NewReader(page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info.Links, tt.want) || info.NoFollow != tt.noFollow {
+				t.Errorf("links %v, nofollow %v, want %v, %v", info.Links, info.NoFollow, tt.want, tt.noFollow)
+			}
+		})
+	}
+}
+
+func TestExtractLinkSources(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	tests := []struct {
+		name                        string
+		body                        string
+		links, frames, assets, alts []string
+		external                    []string
+		canonical                   string
+	}{
+		{name: "anchor", body: `<a href="/a">a</a>`, links: []string{"https://example.com/a"}},
+		{name: "area", body: `<map><area href="/region" shape="rect"></map>`, links: []string{"https://example.com/region"}},
+		{name: "iframe", body: `<iframe src="/embed"></iframe>`, frames: []string{"https://example.com/embed"}},
+		{name: "frameset", body: `<frameset><frame src="menu.html"><frame src="/main"></frameset>`, frames: []string{"https://example.com/menu.html", "https://example.com/main"}},
+		{name: "external iframe", body: `<iframe src="https://video.example.net/v/1"></iframe>`, external: []string{"https://video.example.net/v/1"}},
+		{name: "stylesheet and icon", body: `<link rel="stylesheet" href="/site.css"><link rel="icon" href="/favicon.ico">`, assets: []string{"https://example.com/site.css", "https://example.com/favicon.ico"}},
+		{name: "canonical", body: `<link rel="canonical" href="/page">`, canonical: "https://example.com/page"},
+		{name: "alternate", body: `<link rel="alternate" type="application/rss+xml" href="/feed.xml">`, alts: []string{"https://example.com/feed.xml"}},
+		{name: "unknown rel", body: `<link rel="preconnect" href="https://cdn.example.net">`},
+		{name: "img src and srcset", body: `<img src="/a.png" srcset="/a-2x.png 2x, /a-3x.png 3x">`, assets: []string{"https://example.com/a.png", "https://example.com/a-2x.png", "https://example.com/a-3x.png"}},
+		{name: "picture source", body: `<picture><source srcset="/wide.webp 1200w, /narrow.webp 600w"></picture>`, assets: []string{"https://example.com/wide.webp", "https://example.com/narrow.webp"}},
+		{name: "script", body: `<script src="/app.js"></script>`, assets: []string{"https://example.com/app.js"}},
+		{name: "asset fragment dropped", body: `<img src="/sprite.svg#icon">`, assets: []string{"https://example.com/sprite.svg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := extract(t, c, "https://example.com/", `<html><head></head>`+tt.body+`</html>`)
+			for _, got := range []struct {
+				what      string
+				got, want []string
+			}{
+				{"links", info.Links, tt.links},
+				{"frames", info.Frames, tt.frames},
+				{"external", info.External, tt.external},
+				{"assets", info.Assets, tt.assets},
+				{"alternates", info.Alternates, tt.alts},
+			} {
+				if !reflect.DeepEqual(got.got, got.want) {
+					t.Errorf("%s = %v, want %v", got.what, got.got, got.want)
+				}
+			}
+			if info.Canonical != tt.canonical {
+				t.Errorf("canonical = %q, want %q", info.Canonical, tt.canonical)
+			}
+		})
+	}
+}
+
+func TestParseSrcset(t *testing.T) {
+	tests := []struct {
+		srcset string
+		want   []string
+	}{
+		{"", nil},
+		{"a.png", []string{"a.png"}},
+		{"a.png 1x", []string{"a.png"}},
+		{"a.png 480w, b.png 800w, c.png 1200w", []string{"a.png", "b.png", "c.png"}},
+		{"a.png 1x,b.png 2x", []string{"a.png", "b.png"}},
+		{"a.png, b.png 2x", []string{"a.png", "b.png"}},
+		{"  a.png  1x ,\n b.png\t2x  ", []string{"a.png", "b.png"}},
+		{"img.php?w=1,2 1x, b.png 2x", []string{"img.php?w=1,2", "b.png"}},
+		{"a.png 100w (max-width: 1,2), b.png", []string{"a.png", "b.png"}},
+		{",,a.png", []string{"a.png"}},
+	}
+	for _, tt := range tests {
+		if got := parseSrcset(tt.srcset); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSrcset(%q) = %q, want %q", tt.srcset, got, tt.want)
+		}
+	}
+}
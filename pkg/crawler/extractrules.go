@@ -0,0 +1,188 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// ExtractRule pulls named fields out of every page whose URL it matches.
+// Several rules can match one page, each giving its own record.
+type ExtractRule struct {
+	Name   string
+	URL    *regexp.Regexp
+	Fields []ExtractField
+}
+
+// ExtractField is one value a rule extracts: the text of the first element
+// Selector matches, or its Attr attribute if set. With All it is every
+// match instead, as an array.
+type ExtractField struct {
+	Name     string
+	Selector string
+	Attr     string
+	All      bool
+}
+
+// compiledRule is an ExtractRule with its selectors parsed
+type compiledRule struct {
+	ExtractRule
+	matchers []goquery.Matcher
+}
+
+// compileRule checks a rule and parses its selectors, naming the rule and
+// field in every problem
+func compileRule(r ExtractRule) (compiledRule, error) {
+	var errs []error
+	if r.Name == "" {
+		errs = append(errs, errors.New("extraction rule without a name"))
+	}
+	if r.URL == nil {
+		errs = append(errs, fmt.Errorf("extraction rule %q has no url pattern", r.Name))
+	}
+	if len(r.Fields) == 0 {
+		errs = append(errs, fmt.Errorf("extraction rule %q has no fields", r.Name))
+	}
+	compiled := compiledRule{ExtractRule: r}
+	seen := make(map[string]bool)
+	for _, f := range r.Fields {
+		if f.Name == "" || seen[f.Name] {
+			errs = append(errs, fmt.Errorf("extraction rule %q has an empty or repeated field name %q", r.Name, f.Name))
+		}
+		seen[f.Name] = true
+		m, err := cascadia.Compile(f.Selector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("extraction rule %q, field %q: invalid selector %q: %v", r.Name, f.Name, f.Selector, err))
+			continue
+		}
+		compiled.matchers = append(compiled.matchers, m)
+	}
+	return compiled, errors.Join(errs...)
+}
+
+// ruleFile is the JSON an extraction rules file holds: a list of rules
+// whose fields are a selector, for the element's text, or an object
+type ruleFile []struct {
+	Name   string                     `json:"name"`
+	URL    string                     `json:"url"`
+	Fields map[string]json.RawMessage `json:"fields"`
+}
+
+// LoadExtractRules reads the extraction rules in a JSON file, reporting
+// every problem in it rather than just the first
+func LoadExtractRules(path string) ([]ExtractRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file ruleFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var rules []ExtractRule
+	var errs []error
+	names := make(map[string]bool)
+	for _, raw := range file {
+		r := ExtractRule{Name: raw.Name}
+		if names[raw.Name] {
+			errs = append(errs, fmt.Errorf("%s: extraction rule %q is defined twice", path, raw.Name))
+		}
+		names[raw.Name] = true
+		if raw.URL != "" {
+			if r.URL, err = regexp.Compile(raw.URL); err != nil {
+				errs = append(errs, fmt.Errorf("%s: extraction rule %q: invalid url pattern %q: %v", path, raw.Name, raw.URL, err))
+				continue
+			}
+		}
+		// Sorted so the fields come out in the same order every run
+		fieldNames := make([]string, 0, len(raw.Fields))
+		for name := range raw.Fields {
+			fieldNames = append(fieldNames, name)
+		}
+		sort.Strings(fieldNames)
+		for _, name := range fieldNames {
+			f := ExtractField{Name: name}
+			var spec struct {
+				Selector string `json:"selector"`
+				Attr     string `json:"attr"`
+				All      bool   `json:"all"`
+			}
+			if json.Unmarshal(raw.Fields[name], &f.Selector) != nil {
+				if err := json.Unmarshal(raw.Fields[name], &spec); err != nil {
+					errs = append(errs, fmt.Errorf("%s: extraction rule %q, field %q: want a selector or {selector, attr, all}", path, raw.Name, name))
+					continue
+				}
+				f.Selector, f.Attr, f.All = spec.Selector, spec.Attr, spec.All
+			}
+			r.Fields = append(r.Fields, f)
+		}
+		if _, err := compileRule(r); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, errors.Join(errs...)
+}
+
+// extractedRecord is one line of extracted.jsonl. A field whose selector
+// matched nothing is null.
+type extractedRecord struct {
+	Rule        string         `json:"rule"`
+	URL         string         `json:"url"`
+	ExtractedAt time.Time      `json:"extracted_at"`
+	Fields      map[string]any `json:"fields"`
+}
+
+// applyExtractRules writes a record for every rule matching url, read from
+// the already parsed page
+func (c *Crawler) applyExtractRules(url string, doc *goquery.Document) {
+	if c.extracted == nil || doc == nil {
+		return
+	}
+	now := time.Now().UTC()
+	for _, r := range c.extractRules {
+		if !r.URL.MatchString(url) {
+			continue
+		}
+		fields := make(map[string]any, len(r.Fields))
+		for i, f := range r.Fields {
+			var values []string
+			doc.FindMatcher(r.matchers[i]).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+				if v, ok := fieldValue(s, f.Attr); ok {
+					values = append(values, v)
+				}
+				return f.All || len(values) == 0
+			})
+			switch {
+			case len(values) == 0:
+				fields[f.Name] = nil
+			case f.All:
+				fields[f.Name] = values
+			default:
+				fields[f.Name] = values[0]
+			}
+		}
+		_ = c.extracted.write(extractedRecord{Rule: r.Name, URL: url, ExtractedAt: now, Fields: fields})
+	}
+}
+
+// fieldValue is an element's attr attribute, or its text with the
+// whitespace collapsed when attr is empty
+func fieldValue(s *goquery.Selection, attr string) (string, bool) {
+	if attr != "" {
+		return s.Attr(attr)
+	}
+	return strings.Join(strings.Fields(s.Text()), " "), true
+}
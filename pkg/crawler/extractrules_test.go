@@ -0,0 +1,217 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestCompileRule(t *testing.T) {
+	url := regexp.MustCompile(`/products/`)
+	tests := []struct {
+		name     string
+		rule     ExtractRule
+		problems []string
+	}{
+		{"valid", ExtractRule{Name: "product", URL: url, Fields: []ExtractField{{Name: "title", Selector: "h1"}, {Name: "photos", Selector: "img", Attr: "src", All: true}}}, nil},
+		{"no name", ExtractRule{URL: url, Fields: []ExtractField{{Name: "title", Selector: "h1"}}}, []string{"without a name"}},
+		{"no url", ExtractRule{Name: "product", Fields: []ExtractField{{Name: "title", Selector: "h1"}}}, []string{`"product" has no url pattern`}},
+		{"no fields", ExtractRule{Name: "product", URL: url}, []string{`"product" has no fields`}},
+		{"repeated field", ExtractRule{Name: "product", URL: url, Fields: []ExtractField{{Name: "title", Selector: "h1"}, {Name: "title", Selector: "h2"}}}, []string{`repeated field name "title"`}},
+		{"bad selector", ExtractRule{Name: "product", URL: url, Fields: []ExtractField{{Name: "price", Selector: "span["}}}, []string{`field "price": invalid selector "span["`}},
+		{
+			"every problem",
+			ExtractRule{Fields: []ExtractField{{Name: "", Selector: "h1"}, {Name: "price", Selector: "::"}}},
+			[]string{"without a name", "has no url pattern", "empty or repeated field name", `invalid selector "::"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileRule(tt.rule)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("compileRule: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("compileRule succeeded, want %q", tt.problems)
+			}
+			for _, p := range tt.problems {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("error %q doesn't mention %q", err, p)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadExtractRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		want     []ExtractRule
+		problems []string
+	}{
+		{
+			name: "selectors and objects",
+			file: `[{"name": "product", "url": "/products/", "fields": {
+				"title": "h1",
+				"photos": {"selector": "img.photo", "attr": "src", "all": true}
+			}}]`,
+			want: []ExtractRule{{Name: "product", URL: regexp.MustCompile(`/products/`), Fields: []ExtractField{
+				{Name: "photos", Selector: "img.photo", Attr: "src", All: true},
+				{Name: "title", Selector: "h1"},
+			}}},
+		},
+		{name: "not a list", file: `{"name": "product"}`, problems: []string{"cannot unmarshal object"}},
+		{name: "unknown key", file: `[{"name": "product", "url": "/", "fields": {"t": "h1"}, "selector": "h1"}]`, problems: []string{`unknown field "selector"`}},
+		{
+			name: "every problem",
+			file: `[
+				{"name": "a", "url": "(", "fields": {"t": "h1"}},
+				{"name": "b", "url": "/", "fields": {"t": 3}},
+				{"name": "b", "url": "/", "fields": {"t": "h1["}}
+			]`,
+			problems: []string{`rule "a": invalid url pattern "("`, `rule "b", field "t": want a selector`, `"b" is defined twice`, `invalid selector "h1["`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := LoadExtractRules(path)
+			for _, p := range tt.problems {
+				if err == nil || !strings.Contains(err.Error(), p) {
+					t.Errorf("error %v doesn't mention %q", err, p)
+				}
+			}
+			if len(tt.problems) > 0 {
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadExtractRules: %v", err)
+			}
+			if len(rules) != len(tt.want) {
+				t.Fatalf("got %d rules, want %d", len(rules), len(tt.want))
+			}
+			for i, r := range rules {
+				w := tt.want[i]
+				if r.Name != w.Name || r.URL.String() != w.URL.String() || !reflect.DeepEqual(r.Fields, w.Fields) {
+					t.Errorf("rule %d = %+v, want %+v", i, r, w)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyExtractRules(t *testing.T) {
+	page, err := os.ReadFile("testdata/product.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	product := ExtractRule{Name: "product", URL: regexp.MustCompile(`/products/`), Fields: []ExtractField{
+		{Name: "title", Selector: "h1.product-title"},
+		{Name: "price", Selector: ".price"},
+		{Name: "currency", Selector: ".price", Attr: "data-currency"},
+		{Name: "sku", Selector: "[itemprop=sku]"},
+		{Name: "sizes", Selector: ".sizes li", All: true},
+		{Name: "photos", Selector: "img.photo", Attr: "src", All: true},
+		{Name: "first_photo", Selector: "img.photo", Attr: "src"},
+		{Name: "rating", Selector: ".rating"},
+		{Name: "reviews", Selector: ".review", All: true},
+		{Name: "missing_attr", Selector: "h1", Attr: "data-id"},
+	}}
+	page2 := ExtractRule{Name: "page", URL: regexp.MustCompile(`.`), Fields: []ExtractField{{Name: "title", Selector: "title"}}}
+	tests := []struct {
+		name  string
+		url   string
+		rules []ExtractRule
+		want  []extractedRecord
+	}{
+		{
+			name:  "fields of every kind",
+			url:   "https://example.com/products/trs-42",
+			rules: []ExtractRule{product},
+			want: []extractedRecord{{Rule: "product", URL: "https://example.com/products/trs-42", Fields: map[string]any{
+				"title":        "Trail Running Shoe",
+				"price":        "89.00",
+				"currency":     "EUR",
+				"sku":          "TRS-42",
+				"sizes":        []any{"40", "41", "42"},
+				"photos":       []any{"/img/shoe-front.jpg", "/img/shoe-side.jpg"},
+				"first_photo":  "/img/shoe-front.jpg",
+				"rating":       nil,
+				"reviews":      nil,
+				"missing_attr": nil,
+			}}},
+		},
+		{
+			name:  "a record per matching rule",
+			url:   "https://example.com/products/trs-42",
+			rules: []ExtractRule{page2, product},
+			want: []extractedRecord{
+				{Rule: "page", URL: "https://example.com/products/trs-42", Fields: map[string]any{"title": "Trail Running Shoe | Example Outfitters"}},
+				{Rule: "product", URL: "https://example.com/products/trs-42"},
+			},
+		},
+		{
+			name:  "no rule matches",
+			url:   "https://example.com/about",
+			rules: []ExtractRule{product},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.ExtractRules = tt.rules })
+			path := filepath.Join(t.TempDir(), "extracted.jsonl")
+			if c.extracted, err = openJSONL(path); err != nil {
+				t.Fatal(err)
+			}
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(page)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.applyExtractRules(tt.url, doc)
+			c.extracted.close()
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var got []extractedRecord
+			for scanner := bufio.NewScanner(f); scanner.Scan(); {
+				var r extractedRecord
+				if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+					t.Fatalf("line %q: %v", scanner.Text(), err)
+				}
+				if r.ExtractedAt.IsZero() {
+					t.Errorf("%s record has no time", r.Rule)
+				}
+				got = append(got, r)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d records, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, r := range got {
+				w := tt.want[i]
+				if r.Rule != w.Rule || r.URL != w.URL {
+					t.Errorf("record %d is %s for %s, want %s for %s", i, r.Rule, r.URL, w.Rule, w.URL)
+				}
+				if w.Fields != nil && !reflect.DeepEqual(r.Fields, w.Fields) {
+					t.Errorf("%s fields = %v, want %v", r.Rule, r.Fields, w.Fields)
+				}
+			}
+		})
+	}
+}
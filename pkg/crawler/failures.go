@@ -0,0 +1,210 @@
+package crawler
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The classes a failure falls in, as failed_urls.jsonl, the progress lines
+// and the report name them
+const (
+	failDNS              = "dns"
+	failConnect          = "connect"
+	failConnectTimeout   = "connect-timeout"
+	failTimeout          = "timeout"
+	failTLS              = "tls"
+	failHTTP4xx          = "http-4xx"
+	failHTTP5xx          = "http-5xx"
+	failTooManyRedirects = "too-many-redirects"
+	failBodyTooLarge     = "body-too-large"
+	failParse            = "parse"
+	failOther            = "other"
+)
+
+// fetchError is a failed fetch with its class and how many attempts went
+// into it. Its message is the underlying error's, which is what the
+// frontier keeps as the reason.
+type fetchError struct {
+	class    string
+	attempts int
+	err      error
+}
+
+func (e *fetchError) Error() string { return e.err.Error() }
+func (e *fetchError) Unwrap() error { return e.err }
+
+// parseError is a page that was fetched but couldn't be read: a corrupt
+// encoding or HTML that doesn't parse
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string { return e.err.Error() }
+func (e *parseError) Unwrap() error { return e.err }
+
+// classify says what kind of failure err is
+func classify(err error) string {
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		return fe.class
+	}
+	var se *statusError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var pe *parseError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var hostErr x509.HostnameError
+	var authErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var corrupt flate.CorruptInputError
+	switch {
+	case errors.As(err, &se):
+		switch {
+		case se.code >= 500:
+			return failHTTP5xx
+		case se.code >= 400:
+			return failHTTP4xx
+		}
+		return failOther
+	case errors.Is(err, errTooManyRedirects):
+		return failTooManyRedirects
+	case errors.Is(err, errBodyTooLarge):
+		return failBodyTooLarge
+	case errors.As(err, &pe), errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum), errors.As(err, &corrupt):
+		return failParse
+	case errors.As(err, &dnsErr):
+		return failDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.As(err, &hostErr), errors.As(err, &authErr), errors.As(err, &invalidErr):
+		return failTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		if opErr.Timeout() {
+			return failConnectTimeout
+		}
+		return failConnect
+	case isTimeout(err):
+		return failTimeout
+	}
+	return failOther
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// retryable reports whether another attempt could succeed. Network
+// trouble and server errors may pass; a missing host, a bad certificate, a
+// client error or a redirect loop won't change by asking again.
+func retryable(err error) bool {
+	var offSite *offSiteRedirectError
+	if errors.As(err, &offSite) {
+		return false
+	}
+	switch classify(err) {
+	case failConnect, failConnectTimeout, failTimeout, failOther:
+		return true
+	case failHTTP5xx:
+		var se *statusError
+		errors.As(err, &se)
+		return se.code != http.StatusNotImplemented && se.code != http.StatusHTTPVersionNotSupported
+	case failHTTP4xx:
+		var se *statusError
+		errors.As(err, &se)
+		return se.code == http.StatusRequestTimeout
+	case failDNS:
+		var dnsErr *net.DNSError
+		errors.As(err, &dnsErr)
+		return !dnsErr.IsNotFound
+	}
+	return false
+}
+
+// deadLetter is one line of failed_urls.jsonl: a URL that failed for good
+// this run, once all its attempts were used up
+type deadLetter struct {
+	URL      string    `json:"url"`
+	Class    string    `json:"class"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Referrer string    `json:"referrer,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// maxRecentFailures is how many of the latest failures are kept for the
+// control API's status
+const maxRecentFailures = 20
+
+// failureCounts counts this run's failures by class, keeping the latest
+type failureCounts struct {
+	mu      sync.Mutex
+	byClass map[string]int
+	recent  []deadLetter
+}
+
+func (f *failureCounts) add(d deadLetter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.byClass == nil {
+		f.byClass = make(map[string]int)
+	}
+	f.byClass[d.Class]++
+	if len(f.recent) == maxRecentFailures {
+		f.recent = append(f.recent[:0], f.recent[1:]...)
+	}
+	f.recent = append(f.recent, d)
+}
+
+// latest copies the latest failures, newest last
+func (f *failureCounts) latest() []deadLetter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]deadLetter(nil), f.recent...)
+}
+
+// counts copies the counts, nil if there were no failures
+func (f *failureCounts) counts() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.byClass) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(f.byClass))
+	for class, n := range f.byClass {
+		counts[class] = n
+	}
+	return counts
+}
+
+// String lists the counts by class, as "dns=1 http-4xx=3"
+func (f *failureCounts) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return formatClassCounts(f.byClass)
+}
+
+func formatClassCounts(counts map[string]int) string {
+	classes := make([]string, 0, len(counts))
+	for class := range counts {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = class + "=" + strconv.Itoa(counts[class])
+	}
+	return strings.Join(parts, " ")
+}
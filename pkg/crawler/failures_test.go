@@ -0,0 +1,172 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getErr is the error client gets fetching rawURL
+func getErr(t *testing.T, client *http.Client, rawURL string) error {
+	t.Helper()
+	resp, err := client.Get(rawURL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("GET %s succeeded", rawURL)
+	}
+	return err
+}
+
+// dialClient is a client whose connections are made by dial
+func dialClient(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http.Client {
+	return &http.Client{Transport: &http.Transport{DialContext: dial}}
+}
+
+// TestClassify has real network errors, and the crawl's own, put in their
+// classes
+func TestClassify(t *testing.T) {
+	// A port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	tlsSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tlsSrv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+	hang := make(chan struct{})
+	defer close(hang)
+	slowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slowSrv.Close()
+
+	noDNS := &net.Dialer{Resolver: &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("no DNS server")
+	}}}
+	noTime := &net.Dialer{Timeout: time.Nanosecond}
+
+	tests := []struct {
+		name string
+		err  func() error
+		want string
+	}{
+		{"unresolvable host", func() error { return getErr(t, dialClient(noDNS.DialContext), "http://nowhere.example/") }, failDNS},
+		{"connection refused", func() error { return getErr(t, http.DefaultClient, "http://"+closedAddr+"/") }, failConnect},
+		{"connect timeout", func() error { return getErr(t, dialClient(noTime.DialContext), slowSrv.URL) }, failConnectTimeout},
+		{"unknown certificate authority", func() error { return getErr(t, &http.Client{Transport: &http.Transport{}}, tlsSrv.URL) }, failTLS},
+		{"response timeout", func() error { return getErr(t, &http.Client{Timeout: 50 * time.Millisecond}, slowSrv.URL) }, failTimeout},
+		{"404", func() error { return &statusError{code: http.StatusNotFound} }, failHTTP4xx},
+		{"429", func() error { return &statusError{code: http.StatusTooManyRequests} }, failHTTP4xx},
+		{"500", func() error { return &statusError{code: http.StatusInternalServerError} }, failHTTP5xx},
+		{"503", func() error { return &statusError{code: http.StatusServiceUnavailable} }, failHTTP5xx},
+		{"too many redirects", func() error { return &url.Error{Op: "Get", URL: "http://x/", Err: errTooManyRedirects} }, failTooManyRedirects},
+		{"body too large", func() error { return fmt.Errorf("reading: %w", errBodyTooLarge) }, failBodyTooLarge},
+		{"parse error", func() error { return &parseError{errors.New("bad HTML")} }, failParse},
+		{"class given by the fetch", func() error {
+			return &fetchError{class: failDNS, attempts: 2, err: &statusError{code: http.StatusBadGateway}}
+		}, failDNS},
+		{"anything else", func() error { return errors.New("something") }, failOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err()
+			if got := classify(err); got != tt.want {
+				t.Errorf("classify(%v) = %q, want %q", err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFailureClassesInCrawl crawls pages that fail each their own way: the
+// dead letters and the run's counts have each in its class
+func TestFailureClassesInCrawl(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><a href="/missing">1</a><a href="/broken">2</a><a href="/loop">3</a>` +
+				`<a href="/big">4</a><a href="/corrupt">5</a></html>`))
+		case "/missing":
+			http.NotFound(w, r)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/big":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>" + strings.Repeat("x", 4096) + "</html>"))
+		case "/corrupt":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte("not gzip at all"))
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.MaxAttempts = 1
+		cfg.MaxBodySize = 1024
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c.Run(ctx)
+
+	want := map[string]string{
+		"/missing": failHTTP4xx,
+		"/broken":  failHTTP5xx,
+		"/loop":    failTooManyRedirects,
+		"/big":     failBodyTooLarge,
+		"/corrupt": failParse,
+	}
+	f, err := os.Open(c.deadLettersFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Fatalf("decoding %q: %v", scanner.Text(), err)
+		}
+		if d.Attempts != 1 || d.Error == "" || d.Referrer != srv.URL+"/" {
+			t.Errorf("dead letter %+v", d)
+		}
+		got[strings.TrimPrefix(d.URL, srv.URL)] = d.Class
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dead letter classes %v, want %v", got, want)
+	}
+
+	counts := make(map[string]int)
+	for _, class := range want {
+		counts[class]++
+	}
+	run := c.LastRun()
+	if !reflect.DeepEqual(run.Failures, counts) || run.Failed != len(want) {
+		t.Errorf("run counts %d %v, want %d %v", run.Failed, run.Failures, len(want), counts)
+	}
+}
@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// File names are kept well under what Windows allows, 255 bytes a
+// component and 260 for the whole path, leaving room for the project and
+// download folders in front
+const (
+	maxComponentBytes = 120
+	maxPathBytes      = 200
+)
+
+// reservedNames are the device names Windows won't create a file as, with
+// or without an extension
+var reservedNames = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true}
+
+func init() {
+	for _, prefix := range []string{"COM", "LPT"} {
+		for i := 0; i <= 9; i++ {
+			reservedNames[fmt.Sprintf("%s%d", prefix, i)] = true
+		}
+	}
+}
+
+// sanitizeFilename turns an escaped URL path into a relative file path
+// that is valid on Windows as well as Unix: each segment is percent-decoded
+// on its own, so an encoded slash stays inside it, then has the characters
+// Windows reserves replaced, a trailing dot or space replaced, a device
+// name like CON or nul.txt prefixed with "_", and anything over
+// maxComponentBytes cut, with a hash of the whole segment standing in for
+// the rest. A path longer than maxPathBytes keeps the folders that fit and
+// a hash of the rest for its last segment. Different paths can come out
+// the same, as "a?b" and "a*b" do; claimFile keeps their files apart.
+func sanitizeFilename(escapedPath string) string {
+	var parts []string
+	for _, seg := range strings.Split(escapedPath, "/") {
+		if seg != "" {
+			parts = append(parts, sanitizeComponent(seg))
+		}
+	}
+	joined := strings.Join(parts, "/")
+	if len(joined) <= maxPathBytes {
+		return joined
+	}
+	last := parts[len(parts)-1]
+	tail := "~" + shortHash(joined) + fileExt(last)
+	var kept []string
+	size := len(tail)
+	for _, p := range parts[:len(parts)-1] {
+		if size+len(p)+1 > maxPathBytes {
+			break
+		}
+		kept = append(kept, p)
+		size += len(p) + 1
+	}
+	return path.Join(append(kept, tail)...)
+}
+
+// sanitizeComponent is sanitizeFilename for one escaped path segment
+func sanitizeComponent(seg string) string {
+	original := seg
+	if unescaped, err := url.PathUnescape(seg); err == nil {
+		seg = unescaped
+	}
+	var b strings.Builder
+	for i, r := range seg {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(seg[i:], "�"):
+			// A byte that isn't UTF-8, from an escape like %FF
+			b.WriteByte('_')
+		case r < ' ', r == 0x7f, strings.ContainsRune(`<>:"/\|?*`, r):
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	seg = b.String()
+	// Windows drops trailing dots and spaces, which would make "a." and "a"
+	// the same file, and "." and ".." aren't files at all
+	if trimmed := strings.TrimRight(seg, ". "); trimmed != seg {
+		seg = trimmed + strings.Repeat("_", len(seg)-len(trimmed))
+	}
+	if seg == "" {
+		seg = "_"
+	}
+	stem, _, _ := strings.Cut(seg, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		seg = "_" + seg
+	}
+	if len(seg) <= maxComponentBytes {
+		return seg
+	}
+	ext := fileExt(seg)
+	keep := maxComponentBytes - len(ext) - 1 - 2*shortHashBytes
+	stem = seg[:len(seg)-len(ext)]
+	for keep > 0 && !utf8.RuneStart(stem[keep]) {
+		keep--
+	}
+	return stem[:keep] + "~" + shortHash(original) + ext
+}
+
+// fileExt is name's extension, if it is short enough to be one worth
+// keeping when the name is cut
+func fileExt(name string) string {
+	ext := path.Ext(name)
+	if len(ext) > 16 {
+		return ""
+	}
+	return ext
+}
+
+// shortHashBytes is how much of the SHA-256 shortHash keeps
+const shortHashBytes = 4
+
+// shortHash is a short hex hash of s, that tells names apart
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:shortHashBytes])
+}
+
+// withSuffix adds suffix to name before its extension
+func withSuffix(name, suffix string) string {
+	ext := fileExt(name)
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+// fileKey is the name a file is known by when telling whether two names
+// are the same file, which on Windows and macOS ignores case
+func fileKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// claimFile reserves name for rawURL's file and returns it, or, when a
+// file by that name, ignoring case, belongs to another URL already, the
+// name with a short hash of rawURL before the extension. Once claimed a
+// name stays rawURL's for the rest of the run, saved or not.
+func (m *manifest) claimFile(name, rawURL string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	candidate := name
+	for n := 1; ; n++ {
+		key := fileKey(candidate)
+		if owner, ok := m.files[key]; !ok || owner == rawURL {
+			m.files[key] = rawURL
+			return candidate
+		}
+		suffix := "-" + shortHash(rawURL)
+		if n > 1 {
+			suffix += fmt.Sprintf("-%d", n)
+		}
+		candidate = withSuffix(name, suffix)
+	}
+}
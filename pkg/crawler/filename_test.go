@@ -0,0 +1,104 @@
+package crawler
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name, path, want string
+	}{
+		{"plain", "/docs/intro.html", "docs/intro.html"},
+		{"device name", "/CON", "_CON"},
+		{"device name in lower case", "/nul", "_nul"},
+		{"device name with an extension", "/con.txt", "_con.txt"},
+		{"numbered device name", "/COM1.html", "_COM1.html"},
+		{"device name as a folder", "/a/aux/b", "a/_aux/b"},
+		{"not quite a device name", "/COM10", "COM10"},
+		{"device name as a prefix", "/CONSOLE", "CONSOLE"},
+		{"reserved characters", "/a%3F%3A%2A%7C%3C%3E%22b", "a_______b"},
+		{"escaped slashes", "/a%2Fb%5Cc", "a_b_c"},
+		{"control character", "/a%01b", "a_b"},
+		{"trailing dot", "/trail.", "trail_"},
+		{"trailing space", "/trail%20", "trail_"},
+		{"dot dot", "/..", "__"},
+		{"non-ASCII", "/caf%C3%A9/%E6%97%A5%E6%9C%AC.html", "café/日本.html"},
+		{"invalid UTF-8", "/%FFa", "_a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.path); got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// hashed is a name cut short with a hash standing in for the rest
+var hashed = regexp.MustCompile(`~[0-9a-f]{8}(\.[a-z]+)?$`)
+
+// TestSanitizeFilenameLength has overlong segments and paths cut under the
+// limits, each keeping its extension and a hash that tells it apart from
+// others cut at the same place
+func TestSanitizeFilenameLength(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"long segment", "/" + long + ".html"},
+		{"long non-ASCII segment", "/" + strings.Repeat("%C3%A9", 200) + ".html"},
+		{"long path", strings.Repeat("/"+strings.Repeat("d", 50), 10) + "/page.html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeFilename(tt.path)
+			if len(got) > maxPathBytes {
+				t.Errorf("%d bytes, over %d", len(got), maxPathBytes)
+			}
+			for _, seg := range strings.Split(got, "/") {
+				if len(seg) > maxComponentBytes {
+					t.Errorf("segment of %d bytes, over %d", len(seg), maxComponentBytes)
+				}
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("%q was cut inside a character", got)
+			}
+			if !hashed.MatchString(got) || !strings.HasSuffix(got, ".html") {
+				t.Errorf("%q doesn't end in a hash and .html", got)
+			}
+			other := sanitizeFilename(strings.Replace(tt.path, ".html", "x.html", 1))
+			if other == got {
+				t.Errorf("%q and its variant came out the same", got)
+			}
+		})
+	}
+}
+
+// TestClaimFile has names that collide, exactly or only in case as they
+// would on Windows and macOS, kept apart for each URL that claims them
+func TestClaimFile(t *testing.T) {
+	m := &manifest{files: make(map[string]string)}
+	const a, b, c = "https://example.com/a?b", "https://example.com/a*b", "https://example.com/A?B"
+	first := m.claimFile("a_b.html", a)
+	if first != "a_b.html" {
+		t.Errorf("first claim = %q", first)
+	}
+	if again := m.claimFile("a_b.html", a); again != first {
+		t.Errorf("claiming again = %q, want %q", again, first)
+	}
+	second := m.claimFile("a_b.html", b)
+	if second == first || !strings.HasPrefix(second, "a_b-") || !strings.HasSuffix(second, ".html") {
+		t.Errorf("colliding claim = %q", second)
+	}
+	third := m.claimFile("A_B.html", c)
+	if strings.EqualFold(third, first) || strings.EqualFold(third, second) {
+		t.Errorf("claim differing only in case = %q, collides with %q or %q", third, first, second)
+	}
+	if again := m.claimFile("a_b.html", b); again != second {
+		t.Errorf("claiming the collision again = %q, want %q", again, second)
+	}
+}
@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CompilePatterns compiles a comma-separated list of regular expressions,
+// naming the setting and every pattern that doesn't compile.
+func CompilePatterns(name, list string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	var errs []error
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern %q in %s: %v", p, name, err))
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, errors.Join(errs...)
+}
+
+// compileNumberedPatterns parses a comma-separated list of pattern=number
+// entries for the setting called name, the number being a what, reporting
+// every bad entry. The number follows the last "=", so patterns may have
+// one.
+func compileNumberedPatterns(name, what, list string) ([]*regexp.Regexp, []int, error) {
+	var patterns []*regexp.Regexp
+	var numbers []int
+	var errs []error
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		i := strings.LastIndex(e, "=")
+		if i <= 0 {
+			errs = append(errs, fmt.Errorf("%s entries must be pattern=%s, got %q", name, what, e))
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(e[i+1:]))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s in %q in %s", what, e, name))
+			continue
+		}
+		re, err := regexp.Compile(strings.TrimSpace(e[:i]))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern %q in %s: %v", e[:i], name, err))
+			continue
+		}
+		patterns, numbers = append(patterns, re), append(numbers, n)
+	}
+	return patterns, numbers, errors.Join(errs...)
+}
+
+// passesFilters reports whether url should enter the frontier. Excludes win
+// over includes, and with no includes everything in scope is wanted.
+func (c *Crawler) passesFilters(url string) bool {
+	for _, re := range c.cfg.Exclude {
+		if re.MatchString(url) {
+			return false
+		}
+	}
+	if len(c.cfg.Include) == 0 {
+		return true
+	}
+	for _, re := range c.cfg.Include {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPassesFilters(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude string
+		pass, fail       []string
+	}{
+		{
+			name: "no filters",
+			pass: []string{"https://example.com/", "https://example.com/blog/?print=1"},
+		},
+		{
+			name:    "include only",
+			include: "/docs/",
+			pass:    []string{"https://example.com/docs/", "https://example.com/docs/a?page=2"},
+			fail:    []string{"https://example.com/", "https://example.com/blog/"},
+		},
+		{
+			name:    "exclude only",
+			exclude: `/blog/, [?&]print=1(&|$)`,
+			pass:    []string{"https://example.com/", "https://example.com/docs/?printer=1", "https://example.com/docs/?print=10"},
+			fail:    []string{"https://example.com/blog/a", "https://example.com/docs/?print=1", "https://example.com/docs/?a=b&print=1&c=d"},
+		},
+		{
+			name:    "exclude wins over an overlapping include",
+			include: "/docs/",
+			exclude: "/docs/internal/",
+			pass:    []string{"https://example.com/docs/public"},
+			fail:    []string{"https://example.com/docs/internal/a", "https://example.com/about"},
+		},
+		{
+			name:    "any of several includes",
+			include: `/docs/, /guides/, \?lang=en`,
+			pass:    []string{"https://example.com/docs/", "https://example.com/guides/x", "https://example.com/?lang=en"},
+			fail:    []string{"https://example.com/?lang=de"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			include, err := CompilePatterns("INCLUDE_PATTERNS", tt.include)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exclude, err := CompilePatterns("EXCLUDE_PATTERNS", tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.Include, cfg.Exclude = include, exclude })
+			for _, u := range tt.pass {
+				if !c.passesFilters(u) {
+					t.Errorf("%s filtered out", u)
+				}
+			}
+			for _, u := range tt.fail {
+				if c.passesFilters(u) {
+					t.Errorf("%s passed", u)
+				}
+			}
+		})
+	}
+}
+
+func TestCompilePatterns(t *testing.T) {
+	tests := []struct {
+		list    string
+		n       int
+		invalid []string
+	}{
+		{"", 0, nil},
+		{" /docs/ , ,/blog/", 2, nil},
+		{"/docs/,(unclosed,[z-a]", 1, []string{`"(unclosed"`, `"[z-a]"`}},
+	}
+	for _, tt := range tests {
+		patterns, err := CompilePatterns("EXCLUDE_PATTERNS", tt.list)
+		if len(patterns) != tt.n {
+			t.Errorf("CompilePatterns(%q) = %d patterns, want %d", tt.list, len(patterns), tt.n)
+		}
+		if (err != nil) != (len(tt.invalid) > 0) {
+			t.Errorf("CompilePatterns(%q): %v", tt.list, err)
+		}
+		for _, bad := range tt.invalid {
+			if err == nil || !strings.Contains(err.Error(), bad) || !strings.Contains(err.Error(), "EXCLUDE_PATTERNS") {
+				t.Errorf("CompilePatterns(%q) = %v, want it to name %s and the setting", tt.list, err, bad)
+			}
+		}
+	}
+}
+
+// TestFiltersInCrawl has the filtered out links never requested
+func TestFiltersInCrawl(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.RequestURI())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><a href="/docs/a">a</a><a href="/docs/a?print=1">print</a><a href="/blog/b">b</a></html>`))
+	}))
+	defer srv.Close()
+	include, _ := CompilePatterns("INCLUDE_PATTERNS", `/docs/,/$`)
+	exclude, _ := CompilePatterns("EXCLUDE_PATTERNS", `print=1`)
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.Include, cfg.Exclude = include, exclude
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	sort.Strings(requested)
+	if strings.Join(requested, " ") != "/ /docs/a" {
+		t.Errorf("requested %v, want / and /docs/a", requested)
+	}
+}
@@ -0,0 +1,378 @@
+package crawler
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frontier keeps what a crawl has found and what it has finished with.
+// Found URLs are pending, and handed out in the order they were added,
+// until they are either scraped or failed. Run opens the frontier first
+// and closes it last; its methods may be called from several goroutines
+// in between.
+type Frontier interface {
+	Open() error
+	// Add records a found URL, reporting false if it was already there
+	Add(e FrontierEntry) (bool, error)
+	// NextBatch returns up to n pending URLs. They stay pending, and are
+	// returned again, until they are marked.
+	NextBatch(n int) []FrontierEntry
+	Stats() FrontierStats
+	Found() []FrontierEntry
+	MarkScraped(url string) error
+	IsScraped(url string) bool
+	Scraped() []string
+	MarkFailed(f FailedURL) error
+	Failed() []FailedURL
+	// ForgetScraped drops urls from the scraped list, or all of it when
+	// urls is nil, so they are dispatched again
+	ForgetScraped(urls map[string]bool) error
+	// FetchedAt is when url was last scraped, zero if that isn't known
+	FetchedAt(url string) time.Time
+	// ForgetFailed drops urls from the failed list, or empties it when urls
+	// is nil
+	ForgetFailed(urls map[string]bool) error
+	// Flush makes everything recorded so far durable
+	Flush() error
+	Close() error
+}
+
+// FrontierEntry is a found URL, how many links away from a seed it was
+// discovered and the page it was first found on
+type FrontierEntry struct {
+	URL      string
+	Depth    int
+	Referrer string
+
+	// Asset entries come from the asset inventory, not the found file, and
+	// Image ones, assets too, from the image inventory
+	Asset bool
+	Image bool
+	// Source is sourceScript for a URL found in an inline script, and
+	// sourcePagination for one that is another's rel="next", Chain steps
+	// down the pagination chain. Only the journal frontier keeps them.
+	Source string
+	Chain  int
+	// Priority is the weight the PriorityRules give the URL, which a
+	// frontier that orders pending URLs itself keeps
+	Priority int
+}
+
+// FailedURL is a URL that ran out of attempts
+type FailedURL struct {
+	URL      string
+	Reason   string
+	Referrer string
+	At       time.Time
+}
+
+// FrontierStats counts the URLs in a frontier. Scraped and Failed may
+// include URLs that were never found, like redirect targets and assets;
+// Pending is the found URLs that are neither.
+type FrontierStats struct {
+	Found   int `json:"found"`
+	Scraped int `json:"scraped"`
+	Failed  int `json:"failed"`
+	Pending int `json:"pending"`
+}
+
+// textFrontier is the default frontier: the found, scraped and failed
+// files in the project folder, one URL per line.
+//
+//	found:   url \t depth [\t referrer]
+//	scraped: url
+//	failed:  url \t reason \t referrer \t RFC 3339 time
+type textFrontier struct {
+	foundPath, scrapedPath, failedPath string
+	// normalize is applied to the lines of older runs when opening
+	normalize func(string) (string, error)
+	log       func() *slog.Logger
+
+	found, scraped, failed *stateFile
+}
+
+func (t *textFrontier) Open() error {
+	for _, f := range []string{t.foundPath, t.scrapedPath} {
+		if err := t.dedupeURLFile(f); err != nil {
+			return fmt.Errorf("normalizing %s: %w", f, err)
+		}
+	}
+	var err error
+	if t.found, err = openStateFile(t.foundPath); err != nil {
+		return err
+	}
+	if t.scraped, err = openStateFile(t.scrapedPath); err != nil {
+		return err
+	}
+	if t.failed, err = openStateFile(t.failedPath); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *textFrontier) Add(e FrontierEntry) (bool, error) {
+	line := fmt.Sprintf("%s\t%d", e.URL, e.Depth)
+	if e.Referrer != "" {
+		line += "\t" + e.Referrer
+	}
+	return t.found.add(line)
+}
+
+func (t *textFrontier) NextBatch(n int) []FrontierEntry {
+	done := t.done()
+	var batch []FrontierEntry
+	for _, e := range t.Found() {
+		if len(batch) == n {
+			break
+		}
+		if !done[e.URL] {
+			batch = append(batch, e)
+		}
+	}
+	return batch
+}
+
+func (t *textFrontier) Stats() FrontierStats {
+	done := t.done()
+	s := FrontierStats{Scraped: len(t.scraped.snapshot()), Failed: len(t.failed.snapshot())}
+	for _, e := range t.Found() {
+		s.Found++
+		if !done[e.URL] {
+			s.Pending++
+		}
+	}
+	return s
+}
+
+// done returns the scraped and failed URLs
+func (t *textFrontier) done() map[string]bool {
+	scraped, failed := t.scraped.snapshot(), t.failed.snapshot()
+	done := make(map[string]bool, len(scraped)+len(failed))
+	for _, u := range scraped {
+		done[u] = true
+	}
+	for _, line := range failed {
+		done[lineKey(line)] = true
+	}
+	return done
+}
+
+func (t *textFrontier) Found() []FrontierEntry {
+	return parseFoundLines(t.found.snapshot())
+}
+
+func (t *textFrontier) MarkScraped(url string) error {
+	_, err := t.scraped.add(url)
+	return err
+}
+
+// FetchedAt is never known: the scraped file holds only URLs, so with
+// MaxAge every scraped URL is fetched again
+func (t *textFrontier) FetchedAt(url string) time.Time {
+	return time.Time{}
+}
+
+func (t *textFrontier) IsScraped(url string) bool {
+	return t.scraped.has(url)
+}
+
+func (t *textFrontier) Scraped() []string {
+	return t.scraped.snapshot()
+}
+
+// MarkFailed keeps the error, the page that linked to the URL (possibly
+// empty) and the time on the same line, tab-separated, for later
+// inspection
+func (t *textFrontier) MarkFailed(f FailedURL) error {
+	reason := strings.Join(strings.Fields(f.Reason), " ")
+	line := strings.Join([]string{f.URL, reason, f.Referrer, f.At.UTC().Format(time.RFC3339)}, "\t")
+	_, err := t.failed.add(line)
+	return err
+}
+
+func (t *textFrontier) Failed() []FailedURL {
+	return parseFailedLines(t.failed.snapshot())
+}
+
+func (t *textFrontier) ForgetScraped(urls map[string]bool) error {
+	return t.rewrite(&t.scraped, t.scrapedPath, keptLines(t.scraped.snapshot(), urls))
+}
+
+func (t *textFrontier) ForgetFailed(urls map[string]bool) error {
+	return t.rewrite(&t.failed, t.failedPath, keptLines(t.failed.snapshot(), urls))
+}
+
+// keptLines is lines without those about urls, none at all if it is nil
+func keptLines(lines []string, urls map[string]bool) string {
+	var kept strings.Builder
+	if urls != nil {
+		for _, line := range lines {
+			if !urls[lineKey(line)] {
+				kept.WriteString(line + "\n")
+			}
+		}
+	}
+	return kept.String()
+}
+
+// rewrite replaces an open state file's contents and reopens it
+func (t *textFrontier) rewrite(s **stateFile, path, contents string) error {
+	if err := (*s).close(); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, []byte(contents)); err != nil {
+		return err
+	}
+	reopened, err := openStateFile(path)
+	if err != nil {
+		return err
+	}
+	*s = reopened
+	return nil
+}
+
+func (t *textFrontier) Flush() error {
+	for _, s := range []*stateFile{t.found, t.scraped, t.failed} {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *textFrontier) Close() error {
+	var firstErr error
+	for _, s := range []*stateFile{t.found, t.scraped, t.failed} {
+		if s == nil {
+			continue
+		}
+		if err := s.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// textFrontier returns the text frontier for the project folder
+func (c *Crawler) textFrontier() *textFrontier {
+	return &textFrontier{
+		foundPath:   c.foundUrlFileName,
+		scrapedPath: c.scrapedUrlFileName,
+		failedPath:  c.failedUrlFileName,
+		normalize:   c.normalizeURL,
+		log:         c.log,
+	}
+}
+
+// copyFrontier adds everything in from to an empty frontier, returning
+// what it then holds
+func copyFrontier(to, from Frontier) (FrontierStats, error) {
+	for _, e := range from.Found() {
+		if _, err := to.Add(e); err != nil {
+			return FrontierStats{}, err
+		}
+	}
+	for _, u := range from.Scraped() {
+		if err := to.MarkScraped(u); err != nil {
+			return FrontierStats{}, err
+		}
+	}
+	for _, f := range from.Failed() {
+		if err := to.MarkFailed(f); err != nil {
+			return FrontierStats{}, err
+		}
+	}
+	return to.Stats(), nil
+}
+
+// parseFoundLines parses found file lines. Lines written before depth was
+// tracked have no depth field and are treated as seeds; lines without a
+// referrer, like seeds, leave it empty.
+func parseFoundLines(lines []string) []FrontierEntry {
+	entries := make([]FrontierEntry, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 3)
+		e := FrontierEntry{URL: fields[0]}
+		if len(fields) > 1 {
+			e.Depth, _ = strconv.Atoi(fields[1])
+		}
+		if len(fields) > 2 {
+			e.Referrer = fields[2]
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// parseFailedLines parses failed file lines; older lines stop after the
+// reason
+func parseFailedLines(lines []string) []FailedURL {
+	failed := make([]FailedURL, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Split(line, "\t")
+		f := FailedURL{URL: fields[0]}
+		if len(fields) > 1 {
+			f.Reason = fields[1]
+		}
+		if len(fields) > 2 {
+			f.Referrer = fields[2]
+		}
+		if len(fields) > 3 {
+			f.At, _ = time.Parse(time.RFC3339, fields[3])
+		}
+		failed = append(failed, f)
+	}
+	return failed
+}
+
+// dedupeURLFile normalizes every line of a state file and drops the
+// duplicates that older runs left behind, keeping the first occurrence so
+// the order the crawl relies on is preserved.
+func (t *textFrontier) dedupeURLFile(path string) error {
+	lines, err := readLines(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(lines))
+	var out []string
+	for _, line := range lines {
+		key, rest, hasRest := strings.Cut(line, "\t")
+		normalized, err := t.normalize(key)
+		if err != nil {
+			normalized = key
+		}
+		if seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		if hasRest {
+			normalized += "\t" + rest
+		}
+		out = append(out, normalized)
+	}
+	if len(out) == len(lines) {
+		changed := false
+		for i := range out {
+			if out[i] != lines[i] {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return nil
+		}
+	}
+	t.log().Info("normalized state file", "file", path, "before", len(lines), "after", len(out))
+	var sb strings.Builder
+	for _, line := range out {
+		sb.WriteString(line + "\n")
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
@@ -0,0 +1,313 @@
+package crawler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frontierBackends are a new frontier of each kind, in dir
+func frontierBackends(t testing.TB, dir string) []struct {
+	name string
+	f    Frontier
+} {
+	c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.ProjectFolder = dir })
+	return []struct {
+		name string
+		f    Frontier
+	}{
+		{"sqlite", &sqliteFrontier{path: filepath.Join(dir, "frontier.db")}},
+		{"journal", &journalFrontier{path: filepath.Join(dir, "frontier.jsonl")}},
+		{"text", c.textFrontier()},
+	}
+}
+
+func urlsOf(entries []FrontierEntry) []string {
+	var urls []string
+	for _, e := range entries {
+		urls = append(urls, e.URL)
+	}
+	return urls
+}
+
+// TestFrontierResume records a crawl part way through, closes the frontier
+// and opens it again, as a resumed run does: what was pending still is, in
+// the order it was found, and the rest stays done
+func TestFrontierResume(t *testing.T) {
+	const a, b, c, d = "https://example.com/a", "https://example.com/b", "https://example.com/c", "https://example.com/d"
+	for _, be := range frontierBackends(t, t.TempDir()) {
+		f := be.f
+		if err := f.Open(); err != nil {
+			t.Fatalf("%s: Open: %v", be.name, err)
+		}
+		for _, u := range []string{a, b, c, d} {
+			if added, err := f.Add(FrontierEntry{URL: u, Depth: 1, Referrer: "https://example.com/"}); !added || err != nil {
+				t.Errorf("%s: Add(%s) = %v, %v", be.name, u, added, err)
+			}
+		}
+		if added, _ := f.Add(FrontierEntry{URL: a}); added {
+			t.Errorf("%s: %s was added twice", be.name, a)
+		}
+		f.MarkScraped(a)
+		f.MarkFailed(FailedURL{URL: c, Reason: "bad status code: 500", At: time.Now()})
+		// A redirect target is marked without being found
+		f.MarkScraped("https://example.com/elsewhere")
+		if err := f.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", be.name, err)
+		}
+
+		if err := f.Open(); err != nil {
+			t.Fatalf("%s: reopening: %v", be.name, err)
+		}
+		tests := []struct {
+			what      string
+			got, want any
+		}{
+			{"pending", urlsOf(f.NextBatch(10)), []string{b, d}},
+			{"first batch of one", urlsOf(f.NextBatch(1)), []string{b}},
+			{"stats", f.Stats(), FrontierStats{Found: 4, Scraped: 2, Failed: 1, Pending: 2}},
+			{"scraped", f.IsScraped(a), true},
+			{"failed", len(f.Failed()), 1},
+			{"found", urlsOf(f.Found()), []string{a, b, c, d}},
+		}
+		for _, tt := range tests {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("%s: %s after resuming = %v, want %v", be.name, tt.what, tt.got, tt.want)
+			}
+		}
+		if got := f.NextBatch(1); len(got) == 1 && got[0].Referrer != "https://example.com/" {
+			t.Errorf("%s: referrer = %q after resuming", be.name, got[0].Referrer)
+		}
+		if err := f.ForgetFailed(nil); err != nil {
+			t.Errorf("%s: ForgetFailed: %v", be.name, err)
+		}
+		if got := urlsOf(f.NextBatch(10)); !reflect.DeepEqual(got, []string{b, c, d}) {
+			t.Errorf("%s: pending after forgetting the failures = %v", be.name, got)
+		}
+		f.Close()
+	}
+}
+
+// TestJournalCompaction reopens a journal most of whose lines are stale:
+// it is rewritten with a line per URL and the same state
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frontier.jsonl")
+	j := &journalFrontier{path: path}
+	if err := j.Open(); err != nil {
+		t.Fatal(err)
+	}
+	urls := []string{"https://example.com/a", "https://example.com/b"}
+	for _, u := range urls {
+		j.Add(FrontierEntry{URL: u})
+	}
+	// Every recrawl marks the pages again, appending a line each time
+	for i := 0; i < 3; i++ {
+		for _, u := range urls {
+			j.MarkScraped(u)
+		}
+		j.ForgetScraped(nil)
+	}
+	j.MarkScraped(urls[0])
+	j.Close()
+	lines := func() int {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(b), "\n")
+	}
+	if n := lines(); n <= 2*len(urls) {
+		t.Fatalf("%d lines before compacting, want more than %d", n, 2*len(urls))
+	}
+
+	j = &journalFrontier{path: path}
+	if err := j.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if n := lines(); n != len(urls) {
+		t.Errorf("%d lines after compacting, want %d", n, len(urls))
+	}
+	if want := (FrontierStats{Found: 2, Scraped: 1, Pending: 1}); j.Stats() != want {
+		t.Errorf("stats after compacting = %+v, want %+v", j.Stats(), want)
+	}
+	if got := urlsOf(j.NextBatch(10)); !reflect.DeepEqual(got, urls[1:]) {
+		t.Errorf("pending after compacting = %v", got)
+	}
+}
+
+func TestMigrateFrontier(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		older   func(c *Crawler) Frontier
+	}{
+		{"journal into sqlite", "sqlite", func(c *Crawler) Frontier { return &journalFrontier{path: c.frontierFileName} }},
+		{"text into sqlite", "sqlite", func(c *Crawler) Frontier { return c.textFrontier() }},
+		{"text into journal", "journal", func(c *Crawler) Frontier { return c.textFrontier() }},
+	}
+	for _, tt := range tests {
+		c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.FrontierBackend = tt.backend })
+		older := tt.older(c)
+		if err := older.Open(); err != nil {
+			t.Fatal(err)
+		}
+		older.Add(FrontierEntry{URL: "https://example.com/a"})
+		older.Add(FrontierEntry{URL: "https://example.com/b", Depth: 1, Referrer: "https://example.com/a"})
+		older.MarkScraped("https://example.com/a")
+		older.Close()
+
+		if err := c.Frontier.Open(); err == nil || !strings.Contains(err.Error(), "migrate") {
+			t.Errorf("%s: opening before migrating = %v, want a pointer at migrate", tt.name, err)
+			c.Frontier.Close()
+		}
+		stats, err := c.MigrateFrontier()
+		if err != nil {
+			t.Fatalf("%s: MigrateFrontier: %v", tt.name, err)
+		}
+		if want := (FrontierStats{Found: 2, Scraped: 1, Pending: 1}); stats != want {
+			t.Errorf("%s: migrated %+v, want %+v", tt.name, stats, want)
+		}
+		if err := c.Frontier.Open(); err != nil {
+			t.Fatalf("%s: opening after migrating: %v", tt.name, err)
+		}
+		if got := c.Frontier.NextBatch(10); len(got) != 1 || got[0].Referrer != "https://example.com/a" {
+			t.Errorf("%s: pending after migrating = %+v", tt.name, got)
+		}
+		c.Frontier.Close()
+		if _, err := c.MigrateFrontier(); err == nil {
+			t.Errorf("%s: migrating twice succeeded", tt.name)
+		}
+	}
+}
+
+// TestFrontierOutOfOrder has workers finish a pass in some order, then
+// crashes: a fresh frontier over the same files, the old one never closed,
+// has exactly the URLs that didn't finish pending, in the order they were
+// found, whatever the order they finished in
+func TestFrontierOutOfOrder(t *testing.T) {
+	urls := []string{"https://example.com/0", "https://example.com/1", "https://example.com/2", "https://example.com/3", "https://example.com/4"}
+	tests := []struct {
+		name     string
+		finished []int
+		pending  []string
+	}{
+		{"none", nil, urls},
+		{"in order", []int{0, 1}, urls[2:]},
+		{"last first", []int{4}, urls[:4]},
+		{"interleaved", []int{3, 0, 4}, []string{urls[1], urls[2]}},
+		{"all but the first", []int{4, 2, 3, 1}, urls[:1]},
+		{"all", []int{2, 0, 4, 1, 3}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			crashed, resumed := frontierBackends(t, dir), frontierBackends(t, dir)
+			for i, be := range crashed {
+				f := be.f
+				if err := f.Open(); err != nil {
+					t.Fatalf("%s: Open: %v", be.name, err)
+				}
+				for _, u := range urls {
+					f.Add(FrontierEntry{URL: u})
+				}
+				for _, n := range tt.finished {
+					if err := f.MarkScraped(urls[n]); err != nil {
+						t.Fatalf("%s: MarkScraped: %v", be.name, err)
+					}
+				}
+				if err := f.Flush(); err != nil {
+					t.Fatalf("%s: Flush: %v", be.name, err)
+				}
+
+				again := resumed[i].f
+				if err := again.Open(); err != nil {
+					t.Fatalf("%s: opening after the crash: %v", be.name, err)
+				}
+				if got := urlsOf(again.NextBatch(len(urls))); !reflect.DeepEqual(got, tt.pending) {
+					t.Errorf("%s: pending %v, want %v", be.name, got, tt.pending)
+				}
+				again.Close()
+				f.Close()
+			}
+		})
+	}
+}
+
+// rereadAppend is how the found file was added to before the frontier kept
+// it in memory: the whole file read for every link
+func rereadAppend(path, line string) error {
+	lines, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if l == line {
+			return nil
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+// BenchmarkFrontierAdd adds links to a frontier already holding 100k URLs,
+// every other link one it has, as pages mostly link to pages already
+// found. reread is the found file as it was kept before, the others each
+// backend as it is now.
+func BenchmarkFrontierAdd(b *testing.B) {
+	const size = 100_000
+	url := func(i int) string { return fmt.Sprintf("https://example.com/section/%d/page-%d", i%100, i) }
+	link := func(i int) string {
+		if i%2 == 0 {
+			return url(i * 7919 % size)
+		}
+		return url(size + i)
+	}
+
+	b.Run("reread", func(b *testing.B) {
+		path := filepath.Join(b.TempDir(), "found.txt")
+		var sb strings.Builder
+		for i := range size {
+			sb.WriteString(url(i) + "\n")
+		}
+		if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := rereadAppend(path, link(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, be := range frontierBackends(b, b.TempDir()) {
+		if err := be.f.Open(); err != nil {
+			b.Fatal(err)
+		}
+		for i := range size {
+			if _, err := be.f.Add(FrontierEntry{URL: url(i), Depth: 1}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := be.f.Flush(); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(be.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := be.f.Add(FrontierEntry{URL: link(i), Depth: 2}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		be.f.Close()
+	}
+}
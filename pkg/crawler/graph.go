@@ -0,0 +1,258 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// linkEdge is one line of edges.jsonl: a link from one crawled page to an
+// in-scope URL
+type linkEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// recordEdges writes an edge from page to each distinct link on it
+func (c *Crawler) recordEdges(page string, links []string) {
+	seen := make(map[string]bool, len(links))
+	for _, link := range links {
+		if normalized, err := c.normalizeURL(link); err == nil {
+			link = normalized
+		}
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		_ = c.linkEdges.write(linkEdge{From: page, To: link})
+	}
+}
+
+// nodeInfo is what the graph knows about a URL besides its edges
+type nodeInfo struct {
+	status int
+	depth  int
+}
+
+// loadNodeInfo reads depths from the frontier and statuses from
+// pages.jsonl and the failed URLs. Only URLs with a status are returned,
+// and only the per-URL summary is kept, never the edges.
+func (c *Crawler) loadNodeInfo() (map[string]nodeInfo, error) {
+	found := c.Frontier.Found()
+	depths := make(map[string]int, len(found))
+	for _, e := range found {
+		depths[e.URL] = e.Depth
+	}
+
+	nodes := make(map[string]nodeInfo)
+	f, err := os.Open(c.pagesFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			var r pageRecord
+			if json.Unmarshal(scanner.Bytes(), &r) == nil {
+				nodes[r.URL] = nodeInfo{status: r.Status, depth: r.Depth}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range c.Frontier.Failed() {
+		if status, _ := brokenProblem(f.Reason); status != 0 {
+			nodes[f.URL] = nodeInfo{status: status, depth: depths[f.URL]}
+		}
+	}
+	return nodes, nil
+}
+
+// collapseURL cuts a URL down to its host and first segments path
+// segments, so a graph of thousands of pages becomes one of sections
+func collapseURL(raw string, segments int) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) > segments {
+		parts = parts[:segments]
+	}
+	return u.Scheme + "://" + u.Host + "/" + strings.Join(parts, "/")
+}
+
+// graphWriter emits nodes and edges in one output format. Nodes are
+// always written before the first edge that uses them.
+type graphWriter interface {
+	begin() error
+	node(id int, url string, info nodeInfo, known bool) error
+	edge(from, to int) error
+	end() error
+}
+
+// exportGraph streams edges.jsonl into path in the given format ("dot" or
+// "graphml"). With collapse > 0, URLs are grouped by their first collapse
+// path segments and repeated edges between groups are dropped; only the
+// node ids and, when collapsing, the distinct edges are kept in memory.
+func (c *Crawler) exportGraph(format, path string, collapse int) error {
+	if format != "dot" && format != "graphml" {
+		return fmt.Errorf("unknown graph format %q, want dot or graphml", format)
+	}
+	in, err := os.Open(c.edgesFileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	nodes, err := c.loadNodeInfo()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+
+	var g graphWriter = &dotWriter{w: w}
+	if format == "graphml" {
+		g = &graphMLWriter{w: w}
+	}
+
+	ids := make(map[string]int)
+	nodeID := func(u string) (int, error) {
+		if collapse > 0 {
+			u = collapseURL(u, collapse)
+		}
+		if id, ok := ids[u]; ok {
+			return id, nil
+		}
+		id := len(ids)
+		ids[u] = id
+		info, known := nodes[u]
+		return id, g.node(id, u, info, known)
+	}
+	seenEdges := make(map[[2]int]bool)
+
+	if err := g.begin(); err != nil {
+		return err
+	}
+	edges := 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e linkEdge
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.From == "" || e.To == "" {
+			continue
+		}
+		from, err := nodeID(e.From)
+		if err != nil {
+			return err
+		}
+		to, err := nodeID(e.To)
+		if err != nil {
+			return err
+		}
+		if collapse > 0 {
+			key := [2]int{from, to}
+			if from == to || seenEdges[key] {
+				continue
+			}
+			seenEdges[key] = true
+		}
+		if err := g.edge(from, to); err != nil {
+			return err
+		}
+		edges++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := g.end(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	c.log().Info("wrote link graph", "file", path, "nodes", len(ids), "edges", edges)
+	return out.Close()
+}
+
+type dotWriter struct {
+	w io.Writer
+}
+
+func (d *dotWriter) begin() error {
+	_, err := io.WriteString(d.w, "digraph crawl {\n")
+	return err
+}
+
+func (d *dotWriter) node(id int, u string, info nodeInfo, known bool) error {
+	attrs := "label=" + strconv.Quote(u)
+	if known {
+		attrs += fmt.Sprintf(", status=%d, depth=%d", info.status, info.depth)
+	}
+	_, err := fmt.Fprintf(d.w, "  n%d [%s];\n", id, attrs)
+	return err
+}
+
+func (d *dotWriter) edge(from, to int) error {
+	_, err := fmt.Fprintf(d.w, "  n%d -> n%d;\n", from, to)
+	return err
+}
+
+func (d *dotWriter) end() error {
+	_, err := io.WriteString(d.w, "}\n")
+	return err
+}
+
+type graphMLWriter struct {
+	w     io.Writer
+	edges int
+}
+
+func (g *graphMLWriter) begin() error {
+	_, err := io.WriteString(g.w, xml.Header+`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="url" for="node" attr.name="url" attr.type="string"/>
+  <key id="status" for="node" attr.name="status" attr.type="int"/>
+  <key id="depth" for="node" attr.name="depth" attr.type="int"/>
+  <graph id="crawl" edgedefault="directed">
+`)
+	return err
+}
+
+func (g *graphMLWriter) node(id int, u string, info nodeInfo, known bool) error {
+	var escaped strings.Builder
+	if err := xml.EscapeText(&escaped, []byte(u)); err != nil {
+		return err
+	}
+	data := fmt.Sprintf(`<data key="url">%s</data>`, escaped.String())
+	if known {
+		data += fmt.Sprintf(`<data key="status">%d</data><data key="depth">%d</data>`, info.status, info.depth)
+	}
+	_, err := fmt.Fprintf(g.w, "    <node id=\"n%d\">%s</node>\n", id, data)
+	return err
+}
+
+func (g *graphMLWriter) edge(from, to int) error {
+	g.edges++
+	_, err := fmt.Fprintf(g.w, "    <edge id=\"e%d\" source=\"n%d\" target=\"n%d\"/>\n", g.edges, from, to)
+	return err
+}
+
+func (g *graphMLWriter) end() error {
+	_, err := io.WriteString(g.w, "  </graph>\n</graphml>\n")
+	return err
+}
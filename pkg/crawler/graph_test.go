@@ -0,0 +1,177 @@
+package crawler
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollapseURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		segments int
+		want     string
+	}{
+		{"https://example.com/docs/a/b", 1, "https://example.com/docs"},
+		{"https://example.com/docs/a/b", 2, "https://example.com/docs/a"},
+		{"https://example.com/docs/a/b", 5, "https://example.com/docs/a/b"},
+		{"https://example.com/", 1, "https://example.com/"},
+		{"https://example.com/docs/?page=2", 1, "https://example.com/docs"},
+	}
+	for _, tt := range tests {
+		if got := collapseURL(tt.url, tt.segments); got != tt.want {
+			t.Errorf("collapseURL(%q, %d) = %q, want %q", tt.url, tt.segments, got, tt.want)
+		}
+	}
+}
+
+// graphSite is ten pages in two sections, each page's links by path
+var graphSite = map[string][]string{
+	"/":       {"/docs/1", "/blog/1", "/about"},
+	"/about":  {"/"},
+	"/docs/1": {"/docs/2", "/"},
+	"/docs/2": {"/docs/3", "/"},
+	"/docs/3": {"/docs/4", "/", "/docs/4"},
+	"/docs/4": {"/"},
+	"/blog/1": {"/blog/2", "/docs/1"},
+	"/blog/2": {"/blog/3"},
+	"/blog/3": {"/blog/4"},
+	"/blog/4": {"/gone"},
+}
+
+// dotEdges reads the edges of a DOT graph as "from -> to" by their labels,
+// sorted, and the status of each labelled node
+func dotEdges(t *testing.T, dot string) ([]string, map[string]string) {
+	t.Helper()
+	labels := make(map[string]string)
+	statuses := make(map[string]string)
+	for _, m := range regexp.MustCompile(`(n\d+) \[label="([^"]*)"(?:, status=(\d+))?`).FindAllStringSubmatch(dot, -1) {
+		labels[m[1]] = m[2]
+		statuses[m[2]] = m[3]
+	}
+	var edges []string
+	for _, m := range regexp.MustCompile(`(n\d+) -> (n\d+);`).FindAllStringSubmatch(dot, -1) {
+		edges = append(edges, labels[m[1]]+" -> "+labels[m[2]])
+	}
+	sort.Strings(edges)
+	return edges, statuses
+}
+
+// TestExportGraph crawls a ten-page site and exports its link graph, in
+// full and collapsed by section
+func TestExportGraph(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		links, ok := graphSite[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		var sb strings.Builder
+		for _, l := range links {
+			sb.WriteString(`<a href="` + l + `">x</a>`)
+		}
+		w.Write([]byte("<html>" + sb.String() + "</html>"))
+	}))
+	defer srv.Close()
+	folder := t.TempDir()
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.ProjectFolder = folder
+		cfg.Workers = 2
+		cfg.RespectRobots = false
+		cfg.MaxAttempts = 1
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var full []string
+	for from, links := range graphSite {
+		seen := make(map[string]bool)
+		for _, to := range links {
+			if !seen[to] {
+				seen[to] = true
+				full = append(full, srv.URL+from+" -> "+srv.URL+to)
+			}
+		}
+	}
+	sort.Strings(full)
+	sections := func(s string) string { return srv.URL + s }
+	tests := []struct {
+		name     string
+		collapse int
+		want     []string
+	}{
+		{"every page", 0, full},
+		{"by section", 1, []string{
+			sections("/ -> ") + sections("/about"), sections("/ -> ") + sections("/blog"), sections("/ -> ") + sections("/docs"),
+			sections("/about -> ") + sections("/"),
+			sections("/blog -> ") + sections("/docs"), sections("/blog -> ") + sections("/gone"),
+			sections("/docs -> ") + sections("/"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export := newTestCrawler(t, srv.URL+"/", func(cfg *Config) { cfg.ProjectFolder = folder })
+			path := filepath.Join(t.TempDir(), "graph.dot")
+			if err := export.ExportGraph("dot", path, tt.collapse); err != nil {
+				t.Fatalf("ExportGraph: %v", err)
+			}
+			dot, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			edges, statuses := dotEdges(t, string(dot))
+			if !reflect.DeepEqual(edges, tt.want) {
+				t.Errorf("edges\n  %s\nwant\n  %s", strings.Join(edges, "\n  "), strings.Join(tt.want, "\n  "))
+			}
+			if tt.collapse == 0 && (statuses[srv.URL+"/docs/1"] != "200" || statuses[srv.URL+"/gone"] != "404") {
+				t.Errorf("statuses %v", statuses)
+			}
+		})
+	}
+
+	t.Run("graphml", func(t *testing.T) {
+		export := newTestCrawler(t, srv.URL+"/", func(cfg *Config) { cfg.ProjectFolder = folder })
+		path := filepath.Join(t.TempDir(), "graph.graphml")
+		if err := export.ExportGraph("graphml", path, 0); err != nil {
+			t.Fatalf("ExportGraph: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc struct {
+			Graph struct {
+				Nodes []struct {
+					ID string `xml:"id,attr"`
+				} `xml:"node"`
+				Edges []struct {
+					Source string `xml:"source,attr"`
+					Target string `xml:"target,attr"`
+				} `xml:"edge"`
+			} `xml:"graph"`
+		}
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("not XML: %v", err)
+		}
+		if len(doc.Graph.Nodes) != 11 || len(doc.Graph.Edges) != len(full) {
+			t.Errorf("%d nodes and %d edges, want 11 and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges), len(full))
+		}
+	})
+
+	if err := newTestCrawler(t, srv.URL+"/", func(cfg *Config) { cfg.ProjectFolder = folder }).ExportGraph("svg", filepath.Join(t.TempDir(), "g"), 0); err == nil {
+		t.Error("exporting as svg succeeded")
+	}
+}
@@ -0,0 +1,138 @@
+package crawler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// schemeUpgradeRedirects is how many of a host's http:// URLs have to
+// redirect permanently to their https:// twins before NormalizeScheme
+// takes it that they all do
+const schemeUpgradeRedirects = 2
+
+// schemeUpgrades are the hosts whose http:// URLs NormalizeScheme turns
+// into https://: every allowed one when BaseURL is https, and the ones
+// seen redirecting there. A host that served an http:// page as it was is
+// never learned.
+type schemeUpgrades struct {
+	mu      sync.Mutex
+	always  bool
+	seen    map[string]int
+	learned map[string]bool
+	refused map[string]bool
+}
+
+func newSchemeUpgrades(cfg Config, base *url.URL) *schemeUpgrades {
+	return &schemeUpgrades{
+		always:  cfg.NormalizeScheme && base.Scheme == "https",
+		seen:    make(map[string]int),
+		learned: make(map[string]bool),
+		refused: make(map[string]bool),
+	}
+}
+
+func (s *schemeUpgrades) has(hostname string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.learned[hostname]
+}
+
+// foldHost rewrites u in place as NormalizeWWW and NormalizeScheme have
+// it: "www." added to or dropped from the BaseURL host's other form, then
+// http:// made https:// on a host that is upgraded. Only URLs on the
+// default port are upgraded.
+func (c *Crawler) foldHost(u *url.URL) {
+	if c.cfg.NormalizeWWW && c.wwwTwin != "" && strings.EqualFold(u.Host, c.wwwTwin) {
+		u.Host = strings.ToLower(c.baseHost)
+	}
+	if !c.cfg.NormalizeScheme || u.Scheme != "http" || (u.Port() != "" && u.Port() != "80") {
+		return
+	}
+	hostname := strings.ToLower(u.Hostname())
+	if (c.upgrades.always && c.hostAllowed(u.Host)) || c.upgrades.has(hostname) {
+		u.Scheme = "https"
+		u.Host = strings.TrimSuffix(u.Host, ":80")
+	}
+}
+
+// foldURL is foldHost for a URL string, which is returned as it is when
+// neither is on or it doesn't parse
+func (c *Crawler) foldURL(raw string) string {
+	if !c.cfg.NormalizeScheme && !c.cfg.NormalizeWWW {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	c.foldHost(u)
+	return u.String()
+}
+
+// wwwTwinOf is the other form of a host, with "www." or without it, or
+// empty for an IP address or a host with no dot to drop it to
+func wwwTwinOf(host string) string {
+	host = strings.ToLower(host)
+	if bare, ok := strings.CutPrefix(host, "www."); ok {
+		if strings.Contains(urlHostname(bare), ".") {
+			return bare
+		}
+		return ""
+	}
+	name := urlHostname(host)
+	if !strings.Contains(name, ".") || strings.Contains(name, ":") || strings.Trim(name, "0123456789.") == "" {
+		return ""
+	}
+	return "www." + host
+}
+
+// noteSchemeRedirect learns from a redirect from one URL to another with
+// status whether the host upgrades http:// to https://
+func (c *Crawler) noteSchemeRedirect(from, to *url.URL, status int) {
+	if !c.cfg.NormalizeScheme || c.upgrades.always || from.Scheme != "http" || !isSchemeUpgrade(from, to) {
+		return
+	}
+	if status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect {
+		return
+	}
+	hostname := strings.ToLower(from.Hostname())
+	s := c.upgrades
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refused[hostname] || s.learned[hostname] {
+		return
+	}
+	if s.seen[hostname]++; s.seen[hostname] >= schemeUpgradeRedirects {
+		s.learned[hostname] = true
+		c.log().Info("upgrading http:// to https://", "host", hostname, "redirects", s.seen[hostname])
+	}
+}
+
+// noteServedPlain records that an http:// URL was served as it was, so
+// its host is never upgraded
+func (c *Crawler) noteServedPlain(rawURL string, hops []redirectHop, status int) {
+	if !c.cfg.NormalizeScheme || c.upgrades.always || len(hops) > 0 || status >= 300 || !strings.HasPrefix(rawURL, "http://") {
+		return
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	hostname := strings.ToLower(u.Hostname())
+	c.upgrades.mu.Lock()
+	defer c.upgrades.mu.Unlock()
+	if !c.upgrades.learned[hostname] {
+		c.upgrades.refused[hostname] = true
+	}
+}
+
+// isSchemeUpgrade reports whether to is from with https:// for http://,
+// give or take a trailing slash
+func isSchemeUpgrade(from, to *url.URL) bool {
+	if to.Scheme != "https" || !strings.EqualFold(from.Hostname(), to.Hostname()) {
+		return false
+	}
+	return strings.TrimSuffix(from.EscapedPath(), "/") == strings.TrimSuffix(to.EscapedPath(), "/") && from.RawQuery == to.RawQuery
+}
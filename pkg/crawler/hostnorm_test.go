@@ -0,0 +1,211 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWWWTwinOf(t *testing.T) {
+	tests := []struct{ host, want string }{
+		{"example.com", "www.example.com"},
+		{"www.example.com", "example.com"},
+		{"WWW.Example.com", "example.com"},
+		{"example.com:8443", "www.example.com:8443"},
+		{"www.example.com:8443", "example.com:8443"},
+		{"shop.example.com", "www.shop.example.com"},
+		{"localhost", ""},
+		{"www.localhost", ""},
+		{"127.0.0.1", ""},
+		{"[::1]:8080", ""},
+	}
+	for _, tt := range tests {
+		if got := wwwTwinOf(tt.host); got != tt.want {
+			t.Errorf("wwwTwinOf(%s) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+// TestHostNormalizationSeeds seeds a crawl of https://example.com/ with
+// each form of its pages under each option: a seed the options fold onto
+// the site is kept in its folded form, and one they don't is refused as
+// out of scope
+func TestHostNormalizationSeeds(t *testing.T) {
+	seeds := []string{
+		"https://example.com/a",
+		"https://www.example.com/a",
+		"http://example.com/a",
+		"http://www.example.com/a",
+		"http://example.com:8080/a",
+	}
+	tests := []struct {
+		name          string
+		scheme, www   bool
+		want          []string
+		wantRejection []string
+	}{
+		{"neither", false, false,
+			[]string{"https://example.com/a"},
+			[]string{"https://www.example.com/a", "http://example.com/a", "http://www.example.com/a", "http://example.com:8080/a"}},
+		{"www", false, true,
+			[]string{"https://example.com/a", "https://example.com/a"},
+			[]string{"http://example.com/a", "http://www.example.com/a", "http://example.com:8080/a"}},
+		{"scheme", true, false,
+			[]string{"https://example.com/a", "https://example.com/a"},
+			[]string{"https://www.example.com/a", "http://www.example.com/a", "http://example.com:8080/a"}},
+		{"both", true, true,
+			[]string{"https://example.com/a", "https://example.com/a", "https://example.com/a", "https://example.com/a"},
+			[]string{"http://example.com:8080/a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got, rejected []string
+			for _, seed := range seeds {
+				cfg := DefaultConfig("https://example.com/")
+				cfg.ProjectFolder = t.TempDir()
+				cfg.NormalizeScheme, cfg.NormalizeWWW = tt.scheme, tt.www
+				cfg.SeedURLs = []string{seed}
+				c, err := New(cfg)
+				if err != nil {
+					if !strings.Contains(err.Error(), "outside BaseURL") {
+						t.Errorf("seed %s: %v", seed, err)
+					}
+					rejected = append(rejected, seed)
+					continue
+				}
+				got = append(got, c.cfg.SeedURLs...)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("seeds %v, want %v", got, tt.want)
+			}
+			if strings.Join(rejected, " ") != strings.Join(tt.wantRejection, " ") {
+				t.Errorf("rejected %v, want %v", rejected, tt.wantRejection)
+			}
+		})
+	}
+
+	t.Run("http BaseURL", func(t *testing.T) {
+		// An http site has its https twin in scope, but keeps the seed's
+		// scheme until the host is seen redirecting to it
+		c := newTestCrawler(t, "http://example.com/", func(cfg *Config) {
+			cfg.NormalizeScheme = true
+			cfg.SeedURLs = []string{"https://example.com/a", "http://example.com/b"}
+		})
+		if got := strings.Join(c.cfg.SeedURLs, " "); got != "https://example.com/a http://example.com/b" {
+			t.Errorf("seeds %s", got)
+		}
+	})
+}
+
+// TestSchemeUpgradeLearning has an http site's host redirect its http://
+// URLs to https://: it is upgraded after schemeUpgradeRedirects permanent
+// ones, and never once a page has been served over http as it was
+func TestSchemeUpgradeLearning(t *testing.T) {
+	redirect := func(c *Crawler, path string, status int) {
+		from, _ := url.Parse("http://example.com" + path)
+		to, _ := url.Parse("https://example.com" + path)
+		c.noteSchemeRedirect(from, to, status)
+	}
+	folded := func(c *Crawler) string {
+		u, _ := c.normalizeURL("http://example.com/page")
+		return u
+	}
+
+	c := newTestCrawler(t, "http://example.com/", func(cfg *Config) { cfg.NormalizeScheme = true })
+	redirect(c, "/a", http.StatusFound)
+	redirect(c, "/b", http.StatusMovedPermanently)
+	if got := folded(c); got != "http://example.com/page" {
+		t.Errorf("upgraded to %s after one permanent redirect", got)
+	}
+	redirect(c, "/c", http.StatusPermanentRedirect)
+	if got := folded(c); got != "https://example.com/page" {
+		t.Errorf("%s after %d permanent redirects, want it upgraded", got, schemeUpgradeRedirects)
+	}
+
+	c = newTestCrawler(t, "http://example.com/", func(cfg *Config) { cfg.NormalizeScheme = true })
+	c.noteServedPlain("http://example.com/plain", nil, http.StatusOK)
+	redirect(c, "/a", http.StatusMovedPermanently)
+	redirect(c, "/b", http.StatusMovedPermanently)
+	if got := folded(c); got != "http://example.com/page" {
+		t.Errorf("upgraded to %s though a page was served over http", got)
+	}
+}
+
+// TestHostNormalizationCrawl crawls a site on https://example.test/ whose
+// pages link to each other as http and https, www and not: with both
+// options each page is fetched once, over https from the bare host, and
+// nothing is asked of the http server
+func TestHostNormalizationCrawl(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Every page is only linked to in forms other than its own
+	links := map[string]string{
+		"/":  `<a href="http://example.test/a">a</a><a href="https://www.example.test/b">b</a><a href="http://www.example.test/c">c</a>`,
+		"/a": `<a href="https://www.example.test/a">a</a><a href="http://example.test/b">b</a><a href="//www.example.test/c">c</a>`,
+		"/b": `<a href="http://www.example.test/">home</a><a href="HTTP://WWW.example.test/a">a</a>`,
+		"/c": `<a href="https://www.example.test/c#top">c</a>`,
+	}
+	var mu sync.Mutex
+	var requested, plain []string
+	srv := newTLSTestServer(t, ca, nil, "example.test", "www.example.test")
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.Host+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>` + links[r.URL.Path] + `</body></html>`))
+	})
+	plainSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		plain = append(plain, r.Host+r.URL.Path)
+		mu.Unlock()
+		http.Redirect(w, r, "https://"+r.Host+r.URL.Path, http.StatusMovedPermanently)
+	}))
+	defer plainSrv.Close()
+	tlsAddr, plainAddr := strings.TrimPrefix(srv.URL, "https://"), strings.TrimPrefix(plainSrv.URL, "http://")
+
+	c := newTestCrawler(t, "https://example.test/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.MaxAttempts = 1
+		cfg.TLSCAFile = caFile
+		cfg.NormalizeScheme = true
+		cfg.NormalizeWWW = true
+		cfg.HostMapping = map[string]string{
+			"example.test:443":     tlsAddr,
+			"www.example.test:443": tlsAddr,
+			"example.test:80":      plainAddr,
+			"www.example.test:80":  plainAddr,
+		}
+	})
+	var pages []string
+	c.OnPage = func(p PageResult) { pages = append(pages, p.URL) }
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	sort.Strings(requested)
+	if got, want := strings.Join(requested, " "), "example.test/ example.test/a example.test/b example.test/c"; got != want {
+		t.Errorf("requested %s, want %s", got, want)
+	}
+	if len(plain) != 0 {
+		t.Errorf("requested %v over http", plain)
+	}
+	sort.Strings(pages)
+	if got, want := strings.Join(pages, " "), "https://example.test/ https://example.test/a https://example.test/b https://example.test/c"; got != want {
+		t.Errorf("pages %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// hrefUnsafe are the ASCII characters browsers percent-encode in an href
+// as written rather than send as they are
+const hrefUnsafe = " \"<>`"
+
+// cleanHref readies an href as written, its entities already decoded by
+// the HTML parser, for url.Parse the way browsers read one: tabs and
+// newlines are dropped, and spaces, quotes, angle brackets and a "%" that
+// doesn't start an escape are percent-encoded. Escapes already there are
+// left alone, so "%20" isn't encoded again.
+func cleanHref(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if !strings.ContainsAny(raw, hrefUnsafe+"%\t\n\r") {
+		return raw
+	}
+	var sb strings.Builder
+	for i := 0; i < len(raw); i++ {
+		switch b := raw[i]; {
+		case b == '\t' || b == '\n' || b == '\r':
+		case b == '%' && (i+2 >= len(raw) || !isHex(raw[i+1]) || !isHex(raw[i+2])):
+			sb.WriteString("%25")
+		case strings.IndexByte(hrefUnsafe, b) >= 0:
+			sb.WriteString(percentByte(b))
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	return sb.String()
+}
+
+// encodeURL makes a parsed URL fit to request: a Unicode host becomes
+// punycode and the query has its spaces and non-ASCII characters
+// percent-encoded. String encodes the path and fragment itself.
+func encodeURL(u *url.URL) {
+	u.Host = asciiHost(u.Host)
+	u.RawQuery = escapeQuery(u.RawQuery)
+}
+
+// asciiHost is host, with its port if it has one, in punycode: "bücher.de"
+// is "xn--bcher-kva.de". A host that is ASCII already or isn't a valid
+// domain name is returned as it is.
+func asciiHost(host string) string {
+	if isASCII(host) {
+		return host
+	}
+	name, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		name, port = h, p
+	}
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return host
+	}
+	if port != "" {
+		return net.JoinHostPort(ascii, port)
+	}
+	return ascii
+}
+
+// escapeQuery percent-encodes the bytes of a raw query that can't go in a
+// request line as they are, keeping the escapes, "&" and "=" it has
+func escapeQuery(rawQuery string) string {
+	var sb strings.Builder
+	for i := 0; i < len(rawQuery); i++ {
+		b := rawQuery[i]
+		if b > ' ' && b < 0x7f && strings.IndexByte(hrefUnsafe, b) < 0 {
+			if sb.Len() > 0 {
+				sb.WriteByte(b)
+			}
+			continue
+		}
+		if sb.Len() == 0 {
+			sb.WriteString(rawQuery[:i])
+		}
+		sb.WriteString(percentByte(b))
+	}
+	if sb.Len() == 0 {
+		return rawQuery
+	}
+	return sb.String()
+}
+
+// displayURL is a URL with a punycode host as people read it, its host in
+// Unicode, or empty for one without
+func displayURL(raw string) string {
+	if !strings.Contains(raw, "xn--") {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	name, err := idna.Display.ToUnicode(u.Hostname())
+	if err != nil || name == u.Hostname() {
+		return ""
+	}
+	if port := u.Port(); port != "" {
+		name = net.JoinHostPort(name, port)
+	}
+	// The host is put back by hand, String would percent-encode it
+	u.User, u.Host = nil, ""
+	return strings.Replace(u.String(), "://", "://"+name, 1)
+}
+
+func percentByte(b byte) string {
+	const hex = "0123456789ABCDEF"
+	return string([]byte{'%', hex[b>>4], hex[b&15]})
+}
+
+func isHex(b byte) bool {
+	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package crawler
+
+import (
+	"testing"
+)
+
+// TestHrefRoundTrip takes hrefs as written in a page through extraction
+// and normalization: what is requested is encoded once, whatever the page
+// had encoded already, and a Unicode host is requested in punycode but
+// shown as it was written
+func TestHrefRoundTrip(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	tests := []struct {
+		name    string
+		href    string
+		want    string
+		display string
+	}{
+		{"entity-encoded ampersand", `/search?q=go&amp;page=2`, "https://example.com/search?page=2&q=go", ""},
+		{"entity-encoded ampersand in a relative query", `?a=1&amp;b=2`, "https://example.com/docs?a=1&b=2", ""},
+		{"existing escape kept", `/my%20file.html`, "https://example.com/my%20file.html", ""},
+		{"space encoded", `/my file.html`, "https://example.com/my%20file.html", ""},
+		{"escaped slash kept", `/a%2Fb`, "https://example.com/a%2Fb", ""},
+		{"stray percent encoded", `/100%`, "https://example.com/100%25", ""},
+		{"unicode path", `/café/menü`, "https://example.com/caf%C3%A9/men%C3%BC", ""},
+		{"encoded unicode path kept", `/caf%C3%A9`, "https://example.com/caf%C3%A9", ""},
+		{"spaces and unicode in the query", `/a?q=x y&amp;z=ä`, "https://example.com/a?q=x%20y&z=%C3%A4", ""},
+		{"padding and newlines dropped", " /pad\nded\t", "https://example.com/padded", ""},
+		{"unicode host", `https://bücher.de/katalog`, "https://xn--bcher-kva.de/katalog", "https://bücher.de/katalog"},
+		{"unicode host with a port", `https://bücher.de:8443/x?q=1`, "https://xn--bcher-kva.de:8443/x?q=1", "https://bücher.de:8443/x?q=1"},
+		{"punycode host", `https://xn--bcher-kva.de/`, "https://xn--bcher-kva.de/", "https://bücher.de/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := extract(t, c, "https://example.com/docs/", `<a href="`+tt.href+`">x</a>`)
+			found := append(info.Links, info.External...)
+			if len(found) != 1 {
+				t.Fatalf("found %v, want one link", found)
+			}
+			got, err := c.normalizeURL(found[0])
+			if err != nil {
+				t.Fatalf("normalizing %s: %v", found[0], err)
+			}
+			if got != tt.want {
+				t.Errorf("requested as %s, want %s", got, tt.want)
+			}
+			// Normalizing what is requested again changes nothing
+			if again, _ := c.normalizeURL(got); again != got {
+				t.Errorf("normalized again to %s", again)
+			}
+			if display := displayURL(got); display != tt.display {
+				t.Errorf("displayed as %q, want %q", display, tt.display)
+			}
+		})
+	}
+}
+
+func TestCleanHref(t *testing.T) {
+	tests := []struct{ raw, want string }{
+		{"/plain", "/plain"},
+		{" /padded ", "/padded"},
+		{"/a b", "/a%20b"},
+		{"/a%20b", "/a%20b"},
+		{"/50%off", "/50%25off"},
+		{"/%zz", "/%25zz"},
+		{"/a<b>`c`", "/a%3Cb%3E%60c%60"},
+		{"/li\nne\r\tbreak", "/linebreak"},
+		{"/café", "/café"},
+	}
+	for _, tt := range tests {
+		if got := cleanHref(tt.raw); got != tt.want {
+			t.Errorf("cleanHref(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeQuery(t *testing.T) {
+	tests := []struct{ raw, want string }{
+		{"", ""},
+		{"a=1&b=2", "a=1&b=2"},
+		{"q=a%20b", "q=a%20b"},
+		{"q=a b", "q=a%20b"},
+		{"q=ä", "q=%C3%A4"},
+		{`q="x"`, "q=%22x%22"},
+	}
+	for _, tt := range tests {
+		if got := escapeQuery(tt.raw); got != tt.want {
+			t.Errorf("escapeQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestAsciiHost(t *testing.T) {
+	tests := []struct{ host, want string }{
+		{"example.com", "example.com"},
+		{"example.com:8080", "example.com:8080"},
+		{"bücher.de", "xn--bcher-kva.de"},
+		{"bücher.de:8443", "xn--bcher-kva.de:8443"},
+		{"Bücher.DE", "xn--bcher-kva.de"},
+		{"ex ample.ü", "ex ample.ü"},
+	}
+	for _, tt := range tests {
+		if got := asciiHost(tt.host); got != tt.want {
+			t.Errorf("asciiHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
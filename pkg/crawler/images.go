@@ -0,0 +1,372 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// imagesSubfolder sits inside the downloaded files folder
+const imagesSubfolder = "images"
+
+// imageHeaderSize is how much of an image is kept to read its dimensions
+// from; JPEGs can carry a lot of EXIF before the frame header
+const imageHeaderSize = 256 << 10
+
+// imageRef is an image a page shows: an img src or srcset candidate, a
+// <picture> source, or a CSS background. Alt is the img alt text, empty
+// for backgrounds.
+type imageRef struct {
+	URL string
+	Alt string
+}
+
+// imageRecord is one line of images.jsonl, written when an image has been
+// fetched or has failed with an error status. Width, Height and Format are
+// read from the image header, so they are missing for formats Go can't
+// decode, and with ImagesReportOnly, which only sends a HEAD.
+type imageRecord struct {
+	URL         string    `json:"url"`
+	Referrer    string    `json:"referrer,omitempty"`
+	Alt         string    `json:"alt"`
+	Status      int       `json:"status,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Format      string    `json:"format,omitempty"`
+	Bytes       int64     `json:"bytes"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	File        string    `json:"file,omitempty"`
+	Method      string    `json:"method,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+var cssBackgroundPattern = regexp.MustCompile(`(?i)background(?:-image)?\s*:([^;}]*)`)
+
+// cssBackgrounds returns the URLs of the background and background-image
+// declarations in css, resolved against base
+func cssBackgrounds(base *url.URL, css string) []string {
+	var urls []string
+	for _, m := range cssBackgroundPattern.FindAllStringSubmatch(css, -1) {
+		urls = append(urls, cssReferences(base, m[1])...)
+	}
+	return urls
+}
+
+// extractImages lists the images on a parsed page, resolved through
+// resolve, with the alt text of the img each belongs to
+func extractImages(doc *goquery.Document, page *url.URL, resolve func(string) (*url.URL, bool)) []imageRef {
+	var refs []imageRef
+	add := func(raw, alt string) {
+		if u, ok := resolve(raw); ok {
+			u.Fragment = ""
+			refs = append(refs, imageRef{URL: u.String(), Alt: strings.Join(strings.Fields(alt), " ")})
+		}
+	}
+	addSet := func(s *goquery.Selection, alt string) {
+		if src, ok := s.Attr("src"); ok {
+			add(src, alt)
+		}
+		if srcset, ok := s.Attr("srcset"); ok {
+			for _, candidate := range parseSrcset(srcset) {
+				add(candidate, alt)
+			}
+		}
+	}
+	doc.Find("img").Each(func(i int, s *goquery.Selection) {
+		alt, _ := s.Attr("alt")
+		addSet(s, alt)
+	})
+	// A <picture>'s sources are alternatives to its img, and share its alt
+	doc.Find("picture source").Each(func(i int, s *goquery.Selection) {
+		alt, _ := s.Closest("picture").Find("img").First().Attr("alt")
+		addSet(s, alt)
+	})
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		for _, u := range cssBackgrounds(page, s.Text()) {
+			refs = append(refs, imageRef{URL: u})
+		}
+	})
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		style, _ := s.Attr("style")
+		for _, u := range cssBackgrounds(page, style) {
+			refs = append(refs, imageRef{URL: u})
+		}
+	})
+	return refs
+}
+
+// recordImages adds a page's in-scope images to the image inventory, one
+// line per image, page and alt text
+func (c *Crawler) recordImages(images []imageRef, referrer string) {
+	if c.imageURLsFile == nil {
+		return
+	}
+	for _, img := range images {
+		u, err := url.Parse(img.URL)
+		if err != nil || !c.hostAllowed(u.Host) {
+			continue
+		}
+		link := img.URL
+		if normalized, err := c.normalizeURL(link); err == nil {
+			link = normalized
+		}
+		_, _ = c.imageURLsFile.add(link + "\t" + referrer + "\t" + img.Alt)
+	}
+}
+
+// imageAlts keeps the alt text an image was first found with, for its line
+// in images.jsonl
+type imageAlts struct {
+	mu   sync.Mutex
+	alts map[string]string
+}
+
+func (a *imageAlts) set(url, alt string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.alts == nil {
+		a.alts = make(map[string]string)
+	}
+	if _, ok := a.alts[url]; !ok {
+		a.alts[url] = alt
+	}
+}
+
+func (a *imageAlts) get(url string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.alts[url]
+}
+
+// pendingImages returns the image inventory entries still to fetch, done
+// once the frontier has them as scraped or failed like assets, noting
+// each one's first alt text on the way. seen holds the URLs already
+// accounted for, and gets these added.
+func (c *Crawler) pendingImages(seen map[string]bool) []FrontierEntry {
+	if c.imageURLsFile == nil {
+		return nil
+	}
+	var images []FrontierEntry
+	for _, line := range c.imageURLsFile.snapshot() {
+		u, rest, _ := strings.Cut(line, "\t")
+		referrer, alt, _ := strings.Cut(rest, "\t")
+		if seen[u] || c.Frontier.IsScraped(u) {
+			continue
+		}
+		seen[u] = true
+		c.imageAlts.set(u, alt)
+		images = append(images, FrontierEntry{URL: u, Referrer: referrer, Asset: true, Image: true})
+	}
+	return images
+}
+
+// imageReferrerLines returns the inventory as url and referrer lines, for
+// the broken links report
+func (c *Crawler) imageReferrerLines() []string {
+	if c.imageURLsFile == nil {
+		return nil
+	}
+	var lines []string
+	for _, line := range c.imageURLsFile.snapshot() {
+		u, rest, _ := strings.Cut(line, "\t")
+		referrer, _, _ := strings.Cut(rest, "\t")
+		lines = append(lines, u+"\t"+referrer)
+	}
+	return lines
+}
+
+// scrapeImage fetches one image from the inventory and writes its line in
+// images.jsonl. It is saved under images/, or in the mirror layout under
+// assets/ when it is also an asset to download, unless ImagesReportOnly
+// keeps it to a HEAD.
+func (c *Crawler) scrapeImage(ctx context.Context, job Job) error {
+	log := c.logFrom(ctx)
+	log.Debug("fetching image")
+	started := time.Now()
+
+	u, err := url.Parse(job.URL)
+	if err != nil {
+		return err
+	}
+	rec := imageRecord{URL: job.URL, Referrer: job.Referrer, Alt: c.imageAlts.get(job.URL), FetchedAt: started.UTC()}
+	if c.cfg.ImagesReportOnly {
+		err = c.headImage(ctx, &rec)
+	} else {
+		err = c.downloadImage(ctx, job, u, &rec, started)
+	}
+	var se *statusError
+	if err != nil {
+		if !errors.As(err, &se) {
+			return err
+		}
+		rec.Status, rec.Error = se.code, err.Error()
+	}
+	if c.images != nil {
+		_ = c.images.write(rec)
+	}
+	return err
+}
+
+// headImage fills in rec from a HEAD, falling back to a GET read no
+// further than the header for servers that refuse HEAD
+func (c *Crawler) headImage(ctx context.Context, rec *imageRecord) error {
+	rec.Method = http.MethodHead
+	resp, err := c.requestWithRetry(ctx, http.MethodHead, rec.URL, nil)
+	if se := (*statusError)(nil); errors.As(err, &se) && (se.code == http.StatusMethodNotAllowed || se.code == http.StatusNotImplemented) {
+		rec.Method = http.MethodGet
+		resp, err = c.fetchWithRetry(ctx, rec.URL, nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rec.Status = resp.StatusCode
+	rec.Bytes = max(resp.ContentLength, 0)
+	if rec.Method == http.MethodHead {
+		if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+			rec.ContentType = strings.ToLower(mediaType)
+		}
+		rec.Format = formatFromType(rec.ContentType)
+		return nil
+	}
+	decoded, err := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewReader(decoded)
+	rec.ContentType = responseContentType(resp, buffered)
+	rec.Format = formatFromType(rec.ContentType)
+	head, _ := io.ReadAll(io.LimitReader(buffered, imageHeaderSize))
+	readImageHeader(head, rec)
+	return nil
+}
+
+// downloadImage saves an image, capped by AssetMaxSize, filling in rec
+// with what its header says
+func (c *Crawler) downloadImage(ctx context.Context, job Job, u *url.URL, rec *imageRecord, started time.Time) error {
+	resp, err := c.fetchWithRetry(ctx, job.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if c.cfg.AssetMaxSize > 0 && resp.ContentLength > c.cfg.AssetMaxSize {
+		return errAssetTooLarge
+	}
+	wire, captured, err := c.archiveCapture(resp.Body)
+	if err != nil {
+		return err
+	}
+	if captured != nil {
+		defer captured.discard()
+	}
+	decoded, err := decodeBody(wire, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewReader(decoded)
+	contentType := responseContentType(resp, buffered)
+
+	fileName := c.mirrorPath(imagesSubfolder, u)
+	if c.wantAsset(job.URL) {
+		fileName = c.assetPath(u)
+	}
+	fileName = c.pageManifest.claimFile(fileName, job.URL)
+	var body io.Reader = buffered
+	if c.cfg.AssetMaxSize > 0 {
+		body = &limitedBody{r: body, remaining: c.cfg.AssetMaxSize, err: errAssetTooLarge}
+	}
+	var head headBuffer
+	hashed := newHashingReader(io.TeeReader(body, &head))
+	size, err := c.Storage.SavePage(fileName, PageMeta{URL: job.URL, ContentType: contentType, Header: resp.Header}, &ctxReader{ctx: ctx, r: hashed})
+	if err != nil {
+		return err
+	}
+	c.bodiesSaved.Add(1)
+	c.bytesSaved.Add(size)
+	c.archive(resp, captured, wire, started)
+	entry := manifestEntry{
+		URL:          job.URL,
+		File:         fileName,
+		Size:         size,
+		ContentType:  contentType,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CheckedAt:    started.UTC(),
+		SHA256:       hashed.sum(),
+	}
+	c.noteStored(&entry)
+	if err := c.pageManifest.record(entry); err != nil {
+		return err
+	}
+	_ = c.pageRecords.write(pageRecord{
+		URL:           job.URL,
+		Status:        resp.StatusCode,
+		ContentType:   contentType,
+		ContentLength: size,
+		FetchedAt:     started.UTC(),
+		DurationMS:    time.Since(started).Milliseconds(),
+		UserAgent:     resp.Request.Header.Get("User-Agent"),
+		File:          fileName,
+		Order:         job.Order,
+		Referrer:      job.Referrer,
+	})
+	c.logFrom(ctx).Log(ctx, c.pageLevel(), "image saved", "status", resp.StatusCode, "duration_ms", time.Since(started).Milliseconds(),
+		"bytes", size, "file", fileName)
+
+	rec.Status = resp.StatusCode
+	rec.ContentType = contentType
+	rec.Format = formatFromType(contentType)
+	rec.Bytes = size
+	rec.File = fileName
+	readImageHeader(head.Bytes(), rec)
+	return nil
+}
+
+// headBuffer keeps the first imageHeaderSize bytes written to it
+type headBuffer struct {
+	bytes.Buffer
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if room := imageHeaderSize - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// readImageHeader sets rec's dimensions and format from the start of the
+// image, when Go can decode it
+func readImageHeader(head []byte, rec *imageRecord) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		return
+	}
+	rec.Width, rec.Height, rec.Format = cfg.Width, cfg.Height, format
+}
+
+// formatFromType names an image format after its media type: "webp" for
+// image/webp, "svg" for image/svg+xml
+func formatFromType(mediaType string) string {
+	major, sub, ok := strings.Cut(mediaType, "/")
+	if !ok || major != "image" {
+		return ""
+	}
+	sub, _, _ = strings.Cut(sub, "+")
+	return strings.TrimPrefix(sub, "x-")
+}
@@ -0,0 +1,356 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// URL states in the frontier journal
+const (
+	statePending = "pending"
+	stateScraped = "scraped"
+	stateFailed  = "failed"
+)
+
+// journalRecord is the state of one URL in the frontier journal
+type journalRecord struct {
+	URL string `json:"url"`
+	// Found is false for URLs that were only ever marked, like redirect
+	// targets and assets, which are never dispatched from the frontier
+	Found    bool   `json:"found,omitempty"`
+	Depth    int    `json:"depth,omitempty"`
+	Referrer string `json:"referrer,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Chain    int    `json:"chain,omitempty"`
+	// Priority is only kept by the SQLite frontier, which orders by it
+	Priority int    `json:"priority,omitempty"`
+	State    string `json:"state"`
+	// Failures counts the runs the URL failed in, each after using up its
+	// attempts; LastError is why it failed the last time
+	Failures  int       `json:"failures,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	FoundAt   time.Time `json:"found_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// FetchedAt is when the URL was last scraped; it outlives the state
+	// going back to pending for a recrawl
+	FetchedAt time.Time `json:"fetched_at,omitzero"`
+}
+
+// journalFrontier is the default frontier: every change to a URL appends
+// its whole record to frontier.jsonl, and the last record for a URL wins.
+// The records live in memory, in the order the URLs were first seen, and
+// the journal is compacted on open once most of its lines are stale.
+type journalFrontier struct {
+	path string
+	// legacyPath is the text frontier's found file, which a project that
+	// hasn't been migrated yet still has
+	legacyPath string
+
+	mu      sync.Mutex
+	records map[string]*journalRecord
+	order   []string
+	// next is the position in order before which nothing is pending
+	next  int
+	file  *os.File
+	w     *bufio.Writer
+	lines int
+}
+
+func (j *journalFrontier) Open() error {
+	if _, err := os.Stat(j.path); os.IsNotExist(err) && j.legacyPath != "" {
+		if info, err := os.Stat(j.legacyPath); err == nil && info.Size() > 0 {
+			return fmt.Errorf("%s is from the text frontier; run the migrate command to import it, or set FRONTIER=text", j.legacyPath)
+		}
+	}
+	j.records = make(map[string]*journalRecord)
+	j.order, j.next, j.lines = nil, 0, 0
+	if err := j.load(); err != nil {
+		return err
+	}
+	if j.lines > 2*len(j.records) {
+		if err := j.compact(); err != nil {
+			return fmt.Errorf("compacting %s: %w", j.path, err)
+		}
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	j.file, j.w = f, bufio.NewWriter(f)
+	return nil
+}
+
+func (j *journalFrontier) load() error {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		j.lines++
+		var r journalRecord
+		// A line cut short by a crash is skipped rather than fatal
+		if err := json.Unmarshal([]byte(line), &r); err != nil || r.URL == "" {
+			continue
+		}
+		if _, ok := j.records[r.URL]; !ok {
+			j.order = append(j.order, r.URL)
+		}
+		j.records[r.URL] = &r
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the journal with one line per URL
+func (j *journalFrontier) compact() error {
+	var sb strings.Builder
+	for _, u := range j.order {
+		line, err := json.Marshal(j.records[u])
+		if err != nil {
+			return err
+		}
+		sb.Write(line)
+		sb.WriteByte('\n')
+	}
+	if err := writeFileAtomic(j.path, []byte(sb.String())); err != nil {
+		return err
+	}
+	j.lines = len(j.order)
+	return nil
+}
+
+// put stores r and appends it to the journal; the caller holds j.mu
+func (j *journalFrontier) put(r *journalRecord) error {
+	r.UpdatedAt = r.UpdatedAt.UTC()
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.lines++
+	if _, ok := j.records[r.URL]; !ok {
+		j.order = append(j.order, r.URL)
+	}
+	j.records[r.URL] = r
+	return nil
+}
+
+// record returns a copy of url's record to modify, or a new one
+func (j *journalFrontier) record(url string) *journalRecord {
+	if r, ok := j.records[url]; ok {
+		copied := *r
+		return &copied
+	}
+	return &journalRecord{URL: url, State: statePending}
+}
+
+func (j *journalFrontier) Add(e FrontierEntry) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	r := j.record(e.URL)
+	if r.Found {
+		return false, nil
+	}
+	now := time.Now()
+	r.Found, r.Depth, r.Referrer, r.Source, r.Chain, r.FoundAt, r.UpdatedAt = true, e.Depth, e.Referrer, e.Source, e.Chain, now.UTC(), now
+	return true, j.put(r)
+}
+
+func (j *journalFrontier) NextBatch(n int) []FrontierEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for j.next < len(j.order) && !j.records[j.order[j.next]].pending() {
+		j.next++
+	}
+	var batch []FrontierEntry
+	for _, u := range j.order[j.next:] {
+		if len(batch) == n {
+			break
+		}
+		if r := j.records[u]; r.pending() {
+			batch = append(batch, FrontierEntry{URL: r.URL, Depth: r.Depth, Referrer: r.Referrer, Source: r.Source, Chain: r.Chain})
+		}
+	}
+	return batch
+}
+
+func (r *journalRecord) pending() bool {
+	return r.Found && r.State == statePending
+}
+
+func (j *journalFrontier) Stats() FrontierStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var s FrontierStats
+	for _, r := range j.records {
+		if r.Found {
+			s.Found++
+		}
+		switch {
+		case r.State == stateScraped:
+			s.Scraped++
+		case r.State == stateFailed:
+			s.Failed++
+		case r.Found:
+			s.Pending++
+		}
+	}
+	return s
+}
+
+func (j *journalFrontier) Found() []FrontierEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var found []FrontierEntry
+	for _, u := range j.order {
+		if r := j.records[u]; r.Found {
+			found = append(found, FrontierEntry{URL: r.URL, Depth: r.Depth, Referrer: r.Referrer, Source: r.Source, Chain: r.Chain})
+		}
+	}
+	return found
+}
+
+func (j *journalFrontier) MarkScraped(url string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if r, ok := j.records[url]; ok && r.State == stateScraped {
+		return nil
+	}
+	r := j.record(url)
+	now := time.Now()
+	r.State, r.UpdatedAt, r.FetchedAt = stateScraped, now, now
+	return j.put(r)
+}
+
+// FetchedAt falls back on when the URL was marked scraped for journals
+// written before fetch times were kept
+func (j *journalFrontier) FetchedAt(url string) time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	r, ok := j.records[url]
+	switch {
+	case !ok:
+		return time.Time{}
+	case r.FetchedAt.IsZero() && r.State == stateScraped:
+		return r.UpdatedAt
+	}
+	return r.FetchedAt
+}
+
+func (j *journalFrontier) IsScraped(url string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	r, ok := j.records[url]
+	return ok && r.State == stateScraped
+}
+
+func (j *journalFrontier) Scraped() []string {
+	return j.urlsIn(stateScraped)
+}
+
+func (j *journalFrontier) urlsIn(state string) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var urls []string
+	for _, u := range j.order {
+		if j.records[u].State == state {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// MarkFailed keeps the referrer of a URL that was never found, like an
+// asset, since there is no found one to fall back on
+func (j *journalFrontier) MarkFailed(f FailedURL) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if r, ok := j.records[f.URL]; ok && r.State == stateFailed {
+		return nil
+	}
+	r := j.record(f.URL)
+	r.State, r.UpdatedAt = stateFailed, f.At
+	r.Failures++
+	r.LastError = strings.Join(strings.Fields(f.Reason), " ")
+	if r.Referrer == "" {
+		r.Referrer = f.Referrer
+	}
+	return j.put(r)
+}
+
+func (j *journalFrontier) Failed() []FailedURL {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var failed []FailedURL
+	for _, u := range j.order {
+		if r := j.records[u]; r.State == stateFailed {
+			failed = append(failed, FailedURL{URL: r.URL, Reason: r.LastError, Referrer: r.Referrer, At: r.UpdatedAt})
+		}
+	}
+	return failed
+}
+
+func (j *journalFrontier) ForgetScraped(urls map[string]bool) error {
+	return j.forget(stateScraped, urls)
+}
+
+func (j *journalFrontier) ForgetFailed(urls map[string]bool) error {
+	return j.forget(stateFailed, urls)
+}
+
+// forget makes the URLs in state pending again, only those in urls unless
+// it is nil
+func (j *journalFrontier) forget(state string, urls map[string]bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for _, u := range j.order {
+		if j.records[u].State != state || (urls != nil && !urls[u]) {
+			continue
+		}
+		r := j.record(u)
+		r.State, r.UpdatedAt = statePending, now
+		if err := j.put(r); err != nil {
+			return err
+		}
+	}
+	j.next = 0
+	return nil
+}
+
+func (j *journalFrontier) Flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *journalFrontier) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.Flush()
+	if closeErr := j.file.Close(); err == nil {
+		err = closeErr
+	}
+	j.file = nil
+	return err
+}
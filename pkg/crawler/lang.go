@@ -0,0 +1,221 @@
+package crawler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Where a page's language was read from, as pages.jsonl has it
+const (
+	languageAttr   = "lang"
+	languageHeader = "header"
+	languageText   = "text"
+)
+
+// languageSampleWords is how many words of a page's text the detector
+// reads, and languageMinWords how many it needs to say anything
+const (
+	languageSampleWords = 2000
+	languageMinWords    = 20
+)
+
+// languageScripts are the writing systems that give a language away by
+// themselves. Japanese is told from Chinese by its kana, so the kana come
+// before Han.
+var languageScripts = []struct {
+	table *unicode.RangeTable
+	tag   string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// stopwords are the commonest words of the languages written in Latin
+// letters that the detector knows, few of them shared between two
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "for", "with", "you", "this", "are", "was", "on"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "sie", "ein", "eine", "auf", "für", "sich", "auch", "dem"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "dans", "pour", "pas", "que", "qui", "sur", "du", "avec"},
+	"es": {"el", "los", "las", "y", "es", "una", "por", "con", "para", "del", "que", "como", "pero", "más", "su"},
+	"it": {"il", "di", "che", "è", "della", "per", "una", "sono", "gli", "con", "non", "del", "alla", "anche", "questo"},
+	"pt": {"o", "os", "e", "do", "da", "não", "uma", "com", "para", "em", "que", "dos", "das", "mais", "também"},
+	"nl": {"de", "het", "een", "en", "van", "is", "niet", "dat", "op", "zijn", "met", "voor", "ook", "je", "er"},
+	"sv": {"och", "att", "det", "som", "är", "en", "på", "för", "med", "inte", "av", "till", "den", "har", "jag"},
+}
+
+// stopwordLanguages maps each stopword to the languages it counts for
+var stopwordLanguages = func() map[string][]string {
+	m := make(map[string][]string)
+	for tag, words := range stopwords {
+		for _, w := range words {
+			m[w] = append(m[w], tag)
+		}
+	}
+	return m
+}()
+
+// translation is a page's hreflang alternate
+type translation struct {
+	Lang string
+	URL  string
+}
+
+// detectLanguage returns a parsed page's language and where it was read
+// from: <html lang> or xml:lang, else the Content-Language header or its
+// <meta http-equiv> stand-in, else a guess from the text. The tag is
+// lowercase, like "en" or "pt-br"; it is empty when the text doesn't say.
+func detectLanguage(doc *goquery.Document, header http.Header) (tag, source string) {
+	root := doc.Find("html").First()
+	for _, attr := range []string{"lang", "xml:lang"} {
+		if v, _ := root.Attr(attr); normalizeLanguage(v) != "" {
+			return normalizeLanguage(v), languageAttr
+		}
+	}
+	if v := normalizeLanguage(header.Get("Content-Language")); v != "" {
+		return v, languageHeader
+	}
+	meta := ""
+	doc.Find("meta[http-equiv][content]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if equiv, _ := s.Attr("http-equiv"); strings.EqualFold(strings.TrimSpace(equiv), "content-language") {
+			meta, _ = s.Attr("content")
+			return false
+		}
+		return true
+	})
+	if v := normalizeLanguage(meta); v != "" {
+		return v, languageHeader
+	}
+	if v := guessLanguage(pageText(doc, textFull)); v != "" {
+		return v, languageText
+	}
+	return "", ""
+}
+
+// normalizeLanguage is the first of a comma-separated list of language
+// tags, lowercased with "-" between its parts, or empty if it isn't one
+func normalizeLanguage(v string) string {
+	v, _, _ = strings.Cut(v, ",")
+	v = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(v), "_", "-"))
+	primary, _, _ := strings.Cut(v, "-")
+	if len(primary) < 2 || len(primary) > 8 || strings.IndexFunc(primary, func(r rune) bool { return r < 'a' || r > 'z' }) >= 0 {
+		return ""
+	}
+	return v
+}
+
+// primaryLanguage is a tag's language without its region or script:
+// "pt" for "pt-br"
+func primaryLanguage(tag string) string {
+	primary, _, _ := strings.Cut(tag, "-")
+	return primary
+}
+
+// guessLanguage tells the language of text from its writing system, or
+// for Latin letters from which language's stopwords it has most of. It
+// gives up on short texts and on those no language stands out in.
+func guessLanguage(text string) string {
+	words := strings.Fields(text)
+	if len(words) > languageSampleWords {
+		words = words[:languageSampleWords]
+	}
+	if len(words) < languageMinWords {
+		return ""
+	}
+	letters := 0
+	scripts := make(map[string]int)
+	for _, w := range words {
+		for _, r := range w {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			for _, s := range languageScripts {
+				if unicode.Is(s.table, r) {
+					scripts[s.tag]++
+					break
+				}
+			}
+		}
+	}
+	for _, s := range languageScripts {
+		// Kana are a minority among the kanji of Japanese text
+		share := 2
+		if s.tag == "ja" {
+			share = 10
+		}
+		if letters > 0 && scripts[s.tag]*share > letters {
+			return s.tag
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimFunc(w, func(r rune) bool { return !unicode.IsLetter(r) }))
+		for _, tag := range stopwordLanguages[w] {
+			counts[tag]++
+		}
+	}
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	best, bestN, secondN := "", 0, 0
+	for _, tag := range tags {
+		switch n := counts[tag]; {
+		case n > bestN:
+			best, bestN, secondN = tag, n, bestN
+		case n > secondN:
+			secondN = n
+		}
+	}
+	// Running text has a stopword in every twenty words at the least, and
+	// the winner has to lead clearly
+	if bestN*20 < len(words) || bestN*2 < secondN*3 {
+		return ""
+	}
+	return best
+}
+
+// languageAllowed reports whether a page in tag may be saved: always
+// without AllowedLanguages, and when its language isn't known. An allowed
+// "en" takes "en-gb" too, an allowed "en-gb" only that.
+func (c *Crawler) languageAllowed(tag string) bool {
+	if len(c.cfg.AllowedLanguages) == 0 || tag == "" {
+		return true
+	}
+	for _, allowed := range c.cfg.AllowedLanguages {
+		if tag == allowed || strings.HasPrefix(tag, allowed+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// storeTranslations stores the in-scope hreflang alternates of a page in
+// the allowed languages, as finishPage stores its links, whether or not
+// the page itself is in one
+func (c *Crawler) storeTranslations(job Job, info *pageInfo) {
+	if len(c.cfg.AllowedLanguages) == 0 || info.NoFollow || (c.cfg.MaxDepth >= 0 && job.Depth >= c.cfg.MaxDepth) {
+		return
+	}
+	var links []string
+	for _, t := range info.Translations {
+		if lang := normalizeLanguage(t.Lang); lang != "" && c.languageAllowed(lang) && c.inScope(t.URL) {
+			links = append(links, t.URL)
+		}
+	}
+	c.storeURLs(links, FrontierEntry{Depth: job.Depth + 1, Referrer: job.URL})
+}
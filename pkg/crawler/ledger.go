@@ -0,0 +1,215 @@
+package crawler
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Run outcomes in the ledger
+const (
+	outcomeCompleted   = "completed"
+	outcomeInterrupted = "interrupted"
+	outcomeDeadline    = "deadline"
+	outcomeLimit       = "limit"
+	outcomeFailed      = "failed"
+	outcomeOriginDown  = "origin-down"
+)
+
+// runRecord is one crawl in runs.jsonl. A run writes its record when it
+// starts and again when it ends; the last line for an ID wins, so a run
+// without an end never finished, most likely because the process died.
+type runRecord struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at,omitzero"`
+	Outcome   string    `json:"outcome,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	// Pages is how many fetches the run made; Frontier is the state it
+	// left behind
+	Pages    int64          `json:"pages,omitempty"`
+	Frontier *FrontierStats `json:"frontier,omitempty"`
+	Proxies  []ProxyStats   `json:"proxies,omitempty"`
+	// TrapSuspects counts the URLs each trap rule turned down
+	TrapSuspects map[string]int `json:"trap_suspects,omitempty"`
+	// Failures counts the run's failed URLs by class
+	Failures map[string]int `json:"failures,omitempty"`
+	Config   Config         `json:"config"`
+}
+
+func newRunID(now time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// lastRun returns the latest run in the ledger, if there is one
+func lastRun(path string) (runRecord, bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return runRecord{}, false, nil
+	}
+	if err != nil {
+		return runRecord{}, false, err
+	}
+	defer f.Close()
+	var last runRecord
+	found := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var r runRecord
+		// A line cut short by a crash is skipped rather than fatal
+		if line == "" || json.Unmarshal([]byte(line), &r) != nil || r.ID == "" {
+			continue
+		}
+		last, found = r, true
+	}
+	return last, found, scanner.Err()
+}
+
+// ledgerConfig is the config as recorded in the ledger, without secrets
+func ledgerConfig(cfg Config) Config {
+	if cfg.S3.SecretAccessKey != "" {
+		cfg.S3.SecretAccessKey = "redacted"
+	}
+	for _, secret := range []*string{&cfg.BasicAuthPassword, &cfg.BearerToken, &cfg.Cookies} {
+		if *secret != "" {
+			*secret = "redacted"
+		}
+	}
+	if cfg.Headers != nil {
+		// Any header may carry a key, so only the names are kept
+		headers := make(map[string]string, len(cfg.Headers))
+		for name := range cfg.Headers {
+			headers[name] = "redacted"
+		}
+		cfg.Headers = headers
+	}
+	if cfg.Webhooks != nil {
+		// The URL of a chat webhook is a credential too
+		hooks := make([]Webhook, len(cfg.Webhooks))
+		for i, h := range cfg.Webhooks {
+			hooks[i] = Webhook{URL: "redacted", Events: h.Events}
+			if u, err := url.Parse(h.URL); err == nil {
+				hooks[i].URL = u.Scheme + "://" + u.Host + "/redacted"
+			}
+			if h.Secret != "" {
+				hooks[i].Secret = "redacted"
+			}
+		}
+		cfg.Webhooks = hooks
+	}
+	if u, err := url.Parse(cfg.EventSink); err == nil && u.User != nil {
+		// A user without a password is a NATS token
+		u.User = url.User("redacted")
+		cfg.EventSink = u.String()
+	}
+	if cfg.Login.Fields != nil {
+		fields := make(map[string]string, len(cfg.Login.Fields))
+		for name := range cfg.Login.Fields {
+			fields[name] = "redacted"
+		}
+		cfg.Login.Fields = fields
+	}
+	if cfg.ProxyURLs != nil {
+		cfg.ProxyURLs = append([]string(nil), cfg.ProxyURLs...)
+		for i, raw := range cfg.ProxyURLs {
+			if u, err := url.Parse(raw); err == nil {
+				cfg.ProxyURLs[i] = u.Redacted()
+			}
+		}
+	}
+	return cfg
+}
+
+// startRun records the start of this run, whose ID Run has already set,
+// in the ledger and returns the run before it
+func (c *Crawler) startRun() (runRecord, bool, error) {
+	previous, found, err := lastRun(c.runsFileName)
+	if err != nil {
+		return runRecord{}, false, err
+	}
+	c.ledger, err = openJSONL(c.runsFileName)
+	if err != nil {
+		return runRecord{}, false, err
+	}
+	c.run.Config = ledgerConfig(c.cfg)
+	return previous, found, c.ledger.write(c.run)
+}
+
+// endRun records how this run ended and closes the ledger
+func (c *Crawler) endRun(outcome string, err error) {
+	if c.ledger == nil {
+		return
+	}
+	c.run.EndedAt = time.Now().UTC()
+	c.run.Outcome = outcome
+	if err != nil {
+		// Errors before the crawl loop leave the outcome as it started
+		if outcome == outcomeCompleted {
+			c.run.Outcome = outcomeFailed
+		}
+		c.run.Error = err.Error()
+	}
+	c.run.Pages = c.pagesStarted.Load()
+	stats := c.Frontier.Stats()
+	c.run.Frontier = &stats
+	c.run.Proxies = c.proxyStats()
+	c.run.TrapSuspects = c.trapCounts()
+	c.run.Failures = c.failures.counts()
+	_ = c.ledger.write(c.run)
+	_ = c.ledger.close()
+	c.ledger = nil
+}
+
+// RunResult is how the last Run ended, for programs wrapping the crawl
+type RunResult struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at,omitzero"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	Pages     int64     `json:"pages"`
+	// Failed counts the URLs that failed for good in the run, and Failures
+	// those by class
+	Failed   int            `json:"failed"`
+	Failures map[string]int `json:"failures,omitempty"`
+	Frontier *FrontierStats `json:"frontier,omitempty"`
+}
+
+// LastRun returns how the last Run ended. A run that failed before it
+// could start crawling has the outcome "failed".
+func (c *Crawler) LastRun() RunResult {
+	r := RunResult{
+		ID:        c.run.ID,
+		StartedAt: c.run.StartedAt,
+		EndedAt:   c.run.EndedAt,
+		Outcome:   cmp.Or(c.run.Outcome, outcomeFailed),
+		Error:     c.run.Error,
+		Pages:     c.pagesStarted.Load(),
+		Failures:  c.failures.counts(),
+		Frontier:  c.run.Frontier,
+	}
+	for _, n := range r.Failures {
+		r.Failed += n
+	}
+	return r
+}
+
+// Aborted reports whether the run was cut short: interrupted, past its
+// deadline or stopped by the circuit breaker
+func (r RunResult) Aborted() bool {
+	switch r.Outcome {
+	case outcomeInterrupted, outcomeDeadline, outcomeOriginDown:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestLastRunResumed crawls a site with a missing page, then resumes the
+// finished crawl: the resumed run fails nothing itself, but its frontier
+// still holds the URL that failed before
+func TestLastRunResumed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><a href="/missing">missing</a></html>`))
+	}))
+	defer srv.Close()
+
+	folder := t.TempDir()
+	runs := []struct {
+		name                string
+		failed, stillFailed int
+	}{
+		{"first", 1, 1},
+		{"resumed", 0, 1},
+	}
+	for _, run := range runs {
+		c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+			cfg.ProjectFolder = folder
+			cfg.Workers = 1
+			cfg.RespectRobots = false
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := c.Run(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s run: %v", run.name, err)
+		}
+		last := c.LastRun()
+		if last.Failed != run.failed {
+			t.Errorf("%s run failed %d URLs, want %d", run.name, last.Failed, run.failed)
+		}
+		if last.Frontier == nil || last.Frontier.Failed != run.stillFailed {
+			t.Errorf("%s run left %+v in the frontier, want %d failed", run.name, last.Frontier, run.stillFailed)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package crawler
+
+import (
+	"fmt"
+	"time"
+)
+
+// limitReached names the limit that should stop the crawl, or returns ""
+// to keep going.
+func (c *Crawler) limitReached() string {
+	if c.cfg.MaxDuration > 0 && time.Since(c.crawlStart) >= c.cfg.MaxDuration {
+		return fmt.Sprintf("MAX_DURATION=%s", c.cfg.MaxDuration)
+	}
+	if c.cfg.MaxPages > 0 && c.pagesStarted.Load() >= int64(c.cfg.MaxPages) {
+		return fmt.Sprintf("MAX_PAGES=%d", c.cfg.MaxPages)
+	}
+	return ""
+}
+
+// reservePage claims a MaxPages slot before a fetch, returning false once
+// they are all taken.
+func (c *Crawler) reservePage() bool {
+	for {
+		n := c.pagesStarted.Load()
+		if c.cfg.MaxPages > 0 && n >= int64(c.cfg.MaxPages) {
+			return false
+		}
+		if c.pagesStarted.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// releasePage gives a slot back when a fetch didn't use up a page, e.g.
+// when the URL was re-queued.
+func (c *Crawler) releasePage() {
+	c.pagesStarted.Add(-1)
+}
@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The kinds of contact in contacts.csv
+const (
+	contactEmail = "email"
+	contactPhone = "phone"
+)
+
+// malformedSamples is how many malformed hrefs a run writes to
+// malformed_links.txt; the rest are only counted
+const malformedSamples = 200
+
+// contactsHeader is the header row of contacts.csv
+var contactsHeader = []string{"page", "kind", "value"}
+
+// contact is a mailto: address or a tel: number a page links to
+type contact struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// linkScheme sorts an href that isn't a link to a page: it returns the
+// lowercased scheme of one that parses with a scheme other than http or
+// https, like "mailto" or "javascript", and malformed for one that doesn't
+// parse. Both are empty for a link to crawl.
+func linkScheme(raw string) (scheme string, u *url.URL, malformed bool) {
+	raw = cleanHref(raw)
+	if raw == "" {
+		return "", nil, false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", nil, true
+	}
+	if s := strings.ToLower(u.Scheme); s != "" && s != "http" && s != "https" {
+		return s, u, false
+	}
+	return "", nil, false
+}
+
+// isWebURL reports whether a normalized URL is http:// or https://, the
+// only ones the frontier takes
+func isWebURL(normalized string) bool {
+	return strings.HasPrefix(normalized, "http://") || strings.HasPrefix(normalized, "https://")
+}
+
+// contactsOf returns the addresses of a mailto: URL, or the number of a
+// tel: one, without the query a mailto: may carry a subject in
+func contactsOf(scheme string, u *url.URL) []contact {
+	value := u.Opaque
+	if value == "" {
+		value = strings.TrimPrefix(u.Path, "//")
+	}
+	if v, err := url.PathUnescape(value); err == nil {
+		value = v
+	}
+	var contacts []contact
+	switch scheme {
+	case "mailto":
+		for _, addr := range strings.Split(value, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				contacts = append(contacts, contact{Kind: contactEmail, Value: strings.ToLower(addr)})
+			}
+		}
+	case "tel":
+		if number := strings.TrimSpace(value); number != "" {
+			contacts = append(contacts, contact{Kind: contactPhone, Value: number})
+		}
+	}
+	return contacts
+}
+
+// recordMalformed counts the hrefs of a page that didn't parse and, for
+// the first malformedSamples of the run, records them in
+// malformed_links.txt, quoted, with the page they were found on
+func (c *Crawler) recordMalformed(page string, hrefs []string) {
+	for _, href := range hrefs {
+		n := c.malformedLinks.Add(1)
+		c.log().Debug("malformed link", "href", href, "referrer", page)
+		if n <= malformedSamples && c.malformedFile != nil {
+			_, _ = c.malformedFile.add(strconv.Quote(href) + "\t" + page)
+		}
+	}
+}
+
+// logMalformed logs how many malformed hrefs the run came across
+func (c *Crawler) logMalformed() {
+	if n := c.malformedLinks.Load(); n > 0 {
+		c.log().Info("malformed links", "count", n, "file", c.malformedFileName)
+	}
+}
+
+// writeContactsReport writes contacts.csv from the pages.jsonl records, a
+// row per address and number of each page's latest fetch, returning how
+// many rows there are
+func (c *Crawler) writeContactsReport(path string) (int, error) {
+	last, err := c.lastPageRecords()
+	if err != nil {
+		return 0, err
+	}
+	pages := make([]string, 0, len(last))
+	for u, p := range last {
+		if len(p.Contacts) > 0 {
+			pages = append(pages, u)
+		}
+	}
+	sort.Strings(pages)
+	var rows [][]string
+	for _, u := range pages {
+		for _, ct := range last[u].Contacts {
+			rows = append(rows, []string{u, ct.Kind, ct.Value})
+		}
+	}
+	if err := writeCSVFile(path, contactsHeader, rows); err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
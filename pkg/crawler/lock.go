@@ -0,0 +1,140 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned when another crawl holds the project folder
+var ErrLocked = errors.New("project folder is in use by another crawl")
+
+// lockTakeoverTimeout is how long a takeover of crawler.lock may last
+// before it is taken for one cut short by a crash
+const lockTakeoverTimeout = 10 * time.Second
+
+// lockInfo is what crawler.lock holds: who took the lock and when
+type lockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// lock takes crawler.lock in the project folder, so two crawls can't write
+// the same state files. A lock left behind by a process that is no longer
+// running on this host is taken over; one whose owner may still be alive
+// is only taken over with ForceLock.
+//
+// The lock is written to a file of its own first and linked into place,
+// so no other crawl ever sees it empty, and only one crawl at a time may
+// take over a lock, under crawler.lock's takeover file.
+func (c *Crawler) lock() error {
+	host, _ := os.Hostname()
+	me := lockInfo{PID: os.Getpid(), Host: host, StartedAt: time.Now().UTC()}
+	data, err := json.Marshal(me)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.lockFileName), filepath.Base(c.lockFileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Link(tmp.Name(), c.lockFileName); !os.IsExist(err) {
+		return err
+	}
+	release, err := c.lockTakeover()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	held, readable, err := c.readLock()
+	if err != nil {
+		return err
+	}
+	stale := !readable || (held.Host == host && held.PID != me.PID && !processAlive(held.PID))
+	switch {
+	case stale:
+		c.log().Warn("removing stale lock", "file", c.lockFileName)
+	case c.cfg.ForceLock:
+		c.log().Warn("taking over lock", "file", c.lockFileName, "pid", held.PID, "host", held.Host)
+	default:
+		return lockedError(c.lockFileName, held)
+	}
+	if err := os.Remove(c.lockFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// A crawl starting now may take the lock before it is linked back
+	if err := os.Link(tmp.Name(), c.lockFileName); os.IsExist(err) {
+		if held, _, err := c.readLock(); err == nil {
+			return lockedError(c.lockFileName, held)
+		}
+		return fmt.Errorf("%w: %s was taken while taking it over", ErrLocked, c.lockFileName)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// readLock reads crawler.lock, reporting whether it holds a lock at all;
+// one that doesn't is most likely one cut short by a crash. A missing lock
+// is read as an unreadable one.
+func (c *Crawler) readLock() (held lockInfo, readable bool, err error) {
+	raw, err := os.ReadFile(c.lockFileName)
+	if os.IsNotExist(err) {
+		return held, false, nil
+	}
+	if err != nil {
+		return held, false, err
+	}
+	return held, json.Unmarshal(raw, &held) == nil && held.PID > 0, nil
+}
+
+// lockTakeover takes the file that lets one crawl at a time replace
+// crawler.lock, so a crawl can't remove a lock another has just taken over
+// for one it judged stale. It returns the function that gives it up.
+func (c *Crawler) lockTakeover() (release func(), err error) {
+	name := c.lockFileName + ".tmp-takeover"
+	for tries := 0; ; tries++ {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		fi, statErr := os.Stat(name)
+		if tries > 0 || statErr != nil || time.Since(fi.ModTime()) < lockTakeoverTimeout {
+			return nil, fmt.Errorf("%w: %s is being taken over", ErrLocked, c.lockFileName)
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// lockedError reports that the lock in file is held
+func lockedError(file string, held lockInfo) error {
+	return fmt.Errorf("%w: %s is held by process %d on %s since %s",
+		ErrLocked, file, held.PID, held.Host, held.StartedAt.Format(time.RFC3339))
+}
+
+// unlock removes the lock taken by lock
+func (c *Crawler) unlock() {
+	if err := os.Remove(c.lockFileName); err != nil && !os.IsNotExist(err) {
+		c.log().Error("removing lock", "error", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package crawler
+
+// processAlive can't tell here, so a lock's owner is assumed to be running
+func processAlive(pid int) bool {
+	return true
+}
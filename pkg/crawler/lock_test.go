@@ -0,0 +1,209 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	host, _ := os.Hostname()
+	// No process has the highest ID there can be
+	const deadPID = 1<<31 - 1
+	held := func(pid int, host string) string {
+		b, _ := json.Marshal(lockInfo{PID: pid, Host: host, StartedAt: time.Now().UTC()})
+		return string(b) + "\n"
+	}
+	tests := []struct {
+		name     string
+		existing string
+		force    bool
+		// unix needs processAlive to tell a dead owner
+		unix   bool
+		locked bool
+	}{
+		{name: "no lock"},
+		{name: "live owner", existing: held(os.Getppid(), host), locked: true},
+		{name: "live owner, forced", existing: held(os.Getppid(), host), force: true},
+		{name: "owner on another host", existing: held(deadPID, "elsewhere.example"), locked: true},
+		{name: "owner on another host, forced", existing: held(deadPID, "elsewhere.example"), force: true},
+		{name: "dead owner", existing: held(deadPID, host), unix: true},
+		{name: "cut short", existing: `{"pid": 12`},
+		{name: "empty", existing: " "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.unix && processAlive(deadPID) {
+				t.Skip("can't tell whether a process is running here")
+			}
+			c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.ForceLock = tt.force })
+			if tt.existing != "" {
+				if err := os.WriteFile(c.lockFileName, []byte(tt.existing), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := c.lock()
+			if errors.Is(err, ErrLocked) != tt.locked || (err != nil && !tt.locked) {
+				t.Fatalf("lock() = %v, want locked %v", err, tt.locked)
+			}
+			raw, _ := os.ReadFile(c.lockFileName)
+			var info lockInfo
+			json.Unmarshal(raw, &info)
+			if mine := info.PID == os.Getpid(); mine == tt.locked {
+				t.Errorf("lock file holds %q after lock() = %v", raw, err)
+			}
+			if tt.locked {
+				return
+			}
+			c.unlock()
+			if _, err := os.Stat(c.lockFileName); !os.IsNotExist(err) {
+				t.Errorf("lock file left after unlock: %v", err)
+			}
+		})
+	}
+}
+
+// TestLockConcurrent has crawls in one folder take the lock at once, with
+// the folder free and with a stale lock left in it: exactly one gets it
+func TestLockConcurrent(t *testing.T) {
+	const crawls, rounds = 16, 200
+	tests := []struct{ name, existing string }{
+		{name: "no lock"},
+		{name: "stale lock", existing: `{"pid": 12`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			var crawlers []*Crawler
+			for range crawls {
+				crawlers = append(crawlers, newTestCrawler(t, "https://example.com/", func(cfg *Config) {
+					cfg.ProjectFolder = folder
+				}))
+			}
+			if tt.existing != "" {
+				if err := os.WriteFile(crawlers[0].lockFileName, []byte(tt.existing), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The race is narrow, so it is run a few times over
+			for round := range rounds {
+				if round > 0 {
+					os.Remove(crawlers[0].lockFileName)
+					if tt.existing != "" {
+						os.WriteFile(crawlers[0].lockFileName, []byte(tt.existing), 0o644)
+					}
+				}
+				errs := make(chan error, crawls)
+				start := make(chan struct{})
+				for _, c := range crawlers {
+					go func() {
+						<-start
+						errs <- c.lock()
+					}()
+				}
+				close(start)
+				won := 0
+				for range crawls {
+					switch err := <-errs; {
+					case err == nil:
+						won++
+					case !errors.Is(err, ErrLocked):
+						t.Errorf("lock() = %v, want ErrLocked", err)
+					}
+				}
+				if won != 1 {
+					t.Fatalf("round %d: %d crawls took the lock, want 1", round, won)
+				}
+			}
+			raw, _ := os.ReadFile(crawlers[0].lockFileName)
+			var info lockInfo
+			if err := json.Unmarshal(raw, &info); err != nil || info.PID != os.Getpid() {
+				t.Errorf("lock file holds %q", raw)
+			}
+			entries, _ := os.ReadDir(folder)
+			for _, e := range entries {
+				if name := e.Name(); name != "crawler.lock" && strings.HasPrefix(name, "crawler.lock") {
+					t.Errorf("%s left in the folder", name)
+				}
+			}
+		})
+	}
+}
+
+// TestLockTwoCrawlers starts a second crawl in the folder of one that is
+// running: it fails at once, and the first removes its lock however it
+// ends
+func TestLockTwoCrawlers(t *testing.T) {
+	tests := []struct {
+		name string
+		// cancel stops the first crawl, as a signal does, instead of
+		// letting it finish
+		cancel bool
+	}{
+		{name: "finished"},
+		{name: "cancelled", cancel: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started, release := make(chan struct{}, 1), make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+				w.Header().Set("Content-Type", "text/html")
+				io.WriteString(w, "<html><body>page</body></html>")
+			}))
+			defer srv.Close()
+
+			folder := t.TempDir()
+			newCrawler := func() *Crawler {
+				return newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+					cfg.ProjectFolder = folder
+					cfg.Workers = 1
+					cfg.RespectRobots = false
+					cfg.ShutdownTimeout = 100 * time.Millisecond
+				})
+			}
+			first := newCrawler()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- first.Run(ctx) }()
+			<-started
+
+			begun := time.Now()
+			err := newCrawler().Run(context.Background())
+			if !errors.Is(err, ErrLocked) {
+				t.Fatalf("second crawl: %v, want ErrLocked", err)
+			}
+			if elapsed := time.Since(begun); elapsed > 5*time.Second {
+				t.Errorf("second crawl took %s to fail", elapsed)
+			}
+
+			if tt.cancel {
+				cancel()
+			} else {
+				close(release)
+			}
+			if err := <-done; err != nil && !tt.cancel {
+				t.Fatalf("first crawl: %v", err)
+			}
+			if _, err := os.Stat(first.lockFileName); !os.IsNotExist(err) {
+				t.Errorf("lock file left after the crawl: %v", err)
+			}
+		})
+	}
+}
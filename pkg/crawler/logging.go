@@ -0,0 +1,48 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// withLogger returns ctx carrying log, so everything done for one job logs
+// with the job's worker and URL attached
+func withLogger(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// logFrom returns the logger ctx carries, or the crawler's
+func (c *Crawler) logFrom(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+	return c.log()
+}
+
+// log returns the logger for messages that aren't about one job: the run's,
+// with its ID attached, once Run has started
+func (c *Crawler) log() *slog.Logger {
+	if log := c.runLog.Load(); log != nil {
+		return log
+	}
+	return c.baseLog()
+}
+
+// baseLog returns Logger, or the default logger when it is nil
+func (c *Crawler) baseLog() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
+// pageLevel is the level of the messages about pages going well, which
+// Quiet turns down to debug
+func (c *Crawler) pageLevel() slog.Level {
+	if c.cfg.Quiet {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
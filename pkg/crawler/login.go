@@ -0,0 +1,132 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LoginConfig describes a login form to submit before crawling, so the
+// crawl carries the session cookies it sets
+type LoginConfig struct {
+	// URL is the page with the login form
+	URL string
+	// Method is how the form is sent, POST unless set
+	Method string
+	// Fields are the form values to fill in, by input name. Hidden inputs
+	// of the form, such as CSRF tokens, are sent along as the page has them.
+	Fields map[string]string
+	// SuccessStatus, if set, is the status the page after logging in must
+	// have; otherwise any 2xx will do. SuccessSelector, if set, must match
+	// something on that page.
+	SuccessStatus   int
+	SuccessSelector string
+}
+
+// login fetches the login page, submits its form with the configured
+// fields and checks that it worked. The cookies it gets end up in the jar.
+func (c *Crawler) login(ctx context.Context) error {
+	l := c.cfg.Login
+	page, err := c.loginRequest(ctx, "GET", l.URL, nil)
+	if err != nil {
+		return fmt.Errorf("fetching the login page: %w", err)
+	}
+	defer page.Body.Close()
+	if page.StatusCode/100 != 2 {
+		return fmt.Errorf("fetching the login page: bad status code: %d", page.StatusCode)
+	}
+	doc, err := goquery.NewDocumentFromReader(page.Body)
+	if err != nil {
+		return fmt.Errorf("reading the login page: %w", err)
+	}
+	form := loginForm(doc, l.Fields)
+	action := page.Request.URL
+	values := url.Values{}
+	if form != nil {
+		if a, ok := form.Attr("action"); ok && strings.TrimSpace(a) != "" {
+			if action, err = page.Request.URL.Parse(strings.TrimSpace(a)); err != nil {
+				return fmt.Errorf("login form action %q: %w", a, err)
+			}
+		}
+		form.Find(`input[type="hidden" i]`).Each(func(_ int, in *goquery.Selection) {
+			if name, ok := in.Attr("name"); ok && name != "" {
+				values.Set(name, in.AttrOr("value", ""))
+			}
+		})
+	}
+	for name, value := range l.Fields {
+		values.Set(name, value)
+	}
+
+	method := strings.ToUpper(l.Method)
+	if method == "" {
+		method = "POST"
+	}
+	var body io.Reader
+	target := *action
+	if method == "GET" {
+		target.RawQuery = values.Encode()
+	} else {
+		body = strings.NewReader(values.Encode())
+	}
+	resp, err := c.loginRequest(ctx, method, target.String(), body)
+	if err != nil {
+		return fmt.Errorf("submitting the login form: %w", err)
+	}
+	defer resp.Body.Close()
+	if l.SuccessStatus != 0 && resp.StatusCode != l.SuccessStatus {
+		return fmt.Errorf("login failed: %s answered %d, want %d", resp.Request.URL, resp.StatusCode, l.SuccessStatus)
+	}
+	if l.SuccessStatus == 0 && resp.StatusCode/100 != 2 {
+		return fmt.Errorf("login failed: %s answered %d", resp.Request.URL, resp.StatusCode)
+	}
+	if l.SuccessSelector != "" {
+		after, err := goquery.NewDocumentFromReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("reading the page after logging in: %w", err)
+		}
+		if after.Find(l.SuccessSelector).Length() == 0 {
+			return fmt.Errorf("login failed: nothing on %s matches %q", resp.Request.URL, l.SuccessSelector)
+		}
+	}
+	c.log().Info("logged in", "url", resp.Request.URL.String(), "status", resp.StatusCode)
+	return nil
+}
+
+func (c *Crawler) loginRequest(ctx context.Context, method, target string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent(ctx))
+	c.setRequestHeaders(req)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return c.httpClient.Do(req)
+}
+
+// loginForm picks the form that has an input for one of the fields, or
+// the page's only form
+func loginForm(doc *goquery.Document, fields map[string]string) *goquery.Selection {
+	forms := doc.Find("form")
+	var found *goquery.Selection
+	forms.EachWithBreak(func(_ int, f *goquery.Selection) bool {
+		for name := range fields {
+			if f.Find(`[name="`+strings.ReplaceAll(name, `"`, `\"`)+`"]`).Length() > 0 {
+				found = f
+				return false
+			}
+		}
+		return true
+	})
+	if found == nil && forms.Length() == 1 {
+		found = forms
+	}
+	return found
+}
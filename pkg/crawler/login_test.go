@@ -0,0 +1,163 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// loginSite is a site behind a minimal session login: /login hands out a
+// CSRF token tied to a cookie, a POST with the token and the right
+// password sets the session cookie and redirects to /account, and every
+// other page wants that session
+func loginSite(t *testing.T) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var served []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := r.Cookie("session")
+		loggedIn := session != nil && session.Value == "s3cret-session"
+		switch {
+		case r.URL.Path == "/login" && r.Method == "GET":
+			http.SetCookie(w, &http.Cookie{Name: "csrf", Value: "token-42", Path: "/"})
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, `<html><body>
+				<form action="/search"><input name="q"></form>
+				<form method="post" action="/login">
+					<input type="hidden" name="csrf_token" value="token-42">
+					<input name="username"><input type="password" name="password">
+				</form></body></html>`)
+			return
+		case r.URL.Path == "/login" && r.Method == "POST":
+			csrf, _ := r.Cookie("csrf")
+			if csrf == nil || r.PostFormValue("csrf_token") != csrf.Value {
+				http.Error(w, "bad csrf token", http.StatusForbidden)
+				return
+			}
+			if r.PostFormValue("username") != "ada" || r.PostFormValue("password") != "hunter2" {
+				w.Header().Set("Content-Type", "text/html")
+				io.WriteString(w, `<html><body><p class="error">Wrong password</p></body></html>`)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret-session", Path: "/"})
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		case !loggedIn:
+			http.Error(w, "log in first", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		served = append(served, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/account":
+			io.WriteString(w, `<html><body><div id="welcome">Hello, ada</div></body></html>`)
+		case "/":
+			io.WriteString(w, `<html><body><a href="/members">members</a></body></html>`)
+		default:
+			io.WriteString(w, `<html><body>members only</body></html>`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), served...)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	good := map[string]string{"username": "ada", "password": "hunter2"}
+	tests := []struct {
+		name  string
+		login LoginConfig
+		// err is part of the error Run fails with, if it should
+		err string
+	}{
+		{name: "logged in", login: LoginConfig{Fields: good}},
+		{name: "success status", login: LoginConfig{Fields: good, SuccessStatus: 200}},
+		{name: "success selector", login: LoginConfig{Fields: good, SuccessSelector: "#welcome"}},
+		{name: "wrong password", login: LoginConfig{Fields: map[string]string{"username": "ada", "password": "nope"}, SuccessSelector: "#welcome"}, err: `login failed: nothing on`},
+		{name: "selector missing after logging in", login: LoginConfig{Fields: good, SuccessSelector: ".dashboard"}, err: `matches ".dashboard"`},
+		{name: "unexpected status", login: LoginConfig{Fields: good, SuccessStatus: 201}, err: "answered 200, want 201"},
+		{name: "form sent the wrong way", login: LoginConfig{Fields: good, Method: "GET", SuccessSelector: "#welcome"}, err: `matches "#welcome"`},
+		{name: "no login page", login: LoginConfig{URL: "/nowhere", Fields: good}, err: "fetching the login page: bad status code: 401"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, served := loginSite(t)
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.MaxAttempts = 1
+				cfg.Login = tt.login
+				cfg.Login.URL = srv.URL + "/login"
+				if tt.login.URL != "" {
+					cfg.Login.URL = srv.URL + tt.login.URL
+				}
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			err := c.Run(ctx)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Run = %v, want an error with %q", err, tt.err)
+				}
+				// Nothing is crawled after a failed login
+				for _, p := range served() {
+					if p != "/account" {
+						t.Errorf("crawled %s", p)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if got, want := strings.Join(served(), " "), "/account / /members"; got != want {
+				t.Errorf("served %s, want %s", got, want)
+			}
+			if run := c.LastRun(); run.Failed != 0 {
+				t.Errorf("%d URLs failed", run.Failed)
+			}
+		})
+	}
+}
+
+func TestLoginForm(t *testing.T) {
+	tests := []struct {
+		name   string
+		page   string
+		fields map[string]string
+		want   string
+	}{
+		{"only form", `<form id="a"><input name="user"></form>`, map[string]string{"password": "x"}, "a"},
+		{"form with the field", `<form id="a"><input name="q"></form><form id="b"><input name="password"></form>`, map[string]string{"password": "x"}, "b"},
+		{"field with a quote", `<form id="a"></form><form id="b"><input name='pass"word'></form>`, map[string]string{`pass"word`: "x"}, "b"},
+		{"no form with the field", `<form id="a"></form><form id="b"></form>`, map[string]string{"password": "x"}, ""},
+		{"no form", `<p>nothing</p>`, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			form := loginForm(doc, tt.fields)
+			got := ""
+			if form != nil {
+				got = form.AttrOr("id", "?")
+			}
+			if got != tt.want {
+				t.Errorf("form = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package crawler
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestEntry maps a crawled URL to the file its body was saved in,
+// relative to the downloaded files folder. Size is the byte count written,
+// used on startup to spot truncated files; it is unknown for migrated pages.
+type manifestEntry struct {
+	URL         string `json:"url"`
+	File        string `json:"file"`
+	Size        int64  `json:"size,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+
+	// RawFile is the body as received, before Content-Encoding was undone
+	RawFile         string `json:"raw_file,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+
+	// Charset is set when the page was transcoded to UTF-8 from something
+	// else; OriginalFile then holds the untranscoded bytes, if kept
+	Charset      string `json:"charset,omitempty"`
+	OriginalFile string `json:"original_file,omitempty"`
+
+	// ETag and LastModified are the validators sent back on -recrawl;
+	// CheckedAt is when the server last confirmed or replaced the file
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	CheckedAt    time.Time `json:"checked_at,omitzero"`
+
+	// SHA256 is the hash of the saved body. DuplicateOf names the URL whose
+	// file this entry shares because the bodies were identical.
+	SHA256      string `json:"sha256,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+
+	// Codec is set when File is stored compressed, as File plus the codec's
+	// suffix; StoredSize is then its size on disk and Size the body's
+	Codec      string `json:"codec,omitempty"`
+	StoredSize int64  `json:"stored_size,omitempty"`
+
+	// Rendered is set when File is the DOM headless Chrome rendered
+	Rendered bool `json:"rendered,omitempty"`
+}
+
+// manifest is the URL→filename index, stored as JSON lines. A URL saved more
+// than once has several lines and the last one wins. byHash maps a body hash
+// to the first URL saved with its own copy of that body.
+type manifest struct {
+	mu      sync.Mutex
+	entries map[string]manifestEntry
+	byHash  map[string]string
+	// files maps the fileKey of each file name to the URL it belongs to
+	files map[string]string
+	file  *os.File
+}
+
+func openManifest(path string) (*manifest, error) {
+	m := &manifest{entries: make(map[string]manifestEntry), byHash: make(map[string]string), files: make(map[string]string)}
+	if err := m.load(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m.file = f
+	return m, nil
+}
+
+func (m *manifest) load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e manifestEntry
+		// A line cut short by a crash is skipped rather than fatal
+		if err := json.Unmarshal([]byte(line), &e); err != nil || e.URL == "" {
+			continue
+		}
+		m.add(e)
+	}
+	return scanner.Err()
+}
+
+// record adds e to the manifest. Entries are written straight through since
+// each one describes a file that is already on disk.
+func (m *manifest) record(e manifestEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	m.add(e)
+	return nil
+}
+
+// add indexes e; the caller holds m.mu or owns m exclusively
+func (m *manifest) add(e manifestEntry) {
+	m.entries[e.URL] = e
+	if key := fileKey(e.File); e.File != "" && m.files[key] == "" {
+		m.files[key] = cmp.Or(e.DuplicateOf, e.URL)
+	}
+	if e.SHA256 != "" && e.DuplicateOf == "" {
+		if _, ok := m.byHash[e.SHA256]; !ok {
+			m.byHash[e.SHA256] = e.URL
+		}
+	}
+}
+
+func (m *manifest) lookup(url string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[url]
+	return e, ok
+}
+
+// canonical returns the entry that owns the saved copy of a body with the
+// given hash, if there is one other than url itself
+func (m *manifest) canonical(hash, url string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	owner, ok := m.byHash[hash]
+	if !ok || owner == url {
+		return manifestEntry{}, false
+	}
+	e, ok := m.entries[owner]
+	return e, ok && e.SHA256 == hash
+}
+
+func (m *manifest) close() error {
+	return m.file.Close()
+}
+
+// pageFileName names the file a page is saved in after a short hash of its
+// URL, which stays the same no matter how the found file is reordered.
+func pageFileName(url, ext string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8]) + ext
+}
+
+// migrateNumericFiles builds a manifest for pages saved by older versions as
+// "<index>.html.html", where index was the URL's line in the found file. It
+// has to run before the found file is deduplicated, which shifts lines.
+func (c *Crawler) migrateNumericFiles() error {
+	if _, err := os.Stat(c.manifestFileName); err == nil {
+		return nil
+	}
+	lines, err := readLines(c.foundUrlFileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []manifestEntry
+	for i, line := range lines {
+		name := fmt.Sprintf("%d.html.html", i)
+		if _, err := os.Stat(filepath.Join(c.downloadedFilesFolderName, name)); err != nil {
+			continue
+		}
+		url := lineKey(line)
+		if normalized, err := c.normalizeURL(url); err == nil {
+			url = normalized
+		}
+		entries = append(entries, manifestEntry{URL: url, File: name})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	m, err := openManifest(c.manifestFileName)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+	for _, e := range entries {
+		if err := m.record(e); err != nil {
+			return err
+		}
+	}
+	c.log().Info("built manifest for previously saved pages", "file", c.manifestFileName, "pages", len(entries))
+	return nil
+}
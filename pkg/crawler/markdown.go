@@ -0,0 +1,478 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// markdownSubfolder sits inside the downloaded files folder, next to
+// assets/
+const markdownSubfolder = "markdown"
+
+// markdownPath lays a page's Markdown copy out under markdown/ by its URL
+// path: /docs/ becomes docs/index.md and /docs/a.html docs/a.md. Other
+// hosts than the BaseURL one, query strings and names that aren't valid
+// files are handled as for assets.
+func (c *Crawler) markdownPath(u *url.URL) string {
+	p := path.Clean("/" + u.EscapedPath())
+	if strings.HasSuffix(u.Path, "/") || p == "/" {
+		p = path.Join(p, "index")
+	} else {
+		p = strings.TrimSuffix(p, path.Ext(p))
+	}
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		p += "-" + hex.EncodeToString(sum[:4])
+	}
+	return path.Join(markdownSubfolder, c.hostFolder(u), sanitizeFilename(p+".md"))
+}
+
+// writeMarkdown converts every saved page to Markdown under markdown/.
+// Links to crawled pages point at their .md files and links to saved
+// assets at the files, relative to the page so the folder can be moved;
+// the rest point at the live site. With ExtractText set to "main" only the
+// main content is converted.
+func (c *Crawler) writeMarkdown() error {
+	finals, err := c.loadFinalURLs()
+	if err != nil {
+		return err
+	}
+	c.pageManifest.mu.Lock()
+	var entries []manifestEntry
+	for _, e := range c.pageManifest.entries {
+		entries = append(entries, e)
+	}
+	c.pageManifest.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+
+	// A file saved under several URLs, after a redirect, gets one copy.
+	// Pages whose paths map to the same name, like / and /index.html, get
+	// the name with a short hash of their URL before the extension, as
+	// claimFile does for assets.
+	mdFiles := make(map[string]string)
+	owners := make(map[string]string)
+	var pages []manifestEntry
+	for _, e := range entries {
+		if !isHTMLType(e.ContentType) || e.DuplicateOf != "" || mdFiles[e.File] != "" {
+			continue
+		}
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			continue
+		}
+		name := c.markdownPath(u)
+		md := name
+		for n := 1; owners[fileKey(md)] != ""; n++ {
+			suffix := "-" + shortHash(e.URL)
+			if n > 1 {
+				suffix += fmt.Sprintf("-%d", n)
+			}
+			md = withSuffix(name, suffix)
+		}
+		owners[fileKey(md)] = e.File
+		mdFiles[e.File] = md
+		pages = append(pages, e)
+	}
+
+	converted := 0
+	for _, e := range pages {
+		pageURL := e.URL
+		if final, ok := finals[e.URL]; ok {
+			pageURL = final
+		}
+		page, err := url.Parse(pageURL)
+		if err != nil {
+			continue
+		}
+		md := mdFiles[e.File]
+		dir := path.Dir(md)
+		// Within markdown/ the links are relative to the .md files alone
+		mdDir := path.Dir(strings.TrimPrefix(md, markdownSubfolder+"/"))
+		target := func(u *url.URL) string {
+			key := u.String()
+			if normalized, err := c.normalizeURL(key); err == nil {
+				key = normalized
+			}
+			if e, ok := c.pageManifest.lookup(key); ok {
+				if f, ok := mdFiles[e.File]; ok {
+					return relativePath(mdDir, strings.TrimPrefix(f, markdownSubfolder+"/"))
+				}
+				if !isHTMLType(e.ContentType) {
+					return relativePath(dir, e.File)
+				}
+			}
+			return u.String()
+		}
+		f, err := c.Storage.LoadPage(e.File)
+		if err != nil {
+			c.log().Warn("skipped page", "file", e.File, "error", err)
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(f)
+		f.Close()
+		if err != nil {
+			c.log().Warn("skipped page", "file", e.File, "error", err)
+			continue
+		}
+		// Links resolve against the <base href>, itself relative to the page
+		if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+			if ref, err := url.Parse(strings.TrimSpace(href)); err == nil {
+				page = page.ResolveReference(ref)
+			}
+		}
+		body := doc.Find("body").First()
+		if body.Length() == 0 {
+			body = doc.Selection
+		}
+		body = body.Clone()
+		body.Find(nonText).Remove()
+		if c.cfg.ExtractText == textMain {
+			body = mainContent(body)
+		}
+		m := &mdConverter{page: page, target: target}
+		out := m.document(body)
+		if _, err := c.Storage.SavePage(md, PageMeta{URL: e.URL, ContentType: "text/markdown"}, strings.NewReader(out)); err != nil {
+			return err
+		}
+		converted++
+	}
+	c.log().Info("converted pages to markdown", "pages", converted)
+	return nil
+}
+
+// mdConverter turns HTML into Markdown. target maps a resolved link or
+// image URL to what the Markdown should point at.
+type mdConverter struct {
+	page   *url.URL
+	target func(*url.URL) string
+}
+
+// document converts the nodes of body to a Markdown file
+func (m *mdConverter) document(body *goquery.Selection) string {
+	var blocks []string
+	for _, n := range body.Nodes {
+		if b := m.container(n, false); b != "" {
+			blocks = append(blocks, b)
+		}
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+// mdBlocks are the elements that make blocks of their own; anything else
+// is inline
+var mdBlocks = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true,
+	"dd": true, "details": true, "div": true, "dl": true, "dt": true, "fieldset": true,
+	"figcaption": true, "figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"summary": true, "table": true, "ul": true,
+}
+
+// container converts the children of n, one Markdown block per block
+// element and per run of inline content. Tight containers, list items,
+// put their blocks on consecutive lines.
+func (m *mdConverter) container(n *html.Node, tight bool) string {
+	var blocks []string
+	var inline strings.Builder
+	flush := func() {
+		if text := tidyInline(inline.String()); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && mdBlocks[child.Data] {
+			flush()
+			if b := m.block(child); b != "" {
+				blocks = append(blocks, b)
+			}
+			continue
+		}
+		m.inline(&inline, child)
+	}
+	flush()
+	sep := "\n\n"
+	if tight {
+		sep = "\n"
+	}
+	return strings.Join(blocks, sep)
+}
+
+func (m *mdConverter) block(n *html.Node) string {
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		if text := m.inlineText(n); text != "" {
+			return strings.Repeat("#", level) + " " + text
+		}
+		return ""
+	case "p", "dt", "summary", "figcaption":
+		return m.inlineText(n)
+	case "ul", "ol":
+		return m.list(n)
+	case "pre":
+		return fencedCode(n)
+	case "blockquote":
+		inner := m.container(n, false)
+		if inner == "" {
+			return ""
+		}
+		lines := strings.Split(inner, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return strings.Join(lines, "\n")
+	case "table":
+		return m.table(n)
+	case "hr":
+		return "---"
+	}
+	return m.container(n, false)
+}
+
+// list converts a ul or ol, indenting what follows each marker so nested
+// lists and later paragraphs stay inside their item
+func (m *mdConverter) list(n *html.Node) string {
+	ordered := n.Data == "ol"
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil && ordered {
+		number = start
+	}
+	var items []string
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		content := m.container(li, true)
+		indent := strings.Repeat(" ", len(marker))
+		lines := strings.Split(content, "\n")
+		for i := 1; i < len(lines); i++ {
+			if lines[i] != "" {
+				lines[i] = indent + lines[i]
+			}
+		}
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+	return strings.Join(items, "\n")
+}
+
+// fencedCode converts a pre block, taking the language from a
+// language-x or lang-x class on it or its code element
+func fencedCode(n *html.Node) string {
+	lang := codeLanguage(n)
+	for child := n.FirstChild; child != nil && lang == ""; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "code" {
+			lang = codeLanguage(child)
+		}
+	}
+	code := strings.TrimRight(strings.TrimPrefix(nodeText(n), "\n"), "\n")
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + code + "\n" + fence
+}
+
+func codeLanguage(n *html.Node) string {
+	for _, class := range strings.Fields(attr(n, "class")) {
+		for _, prefix := range []string{"language-", "lang-"} {
+			if lang, ok := strings.CutPrefix(class, prefix); ok {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// table converts a table to a GFM table with its first row as the header
+func (m *mdConverter) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "tr":
+				var row []string
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						row = append(row, strings.ReplaceAll(m.inlineText(cell), "|", `\|`))
+					}
+				}
+				rows = append(rows, row)
+			case "thead", "tbody", "tfoot":
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		sb.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			sb.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// inlineText converts the content of n as one paragraph
+func (m *mdConverter) inlineText(n *html.Node) string {
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		m.inline(&sb, child)
+	}
+	return tidyInline(sb.String())
+}
+
+func (m *mdConverter) inline(sb *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		sb.WriteString(escapeMarkdown(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	// wrap writes the converted content between the markers, unless
+	// there is none
+	wrap := func(open, close string) {
+		if text := m.inlineText(n); text != "" {
+			sb.WriteString(open + text + close)
+		}
+	}
+	switch n.Data {
+	case "br":
+		sb.WriteString("\\\n")
+	case "strong", "b":
+		wrap("**", "**")
+	case "em", "i":
+		wrap("_", "_")
+	case "del", "s":
+		wrap("~~", "~~")
+	case "code", "kbd", "samp":
+		code := strings.Join(strings.Fields(nodeText(n)), " ")
+		if code == "" {
+			return
+		}
+		tick := "`"
+		for strings.Contains(code, tick) {
+			tick += "`"
+		}
+		if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+			code = " " + code + " "
+		}
+		sb.WriteString(tick + code + tick)
+	case "a":
+		text := m.inlineText(n)
+		href := strings.TrimSpace(attr(n, "href"))
+		if href == "" || text == "" {
+			sb.WriteString(text)
+			return
+		}
+		sb.WriteString("[" + text + "](" + m.link(href) + ")")
+	case "img":
+		src := strings.TrimSpace(attr(n, "src"))
+		if src == "" {
+			return
+		}
+		sb.WriteString("![" + escapeMarkdown(strings.TrimSpace(attr(n, "alt"))) + "](" + m.link(src) + ")")
+	default:
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			m.inline(sb, child)
+		}
+	}
+}
+
+// link resolves a link against the page and maps it; a fragment within
+// the page stays as it is
+func (m *mdConverter) link(raw string) string {
+	if strings.HasPrefix(raw, "#") {
+		return raw
+	}
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u := m.page.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return u.String()
+	}
+	fragment := u.Fragment
+	u.Fragment = ""
+	target := m.target(u)
+	if fragment != "" {
+		target += "#" + fragment
+	}
+	// Markdown link destinations can't hold spaces or unbalanced parens
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(target)
+}
+
+// tidyInline collapses the whitespace of converted inline content, keeping
+// the line breaks of <br>
+func tidyInline(s string) string {
+	lines := strings.Split(s, "\\\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.FieldsFunc(line, unicode.IsSpace), " ")
+	}
+	return strings.Trim(strings.Join(lines, "\\\n"), "\\\n ")
+}
+
+// escapeMarkdown escapes the characters that would otherwise start
+// emphasis, code or links in text
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "`", "\\`", "[", `\[`, "]", `\]`).Replace(s)
+}
+
+// nodeText is all the text under n, as written
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
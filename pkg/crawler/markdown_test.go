@@ -0,0 +1,127 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestMarkdownPath(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	tests := []struct {
+		url, want string
+	}{
+		{"https://example.com/", "markdown/index.md"},
+		{"https://example.com/index.html", "markdown/index.md"},
+		{"https://example.com/docs/", "markdown/docs/index.md"},
+		{"https://example.com/docs/a.html", "markdown/docs/a.md"},
+		{"https://example.com/docs/a", "markdown/docs/a.md"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := c.markdownPath(u); got != tt.want {
+			t.Errorf("markdownPath(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+// TestMarkdownGolden converts the fixture pages and compares them with the
+// golden files next to them; go test -run TestMarkdownGolden -update
+// rewrites those
+func TestMarkdownGolden(t *testing.T) {
+	page, _ := url.Parse("https://example.com/docs/")
+	for _, fixture := range []string{"lists", "code"} {
+		t.Run(fixture, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", fixture+".html"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			doc, err := goquery.NewDocumentFromReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &mdConverter{page: page, target: func(u *url.URL) string { return u.String() }}
+			got := m.document(doc.Find("body"))
+
+			golden := filepath.Join("testdata", fixture+".md")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("markdown differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// TestMarkdownCopies crawls pages whose paths map to the same .md name and
+// one with a <base href>: every page has to get its own copy, and links
+// resolve against the base
+func TestMarkdownCopies(t *testing.T) {
+	pages := map[string]string{
+		"/":            `<html><body><p>home</p><a href="/docs/a.html">a</a> <a href="/index.html">index</a> <a href="/blog/post">post</a></body></html>`,
+		"/index.html":  `<html><body><p>index</p></body></html>`,
+		"/docs/a.html": `<html><body><p>a.html</p><a href="a">a</a></body></html>`,
+		"/docs/a":      `<html><body><p>a</p></body></html>`,
+		"/blog/post":   `<html><head><base href="/docs/"></head><body><a href="a.html">in docs</a> <a href="b">not crawled</a></body></html>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.Markdown = true
+		cfg.MaxPages = len(pages)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// Sorted by URL, / claims index.md and /docs/a docs/a.md first
+	index := withSuffix("markdown/index.md", "-"+shortHash(srv.URL+"/index.html"))
+	docsA := withSuffix("markdown/docs/a.md", "-"+shortHash(srv.URL+"/docs/a.html"))
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"markdown/index.md", []string{"home", "[a](" + strings.TrimPrefix(docsA, "markdown/") + ")", "[index](" + strings.TrimPrefix(index, "markdown/") + ")"}},
+		{index, []string{"index"}},
+		{"markdown/docs/a.md", []string{"a"}},
+		{docsA, []string{"a.html", "[a](../docs/a.md)"}},
+		{"markdown/blog/post.md", []string{"[in docs](../docs/" + strings.TrimPrefix(docsA, "markdown/docs/") + ")", "[not crawled](" + srv.URL + "/docs/b)"}},
+	}
+	for _, tt := range tests {
+		got := readSaved(t, c, tt.file)
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s doesn't have %q:\n%s", tt.file, want, got)
+			}
+		}
+	}
+}
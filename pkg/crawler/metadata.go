@@ -0,0 +1,168 @@
+package crawler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// pageRecord is one line of pages.jsonl, written after every fetch that
+// got a usable response.
+type pageRecord struct {
+	URL      string `json:"url"`
+	FinalURL string `json:"final_url,omitempty"`
+	// DisplayURL is URL with its punycode host in Unicode, if it has one
+	DisplayURL    string    `json:"display_url,omitempty"`
+	Status        int       `json:"status"`
+	ContentType   string    `json:"content_type,omitempty"`
+	ContentLength int64     `json:"content_length"`
+	FetchedAt     time.Time `json:"fetched_at"`
+	DurationMS    int64     `json:"duration_ms"`
+	File          string    `json:"file,omitempty"`
+	Title         string    `json:"title,omitempty"`
+	Links         int       `json:"links"`
+	Assets        int       `json:"assets"`
+	Canonical     string    `json:"canonical,omitempty"`
+	Alternates    []string  `json:"alternates,omitempty"`
+	// Next and Prev are the page's rel="next" and rel="prev", from its
+	// markup or its Link header
+	Next     string `json:"next,omitempty"`
+	Prev     string `json:"prev,omitempty"`
+	NoFollow bool   `json:"nofollow,omitempty"`
+	Depth    int    `json:"depth"`
+	// Order is the page's place in the run's dispatch order
+	Order    int64  `json:"order,omitempty"`
+	Referrer string `json:"referrer,omitempty"`
+	// Source is sourceScript for a page found in an inline script and
+	// sourcePagination for one reached by rel="next", Chain steps from the
+	// first page followed that way
+	Source    string `json:"source,omitempty"`
+	Chain     int    `json:"pagination_chain,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Soft404 is a page that looks like the site's answer for missing pages
+	Soft404 bool `json:"soft_404,omitempty"`
+	// Probed is a page skipped for what a HEAD said about it, without a GET
+	Probed bool `json:"probed,omitempty"`
+	// NonCanonical is a page whose body wasn't kept, with SkipNonCanonical,
+	// because its canonical is another in-scope URL
+	NonCanonical bool `json:"non_canonical,omitempty"`
+	// Rendered is a page saved as headless Chrome rendered it rather than
+	// as it was served
+	Rendered bool `json:"rendered,omitempty"`
+	// Screenshot is the PNG of a rendered page, with Screenshots, and
+	// ScreenshotWidth and ScreenshotHeight its size in pixels
+	Screenshot       string `json:"screenshot,omitempty"`
+	ScreenshotWidth  int    `json:"screenshot_width,omitempty"`
+	ScreenshotHeight int    `json:"screenshot_height,omitempty"`
+	// Language is the page's language tag and LanguageSource where it was
+	// read from: languageAttr, languageHeader or languageText
+	Language       string `json:"language,omitempty"`
+	LanguageSource string `json:"language_source,omitempty"`
+	// MixedContent are the http:// URLs on a page served over HTTPS
+	MixedContent []insecureRef `json:"mixed_content,omitempty"`
+	// LinkSchemes counts the page's links that aren't to pages by scheme,
+	// MalformedLinks those that don't parse; Contacts are only set with
+	// ExtractContacts
+	LinkSchemes    map[string]int `json:"link_schemes,omitempty"`
+	MalformedLinks int            `json:"malformed_links,omitempty"`
+	Contacts       []contact      `json:"contacts,omitempty"`
+	// TextFile and WordCount are only set with ExtractText
+	TextFile  string `json:"text_file,omitempty"`
+	WordCount int    `json:"word_count,omitempty"`
+	pageMetadata
+
+	// Redirects lists the hops before FinalURL; Headers are the final
+	// response's headers, only kept with RecordHeaders
+	Redirects []redirectHop `json:"redirects,omitempty"`
+	Headers   http.Header   `json:"headers,omitempty"`
+}
+
+// pageMetadata is the standard metadata of an HTML page besides its title
+// and canonical URL. Robots joins the robots meta tags; OpenGraph and
+// Twitter hold the og: and twitter: meta tags without their prefix, the
+// first of each name.
+type pageMetadata struct {
+	Description string            `json:"description,omitempty"`
+	Lang        string            `json:"lang,omitempty"`
+	Robots      string            `json:"robots,omitempty"`
+	OpenGraph   map[string]string `json:"og,omitempty"`
+	Twitter     map[string]string `json:"twitter,omitempty"`
+}
+
+// readMetadata reads the metadata of a parsed page
+func readMetadata(doc *goquery.Document) pageMetadata {
+	var m pageMetadata
+	m.Lang, _ = doc.Find("html").First().Attr("lang")
+	m.Lang = strings.TrimSpace(m.Lang)
+	doc.Find("meta[content]").Each(func(i int, s *goquery.Selection) {
+		content, _ := s.Attr("content")
+		content = strings.TrimSpace(content)
+		name, _ := s.Attr("name")
+		if name == "" {
+			// Open Graph uses property, and some sites use it for Twitter
+			name, _ = s.Attr("property")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "description" && m.Description == "":
+			m.Description = content
+		case name == "robots":
+			// Every robots tag counts, so they are all kept
+			if m.Robots != "" {
+				content = m.Robots + ", " + content
+			}
+			m.Robots = content
+		case strings.HasPrefix(name, "og:"):
+			m.OpenGraph = setOnce(m.OpenGraph, strings.TrimPrefix(name, "og:"), content)
+		case strings.HasPrefix(name, "twitter:"):
+			m.Twitter = setOnce(m.Twitter, strings.TrimPrefix(name, "twitter:"), content)
+		}
+	})
+	return m
+}
+
+// setOnce sets key in m, making m if needed, unless it is set already
+func setOnce(m map[string]string, key, value string) map[string]string {
+	if m == nil {
+		m = make(map[string]string)
+	}
+	if _, ok := m[key]; !ok {
+		m[key] = value
+	}
+	return m
+}
+
+// jsonlWriter appends JSON values as lines to a file. The mutex keeps lines
+// from concurrent workers from interleaving.
+type jsonlWriter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openJSONL(path string) (*jsonlWriter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlWriter{file: f}, nil
+}
+
+func (w *jsonlWriter) write(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.file.Write(append(line, '\n'))
+	return err
+}
+
+func (w *jsonlWriter) close() error {
+	return w.file.Close()
+}
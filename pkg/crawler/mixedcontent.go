@@ -0,0 +1,177 @@
+package crawler
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// How browsers treat an http:// reference on an HTTPS page: active content
+// can rewrite the page and is blocked, passive content is only shown and
+// gets a warning, and a link is insecure once followed
+const (
+	mixedActive  = "active"
+	mixedPassive = "passive"
+	mixedLink    = "link"
+)
+
+// mixedContentHeader is the header row of mixed_content.csv
+var mixedContentHeader = []string{"page", "url", "element", "attribute", "kind"}
+
+// insecureRef is an http:// URL on a page served over HTTPS: the element
+// and attribute it was found in, "url()" or "@import" for one in a <style>
+// block, and its kind
+type insecureRef struct {
+	URL       string `json:"url"`
+	Element   string `json:"element"`
+	Attribute string `json:"attribute"`
+	Kind      string `json:"kind"`
+}
+
+// passiveElements load what they refer to only to show it. Everything else
+// that loads a URL into the page is active, as the mixed content spec has
+// it; a <source> is passive in a <picture>, <audio> or <video>.
+var passiveElements = map[string]bool{"img": true, "picture": true, "audio": true, "video": true}
+
+// insecureRefs lists the http:// URLs in the src, href, srcset, poster and
+// style attributes and <style> blocks of a page, each once per element and
+// attribute. CSS images are passive like <img>; @import is active.
+func insecureRefs(doc *goquery.Document, resolve func(string) (*url.URL, bool)) []insecureRef {
+	var refs []insecureRef
+	seen := make(map[insecureRef]bool)
+	add := func(raw, element, attr, kind string) {
+		u, ok := resolve(raw)
+		if !ok || u.Scheme != "http" {
+			return
+		}
+		u.Fragment = ""
+		ref := insecureRef{URL: u.String(), Element: element, Attribute: attr, Kind: kind}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+
+	doc.Find("a[href], area[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		add(href, goquery.NodeName(s), "href", mixedLink)
+	})
+	doc.Find("link[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		rel, _ := s.Attr("rel")
+		kind := mixedLink
+		switch {
+		case hasToken(rel, "stylesheet"), hasToken(rel, "preload"), hasToken(rel, "modulepreload"), hasToken(rel, "manifest"):
+			kind = mixedActive
+		case hasToken(rel, "icon"), hasToken(rel, "apple-touch-icon"):
+			kind = mixedPassive
+		}
+		add(href, "link", "href", kind)
+	})
+	doc.Find("script[src], iframe[src], frame[src], embed[src], track[src], img, source, audio, video, input[src]").Each(func(i int, s *goquery.Selection) {
+		element := goquery.NodeName(s)
+		kind := mixedActive
+		if passiveElements[element] || (element == "source" && s.ParentFiltered("picture, audio, video").Length() > 0) {
+			kind = mixedPassive
+		}
+		for _, attr := range []string{"src", "poster"} {
+			if v, ok := s.Attr(attr); ok {
+				add(v, element, attr, kind)
+			}
+		}
+		if srcset, ok := s.Attr("srcset"); ok {
+			for _, candidate := range parseSrcset(srcset) {
+				add(candidate, element, "srcset", kind)
+			}
+		}
+	})
+	doc.Find("object[data]").Each(func(i int, s *goquery.Selection) {
+		data, _ := s.Attr("data")
+		add(data, "object", "data", mixedActive)
+	})
+
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		style, _ := s.Attr("style")
+		for _, raw := range cssURLs(style) {
+			add(raw.url, goquery.NodeName(s), "style", mixedPassive)
+		}
+	})
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		for _, raw := range cssURLs(s.Text()) {
+			if raw.imported {
+				add(raw.url, "style", "@import", mixedActive)
+			} else {
+				add(raw.url, "style", "url()", mixedPassive)
+			}
+		}
+	})
+	return refs
+}
+
+// cssURL is a URL in CSS as written, and whether it is an @import
+type cssURL struct {
+	url      string
+	imported bool
+}
+
+// cssURLs returns the url() and @import references of css unresolved, as
+// cssReferences finds them
+func cssURLs(css string) []cssURL {
+	var urls []cssURL
+	for _, m := range cssURLPattern.FindAllStringSubmatchIndex(css, -1) {
+		if raw := submatches(css, m); raw != "" && !strings.HasPrefix(raw, "data:") {
+			urls = append(urls, cssURL{url: raw, imported: strings.HasSuffix(strings.TrimRight(css[:m[0]], " \t\r\n"), "@import")})
+		}
+	}
+	for _, m := range cssImportPattern.FindAllStringSubmatchIndex(css, -1) {
+		if raw := submatches(css, m); raw != "" {
+			urls = append(urls, cssURL{url: raw, imported: true})
+		}
+	}
+	return urls
+}
+
+// submatches joins the groups a regexp match found in s, trimmed
+func submatches(s string, m []int) string {
+	var sb strings.Builder
+	for i := 2; i+1 < len(m); i += 2 {
+		if m[i] >= 0 {
+			sb.WriteString(s[m[i]:m[i+1]])
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// writeMixedContentReport writes mixed_content.csv from the pages.jsonl
+// records, a row per insecure reference of each page's latest fetch,
+// returning how many of the rows are active content and how many not
+func (c *Crawler) writeMixedContentReport(path string) (active, other int, err error) {
+	last, err := c.lastPageRecords()
+	if err != nil {
+		return 0, 0, err
+	}
+	pages := make([]string, 0, len(last))
+	for u, p := range last {
+		if len(p.MixedContent) > 0 {
+			pages = append(pages, u)
+		}
+	}
+	sort.Strings(pages)
+	var rows [][]string
+	for _, u := range pages {
+		for _, ref := range last[u].MixedContent {
+			rows = append(rows, []string{u, ref.URL, ref.Element, ref.Attribute, ref.Kind})
+			if ref.Kind == mixedActive {
+				active++
+			} else {
+				other++
+			}
+		}
+	}
+	if err := writeCSVFile(path, mixedContentHeader, rows); err != nil {
+		return 0, 0, err
+	}
+	return active, other, nil
+}
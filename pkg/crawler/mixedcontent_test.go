@@ -0,0 +1,79 @@
+package crawler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInsecureRefs(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	tests := []struct {
+		name string
+		html string
+		want []insecureRef
+	}{
+		{"inline style", `<div style="background: url(http://cdn.example/bg.png) no-repeat"></div>` +
+			`<p style='background-image:url( "http://cdn.example/quoted.png" )'></p>` +
+			`<span style="background: url(https://cdn.example/safe.png), url(data:image/png;base64,AAAA)"></span>`,
+			[]insecureRef{
+				{URL: "http://cdn.example/bg.png", Element: "div", Attribute: "style", Kind: mixedPassive},
+				{URL: "http://cdn.example/quoted.png", Element: "p", Attribute: "style", Kind: mixedPassive},
+			}},
+		{"style block", `<style>@import "http://cdn.example/theme.css"; body { background: url('http://cdn.example/body.png') }</style>`,
+			[]insecureRef{
+				{URL: "http://cdn.example/body.png", Element: "style", Attribute: "url()", Kind: mixedPassive},
+				{URL: "http://cdn.example/theme.css", Element: "style", Attribute: "@import", Kind: mixedActive},
+			}},
+		{"source srcset in picture", `<picture>` +
+			`<source srcset="http://cdn.example/a-1x.webp 1x, https://cdn.example/a-2x.webp 2x, http://cdn.example/a-3x.webp 3x" type="image/webp">` +
+			`<img src="https://cdn.example/a.png" srcset="http://cdn.example/a-480.png 480w, /a-800.png 800w"></picture>`,
+			[]insecureRef{
+				{URL: "http://cdn.example/a-1x.webp", Element: "source", Attribute: "srcset", Kind: mixedPassive},
+				{URL: "http://cdn.example/a-3x.webp", Element: "source", Attribute: "srcset", Kind: mixedPassive},
+				{URL: "http://cdn.example/a-480.png", Element: "img", Attribute: "srcset", Kind: mixedPassive},
+			}},
+		{"source in video", `<video poster="http://cdn.example/poster.jpg"><source src="http://cdn.example/clip.mp4" type="video/mp4"></video>`,
+			[]insecureRef{
+				{URL: "http://cdn.example/poster.jpg", Element: "video", Attribute: "poster", Kind: mixedPassive},
+				{URL: "http://cdn.example/clip.mp4", Element: "source", Attribute: "src", Kind: mixedPassive},
+			}},
+		{"active elements", `<script src="http://cdn.example/app.js"></script><iframe src="http://widgets.example/"></iframe>` +
+			`<link rel="stylesheet" href="http://cdn.example/site.css"><link rel="icon" href="http://cdn.example/favicon.ico">` +
+			`<object data="http://cdn.example/movie.swf"></object>`,
+			[]insecureRef{
+				{URL: "http://cdn.example/site.css", Element: "link", Attribute: "href", Kind: mixedActive},
+				{URL: "http://cdn.example/favicon.ico", Element: "link", Attribute: "href", Kind: mixedPassive},
+				{URL: "http://cdn.example/app.js", Element: "script", Attribute: "src", Kind: mixedActive},
+				{URL: "http://widgets.example/", Element: "iframe", Attribute: "src", Kind: mixedActive},
+				{URL: "http://cdn.example/movie.swf", Element: "object", Attribute: "data", Kind: mixedActive},
+			}},
+		{"links, each once", `<a href="http://partner.example/#top">1</a><a href="http://partner.example/">2</a><a href="/local">3</a>`,
+			[]insecureRef{
+				{URL: "http://partner.example/", Element: "a", Attribute: "href", Kind: mixedLink},
+			}},
+		{"nothing insecure", `<img src="/logo.png" srcset="//cdn.example/logo-2x.png 2x"><div style="background: url(/bg.png)"></div>`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := c.extractLinksFromHTML(pageContext{URL: "https://example.com/page"}, strings.NewReader(`<html><body>`+tt.html+`</body></html>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info.Insecure, tt.want) {
+				t.Errorf("insecure refs\n%+v\nwant\n%+v", info.Insecure, tt.want)
+			}
+		})
+	}
+
+	t.Run("http page", func(t *testing.T) {
+		info, err := c.extractLinksFromHTML(pageContext{URL: "http://example.com/page"},
+			strings.NewReader(`<html><body><div style="background: url(http://cdn.example/bg.png)"></div></body></html>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(info.Insecure) != 0 {
+			t.Errorf("insecure refs %+v on a page served over http", info.Insecure)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// natsMaxPayload is what a server allows when its INFO doesn't say
+const natsMaxPayload = 1 << 20
+
+// natsPublisher speaks just enough of the NATS client protocol to publish:
+// CONNECT, then PUB followed by a PING, whose PONG means the server has
+// taken the message. The connection is made on the first Publish and made
+// again after any error.
+type natsPublisher struct {
+	u    *url.URL
+	conn net.Conn
+	r    *bufio.Reader
+	// maxPayload is the server's limit from its INFO
+	maxPayload int
+}
+
+func newNATSPublisher(u *url.URL) *natsPublisher {
+	return &natsPublisher{u: u}
+}
+
+// String names the broker without its credentials, for the logs
+func (p *natsPublisher) String() string {
+	return p.u.Scheme + "://" + p.u.Host
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, topic string, msg []byte) error {
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return fmt.Errorf("connecting to %s: %w", p, err)
+		}
+	}
+	if len(msg) > p.maxPayload {
+		return fmt.Errorf("%w: %d bytes is over the server's limit of %d", ErrEventRejected, len(msg), p.maxPayload)
+	}
+	conn := p.conn
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	err := p.publish(topic, msg)
+	if err != nil && !errors.Is(err, ErrEventRejected) {
+		p.Close()
+	}
+	return err
+}
+
+func (p *natsPublisher) publish(topic string, msg []byte) error {
+	frame := fmt.Appendf(nil, "PUB %s %d\r\n", topic, len(msg))
+	frame = append(frame, msg...)
+	frame = append(frame, "\r\nPING\r\n"...)
+	if _, err := p.conn.Write(frame); err != nil {
+		return err
+	}
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			// The server closes the connection after most errors
+			p.Close()
+			e := strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'")
+			if strings.Contains(strings.ToLower(e), "permissions violation") {
+				return fmt.Errorf("%w: %s", ErrEventRejected, e)
+			}
+			return errors.New(e)
+		}
+		// +OK and INFO updates need nothing
+	}
+}
+
+// connect dials the server, upgrading to TLS for tls:// or when the
+// server requires it, and logs in with the URL's user and password
+func (p *natsPublisher) connect(ctx context.Context) (err error) {
+	host := p.u.Host
+	if p.u.Port() == "" {
+		host = net.JoinHostPort(p.u.Hostname(), "4222")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	p.conn, p.r = conn, bufio.NewReader(conn)
+	defer func() {
+		if err != nil {
+			p.Close()
+		}
+	}()
+
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("expected INFO from the server, got %q", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+		MaxPayload  int  `json:"max_payload"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		return fmt.Errorf("reading the server's INFO: %w", err)
+	}
+	p.maxPayload = info.MaxPayload
+	if p.maxPayload <= 0 {
+		p.maxPayload = natsMaxPayload
+	}
+	if p.u.Scheme == "tls" || info.TLSRequired {
+		tc := tls.Client(conn, &tls.Config{ServerName: p.u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		p.conn, p.r = tc, bufio.NewReader(tc)
+	}
+
+	opts := map[string]any{
+		"verbose":      false,
+		"pedantic":     false,
+		"tls_required": p.u.Scheme == "tls" || info.TLSRequired,
+		"name":         "simple-web-scraper",
+		"lang":         "go",
+		"version":      "1",
+		"protocol":     0,
+	}
+	if user := p.u.User; user != nil {
+		if pass, ok := user.Password(); ok {
+			opts["user"], opts["pass"] = user.Username(), pass
+		} else {
+			opts["auth_token"] = user.Username()
+		}
+	}
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	// The PONG confirms the CONNECT went through; a bad login gets -ERR
+	if _, err := p.conn.Write(fmt.Appendf(nil, "CONNECT %s\r\nPING\r\n", data)); err != nil {
+		return err
+	}
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		}
+	}
+}
+
+func (p *natsPublisher) readLine() (string, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (p *natsPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.r = nil, nil
+	return err
+}
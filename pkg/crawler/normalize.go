@@ -0,0 +1,137 @@
+package crawler
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// normalizeURL reduces equivalent spellings of a URL to a single form so the
+// found file doesn't collect duplicates: the fragment is dropped, scheme and
+// host are lowercased, the host in punycode and the URL encoded as
+// encodeURL has it, the default port is dropped, repeated slashes are
+// collapsed, the trailing slash is removed from every path except the
+// root, and tracking/session parameters are stripped from a query that is
+// then sorted by key.
+func (c *Crawler) normalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(cleanHref(rawURL))
+	if err != nil {
+		return "", err
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	encodeURL(u)
+	dropDefaultPort(u)
+	c.foldHost(u)
+
+	path := u.EscapedPath()
+	if c.cfg.NormalizeQuery {
+		path = c.stripPathSessionIDs(path)
+	}
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if path == "" && u.Host != "" {
+		path = "/"
+	}
+	if err := setEscapedPath(u, path); err != nil {
+		return "", err
+	}
+	if c.cfg.NormalizeQuery {
+		u.RawQuery = c.cleanQuery(u.RawQuery)
+	}
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// dropDefaultPort removes ":80" from an http:// host and ":443" from an
+// https:// one
+func dropDefaultPort(u *url.URL) {
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = strings.TrimSuffix(u.Host, ":"+u.Port())
+	}
+}
+
+func setEscapedPath(u *url.URL, escaped string) error {
+	unescaped, err := url.PathUnescape(escaped)
+	if err != nil {
+		return err
+	}
+	u.Path = unescaped
+	u.RawPath = ""
+	if u.EscapedPath() != escaped {
+		u.RawPath = escaped
+	}
+	return nil
+}
+
+func (c *Crawler) isStrippedParam(key string) bool {
+	key = strings.ToLower(key)
+	for _, p := range c.cfg.StripQueryParams {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == p {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanQuery drops stripped parameters and sorts the rest by key. Pairs are
+// kept in their original encoding, and repeated keys keep their order.
+func (c *Crawler) cleanQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	var pairs []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		if c.isStrippedParam(queryKey(pair)) {
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return queryKey(pairs[i]) < queryKey(pairs[j])
+	})
+	return strings.Join(pairs, "&")
+}
+
+func queryKey(pair string) string {
+	key, _, _ := strings.Cut(pair, "=")
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+	return key
+}
+
+// stripPathSessionIDs removes ";jsessionid=..." style parameters that some
+// servers embed in the path itself.
+func (c *Crawler) stripPathSessionIDs(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		name, params, ok := strings.Cut(seg, ";")
+		if !ok {
+			continue
+		}
+		kept := []string{name}
+		for _, param := range strings.Split(params, ";") {
+			key, _, _ := strings.Cut(param, "=")
+			if !c.isStrippedParam(key) {
+				kept = append(kept, param)
+			}
+		}
+		segments[i] = strings.Join(kept, ";")
+	}
+	return strings.Join(segments, "/")
+}
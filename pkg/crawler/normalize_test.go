@@ -0,0 +1,83 @@
+package crawler
+
+import "testing"
+
+func TestNormalizeURL(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	tests := []struct {
+		in, want string
+	}{
+		{"https://example.com", "https://example.com/"},
+		{"HTTPS://Example.COM/Docs", "https://example.com/Docs"},
+		{"https://example.com/", "https://example.com/"},
+		{"https://example.com//", "https://example.com/"},
+		{"https://example.com/page", "https://example.com/page"},
+		{"https://example.com/page/", "https://example.com/page"},
+		{"https://example.com/page/?b=2&a=1", "https://example.com/page?a=1&b=2"},
+		{"https://example.com//a///b/", "https://example.com/a/b"},
+		{"https://example.com/page#section", "https://example.com/page"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"https://example.com:80/a", "https://example.com:80/a"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+		{"https://example.com/search?b=2&a=1", "https://example.com/search?a=1&b=2"},
+		{"https://example.com/?utm_source=x&id=3", "https://example.com/?id=3"},
+		{"https://example.com/?", "https://example.com/"},
+	}
+	for _, tt := range tests {
+		got, err := c.normalizeURL(tt.in)
+		if err != nil {
+			t.Errorf("normalizeURL(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if again, _ := c.normalizeURL(got); again != got {
+			t.Errorf("normalizeURL(%q) = %q, not idempotent", got, again)
+		}
+	}
+}
+
+func TestBaseURLNormalized(t *testing.T) {
+	tests := []struct {
+		base, wantBase string
+		inScope        []string
+		outOfScope     []string
+	}{
+		{
+			base:       "https://Example.com/",
+			wantBase:   "https://example.com/",
+			inScope:    []string{"https://example.com/", "https://example.com/a"},
+			outOfScope: []string{"https://other.com/"},
+		},
+		{
+			base:     "https://example.com:443/",
+			wantBase: "https://example.com/",
+			inScope:  []string{"https://example.com/a", "https://example.com:443/b"},
+		},
+		{
+			base:       "HTTP://EXAMPLE.COM:80/docs/",
+			wantBase:   "http://example.com/docs",
+			inScope:    []string{"http://example.com/docs/", "http://example.com/docs", "http://example.com/docs/a/", "http://example.com/docs?page=2"},
+			outOfScope: []string{"http://example.com/blog/", "http://example.com/docs-old/", "http://example.com/"},
+		},
+	}
+	for _, tt := range tests {
+		c := newTestCrawler(t, tt.base, nil)
+		if c.cfg.BaseURL != tt.wantBase {
+			t.Errorf("BaseURL %q became %q, want %q", tt.base, c.cfg.BaseURL, tt.wantBase)
+		}
+		for _, raw := range tt.inScope {
+			found, err := c.normalizeURL(raw)
+			if err != nil || !c.inScope(found) {
+				t.Errorf("base %q: %q (found as %q) is out of scope", tt.base, raw, found)
+			}
+		}
+		for _, raw := range tt.outOfScope {
+			if found, _ := c.normalizeURL(raw); c.inScope(found) {
+				t.Errorf("base %q: %q is in scope", tt.base, raw)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package crawler
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+)
+
+// The crawl orders
+const (
+	orderBFS = "bfs"
+	orderDFS = "dfs"
+)
+
+// PriorityRule gives the URLs matching Pattern a weight; pending URLs of
+// higher weight are dispatched first, whatever their depth
+type PriorityRule struct {
+	Pattern *regexp.Regexp
+	Weight  int
+}
+
+// CompilePriorityRules parses a comma-separated list of pattern=weight
+// rules for the setting called name, reporting every bad rule
+func CompilePriorityRules(name, list string) ([]PriorityRule, error) {
+	patterns, weights, err := compileNumberedPatterns(name, "weight", list)
+	rules := make([]PriorityRule, len(patterns))
+	for i := range patterns {
+		rules[i] = PriorityRule{Pattern: patterns[i], Weight: weights[i]}
+	}
+	return rules, err
+}
+
+// priority is the weight of the first rule url matches, zero if none does
+func (c *Crawler) priority(url string) int {
+	for _, r := range c.cfg.PriorityRules {
+		if r.Pattern.MatchString(url) {
+			return r.Weight
+		}
+	}
+	return 0
+}
+
+// batchSize is how many pending URLs one pass of the crawl loop dispatches.
+// Breadth-first order holds with big batches, since the links found by a
+// batch are deeper than it. Depth-first order and priority rules need each
+// page's links weighed before the next pages go out, so their batches are
+// a page per worker.
+func (c *Crawler) batchSize(workers int) int {
+	if c.cfg.CrawlOrder == orderDFS || len(c.cfg.PriorityRules) > 0 {
+		return workers
+	}
+	return frontierBatchSize
+}
+
+// orderedFrontier is a frontier that ranks its pending URLs in crawl order
+// itself, by the priority they were added with, so the crawl loop needn't
+// load them all to pick a batch
+type orderedFrontier interface {
+	// NextOrdered returns up to n pending URLs by priority, then
+	// shallowest first in the order they were found, or deepest first,
+	// the last found first, when dfs is set
+	NextOrdered(n int, dfs bool) []FrontierEntry
+	// Reprioritize sets the priority of every pending URL to what
+	// priority returns for it
+	Reprioritize(priority func(url string) int) error
+}
+
+// reprioritize brings the priorities an ordered frontier holds in line
+// with the rules, which may have changed since a resumed crawl stored them
+func (c *Crawler) reprioritize() error {
+	if f, ok := c.Frontier.(orderedFrontier); ok {
+		return f.Reprioritize(c.priority)
+	}
+	return nil
+}
+
+// rankedEntry is a pending URL with what orders it: its priority and its
+// place in the frontier
+type rankedEntry struct {
+	FrontierEntry
+	priority, seq int
+}
+
+// before reports whether a goes out before b: by priority, then shallowest
+// first, in the order they were found, for breadth-first, or deepest
+// first, the last found first, for depth-first
+func (a rankedEntry) before(b rankedEntry, dfs bool) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if dfs {
+		return cmp.Or(cmp.Compare(b.Depth, a.Depth), cmp.Compare(b.seq, a.seq)) < 0
+	}
+	return cmp.Or(cmp.Compare(a.Depth, b.Depth), cmp.Compare(a.seq, b.seq)) < 0
+}
+
+// nextBatch returns the first n pending URLs in crawl order. An ordered
+// frontier picks them itself; otherwise breadth-first without rules is the
+// order they were found in, and anything else ranks every pending URL,
+// with the priorities worked out from the rules each time.
+func (c *Crawler) nextBatch(n int) []FrontierEntry {
+	dfs := c.cfg.CrawlOrder == orderDFS
+	if f, ok := c.Frontier.(orderedFrontier); ok {
+		return f.NextOrdered(n, dfs)
+	}
+	if !dfs && len(c.cfg.PriorityRules) == 0 {
+		return c.Frontier.NextBatch(n)
+	}
+	// Only the best n are kept, in order, as the pending URLs go by
+	best := make([]rankedEntry, 0, n)
+	for i, e := range c.Frontier.NextBatch(c.Frontier.Stats().Pending) {
+		r := rankedEntry{e, c.priority(e.URL), i}
+		if len(best) == n && !r.before(best[n-1], dfs) {
+			continue
+		}
+		at, _ := slices.BinarySearchFunc(best, r, func(b, r rankedEntry) int {
+			if b.before(r, dfs) {
+				return -1
+			}
+			return 1
+		})
+		if len(best) == n {
+			best = best[:n-1]
+		}
+		best = slices.Insert(best, at, r)
+	}
+	batch := make([]FrontierEntry, len(best))
+	for i, r := range best {
+		batch[i] = r.FrontierEntry
+	}
+	return batch
+}
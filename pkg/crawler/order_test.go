@@ -0,0 +1,174 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// treeSite serves a site two levels deep, / linking to /a and /b and each
+// of those to two pages below it, and returns the pages in the order they
+// were requested
+func treeSite(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	links := map[string][]string{
+		"/":  {"/a", "/b"},
+		"/a": {"/a/1", "/a/2"},
+		"/b": {"/b/1", "/b/2"},
+	}
+	var mu sync.Mutex
+	var visited []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		visited = append(visited, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		var page strings.Builder
+		page.WriteString("<html>")
+		for _, l := range links[r.URL.Path] {
+			page.WriteString(`<a href="` + l + `">` + l + `</a>`)
+		}
+		page.WriteString("</html>")
+		w.Write([]byte(page.String()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), visited...)
+	}
+}
+
+// TestCrawlOrder crawls the tree site with one worker and each frontier:
+// the pages are visited level by level, branch by branch, or with the
+// prioritized branch first, whichever frontier ranks them
+func TestCrawlOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    string
+		priority string
+		want     []string
+	}{
+		{"breadth-first", orderBFS, "", []string{"/", "/a", "/b", "/a/1", "/a/2", "/b/1", "/b/2"}},
+		{"depth-first", orderDFS, "", []string{"/", "/b", "/b/2", "/b/1", "/a", "/a/2", "/a/1"}},
+		{"breadth-first by priority", orderBFS, "/b=5", []string{"/", "/b", "/b/1", "/b/2", "/a", "/a/1", "/a/2"}},
+		{"depth-first by priority", orderDFS, "/a=5", []string{"/", "/a", "/a/2", "/a/1", "/b", "/b/2", "/b/1"}},
+	}
+	for _, backend := range []string{"sqlite", "journal", "text"} {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				srv, visited := treeSite(t)
+				rules, err := CompilePriorityRules("PRIORITY_RULES", tt.priority)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+					cfg.Workers = 1
+					cfg.RespectRobots = false
+					cfg.FrontierBackend = backend
+					cfg.CrawlOrder = tt.order
+					cfg.PriorityRules = rules
+				})
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := c.Run(ctx); err != nil {
+					t.Fatalf("Run: %v", err)
+				}
+				if got := visited(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("visited %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// TestSQLiteFrontierOrder has the SQLite frontier rank its pending URLs,
+// then rank them again once the rules that weighed them have changed, as a
+// resumed crawl does
+func TestSQLiteFrontierOrder(t *testing.T) {
+	const a, b, c, d = "https://example.com/a", "https://example.com/b", "https://example.com/a/c", "https://example.com/b/d"
+	path := filepath.Join(t.TempDir(), "frontier.db")
+	f := &sqliteFrontier{path: path}
+	if err := f.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []FrontierEntry{{URL: a, Depth: 1}, {URL: b, Depth: 1}, {URL: c, Depth: 2}, {URL: d, Depth: 2, Priority: 3}} {
+		if _, err := f.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		what string
+		dfs  bool
+		n    int
+		want []string
+	}{
+		{"breadth-first", false, 10, []string{d, a, b, c}},
+		{"depth-first", true, 10, []string{d, c, b, a}},
+		{"breadth-first, first two", false, 2, []string{d, a}},
+	}
+	for _, tt := range tests {
+		if got := urlsOf(f.NextOrdered(tt.n, tt.dfs)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.what, got, tt.want)
+		}
+	}
+
+	// The rules changed: /a/ pages first, and nothing else weighs more
+	rule := regexp.MustCompile(`/a/`)
+	if err := f.Reprioritize(func(url string) int {
+		if rule.MatchString(url) {
+			return 2
+		}
+		return 0
+	}); err != nil {
+		t.Fatalf("Reprioritize: %v", err)
+	}
+	if got, want := urlsOf(f.NextOrdered(10, false)), []string{c, a, b, d}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after reprioritizing: %v, want %v", got, want)
+	}
+	f.MarkScraped(c)
+	if got, want := urlsOf(f.NextOrdered(10, false)), []string{a, b, d}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after scraping %s: %v, want %v", c, got, want)
+	}
+}
+
+// TestSQLiteFrontierAddsPriority opens a frontier.db from before priorities
+// were kept: it gets the column, and its pending URLs keep their order
+func TestSQLiteFrontierAddsPriority(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frontier.db")
+	old := strings.Replace(frontierSchema, "\tpriority INTEGER NOT NULL DEFAULT 0,\n", "", 1)
+	db, err := openSQLite(path, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"https://example.com/a", "https://example.com/b"} {
+		if _, err := db.Exec(`INSERT INTO urls (url, found, depth, state) VALUES (?, 1, 1, 'pending')`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	f := &sqliteFrontier{path: path}
+	if err := f.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if got, want := urlsOf(f.NextOrdered(10, false)), []string{"https://example.com/a", "https://example.com/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pending %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package crawler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// sourcePagination marks the frontier entries and page records of pages
+// reached by following rel="next"
+const sourcePagination = "pagination"
+
+// linkHeader returns the targets of the page's Link header values that
+// have rel, as in `<https://example.com/?page=2>; rel="next"`, in order
+func linkHeader(h http.Header, rel string) []string {
+	var targets []string
+	for _, v := range h.Values("Link") {
+		for {
+			v = strings.TrimLeft(v, " \t,")
+			if !strings.HasPrefix(v, "<") {
+				break
+			}
+			end := strings.IndexByte(v, '>')
+			if end < 0 {
+				break
+			}
+			target := v[1:end]
+			var params string
+			params, v = splitLinkParams(v[end+1:])
+			if hasToken(linkParam(params, "rel"), rel) {
+				targets = append(targets, strings.TrimSpace(target))
+			}
+		}
+	}
+	return targets
+}
+
+// splitLinkParams splits the parameters of one Link value from the values
+// after it, at the first comma outside quotes
+func splitLinkParams(s string) (params, rest string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case ',':
+			if !quoted {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+// linkParam is the value of the parameter name in the ";"-separated
+// parameters of a Link value, unquoted
+func linkParam(params, name string) string {
+	for _, p := range strings.Split(params, ";") {
+		k, v, ok := strings.Cut(p, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	return ""
+}
+
+// storePagination stores the page's rel="next" at the page's own depth, a
+// step further along its chain, so a depth limit can't cut a paginated
+// listing short. Past MaxPaginationChain steps the next page is left to
+// finishPage, which stores it a level deeper like any other link.
+func (c *Crawler) storePagination(job Job, next string) {
+	if next == "" {
+		return
+	}
+	if limit := c.cfg.MaxPaginationChain; limit > 0 && job.Chain >= limit {
+		c.log().Debug("pagination chain at its limit", "url", job.URL, "next", next, "limit", limit)
+		return
+	}
+	c.storeURLs([]string{next}, FrontierEntry{Depth: job.Depth, Referrer: job.URL, Source: sourcePagination, Chain: job.Chain + 1})
+}
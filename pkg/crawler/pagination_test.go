@@ -0,0 +1,174 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLinkHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		rel    string
+		want   []string
+	}{
+		{"none", nil, "next", nil},
+		{"one", []string{`<https://example.com/?page=2>; rel="next"`}, "next", []string{"https://example.com/?page=2"}},
+		{"unquoted rel", []string{`</page/2>; rel=next`}, "next", []string{"/page/2"}},
+		{"other rel", []string{`</page/1>; rel="prev"`}, "next", nil},
+		{"several in one value", []string{`</page/1>; rel="prev", </page/3>; rel="next"`}, "next", []string{"/page/3"}},
+		{"several values", []string{`</page/1>; rel="prev"`, `</page/3>; rel="next"`}, "prev", []string{"/page/1"}},
+		{"rel list", []string{`</page/3>; rel="next last"`}, "next", []string{"/page/3"}},
+		{"rel in upper case", []string{`</page/3>; REL="Next"`}, "next", []string{"/page/3"}},
+		{"comma in a quoted parameter", []string{`</a>; title="a, b"; rel="next", </b>; rel="prev"`}, "next", []string{"/a"}},
+		{"padding", []string{`  </page/3> ;  rel = "next" `}, "next", []string{"/page/3"}},
+		{"not a link", []string{`rel="next"`}, "next", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for _, v := range tt.values {
+				h.Add("Link", v)
+			}
+			if got := linkHeader(h, tt.rel); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("linkHeader(%q, %s) = %q, want %q", tt.values, tt.rel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPagination(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	const page = "https://example.com/list/2"
+	tests := []struct {
+		name       string
+		head, body string
+		header     http.Header
+		next, prev string
+	}{
+		{"none", ``, ``, nil, "", ""},
+		{"link elements", `<link rel="next" href="/list/3"><link rel="prev" href="1">`, ``, nil,
+			"https://example.com/list/3", "https://example.com/list/1"},
+		{"anchors", ``, `<a rel="next" href="3">more</a><a rel="prev" href="/list/1">back</a>`, nil,
+			"https://example.com/list/3", "https://example.com/list/1"},
+		{"Link header", ``, ``, http.Header{"Link": {`</list/3>; rel="next", </list/1>; rel="prev"`}},
+			"https://example.com/list/3", "https://example.com/list/1"},
+		{"markup before the header", `<link rel="next" href="/list/3">`, ``, http.Header{"Link": {`</list/9>; rel="next"`}},
+			"https://example.com/list/3", ""},
+		{"first of several", ``, `<a rel="next" href="3">3</a><a rel="next" href="4">4</a>`, nil,
+			"https://example.com/list/3", ""},
+		{"out of scope", ``, `<a rel="next" href="https://elsewhere.example/list/3">3</a>`, nil, "", ""},
+		{"fragment dropped", ``, `<a rel="next" href="3#top">3</a>`, nil, "https://example.com/list/3", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html := `<html><head>` + tt.head + `</head><body>` + tt.body + `</body></html>`
+			info, err := c.extractLinksFromHTML(pageContext{URL: page, Header: tt.header}, strings.NewReader(html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Next != tt.next || info.Prev != tt.prev {
+				t.Errorf("next %q, prev %q, want %q and %q", info.Next, info.Prev, tt.next, tt.prev)
+			}
+		})
+	}
+}
+
+// paginatedSite serves pages whose rel="next", given by next, is put in
+// the markup or the header as each path's way says, every page linking to
+// /other too, and returns the paths in the order they were requested
+func paginatedSite(t *testing.T, next map[string]string, way map[string]string) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		var head, body string
+		if n, ok := next[r.URL.Path]; ok {
+			switch way[r.URL.Path] {
+			case "header":
+				w.Header().Set("Link", `<`+n+`>; rel="next"`)
+			case "a":
+				body = `<a rel="next" href="` + n + `">next</a>`
+			default:
+				head = `<link rel="next" href="` + n + `">`
+			}
+		}
+		w.Write([]byte(`<html><head>` + head + `</head><body><a href="/other">other</a>` + body + `</body></html>`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requested...)
+	}
+}
+
+// TestPaginationCrawl follows chains of rel="next", however each page
+// gives it, at the depth of the first page, so a MAX_DEPTH of 0 keeps the
+// ordinary links out but not the next pages
+func TestPaginationCrawl(t *testing.T) {
+	next := map[string]string{"/": "/2", "/2": "/3", "/3": "/4"}
+	way := map[string]string{"/": "header", "/2": "link", "/3": "a"}
+	tests := []struct {
+		name  string
+		chain int
+		want  []string
+	}{
+		{"whole chain", 0, []string{"/", "/2", "/3", "/4"}},
+		{"capped chain", 2, []string{"/", "/2", "/3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requested := paginatedSite(t, next, way)
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.MaxDepth = 0
+				cfg.MaxPaginationChain = tt.chain
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.Run(ctx); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if got := requested(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requested %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPaginationLoop has two pages each other's rel="next": the crawl
+// fetches each once and ends
+func TestPaginationLoop(t *testing.T) {
+	srv, requested := paginatedSite(t, map[string]string{"/": "/b", "/b": "/"}, map[string]string{"/b": "header"})
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.MaxDepth = 0
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if run := c.LastRun(); run.Outcome != outcomeCompleted {
+		t.Errorf("outcome %q, want %q", run.Outcome, outcomeCompleted)
+	}
+	got := requested()
+	sort.Strings(got)
+	if want := []string{"/", "/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requested %v, want each page once", got)
+	}
+}
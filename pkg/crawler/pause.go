@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pausePollInterval is how often a paused crawl checks whether it may go on
+const pausePollInterval = time.Second
+
+// CrawlWindow is the time of day, in local time, a crawl may run in: from
+// Start to End past midnight. A window whose End is before its Start runs
+// over midnight.
+type CrawlWindow struct {
+	Start, End time.Duration
+}
+
+// ParseCrawlWindow parses a window such as "22:00-06:00"
+func ParseCrawlWindow(s string) (*CrawlWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("crawl window must be HH:MM-HH:MM, got %q", s)
+	}
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("crawl window %q is empty", s)
+	}
+	return &CrawlWindow{Start: start, End: end}, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hours, herr := strconv.Atoi(h)
+	minutes, merr := strconv.Atoi(m)
+	if !ok || herr != nil || merr != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 59 ||
+		(hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+func (w *CrawlWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+// contains reports whether t falls in the window
+func (w *CrawlWindow) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	at := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.Start < w.End {
+		return at >= w.Start && at < w.End
+	}
+	return at >= w.Start || at < w.End
+}
+
+// Pause stops the crawl dispatching new jobs; the requests in flight
+// finish, then the workers idle until Resume. It is safe to call from
+// another goroutine, such as a signal handler.
+func (c *Crawler) Pause() {
+	c.pauseRequested.Store(true)
+}
+
+// Resume lets a crawl paused with Pause go on, unless the PAUSE file or the
+// schedule still holds it
+func (c *Crawler) Resume() {
+	c.pauseRequested.Store(false)
+}
+
+// pauseReason says why the crawl should be paused now, or is empty when it
+// may run
+func (c *Crawler) pauseReason() string {
+	if c.pauseRequested.Load() {
+		return "requested"
+	}
+	if _, err := os.Stat(c.pauseFileName); err == nil {
+		return "PAUSE file present"
+	}
+	if w := c.cfg.Schedule; w != nil && !w.contains(time.Now()) {
+		return "outside the crawl window " + w.String()
+	}
+	return ""
+}
+
+// waitWhilePaused idles the crawl, with its state flushed so that killing
+// the process loses nothing, until nothing holds it any longer or ctx is
+// done
+func (c *Crawler) waitWhilePaused(ctx context.Context, reason string) error {
+	started := time.Now()
+	defer c.paused.Store("")
+	c.log().Info("crawl paused", "reason", reason)
+	if err := c.flushStateFiles(); err != nil {
+		c.log().Error("writing state files", "error", err)
+	}
+	for reason != "" {
+		c.paused.Store(reason)
+		if err := sleepCtx(ctx, pausePollInterval); err != nil {
+			return err
+		}
+		reason = c.pauseReason()
+	}
+	c.log().Info("crawl resumed", "paused_for", time.Since(started).Round(time.Second))
+	return nil
+}
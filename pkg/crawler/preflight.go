@@ -0,0 +1,148 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ErrPreflight is returned by Run when a check before the crawl failed;
+// nothing has been written by then
+var ErrPreflight = errors.New("preflight check failed")
+
+// preflight checks, before the project folder is touched, that the folder
+// can be written and that BaseURL may be crawled and answers
+func (c *Crawler) preflight(ctx context.Context) error {
+	if err := checkWritable(c.cfg.ProjectFolder); err != nil {
+		return fmt.Errorf("%w: project folder %s is not writable: %v; pick another folder or fix its permissions",
+			ErrPreflight, c.cfg.ProjectFolder, err)
+	}
+	base, err := url.Parse(c.cfg.BaseURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPreflight, err)
+	}
+	robots := "not respected"
+	if c.cfg.RespectRobots {
+		rules := c.robotsFor(ctx, base)
+		switch {
+		case rules == nil:
+			robots = "none"
+		case !rules.allowed(base):
+			return fmt.Errorf("%w: %s is disallowed by robots.txt for %s; crawl it only if you may, with RespectRobots off",
+				ErrPreflight, c.cfg.BaseURL, c.robotsToken())
+		default:
+			robots = "allows BaseURL"
+		}
+	}
+	status, method, err := c.checkBase(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrPreflight, c.cfg.BaseURL, baseProblem(err))
+	}
+	c.log().Info("preflight passed", "url", c.cfg.BaseURL, "method", method, "status", status, "robots", robots,
+		"project", c.cfg.ProjectFolder)
+	return nil
+}
+
+// checkBase requests BaseURL with a HEAD, or a GET if the server doesn't
+// take HEAD, and returns the status it got. A status the crawl can't use
+// is returned as an error, except for a 401 or 403 when a login or saved
+// cookies may get past it.
+func (c *Crawler) checkBase(ctx context.Context) (code int, method string, err error) {
+	method = http.MethodHead
+	code, err = c.requestBase(ctx, method)
+	if err == nil && (code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+		method = http.MethodGet
+		code, err = c.requestBase(ctx, method)
+	}
+	switch {
+	case err != nil:
+		return 0, method, err
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		if c.cfg.Login.URL == "" && !c.cfg.PersistCookies {
+			return code, method, &statusError{code: code}
+		}
+		c.log().Warn("BaseURL refused before logging in", "status", code)
+	case code >= 400:
+		return code, method, &statusError{code: code}
+	}
+	return code, method, nil
+}
+
+func (c *Crawler) requestBase(ctx context.Context, method string) (int, error) {
+	chainCtx, _ := withRedirectChain(ctx)
+	req, err := http.NewRequestWithContext(chainCtx, method, c.cfg.BaseURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", c.userAgent(ctx))
+	c.setRequestHeaders(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// baseProblem says what went wrong reaching BaseURL, and what to look at
+func baseProblem(err error) string {
+	var offSite *offSiteRedirectError
+	if errors.As(err, &offSite) {
+		return fmt.Sprintf("it redirects out of scope to %s; start from that URL instead", offSite.to)
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		switch {
+		case se.code == http.StatusUnauthorized || se.code == http.StatusForbidden:
+			return fmt.Sprintf("answered %d; the site wants credentials, or refuses this user agent", se.code)
+		case se.code == http.StatusTooManyRequests:
+			return "answered 429; the site is rate limiting already, lower the request rate"
+		case se.code >= 500:
+			return fmt.Sprintf("answered %d; the site is having trouble, try again later", se.code)
+		}
+		return fmt.Sprintf("answered %d; check the path", se.code)
+	}
+	switch classify(err) {
+	case failDNS:
+		return fmt.Sprintf("the host name doesn't resolve; check it for typos (%v)", err)
+	case failConnect, failConnectTimeout:
+		return fmt.Sprintf("can't connect; check the port, the proxy and that the site is up (%v)", err)
+	case failTLS:
+		return fmt.Sprintf("the TLS handshake failed; check the CA and client certificates (%v)", err)
+	case failTimeout:
+		return fmt.Sprintf("no answer within the request timeout (%v)", err)
+	}
+	return err.Error()
+}
+
+// checkWritable checks that dir, or the nearest folder above it that
+// exists when it doesn't, takes new files, leaving nothing behind
+func checkWritable(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a folder", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	f, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,159 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The ProbeHead modes
+const (
+	probeOff  = "off"
+	probeOn   = "on"
+	probeAuto = "auto"
+)
+
+// probeAutoSample is how many pages the "auto" mode sees before it judges
+// the share that isn't HTML
+const probeAutoSample = 20
+
+// probeResult is what a HEAD said about a URL: its media type, empty when
+// the server didn't tell, and its size, -1 when it didn't say
+type probeResult struct {
+	status      int
+	contentType string
+	length      int64
+}
+
+// prober keeps the HEAD answers until the URL is fetched, so a job
+// re-queued or retried doesn't probe again, and the hosts that refuse
+// HEAD. It also counts the pages by type for the "auto" mode.
+type prober struct {
+	mu      sync.Mutex
+	results map[string]probeResult
+	noHead  map[string]bool
+
+	html, other atomic.Int64
+}
+
+func newProber() *prober {
+	return &prober{results: make(map[string]probeResult), noHead: make(map[string]bool)}
+}
+
+// countType counts a page's media type for the "auto" mode
+func (p *prober) countType(mediaType string) {
+	if isHTMLType(mediaType) {
+		p.html.Add(1)
+	} else {
+		p.other.Add(1)
+	}
+}
+
+// forget drops what was learned about url, once it has been fetched
+func (p *prober) forget(url string) {
+	p.mu.Lock()
+	delete(p.results, url)
+	p.mu.Unlock()
+}
+
+// probing reports whether page fetches send a HEAD first: always with
+// ProbeHead "on", and with "auto" once enough pages were seen and more
+// than ProbeAutoRatio of them weren't HTML
+func (c *Crawler) probing() bool {
+	switch c.cfg.ProbeHead {
+	case probeOn:
+		return true
+	case probeAuto:
+		html, other := c.probes.html.Load(), c.probes.other.Load()
+		total := html + other
+		return total >= probeAutoSample && float64(other) > c.cfg.ProbeAutoRatio*float64(total)
+	}
+	return false
+}
+
+// probeHead sends url a HEAD, or returns what an earlier one said. ok is false
+// when it says nothing useful: the host refuses HEAD, or the request
+// failed in a way a GET may not, so the GET finds out instead. A 429 or
+// 503 pauses the host and comes back as a *throttledError like a GET's.
+func (c *Crawler) probeHead(ctx context.Context, url string) (r probeResult, ok bool, err error) {
+	host := hostOf(url)
+	c.probes.mu.Lock()
+	r, cached := c.probes.results[url]
+	refused := c.probes.noHead[host]
+	c.probes.mu.Unlock()
+	if cached {
+		return r, true, nil
+	}
+	if refused {
+		return probeResult{}, false, nil
+	}
+
+	// Off-site redirects end the HEAD like they would the GET
+	ctx, _ = withRedirectChain(ctx)
+	req, err := http.NewRequestWithContext(c.conns.trace(ctx), http.MethodHead, url, nil)
+	if err != nil {
+		return probeResult{}, false, err
+	}
+	req.Header.Set("User-Agent", c.userAgent(ctx))
+	c.setRequestHeaders(req)
+	if err := c.throttle.wait(ctx, req.URL.Host); err != nil {
+		return probeResult{}, false, err
+	}
+	if err := c.waitForLimiter(ctx, req.URL.Host); err != nil {
+		return probeResult{}, false, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		var offSite *offSiteRedirectError
+		if ctx.Err() != nil || errors.As(err, &offSite) {
+			return probeResult{}, false, err
+		}
+		return probeResult{}, false, nil
+	}
+	resp.Body.Close()
+	switch code := resp.StatusCode; {
+	case isThrottleStatus(code):
+		delay := c.throttle.pause(req.URL.Host, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+		return probeResult{}, false, &throttledError{code: code, delay: delay}
+	case code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented:
+		c.logFrom(ctx).Debug("host refuses HEAD, fetching its pages without probing", "host", host, "status", code)
+		c.probes.mu.Lock()
+		c.probes.noHead[host] = true
+		c.probes.mu.Unlock()
+		return probeResult{}, false, nil
+	case code != http.StatusOK:
+		// The GET gets the same answer, or a better one
+		return probeResult{}, false, nil
+	}
+	r = probeResult{status: resp.StatusCode, length: resp.ContentLength}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		r.contentType = mediaType
+	}
+	// A generic type is often a server that doesn't know; the GET sniffs
+	if r.contentType == "application/octet-stream" {
+		r.contentType = ""
+	}
+	c.probes.mu.Lock()
+	c.probes.results[url] = r
+	c.probes.mu.Unlock()
+	return r, true, nil
+}
+
+// probeSkip reports why url's probe means it needn't be fetched: a type
+// that isn't kept, or a body over MaxBodySize, which fails it with
+// errBodyTooLarge as the GET would
+func (c *Crawler) probeSkip(url string, r probeResult) (reason string, err error) {
+	t := r.contentType
+	kept := isHTMLType(t) || (c.documents != nil && c.isDocument(url, t)) || (c.cfg.SaveNonHTML && c.contentTypeAllowed(t))
+	if t != "" && !kept {
+		return "content type", nil
+	}
+	if c.cfg.MaxBodySize > 0 && r.length > c.cfg.MaxBodySize {
+		return "", errBodyTooLarge
+	}
+	return "", nil
+}
@@ -0,0 +1,68 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// reportProgress prints a progress line every ProgressInterval until ctx
+// is done. The request rate, the throughput and the ETA use the last
+// interval only, so they follow the crawl as it speeds up or slows down.
+func (c *Crawler) reportProgress(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.ProgressInterval)
+	defer ticker.Stop()
+	lastAt := time.Now()
+	lastRequests, lastDone, lastRead := c.requestCount.Load(), c.pagesDone.Load(), c.bandwidth.read.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			state, _ := c.crawlStatus()
+			requests, done, read := c.requestCount.Load(), c.pagesDone.Load(), c.bandwidth.read.Load()
+			secs := now.Sub(lastAt).Seconds()
+			pageRate := float64(done-lastDone) / secs
+			eta := "unknown"
+			if pageRate > 0 {
+				eta = (time.Duration(float64(state.Pending)/pageRate) * time.Second).Round(time.Second).String()
+			}
+			avg := "-"
+			if saved := c.bodiesSaved.Load(); saved > 0 {
+				avg = formatSize(c.bytesSaved.Load() / saved)
+			}
+			attrs := []any{"scraped", done, "failed", c.pagesFailed.Load(), "pending", state.Pending,
+				"req_per_sec", roundRate(float64(requests-lastRequests) / secs), "avg_page", avg,
+				"throughput", formatSize(int64(float64(read-lastRead)/secs)) + "/s",
+				"elapsed", now.Sub(c.crawlStart).Round(time.Second), "eta", eta}
+			if c.gate != nil {
+				attrs = append(attrs, "workers", c.workerLimit())
+			}
+			if reason, _ := c.paused.Load().(string); reason != "" {
+				attrs = append(attrs, "paused", reason)
+			}
+			if failures := c.failures.String(); failures != "" {
+				attrs = append(attrs, "failures", failures)
+			}
+			c.log().Info("progress", append(attrs, c.conns.attrs()...)...)
+			for _, p := range c.proxyStats() {
+				c.log().Info("proxy", "proxy", p.Proxy, "ok", p.OK, "errors", p.Errors)
+			}
+			lastAt, lastRequests, lastDone, lastRead = now, requests, done, read
+		}
+	}
+}
+
+// formatSize prints a byte count the way settings like MAX_BODY_SIZE are
+// written
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyStats counts the requests that went through one proxy: OK ones got
+// a response, whatever its status; Errors failed to connect or to get one
+type ProxyStats struct {
+	Proxy  string `json:"proxy"`
+	OK     int64  `json:"ok"`
+	Errors int64  `json:"errors"`
+}
+
+// proxyPool sends requests through ProxyURLs, each with its own transport,
+// picking them in turn or by worker. A request that fails to connect is
+// tried on the next proxy, each proxy once.
+type proxyPool struct {
+	proxies []*poolProxy
+	// perWorker pins each worker to a proxy instead of taking turns
+	perWorker bool
+	next      atomic.Uint64
+}
+
+type poolProxy struct {
+	label     string
+	transport *http.Transport
+	ok, errs  atomic.Int64
+}
+
+func (c *Crawler) newProxyPool(timeout time.Duration) (*proxyPool, error) {
+	pool := &proxyPool{perWorker: c.cfg.ProxyRotation == "per-worker"}
+	for _, raw := range c.cfg.ProxyURLs {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("proxy %q is not a URL", raw)
+		}
+		transport := c.newTransport(timeout)
+		switch u.Scheme {
+		case "http", "https":
+			// Credentials in the URL become Proxy-Authorization
+			transport.Proxy = http.ProxyURL(u)
+		case "socks5", "socks5h":
+			dialer, err := proxy.FromURL(u, &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second})
+			if err != nil {
+				return nil, fmt.Errorf("proxy %s: %w", u.Redacted(), err)
+			}
+			contextDialer, ok := dialer.(proxy.ContextDialer)
+			if !ok {
+				return nil, fmt.Errorf("proxy %s can't dial with a context", u.Redacted())
+			}
+			transport.Proxy = nil
+			transport.DialContext = contextDialer.DialContext
+		default:
+			return nil, fmt.Errorf("proxy %s: the scheme must be http, https or socks5", u.Redacted())
+		}
+		pool.proxies = append(pool.proxies, &poolProxy{label: u.Redacted(), transport: transport})
+	}
+	return pool, nil
+}
+
+func (p *proxyPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := len(p.proxies)
+	var first int
+	if id, ok := req.Context().Value(workerKey{}).(int); ok && p.perWorker {
+		first = (id - 1) % n
+	} else {
+		first = int((p.next.Add(1) - 1) % uint64(n))
+	}
+	var err error
+	for i := range n {
+		px := p.proxies[(first+i)%n]
+		var resp *http.Response
+		resp, err = px.transport.RoundTrip(req)
+		if err == nil {
+			px.ok.Add(1)
+			return resp, nil
+		}
+		px.errs.Add(1)
+		// Only a request that never got through is safe to send again
+		var opErr *net.OpError
+		if req.Context().Err() != nil || req.Body != nil || !errors.As(err, &opErr) {
+			break
+		}
+	}
+	return nil, err
+}
+
+func (p *proxyPool) stats() []ProxyStats {
+	stats := make([]ProxyStats, len(p.proxies))
+	for i, px := range p.proxies {
+		stats[i] = ProxyStats{Proxy: px.label, OK: px.ok.Load(), Errors: px.errs.Load()}
+	}
+	return stats
+}
+
+// proxyStats is how each proxy did this run, nil without ProxyURLs
+func (c *Crawler) proxyStats() []ProxyStats {
+	if c.proxies == nil {
+		return nil
+	}
+	return c.proxies.stats()
+}
+
+type workerKey struct{}
+
+// withWorker returns ctx carrying the worker's number, which picks its
+// proxy with per-worker rotation
+func withWorker(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, workerKey{}, id)
+}
@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// hostLimiter holds a rate limiter per host, each allowing rps requests
+// per second
+type hostLimiter struct {
+	mu    sync.Mutex
+	rps   float64
+	hosts map[string]*rate.Limiter
+}
+
+func newLimiter(rps float64) *hostLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &hostLimiter{rps: rps, hosts: make(map[string]*rate.Limiter)}
+}
+
+func (l *hostLimiter) forHost(host string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim, ok := l.hosts[host]
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(l.rps), 1)
+		l.hosts[host] = lim
+	}
+	return lim
+}
+
+// waitForLimiter blocks until host's limiter allows another request and
+// counts it towards the achieved rate. A wait that would run past ctx's
+// deadline is waited out until the deadline, as the limiter refuses it
+// before ctx is done and the caller would take it for a failure rather
+// than an interruption.
+func (c *Crawler) waitForLimiter(ctx context.Context, host string) error {
+	if c.limiter != nil {
+		if err := c.limiter.forHost(strings.ToLower(host)).Wait(ctx); err != nil {
+			if _, ok := ctx.Deadline(); ok {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+	c.requestCount.Add(1)
+	return nil
+}
+
+// achievedRate is the average number of requests per second since the
+// crawl started.
+func (c *Crawler) achievedRate() float64 {
+	elapsed := time.Since(c.crawlStart).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(c.requestCount.Load()) / elapsed
+}
+
+// roundRate rounds a rate to two decimals for the logs
+func roundRate(r float64) float64 {
+	return math.Round(r*100) / 100
+}
@@ -0,0 +1,57 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestWaitForLimiterDeadline waits for a request the limiter won't allow
+// before ctx's deadline: it returns once ctx is done, with ctx's error
+func TestWaitForLimiterDeadline(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.MaxRequestsPerSecond = 0.2 })
+	if err := c.waitForLimiter(context.Background(), "example.com"); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := c.waitForLimiter(ctx, "example.com")
+	if !errors.Is(err, context.DeadlineExceeded) || ctx.Err() == nil {
+		t.Errorf("waitForLimiter = %v with ctx error %v, want the deadline passed", err, ctx.Err())
+	}
+	if got := c.requestCount.Load(); got != 1 {
+		t.Errorf("counted %d requests, want 1", got)
+	}
+}
+
+// TestLimiterPastDeadline crawls a site at a rate the crawl's deadline
+// comes before the next request is allowed: the limiter holds the worker
+// until the deadline, and the links found are left pending, not failed
+func TestLimiterPastDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><a href="/a">a</a><a href="/b">b</a><a href="/c">c</a></html>`))
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.MaxRequestsPerSecond = 0.2
+		cfg.Deadline = 500 * time.Millisecond
+		cfg.ShutdownTimeout = 200 * time.Millisecond
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c.Run(ctx)
+	run := c.LastRun()
+	if run.Outcome != outcomeDeadline {
+		t.Errorf("outcome %q, want %q", run.Outcome, outcomeDeadline)
+	}
+	if run.Pages != 1 || run.Failed != 0 || run.Frontier == nil || run.Frontier.Pending != 3 || run.Frontier.Failed != 0 {
+		t.Errorf("fetched %d pages, %d failed, frontier %+v, want the three links left pending", run.Pages, run.Failed, run.Frontier)
+	}
+}
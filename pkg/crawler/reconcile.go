@@ -0,0 +1,111 @@
+package crawler
+
+import (
+	"io"
+	"net/url"
+)
+
+// reconcile repairs the frontier against the saved files before a run.
+// Scraped URLs whose file is missing, empty or not the size the manifest
+// recorded are queued again. If the previous run never ended, pages it
+// saved but died before marking are marked now, with their links stored
+// as if they had just been scraped.
+func (c *Crawler) reconcile(previous runRecord, hasPrevious bool) error {
+	bad := make(map[string]bool)
+	c.pageManifest.mu.Lock()
+	entries := make([]manifestEntry, 0, len(c.pageManifest.entries))
+	for _, e := range c.pageManifest.entries {
+		entries = append(entries, e)
+	}
+	c.pageManifest.mu.Unlock()
+	for _, e := range entries {
+		if !c.Frontier.IsScraped(e.URL) {
+			continue
+		}
+		size, ok := c.Storage.Exists(e.File)
+		if !ok || size == 0 || (e.Size > 0 && size != e.Size) {
+			bad[e.URL] = true
+		}
+	}
+	if len(bad) > 0 {
+		if err := c.Frontier.ForgetScraped(bad); err != nil {
+			return err
+		}
+	}
+
+	recovered := 0
+	if hasPrevious && previous.EndedAt.IsZero() {
+		var jobs []Job
+		for _, e := range c.Frontier.NextBatch(c.Frontier.Stats().Pending) {
+			jobs = append(jobs, Job{URL: e.URL, Depth: e.Depth, Referrer: e.Referrer, Source: e.Source, Chain: e.Chain})
+		}
+		for _, e := range c.pendingAssets() {
+			jobs = append(jobs, Job{URL: e.URL, Referrer: e.Referrer, Asset: true})
+		}
+		finals, err := c.loadFinalURLs()
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			e, ok := c.pageManifest.lookup(job.URL)
+			if !ok || bad[job.URL] || e.CheckedAt.Before(previous.StartedAt) {
+				continue
+			}
+			if size, ok := c.Storage.Exists(e.File); !ok || size == 0 || (e.Size > 0 && size != e.Size) {
+				continue
+			}
+			pageURL := job.URL
+			if final, ok := finals[job.URL]; ok {
+				pageURL = final
+			}
+			if err := c.recoverSaved(job, e, pageURL); err != nil {
+				c.log().Warn("could not recover saved file, fetching it again", "url", job.URL, "error", err)
+				continue
+			}
+			recovered++
+		}
+	}
+
+	if len(bad) > 0 || recovered > 0 {
+		c.log().Info("reconciled frontier", "requeued", len(bad), "recovered", recovered)
+	}
+	return nil
+}
+
+// recoverSaved finishes a page or asset whose file was saved by a run that
+// died before marking it, reading its links back from the file the way
+// scraping it would have. pageURL is where the page was served from.
+func (c *Crawler) recoverSaved(job Job, e manifestEntry, pageURL string) error {
+	var links []string
+	page := !job.Asset && isHTMLType(e.ContentType)
+	stylesheet := job.Asset && e.ContentType == "text/css"
+	if page || stylesheet {
+		f, err := c.Storage.LoadPage(e.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if page {
+			info, pageLinks, err := c.recordLinks(job.URL, pageContext{URL: pageURL}, f)
+			if err != nil {
+				return err
+			}
+			links = pageLinks
+			c.storeScriptLinks(job, info.ScriptLinks)
+			c.storePagination(job, info.Next)
+			c.storeTranslations(job, info)
+		} else {
+			css, err := io.ReadAll(f)
+			if err != nil {
+				return err
+			}
+			base, err := url.Parse(pageURL)
+			if err != nil {
+				return err
+			}
+			c.recordLinksFrom(c.assetURLsFile, cssReferences(base, string(css)), job.URL)
+		}
+	}
+	c.finishPage(job, links)
+	return nil
+}
@@ -0,0 +1,163 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReconcile crawls a site, damages the project the way a crash would,
+// and crawls it again: only what was lost is fetched a second time
+func TestReconcile(t *testing.T) {
+	site := map[string]string{
+		"/":  `<a href="/a">a</a><a href="/b">b</a>`,
+		"/a": `<a href="/c">c</a>`,
+		"/b": `b`,
+		"/c": `c`,
+	}
+	// removeLines drops the lines of a state file that start with prefix
+	removeLines := func(t *testing.T, path, prefix string) {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kept []string
+		for _, line := range strings.SplitAfter(string(b), "\n") {
+			if line != "" && !strings.HasPrefix(line, prefix) {
+				kept = append(kept, line)
+			}
+		}
+		if err := os.WriteFile(path, []byte(strings.Join(kept, "")), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		// crash damages the project c crawled, whose pages were saved as
+		// files, by path
+		crash func(t *testing.T, c *Crawler, base string, files map[string]string)
+		want  []string
+	}{
+		{
+			name:  "nothing lost",
+			crash: func(t *testing.T, c *Crawler, base string, files map[string]string) {},
+		},
+		{
+			name: "file deleted",
+			crash: func(t *testing.T, c *Crawler, base string, files map[string]string) {
+				if err := os.Remove(filepath.Join(c.downloadedFilesFolderName, files["/b"])); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"/b"},
+		},
+		{
+			name: "file truncated",
+			crash: func(t *testing.T, c *Crawler, base string, files map[string]string) {
+				if err := os.Truncate(filepath.Join(c.downloadedFilesFolderName, files["/a"]), 10); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"/a"},
+		},
+		{
+			name: "file emptied",
+			crash: func(t *testing.T, c *Crawler, base string, files map[string]string) {
+				if err := os.Truncate(filepath.Join(c.downloadedFilesFolderName, files["/c"]), 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"/c"},
+		},
+		{
+			// The run saved /a and died before marking it scraped or
+			// storing its link to /c
+			name: "died before marking",
+			crash: func(t *testing.T, c *Crawler, base string, files map[string]string) {
+				removeLines(t, c.scrapedUrlFileName, base+"/a\n")
+				removeLines(t, c.scrapedUrlFileName, base+"/c\n")
+				removeLines(t, c.foundUrlFileName, base+"/c\t")
+				f, err := os.OpenFile(c.runsFileName, os.O_APPEND|os.O_WRONLY, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				io.WriteString(f, `{"id":"crashed","started_at":"2000-01-01T00:00:00Z","config":{}}`+"\n")
+			},
+			want: []string{"/c"},
+		},
+		{
+			// Without the ledger saying the run never ended, a page that
+			// isn't marked is fetched again
+			name: "unmarked after a finished run",
+			crash: func(t *testing.T, c *Crawler, base string, files map[string]string) {
+				removeLines(t, c.scrapedUrlFileName, base+"/b\n")
+			},
+			want: []string{"/b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var fetched []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, ok := site[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				mu.Lock()
+				fetched = append(fetched, r.URL.Path)
+				mu.Unlock()
+				w.Header().Set("Content-Type", "text/html")
+				io.WriteString(w, "<html><body>"+body+"</body></html>")
+			}))
+			defer srv.Close()
+
+			folder := t.TempDir()
+			crawl := func() (*Crawler, map[string]string) {
+				c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+					cfg.ProjectFolder = folder
+					cfg.Workers = 1
+					cfg.RespectRobots = false
+					cfg.FrontierBackend = "text"
+				})
+				files := make(map[string]string)
+				c.OnPage = func(p PageResult) { files[p.URL[len(srv.URL):]] = p.File }
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := c.Run(ctx); err != nil {
+					t.Fatalf("crawling: %v", err)
+				}
+				return c, files
+			}
+			c, files := crawl()
+			if len(files) != len(site) {
+				t.Fatalf("first run saved %v, want the %d pages", files, len(site))
+			}
+			tt.crash(t, c, srv.URL, files)
+			mu.Lock()
+			fetched = nil
+			mu.Unlock()
+
+			c, _ = crawl()
+			sort.Strings(fetched)
+			if !reflect.DeepEqual(fetched, tt.want) {
+				t.Errorf("second run fetched %v, want %v", fetched, tt.want)
+			}
+			if stats := c.LastRun().Frontier; stats == nil || stats.Scraped != len(site) || stats.Pending != 0 {
+				t.Errorf("frontier after the second run = %+v, want all %d pages scraped", stats, len(site))
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package crawler
+
+import (
+	"net/http"
+	"time"
+)
+
+// conditionalHeaders returns the If-None-Match / If-Modified-Since headers
+// for a previously saved page, or nil when there is nothing to validate
+// against or the saved file is gone.
+func (c *Crawler) conditionalHeaders(e manifestEntry) http.Header {
+	if e.ETag == "" && e.LastModified == "" {
+		return nil
+	}
+	if _, ok := c.Storage.Exists(e.File); !ok {
+		return nil
+	}
+	h := http.Header{}
+	if e.ETag != "" {
+		h.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		h.Set("If-Modified-Since", e.LastModified)
+	}
+	return h
+}
+
+// startRecrawl forgets which URLs were scraped so they are all dispatched
+// again. Failed URLs stay failed unless RetryFailed is set too. With
+// MaxAge only the URLs fetched longer ago than that are forgotten; the
+// rest stay scraped and are skipped this run.
+func (c *Crawler) startRecrawl() error {
+	scraped := c.Frontier.Scraped()
+	if c.cfg.MaxAge <= 0 {
+		c.log().Info("recrawling scraped URLs", "count", len(scraped))
+		return c.Frontier.ForgetScraped(nil)
+	}
+	stale := make(map[string]bool)
+	now := time.Now()
+	for _, url := range scraped {
+		fetched := c.Frontier.FetchedAt(url)
+		if age := now.Sub(fetched); fetched.IsZero() || age >= c.cfg.MaxAge {
+			stale[url] = true
+		} else {
+			c.log().Debug("skipped", "reason", "fetched recently", "url", url, "age", age.Round(time.Second),
+				"max_age", c.cfg.MaxAge)
+		}
+	}
+	c.log().Info("recrawling stale URLs", "count", len(stale), "fresh", len(scraped)-len(stale), "max_age", c.cfg.MaxAge)
+	return c.Frontier.ForgetScraped(stale)
+}
+
+func (c *Crawler) printRecrawlSummary() {
+	c.log().Info("recrawl summary", "unchanged", c.pagesUnchanged.Load(), "updated", c.pagesUpdated.Load(), "new", c.pagesNew.Load())
+}
@@ -0,0 +1,118 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// redirectHop is one redirect followed while fetching a page: the URL that
+// answered and the status it answered with.
+type redirectHop struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// redirectChain collects the hops of a single fetch. The HTTP client only
+// reports redirects through CheckRedirect, so the chain travels to it in
+// the request's context.
+type redirectChain struct {
+	mu   sync.Mutex
+	hops []redirectHop
+}
+
+type redirectChainKey struct{}
+
+func withRedirectChain(ctx context.Context) (context.Context, *redirectChain) {
+	chain := &redirectChain{}
+	return context.WithValue(ctx, redirectChainKey{}, chain), chain
+}
+
+func redirectChainFrom(ctx context.Context) *redirectChain {
+	chain, _ := ctx.Value(redirectChainKey{}).(*redirectChain)
+	return chain
+}
+
+func (c *redirectChain) add(hop redirectHop) {
+	c.mu.Lock()
+	c.hops = append(c.hops, hop)
+	c.mu.Unlock()
+}
+
+// reset forgets hops from an earlier attempt at the same URL
+func (c *redirectChain) reset() {
+	c.mu.Lock()
+	c.hops = nil
+	c.mu.Unlock()
+}
+
+func (c *redirectChain) list() []redirectHop {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]redirectHop(nil), c.hops...)
+}
+
+const maxRedirects = 10
+
+// errTooManyRedirects usually means a redirect loop
+var errTooManyRedirects = fmt.Errorf("redirect loop or more than %d redirects", maxRedirects)
+
+// offSiteRedirectError stops a fetch that redirects out of scope
+type offSiteRedirectError struct {
+	to string
+}
+
+func (e *offSiteRedirectError) Error() string {
+	return "redirected out of scope to " + e.to
+}
+
+// checkRedirect follows up to maxRedirects redirects. Page fetches carry a
+// redirect chain, which records each hop and keeps them in scope, the
+// https:// twin of the last one counting as in scope with NormalizeScheme;
+// robots.txt and sitemaps may be served from anywhere.
+func (c *Crawler) checkRedirect(req *http.Request, via []*http.Request) error {
+	if chain := redirectChainFrom(req.Context()); chain != nil {
+		prev := via[len(via)-1].URL
+		if req.Response != nil {
+			chain.add(redirectHop{URL: prev.String(), Status: req.Response.StatusCode})
+			c.noteSchemeRedirect(prev, req.URL, req.Response.StatusCode)
+		}
+		upgrade := c.cfg.NormalizeScheme && prev.Scheme == "http" && isSchemeUpgrade(prev, req.URL)
+		if !upgrade && !c.inScope(req.URL.String()) {
+			return &offSiteRedirectError{to: req.URL.String()}
+		}
+	}
+	if len(via) >= maxRedirects {
+		return errTooManyRedirects
+	}
+	return nil
+}
+
+// recordRedirect notes in the redirects file that source ended up at
+// destination, with an optional note such as "out-of-scope"
+func (c *Crawler) recordRedirect(source, destination, note string) {
+	line := source + "\t" + destination
+	if note != "" {
+		line += "\t" + note
+	}
+	_, _ = c.redirectsFile.add(line)
+}
+
+// recordRefresh notes the redirect a page's meta refresh or script makes,
+// as recordRedirect does those the server makes. The target is crawled
+// like any link on the page.
+func (c *Crawler) recordRefresh(source string, info *pageInfo) {
+	target := info.Refresh
+	if normalized, err := c.normalizeURL(target); err == nil {
+		target = normalized
+	}
+	if target == source {
+		return
+	}
+	note := info.RefreshKind
+	if !c.inScope(target) {
+		note += ",out-of-scope"
+	}
+	c.recordRedirect(source, target, note)
+}
@@ -0,0 +1,25 @@
+package crawler
+
+// wholeLine keys referrer lines by the URL and referrer pair, so the same
+// link seen on two pages is kept twice but never more
+func wholeLine(line string) string {
+	return line
+}
+
+// recordLinksFrom adds each of links with the page it was found on to f,
+// for URLs that are recorded but never crawled: the external URLs file,
+// which is the outbound link graph, and the asset inventory.
+func (c *Crawler) recordLinksFrom(f *stateFile, links []string, referrer string) {
+	for _, link := range links {
+		if normalized, err := c.normalizeURL(link); err == nil {
+			link = normalized
+		}
+		_, _ = f.add(link + "\t" + referrer)
+	}
+}
+
+func (c *Crawler) recordReferrer(url, referrer string) {
+	if c.cfg.RecordAllReferrers {
+		_, _ = c.referrersFile.add(url + "\t" + referrer)
+	}
+}
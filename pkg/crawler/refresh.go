@@ -0,0 +1,103 @@
+package crawler
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The notes a redirect found in a page gets in the redirects file
+const (
+	refreshMeta   = "meta-refresh"
+	refreshScript = "script"
+)
+
+// parseRefresh splits the content of <meta http-equiv="refresh"> into the
+// delay and the target, which is empty for a page that only reloads
+// itself. Like browsers it takes "5", "0;url=/new", "0; URL='/new'",
+// "0, url=/new" and "0;/new".
+func parseRefresh(content string) (delay time.Duration, target string, ok bool) {
+	s := strings.TrimSpace(content)
+	end := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end < 0 {
+		end = len(s)
+	}
+	seconds, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil || seconds < 0 {
+		return 0, "", false
+	}
+	delay = time.Duration(seconds * float64(time.Second))
+	s = strings.TrimLeft(s[end:], " \t\n\r\f")
+	if s == "" {
+		return delay, "", true
+	}
+	if s[0] != ';' && s[0] != ',' {
+		return 0, "", false
+	}
+	s = strings.TrimLeft(s[1:], " \t\n\r\f")
+	if len(s) >= 3 && strings.EqualFold(s[:3], "url") {
+		if rest := strings.TrimLeft(s[3:], " \t\n\r\f"); strings.HasPrefix(rest, "=") {
+			s = strings.TrimLeft(rest[1:], " \t\n\r\f")
+		}
+	}
+	if s != "" && (s[0] == '\'' || s[0] == '"') {
+		quote := s[0]
+		s = s[1:]
+		if i := strings.IndexByte(s, quote); i >= 0 {
+			s = s[:i]
+		}
+	}
+	return delay, strings.TrimSpace(s), true
+}
+
+// metaRefresh returns the target of the page's first refresh tag that
+// leaves the page within maxDelay. Longer delays are left alone, as they
+// are usually slideshows or pages that reload themselves.
+func metaRefresh(doc *goquery.Document, maxDelay time.Duration) string {
+	target := ""
+	doc.Find("meta[http-equiv]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if equiv, _ := s.Attr("http-equiv"); !strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+			return true
+		}
+		content, _ := s.Attr("content")
+		delay, t, ok := parseRefresh(content)
+		if !ok || t == "" || delay > maxDelay {
+			return true
+		}
+		target = t
+		return false
+	})
+	return target
+}
+
+// scriptRedirectPatterns match the one-line redirects of inline scripts:
+// location = "...", window.location.href = '...' and location.replace("..."),
+// with a string literal for the URL. Anything computed is beyond them.
+var scriptRedirectPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?:^|[^\w$.])(?:(?:window|document|self|top)\.)?location(?:\.href)?\s*=\s*["']([^"'\n]+)["']`),
+	regexp.MustCompile(`(?:^|[^\w$.])(?:(?:window|document|self|top)\.)?location(?:\.href)?\.(?:replace|assign)\(\s*["']([^"'\n]+)["']\s*\)`),
+}
+
+// scriptRedirect returns the URL the page's first inline script that sets
+// the location sends the browser to
+func scriptRedirect(doc *goquery.Document) string {
+	target := ""
+	doc.Find("script:not([src])").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		code := s.Text()
+		first := -1
+		for _, re := range scriptRedirectPatterns {
+			if m := re.FindStringSubmatchIndex(code); m != nil && (first < 0 || m[0] < first) {
+				first, target = m[0], strings.ReplaceAll(code[m[2]:m[3]], `\/`, "/")
+			}
+		}
+		// Only the fragment changes: the page stays where it is
+		if strings.HasPrefix(target, "#") {
+			target = ""
+		}
+		return target == ""
+	})
+	return target
+}
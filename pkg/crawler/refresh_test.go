@@ -0,0 +1,154 @@
+package crawler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestParseRefresh(t *testing.T) {
+	tests := []struct {
+		content string
+		delay   time.Duration
+		target  string
+		ok      bool
+	}{
+		{"0", 0, "", true},
+		{"5", 5 * time.Second, "", true},
+		{"0;url=/new", 0, "/new", true},
+		{"0; URL=/new", 0, "/new", true},
+		{"0;Url=/new", 0, "/new", true},
+		{"3;url=https://example.com/new?a=1", 3 * time.Second, "https://example.com/new?a=1", true},
+		{"0;url='/quoted'", 0, "/quoted", true},
+		{`0;url="/double quoted"`, 0, "/double quoted", true},
+		{`0;url="/unclosed`, 0, "/unclosed", true},
+		{"0;url='/quoted' trailing", 0, "/quoted", true},
+		{"  1  ;  url  =  /spaced  ", time.Second, "/spaced", true},
+		{"0,url=/comma", 0, "/comma", true},
+		{"0;/bare", 0, "/bare", true},
+		{"0;new.html", 0, "new.html", true},
+		{"0;url", 0, "url", true},
+		{"0.5;url=/half", 500 * time.Millisecond, "/half", true},
+		{"10;\turl=/tab", 10 * time.Second, "/tab", true},
+		{"0;", 0, "", true},
+		{"", 0, "", false},
+		{"url=/new", 0, "", false},
+		{"-1;url=/new", 0, "", false},
+		{"0 url=/new", 0, "", false},
+		{"soon;url=/new", 0, "", false},
+	}
+	for _, tt := range tests {
+		delay, target, ok := parseRefresh(tt.content)
+		if delay != tt.delay || target != tt.target || ok != tt.ok {
+			t.Errorf("parseRefresh(%q) = %s, %q, %v, want %s, %q, %v", tt.content, delay, target, ok, tt.delay, tt.target, tt.ok)
+		}
+	}
+}
+
+// TestMetaRefresh takes the first refresh tag that leaves the page within
+// the delay
+func TestMetaRefresh(t *testing.T) {
+	tests := []struct {
+		name, head string
+		want       string
+	}{
+		{"none", ``, ""},
+		{"immediate", `<meta http-equiv="refresh" content="0;url=/new">`, "/new"},
+		{"at the limit", `<meta http-equiv="refresh" content="2;url=/new">`, "/new"},
+		{"past the limit", `<meta http-equiv="refresh" content="30;url=/slide/2">`, ""},
+		{"reload only", `<meta http-equiv="refresh" content="0">`, ""},
+		{"odd case and spacing", `<meta http-equiv=" REFRESH " content="0; URL = '/new'">`, "/new"},
+		{"other http-equiv", `<meta http-equiv="content-type" content="0;url=/new">`, ""},
+		{"first that leaves", `<meta http-equiv="refresh" content="60;url=/late"><meta http-equiv="refresh" content="1;url=/soon">`, "/soon"},
+		{"unparsable", `<meta http-equiv="refresh" content="url=/new">`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head>` + tt.head + `</head></html>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := metaRefresh(doc, 2*time.Second); got != tt.want {
+				t.Errorf("metaRefresh = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptRedirect(t *testing.T) {
+	tests := []struct {
+		name, script string
+		want         string
+	}{
+		{"location", `location = "/new";`, "/new"},
+		{"window.location", `window.location='https://example.com/new'`, "https://example.com/new"},
+		{"location.href", `document.location.href = "new.html";`, "new.html"},
+		{"replace", `window.location.replace( '/replaced' );`, "/replaced"},
+		{"assign", `top.location.assign("/assigned")`, "/assigned"},
+		{"escaped slashes", `location.href = "https:\/\/example.com\/new"`, "https://example.com/new"},
+		{"first in the script", `location.replace("/first"); location = "/second";`, "/first"},
+		{"fragment only", `location.href = "#section"`, ""},
+		{"computed", `location.href = base + "/new";`, ""},
+		{"another object's location", `myLocation = "/nope"; obj.location = "/nope";`, ""},
+		{"read, not set", `if (location.href == "/old") {}`, ""},
+		{"none", `console.log("hi")`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head><script>` + tt.script + `</script></head></html>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := scriptRedirect(doc); got != tt.want {
+				t.Errorf("scriptRedirect(%s) = %q, want %q", tt.script, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("external and later scripts", func(t *testing.T) {
+		doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<html><head>` +
+			`<script src="/app.js">location = "/external";</script>` +
+			`<script>location.hash = "#top"; location.href = "#top";</script>` +
+			`<script>location = "/inline";</script></head></html>`))
+		if got := scriptRedirect(doc); got != "/inline" {
+			t.Errorf("scriptRedirect = %q, want the first inline script's that leaves the page", got)
+		}
+	})
+}
+
+// TestRefreshResolved has the target a page moves on to resolved against
+// the page, relative or absolute, and followed like a link
+func TestRefreshResolved(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", func(cfg *Config) {
+		cfg.MetaRefresh = true
+		cfg.ScriptRedirects = true
+	})
+	const page = "https://example.com/docs/old/"
+	tests := []struct {
+		name, head string
+		want, kind string
+	}{
+		{"relative", `<meta http-equiv="refresh" content="0;url=../new/">`, "https://example.com/docs/new/", refreshMeta},
+		{"root relative", `<meta http-equiv="refresh" content="0; URL='/new'">`, "https://example.com/new", refreshMeta},
+		{"absolute", `<meta http-equiv="refresh" content="1;url=https://example.com/moved#top">`, "https://example.com/moved", refreshMeta},
+		{"script", `<script>location.replace("next.html")</script>`, "https://example.com/docs/old/next.html", refreshScript},
+		{"meta before script", `<meta http-equiv="refresh" content="0;url=/meta"><script>location = "/script"</script>`, "https://example.com/meta", refreshMeta},
+		{"too slow", `<meta http-equiv="refresh" content="600;url=/later">`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := c.extractLinksFromHTML(pageContext{URL: page}, strings.NewReader(`<html><head>`+tt.head+`</head></html>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Refresh != tt.want || info.RefreshKind != tt.kind {
+				t.Errorf("refresh %q (%s), want %q (%s)", info.Refresh, info.RefreshKind, tt.want, tt.kind)
+			}
+			if tt.want != "" && !strings.Contains(strings.Join(info.Links, " "), tt.want) {
+				t.Errorf("links %q, want the refresh target followed", info.Links)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// chromeNames are the executables looked for on PATH when ChromePath is
+// empty, in order
+var chromeNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome", "headless-shell"}
+
+// chromeLocations are where Chrome lives when it isn't on PATH
+var chromeLocations = []string{
+	"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+	"/Applications/Chromium.app/Contents/MacOS/Chromium",
+	`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+	`C:\Program Files (x86)\Google\Chrome\Application\chrome.exe`,
+}
+
+// appRootSelectors are the elements single-page apps mount into
+const appRootSelectors = "#root, #app, #__next, #__nuxt, #___gatsby, [data-reactroot], app-root"
+
+// errNoChrome is logged once when rendering is set up without Chrome
+var errNoChrome = errors.New("no Chrome or Chromium found; set CHROME_PATH")
+
+// renderer loads pages in headless Chrome, RenderWorkers at a time, and
+// returns the DOM they end up with. Each page gets a Chrome of its own,
+// run with --dump-dom, so a page that hangs or crashes it takes nothing
+// else down; the same run takes the page's screenshot.
+type renderer struct {
+	chrome string
+	slots  chan struct{}
+	cfg    *Config
+}
+
+// newRenderer returns nil unless RenderPatterns or RenderMinLinks is set.
+// Without Chrome the renderer has an empty chrome and pages are saved as
+// served.
+func newRenderer(cfg *Config) *renderer {
+	if len(cfg.RenderPatterns) == 0 && cfg.RenderMinLinks <= 0 {
+		return nil
+	}
+	return &renderer{chrome: findChrome(cfg.ChromePath), slots: make(chan struct{}, cfg.RenderWorkers), cfg: cfg}
+}
+
+func findChrome(configured string) string {
+	if configured != "" {
+		if p, err := exec.LookPath(configured); err == nil {
+			return p
+		}
+		return ""
+	}
+	for _, name := range chromeNames {
+		if p, err := exec.LookPath(name); err == nil {
+			return p
+		}
+	}
+	for _, p := range chromeLocations {
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+// matches reports whether rawURL is one of RenderPatterns, always
+// rendered
+func (r *renderer) matches(rawURL string) bool {
+	for _, re := range r.cfg.RenderPatterns {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// shell reports whether a page as served has fewer than RenderMinLinks
+// links and looks like an app that hasn't been mounted
+func (r *renderer) shell(info *pageInfo) bool {
+	if r.cfg.RenderMinLinks <= 0 || len(info.Links)+len(info.Frames) >= r.cfg.RenderMinLinks {
+		return false
+	}
+	return emptyApp(info.doc)
+}
+
+// emptyApp reports whether a page is the shell of a single-page app: it
+// has an app root element with nothing in it, or next to no text besides
+// the scripts meant to fill it
+func emptyApp(doc *goquery.Document) bool {
+	empty := false
+	doc.Find(appRootSelectors).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		empty = strings.TrimSpace(s.Text()) == "" && s.Find("a[href]").Length() == 0
+		return !empty
+	})
+	if empty {
+		return true
+	}
+	body := doc.Find("body").First().Clone()
+	body.Find("script, style, noscript, template").Remove()
+	return doc.Find("script").Length() > 0 && len(strings.TrimSpace(body.Text())) < 200
+}
+
+// rendering is what Chrome made of a page: its serialized DOM and, with
+// Screenshots, the PNG it took in the same run or why it took none
+type rendering struct {
+	dom     []byte
+	shot    []byte
+	shotErr error
+}
+
+// render loads rawURL in Chrome and returns the serialized DOM, and with
+// Screenshots a screenshot of it from the same run. Chrome is given
+// RenderWait of virtual time for the scripts and requests to settle; with
+// RenderWaitSelector it is run again with twice the budget until the
+// selector matches or RenderTimeout is up.
+func (r *renderer) render(ctx context.Context, rawURL, userAgent string) (*rendering, error) {
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.RenderTimeout)
+	defer cancel()
+	for budget := r.cfg.RenderWait; ; budget *= 2 {
+		page, err := r.load(ctx, rawURL, userAgent, budget)
+		if err != nil {
+			return nil, err
+		}
+		if r.cfg.RenderWaitSelector == "" {
+			return page, nil
+		}
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.dom))
+		if err == nil && doc.Find(r.cfg.RenderWaitSelector).Length() > 0 {
+			return page, nil
+		}
+		if deadline, _ := ctx.Deadline(); time.Until(deadline) < 2*budget {
+			return nil, fmt.Errorf("%q did not appear within %s", r.cfg.RenderWaitSelector, r.cfg.RenderTimeout)
+		}
+	}
+}
+
+// acquire waits for one of the RenderWorkers slots, returning the func
+// that gives it back
+func (r *renderer) acquire(ctx context.Context) (func(), error) {
+	select {
+	case r.slots <- struct{}{}:
+		return func() { <-r.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load runs Chrome once with --dump-dom, and with Screenshots --screenshot
+// too, which headless Chrome takes after dumping the DOM
+func (r *renderer) load(ctx context.Context, rawURL, userAgent string, budget time.Duration) (*rendering, error) {
+	profile, err := os.MkdirTemp("", "sws-chrome-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(profile)
+	args := r.args(profile, userAgent, budget)
+	file := filepath.Join(profile, "screenshot.png")
+	if r.cfg.Screenshots {
+		args = append(args, fmt.Sprintf("--window-size=%d,%d", r.cfg.ScreenshotWidth, screenshotWindowHeight(r.cfg)), "--screenshot="+file)
+	}
+	args = append(args, "--dump-dom", rawURL)
+	dom, stderr, err := r.run(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(dom) == 0 {
+		return nil, fmt.Errorf("chrome returned no DOM: %s", lastLine(stderr))
+	}
+	page := &rendering{dom: dom}
+	if r.cfg.Screenshots {
+		if page.shot, err = os.ReadFile(file); err != nil || len(page.shot) == 0 {
+			page.shot, page.shotErr = nil, fmt.Errorf("chrome wrote no screenshot: %s", lastLine(stderr))
+		}
+	}
+	return page, nil
+}
+
+// args are the flags every headless Chrome is run with, profile being a
+// fresh user data folder for it
+func (r *renderer) args(profile, userAgent string, budget time.Duration) []string {
+	args := []string{
+		"--headless", "--disable-gpu", "--no-first-run", "--no-default-browser-check",
+		"--disable-extensions", "--mute-audio", "--hide-scrollbars",
+		"--user-data-dir=" + profile,
+		fmt.Sprintf("--virtual-time-budget=%d", budget.Milliseconds()),
+	}
+	// Chrome refuses to sandbox itself as root, as in most containers
+	if os.Geteuid() == 0 {
+		args = append(args, "--no-sandbox")
+	}
+	if userAgent != "" {
+		args = append(args, "--user-agent="+userAgent)
+	}
+	return args
+}
+
+// run runs Chrome with args, returning what it wrote to stdout and the
+// stderr to explain it
+func (r *renderer) run(ctx context.Context, args []string) ([]byte, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.chrome, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, "", fmt.Errorf("rendering timed out after %s", r.cfg.RenderTimeout)
+		}
+		return nil, "", fmt.Errorf("chrome: %w: %s", err, lastLine(stderr.String()))
+	}
+	return stdout.Bytes(), stderr.String(), nil
+}
+
+// lastLine is the last non-empty line of s, where Chrome puts the error
+// that matters
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// renderPage replaces the saved file of a page that wants rendering with
+// the DOM Chrome renders for it, returning its size and hash. ok is false
+// when the page is kept as served, because it didn't want rendering or
+// rendering it failed, which is logged. With Screenshots the screenshot
+// of the same run is saved too and recorded in record.
+func (c *Crawler) renderPage(ctx context.Context, job Job, pc pageContext, fileName string, meta PageMeta, userAgent string, record *pageRecord) (size int64, sum string, ok bool, err error) {
+	if c.renderer.chrome == "" {
+		return 0, "", false, nil
+	}
+	if !c.renderer.matches(job.URL) {
+		saved, err := c.Storage.LoadPage(fileName)
+		if err != nil {
+			return 0, "", false, err
+		}
+		info, err := c.extractLinksFromHTML(pc, saved)
+		saved.Close()
+		if err != nil || !c.renderer.shell(info) {
+			return 0, "", false, nil
+		}
+	}
+	log := c.logFrom(ctx)
+	page, err := c.renderer.render(ctx, pc.URL, userAgent)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, "", false, ctx.Err()
+		}
+		log.Warn("not rendered, keeping the page as served", "error", err)
+		return 0, "", false, nil
+	}
+	hashed := newHashingReader(bytes.NewReader(page.dom))
+	if size, err = c.Storage.SavePage(fileName, meta, hashed); err != nil {
+		return 0, "", false, err
+	}
+	log.Debug("rendered", "bytes", size)
+	if c.cfg.Screenshots {
+		if record.Screenshot, record.ScreenshotWidth, record.ScreenshotHeight, err = c.saveScreenshot(ctx, job, fileName, page); err != nil {
+			return 0, "", false, err
+		}
+	}
+	return size, hashed.sum(), true, nil
+}
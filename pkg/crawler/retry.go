@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// statusError is returned for any response other than 200 OK
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("bad status code: %d", e.code)
+}
+
+// backoff returns the wait before the given retry (1-based): the base delay
+// doubled per attempt, plus up to one base delay of jitter so workers that
+// failed together don't retry together.
+func (c *Crawler) backoff(retry int) time.Duration {
+	d := c.cfg.RetryBaseDelay << (retry - 1)
+	if c.cfg.RetryBaseDelay > 0 {
+		d += time.Duration(rand.Int63n(int64(c.cfg.RetryBaseDelay)))
+	}
+	return d
+}
+
+// fetchWithRetry GETs url with any extra headers, retrying the failures
+// retryable allows. A 429 or 503 pauses the host and returns a
+// *throttledError instead of retrying; other failures come back as a
+// *fetchError. A 304 counts as success, and on success the caller owns the
+// response body.
+func (c *Crawler) fetchWithRetry(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	return c.requestWithRetry(ctx, http.MethodGet, url, header)
+}
+
+// requestWithRetry is fetchWithRetry with another method, such as HEAD
+func (c *Crawler) requestWithRetry(ctx context.Context, method, url string, header http.Header) (*http.Response, error) {
+	var lastErr error
+	attempts := 0
+	for attempt := 1; attempt <= c.cfg.MaxAttempts; attempt++ {
+		attempts = attempt
+		if attempt > 1 {
+			wait := c.backoff(attempt - 1)
+			c.logFrom(ctx).Warn("retrying", "in", wait.Round(time.Millisecond), "attempt", attempt,
+				"max_attempts", c.cfg.MaxAttempts, "error", lastErr)
+			if err := sleepCtx(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+
+		if chain := redirectChainFrom(ctx); chain != nil {
+			chain.reset()
+		}
+		req, err := http.NewRequestWithContext(c.conns.trace(ctx), method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", c.userAgent(ctx))
+		c.setRequestHeaders(req)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		host := req.URL.Host
+		if err := c.throttle.wait(ctx, host); err != nil {
+			return nil, err
+		}
+		if err := c.waitForLimiter(ctx, host); err != nil {
+			return nil, err
+		}
+		sent := time.Now()
+		resp, err := c.httpClient.Do(req)
+		code := 0
+		if err == nil {
+			code = resp.StatusCode
+		}
+		c.gate.observe(time.Since(sent), isPushback(code, err))
+		if err == nil && isThrottleStatus(resp.StatusCode) {
+			resp.Body.Close()
+			delay := c.throttle.pause(host, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+			return nil, &throttledError{code: resp.StatusCode, delay: delay}
+		}
+		if err == nil && resp.StatusCode != 200 && resp.StatusCode != http.StatusNotModified {
+			resp.Body.Close()
+			err = &statusError{code: resp.StatusCode}
+		}
+		if err == nil {
+			c.throttle.reset(host)
+			resp.Body = c.bandwidth.wrap(ctx, resp.Body)
+			return resp, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil || !retryable(err) {
+			break
+		}
+	}
+	return nil, &fetchError{class: classify(lastErr), attempts: attempts, err: lastErr}
+}
+
+// recordFailure moves url out of the crawl by adding it to the failed
+// list, with the page that linked to it (possibly empty), and records it
+// in failed_urls.jsonl with its class
+func (c *Crawler) recordFailure(url, referrer string, err error) error {
+	c.pagesFailed.Add(1)
+	class, attempts := classify(err), 1
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		attempts = fe.attempts
+	}
+	d := deadLetter{URL: url, Class: class, Attempts: attempts, Error: err.Error(), Referrer: referrer, At: time.Now().UTC()}
+	c.failures.add(d)
+	if c.deadLetters != nil {
+		_ = c.deadLetters.write(d)
+	}
+	if c.OnError != nil {
+		c.OnError(url, err)
+	}
+	return c.Frontier.MarkFailed(FailedURL{URL: url, Reason: err.Error(), Referrer: referrer, At: time.Now()})
+}
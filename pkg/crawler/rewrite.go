@@ -0,0 +1,406 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// rewrittenAttrs are the attributes holding URLs, by tag
+var rewrittenAttrs = map[string][]string{
+	"a":      {"href"},
+	"area":   {"href"},
+	"link":   {"href"},
+	"base":   {"href"},
+	"img":    {"src", "srcset"},
+	"source": {"src", "srcset"},
+	"script": {"src"},
+	"iframe": {"src"},
+	"frame":  {"src"},
+}
+
+// offlineName is the file a page's browsable copy is written to, next to
+// the saved original
+func offlineName(file string) string {
+	ext := path.Ext(file)
+	return strings.TrimSuffix(file, ext) + ".offline" + ext
+}
+
+// localTarget returns where a saved URL lives in the offline mirror,
+// relative to the download folder
+func localTarget(e manifestEntry) string {
+	if isHTMLType(e.ContentType) {
+		return offlineName(e.File)
+	}
+	return e.File
+}
+
+// loadFinalURLs maps each URL in pages.jsonl to the URL its page was
+// actually served from, for resolving relative links after a redirect
+func (c *Crawler) loadFinalURLs() (map[string]string, error) {
+	finals := make(map[string]string)
+	f, err := os.Open(c.pagesFileName)
+	if os.IsNotExist(err) {
+		return finals, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var r pageRecord
+		if json.Unmarshal(scanner.Bytes(), &r) == nil && r.FinalURL != "" {
+			finals[r.URL] = r.FinalURL
+		}
+	}
+	return finals, scanner.Err()
+}
+
+// rewriteSavedPages writes an .offline.html copy of every saved page with
+// its in-scope links pointed at the local files from the manifest, using
+// relative paths so the download folder can be moved as a whole. The
+// originals are left alone, since their sizes and hashes are in the
+// manifest.
+func (c *Crawler) rewriteSavedPages() error {
+	finals, err := c.loadFinalURLs()
+	if err != nil {
+		return err
+	}
+	c.pageManifest.mu.Lock()
+	var pages []manifestEntry
+	done := make(map[string]bool)
+	for _, e := range c.pageManifest.entries {
+		if isHTMLType(e.ContentType) && e.DuplicateOf == "" && !done[e.File] {
+			done[e.File] = true
+			pages = append(pages, e)
+		}
+	}
+	c.pageManifest.mu.Unlock()
+
+	rewritten := 0
+	for _, e := range pages {
+		pageURL := e.URL
+		if final, ok := finals[e.URL]; ok {
+			pageURL = final
+		}
+		doc, err := c.Storage.LoadPage(e.File)
+		if err != nil {
+			c.log().Warn("skipped page", "file", e.File, "error", err)
+			continue
+		}
+		var out bytes.Buffer
+		err = rewriteHTML(&out, doc, pageURL, c.offlineMapper(path.Dir(e.File)))
+		doc.Close()
+		if err != nil {
+			c.log().Warn("skipped page", "file", e.File, "error", err)
+			continue
+		}
+		meta := PageMeta{URL: e.URL, ContentType: e.ContentType}
+		if _, err := c.Storage.SavePage(offlineName(e.File), meta, &out); err != nil {
+			return err
+		}
+		rewritten++
+	}
+	c.log().Info("rewrote links", "pages", rewritten)
+	return nil
+}
+
+// urlMapper returns what an absolute URL found in a page should become, or
+// false to leave the attribute as written
+type urlMapper func(u *url.URL) (string, bool)
+
+// offlineMapper points URLs at local files from the manifest, relative to
+// pageDir inside the download folder, and handles the rest according to
+// REWRITE_UNCRAWLED
+func (c *Crawler) offlineMapper(pageDir string) urlMapper {
+	return func(u *url.URL) (string, bool) {
+		key := u.String()
+		if normalized, err := c.normalizeURL(key); err == nil {
+			key = normalized
+		}
+		if e, ok := c.pageManifest.lookup(key); ok {
+			return relativePath(pageDir, localTarget(e)), true
+		}
+		if c.cfg.RewriteUncrawled == "live" {
+			return u.String(), true
+		}
+		return "", false
+	}
+}
+
+// rewriteHTML copies an HTML document to w, replacing only the values of
+// URL attributes with what mapURL makes of them. Everything else,
+// including whitespace, comments and how the attributes were quoted, is
+// copied byte for byte. Links resolve against the page's <base href> when
+// it has one; that href is dropped, as the copy no longer lives there, and
+// the relative links mapURL leaves alone are made absolute so they still
+// go where they went.
+func rewriteHTML(w io.Writer, r io.Reader, pageURL string, mapURL urlMapper) error {
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return err
+	}
+	doc, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	base := page
+	if href, ok := baseHref(doc); ok {
+		if ref, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			base = page.ResolveReference(ref)
+			mapURL = orLive(mapURL)
+		}
+	}
+	z := html.NewTokenizer(bytes.NewReader(doc))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		}
+		raw := z.Raw()
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			if _, err := w.Write(raw); err != nil {
+				return err
+			}
+			continue
+		}
+		// TagName lowercases the name in the buffer Raw returned
+		tag := string(raw)
+		name, _ := z.TagName()
+		attrs := rewrittenAttrs[string(name)]
+		if len(attrs) == 0 {
+			if _, err := io.WriteString(w, tag); err != nil {
+				return err
+			}
+			continue
+		}
+
+		spans := attributeSpans(tag)
+		var b strings.Builder
+		last := 0
+		for _, sp := range spans {
+			if !containsFold(attrs, sp.name) {
+				continue
+			}
+			if string(name) == "base" {
+				b.WriteString(strings.TrimRight(tag[last:sp.nameStart], " \t\n\r\f"))
+				last = sp.end
+				if sp.quote != 0 {
+					last++
+				}
+				continue
+			}
+			value := html.UnescapeString(tag[sp.start:sp.end])
+			var replaced string
+			if sp.name == "srcset" {
+				replaced = rewriteSrcset(value, base, mapURL)
+			} else {
+				replaced = rewriteURL(value, base, mapURL)
+			}
+			if replaced == value {
+				continue
+			}
+			b.WriteString(tag[last:sp.start])
+			b.WriteString(quoteAttr(replaced, sp.quote))
+			last = sp.end
+		}
+		b.WriteString(tag[last:])
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+}
+
+// baseHref is the href of the first <base> that has one, which is the one
+// browsers use
+func baseHref(doc []byte) (string, bool) {
+	z := html.NewTokenizer(bytes.NewReader(doc))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return "", false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "base" {
+				continue
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "href" {
+					return string(val), true
+				}
+			}
+		}
+	}
+}
+
+// orLive maps what mapURL leaves alone to its absolute URL
+func orLive(mapURL urlMapper) urlMapper {
+	return func(u *url.URL) (string, bool) {
+		if local, ok := mapURL(u); ok {
+			return local, true
+		}
+		return u.String(), true
+	}
+}
+
+// rewriteURL resolves one attribute value against the page and maps it,
+// keeping any fragment
+func rewriteURL(value string, page *url.URL, mapURL urlMapper) string {
+	ref, err := url.Parse(strings.TrimSpace(value))
+	if err != nil || strings.HasPrefix(value, "#") {
+		return value
+	}
+	resolved := page.ResolveReference(ref)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return value
+	}
+	fragment := resolved.Fragment
+	resolved.Fragment = ""
+	local, ok := mapURL(resolved)
+	if !ok {
+		return value
+	}
+	if fragment != "" {
+		local += "#" + fragment
+	}
+	return local
+}
+
+// rewriteSrcset rewrites each candidate URL of a srcset, keeping the
+// descriptors. The value is only reformatted if some URL changed.
+func rewriteSrcset(value string, page *url.URL, mapURL urlMapper) string {
+	candidates := strings.Split(value, ",")
+	changed := false
+	for i, c := range candidates {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		if local := rewriteURL(fields[0], page, mapURL); local != fields[0] {
+			fields[0] = local
+			changed = true
+		}
+		candidates[i] = strings.Join(fields, " ")
+	}
+	if !changed {
+		return value
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// relativePath returns target, a slash path inside the download folder, as
+// seen from the folder dir
+func relativePath(dir, target string) string {
+	if dir == "." || dir == "" {
+		return target
+	}
+	up := strings.Repeat("../", strings.Count(path.Clean(dir), "/")+1)
+	return up + target
+}
+
+// attrSpan locates an attribute and its value inside a raw tag. quote is
+// the quote character around the value, or 0 for an unquoted value.
+type attrSpan struct {
+	name       string
+	nameStart  int
+	start, end int
+	quote      byte
+}
+
+// attributeSpans scans a raw start tag and returns where each attribute's
+// value sits in it. Attributes without a value are skipped.
+func attributeSpans(tag string) []attrSpan {
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' }
+	i := 1
+	for i < len(tag) && !isSpace(tag[i]) && tag[i] != '>' && tag[i] != '/' {
+		i++
+	}
+	var spans []attrSpan
+	for i < len(tag) {
+		for i < len(tag) && (isSpace(tag[i]) || tag[i] == '/') {
+			i++
+		}
+		if i >= len(tag) || tag[i] == '>' {
+			break
+		}
+		nameStart := i
+		for i < len(tag) && !isSpace(tag[i]) && tag[i] != '=' && tag[i] != '>' && tag[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(tag[nameStart:i])
+		j := i
+		for j < len(tag) && isSpace(tag[j]) {
+			j++
+		}
+		if j >= len(tag) || tag[j] != '=' {
+			continue
+		}
+		j++
+		for j < len(tag) && isSpace(tag[j]) {
+			j++
+		}
+		if j >= len(tag) {
+			break
+		}
+		span := attrSpan{name: name, nameStart: nameStart}
+		if q := tag[j]; q == '"' || q == '\'' {
+			span.quote = q
+			span.start = j + 1
+			k := strings.IndexByte(tag[span.start:], q)
+			if k < 0 {
+				break
+			}
+			span.end = span.start + k
+			i = span.end + 1
+		} else {
+			span.start = j
+			k := j
+			for k < len(tag) && !isSpace(tag[k]) && tag[k] != '>' {
+				k++
+			}
+			span.end = k
+			i = k
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// quoteAttr escapes a new attribute value for the quoting it replaces. An
+// unquoted value that now needs quotes gets double quotes.
+func quoteAttr(value string, quote byte) string {
+	value = strings.ReplaceAll(value, "&", "&amp;")
+	switch quote {
+	case '"':
+		return strings.ReplaceAll(value, `"`, "&quot;")
+	case '\'':
+		return strings.ReplaceAll(value, "'", "&#39;")
+	}
+	if strings.ContainsAny(value, " \t\n\r\f\"'=<>`") {
+		return `"` + strings.ReplaceAll(value, `"`, "&quot;") + `"`
+	}
+	return value
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
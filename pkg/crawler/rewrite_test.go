@@ -0,0 +1,122 @@
+package crawler
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteHTML(t *testing.T) {
+	// Only example.com/docs/a and the stylesheet were saved
+	saved := map[string]string{
+		"https://example.com/docs/a":       "docs/a.html",
+		"https://example.com/static/s.css": "assets/s.css",
+	}
+	mapURL := func(u *url.URL) (string, bool) {
+		local, ok := saved[u.String()]
+		return local, ok
+	}
+	tests := []struct {
+		name, page, in, want string
+	}{
+		{
+			name: "relative to the page",
+			page: "https://example.com/docs/index.html",
+			in:   `<a href="a">A</a><a href='../x'>X</a>`,
+			want: `<a href="docs/a.html">A</a><a href='../x'>X</a>`,
+		},
+		{
+			name: "relative to a <base href>",
+			page: "https://example.com/blog/post",
+			in:   `<head><base href="/docs/"><link rel=stylesheet href="../static/s.css"></head><a href="a#top">A</a>`,
+			want: `<head><base><link rel=stylesheet href="assets/s.css"></head><a href="docs/a.html#top">A</a>`,
+		},
+		{
+			name: "the base's other attributes stay",
+			page: "https://example.com/",
+			in:   `<base target="_blank" href="https://example.com/docs/"><a href=a>A</a>`,
+			want: `<base target="_blank"><a href=docs/a.html>A</a>`,
+		},
+		{
+			name: "links not saved still go where the base sent them",
+			page: "https://example.com/blog/post",
+			in:   `<base href="/docs/"><a href="b">B</a><a href="#here">here</a>`,
+			want: `<base><a href="https://example.com/docs/b">B</a><a href="#here">here</a>`,
+		},
+		{
+			name: "a base after the links still counts",
+			page: "https://example.com/blog/post",
+			in:   `<a href="a">A</a><base href="https://example.com/docs/">`,
+			want: `<a href="docs/a.html">A</a><base>`,
+		},
+		{
+			name: "srcset",
+			page: "https://example.com/docs/",
+			in:   `<img srcset="a 1x, b 2x">`,
+			want: `<img srcset="docs/a.html 1x, b 2x">`,
+		},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if err := rewriteHTML(&out, strings.NewReader(tt.in), tt.page, mapURL); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+// TestRewriteGolden rewrites the whole documents in testdata/rewrite, with
+// their comments, spacing, quoting and entities, and compares the bytes
+// with the golden files next to them; go test -run TestRewriteGolden
+// -update rewrites those
+func TestRewriteGolden(t *testing.T) {
+	saved := map[string]string{
+		"https://example.com/docs/a":          "docs/a.html",
+		"https://example.com/docs/a?x=1&y=2":  "docs/a-x1-y2.html",
+		"https://example.com/docs/caf%C3%A9":  "docs/cafe.html",
+		"https://example.com/static/s.css":    "assets/s.css",
+		"https://example.com/img/logo.png":    "assets/logo.png",
+		"https://example.com/img/logo@2x.png": "assets/logo@2x.png",
+	}
+	mapURL := func(u *url.URL) (string, bool) {
+		local, ok := saved[u.String()]
+		return local, ok
+	}
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "rewrite", "*.html"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no fixtures: %v", err)
+	}
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".html")
+		t.Run(name, func(t *testing.T) {
+			in, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			if err := rewriteHTML(&out, strings.NewReader(string(in)), "https://example.com/docs/index.html", mapURL); err != nil {
+				t.Fatal(err)
+			}
+			got := out.String()
+
+			golden := strings.TrimSuffix(fixture, ".html") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("rewritten page differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
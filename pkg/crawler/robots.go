@@ -0,0 +1,230 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsRules is the part of a robots.txt that applies to our user agent.
+// A nil *robotsRules allows everything.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+type robotsGroup struct {
+	agents []string
+	robotsRules
+}
+
+// allowed reports whether u may be fetched. The longest matching pattern
+// wins and Allow beats Disallow on a tie, as Google documents it.
+func (r *robotsRules) allowed(u *url.URL) bool {
+	if r == nil {
+		return true
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	allow, matched := true, -1
+	for _, rule := range r.rules {
+		if !robotsPatternMatches(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > matched || (len(rule.pattern) == matched && rule.allow) {
+			allow, matched = rule.allow, len(rule.pattern)
+		}
+	}
+	return allow
+}
+
+// robotsPatternMatches matches a robots.txt path pattern, where "*" matches
+// any run of characters and a trailing "$" anchors the end of the path.
+func robotsPatternMatches(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(path[pos:], part)
+		}
+		idx := strings.Index(path[pos:], part)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(part)
+	}
+	return !anchored || pos == len(path)
+}
+
+// parseRobots picks the group that best matches the token: the longest
+// User-agent token contained in it, falling back to "*".
+func parseRobots(r io.Reader, token string) (*robotsRules, error) {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if current == nil || inRules {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+				inRules = false
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			// An empty Disallow means "allow everything" and adds no rule
+			if value != "" {
+				current.rules = append(current.rules, robotsRule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	ua := strings.ToLower(token)
+	var best, wildcard *robotsGroup
+	bestLen := 0
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if agent == "*" {
+				if wildcard == nil {
+					wildcard = g
+				}
+			} else if agent != "" && strings.Contains(ua, agent) && len(agent) > bestLen {
+				best, bestLen = g, len(agent)
+			}
+		}
+	}
+	if best == nil {
+		best = wildcard
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return &best.robotsRules, nil
+}
+
+// fetchRobots downloads and parses robots.txt for the host of base. A 4xx
+// means there are no rules; 5xx and network errors are retried a couple of
+// times before giving up and allowing everything.
+func (c *Crawler) fetchRobots(ctx context.Context, base *url.URL) (*robotsRules, error) {
+	robotsURL := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/robots.txt"}
+
+	const attempts = 3
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if err := sleepCtx(ctx, time.Duration(attempt-1)*time.Second); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", robotsURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", c.userAgent(ctx))
+		c.setRequestHeaders(req)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch {
+		case resp.StatusCode >= 500:
+			resp.Body.Close()
+			lastErr = fmt.Errorf("bad status code: %d", resp.StatusCode)
+			continue
+		case resp.StatusCode >= 400:
+			resp.Body.Close()
+			return nil, nil
+		}
+		rules, err := parseRobots(resp.Body, c.robotsToken())
+		resp.Body.Close()
+		return rules, err
+	}
+	return nil, lastErr
+}
+
+// hostRobots is a host's robots.txt, fetched the first time a URL on the
+// host comes up
+type hostRobots struct {
+	once  sync.Once
+	rules *robotsRules
+}
+
+// robotsFor returns the robots.txt rules of u's host, fetching them on
+// first use. Workers asking for the same host meanwhile wait for that one
+// fetch.
+func (c *Crawler) robotsFor(ctx context.Context, u *url.URL) *robotsRules {
+	key := u.Scheme + "://" + strings.ToLower(u.Host)
+	c.robotsMu.Lock()
+	h, ok := c.robots[key]
+	if !ok {
+		h = &hostRobots{}
+		c.robots[key] = h
+	}
+	c.robotsMu.Unlock()
+	h.once.Do(func() {
+		rules, err := c.fetchRobots(ctx, u)
+		if err != nil {
+			c.log().Warn("could not fetch robots.txt, allowing all URLs", "host", u.Host, "error", err)
+			return
+		}
+		h.rules = rules
+		if rules != nil && rules.crawlDelay > 0 {
+			c.log().Info("robots.txt crawl delay", "host", u.Host, "delay", rules.crawlDelay)
+		}
+	})
+	return h.rules
+}
@@ -0,0 +1,246 @@
+package crawler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config points the "s3" storage backend at a bucket on any
+// S3-compatible service (AWS, MinIO, R2, ...)
+type S3Config struct {
+	// Endpoint is the service URL, e.g. https://s3.us-east-1.amazonaws.com
+	// or http://localhost:9000; buckets are addressed path-style under it
+	Endpoint string
+	Bucket   string
+	// Region defaults to us-east-1, which is what most other services
+	// expect too
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is put in front of every object name. Defaults to the
+	// project folder's name, so several projects can share a bucket.
+	Prefix string
+}
+
+// s3Timeout bounds one request to the storage service
+const s3Timeout = 5 * time.Minute
+
+// s3Storage stores bodies as objects, signing requests with AWS
+// Signature Version 4
+type s3Storage struct {
+	cfg    S3Config
+	base   *url.URL
+	client *http.Client
+}
+
+func newS3Storage(cfg S3Config) (*s3Storage, error) {
+	base, err := url.Parse(cfg.Endpoint)
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, errors.New("S3 endpoint must be an http or https URL")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket is not set")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("S3 credentials are not set")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &s3Storage{cfg: cfg, base: base, client: &http.Client{Timeout: s3Timeout}}, nil
+}
+
+func (s *s3Storage) key(name string) string {
+	if s.cfg.Prefix == "" {
+		return name
+	}
+	return s.cfg.Prefix + "/" + name
+}
+
+// SavePage spools body to a temporary file first: a PUT needs the length
+// up front, and nothing reaches the bucket unless body was read in full
+func (s *s3Storage) SavePage(name string, meta PageMeta, body io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp("", ".s3-upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hashed := newHashingReader(body)
+	n, err := io.Copy(tmp, hashed)
+	if err != nil {
+		return n, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return n, err
+	}
+
+	header := http.Header{}
+	if meta.ContentType != "" {
+		header.Set("Content-Type", meta.ContentType)
+	}
+	if meta.URL != "" {
+		header.Set("X-Amz-Meta-Url", meta.URL)
+	}
+	resp, err := s.do(http.MethodPut, name, header, tmp, n, hashed.sum())
+	if err != nil {
+		return n, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return n, s.statusError(resp, name)
+	}
+	return n, nil
+}
+
+func (s *s3Storage) Exists(name string) (int64, bool) {
+	resp, err := s.do(http.MethodHead, name, nil, nil, 0, "")
+	if err != nil {
+		return 0, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false
+	}
+	return resp.ContentLength, true
+}
+
+func (s *s3Storage) LoadPage(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, name, nil, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: "open", Path: s.key(name), Err: fs.ErrNotExist}
+	}
+	defer resp.Body.Close()
+	return nil, s.statusError(resp, name)
+}
+
+func (s *s3Storage) Remove(name string) error {
+	resp, err := s.do(http.MethodDelete, name, nil, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s.statusError(resp, name)
+	}
+	return nil
+}
+
+func (s *s3Storage) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.cfg.Bucket, s.cfg.Prefix)
+}
+
+func (s *s3Storage) statusError(resp *http.Response, name string) error {
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s %s", resp.Request.Method, s.key(name), resp.Status, strings.TrimSpace(string(detail)))
+}
+
+// do sends a signed request for the object holding name. payloadHash is
+// the hex SHA-256 of body; requests without a body leave it empty.
+func (s *s3Storage) do(method, name string, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + s.key(name)
+	u.RawPath = strings.TrimSuffix(s.base.EscapedPath(), "/") + "/" + s3Escape(s.cfg.Bucket) + "/" + s3Escape(s.key(name))
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if payloadHash == "" {
+		sum := sha256.Sum256(nil)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds a Signature Version 4 Authorization header covering the host,
+// the x-amz-* headers and the payload hash
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	stamp := now.Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || k == "content-type" {
+			headers[k] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	canonicalSum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), day)
+	for _, part := range []string{s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes everything in an object path but unreserved
+// characters and the slashes between segments, as the signature expects
+func s3Escape(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
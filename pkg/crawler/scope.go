@@ -0,0 +1,124 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// hostMatches reports whether host is allowed by an AllowedDomains
+// pattern: the host itself, or with a leading "*." any subdomain of the
+// rest, at any depth, but not the rest itself
+func hostMatches(pattern, host string) bool {
+	if parent, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+parent)
+	}
+	return host == pattern
+}
+
+// checkAllowedDomains lowercases the patterns and rejects anything that
+// isn't a host name, optionally with a leading "*."
+func checkAllowedDomains(patterns []string) ([]string, error) {
+	checked := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		name := strings.TrimPrefix(p, "*.")
+		if name == "" || strings.ContainsAny(name, "/:*@ ") || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("AllowedDomains: %q is not a host name or *.domain", p)
+		}
+		checked = append(checked, p)
+	}
+	return checked, nil
+}
+
+// hostAllowed reports whether URLs on host are in scope as far as the host
+// goes: any AllowedDomains match, or without them the BaseURL host
+func (c *Crawler) hostAllowed(host string) bool {
+	if len(c.cfg.AllowedDomains) == 0 {
+		return strings.EqualFold(host, c.baseHost)
+	}
+	name := strings.ToLower(urlHostname(host))
+	for _, p := range c.cfg.AllowedDomains {
+		if hostMatches(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// inScope reports whether a URL is one to crawl: under BaseURL, or with
+// AllowedDomains any http(s) URL on an allowed host. With NormalizeWWW and
+// NormalizeScheme the URL and BaseURL are compared as they fold, and with
+// NormalizeScheme an http BaseURL takes in its https twin.
+func (c *Crawler) inScope(raw string) bool {
+	if len(c.cfg.AllowedDomains) == 0 {
+		raw, base := c.foldURL(raw), c.foldURL(c.cfg.BaseURL)
+		if underBase(raw, base) {
+			return true
+		}
+		rest, ok := strings.CutPrefix(base, "http://")
+		return ok && c.cfg.NormalizeScheme && underBase(raw, "https://"+rest)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return c.hostAllowed(u.Host)
+}
+
+// underBase reports whether raw is base or a URL below it. BaseURL is
+// normalized without its trailing slash, so "/docs" takes in "/docs/a"
+// but not "/docs-old".
+func underBase(raw, base string) bool {
+	rest, ok := strings.CutPrefix(raw, base)
+	if !ok {
+		return false
+	}
+	return rest == "" || strings.HasSuffix(base, "/") || strings.Contains(base, "?") ||
+		rest[0] == '/' || rest[0] == '?'
+}
+
+// hostFolder is the folder files from u's host are laid out under where
+// the layout follows URL paths: none for the BaseURL host, so a
+// single-host mirror looks like the site, and the host name for the
+// others, so their paths don't collide
+func (c *Crawler) hostFolder(u *url.URL) string {
+	if u.Host == "" || strings.EqualFold(u.Host, c.baseHost) {
+		return ""
+	}
+	return strings.ReplaceAll(strings.ToLower(u.Host), ":", "_")
+}
+
+// checkSeeds normalizes SeedURLs and makes sure they are in scope, widening
+// AllowedDomains for them with AllowSeedDomains
+func (c *Crawler) checkSeeds() error {
+	var errs []error
+	for i, seed := range c.cfg.SeedURLs {
+		u, err := url.Parse(strings.TrimSpace(seed))
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("seed %q is not an http or https URL", seed))
+			continue
+		}
+		if !c.inScope(u.String()) {
+			if !c.cfg.AllowSeedDomains {
+				errs = append(errs, fmt.Errorf("seed %s is outside BaseURL and AllowedDomains", seed))
+				continue
+			}
+			if len(c.cfg.AllowedDomains) == 0 {
+				c.cfg.AllowedDomains = []string{strings.ToLower(urlHostname(c.baseHost))}
+			}
+			c.cfg.AllowedDomains = append(c.cfg.AllowedDomains, strings.ToLower(u.Hostname()))
+		}
+		if c.cfg.SeedURLs[i], err = c.normalizeURL(u.String()); err != nil {
+			errs = append(errs, fmt.Errorf("seed %s: %w", seed, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// urlHostname is host without its port
+func urlHostname(host string) string {
+	u := url.URL{Host: host}
+	return u.Hostname()
+}
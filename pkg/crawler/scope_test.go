@@ -0,0 +1,193 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostMatches(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "www.example.com", false},
+		{"example.com", "example.org", false},
+		{"*.example.com", "blog.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"*.example.com", "example.com.evil.net", false},
+	}
+	for _, tt := range tests {
+		if got := hostMatches(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("hostMatches(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAllowedDomains(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{patterns: []string{"Example.COM", " *.Example.com "}, want: []string{"example.com", "*.example.com"}},
+		{patterns: []string{"https://example.com"}, wantErr: true},
+		{patterns: []string{"example.com:8080"}, wantErr: true},
+		{patterns: []string{"example.com/docs"}, wantErr: true},
+		{patterns: []string{"*"}, wantErr: true},
+		{patterns: []string{"*.*.example.com"}, wantErr: true},
+		{patterns: []string{".example.com"}, wantErr: true},
+		{patterns: []string{""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := checkAllowedDomains(tt.patterns)
+		if (err != nil) != tt.wantErr || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("checkAllowedDomains(%q) = %q, %v, want %q, error %v", tt.patterns, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestInScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		allowed []string
+		url     string
+		want    bool
+	}{
+		{"under the base", "https://example.com/docs/", nil, "https://example.com/docs/intro", true},
+		{"outside the base path", "https://example.com/docs/", nil, "https://example.com/blog/", false},
+		{"subdomain without allowed domains", "https://example.com/", nil, "https://blog.example.com/", false},
+		{"exact host", "https://example.com/docs/", []string{"example.com"}, "https://example.com/blog/", true},
+		{"exact host, other scheme", "https://example.com/", []string{"example.com"}, "http://example.com/", true},
+		{"exact host, not its subdomain", "https://example.com/", []string{"example.com"}, "https://blog.example.com/", false},
+		{"wildcard", "https://www.example.com/", []string{"*.example.com"}, "https://docs.example.com/guide", true},
+		{"wildcard, deeper", "https://www.example.com/", []string{"*.example.com"}, "https://api.v2.example.com/", true},
+		{"host with a port", "https://www.example.com/", []string{"*.example.com"}, "https://docs.example.com:8443/", true},
+		{"upper case host", "https://www.example.com/", []string{"*.example.com"}, "https://DOCS.Example.com/", true},
+		{"unrelated domain", "https://www.example.com/", []string{"*.example.com", "example.com"}, "https://example.org/", false},
+		{"look-alike domain", "https://www.example.com/", []string{"*.example.com"}, "https://www.example.com.evil.net/", false},
+		{"not http", "https://www.example.com/", []string{"*.example.com"}, "ftp://files.example.com/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, tt.base, func(cfg *Config) { cfg.AllowedDomains = tt.allowed })
+			if got := c.inScope(tt.url); got != tt.want {
+				t.Errorf("inScope(%s) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedDomainsBaseHost(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		wantErr bool
+	}{
+		{allowed: []string{"example.com"}},
+		{allowed: []string{"*.example.com"}, wantErr: true},
+		{allowed: []string{"other.com", "example.com"}},
+		{allowed: []string{"other.com"}, wantErr: true},
+		{allowed: []string{"not a host"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig("https://example.com/")
+		cfg.ProjectFolder = t.TempDir()
+		cfg.AllowedDomains = tt.allowed
+		if _, err := New(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("New with AllowedDomains %q: %v, want error %v", tt.allowed, err, tt.wantErr)
+		}
+	}
+}
+
+// TestAllowedDomainsCrawl crawls several hosts of one site, all served
+// by one server: each host gets its own robots.txt and its own files,
+// and the unrelated domain is left alone
+func TestAllowedDomainsCrawl(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	// port is the server's, for the links; it is set before the crawl
+	var port string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := strings.Cut(r.Host, ":")
+		mu.Lock()
+		fetched = append(fetched, host+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/robots.txt" && host == "blog.example.test":
+			io.WriteString(w, "User-agent: *\nDisallow: /private\n")
+		case r.URL.Path == "/robots.txt":
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, strings.ReplaceAll(`<html><head><title>`+host+`</title></head><body>
+				<a href="//blog.example.test:PORT/">blog</a>
+				<a href="//blog.example.test:PORT/private">private</a>
+				<a href="//docs.example.test:PORT/">docs</a>
+				<a href="//other.test:PORT/">other</a></body></html>`, "PORT", port))
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port = u.Port()
+
+	c := newTestCrawler(t, "http://www.example.test:"+port+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.AllowedDomains = []string{"www.example.test", "*.example.test"}
+		cfg.HostMapping = make(map[string]string)
+		for _, host := range []string{"www.example.test", "blog.example.test", "docs.example.test", "other.test"} {
+			cfg.HostMapping[host+":"+port] = u.Host
+		}
+	})
+	files := make(map[string]string)
+	c.OnPage = func(p PageResult) {
+		page, _ := url.Parse(p.URL)
+		files[page.Hostname()+page.Path] = p.File
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var pages []string
+	for page := range files {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	if got, want := strings.Join(pages, " "), "blog.example.test/ docs.example.test/ www.example.test/"; got != want {
+		t.Errorf("saved %s, want %s", got, want)
+	}
+	seen := make(map[string]bool)
+	for page, file := range files {
+		if file == "" || seen[file] {
+			t.Errorf("%s saved as %q, which another host's page has too", page, file)
+		}
+		seen[file] = true
+		if got := readSaved(t, c, file); !strings.Contains(got, "<title>"+strings.TrimSuffix(page, "/")+"</title>") {
+			t.Errorf("%s holds another host's page: %.80q", file, got)
+		}
+	}
+	robots := 0
+	for _, f := range fetched {
+		if strings.HasPrefix(f, "other.test") || f == "blog.example.test/private" {
+			t.Errorf("fetched %s", f)
+		}
+		if strings.HasSuffix(f, "/robots.txt") {
+			robots++
+		}
+	}
+	if robots != 3 {
+		t.Errorf("fetched robots.txt %d times, want once for each of the 3 hosts: %v", robots, fetched)
+	}
+}
@@ -0,0 +1,110 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"path"
+	"strings"
+)
+
+// maxScreenshotHeight is how tall a full-page screenshot is taken before
+// the blank below the page is cut off, since Chrome's --screenshot only
+// captures the window
+const maxScreenshotHeight = 16384
+
+// screenshotName is where the screenshot of the page saved as name goes
+func screenshotName(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + ".png"
+}
+
+// screenshotWindowHeight is how tall Chrome's window is made for the
+// screenshot: the viewport's height with ScreenshotAboveFold, otherwise as
+// tall as a page gets, the blank below the page being trimmed after
+func screenshotWindowHeight(cfg *Config) int {
+	if cfg.ScreenshotAboveFold {
+		return cfg.ScreenshotHeight
+	}
+	return maxScreenshotHeight
+}
+
+// cropScreenshot returns the PNG Chrome took, decoded to learn its size
+// and, for the whole page, with the rows below its end trimmed
+func cropScreenshot(cfg *Config, shot []byte) ([]byte, int, int, error) {
+	img, err := png.Decode(bytes.NewReader(shot))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("screenshot: %w", err)
+	}
+	if !cfg.ScreenshotAboveFold {
+		if trimmed, ok := trimBottom(img, cfg.ScreenshotHeight); ok {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, trimmed); err != nil {
+				return nil, 0, 0, err
+			}
+			shot, img = buf.Bytes(), trimmed
+		}
+	}
+	b := img.Bounds()
+	return shot, b.Dx(), b.Dy(), nil
+}
+
+// trimBottom cuts off the rows at the bottom of img that are the same as
+// its last one, the window below the end of the page, keeping at least
+// minHeight. ok is false when there is nothing to trim or img isn't in a
+// format Chrome writes.
+func trimBottom(img image.Image, minHeight int) (image.Image, bool) {
+	var pix []byte
+	var stride int
+	switch m := img.(type) {
+	case *image.RGBA:
+		pix, stride = m.Pix, m.Stride
+	case *image.NRGBA:
+		pix, stride = m.Pix, m.Stride
+	default:
+		return nil, false
+	}
+	b := img.Bounds()
+	rowBytes := 4 * b.Dx()
+	row := func(y int) []byte {
+		start := (y - b.Min.Y) * stride
+		return pix[start : start+rowBytes]
+	}
+	last := row(b.Max.Y - 1)
+	end := b.Max.Y
+	for end > b.Min.Y+max(minHeight, 1) && bytes.Equal(row(end-2), last) {
+		end--
+	}
+	if end == b.Max.Y {
+		return nil, false
+	}
+	sub := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(image.Rect(b.Min.X, b.Min.Y, b.Max.X, end))
+	return sub, true
+}
+
+// saveScreenshot saves the screenshot Chrome took of a rendered page next
+// to its file, returning the screenshot's name and size. Taking it can
+// fail without failing the page: that is logged and the name left empty.
+func (c *Crawler) saveScreenshot(ctx context.Context, job Job, fileName string, page *rendering) (string, int, int, error) {
+	log := c.logFrom(ctx)
+	err := page.shotErr
+	var shot []byte
+	var width, height int
+	if err == nil {
+		shot, width, height, err = cropScreenshot(&c.cfg, page.shot)
+	}
+	if err != nil {
+		log.Warn("no screenshot", "error", err)
+		return "", 0, 0, nil
+	}
+	// An image of the site saved at the same path keeps its name
+	name := c.pageManifest.claimFile(screenshotName(fileName), job.URL)
+	if _, err := c.Storage.SavePage(name, PageMeta{URL: job.URL, ContentType: "image/png"}, bytes.NewReader(shot)); err != nil {
+		return "", 0, 0, err
+	}
+	log.Debug("screenshot", "file", name, "width", width, "height", height)
+	return name, width, height, nil
+}
@@ -0,0 +1,152 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeChrome writes a script standing in for Chrome to dir: it logs its
+// arguments to runs, a line per run, prints dom and, when asked for a
+// screenshot and shot isn't empty, copies shot there
+func fakeChrome(t *testing.T, dir, dom, shot string) (chrome, runs string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake Chrome is a shell script")
+	}
+	chrome, runs = filepath.Join(dir, "chrome"), filepath.Join(dir, "runs")
+	copyShot := ":"
+	if shot != "" {
+		copyShot = fmt.Sprintf("cp %q \"${a#--screenshot=}\"", shot)
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %q
+for a; do
+	case "$a" in --screenshot=*) %s ;; esac
+done
+printf '%%s' %q
+`, runs, copyShot, dom)
+	if err := os.WriteFile(chrome, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return chrome, runs
+}
+
+// writePNG saves a width by height PNG whose top content rows are black
+// and the rest white as path; trimmed, it keeps one white row
+func writePNG(t *testing.T, path string, width, height, content int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{255, 255, 255, 255}
+			if y < content {
+				c = color.NRGBA{0, 0, 0, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRenderScreenshot renders a page with Screenshots on: Chrome runs once
+// for the DOM and the screenshot both, in a window as tall as the
+// screenshot is to be
+func TestRenderScreenshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><div id="root"></div></body></html>`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name           string
+		aboveFold      bool
+		shot           bool
+		window         string
+		wantScreenshot bool
+		width, height  int
+	}{
+		{name: "whole page", shot: true, window: "--window-size=40,16384", wantScreenshot: true, width: 40, height: 31},
+		{name: "above the fold", aboveFold: true, shot: true, window: "--window-size=40,20", wantScreenshot: true, width: 40, height: 100},
+		{name: "chrome takes none", window: "--window-size=40,16384"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			shot := ""
+			if tt.shot {
+				shot = filepath.Join(dir, "shot.png")
+				writePNG(t, shot, 40, 100, 30)
+			}
+			chrome, runs := fakeChrome(t, dir, `<html><body><div id="root"><h1>rendered</h1></div></body></html>`, shot)
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.ChromePath = chrome
+				cfg.RenderPatterns = []*regexp.Regexp{regexp.MustCompile(".")}
+				cfg.Screenshots = true
+				cfg.ScreenshotWidth, cfg.ScreenshotHeight = 40, 20
+				cfg.ScreenshotAboveFold = tt.aboveFold
+			})
+			var files []string
+			c.OnPage = func(p PageResult) { files = append(files, p.File) }
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.Run(ctx); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if len(files) != 1 || !strings.Contains(readSaved(t, c, files[0]), "rendered") {
+				t.Fatalf("saved %v, want the rendered page", files)
+			}
+
+			log, err := os.ReadFile(runs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("chrome ran %d times:\n%s", len(lines), log)
+			}
+			for _, arg := range []string{"--dump-dom", "--screenshot=", tt.window} {
+				if !strings.Contains(lines[0], arg) {
+					t.Errorf("chrome ran without %s: %s", arg, lines[0])
+				}
+			}
+
+			name := screenshotName(files[0])
+			_, exists := c.Storage.Exists(name)
+			if exists != tt.wantScreenshot {
+				t.Fatalf("%s saved: %v, want %v", name, exists, tt.wantScreenshot)
+			}
+			if !exists {
+				return
+			}
+			img, err := png.Decode(strings.NewReader(readSaved(t, c, name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("screenshot is %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+		})
+	}
+}
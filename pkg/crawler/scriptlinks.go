@@ -0,0 +1,132 @@
+package crawler
+
+import (
+	"encoding/json"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// sourceScript marks the frontier entries and page records of URLs found
+// in inline scripts rather than in the markup
+const sourceScript = "script"
+
+// scriptURLKeys are the JSON keys whose relative values are taken for
+// links; elsewhere only absolute URLs are
+var scriptURLKeys = map[string]bool{"href": true, "url": true, "link": true, "path": true, "pathname": true, "as": true}
+
+// scriptURLPattern finds absolute http(s) URLs in script text, slashes
+// escaped as JSON inside JavaScript often has them ("https:\/\/...")
+var scriptURLPattern = regexp.MustCompile(`https?:\\?/\\?/[^\s"'<>` + "`" + `{}|^]+`)
+
+// scriptLinks returns the in-scope page URLs in the page's inline scripts:
+// every absolute URL, and with JSON blocks like Next.js's __NEXT_DATA__,
+// the paths under scriptURLKeys too. At most limit bytes of script are
+// read, 0 for no limit. URLs with the extension of a static file, like
+// .js or .png, are left out, and so are those already in skip.
+func (c *Crawler) scriptLinks(doc *goquery.Document, page *url.URL, limit int64, skip []string) []string {
+	seen := make(map[string]bool, len(skip))
+	for _, u := range skip {
+		seen[u] = true
+	}
+	var links []string
+	add := func(raw string, relative bool) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || strings.ContainsAny(raw, " \t\n") {
+			return
+		}
+		if !relative && !strings.HasPrefix(raw, "http://") && !strings.HasPrefix(raw, "https://") {
+			return
+		}
+		// A relative value has to look like a path, "/about" or "c.html",
+		// rather than a word like "button"
+		if relative && !strings.ContainsAny(raw, "/.") {
+			return
+		}
+		ref, err := url.Parse(raw)
+		if err != nil {
+			return
+		}
+		u := page.ResolveReference(ref)
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return
+		}
+		u.Fragment = ""
+		link := u.String()
+		if seen[link] || !c.inScope(link) || c.staticFile(link) {
+			return
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+
+	remaining := limit
+	doc.Find("script:not([src])").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		text := s.Text()
+		if limit > 0 {
+			if remaining <= 0 {
+				return false
+			}
+			if int64(len(text)) > remaining {
+				text = text[:remaining]
+			}
+			remaining -= int64(len(text))
+		}
+		var data any
+		if json.Unmarshal([]byte(text), &data) == nil {
+			walkScriptJSON(data, "", add)
+			return true
+		}
+		// JavaScript, or JSON cut short by the limit
+		text = strings.NewReplacer(`\u002F`, "/", `\u002f`, "/").Replace(text)
+		for _, m := range scriptURLPattern.FindAllString(text, -1) {
+			m = strings.ReplaceAll(m, `\/`, "/")
+			add(strings.TrimRight(m, `\.,;:)]`), false)
+		}
+		return true
+	})
+	return links
+}
+
+// walkScriptJSON calls add for every string in v, marking those under
+// scriptURLKeys as possibly relative
+func walkScriptJSON(v any, key string, add func(raw string, relative bool)) {
+	switch v := v.(type) {
+	case string:
+		add(v, scriptURLKeys[strings.ToLower(key)])
+	case []any:
+		for _, item := range v {
+			walkScriptJSON(item, key, add)
+		}
+	case map[string]any:
+		// In key order, so the links come out the same every time
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkScriptJSON(v[k], k, add)
+		}
+	}
+}
+
+// staticFile reports whether rawURL's extension is that of a file other
+// than a page, one of DocumentTypes aside
+func (c *Crawler) staticFile(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	ext := path.Ext(u.Path)
+	if ext == "" {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	return mediaType != "" && !isHTMLType(mediaType) && !c.isDocument(rawURL, mediaType)
+}
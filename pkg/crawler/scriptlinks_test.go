@@ -0,0 +1,116 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptOnlyPage is a Next.js-style page with no links in its markup: the
+// same-site pages are only in the __NEXT_DATA__ blob and an inline script,
+// along with strings that aren't links to pages at all
+const scriptOnlyPage = `<html><head>
+<script src="/_next/static/main.js">"https://example.com/from-src-script"</script>
+</head><body><div id="__next"></div>
+<script id="__NEXT_DATA__" type="application/json">{
+  "props": {"pageProps": {
+    "nav": [{"href": "/about", "label": "About us"}, {"href": "blog/first-post", "as": "/blog/first-post"}],
+    "author": {"url": "https://example.com/people/ann", "name": "Ann"},
+    "image": "/static/logo.png",
+    "variant": "primary",
+    "cta": {"link": "button"},
+    "logo": {"url": "https://example.com/static/logo.png"},
+    "partner": "https://elsewhere.example/partners",
+    "mail": {"href": "mailto:ann@example.com"}
+  }},
+  "page": "/[slug]",
+  "buildId": "abc.123",
+  "query": {}
+}</script>
+<script>
+  window.__CONFIG__ = {api: "https:\/\/example.com\/contact", home: 'https://example.com/pricing'};
+  var note = "see https://example.com/faq.";
+  fetch("/relative/in/javascript");
+  loadScript("https://example.com/app.js");
+  var greeting = "hello world";
+</script>
+</body></html>`
+
+// TestScriptLinks has the same-site pages of scriptOnlyPage found, in
+// script order and the JSON's key order, and nothing else
+func TestScriptLinks(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		c := newTestCrawler(t, "https://example.com/", func(cfg *Config) {
+			cfg.ScriptLinks = enabled
+		})
+		info, err := c.extractLinksFromHTML(pageContext{URL: "https://example.com/blog/"}, strings.NewReader(scriptOnlyPage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		if enabled {
+			want = []string{
+				"https://example.com/people/ann",
+				"https://example.com/about",
+				"https://example.com/blog/first-post",
+				"https://example.com/blog/blog/first-post",
+				"https://example.com/contact",
+				"https://example.com/pricing",
+				"https://example.com/faq",
+			}
+		}
+		if !reflect.DeepEqual(info.ScriptLinks, want) {
+			t.Errorf("ScriptLinks %v: got %q, want %q", enabled, info.ScriptLinks, want)
+		}
+		if len(info.Links) != 0 {
+			t.Errorf("Links %q, want none from the markup", info.Links)
+		}
+	}
+}
+
+// TestScriptLinksCrawl crawls a site whose front page links to the others
+// only from its scripts: they are fetched with ScriptLinks and not without
+func TestScriptLinksCrawl(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		var mu sync.Mutex
+		var requested []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requested = append(requested, r.URL.Path)
+			mu.Unlock()
+			w.Header().Set("Content-Type", "text/html")
+			if r.URL.Path == "/" {
+				w.Write([]byte(strings.NewReplacer("https:", "http:", "example.com", r.Host).Replace(scriptOnlyPage)))
+				return
+			}
+			w.Write([]byte(`<html><body>` + r.URL.Path + `</body></html>`))
+		}))
+		c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+			cfg.Workers = 1
+			cfg.RespectRobots = false
+			cfg.ScriptLinks = enabled
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := c.Run(ctx)
+		cancel()
+		srv.Close()
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+
+		sort.Strings(requested)
+		want := []string{"/"}
+		if enabled {
+			want = []string{"/", "/about", "/blog/first-post", "/contact", "/faq", "/people/ann", "/pricing"}
+		}
+		if !reflect.DeepEqual(requested, want) {
+			t.Errorf("ScriptLinks %v: requested %v, want %v", enabled, requested, want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package crawler
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// searchIndex is the full-text index kept in search_index.json: the text
+// of every indexed page, keyed by URL. The postings are rebuilt from it
+// when searching, which is cheap next to parsing the pages again.
+type searchIndex struct {
+	Pages map[string]*indexedPage `json:"pages"`
+}
+
+// indexedPage is one page in the index. SHA256 is the manifest hash of the
+// body it was read from, so unchanged pages are skipped on the next build.
+type indexedPage struct {
+	Title  string `json:"title,omitempty"`
+	Text   string `json:"text"`
+	SHA256 string `json:"sha256,omitempty"`
+	File   string `json:"file"`
+}
+
+// indexSaveEvery is how many new or changed pages the index build goes
+// through between saves, so an interrupted build picks up from there
+const indexSaveEvery = 200
+
+// titleWeight is how many times a title word counts over the same word in
+// the text
+const titleWeight = 3
+
+func (c *Crawler) loadSearchIndex() (*searchIndex, error) {
+	idx := &searchIndex{Pages: make(map[string]*indexedPage)}
+	data, err := os.ReadFile(c.searchIndexFileName)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, err
+	}
+	if idx.Pages == nil {
+		idx.Pages = make(map[string]*indexedPage)
+	}
+	return idx, nil
+}
+
+func (c *Crawler) saveSearchIndex(idx *searchIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.searchIndexFileName, data)
+}
+
+// updateSearchIndex brings the index in line with the manifest: pages
+// saved since the last build, or whose body changed, are read and indexed,
+// and pages no longer saved are dropped. What was indexed is saved every
+// indexSaveEvery pages.
+func (c *Crawler) updateSearchIndex() error {
+	idx, err := c.loadSearchIndex()
+	if err != nil {
+		return err
+	}
+	c.pageManifest.mu.Lock()
+	var pages []manifestEntry
+	for _, e := range c.pageManifest.entries {
+		if isHTMLType(e.ContentType) && e.DuplicateOf == "" {
+			pages = append(pages, e)
+		}
+	}
+	c.pageManifest.mu.Unlock()
+	sort.Slice(pages, func(i, j int) bool { return pages[i].URL < pages[j].URL })
+
+	saved := make(map[string]bool, len(pages))
+	updated, pending := 0, 0
+	for _, e := range pages {
+		saved[e.URL] = true
+		if p, ok := idx.Pages[e.URL]; ok && p.File == e.File && p.SHA256 == e.SHA256 && e.SHA256 != "" {
+			continue
+		}
+		f, err := c.Storage.LoadPage(e.File)
+		if err != nil {
+			c.log().Warn("skipped page", "file", e.File, "error", err)
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(f)
+		f.Close()
+		if err != nil {
+			c.log().Warn("skipped page", "file", e.File, "error", err)
+			continue
+		}
+		mode := c.cfg.ExtractText
+		if mode == "" {
+			mode = textFull
+		}
+		idx.Pages[e.URL] = &indexedPage{
+			Title:  strings.TrimSpace(doc.Find("title").First().Text()),
+			Text:   pageText(doc, mode),
+			SHA256: e.SHA256,
+			File:   e.File,
+		}
+		updated++
+		if pending++; pending >= indexSaveEvery {
+			if err := c.saveSearchIndex(idx); err != nil {
+				return err
+			}
+			pending = 0
+		}
+	}
+	removed := 0
+	for url := range idx.Pages {
+		if !saved[url] {
+			delete(idx.Pages, url)
+			removed++
+		}
+	}
+	if err := c.saveSearchIndex(idx); err != nil {
+		return err
+	}
+	c.log().Info("search index", "pages", len(idx.Pages), "updated", updated, "removed", removed, "file", c.searchIndexFileName)
+	return nil
+}
+
+// SearchResult is one page matching a search, best first. Snippet is a
+// piece of the page's text around the matches, with the matched words
+// wrapped in ** **.
+type SearchResult struct {
+	URL     string
+	Title   string
+	Score   float64
+	Snippet string
+}
+
+// Search looks up query in the index built by earlier crawls, returning up
+// to limit pages ranked by BM25 over their title and text. Every word of
+// the query counts; pages matching more of them rank higher.
+func (c *Crawler) Search(query string, limit int) ([]SearchResult, error) {
+	idx, err := c.loadSearchIndex()
+	if err != nil {
+		return nil, err
+	}
+	terms := uniqueTerms(query)
+	if len(terms) == 0 || len(idx.Pages) == 0 {
+		return nil, nil
+	}
+
+	// Term frequencies of the query terms only, per page
+	want := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		want[t] = true
+	}
+	type pageTerms struct {
+		url    string
+		length int
+		freq   map[string]int
+	}
+	var matched []pageTerms
+	docFreq := make(map[string]int)
+	totalLength := 0
+	for url, p := range idx.Pages {
+		pt := pageTerms{url: url, freq: make(map[string]int)}
+		count := func(text string, weight int) {
+			for _, t := range tokenize(text) {
+				pt.length += weight
+				if want[t] {
+					pt.freq[t] += weight
+				}
+			}
+		}
+		count(p.Title, titleWeight)
+		count(p.Text, 1)
+		totalLength += pt.length
+		for t := range pt.freq {
+			docFreq[t]++
+		}
+		if len(pt.freq) > 0 {
+			matched = append(matched, pt)
+		}
+	}
+
+	const k1, b = 1.2, 0.75
+	n := float64(len(idx.Pages))
+	avgLength := float64(totalLength) / n
+	results := make([]SearchResult, 0, len(matched))
+	for _, pt := range matched {
+		score := 0.0
+		for t, tf := range pt.freq {
+			idf := math.Log(1 + (n-float64(docFreq[t])+0.5)/(float64(docFreq[t])+0.5))
+			f := float64(tf)
+			score += idf * f * (k1 + 1) / (f + k1*(1-b+b*float64(pt.length)/avgLength))
+		}
+		p := idx.Pages[pt.url]
+		results = append(results, SearchResult{URL: pt.url, Title: p.Title, Score: score, Snippet: snippet(p.Text, want)})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].URL < results[j].URL
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// tokenize splits text into lowercase words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func uniqueTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, t := range tokenize(query) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// snippetWords is how many words of text a snippet shows
+const snippetWords = 30
+
+// snippet picks the run of snippetWords words holding the most distinct
+// query terms and highlights them
+func snippet(text string, want map[string]bool) string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return ""
+	}
+	matches := func(w string) string {
+		for _, t := range tokenize(w) {
+			if want[t] {
+				return t
+			}
+		}
+		return ""
+	}
+	best, bestCount := 0, -1
+	for start := 0; start == 0 || start+snippetWords <= len(words); start++ {
+		seen := make(map[string]bool)
+		for _, w := range words[start:min(start+snippetWords, len(words))] {
+			if t := matches(w); t != "" {
+				seen[t] = true
+			}
+		}
+		if len(seen) > bestCount {
+			best, bestCount = start, len(seen)
+		}
+	}
+	end := min(best+snippetWords, len(words))
+	shown := make([]string, 0, end-best)
+	for _, w := range words[best:end] {
+		if matches(w) != "" {
+			w = "**" + w + "**"
+		}
+		shown = append(shown, w)
+	}
+	s := strings.Join(shown, " ")
+	if best > 0 {
+		s = "…" + s
+	}
+	if end < len(words) {
+		s += "…"
+	}
+	return s
+}
@@ -0,0 +1,252 @@
+package crawler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The kinds of SEO finding, each written to seo/<kind>.csv
+const (
+	seoMissingTitle       = "missing_title"
+	seoDuplicateTitle     = "duplicate_title"
+	seoLongTitle          = "long_title"
+	seoMissingDescription = "missing_description"
+	seoMultipleH1         = "multiple_h1"
+	seoImageMissingAlt    = "image_missing_alt"
+	seoNoindexLinked      = "noindex_linked"
+	seoRedirectChain      = "redirect_chain"
+	seoNonCanonicalLinked = "non_canonical_linked"
+	seoBrokenLinked       = "broken_linked"
+)
+
+// seoColumns are the header rows of the findings files, in the order the
+// summary lists them
+var seoColumns = []struct {
+	kind    string
+	columns []string
+}{
+	{seoMissingTitle, []string{"url"}},
+	{seoDuplicateTitle, []string{"title", "url", "pages"}},
+	{seoLongTitle, []string{"url", "title", "length"}},
+	{seoMissingDescription, []string{"url", "title"}},
+	{seoMultipleH1, []string{"url", "h1_count"}},
+	{seoImageMissingAlt, []string{"url", "images", "srcs"}},
+	{seoNoindexLinked, []string{"url", "robots", "inlinks", "referrers"}},
+	{seoRedirectChain, []string{"url", "hops", "final_url", "chain"}},
+	{seoNonCanonicalLinked, []string{"url", "canonical", "inlinks", "referrers"}},
+	{seoBrokenLinked, []string{"url", "status", "referrers"}},
+}
+
+// SEOReport is seo/summary.json: how many HTML pages were checked and how
+// many rows each kind of finding has
+type SEOReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Pages       int            `json:"pages"`
+	Findings    map[string]int `json:"findings"`
+}
+
+// WriteText prints the summary for people
+func (r *SEOReport) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "SEO AUDIT of %d pages\n", r.Pages)
+	for _, k := range seoColumns {
+		fmt.Fprintf(&sb, "\t%s=%d\n", strings.ToUpper(k.kind), r.Findings[k.kind])
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// seoAudit holds the findings of each kind as CSV rows
+type seoAudit map[string][][]string
+
+func (a seoAudit) add(kind string, row ...string) {
+	a[kind] = append(a[kind], row)
+}
+
+// WriteSEOReport audits the saved HTML pages from pages.jsonl, edges.jsonl,
+// the frontier and the saved files, without fetching anything, and writes
+// a CSV per kind of finding and summary.json to the seo folder
+func (c *Crawler) WriteSEOReport() (*SEOReport, error) {
+	if err := c.openStateFiles(); err != nil {
+		return nil, err
+	}
+	defer c.closeStateFiles()
+	last, err := c.lastPageRecords()
+	if err != nil {
+		return nil, err
+	}
+	inbound, err := c.inboundLinks()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(last))
+	for u := range last {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	r := &SEOReport{GeneratedAt: time.Now().UTC(), Findings: make(map[string]int)}
+	audit := make(seoAudit)
+	titles := make(map[string][]string)
+	for _, u := range urls {
+		p := last[u]
+		if p.File == "" || p.Soft404 || !isHTMLType(p.ContentType) {
+			continue
+		}
+		r.Pages++
+		if p.Title == "" {
+			audit.add(seoMissingTitle, p.URL)
+		} else {
+			titles[p.Title] = append(titles[p.Title], p.URL)
+			if n := utf8.RuneCountInString(p.Title); n > c.cfg.SEOTitleMaxLength {
+				audit.add(seoLongTitle, p.URL, p.Title, strconv.Itoa(n))
+			}
+		}
+		if p.Description == "" {
+			audit.add(seoMissingDescription, p.URL, p.Title)
+		}
+		if h1s, noAlt, err := c.checkSavedPage(p.File); err != nil {
+			c.log().Warn("not checked for h1s and alt text", "url", p.URL, "file", p.File, "error", err)
+		} else {
+			if h1s > 1 {
+				audit.add(seoMultipleH1, p.URL, strconv.Itoa(h1s))
+			}
+			if len(noAlt) > 0 {
+				audit.add(seoImageMissingAlt, p.URL, strconv.Itoa(len(noAlt)), strings.Join(noAlt, " "))
+			}
+		}
+		if robots := strings.ToLower(p.Robots); len(inbound[p.URL]) > 0 && (strings.Contains(robots, "noindex") || strings.Contains(robots, "none")) {
+			audit.add(seoNoindexLinked, p.URL, p.Robots, strconv.Itoa(len(inbound[p.URL])), strings.Join(inbound[p.URL], " "))
+		}
+		if len(p.Redirects) > 1 {
+			chain := make([]string, len(p.Redirects))
+			for i, hop := range p.Redirects {
+				chain[i] = fmt.Sprintf("%s (%d)", hop.URL, hop.Status)
+			}
+			audit.add(seoRedirectChain, p.URL, strconv.Itoa(len(p.Redirects)), p.FinalURL, strings.Join(chain, " "))
+		}
+		if canonical := c.canonicalElsewhere(p); canonical != "" && len(inbound[p.URL]) > 0 {
+			audit.add(seoNonCanonicalLinked, p.URL, canonical, strconv.Itoa(len(inbound[p.URL])), strings.Join(inbound[p.URL], " "))
+		}
+	}
+	titleOrder := make([]string, 0, len(titles))
+	for t, pages := range titles {
+		if len(pages) > 1 {
+			titleOrder = append(titleOrder, t)
+		}
+	}
+	sort.Strings(titleOrder)
+	for _, t := range titleOrder {
+		for _, u := range titles[t] {
+			audit.add(seoDuplicateTitle, t, u, strconv.Itoa(len(titles[t])))
+		}
+	}
+	for _, l := range collectBrokenLinks(c.Frontier.Found(), c.referrersFile.snapshot(), c.Frontier.Failed()) {
+		if l.Status >= 400 && len(l.Referrers) > 0 {
+			audit.add(seoBrokenLinked, l.URL, strconv.Itoa(l.Status), strings.Join(l.Referrers, " "))
+		}
+	}
+
+	if err := os.MkdirAll(c.seoFolderName, os.ModePerm); err != nil {
+		return nil, err
+	}
+	for _, k := range seoColumns {
+		r.Findings[k.kind] = len(audit[k.kind])
+		if err := writeCSVFile(filepath.Join(c.seoFolderName, k.kind+".csv"), k.columns, audit[k.kind]); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(c.seoFolderName, "summary.json"), append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// checkSavedPage counts the <h1> elements of a saved page and lists the
+// src of its images with no alt attribute; an empty alt marks an image as
+// decorative, so it isn't missing
+func (c *Crawler) checkSavedPage(file string) (int, []string, error) {
+	f, err := c.Storage.LoadPage(file)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		return 0, nil, err
+	}
+	var noAlt []string
+	doc.Find("img:not([alt])").Each(func(i int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		noAlt = append(noAlt, strings.TrimSpace(src))
+	})
+	return doc.Find("h1").Length(), noAlt, nil
+}
+
+// canonicalElsewhere is the canonical URL of a page that names another
+// page than itself as its canonical, normalized, or empty
+func (c *Crawler) canonicalElsewhere(p pageRecord) string {
+	if p.Canonical == "" {
+		return ""
+	}
+	canonical, err := c.normalizeURL(p.Canonical)
+	if err != nil {
+		return ""
+	}
+	if canonical == p.URL {
+		return ""
+	}
+	if p.FinalURL != "" {
+		if final, err := c.normalizeURL(p.FinalURL); err == nil && final == canonical {
+			return ""
+		}
+	}
+	return canonical
+}
+
+// inboundLinks maps every URL in edges.jsonl to the other pages linking to
+// it, sorted
+func (c *Crawler) inboundLinks() (map[string][]string, error) {
+	seen := make(map[linkEdge]bool)
+	inbound := make(map[string][]string)
+	if err := readJSONL(c.edgesFileName, func(line []byte) {
+		var e linkEdge
+		if json.Unmarshal(line, &e) == nil && e.From != e.To && !seen[e] {
+			seen[e] = true
+			inbound[e.To] = append(inbound[e.To], e.From)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	for _, from := range inbound {
+		sort.Strings(from)
+	}
+	return inbound, nil
+}
+
+// writeCSVFile writes header and rows to path as CSV
+func writeCSVFile(path string, header []string, rows [][]string) error {
+	var sb strings.Builder
+	cw := csv.NewWriter(&sb)
+	cw.Write(header)
+	cw.WriteAll(rows)
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(sb.String()))
+}
@@ -0,0 +1,155 @@
+package crawler
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seoPage is a page with the given head and body, a title and a meta
+// description left out when empty
+func seoPage(title, description, head, body string) string {
+	if title != "" {
+		head += `<title>` + title + `</title>`
+	}
+	if description != "" {
+		head += `<meta name="description" content="` + description + `">`
+	}
+	return `<html><head>` + head + `</head><body>` + body + `</body></html>`
+}
+
+// seoSite serves a site with a page failing each check, and one page, the
+// front page, failing none
+func seoSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	pages := map[string]string{
+		"/": seoPage("Home", "The front page", "", `<h1>Home</h1><img src="/logo.png" alt="Logo">`+
+			`<a href="/untitled">1</a><a href="/same-a">2</a><a href="/same-b">3</a><a href="/long">4</a>`+
+			`<a href="/undescribed">5</a><a href="/headings">6</a><a href="/images">7</a><a href="/hidden">8</a>`+
+			`<a href="/moved">9</a><a href="/copy">10</a><a href="/self">11</a><a href="/gone">12</a>`),
+		"/untitled":    seoPage("", "No title", "", `<h1>Untitled</h1>`),
+		"/same-a":      seoPage("Same", "First of two", "", `<h1>A</h1>`),
+		"/same-b":      seoPage("Same", "Second of two", "", `<h1>B</h1>`),
+		"/long":        seoPage(strings.Repeat("x", 61), "A long title", "", `<h1>Long</h1>`),
+		"/undescribed": seoPage("No description", "", "", `<h1>Undescribed</h1>`),
+		"/headings":    seoPage("Headings", "Two h1s", "", `<h1>One</h1><h1>Two</h1>`),
+		"/images": seoPage("Images", "Images without alt", "", `<h1>Images</h1>`+
+			`<img src="/a.png"><img src="/b.png" alt=""><img src="/c.png" alt="C"><img src=" /d.png ">`),
+		"/hidden":  seoPage("Hidden", "Kept out of the index", `<meta name="robots" content="noindex, follow">`, `<h1>Hidden</h1>`),
+		"/landing": seoPage("Landing", "Where /moved ends", "", `<h1>Landing</h1>`),
+		"/copy":    seoPage("Copy", "A copy of home", `<link rel="canonical" href="/">`, `<h1>Copy</h1>`),
+		"/self":    seoPage("Self", "Its own canonical", `<link rel="canonical" href="/self">`, `<h1>Self</h1>`),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/moved":
+			http.Redirect(w, r, "/hop", http.StatusMovedPermanently)
+		case r.URL.Path == "/hop":
+			http.Redirect(w, r, "/landing", http.StatusMovedPermanently)
+		case strings.HasSuffix(r.URL.Path, ".png"):
+			w.Header().Set("Content-Type", "image/png")
+		case pages[r.URL.Path] != "":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(pages[r.URL.Path]))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestSEOReport crawls seoSite and audits it: each check finds its own
+// page, with the columns filled in, and no other
+func TestSEOReport(t *testing.T) {
+	srv := seoSite(t)
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.MaxAttempts = 1
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	r, err := c.WriteSEOReport()
+	if err != nil {
+		t.Fatalf("WriteSEOReport: %v", err)
+	}
+	// Every saved HTML page; the redirect is saved as /landing
+	if r.Pages != 12 {
+		t.Errorf("%d pages audited, want 12", r.Pages)
+	}
+
+	u := func(path string) string { return srv.URL + path }
+	tests := []struct {
+		kind string
+		want [][]string
+	}{
+		{seoMissingTitle, [][]string{{u("/untitled")}}},
+		{seoDuplicateTitle, [][]string{{"Same", u("/same-a"), "2"}, {"Same", u("/same-b"), "2"}}},
+		{seoLongTitle, [][]string{{u("/long"), strings.Repeat("x", 61), "61"}}},
+		{seoMissingDescription, [][]string{{u("/undescribed"), "No description"}}},
+		{seoMultipleH1, [][]string{{u("/headings"), "2"}}},
+		{seoImageMissingAlt, [][]string{{u("/images"), "2", "/a.png /d.png"}}},
+		{seoNoindexLinked, [][]string{{u("/hidden"), "noindex, follow", "1", u("/")}}},
+		{seoRedirectChain, [][]string{{u("/moved"), "2", u("/landing"), u("/moved") + " (301) " + u("/hop") + " (301)"}}},
+		{seoNonCanonicalLinked, [][]string{{u("/copy"), u("/"), "1", u("/")}}},
+		{seoBrokenLinked, [][]string{{u("/gone"), "404", u("/")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			f, err := os.Open(filepath.Join(c.seoFolderName, tt.kind+".csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rows, err := csv.NewReader(f).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range seoColumns {
+				if k.kind == tt.kind && !reflect.DeepEqual(rows[0], k.columns) {
+					t.Errorf("header %q, want %q", rows[0], k.columns)
+				}
+			}
+			if got := rows[1:]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows %q, want %q", got, tt.want)
+			}
+			if r.Findings[tt.kind] != len(tt.want) {
+				t.Errorf("summary counts %d, want %d", r.Findings[tt.kind], len(tt.want))
+			}
+		})
+	}
+}
+
+func TestCanonicalElsewhere(t *testing.T) {
+	c := newTestCrawler(t, "https://example.com/", nil)
+	tests := []struct {
+		name string
+		p    pageRecord
+		want string
+	}{
+		{"none", pageRecord{URL: "https://example.com/a"}, ""},
+		{"itself", pageRecord{URL: "https://example.com/a", Canonical: "https://example.com/a"}, ""},
+		{"itself unnormalized", pageRecord{URL: "https://example.com/a", Canonical: "HTTPS://Example.com/a#top"}, ""},
+		{"where it redirects", pageRecord{URL: "https://example.com/a", FinalURL: "https://example.com/b", Canonical: "https://example.com/b"}, ""},
+		{"another page", pageRecord{URL: "https://example.com/a", Canonical: "https://example.com/b"}, "https://example.com/b"},
+		{"another host", pageRecord{URL: "https://example.com/a", Canonical: "https://mirror.example/a"}, "https://mirror.example/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.canonicalElsewhere(tt.p); got != tt.want {
+				t.Errorf("canonicalElsewhere(%+v) = %q, want %q", tt.p, got, tt.want)
+			}
+		})
+	}
+}
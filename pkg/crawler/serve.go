@@ -0,0 +1,166 @@
+package crawler
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxNearMisses is how many similar URLs the 404 page suggests
+const maxNearMisses = 10
+
+var notFoundPage = template.Must(template.New("404").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Not in the mirror</title></head>
+<body><h1>Not in the mirror</h1><p>{{.URL}} was not downloaded.</p>
+{{if .NearMisses}}<p>Similar pages that were:</p><ul>
+{{range .NearMisses}}<li><a href="{{.Path}}">{{.URL}}</a></li>
+{{end}}</ul>{{end}}</body></html>
+`))
+
+// mirrorServer serves the download folder as if it were the crawled site,
+// looking request paths up in the manifest
+type mirrorServer struct {
+	c      *Crawler
+	base   *url.URL
+	finals map[string]string
+}
+
+// serveMirror blocks serving the mirror on addr
+func (c *Crawler) serveMirror(addr string) error {
+	base, err := url.Parse(c.cfg.BaseURL)
+	if err != nil {
+		return err
+	}
+	finals, err := c.loadFinalURLs()
+	if err != nil {
+		return err
+	}
+	c.log().Info("serving mirror", "base_url", c.cfg.BaseURL, "storage", fmt.Sprint(c.Storage), "addr", "http://"+addr+"/")
+	return http.ListenAndServe(addr, &mirrorServer{c: c, base: base, finals: finals})
+}
+
+func (s *mirrorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	original := s.base.ResolveReference(&url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery})
+	key := original.String()
+	if normalized, err := s.c.normalizeURL(key); err == nil {
+		key = normalized
+	}
+	e, ok := s.c.pageManifest.lookup(key)
+	if !ok {
+		s.notFound(w, key)
+		return
+	}
+
+	f, err := s.c.Storage.LoadPage(e.File)
+	if os.IsNotExist(err) {
+		s.notFound(w, key)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if e.ContentType != "" {
+		contentType := e.ContentType
+		// Saved HTML has been transcoded to UTF-8
+		if isHTMLType(contentType) {
+			contentType += "; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+	if !isHTMLType(e.ContentType) {
+		// Files support ranges; other storage is streamed as a whole
+		if file, ok := f.(*os.File); ok {
+			var modTime time.Time
+			if info, err := file.Stat(); err == nil {
+				modTime = info.ModTime()
+			}
+			http.ServeContent(w, r, path.Base(e.File), modTime, file)
+		} else if r.Method != http.MethodHead {
+			io.Copy(w, f)
+		}
+		return
+	}
+
+	// Pages still point at the live site; point their links back here
+	if r.Method == http.MethodHead {
+		return
+	}
+	pageURL := key
+	if final, ok := s.finals[key]; ok {
+		pageURL = final
+	}
+	if err := rewriteHTML(w, f, pageURL, s.mapURL); err != nil {
+		s.c.log().Error("serving page", "url", key, "error", err)
+	}
+}
+
+// mapURL turns links to the crawled host into paths on this server
+func (s *mirrorServer) mapURL(u *url.URL) (string, bool) {
+	if !strings.EqualFold(u.Host, s.base.Host) {
+		return "", false
+	}
+	return u.RequestURI(), true
+}
+
+type nearMiss struct {
+	URL  string
+	Path string
+}
+
+// notFound answers with a 404 page suggesting the saved URLs that share
+// the longest prefix with the one asked for
+func (s *mirrorServer) notFound(w http.ResponseWriter, want string) {
+	type scored struct {
+		url   string
+		score int
+	}
+	var candidates []scored
+	s.c.pageManifest.mu.Lock()
+	for u := range s.c.pageManifest.entries {
+		n := 0
+		for n < len(u) && n < len(want) && u[n] == want[n] {
+			n++
+		}
+		candidates = append(candidates, scored{u, n})
+	}
+	s.c.pageManifest.mu.Unlock()
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].url < candidates[j].url
+	})
+
+	var misses []nearMiss
+	for _, c := range candidates {
+		if len(misses) == maxNearMisses {
+			break
+		}
+		u, err := url.Parse(c.url)
+		if err != nil {
+			continue
+		}
+		if p, ok := s.mapURL(u); ok {
+			misses = append(misses, nearMiss{URL: c.url, Path: p})
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	_ = notFoundPage.Execute(w, struct {
+		URL        string
+		NearMisses []nearMiss
+	}{want, misses})
+}
@@ -0,0 +1,43 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// shutdownContexts returns two contexts derived from ctx. crawlCtx is done
+// with ctx and stops new jobs being dispatched; fetchCtx is cancelled
+// ShutdownTimeout later and aborts whatever is still in flight. When ctx
+// runs out of time instead of being cancelled, both stop at once. stop
+// releases the contexts.
+func (c *Crawler) shutdownContexts(ctx context.Context) (crawlCtx, fetchCtx context.Context, stop func()) {
+	fetchCtx, cancelFetch := context.WithCancel(context.WithoutCancel(ctx))
+	stopWatching := context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			cancelFetch()
+			return
+		}
+		c.log().Info("shutting down, waiting for in-flight requests", "timeout", c.cfg.ShutdownTimeout)
+		time.AfterFunc(c.cfg.ShutdownTimeout, cancelFetch)
+	})
+	return ctx, fetchCtx, func() {
+		stopWatching()
+		cancelFetch()
+	}
+}
+
+// sleepCtx sleeps for d, returning early with ctx's error if it is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
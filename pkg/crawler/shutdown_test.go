@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRunStopsBlockedWorker cancels a crawl, or lets it run out of
+// time, while its worker waits on a handler that never answers: Run
+// returns within a second or so and leaves the URL pending
+func TestRunStopsBlockedWorker(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		cancel   time.Duration
+		outcome  string
+	}{
+		{name: "cancelled", cancel: 300 * time.Millisecond, outcome: outcomeInterrupted},
+		{name: "past its deadline", deadline: 300 * time.Millisecond, outcome: outcomeDeadline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/stuck" {
+					select {
+					case <-r.Context().Done():
+					case <-time.After(20 * time.Second):
+					}
+					return
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(`<html><a href="/stuck">stuck</a></html>`))
+			}))
+			defer srv.Close()
+
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.RequestTimeout = time.Minute
+				cfg.ShutdownTimeout = 200 * time.Millisecond
+				cfg.Deadline = tt.deadline
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+			start := time.Now()
+			c.Run(ctx)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Run took %s to stop", elapsed)
+			}
+			run := c.LastRun()
+			if run.Outcome != tt.outcome {
+				t.Errorf("outcome %q, want %q", run.Outcome, tt.outcome)
+			}
+			if run.Frontier == nil || run.Frontier.Pending != 1 || run.Frontier.Failed != 0 {
+				t.Errorf("frontier %+v, want /stuck left pending", run.Frontier)
+			}
+		})
+	}
+}
+
+func TestSleepCtx(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		d       time.Duration
+		wantErr bool
+		max     time.Duration
+	}{
+		{"sleeps", context.Background(), 20 * time.Millisecond, false, time.Second},
+		{"no time", context.Background(), 0, false, 10 * time.Millisecond},
+		{"cancelled", cancelled, time.Minute, true, 100 * time.Millisecond},
+		{"cancelled with no time", cancelled, 0, true, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		err := sleepCtx(tt.ctx, tt.d)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: sleepCtx = %v", tt.name, err)
+		}
+		if elapsed := time.Since(start); elapsed < min(tt.d, tt.max) && !tt.wantErr || elapsed > tt.max {
+			t.Errorf("%s: slept %s", tt.name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Nested sitemap indexes deeper than this are ignored
+const maxSitemapDepth = 5
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapDocument covers both <urlset> and <sitemapindex>; only one of the
+// two slices is populated for any given file.
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+// seedFromSitemap walks /sitemap.xml of the base host, stores every in-scope
+// <loc> in the found file and records the lastmod values next to it.
+func (c *Crawler) seedFromSitemap(ctx context.Context) error {
+	base, err := url.Parse(c.cfg.BaseURL)
+	if err != nil {
+		return err
+	}
+	root := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/sitemap.xml"}
+
+	var entries []sitemapEntry
+	visited := make(map[string]bool)
+	if err := c.collectSitemap(ctx, root.String(), 0, visited, &entries); err != nil {
+		return err
+	}
+
+	var urls []string
+	lastmods, err := os.OpenFile(c.sitemapLastModFileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer lastmods.Close()
+	w := bufio.NewWriter(lastmods)
+	for _, e := range entries {
+		loc, err := c.normalizeURL(e.Loc)
+		if err != nil || !c.inScope(loc) {
+			continue
+		}
+		urls = append(urls, loc)
+		if e.LastMod != "" {
+			fmt.Fprintf(w, "%s\t%s\n", loc, e.LastMod)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	c.log().Info("sitemap read", "urls", len(entries), "in_scope", len(urls))
+	c.storeURLs(urls, FrontierEntry{})
+	return nil
+}
+
+func (c *Crawler) collectSitemap(ctx context.Context, sitemapURL string, depth int, visited map[string]bool, entries *[]sitemapEntry) error {
+	if visited[sitemapURL] || depth > maxSitemapDepth {
+		return nil
+	}
+	visited[sitemapURL] = true
+
+	doc, err := c.fetchSitemap(ctx, sitemapURL)
+	if err != nil {
+		return err
+	}
+	for _, e := range doc.URLs {
+		e.Loc = strings.TrimSpace(e.Loc)
+		e.LastMod = strings.TrimSpace(e.LastMod)
+		if _, err := url.ParseRequestURI(e.Loc); err != nil {
+			c.log().Debug("skipped malformed sitemap entry", "sitemap", sitemapURL, "error", err)
+			continue
+		}
+		*entries = append(*entries, e)
+	}
+	for _, s := range doc.Sitemaps {
+		loc := strings.TrimSpace(s.Loc)
+		if _, err := url.ParseRequestURI(loc); err != nil {
+			c.log().Debug("skipped malformed sitemap entry", "sitemap", sitemapURL, "error", err)
+			continue
+		}
+		// A broken child sitemap shouldn't cost us the rest of the index
+		if err := c.collectSitemap(ctx, loc, depth+1, visited, entries); err != nil {
+			c.log().Warn("could not read sitemap", "sitemap", loc, "error", err)
+		}
+	}
+	return nil
+}
+
+func (c *Crawler) fetchSitemap(ctx context.Context, sitemapURL string) (*sitemapDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sitemapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent(ctx))
+	c.setRequestHeaders(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("bad status code: %d", resp.StatusCode)
+	}
+
+	// .xml.gz sitemaps are served as-is rather than with Content-Encoding,
+	// so sniff the gzip magic bytes instead of trusting the URL or headers
+	body := bufio.NewReader(resp.Body)
+	var r io.Reader = body
+	if magic, err := body.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var doc sitemapDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
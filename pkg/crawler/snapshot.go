@@ -0,0 +1,372 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// snapshotsSubfolder holds one folder per snapshot run, named after the
+// run ID, in the project folder. A snapshot has a manifest.jsonl of the
+// pages the run fetched or confirmed unchanged, and files/ with a hard
+// link to each of their saved files, so a page that didn't change between
+// runs is stored once.
+const snapshotsSubfolder = "snapshots"
+
+func (c *Crawler) snapshotDir(id string) string {
+	return filepath.Join(c.cfg.ProjectFolder, snapshotsSubfolder, id)
+}
+
+// writeSnapshot records the mirror as this run left it. Only entries the
+// run checked, or skipped as fresh, are in it, so a page the site dropped is missing from the
+// snapshot even though the mirror still has it.
+func (c *Crawler) writeSnapshot() error {
+	fsys, ok := c.Storage.(fsStorage)
+	if !ok {
+		return errors.New("snapshots need the fs storage backend")
+	}
+	dir := c.snapshotDir(c.run.ID)
+	// With MaxAge the pages skipped as fresh were checked by earlier runs
+	checkedSince := c.run.StartedAt.Add(-c.cfg.MaxAge)
+	c.pageManifest.mu.Lock()
+	var entries []manifestEntry
+	for _, e := range c.pageManifest.entries {
+		if !e.CheckedAt.Before(checkedSince) {
+			entries = append(entries, e)
+		}
+	}
+	c.pageManifest.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+
+	var lines strings.Builder
+	linked := make(map[string]bool)
+	for _, e := range entries {
+		if !linked[e.File] {
+			linked[e.File] = true
+			codec, _ := fsys.stored(e.File)
+			src := fsys.storedPath(e.File)
+			// A compressed file keeps its suffix, for snapshotText to read it
+			dst := filepath.Join(dir, "files", filepath.FromSlash(e.File)) + codecSuffix(codec)
+			if err := linkOrCopy(src, dst); err != nil {
+				return err
+			}
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		lines.Write(append(line, '\n'))
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "manifest.jsonl"), []byte(lines.String())); err != nil {
+		return err
+	}
+	c.log().Info("snapshot", "pages", len(entries), "folder", dir)
+	return nil
+}
+
+// linkOrCopy hard-links src to dst, copying it where links aren't
+// possible. Saved files are replaced by rename, never rewritten in place,
+// so the link keeps the bytes of this run.
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil || os.IsExist(err) {
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = writeReaderAtomic(dst, f)
+	return err
+}
+
+// Snapshots lists the IDs of the snapshot runs, oldest first
+func (c *Crawler) Snapshots() ([]string, error) {
+	dirs, err := os.ReadDir(filepath.Join(c.cfg.ProjectFolder, snapshotsSubfolder))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, d := range dirs {
+		if _, err := os.Stat(filepath.Join(c.snapshotDir(d.Name()), "manifest.jsonl")); d.IsDir() && err == nil {
+			ids = append(ids, d.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (c *Crawler) loadSnapshot(id string) (map[string]manifestEntry, error) {
+	entries := make(map[string]manifestEntry)
+	err := readJSONL(filepath.Join(c.snapshotDir(id), "manifest.jsonl"), func(line []byte) {
+		var e manifestEntry
+		if json.Unmarshal(line, &e) == nil && e.URL != "" {
+			entries[e.URL] = e
+		}
+	})
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no snapshot %q", id)
+	}
+	return entries, err
+}
+
+// SnapshotDiff is what changed between two snapshot runs
+type SnapshotDiff struct {
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Added     []string      `json:"added"`
+	Removed   []string      `json:"removed"`
+	Changed   []ChangedPage `json:"changed"`
+	Unchanged int           `json:"unchanged"`
+}
+
+// ChangedPage is a page whose content hash differs between the runs.
+// TextDiff is a unified diff of its text, when asked for and both copies
+// are HTML.
+type ChangedPage struct {
+	URL        string `json:"url"`
+	FromSHA256 string `json:"from_sha256"`
+	ToSHA256   string `json:"to_sha256"`
+	TextDiff   string `json:"text_diff,omitempty"`
+}
+
+// DiffSnapshots compares the snapshots of two runs. With text, changed
+// pages get a unified diff of their readable text, extracted as
+// ExtractText says or from the whole body.
+func (c *Crawler) DiffSnapshots(from, to string, text bool) (*SnapshotDiff, error) {
+	a, err := c.loadSnapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.loadSnapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	d := &SnapshotDiff{From: from, To: to, Added: []string{}, Removed: []string{}, Changed: []ChangedPage{}}
+	for url, old := range a {
+		e, ok := b[url]
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, url)
+		case e.SHA256 != old.SHA256:
+			p := ChangedPage{URL: url, FromSHA256: old.SHA256, ToSHA256: e.SHA256}
+			if text && isHTMLType(old.ContentType) && isHTMLType(e.ContentType) {
+				oldText, err := c.snapshotText(from, old.File)
+				if err != nil {
+					return nil, err
+				}
+				newText, err := c.snapshotText(to, e.File)
+				if err != nil {
+					return nil, err
+				}
+				p.TextDiff = unifiedDiff(from+"/"+old.File, to+"/"+e.File, oldText, newText)
+			}
+			d.Changed = append(d.Changed, p)
+		default:
+			d.Unchanged++
+		}
+	}
+	for url := range b {
+		if _, ok := a[url]; !ok {
+			d.Added = append(d.Added, url)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].URL < d.Changed[j].URL })
+	return d, nil
+}
+
+func (c *Crawler) snapshotText(id, file string) (string, error) {
+	f, err := fsStorage{dir: filepath.Join(c.snapshotDir(id), "files")}.LoadPage(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		return "", err
+	}
+	mode := c.cfg.ExtractText
+	if mode == "" {
+		mode = textFull
+	}
+	return pageText(doc, mode), nil
+}
+
+// WriteText writes the diff for people to read
+func (d *SnapshotDiff) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "DIFF %s..%s\n", d.From, d.To)
+	fmt.Fprintf(&sb, "\tADDED=%d REMOVED=%d CHANGED=%d UNCHANGED=%d\n", len(d.Added), len(d.Removed), len(d.Changed), d.Unchanged)
+	for _, url := range d.Added {
+		fmt.Fprintf(&sb, "+ %s\n", url)
+	}
+	for _, url := range d.Removed {
+		fmt.Fprintf(&sb, "- %s\n", url)
+	}
+	for _, p := range d.Changed {
+		fmt.Fprintf(&sb, "~ %s\n", p.URL)
+		sb.WriteString(p.TextDiff)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// diffContext is how many unchanged lines a hunk shows around a change
+const diffContext = 3
+
+// unifiedDiff is a unified diff of two texts by line, empty if they are
+// the same
+func unifiedDiff(fromName, toName, a, b string) string {
+	x, y := splitLines(a), splitLines(b)
+	ops := diffLines(x, y)
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk runs while changes are less than two contexts apart
+		start := max(0, i-diffContext)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = run
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		hunk := ops[start:end]
+		fromStart, toStart := hunk[0].x+1, hunk[0].y+1
+		fromLen, toLen := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				fromLen++
+			}
+			if op.kind != '-' {
+				toLen++
+			}
+		}
+		if fromLen == 0 {
+			fromStart--
+		}
+		if toLen == 0 {
+			toStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", fromStart, fromLen, toStart, toLen)
+		for _, op := range hunk {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffOp is one line of an edit script; x and y are its line indexes in
+// the old and new text
+type diffOp struct {
+	kind byte
+	line string
+	x, y int
+}
+
+// diffLines returns the shortest edit script turning x into y, computed
+// with Myers' algorithm
+func diffLines(x, y []string) []diffOp {
+	n, m := len(x), len(y)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	// trace[d] keeps the diagonals d-1 steps reached, -d-1 to d+1 being all
+	// the walk back needs from it
+	var trace [][]int
+	found := false
+	for d := 0; d <= maxD && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				i = v[offset+k+1]
+			} else {
+				i = v[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i, j = i+1, j+1
+			}
+			v[offset+k] = i
+			if i >= n && j >= m {
+				found = true
+				break
+			}
+		}
+	}
+	// Walk the trace back from the end, collecting the script in reverse
+	var ops []diffOp
+	i, j := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, offset := trace[d], d+1
+		k := i - j
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevI := v[offset+prevK]
+		prevJ := prevI - prevK
+		for i > prevI && j > prevJ {
+			i, j = i-1, j-1
+			ops = append(ops, diffOp{kind: ' ', line: x[i], x: i, y: j})
+		}
+		if d == 0 {
+			break
+		}
+		if i == prevI {
+			j--
+			ops = append(ops, diffOp{kind: '+', line: y[j], x: i, y: j})
+		} else {
+			i--
+			ops = append(ops, diffOp{kind: '-', line: x[i], x: i, y: j})
+		}
+	}
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+	return ops
+}
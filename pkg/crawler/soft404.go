@@ -0,0 +1,121 @@
+package crawler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	// soft404ShingleWords is how many words make up a shingle
+	soft404ShingleWords = 3
+	// soft404Similarity is the share of shingles a page must have in common
+	// with the probe, out of all the shingles of both
+	soft404Similarity = 0.8
+	// soft404ProbeSize caps how much of the probe response is read
+	soft404ProbeSize = 1 << 20
+)
+
+// pageFingerprint is what a page looks like as far as telling soft 404s
+// goes: its title, how long its text is and the hashes of its shingles,
+// the runs of words in it, so that a date or a counter that changes from
+// page to page only changes a few of them
+type pageFingerprint struct {
+	title    string
+	length   int
+	shingles map[uint64]bool
+}
+
+func fingerprint(doc *goquery.Document) *pageFingerprint {
+	words := strings.Fields(pageText(doc, textFull))
+	f := &pageFingerprint{
+		title:    strings.ToLower(strings.TrimSpace(doc.Find("title").First().Text())),
+		shingles: make(map[uint64]bool),
+	}
+	for _, w := range words {
+		f.length += len(w)
+	}
+	for i := 0; i == 0 || i+soft404ShingleWords <= len(words); i++ {
+		h := fnv.New64a()
+		io.WriteString(h, strings.Join(words[i:min(i+soft404ShingleWords, len(words))], " "))
+		f.shingles[h.Sum64()] = true
+	}
+	return f
+}
+
+// like reports whether page is most likely the same page as f: the same
+// title, text of about the same length and mostly the same shingles
+func (f *pageFingerprint) like(page *pageFingerprint) bool {
+	if page.title != f.title || page.length*3 < f.length*2 || page.length*2 > f.length*3 {
+		return false
+	}
+	shared := 0
+	for s := range page.shingles {
+		if f.shingles[s] {
+			shared++
+		}
+	}
+	union := len(f.shingles) + len(page.shingles) - shared
+	return union > 0 && float64(shared)/float64(union) >= soft404Similarity
+}
+
+// probeSoft404 fetches a path of the BaseURL host that can't exist. If the
+// site answers it with a page rather than a 404, that page's fingerprint
+// is what the soft 404s of the site look like.
+func (c *Crawler) probeSoft404(ctx context.Context) error {
+	b := make([]byte, 12)
+	rand.Read(b)
+	base, err := url.Parse(c.cfg.BaseURL)
+	if err != nil {
+		return err
+	}
+	probe := (&url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/" + hex.EncodeToString(b)}).String()
+	resp, err := c.fetchWithRetry(ctx, probe, nil)
+	var se *statusError
+	if errors.As(err, &se) {
+		c.log().Info("soft 404 probe", "url", probe, "status", se.code, "soft_404s", false)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if final := resp.Request.URL.String(); final != probe {
+		// Redirects to one page are already recorded as such
+		c.log().Info("soft 404 probe", "url", probe, "redirected_to", final, "soft_404s", false)
+		return nil
+	}
+	body, err := decodeBody(io.LimitReader(resp.Body, soft404ProbeSize), resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return err
+	}
+	c.soft404 = fingerprint(doc)
+	c.log().Info("soft 404 probe", "url", probe, "status", resp.StatusCode, "soft_404s", true, "title", c.soft404.title)
+	return nil
+}
+
+// isSoft404 reports whether the saved page file, from the BaseURL host,
+// looks like the probe
+func (c *Crawler) isSoft404(file string) (bool, error) {
+	saved, err := c.Storage.LoadPage(file)
+	if err != nil {
+		return false, err
+	}
+	defer saved.Close()
+	doc, err := goquery.NewDocumentFromReader(saved)
+	if err != nil {
+		return false, err
+	}
+	return c.soft404.like(fingerprint(doc)), nil
+}
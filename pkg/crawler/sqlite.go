@@ -0,0 +1,136 @@
+package crawler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema is one row per stored name. headers is the response's as
+// JSON, for bodies that were fetched as they are stored.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS pages (
+	name TEXT PRIMARY KEY,
+	url TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	headers TEXT,
+	body BLOB NOT NULL,
+	size INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	fetched_at TEXT NOT NULL
+)`
+
+// sqliteStorage keeps the bodies in a single SQLite database file, which
+// is easier to sync and back up than a folder of loose files. Every save
+// is a transaction of its own, so a crash loses at most the body being
+// written.
+type sqliteStorage struct {
+	path string
+	db   *sql.DB
+}
+
+// openSQLite opens the database at path, creating it with schema if it
+// is new, and sets the pragmas, like "journal_mode(WAL)", on it
+func openSQLite(path, schema string, pragmas ...string) (*sql.DB, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?_pragma=busy_timeout(10000)"
+	for _, p := range pragmas {
+		dsn += "&_pragma=" + url.QueryEscape(p)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes the writers, which SQLite would anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func newSQLiteStorage(path string) (*sqliteStorage, error) {
+	db, err := openSQLite(path, sqliteSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStorage{path: path, db: db}, nil
+}
+
+// SavePage reads body in full before writing the row, so a failed read
+// leaves the previous body in place
+func (s *sqliteStorage) SavePage(name string, meta PageMeta, body io.Reader) (int64, error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return int64(len(b)), err
+	}
+	var headers []byte
+	if meta.Header != nil {
+		if headers, err = json.Marshal(meta.Header); err != nil {
+			return 0, err
+		}
+	}
+	sum := sha256.Sum256(b)
+	_, err = s.db.Exec(`INSERT INTO pages (name, url, content_type, headers, body, size, sha256, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET url = excluded.url, content_type = excluded.content_type,
+			headers = excluded.headers, body = excluded.body, size = excluded.size,
+			sha256 = excluded.sha256, fetched_at = excluded.fetched_at`,
+		name, meta.URL, meta.ContentType, nullIfEmpty(headers), b, len(b),
+		hex.EncodeToString(sum[:]), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(b)), nil
+}
+
+func (s *sqliteStorage) Exists(name string) (int64, bool) {
+	var size int64
+	if err := s.db.QueryRow(`SELECT size FROM pages WHERE name = ?`, name).Scan(&size); err != nil {
+		return 0, false
+	}
+	return size, true
+}
+
+// LoadPage reads the whole body, so the connection isn't held while it is
+// read
+func (s *sqliteStorage) LoadPage(name string) (io.ReadCloser, error) {
+	var b []byte
+	err := s.db.QueryRow(`SELECT body FROM pages WHERE name = ?`, name).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *sqliteStorage) Remove(name string) error {
+	_, err := s.db.Exec(`DELETE FROM pages WHERE name = ?`, name)
+	return err
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStorage) String() string {
+	return s.path
+}
+
+// nullIfEmpty stores an empty value as NULL
+func nullIfEmpty(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
@@ -0,0 +1,402 @@
+package crawler
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// frontierSchema is one row per URL, seq keeping the order they were
+// first seen in. The partial index makes NextBatch a range scan over the
+// pending URLs only.
+const frontierSchema = `CREATE TABLE IF NOT EXISTS urls (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL UNIQUE,
+	found INTEGER NOT NULL DEFAULT 0,
+	depth INTEGER NOT NULL DEFAULT 0,
+	referrer TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL DEFAULT '',
+	chain INTEGER NOT NULL DEFAULT 0,
+	priority INTEGER NOT NULL DEFAULT 0,
+	state TEXT NOT NULL,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	found_at TEXT NOT NULL DEFAULT '',
+	updated_at TEXT NOT NULL DEFAULT '',
+	fetched_at TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS urls_pending ON urls (seq) WHERE found = 1 AND state = 'pending';
+CREATE INDEX IF NOT EXISTS urls_state ON urls (state, seq)`
+
+// frontierOrderIndexes make NextOrdered a range scan too, one index per
+// crawl order. They are made after older databases get their priority
+// column.
+const frontierOrderIndexes = `CREATE INDEX IF NOT EXISTS urls_bfs ON urls (priority DESC, depth, seq) WHERE found = 1 AND state = 'pending';
+CREATE INDEX IF NOT EXISTS urls_dfs ON urls (priority DESC, depth DESC, seq DESC) WHERE found = 1 AND state = 'pending'`
+
+// urlColumns are the columns scanRecord reads, in its order
+const urlColumns = `url, found, depth, referrer, source, chain, priority, state, failures, last_error, found_at, updated_at, fetched_at`
+
+// sqliteFrontier is the default frontier: a row per URL in frontier.db,
+// with the same fields as a journal record. Nothing is kept in memory, so
+// it holds crawls of any size, and the database ranks the pending URLs in
+// crawl order too. Each change is written in place rather than appended,
+// so there is nothing to compact. The database is in WAL mode; Flush
+// checkpoints it.
+type sqliteFrontier struct {
+	path string
+	// legacyPaths are the journal and the text frontier's found file,
+	// which a project that hasn't been migrated yet still has
+	legacyPaths []string
+
+	// mu makes the read-then-write of a mark atomic
+	mu sync.Mutex
+	db *sql.DB
+}
+
+func (s *sqliteFrontier) Open() error {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		for _, legacy := range s.legacyPaths {
+			if info, err := os.Stat(legacy); err == nil && info.Size() > 0 {
+				return fmt.Errorf("%s is from an older frontier; run the migrate command to import it, or set FRONTIER to the backend it is for", legacy)
+			}
+		}
+	}
+	db, err := openSQLite(s.path, frontierSchema, "journal_mode(WAL)", "synchronous(NORMAL)")
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", s.path, err)
+	}
+	if err := addPriorityColumn(db); err != nil {
+		db.Close()
+		return fmt.Errorf("upgrading %s: %w", s.path, err)
+	}
+	if _, err := db.Exec(frontierOrderIndexes); err != nil {
+		db.Close()
+		return fmt.Errorf("upgrading %s: %w", s.path, err)
+	}
+	s.db = db
+	return nil
+}
+
+// addPriorityColumn gives a frontier.db made before priorities were kept
+// its priority column
+func addPriorityColumn(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM pragma_table_info('urls') WHERE name = 'priority'`).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	_, err := db.Exec(`ALTER TABLE urls ADD COLUMN priority INTEGER NOT NULL DEFAULT 0`)
+	return err
+}
+
+// execer is a database or a transaction in it
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// putRecord writes r, whatever the URL's row held before
+func putRecord(db execer, r *journalRecord) error {
+	_, err := db.Exec(`INSERT INTO urls (`+urlColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET found = excluded.found, depth = excluded.depth,
+			referrer = excluded.referrer, source = excluded.source, chain = excluded.chain,
+			priority = excluded.priority, state = excluded.state, failures = excluded.failures, last_error = excluded.last_error,
+			found_at = excluded.found_at, updated_at = excluded.updated_at, fetched_at = excluded.fetched_at`,
+		r.URL, r.Found, r.Depth, r.Referrer, r.Source, r.Chain, r.Priority, r.State, r.Failures, r.LastError,
+		formatTime(r.FoundAt), formatTime(r.UpdatedAt), formatTime(r.FetchedAt))
+	return err
+}
+
+// record returns url's row to modify, or a new one
+func (s *sqliteFrontier) record(url string) (*journalRecord, error) {
+	r, err := scanRecord(s.db.QueryRow(`SELECT `+urlColumns+` FROM urls WHERE url = ?`, url))
+	if err == sql.ErrNoRows {
+		return &journalRecord{URL: url, State: statePending}, nil
+	}
+	return r, err
+}
+
+// scanRecord reads a row of urlColumns
+func scanRecord(row interface{ Scan(...any) error }) (*journalRecord, error) {
+	var r journalRecord
+	var foundAt, updatedAt, fetchedAt string
+	err := row.Scan(&r.URL, &r.Found, &r.Depth, &r.Referrer, &r.Source, &r.Chain, &r.Priority, &r.State,
+		&r.Failures, &r.LastError, &foundAt, &updatedAt, &fetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.FoundAt, r.UpdatedAt, r.FetchedAt = parseTime(foundAt), parseTime(updatedAt), parseTime(fetchedAt)
+	return &r, nil
+}
+
+// records returns the rows a query for urlColumns selects; errors end
+// the list early
+func (s *sqliteFrontier) records(query string, args ...any) []*journalRecord {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var records []*journalRecord
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			break
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+func (s *sqliteFrontier) Add(e FrontierEntry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.record(e.URL)
+	if err != nil || r.Found {
+		return false, err
+	}
+	now := time.Now().UTC()
+	r.Found, r.Depth, r.Referrer, r.Source, r.Chain, r.FoundAt, r.UpdatedAt = true, e.Depth, e.Referrer, e.Source, e.Chain, now, now
+	r.Priority = e.Priority
+	return true, putRecord(s.db, r)
+}
+
+func (s *sqliteFrontier) NextBatch(n int) []FrontierEntry {
+	return entries(s.records(`SELECT `+urlColumns+` FROM urls WHERE found = 1 AND state = 'pending' ORDER BY seq LIMIT ?`, n))
+}
+
+func (s *sqliteFrontier) NextOrdered(n int, dfs bool) []FrontierEntry {
+	order := `priority DESC, depth, seq`
+	if dfs {
+		order = `priority DESC, depth DESC, seq DESC`
+	}
+	return entries(s.records(`SELECT `+urlColumns+` FROM urls WHERE found = 1 AND state = 'pending' ORDER BY `+order+` LIMIT ?`, n))
+}
+
+// reprioritizeBatch is how many pending rows Reprioritize reads at a time
+const reprioritizeBatch = 1000
+
+// Reprioritize pages through the pending rows by seq, so only a batch of
+// them is ever in memory
+func (s *sqliteFrontier) Reprioritize(priority func(url string) int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type change struct {
+		seq      int64
+		priority int
+	}
+	for after := int64(0); ; {
+		rows, err := s.db.Query(`SELECT seq, url, priority FROM urls WHERE found = 1 AND state = 'pending' AND seq > ? ORDER BY seq LIMIT ?`, after, reprioritizeBatch)
+		if err != nil {
+			return err
+		}
+		var changes []change
+		read := 0
+		for rows.Next() {
+			var url string
+			var old int
+			if err := rows.Scan(&after, &url, &old); err != nil {
+				rows.Close()
+				return err
+			}
+			read++
+			if p := priority(url); p != old {
+				changes = append(changes, change{after, p})
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			tx, err := s.db.Begin()
+			if err != nil {
+				return err
+			}
+			for _, ch := range changes {
+				if _, err := tx.Exec(`UPDATE urls SET priority = ? WHERE seq = ?`, ch.priority, ch.seq); err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+		}
+		if read < reprioritizeBatch {
+			return nil
+		}
+	}
+}
+
+func entries(records []*journalRecord) []FrontierEntry {
+	var found []FrontierEntry
+	for _, r := range records {
+		found = append(found, FrontierEntry{URL: r.URL, Depth: r.Depth, Referrer: r.Referrer, Source: r.Source, Chain: r.Chain, Priority: r.Priority})
+	}
+	return found
+}
+
+func (s *sqliteFrontier) Stats() FrontierStats {
+	var st FrontierStats
+	s.db.QueryRow(`SELECT coalesce(sum(found), 0), coalesce(sum(state = 'scraped'), 0),
+		coalesce(sum(state = 'failed'), 0), coalesce(sum(found = 1 AND state = 'pending'), 0) FROM urls`).
+		Scan(&st.Found, &st.Scraped, &st.Failed, &st.Pending)
+	return st
+}
+
+func (s *sqliteFrontier) Found() []FrontierEntry {
+	return entries(s.records(`SELECT ` + urlColumns + ` FROM urls WHERE found = 1 ORDER BY seq`))
+}
+
+func (s *sqliteFrontier) MarkScraped(url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.record(url)
+	if err != nil || r.State == stateScraped {
+		return err
+	}
+	now := time.Now()
+	r.State, r.UpdatedAt, r.FetchedAt = stateScraped, now, now
+	return putRecord(s.db, r)
+}
+
+// FetchedAt falls back on when the URL was marked scraped for records
+// imported from journals written before fetch times were kept
+func (s *sqliteFrontier) FetchedAt(url string) time.Time {
+	r, err := s.record(url)
+	if err != nil {
+		return time.Time{}
+	}
+	if r.FetchedAt.IsZero() && r.State == stateScraped {
+		return r.UpdatedAt
+	}
+	return r.FetchedAt
+}
+
+func (s *sqliteFrontier) IsScraped(url string) bool {
+	var n int
+	s.db.QueryRow(`SELECT count(*) FROM urls WHERE url = ? AND state = 'scraped'`, url).Scan(&n)
+	return n > 0
+}
+
+func (s *sqliteFrontier) Scraped() []string {
+	var urls []string
+	for _, r := range s.records(`SELECT `+urlColumns+` FROM urls WHERE state = ? ORDER BY seq`, stateScraped) {
+		urls = append(urls, r.URL)
+	}
+	return urls
+}
+
+// MarkFailed keeps the referrer of a URL that was never found, like an
+// asset, since there is no found one to fall back on
+func (s *sqliteFrontier) MarkFailed(f FailedURL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.record(f.URL)
+	if err != nil || r.State == stateFailed {
+		return err
+	}
+	r.State, r.UpdatedAt = stateFailed, f.At
+	r.Failures++
+	r.LastError = strings.Join(strings.Fields(f.Reason), " ")
+	if r.Referrer == "" {
+		r.Referrer = f.Referrer
+	}
+	return putRecord(s.db, r)
+}
+
+func (s *sqliteFrontier) Failed() []FailedURL {
+	var failed []FailedURL
+	for _, r := range s.records(`SELECT `+urlColumns+` FROM urls WHERE state = ? ORDER BY seq`, stateFailed) {
+		failed = append(failed, FailedURL{URL: r.URL, Reason: r.LastError, Referrer: r.Referrer, At: r.UpdatedAt})
+	}
+	return failed
+}
+
+func (s *sqliteFrontier) ForgetScraped(urls map[string]bool) error {
+	return s.forget(stateScraped, urls)
+}
+
+func (s *sqliteFrontier) ForgetFailed(urls map[string]bool) error {
+	return s.forget(stateFailed, urls)
+}
+
+// forget makes the URLs in state pending again, only those in urls unless
+// it is nil
+func (s *sqliteFrontier) forget(state string, urls map[string]bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := formatTime(time.Now())
+	if urls == nil {
+		_, err := s.db.Exec(`UPDATE urls SET state = ?, updated_at = ? WHERE state = ?`, statePending, now, state)
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for u := range urls {
+		if _, err := tx.Exec(`UPDATE urls SET state = ?, updated_at = ? WHERE url = ? AND state = ?`, statePending, now, u, state); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Flush moves the write-ahead log into the database, syncing both
+func (s *sqliteFrontier) Flush() error {
+	_, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
+
+func (s *sqliteFrontier) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.Flush()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	s.db = nil
+	return err
+}
+
+// importJournal copies every record of the journal at path, as it was
+func (s *sqliteFrontier) importJournal(path string) (FrontierStats, error) {
+	j := &journalFrontier{path: path}
+	j.records = make(map[string]*journalRecord)
+	if err := j.load(); err != nil {
+		return FrontierStats{}, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return FrontierStats{}, err
+	}
+	defer tx.Rollback()
+	for _, u := range j.order {
+		if err := putRecord(tx, j.records[u]); err != nil {
+			return FrontierStats{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return FrontierStats{}, err
+	}
+	return s.Stats(), nil
+}
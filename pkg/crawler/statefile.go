@@ -0,0 +1,205 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// stateFlushInterval is how often buffered state lines are written out
+const stateFlushInterval = 5 * time.Second
+
+// stateFiles lists the open state files besides the frontier's, for
+// flushing and closing
+func (c *Crawler) stateFiles() []*stateFile {
+	return []*stateFile{c.redirectsFile, c.referrersFile, c.externalFile, c.assetURLsFile, c.trapsFile, c.budgetFile, c.imageURLsFile, c.canonicalsFile, c.malformedFile}
+}
+
+// stateFile is one of the append-only URL lists. Its lines are loaded into
+// memory once, so checking whether a URL is already present never touches
+// the disk, and new lines go through a buffered writer that is flushed
+// periodically and on shutdown. The file on disk stays the durable format
+// a later run resumes from.
+type stateFile struct {
+	mu    sync.Mutex
+	lines []string
+	keys  map[string]bool
+	key   func(string) string
+	file  *os.File
+	w     *bufio.Writer
+}
+
+func openStateFile(path string) (*stateFile, error) {
+	return openKeyedStateFile(path, lineKey)
+}
+
+// openKeyedStateFile opens a state file whose lines are deduplicated by
+// key(line) instead of by URL
+func openKeyedStateFile(path string, key func(string) string) (*stateFile, error) {
+	lines, err := readLines(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &stateFile{
+		lines: lines,
+		keys:  make(map[string]bool, len(lines)),
+		key:   key,
+		file:  f,
+		w:     bufio.NewWriter(f),
+	}
+	for _, line := range lines {
+		s.keys[key(line)] = true
+	}
+	return s, nil
+}
+
+// add appends line unless the file already has a line with the same key,
+// reporting whether it was added.
+func (s *stateFile) add(line string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.key(line)
+	if s.keys[key] {
+		return false, nil
+	}
+	if _, err := s.w.WriteString(line + "\n"); err != nil {
+		return false, err
+	}
+	s.keys[key] = true
+	s.lines = append(s.lines, line)
+	return true, nil
+}
+
+func (s *stateFile) has(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[url]
+}
+
+// snapshot returns a copy of the lines, including ones not yet flushed
+func (s *stateFile) snapshot() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+// flush writes out buffered lines and fsyncs, making them a checkpoint a
+// crash can't roll back
+func (s *stateFile) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// truncate empties the file, dropping any lines not yet flushed
+func (s *stateFile) truncate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Reset(s.file)
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	s.lines = nil
+	clear(s.keys)
+	return nil
+}
+
+func (s *stateFile) close() error {
+	if err := s.flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// openStateFiles opens the frontier and the other state files
+func (c *Crawler) openStateFiles() error {
+	if err := c.Frontier.Open(); err != nil {
+		return err
+	}
+	var err error
+	if c.redirectsFile, err = openStateFile(c.redirectsFileName); err != nil {
+		return err
+	}
+	if c.referrersFile, err = openKeyedStateFile(c.referrersFileName, wholeLine); err != nil {
+		return err
+	}
+	if c.externalFile, err = openKeyedStateFile(c.externalFileName, wholeLine); err != nil {
+		return err
+	}
+	if c.assetURLsFile, err = openKeyedStateFile(c.assetURLsFileName, wholeLine); err != nil {
+		return err
+	}
+	if c.trapsFile, err = openStateFile(c.trapsFileName); err != nil {
+		return err
+	}
+	if c.budgetFile, err = openStateFile(c.budgetFileName); err != nil {
+		return err
+	}
+	if c.malformedFile, err = openStateFile(c.malformedFileName); err != nil {
+		return err
+	}
+	if c.cfg.RespectCanonical {
+		if c.canonicalsFile, err = openStateFile(c.canonicalsFileName); err != nil {
+			return err
+		}
+	}
+	if c.cfg.CrawlImages {
+		if c.imageURLsFile, err = openKeyedStateFile(c.imageURLsFileName, wholeLine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Crawler) flushStateFiles() error {
+	if err := c.Frontier.Flush(); err != nil {
+		return err
+	}
+	for _, s := range c.stateFiles() {
+		if s == nil {
+			continue
+		}
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Crawler) closeStateFiles() error {
+	firstErr := c.Frontier.Close()
+	for _, s := range c.stateFiles() {
+		if s == nil {
+			continue
+		}
+		if err := s.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// flushStatePeriodically writes buffered state out every stateFlushInterval
+// until ctx is done, so a crash loses at most a few seconds of progress.
+func (c *Crawler) flushStatePeriodically(ctx context.Context) {
+	ticker := time.NewTicker(stateFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = c.flushStateFiles()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
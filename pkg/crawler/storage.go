@@ -0,0 +1,153 @@
+package crawler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Storage keeps the saved bodies: pages, assets and their extra copies.
+// Names are the slash-separated paths the manifest records, relative to
+// the download folder; the manifest stays the index from URLs to names.
+// Its methods may be called from several goroutines.
+type Storage interface {
+	// SavePage stores body under name and returns the bytes written. If
+	// reading body fails, whatever name held before is left in place.
+	SavePage(name string, meta PageMeta, body io.Reader) (int64, error)
+	// Exists reports whether name is stored, and its size if it is
+	Exists(name string) (int64, bool)
+	// LoadPage opens a stored body; a missing one is an os.ErrNotExist
+	LoadPage(name string) (io.ReadCloser, error)
+	// Remove deletes name; removing one that isn't there is not an error
+	Remove(name string) error
+}
+
+// PageMeta describes a body being saved, for backends that store it
+// alongside
+type PageMeta struct {
+	URL         string
+	ContentType string
+	// Header is that of the response the body was fetched with, if it
+	// was fetched
+	Header http.Header
+}
+
+// fsStorage is the default storage: plain files in the download folder.
+// With compress set to a codec, "gzip" or "zstd", bodies of text types are
+// stored compressed as name plus the codec's suffix; every kind is read
+// back the same way, whatever compress is, so a project can be part
+// compressed, or compressed with both.
+type fsStorage struct {
+	dir      string
+	compress string
+}
+
+func (s fsStorage) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+func (s fsStorage) SavePage(name string, meta PageMeta, body io.Reader) (int64, error) {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return 0, err
+	}
+	codec := s.compress
+	if codecSuffix(codec) == "" || !compressible(name, meta.ContentType) {
+		codec = ""
+	}
+	var n int64
+	var err error
+	if codec == "" {
+		n, err = writeReaderAtomic(p, body)
+	} else {
+		n, err = writeCompressedAtomic(p+codecSuffix(codec), codec, body)
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, s.removeCopies(name, codec)
+}
+
+// removeCopies removes the files name is stored as other than with codec,
+// "" being the plain file
+func (s fsStorage) removeCopies(name, codec string) error {
+	var errs []error
+	for _, other := range append([]string{""}, codecs...) {
+		if other != codec {
+			errs = append(errs, removeIfExists(s.path(name)+codecSuffix(other)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Exists reports the size of the body, which for a gzipped one is read
+// from the gzip trailer, so it is only right up to 4GB, and for a zstd one
+// is counted decompressing it
+func (s fsStorage) Exists(name string) (int64, bool) {
+	info, err := os.Stat(s.path(name))
+	if err == nil && !info.IsDir() {
+		return info.Size(), true
+	}
+	for _, codec := range codecs {
+		if size, ok := compressedSize(s.path(name)+codecSuffix(codec), codec); ok {
+			return size, true
+		}
+	}
+	return 0, false
+}
+
+func (s fsStorage) LoadPage(name string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(name))
+	if !os.IsNotExist(err) {
+		return f, err
+	}
+	for _, codec := range codecs {
+		r, codecErr := openCompressed(s.path(name)+codecSuffix(codec), codec)
+		if !os.IsNotExist(codecErr) {
+			return r, codecErr
+		}
+	}
+	return nil, err
+}
+
+func (s fsStorage) Remove(name string) error {
+	errs := []error{removeIfExists(s.path(name))}
+	for _, codec := range codecs {
+		errs = append(errs, removeIfExists(s.path(name)+codecSuffix(codec)))
+	}
+	return errors.Join(errs...)
+}
+
+// stored returns the codec name is stored with, if any, and the size of
+// the file on disk
+func (s fsStorage) stored(name string) (codec string, size int64) {
+	if info, err := os.Stat(s.path(name)); err == nil {
+		return "", info.Size()
+	}
+	for _, codec := range codecs {
+		if info, err := os.Stat(s.path(name) + codecSuffix(codec)); err == nil {
+			return codec, info.Size()
+		}
+	}
+	return "", 0
+}
+
+// storedPath is the file name is kept in
+func (s fsStorage) storedPath(name string) string {
+	codec, _ := s.stored(name)
+	return s.path(name) + codecSuffix(codec)
+}
+
+func removeIfExists(p string) error {
+	err := os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s fsStorage) String() string {
+	return s.dir
+}
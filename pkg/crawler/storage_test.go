@@ -0,0 +1,318 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// fakeS3 is a bucket in memory that answers the requests s3Storage makes,
+// without checking their signatures
+func fakeS3(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		key := r.URL.Path
+		switch r.Method {
+		case http.MethodPut:
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			objects[key] = b
+		case http.MethodGet, http.MethodHead:
+			b, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			if r.Method == http.MethodGet {
+				w.Write(b)
+			}
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStorageBackends(t *testing.T) {
+	s3, err := newS3Storage(S3Config{Endpoint: fakeS3(t).URL, Bucket: "b", AccessKeyID: "id", SecretAccessKey: "secret", Prefix: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := newSQLiteStorage(filepath.Join(t.TempDir(), "pages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	backends := []struct {
+		name string
+		s    Storage
+	}{
+		{"fs", fsStorage{dir: t.TempDir(), compress: compressNone}},
+		{"fs gzip", fsStorage{dir: t.TempDir(), compress: compressGzip}},
+		{"fs zstd", fsStorage{dir: t.TempDir(), compress: compressZstd}},
+		{"s3", s3},
+		{"sqlite", sqlite},
+	}
+	meta := PageMeta{URL: "https://example.com/a", ContentType: "text/html", Header: http.Header{"Etag": {`"1"`}}}
+	const name = "docs/a.html"
+	for _, b := range backends {
+		if _, err := b.s.LoadPage(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: loading a missing page = %v, want fs.ErrNotExist", b.name, err)
+		}
+		if n, err := b.s.SavePage(name, meta, strings.NewReader("<p>one</p>")); err != nil || n != 10 {
+			t.Errorf("%s: SavePage = %d, %v", b.name, n, err)
+		}
+		if _, err := b.s.SavePage(name, meta, strings.NewReader("<p>two</p>")); err != nil {
+			t.Errorf("%s: overwriting: %v", b.name, err)
+		}
+		broken := io.MultiReader(strings.NewReader("<p>thr"), iotest.ErrReader(errors.New("connection reset")))
+		if _, err := b.s.SavePage(name, meta, broken); err == nil {
+			t.Errorf("%s: saving a body that fails to read succeeded", b.name)
+		}
+		if got := loadString(b.s, name); got != "<p>two</p>" {
+			t.Errorf("%s: loaded %q, want the last body read in full", b.name, got)
+		}
+		if size, ok := b.s.Exists(name); !ok || size != 10 {
+			t.Errorf("%s: Exists = %d, %v, want 10, true", b.name, size, ok)
+		}
+		if err := b.s.Remove(name); err != nil {
+			t.Errorf("%s: Remove: %v", b.name, err)
+		}
+		if _, ok := b.s.Exists(name); ok {
+			t.Errorf("%s: the page is still there after Remove", b.name)
+		}
+		if err := b.s.Remove(name); err != nil {
+			t.Errorf("%s: removing a missing page: %v", b.name, err)
+		}
+	}
+}
+
+// TestStorageCodecs changes the codec of an fs project between saves: a
+// file is stored with the last one only, and read back whatever the
+// storage's codec is now
+func TestStorageCodecs(t *testing.T) {
+	tests := []struct {
+		name        string
+		saved, read string
+		file        string
+	}{
+		{"plain read by zstd", compressNone, compressZstd, "a.html"},
+		{"gzip read by zstd", compressGzip, compressZstd, "a.html.gz"},
+		{"zstd read by gzip", compressZstd, compressGzip, "a.html.zst"},
+		{"zstd read by none", compressZstd, compressNone, "a.html.zst"},
+		{"zstd over gzip", compressZstd, compressZstd, "a.html.zst"},
+	}
+	const body = "<p>hello, hello, hello</p>"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			meta := PageMeta{ContentType: "text/html"}
+			// Every case starts with a gzipped copy the save should replace
+			if _, err := (fsStorage{dir: dir, compress: compressGzip}).SavePage("a.html", meta, strings.NewReader("old")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := (fsStorage{dir: dir, compress: tt.saved}).SavePage("a.html", meta, strings.NewReader(body)); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != tt.file {
+				t.Errorf("stored as %v, want only %s", entries, tt.file)
+			}
+			s := fsStorage{dir: dir, compress: tt.read}
+			if got := loadString(s, "a.html"); got != body {
+				t.Errorf("loaded %q", got)
+			}
+			if size, ok := s.Exists("a.html"); !ok || size != int64(len(body)) {
+				t.Errorf("Exists = %d, %v", size, ok)
+			}
+			if codec, _ := s.stored("a.html"); codecSuffix(codec) != strings.TrimPrefix(tt.file, "a.html") {
+				t.Errorf("stored with %q", codec)
+			}
+		})
+	}
+}
+
+func loadString(s Storage, name string) string {
+	f, err := s.LoadPage(name)
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+	b, _ := io.ReadAll(f)
+	return string(b)
+}
+
+func TestSQLiteStorageRow(t *testing.T) {
+	s, err := newSQLiteStorage(filepath.Join(t.TempDir(), "pages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	meta := PageMeta{URL: "https://example.com/", ContentType: "text/html", Header: http.Header{"Etag": {`"1"`}}}
+	if _, err := s.SavePage("index.html", meta, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var url, contentType, headers, sum, fetchedAt string
+	err = s.db.QueryRow(`SELECT url, content_type, headers, sha256, fetched_at FROM pages WHERE name = ?`, "index.html").
+		Scan(&url, &contentType, &headers, &sum, &fetchedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ column, got, want string }{
+		{"url", url, meta.URL},
+		{"content_type", contentType, meta.ContentType},
+		{"headers", headers, `{"Etag":["\"1\""]}`},
+		{"sha256", sum, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.column, tt.got, tt.want)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, fetchedAt); err != nil {
+		t.Errorf("fetched_at %q: %v", fetchedAt, err)
+	}
+}
+
+// TestCrawlToSQLite crawls into the sqlite backend: the pages end up as
+// rows of pages.db in the project folder
+func TestCrawlToSQLite(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Path == "/" {
+			w.Write([]byte(`<html><title>home</title><a href="/a">a</a></html>`))
+			return
+		}
+		w.Write([]byte(`<html><title>a</title></html>`))
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.StorageBackend = "sqlite"
+	})
+	s, ok := c.Storage.(*sqliteStorage)
+	if !ok {
+		t.Fatalf("Storage is a %T", c.Storage)
+	}
+	defer s.Close()
+	if s.path != filepath.Join(c.cfg.ProjectFolder, "pages.db") {
+		t.Errorf("database at %s", s.path)
+	}
+	var files []string
+	c.OnPage = func(p PageResult) { files = append(files, p.File) }
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("saved %v, want 2 pages", files)
+	}
+	for _, f := range files {
+		if got := readSaved(t, c, f); !strings.Contains(got, "<title>") {
+			t.Errorf("%s holds %q", f, got)
+		}
+	}
+	var rows int
+	if err := s.db.QueryRow(`SELECT count(*) FROM pages WHERE headers LIKE '%text/html%'`).Scan(&rows); err != nil || rows < 2 {
+		t.Errorf("%d rows with their headers, %v", rows, err)
+	}
+}
+
+// samplePage is an HTML page of about size bytes, its words drawn from a
+// fixed vocabulary the way a docs site repeats its terms and markup
+func samplePage(rng *rand.Rand, size int) []byte {
+	words := strings.Fields("the crawler saves every page it fetches into the project folder with its links rewritten " +
+		"configuration request response header status content type storage backend frontier manifest report")
+	var b bytes.Buffer
+	b.WriteString(`<!DOCTYPE html><html><head><meta charset="utf-8"><title>Docs</title>` +
+		`<link rel="stylesheet" href="/static/site.css"></head><body><nav><a href="/">Home</a> <a href="/docs/">Docs</a></nav><main>`)
+	for b.Len() < size {
+		fmt.Fprintf(&b, `<h2 id="s%d">Section %d</h2><p>`, b.Len(), b.Len())
+		for i := 0; i < 60; i++ {
+			b.WriteString(words[rng.Intn(len(words))])
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, `<a href="/docs/page-%d.html">more</a></p>`, rng.Intn(1000))
+	}
+	b.WriteString(`</main><footer>© Example</footer></body></html>`)
+	return b.Bytes()
+}
+
+// BenchmarkStorageCompression saves and loads sample pages with each
+// codec: ns/op is the CPU cost per page and stored-% the disk taken as a
+// share of the page's size
+func BenchmarkStorageCompression(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	pages := make([][]byte, 16)
+	for i := range pages {
+		pages[i] = samplePage(rng, 60<<10)
+	}
+	meta := PageMeta{ContentType: "text/html"}
+	for _, codec := range []string{compressNone, compressGzip, compressZstd} {
+		s := fsStorage{dir: b.TempDir(), compress: codec}
+		b.Run(codec+"/save", func(b *testing.B) {
+			var raw, stored int64
+			for i := 0; i < b.N; i++ {
+				page := pages[i%len(pages)]
+				name := fmt.Sprintf("page-%d.html", i%len(pages))
+				if _, err := s.SavePage(name, meta, bytes.NewReader(page)); err != nil {
+					b.Fatal(err)
+				}
+				_, size := s.stored(name)
+				raw, stored = raw+int64(len(page)), stored+size
+			}
+			b.SetBytes(int64(len(pages[0])))
+			b.ReportMetric(100*float64(stored)/float64(raw), "stored-%")
+		})
+		b.Run(codec+"/load", func(b *testing.B) {
+			for i, page := range pages {
+				if _, err := s.SavePage(fmt.Sprintf("page-%d.html", i), meta, bytes.NewReader(page)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := s.LoadPage(fmt.Sprintf("page-%d.html", i%len(pages)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, f)
+				f.Close()
+			}
+			b.SetBytes(int64(len(pages[0])))
+		})
+	}
+}
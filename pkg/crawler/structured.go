@@ -0,0 +1,176 @@
+package crawler
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// structuredRecord is one line of structured_data.jsonl: one schema.org
+// item from a page. A JSON-LD block that doesn't parse gives a record with
+// its Error and the block as Raw instead.
+type structuredRecord struct {
+	URL         string          `json:"url"`
+	Type        string          `json:"type,omitempty"`
+	Format      string          `json:"format"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Raw         string          `json:"raw,omitempty"`
+	ExtractedAt time.Time       `json:"extracted_at"`
+}
+
+// applyStructuredData writes the JSON-LD blocks and microdata items of a
+// parsed page to structured_data.jsonl
+func (c *Crawler) applyStructuredData(url string, doc *goquery.Document) {
+	if c.structured == nil || doc == nil {
+		return
+	}
+	now := time.Now().UTC()
+	write := func(r structuredRecord) {
+		r.URL, r.ExtractedAt = url, now
+		_ = c.structured.write(r)
+	}
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		block := strings.TrimSpace(s.Text())
+		if block == "" {
+			return
+		}
+		var v any
+		if err := json.Unmarshal([]byte(block), &v); err != nil {
+			write(structuredRecord{Format: "json-ld", Error: err.Error(), Raw: block})
+			return
+		}
+		for _, item := range flattenJSONLD(v) {
+			data, _ := json.Marshal(item)
+			write(structuredRecord{Type: schemaType(item["@type"]), Format: "json-ld", Data: data})
+		}
+	})
+	// Items nested in another item's property are part of that item
+	doc.Find("[itemscope]").Not("[itemprop]").Each(func(i int, s *goquery.Selection) {
+		item := microdataItem(s)
+		data, _ := json.Marshal(item)
+		write(structuredRecord{Type: schemaType(item["@type"]), Format: "microdata", Data: data})
+	})
+}
+
+// flattenJSONLD returns the items of a JSON-LD block: the block itself,
+// the elements of a top-level array, or the nodes of an @graph
+func flattenJSONLD(v any) []map[string]any {
+	var items []map[string]any
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			items = append(items, flattenJSONLD(e)...)
+		}
+	case map[string]any:
+		graph, ok := v["@graph"].([]any)
+		if !ok {
+			return []map[string]any{v}
+		}
+		for _, e := range graph {
+			for _, node := range flattenJSONLD(e) {
+				// Graph nodes share the wrapper's @context
+				if _, ok := node["@context"]; !ok && v["@context"] != nil {
+					node["@context"] = v["@context"]
+				}
+				items = append(items, node)
+			}
+		}
+	}
+	return items
+}
+
+// schemaType is an item's @type without the schema.org prefix, several
+// types joined by commas
+func schemaType(t any) string {
+	var types []string
+	switch t := t.(type) {
+	case string:
+		types = strings.Fields(t)
+	case []any:
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	for i, s := range types {
+		for _, prefix := range []string{"https://schema.org/", "http://schema.org/"} {
+			s = strings.TrimPrefix(s, prefix)
+		}
+		types[i] = s
+	}
+	return strings.Join(types, ",")
+}
+
+// microdataItem reads an itemscope element into a JSON-LD-like object.
+// A property given more than once becomes an array.
+func microdataItem(scope *goquery.Selection) map[string]any {
+	item := make(map[string]any)
+	if t, ok := scope.Attr("itemtype"); ok {
+		item["@type"] = strings.TrimSpace(t)
+	}
+	if id, ok := scope.Attr("itemid"); ok {
+		item["@id"] = strings.TrimSpace(id)
+	}
+	var walk func(s *goquery.Selection)
+	walk = func(s *goquery.Selection) {
+		s.Children().Each(func(i int, child *goquery.Selection) {
+			names, isProp := child.Attr("itemprop")
+			_, isScope := child.Attr("itemscope")
+			if isProp {
+				var value any
+				if isScope {
+					value = microdataItem(child)
+				} else {
+					value = microdataValue(child)
+				}
+				for _, name := range strings.Fields(names) {
+					switch existing := item[name].(type) {
+					case nil:
+						item[name] = value
+					case []any:
+						item[name] = append(existing, value)
+					default:
+						item[name] = []any{existing, value}
+					}
+				}
+			}
+			// The properties inside a nested item are its own
+			if !isScope {
+				walk(child)
+			}
+		})
+	}
+	walk(scope)
+	return item
+}
+
+// microdataValue is a property's value as the microdata spec defines it
+// for the element it is on
+func microdataValue(s *goquery.Selection) string {
+	attr := ""
+	switch goquery.NodeName(s) {
+	case "meta":
+		attr = "content"
+	case "audio", "embed", "iframe", "img", "source", "track", "video":
+		attr = "src"
+	case "a", "area", "link":
+		attr = "href"
+	case "object":
+		attr = "data"
+	case "data", "meter":
+		attr = "value"
+	case "time":
+		if v, ok := s.Attr("datetime"); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	if attr != "" {
+		v, _ := s.Attr(attr)
+		return strings.TrimSpace(v)
+	}
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
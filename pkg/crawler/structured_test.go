@@ -0,0 +1,220 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// structuredData is what applyStructuredData writes for a page
+func structuredData(t *testing.T, url, page string) []structuredRecord {
+	t.Helper()
+	c := newTestCrawler(t, "https://example.com/", nil)
+	path := filepath.Join(t.TempDir(), "structured_data.jsonl")
+	var err error
+	if c.structured, err = openJSONL(path); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.applyStructuredData(url, doc)
+	c.structured.close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []structuredRecord
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var r structuredRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestStructuredData(t *testing.T) {
+	// record is what a test expects of a structuredRecord: Data is checked
+	// for the properties given, Error for containing what is given
+	type record struct {
+		typ, format string
+		data        map[string]any
+		err         string
+	}
+	tests := []struct {
+		fixture string
+		want    []record
+	}{
+		{
+			fixture: "product.html",
+			want: []record{
+				{typ: "Product", format: "json-ld", data: map[string]any{
+					"name":   "Trail Running Shoe",
+					"sku":    "TRS-42",
+					"offers": map[string]any{"@type": "Offer", "price": "89.00", "priceCurrency": "EUR"},
+				}},
+				{typ: "Product", format: "microdata", data: map[string]any{
+					"@type":       "https://schema.org/Product",
+					"name":        "Trail Running Shoe",
+					"sku":         "TRS-42",
+					"description": "A light shoe with a grippy sole and a roomy toe box, made for long days on rough and muddy trails.",
+				}},
+			},
+		},
+		{
+			fixture: "article.html",
+			want: []record{
+				{typ: "Article", format: "json-ld", data: map[string]any{
+					"@context": "https://schema.org",
+					"headline": "How We Map Trails",
+					"author":   map[string]any{"@type": "Person", "name": "Sam Rivera"},
+				}},
+				{typ: "BreadcrumbList", format: "json-ld", data: map[string]any{"@context": "https://schema.org"}},
+				{format: "json-ld", err: "invalid character '}'"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			page, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			url := "https://example.com/" + tt.fixture
+			got := structuredData(t, url, string(page))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d records, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, r := range got {
+				w := tt.want[i]
+				if r.URL != url || r.Type != w.typ || r.Format != w.format || r.ExtractedAt.IsZero() {
+					t.Errorf("record %d = %s %s %q at %s, want %s %s %q", i, r.URL, r.Format, r.Type, r.ExtractedAt, url, w.format, w.typ)
+				}
+				if w.err != "" {
+					if !strings.Contains(r.Error, w.err) || r.Raw == "" || r.Data != nil {
+						t.Errorf("record %d has error %q and raw %q, want %q and the block", i, r.Error, r.Raw, w.err)
+					}
+					continue
+				}
+				var data map[string]any
+				if err := json.Unmarshal(r.Data, &data); err != nil {
+					t.Fatalf("record %d data: %v", i, err)
+				}
+				for k, v := range w.data {
+					if !reflect.DeepEqual(data[k], v) {
+						t.Errorf("record %d %s = %v, want %v", i, k, data[k], v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFlattenJSONLD(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		types []string
+	}{
+		{"object", `{"@type": "Product"}`, []string{"Product"}},
+		{"array", `[{"@type": "Product"}, {"@type": "Offer"}]`, []string{"Product", "Offer"}},
+		{"graph", `{"@context": "https://schema.org", "@graph": [{"@type": "WebPage"}, {"@type": "Person"}]}`, []string{"WebPage", "Person"}},
+		{"array of graphs", `[{"@graph": [{"@type": "A"}]}, {"@type": "B"}]`, []string{"A", "B"}},
+		{"graph inside a graph", `{"@graph": [{"@graph": [{"@type": "A"}]}, {"@type": "B"}]}`, []string{"A", "B"}},
+		{"scalar", `"text"`, nil},
+		{"scalars in an array", `[1, {"@type": "A"}]`, []string{"A"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			if err := json.Unmarshal([]byte(tt.block), &v); err != nil {
+				t.Fatal(err)
+			}
+			var types []string
+			for _, item := range flattenJSONLD(v) {
+				types = append(types, schemaType(item["@type"]))
+			}
+			if !reflect.DeepEqual(types, tt.types) {
+				t.Errorf("types = %q, want %q", types, tt.types)
+			}
+		})
+	}
+}
+
+func TestSchemaType(t *testing.T) {
+	tests := []struct {
+		t    any
+		want string
+	}{
+		{"Product", "Product"},
+		{"https://schema.org/Product", "Product"},
+		{"http://schema.org/Product", "Product"},
+		{"https://schema.org/Product https://schema.org/Car", "Product,Car"},
+		{[]any{"Book", "https://schema.org/Product", 3}, "Book,Product"},
+		{nil, ""},
+		{42.0, ""},
+	}
+	for _, tt := range tests {
+		if got := schemaType(tt.t); got != tt.want {
+			t.Errorf("schemaType(%v) = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestMicrodataItem(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want map[string]any
+	}{
+		{
+			name: "values by element",
+			html: `<div itemscope itemtype="https://schema.org/Event" itemid="urn:event:1">
+				<span itemprop="name"> Trail   Day </span>
+				<meta itemprop="duration" content="PT4H">
+				<time itemprop="startDate" datetime="2026-05-01T09:00">May 1st</time>
+				<a itemprop="url" href="https://example.com/day">site</a>
+				<img itemprop="image" src="/day.jpg">
+				<data itemprop="capacity" value="120">a hundred and twenty</data>
+			</div>`,
+			want: map[string]any{
+				"@type": "https://schema.org/Event", "@id": "urn:event:1",
+				"name": "Trail Day", "duration": "PT4H", "startDate": "2026-05-01T09:00",
+				"url": "https://example.com/day", "image": "/day.jpg", "capacity": "120",
+			},
+		},
+		{
+			name: "repeated and multiple names",
+			html: `<div itemscope><span itemprop="tag">a</span><span itemprop="tag">b</span><span itemprop="tag">c</span><b itemprop="name alternateName">x</b></div>`,
+			want: map[string]any{"tag": []any{"a", "b", "c"}, "name": "x", "alternateName": "x"},
+		},
+		{
+			name: "nested item",
+			html: `<div itemscope itemtype="Book"><div><span itemprop="name">Maps</span></div>
+				<div itemprop="author" itemscope itemtype="Person"><span itemprop="name">Sam</span></div></div>`,
+			want: map[string]any{"@type": "Book", "name": "Maps", "author": map[string]any{"@type": "Person", "name": "Sam"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := microdataItem(doc.Find("[itemscope]").First()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("item = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
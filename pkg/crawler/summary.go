@@ -0,0 +1,504 @@
+package crawler
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportTopN is how many pages each top list of the report holds
+const reportTopN = 10
+
+// Report summarizes a project: every page saved so far, whatever run saved
+// it, and the run that last crawled it
+type Report struct {
+	GeneratedAt time.Time  `json:"generated_at"`
+	Run         *ReportRun `json:"run,omitempty"`
+
+	Pages        int     `json:"pages"`
+	Bytes        int64   `json:"bytes"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+	P95LatencyMS int64   `json:"p95_latency_ms"`
+	// StatusCodes counts the last status of every URL fetched, failures
+	// included
+	StatusCodes map[int]int `json:"status_codes"`
+	// Skipped URLs were finished without saving anything: disallowed by
+	// robots.txt, over a crawl budget, redirected away or of an unwanted
+	// content type
+	Failed     int `json:"failed"`
+	Skipped    int `json:"skipped"`
+	Duplicates int `json:"duplicates"`
+	// FailureClasses counts the failed URLs by what went wrong, as
+	// failed_urls.jsonl last recorded them
+	FailureClasses map[string]int `json:"failure_classes,omitempty"`
+	// Languages counts the HTML pages fetched by their language, without
+	// its region, the pages skipped for it included; "unknown" are those
+	// it couldn't be told for
+	Languages map[string]int `json:"languages,omitempty"`
+	// Budgets are the crawl budgets and how much of each is used
+	Budgets []BudgetUsage `json:"budgets,omitempty"`
+
+	// DuplicateTitles are the titles several HTML pages share, and
+	// MissingDescription the HTML pages without a meta description
+	DuplicateTitles    []TitleGroup `json:"duplicate_titles"`
+	MissingDescription []string     `json:"missing_description"`
+	// ThinContent are the pages whose extracted text has fewer than
+	// ThinContentWords words
+	ThinContent []string `json:"thin_content,omitempty"`
+	// Soft404 are the pages taken for the site's answer for missing pages
+	Soft404 []string `json:"soft_404,omitempty"`
+	// Screenshots are the rendered pages that have one, in URL order
+	Screenshots []ReportScreenshot `json:"screenshots,omitempty"`
+
+	Slowest    []ReportPage `json:"slowest"`
+	Largest    []ReportPage `json:"largest"`
+	MostLinked []LinkedPage `json:"most_linked"`
+}
+
+// ReportRun is the run a report was written after
+type ReportRun struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at,omitzero"`
+	Outcome   string    `json:"outcome,omitempty"`
+	DurationS int64     `json:"duration_seconds"`
+	Pages     int64     `json:"pages"`
+}
+
+// ReportPage is a saved page in one of the report's top lists
+type ReportPage struct {
+	URL        string `json:"url"`
+	Status     int    `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// TitleGroup is a title and the pages that have it
+type TitleGroup struct {
+	Title string   `json:"title"`
+	URLs  []string `json:"urls"`
+}
+
+// ReportScreenshot is the screenshot of a rendered page. Src is where
+// report.html finds it, when the pages are stored as files.
+type ReportScreenshot struct {
+	URL    string `json:"url"`
+	File   string `json:"file"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Src    string `json:"-"`
+}
+
+// LinkedPage is a URL and how many crawled pages link to it
+type LinkedPage struct {
+	URL     string `json:"url"`
+	Inlinks int    `json:"inlinks"`
+}
+
+func (r *ReportRun) duration() time.Duration {
+	return time.Duration(r.DurationS) * time.Second
+}
+
+// Report returns the report written by the last Run or WriteReports, or
+// nil if there hasn't been one
+func (c *Crawler) Report() *Report {
+	return c.report
+}
+
+// buildReport reads the report from pages.jsonl, edges.jsonl, the frontier
+// and the manifest, so it is the same whether it is built at the end of a
+// run or later from the files
+func (c *Crawler) buildReport() (*Report, error) {
+	r := &Report{GeneratedAt: time.Now().UTC(), StatusCodes: make(map[int]int)}
+	last, err := c.lastPageRecords()
+	if err != nil {
+		return nil, err
+	}
+	status := make(map[string]int, len(last))
+	var saved []ReportPage
+	titles := make(map[string][]string)
+	for _, p := range last {
+		status[p.URL] = p.Status
+		if isHTMLType(p.ContentType) {
+			if r.Languages == nil {
+				r.Languages = make(map[string]int)
+			}
+			r.Languages[cmp.Or(primaryLanguage(p.Language), "unknown")]++
+		}
+		if p.File == "" {
+			continue
+		}
+		if p.Soft404 {
+			r.Soft404 = append(r.Soft404, p.URL)
+		} else if isHTMLType(p.ContentType) {
+			if p.Title != "" {
+				titles[p.Title] = append(titles[p.Title], p.URL)
+			}
+			if p.Description == "" {
+				r.MissingDescription = append(r.MissingDescription, p.URL)
+			}
+			if p.TextFile != "" && p.WordCount < c.cfg.ThinContentWords {
+				r.ThinContent = append(r.ThinContent, p.URL)
+			}
+		}
+		if p.Screenshot != "" {
+			r.Screenshots = append(r.Screenshots, ReportScreenshot{URL: p.URL, File: p.Screenshot,
+				Width: p.ScreenshotWidth, Height: p.ScreenshotHeight, Src: c.reportSrc(p.Screenshot)})
+		}
+		saved = append(saved, ReportPage{URL: p.URL, Status: p.Status, DurationMS: p.DurationMS, Bytes: p.ContentLength})
+	}
+	classes := make(map[string]string)
+	if err := readJSONL(c.deadLettersFileName, func(line []byte) {
+		var d deadLetter
+		if json.Unmarshal(line, &d) == nil && d.URL != "" {
+			classes[d.URL] = d.Class
+		}
+	}); err != nil {
+		return nil, err
+	}
+	for _, f := range c.Frontier.Failed() {
+		r.Failed++
+		if class, ok := classes[f.URL]; ok {
+			if r.FailureClasses == nil {
+				r.FailureClasses = make(map[string]int)
+			}
+			r.FailureClasses[class]++
+		}
+		if code, _ := brokenProblem(f.Reason); code != 0 {
+			status[f.URL] = code
+		} else {
+			delete(status, f.URL)
+		}
+	}
+	for _, code := range status {
+		r.StatusCodes[code]++
+	}
+
+	sort.Strings(r.MissingDescription)
+	sort.Strings(r.ThinContent)
+	sort.Strings(r.Soft404)
+	sort.Slice(r.Screenshots, func(i, j int) bool { return r.Screenshots[i].URL < r.Screenshots[j].URL })
+	for title, urls := range titles {
+		if len(urls) > 1 {
+			sort.Strings(urls)
+			r.DuplicateTitles = append(r.DuplicateTitles, TitleGroup{Title: title, URLs: urls})
+		}
+	}
+	sort.Slice(r.DuplicateTitles, func(i, j int) bool {
+		a, b := r.DuplicateTitles[i], r.DuplicateTitles[j]
+		if len(a.URLs) != len(b.URLs) {
+			return len(a.URLs) > len(b.URLs)
+		}
+		return a.Title < b.Title
+	})
+
+	r.Pages = len(saved)
+	if len(saved) > 0 {
+		var total int64
+		for _, p := range saved {
+			total += p.DurationMS
+			r.Bytes += p.Bytes
+		}
+		r.AvgLatencyMS = float64(total) / float64(len(saved))
+		sort.Slice(saved, func(i, j int) bool {
+			if saved[i].DurationMS != saved[j].DurationMS {
+				return saved[i].DurationMS > saved[j].DurationMS
+			}
+			return saved[i].URL < saved[j].URL
+		})
+		// Nearest rank: the fastest page that 5% are at least as slow as
+		r.P95LatencyMS = saved[(len(saved)-1)/20].DurationMS
+		r.Slowest = append(r.Slowest, saved[:min(reportTopN, len(saved))]...)
+		sort.Slice(saved, func(i, j int) bool {
+			if saved[i].Bytes != saved[j].Bytes {
+				return saved[i].Bytes > saved[j].Bytes
+			}
+			return saved[i].URL < saved[j].URL
+		})
+		r.Largest = append(r.Largest, saved[:min(reportTopN, len(saved))]...)
+	}
+
+	c.pageManifest.mu.Lock()
+	for _, e := range c.pageManifest.entries {
+		if e.DuplicateOf != "" {
+			r.Duplicates++
+		}
+	}
+	c.pageManifest.mu.Unlock()
+	for _, u := range c.Frontier.Scraped() {
+		if _, ok := c.pageManifest.lookup(u); !ok {
+			r.Skipped++
+		}
+	}
+
+	if r.Budgets, err = c.budgetUsage(); err != nil {
+		return nil, err
+	}
+
+	// Recrawls write a page's edges again, so each pair counts once
+	seen := make(map[linkEdge]bool)
+	inlinks := make(map[string]int)
+	if err := readJSONL(c.edgesFileName, func(line []byte) {
+		var e linkEdge
+		if json.Unmarshal(line, &e) == nil && e.From != e.To && !seen[e] {
+			seen[e] = true
+			inlinks[e.To]++
+		}
+	}); err != nil {
+		return nil, err
+	}
+	for u, n := range inlinks {
+		r.MostLinked = append(r.MostLinked, LinkedPage{URL: u, Inlinks: n})
+	}
+	sort.Slice(r.MostLinked, func(i, j int) bool {
+		a, b := r.MostLinked[i], r.MostLinked[j]
+		if a.Inlinks != b.Inlinks {
+			return a.Inlinks > b.Inlinks
+		}
+		return a.URL < b.URL
+	})
+	r.MostLinked = r.MostLinked[:min(reportTopN, len(r.MostLinked))]
+
+	// While Run runs its record isn't finished yet; otherwise the ledger
+	// has the last run
+	run, ok := c.run, c.ledger != nil
+	if ok {
+		run.EndedAt = time.Now().UTC()
+		run.Pages = c.pagesStarted.Load()
+	} else {
+		if run, ok, err = lastRun(c.runsFileName); err != nil {
+			return nil, err
+		}
+	}
+	if ok {
+		r.Run = &ReportRun{ID: run.ID, StartedAt: run.StartedAt, EndedAt: run.EndedAt, Outcome: run.Outcome, Pages: run.Pages}
+		if !run.EndedAt.IsZero() {
+			r.Run.DurationS = int64(run.EndedAt.Sub(run.StartedAt).Round(time.Second) / time.Second)
+		}
+	}
+	return r, nil
+}
+
+// reportSrc is the link from report.html to the saved file name, empty
+// unless the pages are stored as files
+func (c *Crawler) reportSrc(name string) string {
+	fsys, ok := c.Storage.(fsStorage)
+	if !ok {
+		return ""
+	}
+	rel, err := filepath.Rel(c.cfg.ProjectFolder, fsys.path(name))
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
+}
+
+// lastPageRecords reads pages.jsonl, keeping the last record of each URL:
+// a URL fetched by several runs counts once, as it was last fetched
+func (c *Crawler) lastPageRecords() (map[string]pageRecord, error) {
+	last := make(map[string]pageRecord)
+	if err := readJSONL(c.pagesFileName, func(line []byte) {
+		var p pageRecord
+		if json.Unmarshal(line, &p) == nil && p.URL != "" {
+			// An unchanged page wasn't parsed again, so its metadata is
+			// still the one from when it was
+			if prev, ok := last[p.URL]; ok && p.Status == http.StatusNotModified {
+				p.Title, p.pageMetadata = prev.Title, prev.pageMetadata
+				p.TextFile, p.WordCount = prev.TextFile, prev.WordCount
+				p.Soft404 = prev.Soft404
+				p.Language, p.LanguageSource = prev.Language, prev.LanguageSource
+				p.Screenshot, p.ScreenshotWidth, p.ScreenshotHeight = prev.Screenshot, prev.ScreenshotWidth, prev.ScreenshotHeight
+				p.MixedContent = prev.MixedContent
+				p.LinkSchemes, p.MalformedLinks, p.Contacts = prev.LinkSchemes, prev.MalformedLinks, prev.Contacts
+			}
+			last[p.URL] = p
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return last, nil
+}
+
+// readJSONL calls fn with every line of a JSON lines file; a missing file
+// has no lines
+func readJSONL(path string, fn func(line []byte)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		fn(scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+// writeReport builds the report and writes it as report.json, and as
+// report.html too with ReportHTML
+func (c *Crawler) writeReport() (*Report, error) {
+	r, err := c.buildReport()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(c.reportFileName, append(data, '\n')); err != nil {
+		return nil, err
+	}
+	if c.cfg.ReportHTML {
+		var sb strings.Builder
+		if err := reportPage.Execute(&sb, r); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(c.reportHTMLFileName, []byte(sb.String())); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// statusCodes lists the status counts in code order, as "200=12 404=1"
+func (r *Report) statusCodes() string {
+	codes := make([]int, 0, len(r.StatusCodes))
+	for code := range r.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%d=%d", code, r.StatusCodes[code])
+	}
+	return strings.Join(parts, " ")
+}
+
+// WriteText writes the report for people to read
+func (r *Report) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	if r.Run != nil {
+		fmt.Fprintf(&sb, "SUMMARY of run %s", r.Run.ID)
+		if r.Run.Outcome != "" {
+			fmt.Fprintf(&sb, " (%s in %s, %d fetches)", r.Run.Outcome, r.Run.duration(), r.Run.Pages)
+		}
+		sb.WriteString("\n")
+	} else {
+		sb.WriteString("SUMMARY\n")
+	}
+	fmt.Fprintf(&sb, "\tPAGES=%d (%s)\n", r.Pages, formatSize(r.Bytes))
+	fmt.Fprintf(&sb, "\tLATENCY=avg %.0fms, p95 %dms\n", r.AvgLatencyMS, r.P95LatencyMS)
+	fmt.Fprintf(&sb, "\tSTATUS=%s\n", r.statusCodes())
+	fmt.Fprintf(&sb, "\tFAILED=%d SKIPPED=%d DUPLICATES=%d\n", r.Failed, r.Skipped, r.Duplicates)
+	if len(r.FailureClasses) > 0 {
+		fmt.Fprintf(&sb, "\tFAILURES=%s\n", formatClassCounts(r.FailureClasses))
+	}
+	if len(r.Languages) > 0 {
+		fmt.Fprintf(&sb, "\tLANGUAGES=%s\n", formatClassCounts(r.Languages))
+	}
+	for _, b := range r.Budgets {
+		fmt.Fprintf(&sb, "\tBUDGET %s=%d/%d used, %d skipped\n", b.Pattern, b.Used, b.MaxPages, b.Skipped)
+	}
+	fmt.Fprintf(&sb, "\tDUPLICATE_TITLES=%d MISSING_DESCRIPTION=%d THIN_CONTENT=%d SOFT_404=%d\n",
+		len(r.DuplicateTitles), len(r.MissingDescription), len(r.ThinContent), len(r.Soft404))
+	if len(r.DuplicateTitles) > 0 {
+		sb.WriteString("Duplicate titles:\n")
+		for _, g := range r.DuplicateTitles[:min(reportTopN, len(r.DuplicateTitles))] {
+			fmt.Fprintf(&sb, "\t%6d  %q\n", len(g.URLs), g.Title)
+		}
+	}
+	if len(r.Soft404) > 0 {
+		sb.WriteString("Soft 404s:\n")
+		for _, u := range r.Soft404[:min(reportTopN, len(r.Soft404))] {
+			fmt.Fprintf(&sb, "\t%s\n", u)
+		}
+	}
+	if len(r.Slowest) > 0 {
+		sb.WriteString("Slowest pages:\n")
+		for _, p := range r.Slowest {
+			fmt.Fprintf(&sb, "\t%6dms  %s\n", p.DurationMS, p.URL)
+		}
+		sb.WriteString("Largest pages:\n")
+		for _, p := range r.Largest {
+			fmt.Fprintf(&sb, "\t%8s  %s\n", formatSize(p.Bytes), p.URL)
+		}
+	}
+	if len(r.MostLinked) > 0 {
+		sb.WriteString("Most linked-to pages:\n")
+		for _, p := range r.MostLinked {
+			fmt.Fprintf(&sb, "\t%6d  %s\n", p.Inlinks, p.URL)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+var reportPage = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size": formatSize,
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Crawl report</title>
+<style>body{font-family:sans-serif}td,th{padding:2px 8px;text-align:left}td.n{text-align:right}</style></head>
+<body><h1>Crawl report</h1>
+{{with .Run}}<p>Run {{.ID}}{{if .Outcome}}: {{.Outcome}} in {{.DurationS}}s, {{.Pages}} fetches{{end}}</p>{{end}}
+<table>
+<tr><th>Pages</th><td class="n">{{.Pages}}</td></tr>
+<tr><th>Bytes</th><td class="n">{{size .Bytes}}</td></tr>
+<tr><th>Average latency</th><td class="n">{{printf "%.0f" .AvgLatencyMS}}ms</td></tr>
+<tr><th>p95 latency</th><td class="n">{{.P95LatencyMS}}ms</td></tr>
+<tr><th>Failed</th><td class="n">{{.Failed}}</td></tr>
+<tr><th>Skipped</th><td class="n">{{.Skipped}}</td></tr>
+<tr><th>Duplicates</th><td class="n">{{.Duplicates}}</td></tr>
+</table>
+{{if .FailureClasses}}<h2>Failures</h2><table>
+{{range $class, $n := .FailureClasses}}<tr><th>{{$class}}</th><td class="n">{{$n}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Languages}}<h2>Languages</h2><table>
+{{range $lang, $n := .Languages}}<tr><th>{{$lang}}</th><td class="n">{{$n}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Budgets}}<h2>Crawl budgets</h2><table>
+<tr><th>Pattern</th><th>Used</th><th>Budget</th><th>Skipped</th></tr>
+{{range .Budgets}}<tr><td>{{.Pattern}}</td><td class="n">{{.Used}}</td><td class="n">{{.MaxPages}}</td><td class="n">{{.Skipped}}</td></tr>
+{{end}}</table>{{end}}
+{{if .DuplicateTitles}}<h2>Duplicate titles</h2><table>
+{{range .DuplicateTitles}}<tr><th>{{.Title}}</th><td>{{range .URLs}}<a href="{{.}}">{{.}}</a><br>{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{if .ThinContent}}<h2>Thin content</h2><ul>
+{{range .ThinContent}}<li><a href="{{.}}">{{.}}</a></li>
+{{end}}</ul>{{end}}
+{{if .Soft404}}<h2>Soft 404s</h2><ul>
+{{range .Soft404}}<li><a href="{{.}}">{{.}}</a></li>
+{{end}}</ul>{{end}}
+{{if .Screenshots}}<h2>Screenshots</h2><div>
+{{range .Screenshots}}{{if .Src}}<a href="{{.Src}}" title="{{.URL}}"><img src="{{.Src}}" alt="{{.URL}}" width="160" loading="lazy"></a>
+{{end}}{{end}}</div>{{end}}
+{{if .MissingDescription}}<h2>Pages without a description</h2><ul>
+{{range .MissingDescription}}<li><a href="{{.}}">{{.}}</a></li>
+{{end}}</ul>{{end}}
+<h2>Status codes</h2><table>
+{{range $code, $n := .StatusCodes}}<tr><th>{{$code}}</th><td class="n">{{$n}}</td></tr>
+{{end}}</table>
+{{if .Slowest}}<h2>Slowest pages</h2><table>
+{{range .Slowest}}<tr><td class="n">{{.DurationMS}}ms</td><td><a href="{{.URL}}">{{.URL}}</a></td></tr>
+{{end}}</table>
+<h2>Largest pages</h2><table>
+{{range .Largest}}<tr><td class="n">{{size .Bytes}}</td><td><a href="{{.URL}}">{{.URL}}</a></td></tr>
+{{end}}</table>{{end}}
+{{if .MostLinked}}<h2>Most linked-to pages</h2><table>
+{{range .MostLinked}}<tr><td class="n">{{.Inlinks}}</td><td><a href="{{.URL}}">{{.URL}}</a></td></tr>
+{{end}}</table>{{end}}
+</body></html>
+`))
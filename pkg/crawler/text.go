@@ -0,0 +1,147 @@
+package crawler
+
+import (
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Text extraction modes
+const (
+	textFull = "full"
+	textMain = "main"
+)
+
+// boilerplate is what never counts as a page's text; in main mode the
+// page's navigation, header, footer and sidebars are dropped too
+const (
+	nonText     = "script, style, noscript, template, svg, iframe, object, head"
+	boilerplate = "nav, header, footer, aside, form, [role=navigation], [role=banner], [role=contentinfo], [aria-hidden=true]"
+)
+
+// textFileName is where the text of the page saved as name goes
+func textFileName(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + ".txt"
+}
+
+// pageText returns the readable text of a parsed page, the whole body or
+// its main content depending on mode, leaving doc untouched
+func pageText(doc *goquery.Document, mode string) string {
+	body := doc.Find("body").First()
+	if body.Length() == 0 {
+		body = doc.Selection
+	}
+	body = body.Clone()
+	body.Find(nonText).Remove()
+	if mode == textMain {
+		body = mainContent(body)
+	}
+	var sb strings.Builder
+	for _, n := range body.Nodes {
+		renderText(&sb, n, false)
+	}
+	return tidyText(sb.String())
+}
+
+// mainContent picks the main content of a body: the largest <main>,
+// <article> or role=main element if there is one, otherwise the element
+// holding the most paragraph text once the boilerplate is gone
+func mainContent(body *goquery.Selection) *goquery.Selection {
+	body.Find(boilerplate).Remove()
+	var best *goquery.Selection
+	bestLen := 0
+	body.Find("main, article, [role=main]").Each(func(i int, s *goquery.Selection) {
+		if n := len(strings.TrimSpace(s.Text())); n > bestLen {
+			best, bestLen = s, n
+		}
+	})
+	if best != nil {
+		return best
+	}
+	// Score every element by the text of its own paragraphs, so a wrapper
+	// doesn't win just by containing the real content
+	scores := make(map[*html.Node]int)
+	body.Find("p, pre, blockquote").Each(func(i int, s *goquery.Selection) {
+		if parent := s.Parent(); parent.Length() > 0 {
+			scores[parent.Nodes[0]] += len(strings.TrimSpace(s.Text()))
+		}
+	})
+	var bestNode *html.Node
+	for n, score := range scores {
+		if score > bestLen {
+			bestNode, bestLen = n, score
+		}
+	}
+	if bestNode == nil || bestNode == body.Nodes[0] {
+		return body
+	}
+	return body.FindNodes(bestNode)
+}
+
+// blockElements start a new line in the text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "tr": true, "ul": true,
+}
+
+// renderText writes the text under n, one line per block element. Line
+// breaks in the markup only count inside <pre>.
+func renderText(sb *strings.Builder, n *html.Node, pre bool) {
+	switch n.Type {
+	case html.TextNode:
+		if pre {
+			sb.WriteString(n.Data)
+		} else {
+			sb.WriteString(strings.Map(func(r rune) rune {
+				if unicode.IsSpace(r) {
+					return ' '
+				}
+				return r
+			}, n.Data))
+		}
+		return
+	case html.ElementNode:
+		pre = pre || n.Data == "pre"
+		if blockElements[n.Data] {
+			sb.WriteString("\n")
+			defer sb.WriteString("\n")
+		} else if n.Data == "td" || n.Data == "th" {
+			defer sb.WriteString("\t")
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		renderText(sb, child, pre)
+	}
+}
+
+// tidyText collapses the whitespace within lines and drops empty lines
+func tidyText(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// saveText writes the text of the page saved as name next to it, returning
+// the text file's name and the word count
+func (c *Crawler) saveText(name string, meta PageMeta, doc *goquery.Document) (string, int, error) {
+	text := pageText(doc, c.cfg.ExtractText)
+	file := textFileName(name)
+	meta.ContentType = "text/plain"
+	if _, err := c.Storage.SavePage(file, meta, strings.NewReader(text)); err != nil {
+		return "", 0, err
+	}
+	return file, len(strings.Fields(text)), nil
+}
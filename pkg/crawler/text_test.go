@@ -0,0 +1,167 @@
+package crawler
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestPageText compares the text of the fixture pages with the golden
+// files next to them; go test -run TestPageText -update rewrites those
+func TestPageText(t *testing.T) {
+	for _, fixture := range []string{"product", "article", "thin"} {
+		for _, mode := range []string{textFull, textMain} {
+			t.Run(fixture+"/"+mode, func(t *testing.T) {
+				page, err := os.ReadFile(filepath.Join("testdata", fixture+".html"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(page)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				before, _ := doc.Html()
+				got := pageText(doc, mode)
+				if after, _ := doc.Html(); after != before {
+					t.Error("pageText changed the document")
+				}
+
+				golden := filepath.Join("testdata", fixture+"."+mode+".txt")
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != string(want) {
+					t.Errorf("text differs from %s:\n%s", golden, got)
+				}
+			})
+		}
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	tests := []struct {
+		name, body, mode, want string
+	}{
+		{"inline elements", `<p>one <b>two</b> <i>three</i></p>`, textFull, "one two three\n"},
+		{"blocks", `<h1>Title</h1><p>First</p><div>Second<br>Third</div>`, textFull, "Title\nFirst\nSecond\nThird\n"},
+		{"markup line breaks", "<p>one\n   two\n\tthree</p>", textFull, "one two three\n"},
+		{"pre", "<pre>a  b\n  c</pre>", textFull, "a b\nc\n"},
+		{"table cells", `<table><tr><th>name</th><th>size</th></tr><tr><td>a</td><td>1</td></tr></table>`, textFull, "name size\na 1\n"},
+		{"no text", `<script>var x = 1</script><style>p {}</style><noscript>enable js</noscript>`, textFull, ""},
+		{"boilerplate kept in full", `<nav>Menu</nav><p>Body</p><footer>Foot</footer>`, textFull, "Menu\nBody\nFoot\n"},
+		{"boilerplate dropped in main", `<nav>Menu</nav><p>Body</p><footer>Foot</footer>`, textMain, "Body\n"},
+		{"largest article", `<article>short</article><article>the longer one</article>`, textMain, "the longer one\n"},
+		{"most paragraph text", `<div><p>a</p></div><div id="post"><p>a long paragraph</p><p>and another</p></div>`, textMain, "a long paragraph\nand another\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body>" + tt.body + "</body></html>"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pageText(doc, tt.mode); got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextFileName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"32be333f038ac811.html", "32be333f038ac811.txt"},
+		{"docs/guide.htm", "docs/guide.txt"},
+		{"page", "page.txt"},
+		{"v1.2/page", "v1.2/page.txt"},
+	}
+	for _, tt := range tests {
+		if got := textFileName(tt.name); got != tt.want {
+			t.Errorf("textFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestTextInCrawl saves the text of every page next to it, and reports
+// the pages with fewer than ThinContentWords words
+func TestTextInCrawl(t *testing.T) {
+	fixtures := map[string]string{"/": "article.html", "/thin": "thin.html", "/product": "product.html"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture, ok := fixtures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		page, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			page = []byte(strings.Replace(string(page), "</article>", `<a href="/thin">thin</a> <a href="/product">product</a></article>`, 1))
+		}
+		w.Write(page)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		mode      string
+		thinWords int
+		thin      []string
+	}{
+		{name: "main", mode: textMain, thinWords: 20, thin: []string{srv.URL + "/thin"}},
+		{name: "full", mode: textFull, thinWords: 20, thin: []string{srv.URL + "/thin"}},
+		{name: "all thin", mode: textFull, thinWords: 1000, thin: []string{srv.URL + "/", srv.URL + "/product", srv.URL + "/thin"}},
+		{name: "no minimum", mode: textMain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+				cfg.Workers = 1
+				cfg.RespectRobots = false
+				cfg.ExtractText = tt.mode
+				cfg.ThinContentWords = tt.thinWords
+			})
+			files := make(map[string]string)
+			c.OnPage = func(p PageResult) { files[p.URL[len(srv.URL):]] = p.File }
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+			for path, fixture := range fixtures {
+				want, err := os.ReadFile(filepath.Join("testdata", strings.TrimSuffix(fixture, ".html")+"."+tt.mode+".txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := readSaved(t, c, textFileName(files[path]))
+				if path == "/" {
+					// the links the server added are part of the text
+					got = strings.Replace(got, "\nthin product", "", 1)
+				}
+				if got != string(want) {
+					t.Errorf("%s text = %q, want %q", path, got, want)
+				}
+			}
+			if r := c.Report(); r == nil || !reflect.DeepEqual(r.ThinContent, tt.thin) {
+				t.Errorf("thin content = %v, want %v", r.ThinContent, tt.thin)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultThrottleDelay = 5 * time.Second
+	maxThrottleDelay     = 5 * time.Minute
+)
+
+// hostThrottle is a cooldown per host shared by all workers, so a 429 seen
+// by one worker holds back every request to that host, not just its own.
+// It also spaces requests to a host by its robots.txt crawl delay.
+type hostThrottle struct {
+	mu      sync.Mutex
+	until   map[string]time.Time
+	strikes map[string]int
+	next    map[string]time.Time
+	// requeued counts how often each URL was throttled and re-queued
+	requeued map[string]int
+}
+
+func newHostThrottle() *hostThrottle {
+	return &hostThrottle{
+		until:    make(map[string]time.Time),
+		strikes:  make(map[string]int),
+		next:     make(map[string]time.Time),
+		requeued: make(map[string]int),
+	}
+}
+
+// space blocks until host's next slot, gap after the one before it, and
+// takes it. Workers reserve slots in turn, so gap holds across all of them.
+func (t *hostThrottle) space(ctx context.Context, host string, gap time.Duration) error {
+	if gap <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	slot := time.Now()
+	if next := t.next[host]; next.After(slot) {
+		slot = next
+	}
+	t.next[host] = slot.Add(gap)
+	t.mu.Unlock()
+	return sleepCtx(ctx, time.Until(slot))
+}
+
+// wait blocks until host is out of its cooldown or ctx is done
+func (t *hostThrottle) wait(ctx context.Context, host string) error {
+	for {
+		t.mu.Lock()
+		until := t.until[host]
+		t.mu.Unlock()
+		d := time.Until(until)
+		if d <= 0 {
+			return nil
+		}
+		if err := sleepCtx(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// pause puts host into cooldown for d, or for a default that doubles with
+// each consecutive throttled response when d is zero, and never for longer
+// than maxThrottleDelay. An existing longer cooldown is never shortened.
+func (t *hostThrottle) pause(host string, d time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.strikes[host]++
+	if d <= 0 {
+		d = defaultThrottleDelay << (t.strikes[host] - 1)
+		if d <= 0 {
+			d = maxThrottleDelay
+		}
+	}
+	d = min(d, maxThrottleDelay)
+	if until := time.Now().Add(d); until.After(t.until[host]) {
+		t.until[host] = until
+	}
+	return d
+}
+
+// reset forgets the consecutive throttles once host answers normally again
+func (t *hostThrottle) reset(host string) {
+	t.mu.Lock()
+	delete(t.strikes, host)
+	t.mu.Unlock()
+}
+
+// requeue counts one more throttled response to url and returns how many
+// it has had
+func (t *hostThrottle) requeue(url string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requeued[url]++
+	return t.requeued[url]
+}
+
+// forget drops the count of a URL that was fetched or given up on
+func (t *hostThrottle) forget(url string) {
+	t.mu.Lock()
+	delete(t.requeued, url)
+	t.mu.Unlock()
+}
+
+// throttledError means the host asked us to slow down. The URL isn't failed;
+// it stays pending and is dispatched again on the next pass, up to
+// MaxThrottles times.
+type throttledError struct {
+	code  int
+	delay time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("throttled with status %d, host paused for %s", e.code, e.delay)
+}
+
+func isThrottleStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter reads a Retry-After header in either its delta-seconds or
+// HTTP-date form, returning zero when it is absent or unparseable. A delay
+// longer than maxThrottleDelay is cut to it, so a host can't park the crawl
+// for days.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	// Too many seconds for an int64 is still a delay, just a long one
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil || errors.Is(err, strconv.ErrRange) {
+		switch {
+		case seconds < 0:
+			return 0
+		case seconds > int64(maxThrottleDelay/time.Second):
+			return maxThrottleDelay
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		if d := when.Sub(now); d > 0 {
+			return min(d, maxThrottleDelay)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{" 5 ", 5 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"300", maxThrottleDelay},
+		{"301", maxThrottleDelay},
+		{"86400", maxThrottleDelay},
+		{"9223372036854775807", maxThrottleDelay},
+		{"99999999999999999999999", maxThrottleDelay},
+		{"-99999999999999999999999", 0},
+		{now.Add(48 * time.Hour).Format(http.TimeFormat), maxThrottleDelay},
+		{"Fri, 31 Dec 9999 23:59:59 GMT", maxThrottleDelay},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestHostThrottlePause(t *testing.T) {
+	th := newHostThrottle()
+	for i, want := range []time.Duration{defaultThrottleDelay, 2 * defaultThrottleDelay, 4 * defaultThrottleDelay} {
+		if got := th.pause("example.com", 0); got != want {
+			t.Errorf("pause %d = %s, want %s", i+1, got, want)
+		}
+	}
+	if got := th.pause("example.com", 3*time.Second); got != 3*time.Second {
+		t.Errorf("pause with Retry-After = %s, want 3s", got)
+	}
+	if got := th.pause("example.com", 24*time.Hour); got != maxThrottleDelay {
+		t.Errorf("pause with a day's Retry-After = %s, want %s", got, maxThrottleDelay)
+	}
+	for i := 0; i < 20; i++ {
+		th.pause("slow.example", 0)
+	}
+	if got := th.pause("slow.example", 0); got != maxThrottleDelay {
+		t.Errorf("pause after many strikes = %s, want %s", got, maxThrottleDelay)
+	}
+	th.reset("slow.example")
+	if got := th.pause("slow.example", 0); got != defaultThrottleDelay {
+		t.Errorf("pause after reset = %s, want %s", got, defaultThrottleDelay)
+	}
+}
+
+func TestHostThrottleRequeue(t *testing.T) {
+	th := newHostThrottle()
+	for want := 1; want <= 3; want++ {
+		if got := th.requeue("https://example.com/a"); got != want {
+			t.Errorf("requeue = %d, want %d", got, want)
+		}
+	}
+	if got := th.requeue("https://example.com/b"); got != 1 {
+		t.Errorf("requeue of another URL = %d, want 1", got)
+	}
+	th.forget("https://example.com/a")
+	if got := th.requeue("https://example.com/a"); got != 1 {
+		t.Errorf("requeue after forget = %d, want 1", got)
+	}
+}
+
+// TestAlwaysThrottledFails crawls a host that answers every request with
+// a 429: the URL has to be recorded as failed after MaxThrottles instead
+// of being re-queued for ever
+func TestAlwaysThrottledFails(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.CircuitBreaker = false
+		cfg.MaxThrottles = 2
+		cfg.RetryBaseDelay = time.Millisecond
+	})
+	var mu sync.Mutex
+	var failed []string
+	c.OnError = func(url string, err error) {
+		mu.Lock()
+		failed = append(failed, url)
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("Run kept re-queueing the throttled URL")
+	}
+	if len(failed) != 1 || failed[0] != srv.URL+"/" {
+		t.Errorf("failed URLs = %v, want just %s/", failed, srv.URL)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3: the first and one per re-queue", n)
+	}
+}
@@ -0,0 +1,204 @@
+package crawler
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority made up for a test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t testing.TB, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue signs a certificate for the server names or, with none, a client
+// one, returning it and its key as PEM
+func (ca *testCA) issue(t testing.TB, names ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, name := range names {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		if ip := net.ParseIP(name); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// newTLSTestServer serves over TLS with a certificate ca issued for
+// names, asking for a client certificate from clientCA if it is set
+func newTLSTestServer(t *testing.T, ca *testCA, clientCA *testCA, names ...string) *httptest.Server {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, names...)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		srv.TLS.ClientCAs = pool
+		srv.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	serverCA, clientCA, otherCA := newTestCA(t, "server CA"), newTestCA(t, "client CA"), newTestCA(t, "other CA")
+	caFile := write("ca.pem", serverCA.pem)
+	otherCAFile := write("other-ca.pem", otherCA.pem)
+	clientCert, clientKey := clientCA.issue(t)
+	certFile, keyFile := write("client.pem", clientCert), write("client-key.pem", clientKey)
+	strangerCert, strangerKey := otherCA.issue(t)
+	strangerCertFile, strangerKeyFile := write("stranger.pem", strangerCert), write("stranger-key.pem", strangerKey)
+
+	plain := newTLSTestServer(t, serverCA, nil, "127.0.0.1")
+	mutual := newTLSTestServer(t, serverCA, clientCA, "127.0.0.1")
+	tests := []struct {
+		name string
+		srv  *httptest.Server
+		edit func(cfg *Config)
+		ok   bool
+	}{
+		{"system roots only", plain, func(cfg *Config) {}, false},
+		{"CA file", plain, func(cfg *Config) { cfg.TLSCAFile = caFile }, true},
+		{"another CA's file", plain, func(cfg *Config) { cfg.TLSCAFile = otherCAFile }, false},
+		{"skip verify", plain, func(cfg *Config) { cfg.TLSInsecureSkipVerify = true }, true},
+		{"mTLS without a client certificate", mutual, func(cfg *Config) { cfg.TLSCAFile = caFile }, false},
+		{"mTLS with a client certificate", mutual, func(cfg *Config) {
+			cfg.TLSCAFile, cfg.TLSCertFile, cfg.TLSKeyFile = caFile, certFile, keyFile
+		}, true},
+		{"mTLS with a certificate the server doesn't trust", mutual, func(cfg *Config) {
+			cfg.TLSCAFile, cfg.TLSCertFile, cfg.TLSKeyFile = caFile, strangerCertFile, strangerKeyFile
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, tt.srv.URL+"/", func(cfg *Config) {
+				cfg.RequestTimeout = 5 * time.Second
+				tt.edit(cfg)
+			})
+			resp, err := c.httpClient.Get(tt.srv.URL + "/")
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("GET = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTLSConfigProblems(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+	tests := []struct {
+		name string
+		edit func(cfg *Config)
+		err  string
+	}{
+		{"missing CA file", func(cfg *Config) { cfg.TLSCAFile = filepath.Join(dir, "missing.pem") }, "TLSCAFile"},
+		{"CA file without certificates", func(cfg *Config) { cfg.TLSCAFile = notPEM }, "no PEM certificates"},
+		{"certificate without a key", func(cfg *Config) { cfg.TLSCertFile = notPEM }, "go together"},
+		{"key without a certificate", func(cfg *Config) { cfg.TLSKeyFile = notPEM }, "go together"},
+		{"unreadable pair", func(cfg *Config) { cfg.TLSCertFile, cfg.TLSKeyFile = notPEM, notPEM }, "client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig("https://example.com/")
+			cfg.ProjectFolder = t.TempDir()
+			tt.edit(&cfg)
+			if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("New = %v, want an error with %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTLSSkipVerifyWarning(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		c := newTestCrawler(t, "https://example.com/", func(cfg *Config) { cfg.TLSInsecureSkipVerify = skip })
+		var logs bytes.Buffer
+		c.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+		c.printConfig()
+		if warned := strings.Contains(logs.String(), "level=WARN msg=\"TLS certificate verification is OFF"); warned != skip {
+			t.Errorf("skip verify %v: warned %v:\n%s", skip, warned, logs.String())
+		}
+	}
+}
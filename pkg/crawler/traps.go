@@ -0,0 +1,180 @@
+package crawler
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The trap rules, as they appear in trap_suspects.txt and the run record
+const (
+	trapPathBudget  = "path-budget"
+	trapQueryParams = "query-params"
+	trapRepeats     = "repeated-segments"
+)
+
+// trapSamples is how many URLs each rule writes to trap_suspects.txt per
+// run; the rest are only counted
+const trapSamples = 200
+
+// trapDetector keeps the state of the trap rules: how many URLs each
+// budgeted path prefix has, and how many URLs each rule turned down
+type trapDetector struct {
+	mu       sync.Mutex
+	found    map[string]int
+	rejected map[string]int
+}
+
+func newTrapDetector() *trapDetector {
+	return &trapDetector{found: make(map[string]int), rejected: make(map[string]int)}
+}
+
+// countFound counts the URLs already in the frontier towards their path
+// budgets, so a resumed crawl doesn't get a fresh budget
+func (c *Crawler) countFound(entries []FrontierEntry) {
+	c.traps.mu.Lock()
+	defer c.traps.mu.Unlock()
+	for _, e := range entries {
+		if u, err := url.Parse(e.URL); err == nil {
+			if key, _ := c.pathBudget(u); key != "" {
+				c.traps.found[key]++
+			}
+		}
+	}
+}
+
+// pathBudget returns the prefix u's path counts against, keyed by host,
+// and its budget: the longest PathBudgets prefix that matches, or else the
+// first folder of the path with PathBudget
+func (c *Crawler) pathBudget(u *url.URL) (key string, budget int) {
+	path := u.EscapedPath()
+	best := ""
+	for prefix, n := range c.cfg.PathBudgets {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(best) {
+			best, budget = prefix, n
+		}
+	}
+	if best == "" && c.cfg.PathBudget > 0 {
+		best, budget = "/", c.cfg.PathBudget
+		if i := strings.Index(strings.TrimPrefix(path, "/"), "/"); i >= 0 {
+			best = path[:i+2]
+		}
+	}
+	if best == "" {
+		return "", 0
+	}
+	return strings.ToLower(u.Host) + best, budget
+}
+
+// trapRule returns the rule that turns raw down as a likely crawler trap,
+// with what it saw, or an empty rule. A URL that passes takes a place in
+// its path budget, returned as reserved, to give back with unreserve if
+// the URL turns out to be known already.
+func (c *Crawler) trapRule(raw string) (rule, detail, reserved string) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", ""
+	}
+	if n := c.cfg.MaxQueryParams; n > 0 && u.RawQuery != "" {
+		if params := len(strings.Split(u.RawQuery, "&")); params > n {
+			return trapQueryParams, "params=" + strconv.Itoa(params), ""
+		}
+	}
+	if n := c.cfg.MaxPathRepeats; n > 0 {
+		if run, times := repeatedSegments(strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")); times > n {
+			return trapRepeats, run + " x" + strconv.Itoa(times), ""
+		}
+	}
+	if key, budget := c.pathBudget(u); key != "" {
+		c.traps.mu.Lock()
+		defer c.traps.mu.Unlock()
+		if c.traps.found[key] >= budget {
+			return trapPathBudget, key + " budget=" + strconv.Itoa(budget), ""
+		}
+		c.traps.found[key]++
+		return "", "", key
+	}
+	return "", "", ""
+}
+
+func (c *Crawler) unreserve(key string) {
+	c.traps.mu.Lock()
+	c.traps.found[key]--
+	c.traps.mu.Unlock()
+}
+
+// repeatedSegments finds the run of path segments repeated back to back
+// the most times, such as a/b in a/b/a/b/a/b, and how many times it is
+func repeatedSegments(segs []string) (run string, times int) {
+	times = 1
+	for size := 1; size <= len(segs)/2; size++ {
+		for start := 0; start+2*size <= len(segs); start++ {
+			n := 1
+			for next := start + size; next+size <= len(segs) && equalSegments(segs[start:start+size], segs[next:next+size]); next += size {
+				n++
+			}
+			if n > times {
+				run, times = strings.Join(segs[start:start+size], "/"), n
+			}
+		}
+	}
+	return run, times
+}
+
+func equalSegments(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rejectTrap counts a URL a trap rule turned down and, for the first
+// trapSamples of each rule, records it in trap_suspects.txt with the rule
+// and the page it was found on
+func (c *Crawler) rejectTrap(raw, referrer, rule, detail string) {
+	c.traps.mu.Lock()
+	c.traps.rejected[rule]++
+	sample := c.traps.rejected[rule] <= trapSamples
+	c.traps.mu.Unlock()
+	c.log().Debug("trap suspect", "url", raw, "rule", rule, "detail", detail, "referrer", referrer)
+	if sample && c.trapsFile != nil {
+		_, _ = c.trapsFile.add(raw + "\t" + rule + "\t" + detail + "\t" + referrer)
+	}
+}
+
+// trapCounts is how many URLs each trap rule turned down this run, nil if
+// none
+func (c *Crawler) trapCounts() map[string]int {
+	c.traps.mu.Lock()
+	defer c.traps.mu.Unlock()
+	if len(c.traps.rejected) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(c.traps.rejected))
+	for rule, n := range c.traps.rejected {
+		counts[rule] = n
+	}
+	return counts
+}
+
+// logTraps logs the trap counts at the end of a run
+func (c *Crawler) logTraps() {
+	counts := c.trapCounts()
+	if counts == nil {
+		return
+	}
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	attrs := []any{"file", c.trapsFileName}
+	for _, rule := range rules {
+		attrs = append(attrs, rule, counts[rule])
+	}
+	c.log().Info("trap suspects", attrs...)
+}
@@ -0,0 +1,35 @@
+package crawler
+
+import (
+	"context"
+	"strings"
+)
+
+// defaultUserAgent names the crawler honestly; ContactURL is added to it
+// so site owners can reach whoever runs the crawl
+const defaultUserAgent = "simple-web-scraper/1.0"
+
+// userAgent picks the User-Agent for a request: the only one, the worker's
+// own with per-worker rotation, or the next in turn
+func (c *Crawler) userAgent(ctx context.Context) string {
+	agents := c.cfg.UserAgents
+	if len(agents) == 1 {
+		return agents[0]
+	}
+	if id, ok := ctx.Value(workerKey{}).(int); ok && c.cfg.UserAgentRotation == "per-worker" {
+		return agents[(id-1)%len(agents)]
+	}
+	return agents[(c.agentTurn.Add(1)-1)%uint64(len(agents))]
+}
+
+// robotsToken is the name robots.txt groups and robots directives are
+// matched against: RobotsToken, or the product name of the first
+// User-Agent, in lower case
+func (c *Crawler) robotsToken() string {
+	if c.cfg.RobotsToken != "" {
+		return strings.ToLower(c.cfg.RobotsToken)
+	}
+	token, _, _ := strings.Cut(c.cfg.UserAgents[0], "/")
+	token, _, _ = strings.Cut(token, " ")
+	return strings.ToLower(token)
+}
@@ -0,0 +1,287 @@
+package crawler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// VerifyProblem is a saved file that doesn't match the manifest. URLs are
+// the manifest entries saved in it, none for an orphan.
+type VerifyProblem struct {
+	File   string   `json:"file"`
+	URLs   []string `json:"urls,omitempty"`
+	Reason string   `json:"reason"`
+}
+
+// VerifyReport is what Verify found. Orphans are files in the download
+// folder that no manifest entry accounts for; they are only looked for
+// with the fs storage backend.
+type VerifyReport struct {
+	Checked   int             `json:"checked"`
+	Bytes     int64           `json:"bytes"`
+	Missing   []VerifyProblem `json:"missing,omitempty"`
+	Corrupted []VerifyProblem `json:"corrupted,omitempty"`
+	Orphaned  []VerifyProblem `json:"orphaned,omitempty"`
+	// Requeued is how many URLs Verify queued again, with repair
+	Requeued int `json:"requeued,omitempty"`
+}
+
+// OK reports whether every file matched
+func (r *VerifyReport) OK() bool {
+	return len(r.Missing)+len(r.Corrupted)+len(r.Orphaned) == 0
+}
+
+// WriteText prints the report for people
+func (r *VerifyReport) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Checked %d files, %s: %d missing, %d corrupted, %d orphaned\n",
+		r.Checked, formatSize(r.Bytes), len(r.Missing), len(r.Corrupted), len(r.Orphaned))
+	for _, group := range []struct {
+		label    string
+		problems []VerifyProblem
+	}{{"missing", r.Missing}, {"corrupted", r.Corrupted}, {"orphaned", r.Orphaned}} {
+		for _, p := range group.problems {
+			if err == nil {
+				_, err = fmt.Fprintf(w, "  %-9s %s (%s)\n", group.label, p.File, p.Reason)
+			}
+		}
+	}
+	if r.Requeued > 0 && err == nil {
+		_, err = fmt.Fprintf(w, "Queued %d URLs to be fetched again on the next crawl\n", r.Requeued)
+	}
+	return err
+}
+
+// verifyFile is one saved file to check, with what the manifest says
+type verifyFile struct {
+	name   string
+	size   int64
+	sha256 string
+	urls   []string
+}
+
+// Verify re-hashes every file the manifest lists, Workers at a time,
+// logging progress every ProgressInterval, and reports those missing,
+// cut short or changed since they were saved, and the files nothing in
+// the manifest accounts for. With repair, the URLs saved in missing or
+// corrupted files are queued to be fetched again by the next crawl.
+func (c *Crawler) Verify(ctx context.Context, repair bool) (*VerifyReport, error) {
+	if repair {
+		if err := c.lock(); err != nil {
+			return nil, err
+		}
+		defer c.unlock()
+	}
+	if err := c.openManifest(); err != nil {
+		return nil, err
+	}
+	defer c.pageManifest.close()
+
+	files := c.verifyFiles()
+	report := &VerifyReport{}
+	var mu sync.Mutex
+	var checked, bytes atomic.Int64
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	go func() {
+		ticker := time.NewTicker(c.cfg.ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-progressCtx.Done():
+				return
+			case <-ticker.C:
+				c.log().Info("verifying", "checked", checked.Load(), "files", len(files), "bytes", formatSize(bytes.Load()))
+			}
+		}
+	}()
+
+	jobs := make(chan verifyFile)
+	var wg sync.WaitGroup
+	for w := 0; w < c.cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				missing, reason, n := c.verifyOne(f)
+				checked.Add(1)
+				bytes.Add(n)
+				if reason == "" {
+					continue
+				}
+				p := VerifyProblem{File: f.name, URLs: f.urls, Reason: reason}
+				mu.Lock()
+				if missing {
+					report.Missing = append(report.Missing, p)
+				} else {
+					report.Corrupted = append(report.Corrupted, p)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for _, f := range files {
+		select {
+		case jobs <- f:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	stopProgress()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	report.Checked, report.Bytes = int(checked.Load()), bytes.Load()
+
+	orphans, err := c.orphanedFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	report.Orphaned = orphans
+	for _, list := range [][]VerifyProblem{report.Missing, report.Corrupted} {
+		sort.Slice(list, func(i, j int) bool { return list[i].File < list[j].File })
+	}
+
+	if repair {
+		bad := make(map[string]bool)
+		for _, p := range append(report.Missing, report.Corrupted...) {
+			for _, u := range p.URLs {
+				bad[u] = true
+			}
+		}
+		if len(bad) > 0 {
+			if err := c.Frontier.Open(); err != nil {
+				return nil, err
+			}
+			err := c.Frontier.ForgetScraped(bad)
+			if closeErr := c.Frontier.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, err
+			}
+			report.Requeued = len(bad)
+		}
+	}
+	return report, nil
+}
+
+// verifyFiles groups the manifest entries by file, taking the size and
+// hash from the entry that owns the file rather than one sharing it
+func (c *Crawler) verifyFiles() []verifyFile {
+	byName := make(map[string]*verifyFile)
+	c.pageManifest.mu.Lock()
+	for _, e := range c.pageManifest.entries {
+		if e.File == "" {
+			continue
+		}
+		f, ok := byName[e.File]
+		if !ok {
+			f = &verifyFile{name: e.File}
+			byName[e.File] = f
+		}
+		f.urls = append(f.urls, e.URL)
+		if e.DuplicateOf == "" || f.sha256 == "" {
+			f.size, f.sha256 = e.Size, e.SHA256
+		}
+		// The raw and original copies have no hash of their own
+		for _, extra := range []string{e.RawFile, e.OriginalFile} {
+			if extra == "" {
+				continue
+			}
+			if byName[extra] == nil {
+				byName[extra] = &verifyFile{name: extra}
+			}
+			byName[extra].urls = append(byName[extra].urls, e.URL)
+		}
+	}
+	c.pageManifest.mu.Unlock()
+	files := make([]verifyFile, 0, len(byName))
+	for _, f := range byName {
+		sort.Strings(f.urls)
+		files = append(files, *f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files
+}
+
+// verifyOne checks one file, returning why it doesn't match, if it
+// doesn't, and how many bytes were read
+func (c *Crawler) verifyOne(f verifyFile) (missing bool, reason string, n int64) {
+	r, err := c.Storage.LoadPage(f.name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, "not found", 0
+	}
+	if err != nil {
+		return false, err.Error(), 0
+	}
+	defer r.Close()
+	h := sha256.New()
+	n, err = io.Copy(h, r)
+	switch {
+	case err != nil:
+		return false, err.Error(), n
+	case n == 0 && f.size != 0:
+		return true, "empty", 0
+	case f.size > 0 && n != f.size:
+		return false, fmt.Sprintf("%d bytes, expected %d", n, f.size), n
+	case f.sha256 != "" && hex.EncodeToString(h.Sum(nil)) != f.sha256:
+		return false, "SHA-256 mismatch", n
+	}
+	return false, "", n
+}
+
+// orphanedFiles lists the files in the download folder that aren't in
+// files, leaving out the copies derived from saved pages: the text,
+// offline and Markdown versions, and the screenshots
+func (c *Crawler) orphanedFiles(files []verifyFile) ([]VerifyProblem, error) {
+	fsys, ok := c.Storage.(fsStorage)
+	if !ok {
+		return nil, nil
+	}
+	known := make(map[string]bool, len(files)*3)
+	for _, f := range files {
+		known[f.name] = true
+		known[textFileName(f.name)] = true
+		known[offlineName(f.name)] = true
+		known[screenshotName(f.name)] = true
+	}
+	var orphans []VerifyProblem
+	err := filepath.WalkDir(fsys.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == fsys.dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(fsys.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == markdownSubfolder {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !known[rel] && !known[trimCodecSuffix(rel)] {
+			orphans = append(orphans, VerifyProblem{File: rel, Reason: "not in the manifest"})
+		}
+		return nil
+	})
+	return orphans, err
+}
@@ -0,0 +1,308 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warcSubfolder holds the WARC files, in the project folder
+const warcSubfolder = "warc"
+
+// warcIndexRecord is one line of warc_index.jsonl: where the response
+// record for a URL sits, so it can be read without scanning the archive
+type warcIndexRecord struct {
+	URL         string    `json:"url"`
+	Date        time.Time `json:"date"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type,omitempty"`
+	Digest      string    `json:"digest"`
+	File        string    `json:"file"`
+	Offset      int64     `json:"offset"`
+	Length      int64     `json:"length"`
+}
+
+// warcWriter writes request and response records into .warc.gz files
+// following WARC 1.1, one gzip member per record as replay tools expect.
+// A file is closed and the next one started once it passes maxSize; each
+// starts with a warcinfo record.
+type warcWriter struct {
+	mu      sync.Mutex
+	dir     string
+	prefix  string
+	maxSize int64
+	info    []string
+	index   *jsonlWriter
+
+	seq    int
+	file   *os.File
+	name   string
+	size   int64
+	infoID string
+}
+
+// openWARC prepares the writer for this run; the first file is created
+// with the first record
+func (c *Crawler) openWARC() (*warcWriter, error) {
+	dir := filepath.Join(c.cfg.ProjectFolder, warcSubfolder)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	index, err := openJSONL(c.warcIndexFileName)
+	if err != nil {
+		return nil, err
+	}
+	robots := "ignore"
+	if c.cfg.RespectRobots {
+		robots = "obey"
+	}
+	info := []string{
+		"software: simple-web-scraper",
+		"format: WARC File Format 1.1",
+		"conformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/",
+		"isPartOf: " + filepath.Base(c.cfg.ProjectFolder),
+		"description: crawl of " + c.cfg.BaseURL + ", run " + c.run.ID,
+		"robots: " + robots,
+		"http-header-user-agent: " + strings.Join(c.cfg.UserAgents, " | "),
+		"max-depth: " + strconv.Itoa(c.cfg.MaxDepth),
+		"max-pages: " + strconv.Itoa(c.cfg.MaxPages),
+		"workers: " + strconv.Itoa(c.cfg.Workers),
+	}
+	return &warcWriter{dir: dir, prefix: c.run.ID, maxSize: c.cfg.WARCMaxSize, info: info, index: index}, nil
+}
+
+func (w *warcWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.index.close()
+	if w.file != nil {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+		w.file = nil
+	}
+	return err
+}
+
+// rotate starts the next file if there is none yet or the current one is
+// full
+func (w *warcWriter) rotate() error {
+	if w.file != nil && (w.maxSize <= 0 || w.size < w.maxSize) {
+		return nil
+	}
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+	w.seq++
+	w.name = fmt.Sprintf("%s-%05d.warc.gz", w.prefix, w.seq)
+	f, err := os.OpenFile(filepath.Join(w.dir, w.name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.file, w.size = f, 0
+	w.infoID = warcRecordID()
+	block := []byte(strings.Join(w.info, "\r\n") + "\r\n")
+	_, _, err = w.writeRecord([]string{
+		"WARC-Type: warcinfo",
+		"WARC-Record-ID: " + w.infoID,
+		"WARC-Date: " + warcDate(time.Now()),
+		"WARC-Filename: " + w.name,
+		"Content-Type: application/warc-fields",
+	}, bytes.NewReader(block), int64(len(block)), blockDigest(block))
+	return err
+}
+
+// writeExchange archives one fetch: the request as sent and the response
+// with its headers and body as received, still content-encoded. body must
+// be the whole body; it is read twice, for the digests and the record.
+func (w *warcWriter) writeExchange(resp *http.Response, body io.ReadSeeker, bodySize int64, fetchedAt time.Time) error {
+	var reqHead bytes.Buffer
+	req := resp.Request
+	fmt.Fprintf(&reqHead, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), req.URL.Host)
+	// Credentials stay out of the archive
+	reqHeader := req.Header.Clone()
+	for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+		if reqHeader.Get(name) != "" {
+			reqHeader.Set(name, "redacted")
+		}
+	}
+	reqHeader.Write(&reqHead)
+	reqHead.WriteString("\r\n")
+
+	var respHead bytes.Buffer
+	fmt.Fprintf(&respHead, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&respHead)
+	respHead.WriteString("\r\n")
+
+	payload := sha1.New()
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(payload, body); err != nil {
+		return err
+	}
+	block := sha1.New()
+	block.Write(respHead.Bytes())
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(block, body); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	target := req.URL.String()
+	date := warcDate(fetchedAt)
+	respID, reqID := warcRecordID(), warcRecordID()
+	payloadDigest := "sha1:" + base32.StdEncoding.EncodeToString(payload.Sum(nil))
+	offset, length, err := w.writeRecord([]string{
+		"WARC-Type: response",
+		"WARC-Record-ID: " + respID,
+		"WARC-Date: " + date,
+		"WARC-Target-URI: " + target,
+		"WARC-Warcinfo-ID: " + w.infoID,
+		"WARC-Block-Digest: sha1:" + base32.StdEncoding.EncodeToString(block.Sum(nil)),
+		"WARC-Payload-Digest: " + payloadDigest,
+		"Content-Type: application/http;msgtype=response",
+	}, io.MultiReader(&respHead, body), int64(respHead.Len())+bodySize, "")
+	if err != nil {
+		return err
+	}
+	reqBlock := reqHead.Bytes()
+	if _, _, err := w.writeRecord([]string{
+		"WARC-Type: request",
+		"WARC-Record-ID: " + reqID,
+		"WARC-Date: " + date,
+		"WARC-Target-URI: " + target,
+		"WARC-Warcinfo-ID: " + w.infoID,
+		"WARC-Concurrent-To: " + respID,
+		"Content-Type: application/http;msgtype=request",
+	}, bytes.NewReader(reqBlock), int64(len(reqBlock)), blockDigest(reqBlock)); err != nil {
+		return err
+	}
+	return w.index.write(warcIndexRecord{
+		URL:         target,
+		Date:        fetchedAt.UTC(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Digest:      payloadDigest,
+		File:        w.name,
+		Offset:      offset,
+		Length:      length,
+	})
+}
+
+// writeRecord appends one record as its own gzip member, returning where
+// the member starts in the file and its compressed length. digest, if
+// set, is added as the WARC-Block-Digest.
+func (w *warcWriter) writeRecord(fields []string, block io.Reader, size int64, digest string) (int64, int64, error) {
+	offset := w.size
+	counted := &countingWriter{w: w.file}
+	zw := gzip.NewWriter(counted)
+	var head strings.Builder
+	head.WriteString("WARC/1.1\r\n")
+	for _, f := range fields {
+		head.WriteString(f + "\r\n")
+	}
+	if digest != "" {
+		head.WriteString("WARC-Block-Digest: " + digest + "\r\n")
+	}
+	head.WriteString("Content-Length: " + strconv.FormatInt(size, 10) + "\r\n\r\n")
+	if _, err := io.WriteString(zw, head.String()); err != nil {
+		return 0, 0, err
+	}
+	if _, err := io.Copy(zw, block); err != nil {
+		return 0, 0, err
+	}
+	if _, err := io.WriteString(zw, "\r\n\r\n"); err != nil {
+		return 0, 0, err
+	}
+	err := zw.Close()
+	w.size += counted.n
+	return offset, counted.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func blockDigest(block []byte) string {
+	sum := sha1.Sum(block)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func warcDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// warcRecordID is a random UUID URN, as WARC-Record-ID wants
+func warcRecordID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// archiveCapture tees a response body as received into a temporary file
+// for the WARC files; it is nil when WARC output is off
+func (c *Crawler) archiveCapture(body io.Reader) (io.Reader, *sidecar, error) {
+	if c.warc == nil {
+		return body, nil, nil
+	}
+	s, err := newSidecar()
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.tee(body), s, nil
+}
+
+// archive writes the captured exchange to the WARC files, reading whatever
+// of the body wasn't consumed yet through wire. A failure is logged, not
+// returned: the page itself was saved.
+func (c *Crawler) archive(resp *http.Response, captured *sidecar, wire io.Reader, fetchedAt time.Time) {
+	if captured == nil {
+		return
+	}
+	err := func() error {
+		if _, err := io.Copy(io.Discard, wire); err != nil {
+			return err
+		}
+		size, err := captured.f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		return c.warc.writeExchange(resp, captured.f, size, fetchedAt)
+	}()
+	if err != nil {
+		c.log().Error("writing WARC record", "url", resp.Request.URL.String(), "error", err)
+	}
+}
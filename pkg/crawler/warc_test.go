@@ -0,0 +1,314 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// warcRecord is a record read back from a WARC file
+type warcRecord struct {
+	header http.Header
+	block  []byte
+}
+
+// readWARCRecord reads one record, checking its framing and block digest
+func readWARCRecord(t *testing.T, r *bufio.Reader) (warcRecord, bool) {
+	t.Helper()
+	version, err := r.ReadString('\n')
+	if err == io.EOF && version == "" {
+		return warcRecord{}, false
+	}
+	if version != "WARC/1.1\r\n" {
+		t.Fatalf("record starts with %q, want WARC/1.1", version)
+	}
+	rec := warcRecord{header: make(http.Header)}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading record header: %v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+		name, value, ok := strings.Cut(strings.TrimSuffix(line, "\r\n"), ": ")
+		if !ok {
+			t.Fatalf("bad header line %q", line)
+		}
+		rec.header.Add(name, value)
+	}
+	size, err := strconv.Atoi(rec.header.Get("Content-Length"))
+	if err != nil {
+		t.Fatalf("Content-Length %q: %v", rec.header.Get("Content-Length"), err)
+	}
+	rec.block = make([]byte, size)
+	if _, err := io.ReadFull(r, rec.block); err != nil {
+		t.Fatalf("reading %d byte block: %v", size, err)
+	}
+	end := make([]byte, 4)
+	if _, err := io.ReadFull(r, end); err != nil || string(end) != "\r\n\r\n" {
+		t.Fatalf("record ends with %q, %v", end, err)
+	}
+	if got, want := blockDigest(rec.block), rec.header.Get("WARC-Block-Digest"); got != want {
+		t.Errorf("%s record block digest %s, header says %s", rec.header.Get("WARC-Type"), got, want)
+	}
+	return rec, true
+}
+
+// readWARC reads every record of a .warc.gz file
+func readWARC(t *testing.T, path string) []warcRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(zr)
+	var records []warcRecord
+	for {
+		rec, ok := readWARCRecord(t, r)
+		if !ok {
+			return records
+		}
+		records = append(records, rec)
+	}
+}
+
+// warcIndex reads warc_index.jsonl
+func warcIndex(t *testing.T, path string) []warcIndexRecord {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index []warcIndexRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var r warcIndexRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("index line %q: %v", line, err)
+		}
+		index = append(index, r)
+	}
+	return index
+}
+
+// responsePayload splits the HTTP response a response record's block holds
+func responsePayload(t *testing.T, block []byte) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+	if err != nil {
+		t.Fatalf("reading the archived response: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestWARCWriter(t *testing.T) {
+	bodies := []string{"<html>first</html>", "<html>second page</html>", strings.Repeat("x", 5000)}
+	tests := []struct {
+		name    string
+		maxSize int64
+		files   int
+	}{
+		{"no rotation", 0, 1},
+		{"rotating after every exchange", 1, 3},
+		{"large limit", 1 << 30, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCrawler(t, "https://example.com/", func(cfg *Config) {
+				cfg.WARC = true
+				cfg.WARCMaxSize = tt.maxSize
+			})
+			c.run.ID = "run"
+			w, err := c.openWARC()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fetchedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+			for i, body := range bodies {
+				u, _ := url.Parse(fmt.Sprintf("https://example.com/page/%d?q=1", i))
+				req := &http.Request{Method: "GET", URL: u, Header: http.Header{"User-Agent": {"test"}, "Cookie": {"session=secret"}}}
+				resp := &http.Response{Proto: "HTTP/1.1", Status: "200 OK", StatusCode: 200, Request: req,
+					Header: http.Header{"Content-Type": {"text/html"}}}
+				if err := w.writeExchange(resp, strings.NewReader(body), int64(len(body)), fetchedAt); err != nil {
+					t.Fatalf("writeExchange: %v", err)
+				}
+			}
+			if err := w.close(); err != nil {
+				t.Fatal(err)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(c.cfg.ProjectFolder, warcSubfolder, "*.warc.gz"))
+			sort.Strings(files)
+			if len(files) != tt.files {
+				t.Fatalf("got %d files %v, want %d", len(files), files, tt.files)
+			}
+			var responses []warcRecord
+			for i, file := range files {
+				if want := fmt.Sprintf("run-%05d.warc.gz", i+1); filepath.Base(file) != want {
+					t.Errorf("file %d is %s, want %s", i, filepath.Base(file), want)
+				}
+				records := readWARC(t, file)
+				info := records[0]
+				if info.header.Get("WARC-Type") != "warcinfo" || info.header.Get("WARC-Filename") != filepath.Base(file) {
+					t.Fatalf("%s starts with %v, want its warcinfo", file, info.header)
+				}
+				if !bytes.Contains(info.block, []byte("software: simple-web-scraper")) {
+					t.Errorf("warcinfo = %q", info.block)
+				}
+				ids := make(map[string]bool)
+				for _, rec := range records[1:] {
+					if rec.header.Get("WARC-Warcinfo-ID") != info.header.Get("WARC-Record-ID") {
+						t.Errorf("record doesn't point at its file's warcinfo")
+					}
+					switch rec.header.Get("WARC-Type") {
+					case "response":
+						ids[rec.header.Get("WARC-Record-ID")] = true
+						responses = append(responses, rec)
+					case "request":
+						if !ids[rec.header.Get("WARC-Concurrent-To")] {
+							t.Errorf("request isn't concurrent to the response before it")
+						}
+						if bytes.Contains(rec.block, []byte("secret")) {
+							t.Errorf("request record holds the cookie: %q", rec.block)
+						}
+					default:
+						t.Errorf("unexpected %s record", rec.header.Get("WARC-Type"))
+					}
+				}
+			}
+			if len(responses) != len(bodies) {
+				t.Fatalf("got %d responses, want %d", len(responses), len(bodies))
+			}
+			for i, rec := range responses {
+				if got, want := rec.header.Get("WARC-Target-URI"), fmt.Sprintf("https://example.com/page/%d?q=1", i); got != want {
+					t.Errorf("target %s, want %s", got, want)
+				}
+				if got := rec.header.Get("WARC-Date"); got != "2026-03-01T12:00:00Z" {
+					t.Errorf("date %s", got)
+				}
+				_, payload := responsePayload(t, rec.block)
+				if string(payload) != bodies[i] {
+					t.Errorf("payload %q, want %q", payload, bodies[i])
+				}
+				sum := sha1.Sum(payload)
+				if got, want := rec.header.Get("WARC-Payload-Digest"), "sha1:"+base32.StdEncoding.EncodeToString(sum[:]); got != want {
+					t.Errorf("payload digest %s, want %s", got, want)
+				}
+			}
+
+			// Every index entry leads straight to its response record
+			index := warcIndex(t, c.warcIndexFileName)
+			if len(index) != len(bodies) {
+				t.Fatalf("index has %d entries, want %d", len(index), len(bodies))
+			}
+			for i, e := range index {
+				f, err := os.Open(filepath.Join(c.cfg.ProjectFolder, warcSubfolder, e.File))
+				if err != nil {
+					t.Fatal(err)
+				}
+				zr, err := gzip.NewReader(io.NewSectionReader(f, e.Offset, e.Length))
+				if err != nil {
+					t.Fatalf("entry %d: %v", i, err)
+				}
+				rec, _ := readWARCRecord(t, bufio.NewReader(zr))
+				f.Close()
+				if rec.header.Get("WARC-Type") != "response" || rec.header.Get("WARC-Target-URI") != e.URL || e.Status != 200 {
+					t.Errorf("entry %d %+v leads to %v", i, e, rec.header)
+				}
+				if rec.header.Get("WARC-Payload-Digest") != e.Digest {
+					t.Errorf("entry %d digest %s, record has %s", i, e.Digest, rec.header.Get("WARC-Payload-Digest"))
+				}
+			}
+		})
+	}
+}
+
+// TestWARCInCrawl archives the pages a crawl saved as they came over the
+// wire, content encoding and all; the missing page isn't archived
+func TestWARCInCrawl(t *testing.T) {
+	pages := map[string]string{
+		"/":     `<html><body><a href="/gzip">gzip</a><a href="/missing">missing</a></body></html>`,
+		"/gzip": `<html><body>compressed</body></html>`,
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	io.WriteString(zw, pages["/gzip"])
+	zw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, pages["/"])
+		case "/gzip":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := newTestCrawler(t, srv.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.MaxAttempts = 1
+		cfg.WARC = true
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]byte{srv.URL + "/": []byte(pages["/"]), srv.URL + "/gzip": compressed.Bytes()}
+	index := warcIndex(t, c.warcIndexFileName)
+	if len(index) != len(want) {
+		t.Fatalf("index = %+v, want the %d saved pages", index, len(want))
+	}
+	found := 0
+	for _, e := range index {
+		records := readWARC(t, filepath.Join(c.cfg.ProjectFolder, warcSubfolder, e.File))
+		for _, rec := range records {
+			if rec.header.Get("WARC-Type") != "response" || rec.header.Get("WARC-Target-URI") != e.URL {
+				continue
+			}
+			found++
+			resp, payload := responsePayload(t, rec.block)
+			if !bytes.Equal(payload, want[e.URL]) {
+				t.Errorf("%s payload %q, want %q", e.URL, payload, want[e.URL])
+			}
+			if e.URL == srv.URL+"/gzip" && resp.Header.Get("Content-Encoding") != "gzip" {
+				t.Errorf("%s lost its Content-Encoding: %v", e.URL, resp.Header)
+			}
+		}
+	}
+	if found != len(want) {
+		t.Errorf("found %d response records, want %d", found, len(want))
+	}
+}
@@ -0,0 +1,313 @@
+package crawler
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The events webhooks are posted for
+const (
+	EventStarted   = "started"
+	EventFinished  = "finished"
+	EventFailed    = "failed"
+	EventMilestone = "milestone"
+	EventErrorRate = "error_rate"
+)
+
+var webhookEvents = []string{EventStarted, EventFinished, EventFailed, EventMilestone, EventErrorRate}
+
+const (
+	// webhookQueueSize is how many events can wait for delivery; past it
+	// events are dropped rather than hold up the crawl
+	webhookQueueSize = 100
+	webhookAttempts  = 4
+	// webhookBackoff is the wait before the first retry, doubled for each
+	// one after
+	webhookBackoff = time.Second
+	webhookTimeout = 10 * time.Second
+	// webhookDrainTime is how long the end of Run waits for the events
+	// still queued
+	webhookDrainTime = 15 * time.Second
+	// errorRateWindow is how often the error rate is checked, over the
+	// fetches since the last check
+	errorRateWindow = 10 * time.Second
+)
+
+// Webhook is a URL that crawl events are posted to as JSON
+type Webhook struct {
+	URL string
+	// Events are the ones to post, all of them when empty
+	Events []string
+	// Secret, when set, signs each payload: the X-Signature-256 header is
+	// "sha256=" and the hex HMAC-SHA256 of the body
+	Secret string
+}
+
+func (h Webhook) wants(event string) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+// checkWebhooks reports the webhooks with a bad URL or unknown events
+func checkWebhooks(hooks []Webhook) error {
+	for _, h := range hooks {
+		if u, err := url.Parse(h.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("webhook URL %q is not an http or https URL", h.URL)
+		}
+		for _, e := range h.Events {
+			if !slices.Contains(webhookEvents, e) {
+				return fmt.Errorf("unknown webhook event %q, want one of %v", e, webhookEvents)
+			}
+		}
+	}
+	return nil
+}
+
+// WebhookPayload is what a webhook is posted. Text sums it up for chat
+// services, such as Slack's incoming webhooks, that show a "text" field.
+type WebhookPayload struct {
+	Event   string       `json:"event"`
+	Text    string       `json:"text"`
+	RunID   string       `json:"run_id"`
+	BaseURL string       `json:"base_url"`
+	At      time.Time    `json:"at"`
+	Outcome string       `json:"outcome,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Detail  string       `json:"detail,omitempty"`
+	Stats   WebhookStats `json:"stats"`
+}
+
+// WebhookStats is where the crawl stood at the event
+type WebhookStats struct {
+	FrontierStats
+	// Fetches and Failures are this run's
+	Fetches  int64          `json:"fetches"`
+	Failures map[string]int `json:"failures,omitempty"`
+	ElapsedS int64          `json:"elapsed_seconds"`
+}
+
+// webhookDelivery is a payload on its way to one webhook
+type webhookDelivery struct {
+	hook  Webhook
+	event string
+	body  []byte
+}
+
+// notifier posts the events to the webhooks from its own goroutine, so
+// slow or failing receivers never hold up the crawl. A nil notifier drops
+// every event.
+type notifier struct {
+	hooks   []Webhook
+	client  *http.Client
+	backoff time.Duration
+	log     *slog.Logger
+	// mu guards queue, which is nil unless delivering
+	mu    sync.Mutex
+	queue chan webhookDelivery
+	done  chan struct{}
+	// ctx is cancelled to abandon the deliveries still retrying
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newNotifier(cfg Config) *notifier {
+	if len(cfg.Webhooks) == 0 {
+		return nil
+	}
+	return &notifier{hooks: cfg.Webhooks, client: &http.Client{Timeout: webhookTimeout}, backoff: webhookBackoff}
+}
+
+// start begins delivering, logging to log
+func (n *notifier) start(log *slog.Logger) {
+	if n == nil {
+		return
+	}
+	n.log = log
+	n.queue = make(chan webhookDelivery, webhookQueueSize)
+	n.done = make(chan struct{})
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	go func() {
+		defer close(n.done)
+		for d := range n.queue {
+			n.deliver(d)
+		}
+	}()
+}
+
+// stop waits up to webhookDrainTime for the queued events to be delivered,
+// then gives up on the rest
+func (n *notifier) stop() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if n.queue == nil {
+		n.mu.Unlock()
+		return
+	}
+	close(n.queue)
+	n.queue = nil
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+	case <-time.After(webhookDrainTime):
+		n.log.Warn("webhooks still undelivered, giving up on them")
+		n.cancel()
+		<-n.done
+	}
+	n.cancel()
+}
+
+// send queues p for every webhook that wants its event
+func (n *notifier) send(p WebhookPayload) {
+	if n == nil {
+		return
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.queue == nil {
+		return
+	}
+	for _, h := range n.hooks {
+		if !h.wants(p.Event) {
+			continue
+		}
+		select {
+		case n.queue <- webhookDelivery{hook: h, event: p.Event, body: body}:
+		default:
+			n.log.Warn("webhook queue full, dropping the event", "event", p.Event)
+		}
+	}
+}
+
+// deliver posts d, retrying with a doubling backoff while the receiver
+// can't be reached or answers a 429 or a 5xx
+func (n *notifier) deliver(d webhookDelivery) {
+	wait := n.backoff
+	for attempt := 1; ; attempt++ {
+		code, err := n.post(d)
+		if err == nil && code < 300 {
+			n.log.Debug("webhook delivered", "event", d.event, "status", code)
+			return
+		}
+		if err == nil {
+			err = &statusError{code: code}
+		}
+		retry := code == 0 || code == http.StatusTooManyRequests || code >= 500
+		if !retry || attempt == webhookAttempts || sleepCtx(n.ctx, wait) != nil {
+			n.log.Warn("webhook not delivered", "event", d.event, "attempts", attempt, "error", err)
+			return
+		}
+		wait *= 2
+	}
+}
+
+func (n *notifier) post(d webhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, d.hook.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("X-Webhook-Event", d.event)
+	if d.hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.hook.Secret))
+		mac.Write(d.body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// payload is the payload of event for this run, with detail saying what
+// set it off, and the run's counters
+func (c *Crawler) payload(event, detail string) WebhookPayload {
+	p := WebhookPayload{Event: event, RunID: c.run.ID, BaseURL: c.cfg.BaseURL, At: time.Now().UTC(), Detail: detail}
+	p.Stats = WebhookStats{
+		Fetches:  c.pagesStarted.Load(),
+		Failures: c.failures.counts(),
+		ElapsedS: int64(time.Since(c.run.StartedAt).Round(time.Second) / time.Second),
+	}
+	p.Text = fmt.Sprintf("Crawl of %s %s", c.cfg.BaseURL, event)
+	if detail != "" {
+		p.Text += ": " + detail
+	}
+	return p
+}
+
+// notify posts event while the crawl runs
+func (c *Crawler) notify(event, detail string) {
+	if c.hooks == nil {
+		return
+	}
+	p := c.payload(event, detail)
+	c.whileLive(func() { p.Stats.FrontierStats = c.Frontier.Stats() })
+	c.hooks.send(p)
+}
+
+// notifyEnd posts how the run ended, once endRun has recorded it: finished,
+// with its outcome, or failed, with the error
+func (c *Crawler) notifyEnd(err error) {
+	if c.hooks == nil {
+		return
+	}
+	outcome := cmp.Or(c.run.Outcome, outcomeFailed)
+	event, detail := EventFinished, outcome
+	if err != nil {
+		event, detail = EventFailed, err.Error()
+	}
+	p := c.payload(event, detail)
+	p.Outcome = outcome
+	if err != nil {
+		p.Error = err.Error()
+	}
+	if f := c.run.Frontier; f != nil {
+		p.Stats.FrontierStats = *f
+		p.Text += fmt.Sprintf(" (%d scraped, %d failed, %d pending)", f.Scraped, f.Failed, f.Pending)
+	}
+	c.hooks.send(p)
+}
+
+// watchErrorRate posts an error_rate event when more than WebhookErrorRate
+// of the fetches of a window failed, once until the rate is back under it
+func (c *Crawler) watchErrorRate(ctx context.Context) {
+	ticker := time.NewTicker(errorRateWindow)
+	defer ticker.Stop()
+	lastDone, lastFailed := c.pagesDone.Load(), c.pagesFailed.Load()
+	breached := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			done, failed := c.pagesDone.Load(), c.pagesFailed.Load()
+			if n := (done - lastDone) + (failed - lastFailed); n >= minAdaptSamples {
+				rate := float64(failed-lastFailed) / float64(n)
+				if rate > c.cfg.WebhookErrorRate && !breached {
+					c.notify(EventErrorRate, fmt.Sprintf("%.0f%% of %d fetches failed", rate*100, n))
+				}
+				breached = rate > c.cfg.WebhookErrorRate
+			}
+			lastDone, lastFailed = done, failed
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookCall is a request a test receiver got
+type webhookCall struct {
+	event     string
+	signature string
+	body      []byte
+	payload   WebhookPayload
+	at        time.Time
+}
+
+// webhookReceiver records what is posted to it, answering with what
+// answer returns for the nth call to it, from 1
+func webhookReceiver(t *testing.T, answer func(n int, call webhookCall) int) (*httptest.Server, func() []webhookCall) {
+	t.Helper()
+	var mu sync.Mutex
+	var calls []webhookCall
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		call := webhookCall{event: r.Header.Get("X-Webhook-Event"), signature: r.Header.Get("X-Signature-256"), body: body, at: time.Now()}
+		if err := json.Unmarshal(body, &call.payload); err != nil {
+			t.Errorf("decoding %q: %v", body, err)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("content type %q", ct)
+		}
+		mu.Lock()
+		calls = append(calls, call)
+		n := len(calls)
+		mu.Unlock()
+		code := http.StatusOK
+		if answer != nil {
+			code = answer(n, call)
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []webhookCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhookCall(nil), calls...)
+	}
+}
+
+// twoPageSite serves / linking to /a
+func twoPageSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><a href="/a">a</a></html>`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestWebhookPayloads crawls a small site with two webhooks: each is
+// posted the events it asked for, with the run's fields, and the one with
+// a secret gets them signed with it
+func TestWebhookPayloads(t *testing.T) {
+	const secret = "s3cret"
+	site := twoPageSite(t)
+	all, allCalls := webhookReceiver(t, nil)
+	finished, finishedCalls := webhookReceiver(t, nil)
+	c := newTestCrawler(t, site.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.Webhooks = []Webhook{
+			{URL: all.URL, Secret: secret},
+			{URL: finished.URL, Events: []string{EventFinished}},
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	runID := c.LastRun().ID
+
+	calls := allCalls()
+	if len(calls) != 2 || calls[0].event != EventStarted || calls[1].event != EventFinished {
+		t.Fatalf("signed webhook got %d calls, want started then finished", len(calls))
+	}
+	for _, call := range calls {
+		p := call.payload
+		if p.Event != call.event || p.RunID != runID || p.BaseURL != c.cfg.BaseURL || p.At.IsZero() || p.Text == "" {
+			t.Errorf("%s payload = %+v", call.event, p)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(call.body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(call.signature), []byte(want)) {
+			t.Errorf("%s signature %q, want %q", call.event, call.signature, want)
+		}
+	}
+	end := calls[1].payload
+	if end.Outcome != outcomeCompleted || end.Error != "" || end.Stats.Scraped != 2 || end.Stats.Pending != 0 || end.Stats.Fetches != 2 {
+		t.Errorf("finished payload = %+v", end)
+	}
+
+	calls = finishedCalls()
+	if len(calls) != 1 || calls[0].event != EventFinished {
+		t.Fatalf("finished-only webhook got %d calls", len(calls))
+	}
+	if calls[0].signature != "" {
+		t.Errorf("unsigned webhook got signature %q", calls[0].signature)
+	}
+	if calls[0].payload.RunID != runID {
+		t.Errorf("finished-only webhook run %q, want %q", calls[0].payload.RunID, runID)
+	}
+}
+
+// TestWebhookRetry has the receiver hang on the started event until the
+// crawl is done, then fail twice: the crawl isn't held up, and the event
+// is retried with a doubling wait until it is taken
+func TestWebhookRetry(t *testing.T) {
+	const backoff = 50 * time.Millisecond
+	site := twoPageSite(t)
+	crawled := make(chan struct{})
+	var blocked atomic.Bool
+	hook, hookCalls := webhookReceiver(t, func(n int, call webhookCall) int {
+		if call.event != EventStarted {
+			return http.StatusOK
+		}
+		switch n {
+		case 1:
+			select {
+			case <-crawled:
+			case <-time.After(5 * time.Second):
+				blocked.Store(true)
+			}
+			return http.StatusServiceUnavailable
+		case 2:
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	c := newTestCrawler(t, site.URL+"/", func(cfg *Config) {
+		cfg.Workers = 1
+		cfg.RespectRobots = false
+		cfg.Webhooks = []Webhook{{URL: hook.URL}}
+	})
+	c.hooks.backoff = backoff
+	var pages atomic.Int32
+	c.OnPage = func(PageResult) {
+		if pages.Add(1) == 2 {
+			close(crawled)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if blocked.Load() {
+		t.Error("the crawl waited on the webhook")
+	}
+
+	calls := hookCalls()
+	var events []string
+	for _, call := range calls {
+		events = append(events, call.event)
+	}
+	if len(calls) != 4 || events[0] != EventStarted || events[1] != EventStarted || events[2] != EventStarted || events[3] != EventFinished {
+		t.Fatalf("events %v, want started three times then finished", events)
+	}
+	if gap := calls[2].at.Sub(calls[1].at); gap < 2*backoff {
+		t.Errorf("second retry after %s, want the wait doubled to %s", gap, 2*backoff)
+	}
+	if calls[0].payload.RunID != calls[2].payload.RunID {
+		t.Error("the retried payload changed")
+	}
+}
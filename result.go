@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"simple-web-scraper/pkg/crawler"
+)
+
+// Exit statuses of a crawl; the other commands exit with 0 or exitConfig,
+// besides verify, which exits with exitFailures when files don't match
+const (
+	exitOK = 0
+	// exitConfig is bad settings or a crawl that could not start
+	exitConfig = 1
+	// exitFailures is a crawl that ran to the end with URLs failing for good
+	exitFailures = 2
+	// exitAborted is a crawl cut short by a signal, its deadline or the
+	// circuit breaker
+	exitAborted = 3
+)
+
+const exitStatusHelp = `
+Exit status of crawl and retry-failed:
+  0  the crawl ran to the end, or to a limit, with no URL failing for good
+  1  invalid settings, or the crawl could not start
+  2  the crawl ran to the end with URLs failing for good, in this run or,
+     resumed, in one before it that retry-failed hasn't retried
+  3  the crawl was aborted by an interrupt, its deadline or the circuit breaker
+verify exits with 2 when files are missing, corrupted or orphaned.
+`
+
+// crawlResult is result.json, written to the project folder when a crawl
+// ends so scripts wrapping it needn't read the logs
+type crawlResult struct {
+	ExitCode int               `json:"exit_code"`
+	Reason   string            `json:"reason"`
+	Run      crawler.RunResult `json:"run"`
+	// Report is the summary report's totals, when there is one
+	Report *resultReport `json:"report,omitempty"`
+}
+
+type resultReport struct {
+	Pages      int   `json:"pages"`
+	Bytes      int64 `json:"bytes"`
+	Failed     int   `json:"failed"`
+	Skipped    int   `json:"skipped"`
+	Duplicates int   `json:"duplicates"`
+}
+
+// crawlExit works out the exit status of a crawl that Run ended with err,
+// and why
+func crawlExit(run crawler.RunResult, err error) (code int, reason string) {
+	switch {
+	case run.Aborted():
+		return exitAborted, run.Outcome
+	case errors.Is(err, context.Canceled):
+		// Interrupted before the crawl started
+		return exitAborted, "interrupted"
+	case err != nil:
+		return exitConfig, "error"
+	case run.Failed > 0, run.Frontier != nil && run.Frontier.Failed > 0:
+		// A resumed crawl counts the URLs earlier runs left failed too
+		return exitFailures, "completed-with-failures"
+	}
+	return exitOK, run.Outcome
+}
+
+// writeResult writes result.json for c's last run, logging what goes wrong
+// rather than changing the exit status
+func writeResult(c *crawler.Crawler, code int, reason string) {
+	res := crawlResult{ExitCode: code, Reason: reason, Run: c.LastRun()}
+	if r := c.Report(); r != nil {
+		res.Report = &resultReport{Pages: r.Pages, Bytes: r.Bytes, Failed: r.Failed, Skipped: r.Skipped, Duplicates: r.Duplicates}
+	}
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(c.ProjectFolder(), os.ModePerm)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(c.ProjectFolder(), "result.json"), append(data, '\n'), 0644)
+	}
+	if err != nil {
+		logger.Error("writing result.json", "error", err)
+	}
+}
+
+// finishCrawl writes result.json and exits with the crawl's status, unless
+// the project folder belongs to another crawl, whose result.json is left
+// alone
+func finishCrawl(c *crawler.Crawler, err error) {
+	code, reason := crawlExit(c.LastRun(), err)
+	if !errors.Is(err, crawler.ErrLocked) {
+		writeResult(c, code, reason)
+	}
+	if code != exitOK {
+		os.Exit(code)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"simple-web-scraper/pkg/crawler"
+)
+
+func TestCrawlExit(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    crawler.RunResult
+		err    error
+		code   int
+		reason string
+	}{
+		{"completed", crawler.RunResult{Outcome: "completed", Frontier: &crawler.FrontierStats{Scraped: 3}}, nil, exitOK, "completed"},
+		{"failures in the run", crawler.RunResult{Outcome: "completed", Failed: 2}, nil, exitFailures, "completed-with-failures"},
+		{"failures left by an earlier run", crawler.RunResult{Outcome: "completed", Frontier: &crawler.FrontierStats{Scraped: 3, Failed: 1}}, nil, exitFailures, "completed-with-failures"},
+		{"interrupted with failures", crawler.RunResult{Outcome: "interrupted", Failed: 1}, context.Canceled, exitAborted, "interrupted"},
+		{"deadline", crawler.RunResult{Outcome: "deadline"}, nil, exitAborted, "deadline"},
+		{"origin down", crawler.RunResult{Outcome: "origin-down"}, nil, exitAborted, "origin-down"},
+		{"interrupted before starting", crawler.RunResult{Outcome: "failed"}, fmt.Errorf("preflight: %w", context.Canceled), exitAborted, "interrupted"},
+		{"could not start", crawler.RunResult{Outcome: "failed"}, errors.New("preflight failed"), exitConfig, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason := crawlExit(tt.run, tt.err)
+			if code != tt.code || reason != tt.reason {
+				t.Errorf("crawlExit = %d, %q, want %d, %q", code, reason, tt.code, tt.reason)
+			}
+		})
+	}
+}
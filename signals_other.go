@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "simple-web-scraper/pkg/crawler"
+
+// notifyPause does nothing without SIGUSR1 and SIGUSR2; a PAUSE file in
+// the project folder pauses the crawl instead
+func notifyPause(c *crawler.Crawler) {}
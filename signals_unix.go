@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"simple-web-scraper/pkg/crawler"
+)
+
+// notifyPause pauses the crawl on SIGUSR1 and resumes it on SIGUSR2
+func notifyPause(c *crawler.Crawler) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGUSR1 {
+				fmt.Fprintln(console, "Pausing after the requests in flight (SIGUSR2 to resume)")
+				c.Pause()
+			} else {
+				fmt.Fprintln(console, "Resuming")
+				c.Resume()
+			}
+		}
+	}()
+}